@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, see
+// sd_listen_fds(3).
+const listenFDsStart = 3
+
+// activationListeners returns the listeners passed by systemd socket
+// activation, or nil if the process was not socket-activated.
+func activationListeners() ([]net.Listener, error) {
+	defer func() {
+		// Prevent child processes from mistaking the sockets as theirs.
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return listenersFromEnv(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), listenFDsStart)
+}
+
+// listenersFromEnv implements activationListeners for the given values
+// of the LISTEN_PID and LISTEN_FDS environment variables.
+func listenersFromEnv(pid int, listenPID, listenFDs string, start int) ([]net.Listener, error) {
+	if listenPID == "" || listenFDs == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(listenPID); err != nil || p != pid {
+		// The file descriptors were meant for a different process.
+		return nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing LISTEN_FDS=%q: %v", listenFDs, err)
+	}
+	listeners := make([]net.Listener, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		ln, err := net.FileListener(f)
+		// net.FileListener dup()s the file descriptor.
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("file descriptor %d: %v", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenersFromEnv(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	for _, tt := range []struct {
+		listenPID, listenFDs string
+		want                 int
+	}{
+		{"", "", 0},
+		{"42", "1", 0}, // different process
+		{"1000", "1", 1},
+	} {
+		listeners, err := listenersFromEnv(1000, tt.listenPID, tt.listenFDs, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(listeners); got != tt.want {
+			t.Fatalf("LISTEN_PID=%q LISTEN_FDS=%q: got %d listeners, want %d", tt.listenPID, tt.listenFDs, got, tt.want)
+		}
+		for _, l := range listeners {
+			if got, want := l.Addr().String(), ln.Addr().String(); got != want {
+				t.Errorf("unexpected listener address: got %q, want %q", got, want)
+			}
+			l.Close()
+		}
+	}
+
+	if _, err := listenersFromEnv(1000, "1000", "one", fd); err == nil {
+		t.Errorf("listenersFromEnv unexpectedly succeeded with LISTEN_FDS=one")
+	}
+}
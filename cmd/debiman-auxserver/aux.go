@@ -2,14 +2,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Debian/debiman/internal/aux"
 	"github.com/Debian/debiman/internal/bundled"
@@ -20,77 +24,330 @@ import (
 var (
 	indexPath = flag.String("index",
 		"/srv/man/auxserver.idx",
-		"Path to an auxserver index generated by debiman")
+		"Path to an auxserver index generated by debiman. Send SIGHUP (e.g. systemctl reload debiman-auxserver) to re-read it after a debiman run.")
 
 	listenAddr = flag.String("listen",
 		"localhost:2431",
-		"host:port address to listen on")
+		"host:port address to listen on, or unix:<path> (e.g. unix:/run/debiman/aux.sock) to listen on a Unix domain socket. Ignored when started via systemd socket activation (see debiman-auxserver.socket).")
+
+	whatisGlob = flag.String("whatis",
+		"",
+		"If non-empty, a glob matching the whatis databases generated by debiman -whatis (e.g. /srv/man/whatis-*.json), which are searched by /apropos?q=<keyword>")
+
+	searchGlob = flag.String("search",
+		"",
+		"If non-empty, a glob matching the search indexes generated by debiman -search_index (e.g. /srv/man/search-*.gob), which are queried by /search?q=<query>")
+
+	rulesPath = flag.String("rules",
+		"",
+		"If non-empty, path to a file with rules for rewriting legacy URLs of other manpage sites (e.g. /cgi-bin/man.cgi?query=ls&sektion=1), replacing the built-in rules. Each line contains a regular expression matching the URL path and a target path, in which ${1} refers to the first submatch and {query} to the value of the query parameter “query”.")
+
+	maxIndexAge = flag.Duration("max_index_age",
+		0,
+		"If non-zero, /readyz reports debiman-auxserver as not ready once the index file is older than this (e.g. 48h), e.g. because debiman stopped updating it")
+
+	watchIndex = flag.Bool("watch_index",
+		false,
+		"Reload the index (like SIGHUP does) whenever debiman writes a new index file")
+
+	rateLimit = flag.Float64("rate_limit",
+		0,
+		"If non-zero, the number of requests per second each client IP address may send to the redirect and search endpoints. Exceeding requests are answered with HTTP 429.")
+
+	rateBurst = flag.Int("rate_burst",
+		20,
+		"Number of requests a client IP address may send in a burst before -rate_limit applies")
+
+	maxConcurrent = flag.Int("max_concurrent",
+		0,
+		"If non-zero, the maximum number of redirect and search requests handled concurrently. Exceeding requests are answered with HTTP 503.")
+
+	trustForwardedFor = flag.Bool("trust_forwarded_for",
+		false,
+		"Identify clients for -rate_limit by the last address of the X-Forwarded-For header. Enable only when running behind a reverse proxy which sets that header.")
+
+	corsOrigins = flag.String("cors_origins",
+		"",
+		"If non-empty, a comma-separated list of origins (e.g. https://example.net, or * for all origins) which may query the /api/resolve, /suggest, /apropos and /search endpoints from the browser (CORS)")
+
+	accessLog = flag.String("access_log",
+		"",
+		"If non-empty, path to a file to which to append an access log line per request (- for standard output), see -access_log_format")
+
+	accessLogFormat = flag.String("access_log_format",
+		"combined",
+		"Format of -access_log: common (Common Log Format) or combined (Combined Log Format, which additionally contains the referer and user agent)")
+
+	logLevel = flag.String("log_level",
+		"info",
+		"Minimum level of log messages to print: debug, info, warning or error. debug logs how each redirect request was resolved.")
+
+	logJSON = flag.Bool("log_json",
+		false,
+		"Log one JSON object per line instead of human-readable lines, for ingestion into journald or ELK")
 
 	injectAssets = flag.String("inject_assets",
 		"",
 		"If non-empty, a file system path to a directory containing assets to overwrite")
 )
 
+// reload re-reads the index (and the other files specified by flags)
+// and swaps them into server.
+func reload(server *aux.Server, logger *aux.Logger) {
+	newidx, err := redirect.IndexFromProto(*indexPath)
+	if err != nil {
+		logger.Error("loading new index failed", aux.Fields{"path": *indexPath, "error": err})
+		return
+	}
+
+	logger.Info("loaded new index", aux.Fields{
+		"path":      *indexPath,
+		"entries":   len(newidx.Entries),
+		"suites":    len(newidx.Suites),
+		"languages": len(newidx.Langs),
+	})
+
+	if err := server.SwapIndex(newidx); err != nil {
+		logger.Error("swapping index failed", aux.Fields{"error": err})
+		return
+	}
+
+	logger.Info("index swapped", nil)
+	setIndexModTime(server, logger)
+
+	if *rulesPath != "" {
+		rules, err := redirect.LoadRules(*rulesPath)
+		if err != nil {
+			logger.Error("loading rules failed", aux.Fields{"path": *rulesPath, "error": err})
+		} else {
+			server.SwapRules(rules)
+			logger.Info("loaded rules", aux.Fields{"path": *rulesPath, "rules": len(rules)})
+		}
+	}
+
+	if *whatisGlob != "" {
+		entries, err := aux.LoadWhatis(*whatisGlob)
+		if err != nil {
+			logger.Error("loading whatis databases failed", aux.Fields{"glob": *whatisGlob, "error": err})
+		} else {
+			server.SwapWhatis(entries)
+			logger.Info("loaded whatis databases", aux.Fields{"glob": *whatisGlob, "entries": len(entries)})
+		}
+	}
+
+	if *searchGlob != "" {
+		searchIdx, err := aux.LoadSearch(*searchGlob)
+		if err != nil {
+			logger.Error("loading search indexes failed", aux.Fields{"glob": *searchGlob, "error": err})
+		} else {
+			server.SwapSearch(searchIdx)
+			logger.Info("loaded search indexes", aux.Fields{"glob": *searchGlob, "documents": searchIdx.Len()})
+		}
+	}
+	// Force the garbage collector to return all unused memory to the
+	// operating system. Even though, on Linux, unused memory can
+	// apparently be reclaimed by the kernel, preemptively returning the
+	// memory is less confusing for sysadmins who aren’t intimately
+	// familiar with Go’s memory model.
+	debug.FreeOSMemory()
+}
+
+// watchDebounce is how long to wait for further changes of the index
+// file before reloading it.
+const watchDebounce = 1 * time.Second
+
+// debounce forwards a value from in once no further value was received
+// for d.
+func debounce(in <-chan struct{}, d time.Duration) <-chan struct{} {
+	out := make(chan struct{})
+	go func() {
+		defer close(out)
+		for _ = range in {
+			timer := time.NewTimer(d)
+		wait:
+			for {
+				select {
+				case _, ok := <-in:
+					if !ok {
+						break wait
+					}
+					timer.Reset(d)
+				case <-timer.C:
+					break wait
+				}
+			}
+			timer.Stop()
+			out <- struct{}{}
+		}
+	}()
+	return out
+}
+
+// setIndexModTime tells server the modification time of *indexPath, so
+// that it can report the age of the index.
+func setIndexModTime(server *aux.Server, logger *aux.Logger) {
+	fi, err := os.Stat(*indexPath)
+	if err != nil {
+		logger.Warning("cannot determine index age", aux.Fields{"path": *indexPath, "error": err})
+		return
+	}
+	server.SetIndexModTime(fi.ModTime())
+}
+
 // use go build -ldflags "-X main.debimanVersion=<version>" to set the version
 var debimanVersion = "HEAD"
 
 func main() {
 	flag.Parse()
 
-	log.Printf("debiman auxserver loading index from %q", *indexPath)
+	level, err := aux.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := aux.NewLogger(os.Stderr, level, *logJSON)
+
+	logger.Info("loading index", aux.Fields{"path": *indexPath, "version": debimanVersion})
 
 	if *injectAssets != "" {
 		if err := bundled.Inject(*injectAssets); err != nil {
-			log.Fatal(err)
+			logger.Fatal("injecting assets failed", aux.Fields{"path": *injectAssets, "error": err})
 		}
 	}
 
 	idx, err := redirect.IndexFromProto(*indexPath)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("loading index failed", aux.Fields{"path": *indexPath, "error": err})
 	}
 
 	commonTmpls := commontmpl.MustParseCommonTmpls()
 	notFoundTmpl := template.Must(commonTmpls.New("notfound").Parse(bundled.Asset("notfound.tmpl")))
 	server := aux.NewServer(idx, notFoundTmpl, debimanVersion)
+	server.SetLogger(logger)
+	server.SetMaxIndexAge(*maxIndexAge)
+	setIndexModTime(server, logger)
+	if *rulesPath != "" {
+		rules, err := redirect.LoadRules(*rulesPath)
+		if err != nil {
+			logger.Fatal("loading rules failed", aux.Fields{"path": *rulesPath, "error": err})
+		}
+		logger.Info("loaded rules", aux.Fields{"path": *rulesPath, "rules": len(rules)})
+		server.SwapRules(rules)
+	}
+	if *whatisGlob != "" {
+		entries, err := aux.LoadWhatis(*whatisGlob)
+		if err != nil {
+			logger.Fatal("loading whatis databases failed", aux.Fields{"glob": *whatisGlob, "error": err})
+		}
+		logger.Info("loaded whatis databases", aux.Fields{"glob": *whatisGlob, "entries": len(entries)})
+		server.SwapWhatis(entries)
+	}
+	if *searchGlob != "" {
+		searchIdx, err := aux.LoadSearch(*searchGlob)
+		if err != nil {
+			logger.Fatal("loading search indexes failed", aux.Fields{"glob": *searchGlob, "error": err})
+		}
+		logger.Info("loaded search indexes", aux.Fields{"glob": *searchGlob, "documents": searchIdx.Len()})
+		server.SwapSearch(searchIdx)
+	}
 
+	reloads := make(chan string)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP)
 	go func() {
 		for _ = range c {
-			log.Printf("SIGHUP received, trying to reload index")
-
-			newidx, err := redirect.IndexFromProto(*indexPath)
-			if err != nil {
-				log.Printf("Could not load new index from %q: %v", *indexPath, err)
-				continue
+			reloads <- "SIGHUP received"
+		}
+	}()
+	if *watchIndex {
+		changed, err := watchFile(*indexPath)
+		if err != nil {
+			logger.Fatal("watching index failed", aux.Fields{"path": *indexPath, "error": err})
+		}
+		go func() {
+			for _ = range debounce(changed, watchDebounce) {
+				reloads <- "index file changed"
 			}
+		}()
+	}
+	go func() {
+		for reason := range reloads {
+			logger.Info(reason+", trying to reload index", nil)
+			reload(server, logger)
+		}
+	}()
 
-			log.Printf("Loaded %d manpage entries, %d suites, %d languages from new index %q",
-				len(newidx.Entries), len(newidx.Suites), len(newidx.Langs), *indexPath)
+	limiter := aux.NewLimiter(*rateLimit, *rateBurst, *maxConcurrent, *trustForwardedFor)
+	var origins []string
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	cors := aux.NewCORS(origins)
+	http.HandleFunc("/jump", server.Instrument("jump", limiter.Wrap(server.HandleJump)))
+	http.HandleFunc("/suggest", server.Instrument("suggest", cors.Wrap(limiter.Wrap(server.HandleSuggest))))
+	http.HandleFunc("/apropos", server.Instrument("apropos", cors.Wrap(limiter.Wrap(server.HandleApropos))))
+	http.HandleFunc("/search", server.Instrument("search", cors.Wrap(limiter.Wrap(server.HandleSearch))))
+	http.HandleFunc("/api/resolve", server.Instrument("resolve", cors.Wrap(limiter.Wrap(server.HandleResolve))))
+	http.HandleFunc("/preferences", server.Instrument("preferences", server.HandlePreferences))
+	http.HandleFunc("/metrics", server.HandleMetrics)
+	http.HandleFunc("/healthz", server.HandleHealthz)
+	http.HandleFunc("/readyz", server.HandleReadyz)
+	http.HandleFunc("/", server.Instrument("redirect", limiter.Wrap(server.HandleRedirect)))
 
-			if err := server.SwapIndex(newidx); err != nil {
-				log.Printf("Swapping index failed: %v", err)
-				continue
-			}
+	logger.Info("loaded index", aux.Fields{
+		"path":      *indexPath,
+		"entries":   len(idx.Entries),
+		"suites":    len(idx.Suites),
+		"languages": len(idx.Langs),
+	})
 
-			log.Printf("Index swapped")
-			// Force the garbage collector to return all unused memory to the
-			// operating system. Even though, on Linux, unused memory can
-			// apparently be reclaimed by the kernel, preemptively returning the
-			// memory is less confusing for sysadmins who aren’t intimately
-			// familiar with Go’s memory model.
-			debug.FreeOSMemory()
-		}
-	}()
+	tlsConfig, challenges, err := tlsSetup()
+	if err != nil {
+		logger.Fatal("setting up TLS failed", aux.Fields{"error": err})
+	}
+	if challenges != nil {
+		go func() {
+			logger.Info("starting ACME HTTP-01 challenge listener", aux.Fields{"addr": *acmeHTTPListen})
+			err := http.ListenAndServe(*acmeHTTPListen, challenges)
+			logger.Fatal("ACME HTTP-01 challenge listener failed", aux.Fields{"addr": *acmeHTTPListen, "error": err})
+		}()
+	}
 
-	http.HandleFunc("/jump", server.HandleJump)
-	http.HandleFunc("/suggest", server.HandleSuggest)
-	http.HandleFunc("/", server.HandleRedirect)
+	listeners, err := activationListeners()
+	if err != nil {
+		logger.Fatal("using systemd socket activation failed", aux.Fields{"error": err})
+	}
+	if len(listeners) == 0 {
+		// Not socket-activated by systemd.
+		ln, err := listen(*listenAddr)
+		if err != nil {
+			logger.Fatal("HTTP listener failed", aux.Fields{"addr": *listenAddr, "error": err})
+		}
+		listeners = []net.Listener{ln}
+	}
 
-	log.Printf("Loaded %d manpage entries, %d suites, %d languages from index %q",
-		len(idx.Entries), len(idx.Suites), len(idx.Langs), *indexPath)
+	var handler http.Handler = http.DefaultServeMux
+	if *accessLog != "" {
+		al, err := aux.OpenAccessLog(*accessLog, *accessLogFormat)
+		if err != nil {
+			logger.Fatal("opening access log failed", aux.Fields{"path": *accessLog, "error": err})
+		}
+		handler = al.Wrap(handler)
+	}
 
-	log.Printf("Starting HTTP listener on %q", *listenAddr)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		logger.Info("starting HTTP listener", aux.Fields{
+			"addr": ln.Addr().String(),
+			"tls":  tlsConfig != nil,
+		})
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		go func(ln net.Listener) {
+			errs <- http.Serve(ln, handler)
+		}(ln)
+	}
+	logger.Fatal("HTTP listener failed", aux.Fields{"error": <-errs})
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// listen listens on addr, which is either a TCP host:port address or
+// unix:<path> for a Unix domain socket.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	// Remove the socket of a previous instance, which was not shut down
+	// cleanly. Refuse to remove anything but sockets, and sockets on
+	// which another instance is still listening.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%q is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Connecting requires write permission. Restrict access via the
+	// permissions of the directory containing the socket instead.
+	if err := os.Chmod(path, 0666); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-auxserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "aux.sock")
+
+	// Leave a stale socket behind, like a crashed instance would.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The socket is in use, so it must not be taken over.
+	if second, err := listen("unix:" + path); err == nil {
+		second.Close()
+		t.Errorf("listen unexpectedly took over the socket %q in use", path)
+	}
+	conn, err = net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("socket of the first listener was removed: %v", err)
+	}
+	conn.Close()
+
+	regular := filepath.Join(tmpdir, "regular")
+	if err := ioutil.WriteFile(regular, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix:" + regular); err == nil {
+		t.Errorf("listen unexpectedly replaced the regular file %q", regular)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	tlsCert = flag.String("tls_cert",
+		"",
+		"If non-empty, path to a PEM-encoded TLS certificate (chain). Together with -tls_key, makes debiman-auxserver serve HTTPS instead of HTTP.")
+
+	tlsKey = flag.String("tls_key",
+		"",
+		"If non-empty, path to the PEM-encoded private key of -tls_cert")
+
+	acmeDomains = flag.String("acme_domains",
+		"",
+		"If non-empty, a comma-separated list of domain names for which to serve HTTPS using certificates obtained automatically from Let’s Encrypt. By using this flag, you accept the Let’s Encrypt terms of service.")
+
+	acmeCache = flag.String("acme_cache",
+		"/var/cache/debiman-auxserver/acme",
+		"Directory in which to store the account key and certificates obtained for -acme_domains")
+
+	acmeEmail = flag.String("acme_email",
+		"",
+		"If non-empty, the contact email address to register with Let’s Encrypt, which is notified about expiring certificates")
+
+	acmeHTTPListen = flag.String("acme_http_listen",
+		"",
+		"If non-empty, a host:port address (typically :80) on which to answer HTTP-01 challenges for -acme_domains and redirect all other requests to HTTPS. Without it, only the TLS-ALPN-01 challenge is available.")
+)
+
+// tlsSetup returns the TLS configuration specified by the flags, or nil
+// if debiman-auxserver should serve plain HTTP. The returned handler is
+// non-nil if ACME HTTP-01 challenges should be answered on
+// -acme_http_listen.
+func tlsSetup() (*tls.Config, http.Handler, error) {
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, nil, errors.New("-tls_cert and -tls_key must be specified together")
+	}
+	if *tlsCert != "" && *acmeDomains != "" {
+		return nil, nil, errors.New("-tls_cert and -acme_domains are mutually exclusive")
+	}
+
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+		}, nil, nil
+	}
+
+	if *acmeDomains != "" {
+		var domains []string
+		for _, domain := range strings.Split(*acmeDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(*acmeCache),
+			Email:      *acmeEmail,
+		}
+		var challenges http.Handler
+		if *acmeHTTPListen != "" {
+			challenges = m.HTTPHandler(nil)
+		}
+		return m.TLSConfig(), challenges, nil
+	}
+
+	if *acmeHTTPListen != "" {
+		return nil, nil, errors.New("-acme_http_listen requires -acme_domains")
+	}
+	return nil, nil, nil
+}
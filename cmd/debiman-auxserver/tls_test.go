@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a self-signed certificate and its key to dir.
+func writeSelfSigned(t *testing.T, dir string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestTLSSetup(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-auxserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	certPath, keyPath := writeSelfSigned(t, tmpdir)
+
+	oldCert, oldKey, oldDomains, oldCache, oldHTTP := *tlsCert, *tlsKey, *acmeDomains, *acmeCache, *acmeHTTPListen
+	defer func() {
+		*tlsCert, *tlsKey, *acmeDomains, *acmeCache, *acmeHTTPListen = oldCert, oldKey, oldDomains, oldCache, oldHTTP
+	}()
+	*acmeCache = tmpdir
+
+	for _, tt := range []struct {
+		name                             string
+		cert, key, domains, httpListen   string
+		wantTLS, wantChallenges, wantErr bool
+	}{
+		{name: "plain"},
+		{name: "files", cert: certPath, key: keyPath, wantTLS: true},
+		{name: "cert without key", cert: certPath, wantErr: true},
+		{name: "files and acme", cert: certPath, key: keyPath, domains: "manpages.example", wantErr: true},
+		{name: "acme", domains: "manpages.example", wantTLS: true},
+		{name: "acme with http-01", domains: "manpages.example, man.example", httpListen: ":80", wantTLS: true, wantChallenges: true},
+		{name: "http-01 without acme", httpListen: ":80", wantErr: true},
+	} {
+		*tlsCert, *tlsKey, *acmeDomains, *acmeHTTPListen = tt.cert, tt.key, tt.domains, tt.httpListen
+		cfg, challenges, err := tlsSetup()
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("%s: got err %v, want error: %v", tt.name, err, want)
+			continue
+		}
+		if got, want := cfg != nil, tt.wantTLS; got != want {
+			t.Errorf("%s: got TLS config %v, want %v", tt.name, got, want)
+		}
+		if got, want := challenges != nil, tt.wantChallenges; got != want {
+			t.Errorf("%s: got challenge handler %v, want %v", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// +build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchInterval is how often watchFile checks for changes.
+const watchInterval = 10 * time.Second
+
+// watchFile returns a channel on which a value is sent whenever the
+// modification time of the file at path changes.
+func watchFile(path string) (<-chan struct{}, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(chan struct{})
+	go func() {
+		last := fi.ModTime()
+		for _ = range time.Tick(watchInterval) {
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(last) {
+				continue
+			}
+			last = fi.ModTime()
+			changed <- struct{}{}
+		}
+	}()
+	return changed, nil
+}
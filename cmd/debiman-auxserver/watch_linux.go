@@ -0,0 +1,53 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile returns a channel on which a value is sent whenever the
+// file at path is replaced (as debiman does, by renaming a temporary
+// file) or written to.
+func watchFile(path string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory: a watch on the file itself would stay with
+	// the replaced inode.
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	base := filepath.Base(path)
+	changed := make(chan struct{})
+	go func() {
+		defer close(changed)
+		defer unix.Close(fd)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				return
+			}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + unix.SizeofInotifyEvent
+				name := string(bytes.TrimRight(buf[nameStart:nameStart+int(ev.Len)], "\x00"))
+				if name == base {
+					changed <- struct{}{}
+				}
+				offset = nameStart + int(ev.Len)
+			}
+		}
+	}()
+	return changed, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watchFile polls on non-Linux systems")
+	}
+	tmpdir, err := ioutil.TempDir("", "debiman-auxserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "auxserver.idx")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := watchFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unrelated files do not trigger a change.
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Replace the index like debiman does.
+	tmp := filepath.Join(tmpdir, "auxserver.idx.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported after replacing the watched file")
+	}
+	select {
+	case <-changed:
+		t.Fatal("unexpected additional change reported")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	in := make(chan struct{})
+	out := debounce(in, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		in <- struct{}{}
+	}
+	select {
+	case <-out:
+	case <-time.After(5 * time.Second):
+		t.Fatal("debounced value not forwarded")
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Fatal("more than one debounced value forwarded")
+	}
+}
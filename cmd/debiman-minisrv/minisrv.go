@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"errors"
 	"flag"
+	"fmt"
 	"html/template"
 	"io"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/Debian/debiman/internal/aux"
@@ -29,6 +31,18 @@ var (
 	listenAddr = flag.String("listen",
 		"localhost:8089",
 		"host:port on which to serve manpages")
+
+	redirects = flag.Bool("redirects",
+		true,
+		"Resolve requests for missing files (e.g. /i3) and serve /jump, /suggest, /apropos, /search, /api/resolve and /preferences like debiman-auxserver does, so that no separate debiman-auxserver is required. Requires <serving_dir>/auxserver.idx; uses <serving_dir>/whatis-*.json and <serving_dir>/search-*.gob if present.")
+
+	accessLog = flag.String("access_log",
+		"",
+		"If non-empty, path to a file to which to append an access log line per request (- for standard output), see -access_log_format")
+
+	accessLogFormat = flag.String("access_log_format",
+		"combined",
+		"Format of -access_log: common (Common Log Format) or combined (Combined Log Format, which additionally contains the referer and user agent)")
 )
 
 // use go build -ldflags "-X main.debimanVersion=<version>" to set the version
@@ -66,31 +80,115 @@ func serveFile(w http.ResponseWriter, r *http.Request) error {
 
 	rd := io.Reader(f)
 	if compressed {
-		gzipr, err := gzip.NewReader(f)
-		if err != nil {
-			return err
+		// The response depends on Accept-Encoding, so caches must not
+		// serve it to clients with a different Accept-Encoding.
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else {
+			gzipr, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			rd = gzipr
+			defer gzipr.Close()
 		}
-		rd = gzipr
-		defer gzipr.Close()
 	}
 
 	_, err = io.Copy(w, rd)
 	return err
 }
 
-func main() {
-	flag.Parse()
+// acceptsGzip returns whether the Accept-Encoding header value
+// acceptEncoding allows gzip-encoded responses. An explicit gzip (or
+// x-gzip) entry takes precedence over the * wildcard (RFC 7231, section
+// 5.3.4).
+func acceptsGzip(acceptEncoding string) bool {
+	var explicit, wildcard *bool
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != "gzip" && name != "x-gzip" && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if name == "*" {
+			if wildcard == nil {
+				wildcard = &accepted
+			}
+		} else if explicit == nil {
+			explicit = &accepted
+		}
+	}
+	if explicit != nil {
+		return *explicit
+	}
+	return wildcard != nil && *wildcard
+}
 
+// newAuxServer returns an aux.Server serving the auxserver index, whatis
+// databases and search indexes found in *servingDir.
+func newAuxServer() (*aux.Server, error) {
 	idx, err := redirect.IndexFromProto(filepath.Join(*servingDir, "auxserver.idx"))
 	if err != nil {
-		log.Fatalf("Could not load auxserver index: %v", err)
+		return nil, fmt.Errorf("Could not load auxserver index: %v", err)
 	}
 
 	commonTmpls := commontmpl.MustParseCommonTmpls()
 	notFoundTmpl := template.Must(commonTmpls.New("notfound").Parse(bundled.Asset("notfound.tmpl")))
 	server := aux.NewServer(idx, notFoundTmpl, debimanVersion)
 
-	http.HandleFunc("/jump", server.HandleJump)
+	entries, err := aux.LoadWhatis(filepath.Join(*servingDir, "whatis-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	server.SwapWhatis(entries)
+
+	searchIdx, err := aux.LoadSearch(filepath.Join(*servingDir, "search-*.gob"))
+	if err != nil {
+		return nil, err
+	}
+	server.SwapSearch(searchIdx)
+	return server, nil
+}
+
+func main() {
+	flag.Parse()
+
+	var server *aux.Server
+	if *redirects {
+		var err error
+		server, err = newAuxServer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.HandleFunc("/jump", server.HandleJump)
+		http.HandleFunc("/suggest", server.HandleSuggest)
+		http.HandleFunc("/apropos", server.HandleApropos)
+		http.HandleFunc("/search", server.HandleSearch)
+		http.HandleFunc("/api/resolve", server.HandleResolve)
+		http.HandleFunc("/preferences", server.HandlePreferences)
+	}
+
+	var watchr *watcher
+	if *watch {
+		var dirs []string
+		for _, dir := range []string{*templatesDir, *injectAssets} {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+		watchr = newWatcher(*servingDir, dirs, renderWithDebiman)
+		go watchr.poll()
+		log.Printf("Watching %v and the raw manpages of viewed pages for changes", dirs)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Similarly to http.ServeFile, deny requests containing .. as
@@ -111,12 +209,44 @@ func main() {
 			return
 		}
 		if err == nil {
+			if watchr != nil {
+				watchr.view(r.URL.Path)
+			}
 			return
 		}
 
+		if server == nil {
+			http.NotFound(w, r)
+			return
+		}
 		server.HandleRedirect(w, r)
 	})
 
-	log.Printf("Serving manpages from %q on %q", *servingDir, *listenAddr)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	tlsConfig, err := tlsSetup()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	log.Printf("Serving manpages from %q on %s://%s", *servingDir, scheme, *listenAddr)
+	var handler http.Handler = http.DefaultServeMux
+	if *accessLog != "" {
+		al, err := aux.OpenAccessLog(*accessLog, *accessLogFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler = al.Wrap(handler)
+	}
+	srv := &http.Server{
+		Addr:      *listenAddr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil {
+		log.Fatal(srv.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tt := range []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"gzip, deflate, br", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"br", false},
+		{"*", true},
+		{"*;q=0, gzip", true},
+		{"gzip;q=0, *", false},
+		{"br, *;q=0", false},
+		{"identity", false},
+	} {
+		if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestServeFileCompressed(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-minisrv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer func() { *servingDir = oldServingDir }()
+	*servingDir = tmpdir
+
+	const content = "<html>i3</html>"
+	var buf bytes.Buffer
+	gzipw := gzip.NewWriter(&buf)
+	gzipw.Write([]byte(content))
+	if err := gzipw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "i3.1.en.html.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       []byte
+	}{
+		{"gzip", "gzip", buf.Bytes()},
+		{"", "", []byte(content)},
+	} {
+		req := httptest.NewRequest("GET", "/i3.1.en.html", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		if err := serveFile(rec, req); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := rec.Header().Get("Content-Encoding"), tt.wantEncoding; got != want {
+			t.Errorf("Accept-Encoding %q: unexpected Content-Encoding: got %q, want %q", tt.acceptEncoding, got, want)
+		}
+		if got, want := rec.Header().Get("Content-Type"), "text/html; charset=utf-8"; got != want {
+			t.Errorf("Accept-Encoding %q: unexpected Content-Type: got %q, want %q", tt.acceptEncoding, got, want)
+		}
+		if got, want := rec.Header().Get("Vary"), "Accept-Encoding"; got != want {
+			t.Errorf("Accept-Encoding %q: unexpected Vary: got %q, want %q", tt.acceptEncoding, got, want)
+		}
+		if got, want := rec.Body.Bytes(), tt.wantBody; !bytes.Equal(got, want) {
+			t.Errorf("Accept-Encoding %q: unexpected body: got %q, want %q", tt.acceptEncoding, got, want)
+		}
+	}
+}
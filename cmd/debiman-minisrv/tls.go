@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	tlsCert = flag.String("tls_cert",
+		"",
+		"If non-empty, path to a PEM-encoded TLS certificate (chain). Together with -tls_key, makes minisrv serve HTTPS instead of HTTP.")
+
+	tlsKey = flag.String("tls_key",
+		"",
+		"If non-empty, path to the PEM-encoded private key of -tls_cert")
+
+	tlsSelfSigned = flag.Bool("tls_self_signed",
+		false,
+		"Serve HTTPS using a self-signed certificate generated on startup (valid for localhost and the -listen host), e.g. to test service workers and other features which require a secure context. Browsers will show a certificate warning.")
+)
+
+// selfSignedValidity is how long a certificate generated for
+// -tls_self_signed is valid.
+const selfSignedValidity = 30 * 24 * time.Hour
+
+// selfSigned returns a self-signed certificate which is valid for
+// localhost, the loopback addresses and host (if non-empty).
+func selfSigned(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"debiman-minisrv"}},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsLoopback() && !ip.IsUnspecified() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			}
+		} else if host != "localhost" {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// tlsSetup returns the TLS configuration specified by the flags, or nil
+// if minisrv should serve plain HTTP.
+func tlsSetup() (*tls.Config, error) {
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, errors.New("-tls_cert and -tls_key must be specified together")
+	}
+	if *tlsCert != "" && *tlsSelfSigned {
+		return nil, errors.New("-tls_cert and -tls_self_signed are mutually exclusive")
+	}
+
+	var cert tls.Certificate
+	var err error
+	switch {
+	case *tlsCert != "":
+		cert, err = tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	case *tlsSelfSigned:
+		host, _, splitErr := net.SplitHostPort(*listenAddr)
+		if splitErr != nil {
+			return nil, splitErr
+		}
+		cert, err = selfSigned(host)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+	}, nil
+}
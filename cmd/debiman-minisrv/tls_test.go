@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestSelfSigned(t *testing.T) {
+	for _, tt := range []struct {
+		host     string
+		wantDNS  []string
+		wantIPs  int
+		verifyAs string
+	}{
+		{host: "localhost", wantDNS: []string{"localhost"}, wantIPs: 2, verifyAs: "localhost"},
+		{host: "", wantDNS: []string{"localhost"}, wantIPs: 2, verifyAs: "127.0.0.1"},
+		{host: "manpages.example", wantDNS: []string{"localhost", "manpages.example"}, wantIPs: 2, verifyAs: "manpages.example"},
+		{host: "192.0.2.1", wantDNS: []string{"localhost"}, wantIPs: 3, verifyAs: "192.0.2.1"},
+	} {
+		cert, err := selfSigned(tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(parsed.DNSNames), len(tt.wantDNS); got != want {
+			t.Fatalf("%q: unexpected DNS names: got %v, want %v", tt.host, parsed.DNSNames, tt.wantDNS)
+		}
+		for idx, name := range tt.wantDNS {
+			if got := parsed.DNSNames[idx]; got != name {
+				t.Errorf("%q: DNSNames[%d]: got %q, want %q", tt.host, idx, got, name)
+			}
+		}
+		if got, want := len(parsed.IPAddresses), tt.wantIPs; got != want {
+			t.Errorf("%q: unexpected IP addresses: got %v, want %d entries", tt.host, parsed.IPAddresses, want)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(parsed)
+		if _, err := parsed.Verify(x509.VerifyOptions{DNSName: tt.verifyAs, Roots: roots}); err != nil {
+			t.Errorf("%q: Verify(%q): %v", tt.host, tt.verifyAs, err)
+		}
+	}
+}
+
+func TestTLSSetup(t *testing.T) {
+	oldCert, oldKey, oldSelfSigned, oldListen := *tlsCert, *tlsKey, *tlsSelfSigned, *listenAddr
+	defer func() {
+		*tlsCert, *tlsKey, *tlsSelfSigned, *listenAddr = oldCert, oldKey, oldSelfSigned, oldListen
+	}()
+	*listenAddr = "localhost:8089"
+
+	for _, tt := range []struct {
+		name             string
+		cert, key        string
+		selfSigned       bool
+		wantTLS, wantErr bool
+	}{
+		{name: "plain"},
+		{name: "self-signed", selfSigned: true, wantTLS: true},
+		{name: "cert without key", cert: "cert.pem", wantErr: true},
+		{name: "cert and self-signed", cert: "cert.pem", key: "key.pem", selfSigned: true, wantErr: true},
+		{name: "missing cert", cert: "/nonexistent/cert.pem", key: "/nonexistent/key.pem", wantErr: true},
+	} {
+		*tlsCert, *tlsKey, *tlsSelfSigned = tt.cert, tt.key, tt.selfSigned
+		cfg, err := tlsSetup()
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("%s: got err %v, want error: %v", tt.name, err, want)
+		}
+		if got, want := cfg != nil, tt.wantTLS; got != want {
+			t.Errorf("%s: got TLS config %v, want TLS: %v", tt.name, cfg, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	watch = flag.Bool("watch",
+		false,
+		"Development mode: watch -templates_dir, -inject_assets and the raw manpages viewed in this session, and re-render the viewed manpages (using debiman -render_one) when they change")
+
+	templatesDir = flag.String("templates_dir",
+		"",
+		"If non-empty, a directory containing templates which override the built-in templates, passed to debiman -templates_dir in -watch mode")
+
+	injectAssets = flag.String("inject_assets",
+		"",
+		"If non-empty, a directory containing assets to overwrite, passed to debiman -inject_assets in -watch mode")
+
+	debimanPath = flag.String("debiman",
+		"debiman",
+		"Path to the debiman binary used for re-rendering in -watch mode")
+
+	debimanArgs = flag.String("debiman_args",
+		"",
+		"Additional space-separated arguments to pass to debiman in -watch mode, e.g. -sync_codenames= -sync_suites=unstable -persist_global_view")
+)
+
+// pollInterval is how often the watcher checks for changes.
+const pollInterval = 1 * time.Second
+
+// maxViewed is the number of most recently viewed manpages which the
+// watcher keeps re-rendering.
+const maxViewed = 100
+
+// watcher tracks the manpages viewed in this session and re-renders them
+// when their raw manpage or any template or asset changes.
+type watcher struct {
+	servingDir string
+	dirs       []string
+	render     func(servingPaths []string) error
+
+	mu sync.Mutex
+	// viewed maps the serving paths of viewed manpages to the
+	// modification time of their raw manpage.
+	viewed map[string]time.Time
+	order  []string // serving paths, least recently viewed first
+
+	// dirState maps the files within dirs to their modification time.
+	dirState map[string]time.Time
+}
+
+func newWatcher(servingDir string, dirs []string, render func([]string) error) *watcher {
+	w := &watcher{
+		servingDir: servingDir,
+		dirs:       dirs,
+		render:     render,
+		viewed:     make(map[string]time.Time),
+	}
+	w.dirState = w.scanDirs()
+	return w
+}
+
+// scanDirs returns the modification times of all files within w.dirs.
+func (w *watcher) scanDirs() map[string]time.Time {
+	state := make(map[string]time.Time)
+	for _, dir := range w.dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // skip files which vanished during the walk
+			}
+			if info.Mode().IsRegular() {
+				state[path] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return state
+}
+
+// view records that the page at urlPath (e.g.
+// /jessie/i3-wm/i3.1.en.html) was viewed, if it is a manpage.
+func (w *watcher) view(urlPath string) {
+	if !strings.HasSuffix(urlPath, ".html") {
+		return
+	}
+	servingPath := strings.TrimSuffix(strings.TrimPrefix(urlPath, "/"), ".html")
+	st, err := os.Stat(filepath.Join(w.servingDir, servingPath+".gz"))
+	if err != nil {
+		return // not a manpage, e.g. an index page
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.viewed[servingPath]; ok {
+		for idx, p := range w.order {
+			if p == servingPath {
+				w.order = append(w.order[:idx], w.order[idx+1:]...)
+				break
+			}
+		}
+	} else {
+		w.viewed[servingPath] = st.ModTime()
+	}
+	w.order = append(w.order, servingPath)
+	if len(w.order) > maxViewed {
+		delete(w.viewed, w.order[0])
+		w.order = w.order[1:]
+	}
+}
+
+// changed returns the serving paths of the viewed manpages which need to
+// be re-rendered.
+func (w *watcher) changed() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dirState := w.scanDirs()
+	dirsChanged := len(dirState) != len(w.dirState)
+	for path, modTime := range dirState {
+		if prev, ok := w.dirState[path]; !ok || !prev.Equal(modTime) {
+			dirsChanged = true
+		}
+	}
+	w.dirState = dirState
+
+	var changed []string
+	for _, servingPath := range w.order {
+		st, err := os.Stat(filepath.Join(w.servingDir, servingPath+".gz"))
+		if err != nil {
+			continue
+		}
+		if dirsChanged || !st.ModTime().Equal(w.viewed[servingPath]) {
+			changed = append(changed, servingPath)
+		}
+		w.viewed[servingPath] = st.ModTime()
+	}
+	return changed
+}
+
+// poll re-renders changed manpages every pollInterval.
+func (w *watcher) poll() {
+	for _ = range time.Tick(pollInterval) {
+		changed := w.changed()
+		if len(changed) == 0 {
+			continue
+		}
+		log.Printf("Re-rendering %d changed manpages: %v", len(changed), changed)
+		if err := w.render(changed); err != nil {
+			log.Printf("Re-rendering failed: %v", err)
+		}
+	}
+}
+
+// renderWithDebiman re-renders the manpages identified by servingPaths
+// by invoking debiman -render_one.
+func renderWithDebiman(servingPaths []string) error {
+	args := strings.Fields(*debimanArgs)
+	args = append(args, "-serving_dir="+*servingDir)
+	if *templatesDir != "" {
+		args = append(args, "-templates_dir="+*templatesDir)
+	}
+	if *injectAssets != "" {
+		args = append(args, "-inject_assets="+*injectAssets)
+	}
+	args = append(args, "-render_one="+strings.Join(servingPaths, ","))
+	cmd := exec.Command(*debimanPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-minisrv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	servingDir := filepath.Join(tmpdir, "serving")
+	templates := filepath.Join(tmpdir, "templates")
+	for _, dir := range []string{filepath.Join(servingDir, "jessie", "i3-wm"), templates} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-1 * time.Hour)
+	i3 := filepath.Join(servingDir, "jessie", "i3-wm", "i3.1.en.gz")
+	i3msg := filepath.Join(servingDir, "jessie", "i3-wm", "i3-msg.1.en.gz")
+	write(i3, past)
+	write(i3msg, past)
+	write(filepath.Join(templates, "manpage.tmpl"), past)
+
+	w := newWatcher(servingDir, []string{templates}, nil)
+	w.view("/jessie/i3-wm/i3.1.en.html")
+	w.view("/jessie/i3-wm/i3-msg.1.en.html")
+	w.view("/index.html")           // not a manpage
+	w.view("/jessie/i3-wm/i3.1.en") // not a page
+	if got := w.changed(); len(got) != 0 {
+		t.Fatalf("unexpected changes: %v", got)
+	}
+
+	write(i3, past.Add(1*time.Minute))
+	if got, want := w.changed(), []string{"jessie/i3-wm/i3.1.en"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("raw manpage changed: got %v, want %v", got, want)
+	}
+	if got := w.changed(); len(got) != 0 {
+		t.Fatalf("unexpected repeated changes: %v", got)
+	}
+
+	write(filepath.Join(templates, "manpage.tmpl"), past.Add(1*time.Minute))
+	want := []string{"jessie/i3-wm/i3.1.en", "jessie/i3-wm/i3-msg.1.en"}
+	if got := w.changed(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("template changed: got %v, want %v", got, want)
+	}
+
+	write(filepath.Join(templates, "header.tmpl"), past)
+	if got := w.changed(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("template added: got %v, want %v", got, want)
+	}
+}
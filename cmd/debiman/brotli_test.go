@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestBrotliSibling(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-brotli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	old := *brotliOutput
+	*brotliOutput = true
+	defer func() { *brotliOutput = old }()
+
+	for _, dest := range []string{"sitemap.xml.gz", "metrics.txt"} {
+		if got := siblingPaths(filepath.Join(tmpdir, dest)); len(got) > 0 {
+			t.Errorf("siblingPaths(%q): got %v, want none", dest, got)
+		}
+	}
+
+	dest := filepath.Join(tmpdir, "i3.1.en.html.gz")
+	if !siblingsStale(dest, time.Now()) {
+		t.Fatalf("missing brotli sibling not considered stale")
+	}
+	if err := writeAtomically(dest, true, func(w io.Writer) error {
+		_, err := io.WriteString(w, "<html></html>")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	brPath := strings.TrimSuffix(dest, ".gz") + ".br"
+	f, err := os.Open(brPath)
+	if err != nil {
+		t.Fatalf("brotli sibling not written: %v", err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(brotli.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "<html></html>"; got != want {
+		t.Fatalf("brotli sibling: got %q, want %q", got, want)
+	}
+	if siblingsStale(dest, time.Now().Add(-1*time.Hour)) {
+		t.Fatalf("up to date brotli sibling considered stale")
+	}
+
+	// A failed write must not leave a brotli sibling behind.
+	failed := filepath.Join(tmpdir, "failed.html.gz")
+	if err := writeAtomically(failed, true, func(w io.Writer) error {
+		return io.ErrUnexpectedEOF
+	}); err == nil {
+		t.Fatalf("writeAtomically unexpectedly succeeded")
+	}
+	if _, err := os.Stat(strings.TrimSuffix(failed, ".gz") + ".br"); !os.IsNotExist(err) {
+		t.Fatalf("brotli sibling of failed write unexpectedly present: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Artifact classes, see cachePolicy.
+const (
+	artifactStatic  = "static"
+	artifactSitemap = "sitemap"
+	artifactData    = "data"
+	artifactIndex   = "index"
+	artifactDiff    = "diff"
+	artifactManpage = "manpage"
+	artifactEPUB    = "epub"
+	artifactInfo    = "info"
+)
+
+// artifactClass describes a class of files which debiman places in
+// -serving_dir, together with the Cache-Control header which should
+// be sent when serving them.
+type artifactClass struct {
+	// Class is a short identifier, e.g. “manpage”.
+	Class string `json:"class"`
+
+	// Pattern is a regular expression matching the URL paths of all
+	// files of this class. The .gz suffix of the files on disk is
+	// not part of the URL path.
+	Pattern string `json:"pattern"`
+
+	// Example is the URL path of a file of this class, which
+	// writeCachePolicy verifies to be classified as such.
+	Example string `json:"example"`
+
+	// CacheControl is the suggested value of the Cache-Control HTTP
+	// header.
+	CacheControl string `json:"cache_control"`
+
+	re *regexp.Regexp // compiled Pattern
+}
+
+// cachePolicy lists the classes of artifacts debiman produces. The
+// first matching pattern wins, so more specific patterns (e.g.
+// package indexes) must come before less specific ones (manpages).
+var cachePolicy = []artifactClass{
+	{
+		// Fonts are bundled with debiman and never change.
+		Class:        artifactStatic,
+		Pattern:      `^/[^/]+\.woff2?$`,
+		Example:      "/Inconsolata.woff2",
+		CacheControl: "public, max-age=31536000, immutable",
+	},
+	{
+		// Sitemaps change whenever any package changes and are only
+		// fetched by crawlers, so keep them fresh.
+		Class:        artifactSitemap,
+		Pattern:      `^/([^/]+/)?sitemap(index(-[^/]+)?)?\.xml$`,
+		Example:      "/jessie/sitemap.xml",
+		CacheControl: "public, max-age=3600",
+	},
+	{
+		// Feeds, the whatis databases, the search indexes, the lint
+		// reports and the reference graphs change whenever a manpage
+		// of a suite changes.
+		Class:        artifactData,
+		Pattern:      `^/(feed-[^/]+\.atom|whatis-[^/]+\.(json|txt)|search-[^/]+\.gob|search-index/[^/]+/[^/]+\.json|(lint|references)-[^/]+\.json)$`,
+		Example:      "/feed-jessie.atom",
+		CacheControl: "public, max-age=3600",
+	},
+	{
+		// Info documents and their indexes (see -render_info) change
+		// whenever a package shipping Info documents is updated. Must
+		// come before the package indexes, which /info/<suite>/index.html
+		// would match otherwise.
+		Class:        artifactInfo,
+		Pattern:      `^/info/`,
+		Example:      "/info/jessie/index.html",
+		CacheControl: "public, max-age=3600",
+	},
+	{
+		// Indexes, disambiguation pages and the root pages (which link
+		// to all suites) change whenever a package is added, removed or
+		// updated in a suite.
+		Class:        artifactIndex,
+		Pattern:      `^/((index|about|faq)\.html|(contents|az|section|lang)-[^/]+\.html|[^/]+/[^/]+/index\.html|disambiguation/[^/]+/[^/]+\.html)$`,
+		Example:      "/az-jessie-i.html",
+		CacheControl: "public, max-age=3600",
+	},
+	{
+		// Differences change when the manpage is updated in either
+		// suite (see -render_diffs).
+		Class:        artifactDiff,
+		Pattern:      `^/[^/]+/[^/]+/[^/]+\.diff-[^/.]+\.html$`,
+		Example:      "/jessie/i3-wm/i3.1.en.diff-wheezy.html",
+		CacheControl: "public, max-age=86400",
+	},
+	{
+		// EPUB books only change when their package is updated.
+		Class:        artifactEPUB,
+		Pattern:      `^/[^/]+/[^/]+/[^/]+\.epub$`,
+		Example:      "/jessie/i3-wm/i3-wm.epub",
+		CacheControl: "public, max-age=86400",
+	},
+	{
+		// Manpages, their other formats (plain text, PDF, roff source,
+		// Markdown and JSON sidecar) and their images only change when
+		// their package is updated (or debiman itself is updated). The
+		// roff source is served with its .gz suffix.
+		Class:        artifactManpage,
+		Pattern:      `^/[^/]+/[^/]+/([^/]+\.[^/.]+\.[^/.]+\.(html|txt|pdf|md|json|roff(\.gz)?)|[^/]+\.assets/[^/]+)$`,
+		Example:      "/jessie/i3-wm/i3.1.en.html",
+		CacheControl: "public, max-age=86400",
+	},
+}
+
+func init() {
+	for idx := range cachePolicy {
+		cachePolicy[idx].re = regexp.MustCompile(cachePolicy[idx].Pattern)
+	}
+}
+
+// classify returns the artifact class which operators will apply to
+// the file served at path (i.e. the first matching pattern), or nil if
+// path does not belong to any known artifact class.
+func classify(path string) *artifactClass {
+	for idx, c := range cachePolicy {
+		if c.re.MatchString(path) {
+			return &cachePolicy[idx]
+		}
+	}
+	return nil
+}
+
+// misclassified holds the artifact classes for which recordArtifact
+// already reported a mismatch, so that each is only reported once.
+var misclassified = struct {
+	sync.Mutex
+	classes map[string]bool
+}{classes: make(map[string]bool)}
+
+// recordArtifact is called by the code writing dest, a file in
+// -serving_dir, which knows dest to be an artifact of class. If the
+// patterns of cachePolicy disagree, operators would serve dest with the
+// wrong Cache-Control header, which is reported as a bug.
+func recordArtifact(class, dest string) {
+	rel, err := filepath.Rel(*servingDir, dest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return // not served
+	}
+	path := "/" + filepath.ToSlash(strings.TrimSuffix(rel, ".gz"))
+	var got string
+	if c := classify(path); c != nil {
+		got = c.Class
+	}
+	if got == class {
+		return
+	}
+	misclassified.Lock()
+	defer misclassified.Unlock()
+	if misclassified.classes[class] {
+		return
+	}
+	misclassified.classes[class] = true
+	errorf("BUG: %q is an artifact of class %q, but the cache policy classifies it as %q", path, class, got)
+}
+
+// writeCachePolicy writes cachePolicy to cache-policy.json in
+// destDir, so that operators can generate web server or CDN
+// configuration from it.
+func writeCachePolicy(destDir string) error {
+	for _, c := range cachePolicy {
+		// Operators rely on the first matching pattern being the
+		// right one, see cachePolicy.
+		if got := classify(c.Example); got == nil || got.Class != c.Class {
+			return fmt.Errorf("BUG: cache policy example %q of class %q is not classified as such", c.Example, c.Class)
+		}
+	}
+	return writeAtomically(filepath.Join(destDir, "cache-policy.json"), false, func(w io.Writer) error {
+		b, err := json.MarshalIndent(cachePolicy, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	})
+}
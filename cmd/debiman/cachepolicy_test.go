@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"/jessie/i3-wm/i3.1.en.html", "manpage"},
+		{"/jessie/i3-wm/index.html", "index"},
+		{"/contents-jessie.html", "index"},
+		{"/jessie/sitemap.xml", "sitemap"},
+		{"/sitemapindex.xml", "sitemap"},
+		{"/sitemapindex-debian.xml", "sitemap"},
+		{"/jessie/i3-wm/i3.1.en.diff-wheezy.html", "diff"},
+		{"/az-jessie-i.html", "index"},
+		{"/section-jessie-1.html", "index"},
+		{"/lang-jessie-de.html", "index"},
+		{"/disambiguation/jessie/i3.html", "index"},
+		{"/feed-jessie.atom", "data"},
+		{"/whatis-jessie.json", "data"},
+		{"/whatis-jessie.txt", "data"},
+		{"/search-jessie.gob", "data"},
+		{"/search-index/jessie/i.json", "data"},
+		{"/Inconsolata.woff2", "static"},
+		{"/Roboto-Bold.woff", "static"},
+		{"/jessie/i3-wm/i3.1.en.txt", "manpage"},
+		{"/jessie/i3-wm/i3.1.en.pdf", "manpage"},
+		{"/jessie/i3-wm/i3.1.en.roff.gz", "manpage"},
+		{"/jessie/i3-wm/i3.1.en.md", "manpage"},
+		{"/jessie/i3-wm/i3.1.en.json", "manpage"},
+		{"/jessie/i3-wm/i3.1.en.assets/diagram.png", "manpage"},
+		{"/jessie/i3-wm/i3-wm.epub", "epub"},
+		{"/info/jessie/index.html", "info"},
+		{"/info/jessie/coreutils/coreutils.html", "info"},
+		{"/lint-jessie.json", "data"},
+		{"/references-jessie.json", "data"},
+		{"/index.html", "index"},
+		{"/about.html", "index"},
+		{"/faq.html", "index"},
+		{"/style.css", ""},
+	} {
+		var got string
+		if c := classify(tt.path); c != nil {
+			got = c.Class
+		}
+		if got != tt.want {
+			t.Errorf("classify(%q): got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCachePolicyExamples(t *testing.T) {
+	for _, c := range cachePolicy {
+		if got := classify(c.Example); got == nil || got.Class != c.Class {
+			t.Errorf("example %q of class %q misclassified as %v", c.Example, c.Class, got)
+		}
+	}
+}
+
+func TestRecordArtifact(t *testing.T) {
+	oldServingDir := *servingDir
+	*servingDir = "/srv/man"
+	defer func() { *servingDir = oldServingDir }()
+
+	defer func(old *warningSummary) { logSummary = old }(logSummary)
+	logSummary = &warningSummary{}
+
+	recordArtifact(artifactManpage, "/srv/man/jessie/i3-wm/i3.1.en.html.gz")
+	recordArtifact(artifactIndex, "/tmp/i3.1.en.html.gz") // not served
+	if got := logSummary.levelCount(levelError); got != 0 {
+		t.Fatalf("correctly classified artifacts reported as bugs")
+	}
+	recordArtifact(artifactIndex, "/srv/man/jessie/i3-wm/i3.1.en.html.gz")
+	recordArtifact(artifactIndex, "/srv/man/jessie/i3-wm/i3.1.en.html.gz")
+	if got, want := logSummary.levelCount(levelError), 1; got != want {
+		t.Fatalf("misclassified artifact reported %d times, want once", got)
+	}
+}
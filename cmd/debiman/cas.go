@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var contentAddressed = flag.Bool("content_addressed",
+	false,
+	"Store each distinct rendered file once in <serving_dir>/.pool (named by the SHA-256 of its contents) and hard link it into the <suite>/<binarypkg> tree. Pool files which are no longer linked are removed at the end of each run. Reduces disk usage for sites with many suites.")
+
+// poolDir returns the directory in which content-addressed files are
+// stored.
+func poolDir() string {
+	return filepath.Join(*servingDir, ".pool")
+}
+
+// isRenderedArtifact returns whether dest is a file in a binary package
+// directory which debiman renders (as opposed to e.g. extracted
+// manpages, which are always gzip-compressed).
+func isRenderedArtifact(dest string) bool {
+	rel, err := filepath.Rel(*servingDir, dest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if len(strings.Split(rel, string(filepath.Separator))) != 3 {
+		return false // e.g. aux/ files, contents-<suite>.html.gz
+	}
+	fn := filepath.Base(rel)
+	return !strings.HasSuffix(fn, ".gz") || isRenderedOutput(fn)
+}
+
+// commitFile moves the temporary file tmp to dest. With
+// -content_addressed, rendered artifacts are moved into the pool (or
+// removed if the pool already contains an identical file) and dest
+// becomes a hard link to the pool file.
+func commitFile(tmp, dest string) error {
+	if !*contentAddressed || !isRenderedArtifact(dest) {
+		return os.Rename(tmp, dest)
+	}
+	h, err := fileHash(tmp)
+	if err != nil {
+		return err
+	}
+	hash := hex.EncodeToString([]byte(h))
+	pooled := filepath.Join(poolDir(), hash[:2], hash)
+	if err := os.MkdirAll(filepath.Dir(pooled), 0755); err != nil {
+		return err
+	}
+	// os.Link (unlike os.Rename) fails if pooled already exists, so
+	// concurrent writers of identical files agree on the pool file.
+	if err := os.Link(tmp, pooled); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// The pool file is shared with other rendered files, whose
+		// rendering invariants (rendered files are newer than their
+		// manpages) are kept by moving its modification time forward.
+		now := time.Now()
+		if err := os.Chtimes(pooled, now, now); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+	return replaceWithLink(pooled, dest)
+}
+
+// gcPool removes all files from the pool which are no longer linked
+// into the <suite>/<binarypkg> tree, e.g. because the manpage was
+// re-rendered or its package was deleted.
+func gcPool() error {
+	prefixes, err := ioutil.ReadDir(poolDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var removed int
+	for _, prefix := range prefixes {
+		dir := filepath.Join(poolDir(), prefix.Name())
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			nlink, ok := linkCount(fi)
+			if !ok {
+				return fmt.Errorf("-content_addressed: link counts are not supported on this platform")
+			}
+			if nlink > 1 {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+			removed++
+		}
+	}
+	infof("Removed %d unreferenced files from %q", removed, poolDir())
+	return nil
+}
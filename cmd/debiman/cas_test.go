@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentAddressed(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-cas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	*contentAddressed = true
+	defer func() {
+		*servingDir = oldServingDir
+		*contentAddressed = false
+	}()
+
+	for _, tt := range []struct {
+		path string
+		want bool
+	}{
+		{"jessie/i3-wm/i3.1.en.html.gz", true},
+		{"jessie/i3-wm/i3.1.en.txt.gz", true},
+		{"jessie/i3-wm/i3.1.en.html.br", true},
+		{"jessie/i3-wm/i3.1.en.gz", false},
+		{"jessie/i3-wm/aux/usr/share/man/man7/common.7.gz", false},
+		{"jessie/sitemap.xml.gz", false},
+	} {
+		if got := isRenderedArtifact(filepath.Join(tmpdir, tt.path)); got != tt.want {
+			t.Errorf("isRenderedArtifact(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	write := func(path, content string) string {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeAtomically(path, true, func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	jessie := write("jessie/i3-wm/i3.1.en.html.gz", "<html></html>")
+	stretch := write("stretch/i3-wm/i3.1.en.html.gz", "<html></html>")
+
+	jessiest, err := os.Stat(jessie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stretchst, err := os.Stat(stretch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(jessiest, stretchst) {
+		t.Fatalf("identical files %q and %q not stored once", jessie, stretch)
+	}
+	if nlink, ok := linkCount(stretchst); ok && nlink != 3 {
+		t.Fatalf("unexpected link count: got %d, want 3 (pool, jessie, stretch)", nlink)
+	}
+
+	// Re-rendering one of the files must not affect the other.
+	write("stretch/i3-wm/i3.1.en.html.gz", "<html>updated</html>")
+	b, err := ioutil.ReadFile(jessie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(b), int(jessiest.Size()); got != want {
+		t.Fatalf("%q modified by re-rendering %q", jessie, stretch)
+	}
+
+	if _, ok := linkCount(stretchst); !ok {
+		t.Skip("link counts not supported on this platform")
+	}
+
+	pooled := func() int {
+		var n int
+		if err := filepath.Walk(poolDir(), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				n++
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if got, want := pooled(), 2; got != want {
+		t.Fatalf("unexpected number of pool files: got %d, want %d", got, want)
+	}
+	if err := os.Remove(jessie); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcPool(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pooled(), 1; got != want {
+		t.Fatalf("unexpected number of pool files after gc: got %d, want %d", got, want)
+	}
+}
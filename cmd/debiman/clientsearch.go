@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+var writeClientSearchIndex = flag.Bool("client_search_index",
+	false,
+	"Write a compact index of the names and NAME descriptions of the English manpages of each suite for in-browser typeahead search to search-index/<suite>/, sharded by the first letter of the name (see search-index/<suite>/index.json). Requires -whatis.")
+
+// clientSearchDir returns the path (relative to -serving_dir) of the
+// client-side search index of suite.
+func clientSearchDir(suite string) string {
+	return filepath.Join("search-index", suite)
+}
+
+// clientSearchManifest is the index.json of a client-side search index.
+type clientSearchManifest struct {
+	// Shards maps the first letter of the names (see azLetter) to the
+	// file name of the shard containing them.
+	Shards map[string]string `json:"shards"`
+
+	// Fields names the elements of each entry of a shard.
+	Fields []string `json:"fields"`
+
+	// Entries is the total number of entries of all shards.
+	Entries int `json:"entries"`
+}
+
+// clientSearchEntry is an entry of a shard of the client-side search
+// index: name, section, binary package and description. The manpage
+// is located at /<suite>/<binarypkg>/<name>.<section>.en.html.
+type clientSearchEntry [4]string
+
+type byClientSearchEntry []clientSearchEntry
+
+func (p byClientSearchEntry) Len() int      { return len(p) }
+func (p byClientSearchEntry) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byClientSearchEntry) Less(i, j int) bool {
+	if p[i][0] != p[j][0] {
+		return p[i][0] < p[j][0]
+	}
+	return p[i][1] < p[j][1]
+}
+
+// clientSearchEntries returns the entries of the client-side search
+// index for the whatis entries of a suite, keyed by shard. Of multiple
+// binary packages shipping a manpage, only the first one in the popcon
+// order (see -popcon) is included.
+func clientSearchEntries(entries []whatis.Entry) map[string][]clientSearchEntry {
+	best := make(map[string]whatis.Entry)
+	for _, e := range entries {
+		if e.Language != "en" {
+			continue
+		}
+		// The name of the manpage itself, as opposed to the names
+		// listed in its NAME section, e.g. gunzip for gzip(1).
+		base := e.ServingPath[strings.LastIndex(e.ServingPath, "/")+1:]
+		name := strings.TrimSuffix(base, "."+e.Section+"."+e.Language)
+		key := name + "." + e.Section
+		if b, ok := best[key]; ok && !popconLess(e.Binarypkg, b.Binarypkg) {
+			continue
+		}
+		best[key] = e
+	}
+	shards := make(map[string][]clientSearchEntry)
+	for key, e := range best {
+		name := strings.TrimSuffix(key, "."+e.Section)
+		letter := azLetter(name)
+		shards[letter] = append(shards[letter], clientSearchEntry{name, e.Section, e.Binarypkg, e.Description})
+	}
+	for _, shard := range shards {
+		sort.Sort(byClientSearchEntry(shard))
+	}
+	return shards
+}
+
+// writeClientSearch writes the client-side search index of each suite
+// of gv, based on the whatis databases in destDir.
+func writeClientSearch(destDir string, gv globalView) error {
+	for suite := range gv.suites {
+		entries, err := whatis.Load(whatisPath(destDir, suite))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		dir := filepath.Join(destDir, clientSearchDir(suite))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		manifest := clientSearchManifest{
+			Shards: make(map[string]string),
+			Fields: []string{"name", "section", "binarypkg", "description"},
+		}
+		shards := clientSearchEntries(entries)
+		for _, letter := range azLetters {
+			path := filepath.Join(dir, letter+".json")
+			shard, ok := shards[letter]
+			if !ok {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				continue
+			}
+			recordArtifact(artifactData, path)
+			if err := writeAtomically(path, false, func(w io.Writer) error {
+				return json.NewEncoder(w).Encode(shard)
+			}); err != nil {
+				return err
+			}
+			manifest.Shards[letter] = letter + ".json"
+			manifest.Entries += len(shard)
+		}
+		manifestPath := filepath.Join(dir, "index.json")
+		recordArtifact(artifactData, manifestPath)
+		if err := writeAtomically(manifestPath, false, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(manifest)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+func TestWriteClientSearch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	entries := []whatis.Entry{
+		{Names: []string{"i3"}, Section: "1", Language: "en", Binarypkg: "i3-wm", Description: "an improved dynamic, tiling window manager", ServingPath: "jessie/i3-wm/i3.1.en"},
+		{Names: []string{"i3"}, Section: "1", Language: "de", Binarypkg: "i3-wm", Description: "ein verbesserter Fenstermanager", ServingPath: "jessie/i3-wm/i3.1.de"},
+		{Names: []string{"crontab"}, Section: "5", Language: "en", Binarypkg: "systemd-cron", Description: "tables for driving systemd-cron", ServingPath: "jessie/systemd-cron/crontab.5.en"},
+		{Names: []string{"crontab"}, Section: "5", Language: "en", Binarypkg: "cron", Description: "tables for driving cron", ServingPath: "jessie/cron/crontab.5.en"},
+		{Names: []string{"gzip", "gunzip"}, Section: "1", Language: "en", Binarypkg: "gzip", Description: "compress or expand files", ServingPath: "jessie/gzip/gunzip.1.en"},
+		{Names: []string{"[", "test"}, Section: "1", Language: "en", Binarypkg: "coreutils", Description: "check file types and compare values", ServingPath: "jessie/coreutils/[.1.en"},
+	}
+	if err := writeAtomically(whatisPath(tmpdir, "jessie"), false, func(w io.Writer) error {
+		return whatis.WriteJSON(w, entries)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A shard of a previous run which no longer has any entries.
+	dir := filepath.Join(tmpdir, clientSearchDir("jessie"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "z.json"), []byte("[]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gv := newGlobalView(1, time.Now())
+	gv.suites["jessie"] = true
+	gv.suites["stretch"] = true // no whatis database
+	if err := writeClientSearch(tmpdir, gv); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest clientSearchManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := manifest.Shards, map[string]string{"c": "c.json", "g": "g.json", "i": "i.json", "other": "other.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected shards: got %v, want %v", got, want)
+	}
+	if got, want := manifest.Entries, 4; got != want {
+		t.Errorf("got %d entries, want %d", got, want)
+	}
+
+	// Without popcon data, packages are ordered by name.
+	b, err = ioutil.ReadFile(filepath.Join(dir, "c.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shard []clientSearchEntry
+	if err := json.Unmarshal(b, &shard); err != nil {
+		t.Fatal(err)
+	}
+	if want := []clientSearchEntry{{"crontab", "5", "cron", "tables for driving cron"}}; !reflect.DeepEqual(shard, want) {
+		t.Errorf("unexpected shard c: got %q, want %q", shard, want)
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "g.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard = nil
+	if err := json.Unmarshal(b, &shard); err != nil {
+		t.Fatal(err)
+	}
+	if want := []clientSearchEntry{{"gunzip", "1", "gzip", "compress or expand files"}}; !reflect.DeepEqual(shard, want) {
+		t.Errorf("unexpected shard g: got %q, want %q", shard, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "z.json")); !os.IsNotExist(err) {
+		t.Errorf("stale shard z.json was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, clientSearchDir("stretch"))); !os.IsNotExist(err) {
+		t.Errorf("search index written for a suite without whatis database: %v", err)
+	}
+}
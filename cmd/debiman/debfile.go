@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// debFile is a .deb package opened using loadDeb.
+type debFile struct {
+	// Control contains the fields of the package’s control file, e.g.
+	// Package, Version and Replaces.
+	Control map[string]string
+
+	// Data iterates over the files of the package.
+	Data *tar.Reader
+
+	close func()
+}
+
+// Close releases the resources associated with d.
+func (d *debFile) Close() {
+	if d.close != nil {
+		d.close()
+	}
+}
+
+// arMagic is the global header of ar(5) archives such as .deb files.
+const arMagic = "!<arch>\n"
+
+// arHeaderSize is the size of an ar(5) member header.
+const arHeaderSize = 60
+
+// readArHeader reads the header of the next member of an ar(5) archive
+// from r, returning the member’s name and size.
+func readArHeader(r io.Reader) (string, int64, error) {
+	var buf [arHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", 0, err
+	}
+	if !bytes.Equal(buf[58:60], []byte("`\n")) {
+		return "", 0, fmt.Errorf("invalid ar member header %q", buf[:])
+	}
+	// GNU ar terminates names with a slash.
+	name := strings.TrimSuffix(strings.TrimSpace(string(buf[:16])), "/")
+	size, err := strconv.ParseInt(strings.TrimSpace(string(buf[48:58])), 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("ar member %q: invalid size: %v", name, err)
+	}
+	return name, size, nil
+}
+
+// parseDebControl parses the control file r of a binary package. The
+// values of multi-line fields (e.g. Description) are joined by
+// newlines.
+func parseDebControl(r io.Reader) (map[string]string, error) {
+	result := make(map[string]string)
+	var key string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			break // a control file contains only one paragraph
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if key != "" {
+				result[key] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx == -1 {
+			return nil, fmt.Errorf("invalid control file line %q", line)
+		}
+		key = line[:idx]
+		result[key] = strings.TrimSpace(line[idx+1:])
+	}
+	return result, scanner.Err()
+}
+
+// readDebControl returns the fields of the control file contained in
+// the control.tar member r.
+func readDebControl(r io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("control.tar does not contain a control file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(header.Name, "./") == "control" {
+			return parseDebControl(tr)
+		}
+	}
+}
+
+// loadDeb reads the .deb package r up to its data.tar member, whose
+// files can then be read from the Data field of the returned debFile.
+// The control.tar and data.tar members may be compressed using gzip,
+// xz, zstd (as created by newer dpkg versions) or bzip2, or be
+// uncompressed.
+func loadDeb(r io.Reader) (*debFile, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != arMagic {
+		return nil, fmt.Errorf("not an ar archive (magic %q)", magic)
+	}
+	var control map[string]string
+	for {
+		name, size, err := readArHeader(br)
+		if err == io.EOF {
+			return nil, fmt.Errorf("no data.tar member found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		member := io.LimitReader(br, size)
+		switch {
+		case strings.HasPrefix(name, "control.tar"):
+			rd, close, err := decompressByName(member, name)
+			if err != nil {
+				return nil, err
+			}
+			control, err = readDebControl(rd)
+			close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+
+		case strings.HasPrefix(name, "data.tar"):
+			if control == nil {
+				return nil, fmt.Errorf("no control.tar member found before %s", name)
+			}
+			rd, close, err := decompressByName(member, name)
+			if err != nil {
+				return nil, err
+			}
+			return &debFile{
+				Control: control,
+				Data:    tar.NewReader(rd),
+				close:   close,
+			}, nil
+		}
+		if _, err := io.Copy(ioutil.Discard, member); err != nil {
+			return nil, err
+		}
+		// Members are padded to an even size.
+		if _, err := io.CopyN(ioutil.Discard, br, size%2); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeAr writes an ar(5) archive containing members (in the order of
+// names) to w.
+func writeAr(t *testing.T, w io.Writer, names []string, members map[string][]byte) {
+	if _, err := io.WriteString(w, arMagic); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		content := members[name]
+		header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name+"/", 0, 0, 0, 0644, len(content))
+		if _, err := io.WriteString(w, header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		if len(content)%2 == 1 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// tarFile returns a tar archive containing the files (in the order of
+// names), compressed according to the extension ext (e.g. .gz).
+func tarFile(t *testing.T, ext string, names []string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	var (
+		w     io.Writer = &buf
+		close           = func() error { return nil }
+	)
+	switch ext {
+	case ".gz":
+		gw := gzip.NewWriter(&buf)
+		w, close = gw, gw.Close
+	case ".zst":
+		enc, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, close = enc, enc.Close
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const testDebControl = `Package: i3-wm
+Source: i3-wm (4.13-1)
+Version: 4.13-1
+Architecture: amd64
+Replaces: i3bar (<< 4.0)
+Description: improved dynamic tiling window manager
+ Key features of i3 are good documentation,
+ reasonable defaults.
+`
+
+func TestLoadDeb(t *testing.T) {
+	for _, ext := range []string{".gz", ".zst", ""} {
+		ext := ext // copy
+		t.Run("data.tar"+ext, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeAr(t, &buf, []string{"debian-binary", "control.tar" + ext, "data.tar" + ext}, map[string][]byte{
+				"debian-binary": []byte("2.0\n"),
+				"control.tar" + ext: tarFile(t, ext, []string{"./md5sums", "./control"}, map[string]string{
+					"./md5sums": "",
+					"./control": testDebControl,
+				}),
+				"data.tar" + ext: tarFile(t, ext, []string{"./usr/share/man/man1/i3.1.gz"}, map[string]string{
+					"./usr/share/man/man1/i3.1.gz": "i3(1)",
+				}),
+			})
+			d, err := loadDeb(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if got, want := d.Control["Package"], "i3-wm"; got != want {
+				t.Errorf("Package: got %q, want %q", got, want)
+			}
+			if got, want := d.Control["Replaces"], "i3bar (<< 4.0)"; got != want {
+				t.Errorf("Replaces: got %q, want %q", got, want)
+			}
+			if got, want := d.Control["Description"], "improved dynamic tiling window manager\nKey features of i3 are good documentation,\nreasonable defaults."; got != want {
+				t.Errorf("Description: got %q, want %q", got, want)
+			}
+			header, err := d.Data.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(d.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := []string{header.Name, string(b)}, []string{"./usr/share/man/man1/i3.1.gz", "i3(1)"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("data.tar: got %q, want %q", got, want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	writeAr(t, &buf, []string{"debian-binary", "data.tar.lz"}, map[string][]byte{
+		"debian-binary": []byte("2.0\n"),
+		"data.tar.lz":   nil,
+	})
+	if _, err := loadDeb(&buf); err == nil {
+		t.Fatalf("loadDeb unexpectedly succeeded without control.tar")
+	}
+}
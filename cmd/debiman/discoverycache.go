@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/version"
+)
+
+// discoveryCacheFormat is part of the key of each cached index file and
+// must be incremented whenever the format of cachedContents or
+// cachedPackages changes.
+const discoveryCacheFormat = 3
+
+// cachedContent is the persisted form of a contentEntry of a Contents
+// file, i.e. before the Contents files of all architectures are merged.
+type cachedContent struct {
+	Binarypkg string
+	Filename  string
+	Info      bool
+}
+
+// cachedContents is the result of parsing a Contents file: the entries
+// of each line (see parseContentsEntry), in the order of the file.
+type cachedContents struct {
+	Lines [][]cachedContent
+}
+
+// cachedPkg is the persisted form of a pkgEntry of a Packages file.
+type cachedPkg struct {
+	Source    string
+	Binarypkg string
+	Filename  string
+	Version   version.Version
+	SHA256    []byte
+	Bytes     int64
+	Replaces  []string
+}
+
+// cachedPackages is the result of parsing a Packages file, in the order
+// of the file.
+type cachedPackages struct {
+	Pkgs []cachedPkg
+}
+
+// discoveryCache persists the results of parsing the Contents and Packages
+// files of a suite between runs (see -persist_global_view), one file
+// per index file, keyed by the SHA256 hash of the index file. Only
+// index files which changed since the previous run are downloaded and
+// parsed; the results of all index files are then merged as usual.
+//
+// The cross-reference index (xref) is not persisted: it is built from
+// the merged results on every run, which is cheap compared to parsing
+// the index files.
+//
+// A nil *discoveryCache caches nothing.
+type discoveryCache struct {
+	dir string
+
+	mu   sync.Mutex
+	used map[string]bool // names of the cache files used in this run
+}
+
+// newDiscoveryCache returns the discoveryCache of suite, or nil unless
+// -persist_global_view is set.
+func newDiscoveryCache(suite string) *discoveryCache {
+	if !*persistGlobalView {
+		return nil
+	}
+	return &discoveryCache{
+		dir:  filepath.Join(*servingDir, ".globalview", suite),
+		used: make(map[string]bool),
+	}
+}
+
+// indexHash returns the SHA256 hash of the index file path (e.g.
+// main/Contents-amd64.gz) as listed in the Release file, or "" if path
+// is not listed.
+func indexHash(hashByFilename map[string]*control.SHA256FileHash, path string) string {
+	if fh, ok := hashByFilename[path]; ok {
+		return fh.Hash
+	}
+	return ""
+}
+
+// discoveryCacheKey returns the key under which the result of parsing an
+// index file of the specified kind (e.g. “contents”) is cached. hashes
+// are the SHA256 hashes of the index files from which the result is
+// derived. -render_info is part of the key, as it affects which files
+// are parsed from the Contents files.
+func discoveryCacheKey(kind string, hashes ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%v\n", discoveryCacheFormat, kind, *renderInfo)
+	for _, hash := range hashes {
+		fmt.Fprintln(h, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *discoveryCache) path(key string) string {
+	return filepath.Join(c.dir, key+".gob")
+}
+
+// load decodes the result cached under key into v and returns true, or
+// returns false if no such result is cached.
+func (c *discoveryCache) load(key string, v interface{}) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	c.used[key+".gob"] = true
+	c.mu.Unlock()
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warningf("loading cached index: %v", err)
+		}
+		return false
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(v); err != nil {
+		warningf("loading cached index: %q: %v", c.path(key), err)
+		return false
+	}
+	return true
+}
+
+// store caches v under key. Errors are only logged: the next run parses
+// the index file again.
+func (c *discoveryCache) store(key string, v interface{}) {
+	if c == nil || *dryRun {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		warningf("caching index: %v", err)
+		return
+	}
+	if err := writeAtomically(c.path(key), false, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(v)
+	}); err != nil {
+		warningf("caching index: %v", err)
+	}
+}
+
+// prune removes the cached results of index files which were not used
+// in this run, i.e. of index files which changed or were removed.
+func (c *discoveryCache) prune() error {
+	if c == nil || *dryRun {
+		return nil
+	}
+	// Previous versions persisted each suite as a single file.
+	if err := os.Remove(c.dir + ".gob"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range fis {
+		if c.used[fi.Name()] || !strings.HasSuffix(fi.Name(), ".gob") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadContents returns the Contents file lines cached under key.
+func (c *discoveryCache) loadContents(key string) ([][]*contentEntry, bool) {
+	var cc cachedContents
+	if !c.load(key, &cc) {
+		return nil, false
+	}
+	lines := make([][]*contentEntry, len(cc.Lines))
+	for idx, line := range cc.Lines {
+		lines[idx] = make([]*contentEntry, len(line))
+		for eidx, e := range line {
+			lines[idx][eidx] = &contentEntry{
+				binarypkg: e.Binarypkg,
+				filename:  e.Filename,
+				info:      e.Info,
+			}
+		}
+	}
+	return lines, true
+}
+
+// storeContents caches the Contents file lines under key.
+func (c *discoveryCache) storeContents(key string, lines [][]*contentEntry) {
+	if c == nil {
+		return
+	}
+	cc := cachedContents{Lines: make([][]cachedContent, len(lines))}
+	for idx, line := range lines {
+		cc.Lines[idx] = make([]cachedContent, len(line))
+		for eidx, e := range line {
+			cc.Lines[idx][eidx] = cachedContent{
+				Binarypkg: e.binarypkg,
+				Filename:  e.filename,
+				Info:      e.info,
+			}
+		}
+	}
+	c.store(key, &cc)
+}
+
+// loadPackages returns the Packages file entries cached under key.
+func (c *discoveryCache) loadPackages(key string) ([]pkgEntry, bool) {
+	var cp cachedPackages
+	if !c.load(key, &cp) {
+		return nil, false
+	}
+	pkgs := make([]pkgEntry, len(cp.Pkgs))
+	for idx, p := range cp.Pkgs {
+		pkgs[idx] = pkgEntry{
+			source:    p.Source,
+			binarypkg: p.Binarypkg,
+			filename:  p.Filename,
+			version:   p.Version,
+			sha256:    p.SHA256,
+			bytes:     p.Bytes,
+			replaces:  p.Replaces,
+		}
+	}
+	return pkgs, true
+}
+
+// storePackages caches the Packages file entries under key.
+func (c *discoveryCache) storePackages(key string, pkgs []pkgEntry) {
+	if c == nil {
+		return
+	}
+	cp := cachedPackages{Pkgs: make([]cachedPkg, len(pkgs))}
+	for idx, p := range pkgs {
+		cp.Pkgs[idx] = cachedPkg{
+			Source:    p.source,
+			Binarypkg: p.binarypkg,
+			Filename:  p.filename,
+			Version:   p.version,
+			SHA256:    p.sha256,
+			Bytes:     p.bytes,
+			Replaces:  p.replaces,
+		}
+	}
+	c.store(key, &cp)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/version"
+)
+
+func TestDiscoveryCacheKey(t *testing.T) {
+	key := discoveryCacheKey("packages", "01", "23")
+	if got := discoveryCacheKey("packages", "01", "23"); got != key {
+		t.Fatalf("key unexpectedly changed")
+	}
+	for _, other := range []string{
+		discoveryCacheKey("packages", "01", "45"),
+		discoveryCacheKey("contents", "01", "23"),
+	} {
+		if other == key {
+			t.Fatalf("key unexpectedly unchanged")
+		}
+	}
+
+	old := *renderInfo
+	*renderInfo = !old
+	defer func() { *renderInfo = old }()
+	if got := discoveryCacheKey("packages", "01", "23"); got == key {
+		t.Fatalf("key unexpectedly unchanged after toggling -render_info")
+	}
+}
+
+// newTestDiscoveryCache returns the discoveryCache of the testing suite in a new
+// temporary -serving_dir.
+func newTestDiscoveryCache(t *testing.T) (*discoveryCache, func()) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	oldPersist := *persistGlobalView
+	*persistGlobalView = true
+	return newDiscoveryCache("testing"), func() {
+		*servingDir = oldServingDir
+		*persistGlobalView = oldPersist
+		os.RemoveAll(tmpdir)
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	cache, cleanup := newTestDiscoveryCache(t)
+	defer cleanup()
+
+	if _, ok := cache.loadContents("contents"); ok {
+		t.Fatalf("loadContents unexpectedly succeeded without a cached result")
+	}
+
+	lines := [][]*contentEntry{
+		{{binarypkg: "coreutils", filename: "coreutils.info.gz", info: true}},
+		{{binarypkg: "i3-wm", filename: "man1/i3.1.gz"}, {binarypkg: "i3", filename: "man1/i3.1.gz"}},
+	}
+	cache.storeContents("contents", lines)
+	gotLines, ok := cache.loadContents("contents")
+	if !ok {
+		t.Fatalf("loadContents unexpectedly failed")
+	}
+	if !reflect.DeepEqual(gotLines, lines) {
+		t.Fatalf("contents: got %+v, want %+v", gotLines, lines)
+	}
+
+	pkgs := []pkgEntry{
+		{
+			source:    "i3-wm",
+			binarypkg: "i3-wm",
+			filename:  "pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb",
+			version:   version.Version{Version: "4.13", Revision: "1"},
+			sha256:    []byte{0x01, 0x23},
+			bytes:     1024,
+			replaces:  []string{"i3"},
+		},
+	}
+	cache.storePackages("packages", pkgs)
+	gotPkgs, ok := cache.loadPackages("packages")
+	if !ok {
+		t.Fatalf("loadPackages unexpectedly failed")
+	}
+	if !reflect.DeepEqual(gotPkgs, pkgs) {
+		t.Fatalf("packages: got %+v, want %+v", gotPkgs, pkgs)
+	}
+
+	// A new run only uses the packages, so the contents are pruned.
+	cache = newDiscoveryCache("testing")
+	if _, ok := cache.loadPackages("packages"); !ok {
+		t.Fatalf("loadPackages unexpectedly failed")
+	}
+	if err := cache.prune(); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if want := []string{"packages.gob"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("after pruning: got %v, want %v", names, want)
+	}
+}
+
+func TestGetContentsCached(t *testing.T) {
+	cache, cleanup := newTestDiscoveryCache(t)
+	defer cleanup()
+
+	hashByFilename := make(map[string]*control.SHA256FileHash)
+	for arch, hash := range map[string]string{"amd64": "01", "i386": "23"} {
+		path := contentsPath("main", arch)
+		hashByFilename[path] = &control.SHA256FileHash{
+			FileHash: control.FileHash{Filename: path, Hash: hash},
+		}
+	}
+	cache.storeContents(discoveryCacheKey("contents", "01"), [][]*contentEntry{
+		{{binarypkg: "i3-wm", filename: "man1/i3.1.gz"}},
+		{{binarypkg: "i3lock", filename: "man1/i3lock.1.gz"}},
+	})
+	cache.storeContents(discoveryCacheKey("contents", "23"), [][]*contentEntry{
+		{{binarypkg: "i3-wm", filename: "man1/i3.1.gz"}},
+		{{binarypkg: "i3status", filename: "man1/i3status.1.gz"}},
+	})
+
+	// Cached Contents files are neither downloaded nor parsed, so no
+	// archive.Getter is required.
+	entries, contains, err := getContents(nil, "testing", "main", []string{"amd64", "i386"}, hashByFilename, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each file belongs to a single package, so the order of entries
+	// is that of the Contents files.
+	var got []contentEntry
+	for _, e := range entries {
+		got = append(got, *e)
+	}
+	want := []contentEntry{
+		{suite: "testing", arch: "amd64", binarypkg: "i3-wm", filename: "man1/i3.1.gz"},
+		{suite: "testing", arch: "amd64", binarypkg: "i3lock", filename: "man1/i3lock.1.gz"},
+		{suite: "testing", arch: "i386", binarypkg: "i3status", filename: "man1/i3status.1.gz"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("getContents: got %+v, want %+v", got, want)
+	}
+	wantContains := []map[string]bool{
+		{"i3-wm": true, "i3lock": true},
+		{"i3-wm": true, "i3status": true},
+	}
+	if !reflect.DeepEqual(contains, wantContains) {
+		t.Fatalf("getContents: got contains %v, want %v", contains, wantContains)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -20,10 +19,10 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/Debian/debiman/internal/archive"
+	"github.com/Debian/debiman/internal/convert"
 	"github.com/Debian/debiman/internal/manpage"
 	"github.com/Debian/debiman/internal/recode"
 
-	"pault.ag/go/debian/deb"
 	"pault.ag/go/debian/version"
 )
 
@@ -37,7 +36,7 @@ func canSkip(p pkgEntry, vPath string) bool {
 
 	vCurrent, err := version.Parse(string(v))
 	if err != nil {
-		log.Printf("Warning: could not parse current package version from %q: %v", vPath, err)
+		warningf("could not parse current package version from %q: %v", vPath, err)
 		return false
 	}
 
@@ -50,10 +49,28 @@ func (p contentByBinarypkg) Len() int           { return len(p) }
 func (p contentByBinarypkg) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p contentByBinarypkg) Less(i, j int) bool { return p[i].binarypkg < p[j].binarypkg }
 
+// closestEntries returns the entries of c which are in the suite of p,
+// with the entry of p itself (if any) first, followed by the others
+// sorted by binary package name.
+func closestEntries(p pkgEntry, c []*contentEntry) []*contentEntry {
+	filtered := make([]*contentEntry, 0, len(c))
+	for _, e := range c {
+		if e.suite != p.suite {
+			continue
+		}
+		if e.binarypkg == p.binarypkg {
+			return []*contentEntry{e}
+		}
+		filtered = append(filtered, e)
+	}
+	sort.Sort(contentByBinarypkg(filtered))
+	return filtered
+}
+
 // findClosestFile returns a manpage struct for name, if name exists in the same suite.
 // TODO(stapelberg): resolve multiple matches: consider dependencies of src
-func findClosestFile(logger *log.Logger, p pkgEntry, src, name string, contentByPath map[string][]*contentEntry) string {
-	logger.Printf("findClosestFile(src=%q, name=%q)", src, name)
+func findClosestFile(logger *leveledLogger, p pkgEntry, src, name string, contentByPath map[string][]*contentEntry) string {
+	logger.debugf("findClosestFile(src=%q, name=%q)", src, name)
 	c, ok := contentByPath[strings.TrimPrefix(name, "/usr/share/man/")]
 	if !ok {
 		return ""
@@ -102,7 +119,7 @@ func findClosestFile(logger *log.Logger, p pkgEntry, src, name string, contentBy
 		Binarypkg: c[0].binarypkg,
 		Suite:     c[0].suite,
 	})
-	logger.Printf("parsing %q as man: %v", name, err)
+	logger.debugf("parsing %q as man: %v", name, err)
 	if err == nil {
 		return m.ServingPath() + ".gz"
 	}
@@ -110,7 +127,11 @@ func findClosestFile(logger *log.Logger, p pkgEntry, src, name string, contentBy
 	return ""
 }
 
-func findFile(logger *log.Logger, src, name string, contentByPath map[string][]*contentEntry) (string, string, bool) {
+// findFile resolves the .so reference name of the manpage src (of
+// package p) using the global contents index. Only files in the same
+// suite are considered, preferring files of p itself, so that .so
+// references to manpages of other binary packages are resolved, too.
+func findFile(logger *leveledLogger, p pkgEntry, src, name string, contentByPath map[string][]*contentEntry) (string, string, bool) {
 	// TODO(later): why is "/"+ in front of src necessary?
 	searchPath := []string{
 		"/" + filepath.Dir(src), // “.”
@@ -122,7 +143,7 @@ func findFile(logger *log.Logger, src, name string, contentByPath map[string][]*
 		"/" + filepath.Dir(src) + "/..",
 		"/usr/share/man",
 	}
-	logger.Printf("searching reference so=%q", name)
+	logger.debugf("searching reference so=%q", name)
 	for _, search := range searchPath {
 		var check string
 		if filepath.IsAbs(name) {
@@ -137,7 +158,12 @@ func findFile(logger *log.Logger, src, name string, contentByPath map[string][]*
 
 		c, ok := contentByPath[strings.TrimPrefix(check, "/usr/share/man/")]
 		if !ok {
-			log.Printf("%q does not exist", check)
+			debugf("%q does not exist", check)
+			continue
+		}
+		c = closestEntries(p, c)
+		if len(c) == 0 {
+			debugf("%q does not exist in suite %q", check, p.suite)
 			continue
 		}
 
@@ -145,34 +171,55 @@ func findFile(logger *log.Logger, src, name string, contentByPath map[string][]*
 			Binarypkg: c[0].binarypkg,
 			Suite:     c[0].suite,
 		})
-		logger.Printf("parsing %q as man: %v", check, err)
+		logger.debugf("parsing %q as man: %v", check, err)
 		if err == nil {
 			return m.ServingPath() + ".gz", "", true
 		}
 
-		// TODO: we currently use the first manpage we find. this is non-deterministic, so sort.
 		// TODO(later): try to resolve this reference intelligently, i.e. consider installability to narrow down the list of candidates. add a testcase with all cases that we have in all Debian suites currently
+		if c[0].binarypkg != p.binarypkg {
+			// Only files of p are extracted into its aux directory, so
+			// the file of the other package will not exist.
+			logger.debugf(".so referenced file %q is not a manpage and lives in package %q, which is not extracted", check, c[0].binarypkg)
+			continue
+		}
 		return c[0].suite + "/" + c[0].binarypkg + "/aux" + check, check, true
 	}
 	return name, "", false
 }
 
-func soElim(logger *log.Logger, src string, r io.Reader, w io.Writer, contentByPath map[string][]*contentEntry) ([]string, error) {
+func soElim(logger *leveledLogger, p pkgEntry, src string, r io.Reader, w io.Writer, contentByPath map[string][]*contentEntry) ([]string, error) {
 	var refs []string
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
+		if fields, file := convert.SplitPSPIC(line); file > -1 {
+			// Images are extracted into aux/ like .so references, so
+			// that they can be placed next to the rendered manpage.
+			img := fields[file]
+			if !filepath.IsAbs(img) {
+				img = filepath.Join(filepath.Dir(strings.TrimPrefix(src, ".")), img)
+			}
+			// Cleaning keeps the path within the file system of the
+			// package (e.g. /../../etc/passwd becomes /etc/passwd), so
+			// that it cannot leave aux/ when extracting or rendering.
+			img = filepath.Clean(img)
+			fields[file] = img
+			fmt.Fprintln(w, strings.Join(fields, " "))
+			refs = append(refs, img)
+			continue
+		}
 		if !strings.HasPrefix(line, ".so ") {
 			fmt.Fprintln(w, line)
 			continue
 		}
 		so := strings.TrimSpace(line[len(".so "):])
 
-		resolved, ref, ok := findFile(logger, src, so, contentByPath)
+		resolved, ref, ok := findFile(logger, p, src, so, contentByPath)
 		if !ok {
 			// Omitting .so lines which cannot be found is consistent
 			// with what man(1) and other online man viewers do.
-			logger.Printf("WARNING: could not find .so referenced file %q, omitting the .so line", so)
+			logger.warningf("could not find .so referenced file %q, omitting the .so line", so)
 			continue
 		}
 
@@ -184,7 +231,7 @@ func soElim(logger *log.Logger, src string, r io.Reader, w io.Writer, contentByP
 	return refs, scanner.Err()
 }
 
-func writeManpage(logger *log.Logger, src, dest string, r io.Reader, m *manpage.Meta, contentByPath map[string][]*contentEntry) ([]string, error) {
+func writeManpage(logger *leveledLogger, p pkgEntry, src, dest string, r io.Reader, m *manpage.Meta, contentByPath map[string][]*contentEntry) ([]string, error) {
 	var refs []string
 	content, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -198,22 +245,49 @@ func writeManpage(logger *log.Logger, src, dest string, r io.Reader, m *manpage.
 	}
 	err = writeAtomically(dest, true, func(w io.Writer) error {
 		var err error
-		refs, err = soElim(logger, src, bytes.NewReader(content), w, contentByPath)
+		refs, err = soElim(logger, p, src, bytes.NewReader(content), w, contentByPath)
 		return err
 	})
 	return refs, err
 }
 
-func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
+// upToDate returns true if p does not need to be downloaded and
+// extracted (again), recording it in manifest.
+func upToDate(p pkgEntry, manifest *downloadManifest) bool {
+	if *forceReextract {
+		return false
+	}
 	vPath := filepath.Join(*servingDir, p.suite, p.binarypkg, "VERSION")
+	if manifest.unchanged(p, vPath) {
+		return true
+	}
+	// Packages which are not in the manifest yet (e.g. after
+	// upgrading debiman) are compared by version.
+	if !manifest.has(p) && canSkip(p, vPath) {
+		manifest.record(p, true)
+		return true
+	}
+	return false
+}
 
-	if !*forceReextract && canSkip(p, vPath) {
+func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView, manifest *downloadManifest) error {
+	if upToDate(p, manifest) {
 		return nil
 	}
+	vPath := filepath.Join(*servingDir, p.suite, p.binarypkg, "VERSION")
 
-	logger := log.New(os.Stderr, p.suite+"/"+p.binarypkg+": ", log.LstdFlags)
+	logger := newPackageLogger(p)
+
+	if dir := filepath.Dir(vPath); isTombstone(dir) {
+		// The package was re-added to the archive after the gc
+		// command removed it.
+		logger.infof("removing tombstone %q", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
 
-	tmp, err := ar.Get(p.filename, p.sha256)
+	tmp, err := ar.GetPackage(p.filename, p.sha256)
 	if err != nil {
 		return err
 	}
@@ -224,12 +298,13 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 
 	allRefs := make(map[string]bool)
 
-	d, err := deb.Load(tmp, p.filename)
+	d, err := openPackage(tmp, p.filename)
 	if err != nil {
 		return fmt.Errorf("loading %q: %v", p.filename, err)
 	}
+	defer d.Close()
 	for {
-		header, err := d.Data.Next()
+		header, err := d.Next()
 		if err == io.EOF {
 			break
 		}
@@ -246,6 +321,14 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 		if header.FileInfo().IsDir() {
 			continue
 		}
+		if *renderInfo &&
+			(header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) &&
+			isInfoFile(header.Name) {
+			if err := extractInfo(p, header, d); err != nil {
+				return err
+			}
+			continue
+		}
 		if !strings.HasPrefix(header.Name, "./usr/share/man/") {
 			continue
 		}
@@ -262,7 +345,7 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 		})
 
 		if err != nil {
-			logger.Printf("WARNING: file name %q (underneath /usr/share/man) cannot be parsed: %v", header.Name, err)
+			logger.warningf("file name %q (underneath /usr/share/man) cannot be parsed: %v", header.Name, err)
 			continue
 		}
 
@@ -273,7 +356,7 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 				Suite:     p.suite,
 			})
 			if err != nil {
-				logger.Printf("WARNING: hard link name %q (underneath /usr/share/man) cannot be parsed: %v", header.Linkname, err)
+				logger.warningf("hard link name %q (underneath /usr/share/man) cannot be parsed: %v", header.Linkname, err)
 				continue
 			}
 			if err := os.Link(filepath.Join(*servingDir, d.ServingPath()+".gz"), m.ServingPath()+".gz"); err != nil {
@@ -298,14 +381,14 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 				// package, this will result in a dangling symlink.
 				allRefs[resolved] = true
 				destsp = filepath.Join(filepath.Dir(m.ServingPath()), "aux", resolved)
-				logger.Printf("WARNING: possibly dangling symlink %q -> %q", header.Name, header.Linkname)
+				logger.warningf("possibly dangling symlink %q -> %q", header.Name, header.Linkname)
 			}
 
 			// TODO(stapelberg): add a unit test for this entire function
 			// TODO(stapelberg): ganeti has an interesting twist: their manpages live outside of usr/share/man, and they only have symlinks. in this case, we should extract the file to aux/ and then mangle the symlink dest. problem: manpages actually are in a separate package (ganeti-2.15) and use an absolute symlink (/etc/ganeti/share), which is not shipped with the package.
 			rel, err := filepath.Rel(filepath.Dir(m.ServingPath()), destsp)
 			if err != nil {
-				logger.Printf("WARNING: %v", err)
+				logger.warningf("%v", err)
 				continue
 			}
 			if err := os.Symlink(rel, destPath); err != nil {
@@ -321,11 +404,11 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 			continue
 		}
 
-		r, err := gzip.NewReader(d.Data)
+		r, err := gzip.NewReader(d)
 		if err != nil {
 			return err
 		}
-		refs, err := writeManpage(logger, header.Name, destPath, r, m, gv.contentByPath)
+		refs, err := writeManpage(logger, p, header.Name, destPath, r, m, gv.contentByPath)
 		if err != nil {
 			return err
 		}
@@ -342,18 +425,19 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 	}
 
 	// Extract all non-manpage files which were referenced via .so
-	// statements, if any.
+	// statements or .PSPIC requests, if any.
 	if len(allRefs) > 0 {
 		if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
 			return err
 		}
 
-		d, err = deb.Load(tmp, p.filename)
+		d, err := openPackage(tmp, p.filename)
 		if err != nil {
 			return err
 		}
+		defer d.Close()
 		for {
-			header, err := d.Data.Next()
+			header, err := d.Next()
 			if err == io.EOF {
 				break
 			}
@@ -376,12 +460,12 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 			}
 
 			destPath := filepath.Join(*servingDir, p.suite, p.binarypkg, "aux", header.Name)
-			logger.Printf("extracting referenced non-manpage file %q to %q", header.Name, destPath)
+			logger.debugf("extracting referenced non-manpage file %q to %q", header.Name, destPath)
 			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 				return err
 			}
 			if err := writeAtomically(destPath, false, func(w io.Writer) error {
-				_, err := io.Copy(w, d.Data)
+				_, err := io.Copy(w, d)
 				return err
 			}); err != nil {
 				return err
@@ -394,26 +478,41 @@ func downloadPkg(ar *archive.Getter, p pkgEntry, gv globalView) error {
 			// If the directory does not exist, we did not extract any
 			// manpages. Since Contents files are not precise (they
 			// might lag behind), this can happen occasionally.
+			manifest.record(p, false)
 			return nil
 		}
 		return fmt.Errorf("Writing version file %q: %v", err)
 	}
+	manifest.record(p, true)
 
 	atomic.AddUint64(&gv.stats.PackagesExtracted, 1)
 
 	return nil
 }
 
-func parallelDownload(ar *archive.Getter, gv globalView) error {
+func parallelDownload(ar *archive.Getter, gv globalView) (err error) {
+	manifest, err := loadDownloadManifest(downloadManifestPath())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Keep the entries of packages which were processed even if
+		// others failed.
+		if werr := manifest.write(err == nil, gv.suites); err == nil {
+			err = werr
+		}
+	}()
+
 	eg, ctx := errgroup.WithContext(context.Background())
 	downloadChan := make(chan pkgEntry)
 	// TODO: flag for parallelism level
 	for i := 0; i < 10; i++ {
 		eg.Go(func() error {
 			for p := range downloadChan {
-				if err := downloadPkg(ar, p, gv); err != nil {
+				if err := downloadPkg(gv.getter(p.suite, ar), p, gv, manifest); err != nil {
 					return err
 				}
+				atomic.AddUint64(&progress.packagesProcessed, 1)
 			}
 			return nil
 		})
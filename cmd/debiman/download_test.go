@@ -26,6 +26,23 @@ func TestWriteManpage(t *testing.T) {
 			contentByPath: make(map[string][]*contentEntry),
 		},
 
+		{
+			src:      "/usr/share/man/man1/image.1",
+			manpage:  ".PSPIC diagram.png\n",
+			want:     ".PSPIC /usr/share/man/man1/diagram.png\n",
+			wantRefs: []string{"/usr/share/man/man1/diagram.png"},
+			pkg:      pkgEntry{},
+		},
+
+		{
+			// Paths are cleaned, so that they cannot leave aux/.
+			src:      "/usr/share/man/man1/traversal.1",
+			manpage:  ".PSPIC ../../../../../../etc/passwd\n.PSPIC /../../../../etc/shadow\n",
+			want:     ".PSPIC /etc/passwd\n.PSPIC /etc/shadow\n",
+			wantRefs: []string{"/etc/passwd", "/etc/shadow"},
+			pkg:      pkgEntry{},
+		},
+
 		{
 			src:           "/usr/share/man/man1/unresolved.1",
 			manpage:       ".so notfound.1\n",
@@ -152,6 +169,73 @@ func TestWriteManpage(t *testing.T) {
 				},
 			},
 		},
+
+		{
+			src:      "/usr/share/man/man1/otherpkg.1",
+			manpage:  ".so man8/other.8\n",
+			want:     ".so jessie/extra/other.8.en.gz\n",
+			wantRefs: nil,
+			pkg: pkgEntry{
+				binarypkg: "bash",
+				suite:     "jessie",
+			},
+			contentByPath: map[string][]*contentEntry{
+				"man8/other.8.gz": []*contentEntry{
+					&contentEntry{
+						binarypkg: "zsh",
+						suite:     "jessie",
+					},
+					&contentEntry{
+						binarypkg: "bash",
+						suite:     "stretch",
+					},
+					&contentEntry{
+						binarypkg: "extra",
+						suite:     "jessie",
+					},
+				},
+			},
+		},
+
+		{
+			src:      "/usr/share/man/man1/othersuite.1",
+			manpage:  ".so man8/other.8\n",
+			want:     "",
+			wantRefs: nil,
+			pkg: pkgEntry{
+				binarypkg: "bash",
+				suite:     "jessie",
+			},
+			contentByPath: map[string][]*contentEntry{
+				"man8/other.8.gz": []*contentEntry{
+					&contentEntry{
+						binarypkg: "bash",
+						suite:     "stretch",
+					},
+				},
+			},
+		},
+
+		// Only files of bash itself are extracted into its aux
+		// directory, so the file of zsh would not exist.
+		{
+			src:      "/usr/share/man/man1/otherpkgaux.1",
+			manpage:  ".so man1/otherpkgaux.inc\n",
+			want:     "",
+			wantRefs: nil,
+			pkg: pkgEntry{
+				binarypkg: "bash",
+				suite:     "jessie",
+			},
+			contentByPath: map[string][]*contentEntry{
+				"man1/otherpkgaux.inc.gz": []*contentEntry{
+					&contentEntry{
+						binarypkg: "zsh",
+						suite:     "jessie",
+					},
+				},
+			},
+		},
 	}
 	for _, entry := range table {
 		entry := entry // capture
@@ -160,8 +244,8 @@ func TestWriteManpage(t *testing.T) {
 
 			r := strings.NewReader(entry.manpage)
 			var buf bytes.Buffer
-			logger := log.New(os.Stderr, "", log.LstdFlags)
-			refs, err := soElim(logger, entry.src, r, &buf, entry.contentByPath)
+			logger := &leveledLogger{log.New(os.Stderr, "", log.LstdFlags)}
+			refs, err := soElim(logger, entry.pkg, entry.src, r, &buf, entry.contentByPath)
 			if err != nil {
 				t.Fatal(err)
 			}
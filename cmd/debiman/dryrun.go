@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var dryRun = flag.Bool("dry_run",
+	false,
+	"Print what would be downloaded, extracted, rendered and deleted (with counts and estimated sizes) based on the archive and the serving directory, without writing anything. Discovering packages still downloads the Contents and Packages files of the archive.")
+
+// dryRunReport is the result of a dry run.
+type dryRunReport struct {
+	packages         int
+	extractPackages  int
+	downloadBytes    int64
+	renderManpages   int
+	renderBytes      int64 // of the manpages (not their rendered versions)
+	removedPackages  int
+	removedBytes     int64
+	unreferencedPool int
+	poolBytes        int64
+}
+
+// dirSize returns the total size of the regular files within dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// staleManpages returns the number and total size of the manpages in the
+// binary package directory dir whose rendered version is missing or
+// older than the manpage, i.e. which the render phase would render.
+func staleManpages(dir string) (int, int64, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		n    int
+		size int64
+	)
+	for _, fi := range fis {
+		fn := fi.Name()
+		if !strings.HasSuffix(fn, ".gz") || isRenderedOutput(fn) {
+			continue
+		}
+		htmlst, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(fn, ".gz")+htmlSuffix()))
+		if err == nil && !*forceRerender && !htmlst.ModTime().Before(fi.ModTime()) {
+			continue
+		}
+		n++
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+	return n, size, nil
+}
+
+// dryRunFor determines what a run would do with the packages of gv.
+func dryRunFor(gv globalView) (*dryRunReport, error) {
+	report := &dryRunReport{packages: len(gv.pkgs)}
+
+	// A fresh manifest, which is never written, yields the same
+	// decisions as parallelDownload.
+	manifest, err := loadDownloadManifest(downloadManifestPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range gv.pkgs {
+		if upToDate(*p, manifest) {
+			continue
+		}
+		report.extractPackages++
+		report.downloadBytes += p.bytes
+	}
+
+	var removed []string
+	if *gcRemovedPackages {
+		var err error
+		if removed, err = removedPackages(gv); err != nil {
+			return nil, err
+		}
+	}
+	isRemoved := make(map[string]bool, len(removed))
+	for _, rel := range removed {
+		isRemoved[rel] = true
+		size, err := dirSize(filepath.Join(*servingDir, rel))
+		if err != nil {
+			return nil, err
+		}
+		report.removedPackages++
+		report.removedBytes += size
+	}
+
+	for suite := range gv.suites {
+		bins, err := ioutil.ReadDir(filepath.Join(*servingDir, suite))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, bfi := range bins {
+			if !bfi.IsDir() || isRemoved[suite+"/"+bfi.Name()] {
+				continue
+			}
+			dir := filepath.Join(*servingDir, suite, bfi.Name())
+			n, size, err := staleManpages(dir)
+			if err != nil {
+				return nil, err
+			}
+			report.renderManpages += n
+			report.renderBytes += size
+		}
+	}
+
+	if *contentAddressed {
+		prefixes, err := ioutil.ReadDir(poolDir())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, prefix := range prefixes {
+			fis, err := ioutil.ReadDir(filepath.Join(poolDir(), prefix.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range fis {
+				if nlink, ok := linkCount(fi); ok && nlink <= 1 {
+					report.unreferencedPool++
+					report.poolBytes += fi.Size()
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+func (r *dryRunReport) print() {
+	fmt.Printf("dry run, nothing was written:\n")
+	fmt.Printf("total number of packages: %d\n", r.packages)
+	fmt.Printf("packages to download:     %d (%d bytes)\n", r.extractPackages, r.downloadBytes)
+	fmt.Printf("packages to extract:      %d\n", r.extractPackages)
+	fmt.Printf("manpages to render:       %d (%d bytes), plus those of the extracted packages\n", r.renderManpages, r.renderBytes)
+	fmt.Printf("packages to delete:       %d (%d bytes)\n", r.removedPackages, r.removedBytes)
+	if *contentAddressed {
+		fmt.Printf("pool files to delete:     %d (%d bytes)\n", r.unreferencedPool, r.poolBytes)
+	}
+}
+
+// dryRun prints what a run would do, see -dry_run.
+func (p *pipeline) dryRun() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+	infof("Dry run: comparing %d packages against %q", len(gv.pkgs), *servingDir)
+	report, err := dryRunFor(gv)
+	if err != nil {
+		return err
+	}
+	report.print()
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pault.ag/go/debian/version"
+)
+
+func TestDryRun(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+	defer func(old bool) { *gcRemovedPackages = old }(*gcRemovedPackages)
+	*gcRemovedPackages = true
+
+	old := time.Now().Add(-1 * time.Hour)
+	for _, f := range []struct {
+		path    string
+		content string
+		modTime time.Time
+	}{
+		{"testing/i3-wm/VERSION", "4.13-1", old},
+		{"testing/i3-wm/i3.1.en.gz", "i3", old},
+		{"testing/i3-wm/i3.1.en.html.gz", "i3 html", time.Now()},
+		{"testing/i3-wm/i3-msg.1.en.gz", "i3-msg", old},
+		{"testing/oldpkg/old.1.en.gz", "removed", old},
+	} {
+		path := filepath.Join(tmpdir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i3version, err := version.Parse("4.13-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cronversion, err := version.Parse("3.0pl1-128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gv := globalView{
+		pkgs: []*pkgEntry{
+			{suite: "testing", binarypkg: "i3-wm", version: i3version, bytes: 1234},
+			{suite: "testing", binarypkg: "cron", version: cronversion, bytes: 5678},
+		},
+		suites: map[string]bool{"testing": true},
+	}
+	report, err := dryRunFor(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dryRunReport{
+		packages:        2,
+		extractPackages: 1,
+		downloadBytes:   5678,
+		renderManpages:  1,
+		renderBytes:     int64(len("i3-msg")),
+		removedPackages: 1,
+		removedBytes:    int64(len("removed")),
+	}
+	if *report != want {
+		t.Fatalf("dryRunFor: got %+v, want %+v", *report, want)
+	}
+
+	// Nothing must have been written.
+	if _, err := os.Stat(downloadManifestPath()); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote %q", downloadManifestPath())
+	}
+}
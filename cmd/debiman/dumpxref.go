@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// xrefEntry is a manpage in the -dump_xref JSON output.
+type xrefEntry struct {
+	Suite       string `json:"suite"`
+	Binarypkg   string `json:"binarypkg"`
+	Version     string `json:"version"`
+	Section     string `json:"section"`
+	Language    string `json:"language"`
+	ServingPath string `json:"serving_path"`
+}
+
+type byServingPath []xrefEntry
+
+func (p byServingPath) Len() int           { return len(p) }
+func (p byServingPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byServingPath) Less(i, j int) bool { return p[i].ServingPath < p[j].ServingPath }
+
+// dumpXref writes the cross-reference index of gv, i.e. all manpages
+// by name, to dest. If dest ends in .pb, the index is serialized in the
+// format of the debiman-auxserver index (see writeIndex), otherwise as
+// JSON.
+func dumpXref(dest string, gv globalView) error {
+	if strings.HasSuffix(dest, ".pb") {
+		idxb, err := proto.Marshal(newIndex(gv))
+		if err != nil {
+			return err
+		}
+		return writeAtomically(dest, false, func(w io.Writer) error {
+			_, err := w.Write(idxb)
+			return err
+		})
+	}
+
+	xref := make(map[string][]xrefEntry, len(gv.xref))
+	for name, x := range gv.xref {
+		entries := make([]xrefEntry, len(x))
+		for idx, m := range x {
+			entries[idx] = xrefEntry{
+				Suite:       m.Package.Suite,
+				Binarypkg:   m.Package.Binarypkg,
+				Version:     m.Package.Version.String(),
+				Section:     m.Section,
+				Language:    m.Language,
+				ServingPath: "/" + m.ServingPath(),
+			}
+		}
+		sort.Sort(byServingPath(entries))
+		xref[name] = entries
+	}
+	return writeAtomically(dest, false, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(xref)
+	})
+}
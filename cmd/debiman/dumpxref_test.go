@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+	pb "github.com/Debian/debiman/internal/proto"
+	"github.com/golang/protobuf/proto"
+	"pault.ag/go/debian/version"
+)
+
+func TestDumpXref(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	gv := newGlobalView(1, time.Now())
+	pkg := &manpage.PkgMeta{
+		Binarypkg: "i3-wm",
+		Suite:     "testing",
+		Version:   version.Version{Version: "4.13", Revision: "1"},
+	}
+	for _, path := range []string{"man1/i3.1.gz", "de/man1/i3.1.gz"} {
+		m, err := manpage.FromManPath(path, pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gv.xref[m.Name] = append(gv.xref[m.Name], m)
+	}
+
+	jsonPath := filepath.Join(tmpdir, "xref.json")
+	if err := dumpXref(jsonPath, gv); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]xrefEntry
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]xrefEntry{
+		"i3": {
+			{
+				Suite:       "testing",
+				Binarypkg:   "i3-wm",
+				Version:     "4.13-1",
+				Section:     "1",
+				Language:    "de",
+				ServingPath: "/testing/i3-wm/i3.1.de",
+			},
+			{
+				Suite:       "testing",
+				Binarypkg:   "i3-wm",
+				Version:     "4.13-1",
+				Section:     "1",
+				Language:    "en",
+				ServingPath: "/testing/i3-wm/i3.1.en",
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("dumpXref: got %+v, want %+v", got, want)
+	}
+
+	pbPath := filepath.Join(tmpdir, "xref.pb")
+	if err := dumpXref(pbPath, gv); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(pbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx pb.Index
+	if err := proto.Unmarshal(b, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(idx.Entry), 2; got != want {
+		t.Fatalf("dumpXref: got %d index entries, want %d", got, want)
+	}
+}
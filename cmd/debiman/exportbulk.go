@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var (
+	exportBulkDest = flag.String("export_bulk",
+		"",
+		"If non-empty, export the text and metadata of all rendered manpages as Elasticsearch/OpenSearch bulk API requests: either to an HTTP(S) URL of an index (e.g. http://localhost:9200/manpages), or to a file, which is gzip-compressed if its name ends in .gz")
+
+	exportBulkIndex = flag.String("export_bulk_index",
+		"manpages",
+		"Name of the index referenced by the bulk API requests written to a file by -export_bulk")
+)
+
+// bulkBatchSize is the number of documents sent per bulk API request.
+const bulkBatchSize = 500
+
+// bulkDoc is the document of a manpage in the search cluster.
+type bulkDoc struct {
+	Suite       string `json:"suite"`
+	Binarypkg   string `json:"binarypkg"`
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Section     string `json:"section"`
+	Language    string `json:"language"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"`
+	Text        string `json:"text"`
+}
+
+type bulkAction struct {
+	Index struct {
+		Index string `json:"_index,omitempty"`
+		ID    string `json:"_id"`
+	} `json:"index"`
+}
+
+// encodeBulk writes the index action for doc (identified by id) to w.
+// index may be empty if the request URL specifies the index.
+func encodeBulk(w io.Writer, index, id string, doc bulkDoc) error {
+	var action bulkAction
+	action.Index.Index = index
+	action.Index.ID = id
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	return enc.Encode(doc)
+}
+
+// forEachBulkDoc calls fn with the document of each rendered manpage
+// of gv, ordered by name and section.
+func forEachBulkDoc(gv globalView, fn func(id string, doc bulkDoc) error) error {
+	var mans []*manpage.Meta
+	for _, versions := range gv.xref {
+		mans = append(mans, versions...)
+	}
+	sort.Sort(byNameSection(mans))
+	for _, m := range mans {
+		content, _, err := reuse(filepath.Join(*servingDir, m.ServingPath()+htmlSuffix()))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				warningf("exporting %q: %v", m.ServingPath(), err)
+			}
+			continue
+		}
+		text, err := convert.Text(content)
+		if err != nil {
+			warningf("exporting %q: %v", m.ServingPath(), err)
+			continue
+		}
+		_, description, err := convert.Whatis(content)
+		if err != nil {
+			warningf("exporting %q: %v", m.ServingPath(), err)
+		}
+		if err := fn(m.ServingPath(), bulkDoc{
+			Suite:       m.Package.Suite,
+			Binarypkg:   m.Package.Binarypkg,
+			Version:     m.Package.Version.String(),
+			Name:        m.Name,
+			Section:     m.Section,
+			Language:    m.Language,
+			Description: description,
+			URL:         *baseURL + "/" + m.ServingPath() + ".html",
+			// Remove the anchor links of headings.
+			Text: strings.TrimSpace(strings.Replace(text, "¶", "", -1)),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postBulk sends the bulk API request body to the index at indexURL.
+func postBulk(indexURL string, body io.Reader) error {
+	resp, err := http.Post(strings.TrimSuffix(indexURL, "/")+"/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("bulk request to %q: unexpected HTTP status code: got %d, want %d (body: %q)", indexURL, got, want, string(b))
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID    string          `json:"_id"`
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("bulk request to %q: decoding response: %v", indexURL, err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for _, r := range item {
+			if len(r.Error) > 0 {
+				return fmt.Errorf("bulk request to %q: indexing %q failed: %s", indexURL, r.ID, r.Error)
+			}
+		}
+	}
+	return fmt.Errorf("bulk request to %q failed", indexURL)
+}
+
+// isBulkURL returns whether the -export_bulk destination dest is the URL
+// of an index (as opposed to a file).
+func isBulkURL(dest string) bool {
+	return strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://")
+}
+
+// exportBulk exports all rendered manpages of gv to dest (see
+// -export_bulk).
+func exportBulk(dest string, gv globalView) error {
+	if isBulkURL(dest) {
+		var (
+			batch bytes.Buffer
+			n     int
+		)
+		flush := func() error {
+			if n == 0 {
+				return nil
+			}
+			err := postBulk(dest, &batch)
+			batch.Reset()
+			n = 0
+			return err
+		}
+		if err := forEachBulkDoc(gv, func(id string, doc bulkDoc) error {
+			if err := encodeBulk(&batch, "", id, doc); err != nil {
+				return err
+			}
+			if n++; n == bulkBatchSize {
+				return flush()
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return flush()
+	}
+
+	return writeAtomically(dest, strings.HasSuffix(dest, ".gz"), func(w io.Writer) error {
+		bufw := bufio.NewWriter(w)
+		if err := forEachBulkDoc(gv, func(id string, doc bulkDoc) error {
+			return encodeBulk(bufw, *exportBulkIndex, id, doc)
+		}); err != nil {
+			return err
+		}
+		return bufw.Flush()
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestManpage writes a rendered manpage with content to the serving
+// path servingPath in tmpdir.
+func writeTestManpage(t *testing.T, tmpdir, servingPath, content string) {
+	path := filepath.Join(tmpdir, servingPath+".html")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	html := "<html>\n" + string(mandocDivB) + "\n" + content + "\n</div>\n</div>\n" + string(footerB) + "\n</html>\n"
+	if err := ioutil.WriteFile(path, []byte(html), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readBulk returns the actions and documents of the bulk API request
+// body r.
+func readBulk(t *testing.T, r io.Reader) ([]bulkAction, []bulkDoc) {
+	var (
+		actions []bulkAction
+		docs    []bulkDoc
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var action bulkAction
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, action)
+		if !scanner.Scan() {
+			t.Fatalf("action %+v without document", action)
+		}
+		var doc bulkDoc
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return actions, docs
+}
+
+func TestExportBulk(t *testing.T) {
+	tmpdir, cleanup := newTestServingDir(t)
+	defer cleanup()
+
+	gv := newTestGlobalView(t, "jessie", "i3-wm", "man1/i3.1.gz", "man1/i3-msg.1.gz")
+	// i3-msg(1) was not rendered (e.g. due to -only_render).
+	writeTestManpage(t, tmpdir, "jessie/i3-wm/i3.1.en",
+		`<h1 class="Sh" id="NAME">NAME<a class="anchor" href="#NAME">¶</a></h1><b>i3</b> - an improved dynamic, tiling window manager`)
+
+	dest := filepath.Join(tmpdir, "export.ndjson")
+	if err := exportBulk(dest, gv); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	actions, docs := readBulk(t, f)
+	var wantAction bulkAction
+	wantAction.Index.Index = "manpages"
+	wantAction.Index.ID = "jessie/i3-wm/i3.1.en"
+	if want := []bulkAction{wantAction}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("unexpected actions: got %+v, want %+v", actions, want)
+	}
+	wantDoc := bulkDoc{
+		Suite:       "jessie",
+		Binarypkg:   "i3-wm",
+		Version:     "",
+		Name:        "i3",
+		Section:     "1",
+		Language:    "en",
+		Description: "an improved dynamic, tiling window manager",
+		URL:         *baseURL + "/jessie/i3-wm/i3.1.en.html",
+		Text:        "NAME\ni3 - an improved dynamic, tiling window manager",
+	}
+	if want := []bulkDoc{wantDoc}; !reflect.DeepEqual(docs, want) {
+		t.Fatalf("unexpected documents: got %+v, want %+v", docs, want)
+	}
+
+	// The handler passes the requests to the test goroutine, which
+	// verifies them (t.Fatal must not be called from the handler).
+	type bulkRequest struct {
+		path string
+		body []byte
+	}
+	requests := make(chan bulkRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		requests <- bulkRequest{path: r.URL.Path, body: body}
+		if r.URL.Path == "/failing/_bulk" {
+			io.WriteString(w, `{"errors": true, "items": [{"index": {"_id": "jessie/i3-wm/i3.1.en", "error": {"type": "mapper_parsing_exception"}}}]}`)
+			return
+		}
+		io.WriteString(w, `{"errors": false, "items": [{"index": {"_id": "jessie/i3-wm/i3.1.en", "status": 201}}]}`)
+	}))
+	defer ts.Close()
+
+	verifyRequests := func(wantPath string) {
+		if got, want := len(requests), 1; got != want {
+			t.Fatalf("got %d requests, want %d", got, want)
+		}
+		req := <-requests
+		if req.path != wantPath {
+			t.Errorf("unexpected request path: got %q, want %q", req.path, wantPath)
+		}
+		actions, _ := readBulk(t, bytes.NewReader(req.body))
+		if got, want := len(actions), 1; got != want {
+			t.Errorf("got %d actions, want %d", got, want)
+		}
+		if len(actions) > 0 && actions[0].Index.Index != "" {
+			t.Errorf("unexpected index in request to an index URL: %q", actions[0].Index.Index)
+		}
+	}
+
+	if err := exportBulk(ts.URL+"/manpages", gv); err != nil {
+		t.Fatal(err)
+	}
+	verifyRequests("/manpages/_bulk")
+	if err := exportBulk(ts.URL+"/failing/", gv); err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("exportBulk with indexing errors: got %v, want an error mentioning mapper_parsing_exception", err)
+	}
+	verifyRequests("/failing/_bulk")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Debian/debiman/internal/archive"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var federatedDistributions = flag.String("federated_distributions",
+	"",
+	"If non-empty, path to a file describing additional distributions (e.g. Ubuntu or a Debian derivative) to include in the serving tree, one per line: <prefix> <mirror URL>[,<mirror URL>…] <keyring> <codename>[,<codename>…] [components=<component>[,<component>…]] [architectures=<arch>[,<arch>…]] [format=debian|pacman|rpm]. Further mirror URLs are used when downloading from the first one fails. A keyring of - marks an unsigned repository. components (default: -components) and architectures (default: all architectures of the suite, x86_64 for pacman and rpm) configure the suites of the line, so a distribution whose suites differ can be specified using multiple lines with the same prefix. Their suites are served with the prefix, e.g. ubuntu-jammy, share the cross-references (and thereby the list of suites a manpage is available in) with all other suites and get a sitemap index per prefix (sitemapindex-<prefix>.xml.gz). Empty lines and lines starting with # are ignored.")
+
+// federatedDistribution is a distribution other than Debian, which is
+// rendered into the same serving tree.
+type federatedDistribution struct {
+	prefix     string   // e.g. ubuntu
+	mirrorURLs []string // e.g. http://archive.ubuntu.com/ubuntu/
+	keyring    string   // e.g. /usr/share/keyrings/ubuntu-archive-keyring.gpg
+	codenames  []string
+
+	// components and architectures are nil unless specified.
+	components    []string // e.g. main, rpi
+	architectures []string // e.g. armhf
+
+	// format is the repository format, one of formatDebian,
+	// formatPacman or formatRPM (whose codenames are repositories,
+	// e.g. core or BaseOS).
+	format string
+}
+
+// suiteTitle returns how suite is referred to in page titles, e.g.
+// “Debian jessie” or, for the suites of -federated_distributions,
+// “Ubuntu jammy” (for suite ubuntu-jammy).
+func (gv globalView) suiteTitle(suite string) string {
+	prefix, ok := gv.prefixes[suite]
+	if !ok {
+		return "Debian " + suite
+	}
+	return strings.Title(prefix) + " " + strings.TrimPrefix(suite, prefix+"-")
+}
+
+// validPrefix matches prefixes which are safe to use in URLs and file
+// names and cannot be confused with (or contain) a Debian suite name.
+var validPrefix = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// parseFederatedDistributions parses the -federated_distributions file
+// format.
+func parseFederatedDistributions(r io.Reader) ([]federatedDistribution, error) {
+	var (
+		result []federatedDistribution
+		suites = make(map[string]bool)
+	)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: expected at least 4 fields (<prefix> <mirror URL> <keyring> <codenames>), got %d", lineno, len(fields))
+		}
+		fd := federatedDistribution{
+			prefix:  fields[0],
+			keyring: fields[2],
+			format:  formatDebian,
+		}
+		if !validPrefix.MatchString(fd.prefix) {
+			return nil, fmt.Errorf("line %d: invalid prefix %q: must match %s", lineno, fd.prefix, validPrefix)
+		}
+		urls, err := parseMirrorURLs(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		fd.mirrorURLs = urls
+		fd.codenames = splitList(fields[3])
+		if len(fd.codenames) == 0 {
+			return nil, fmt.Errorf("line %d: no codenames specified", lineno)
+		}
+		for _, codename := range fd.codenames {
+			if suites[fd.prefix+"-"+codename] {
+				return nil, fmt.Errorf("line %d: suite %q specified twice", lineno, fd.prefix+"-"+codename)
+			}
+			suites[fd.prefix+"-"+codename] = true
+		}
+		for _, option := range fields[4:] {
+			idx := strings.Index(option, "=")
+			if idx == -1 {
+				return nil, fmt.Errorf("line %d: invalid option %q: expected <key>=<value>", lineno, option)
+			}
+			key, values := option[:idx], splitList(option[idx+1:])
+			if len(values) == 0 {
+				return nil, fmt.Errorf("line %d: option %q: no values specified", lineno, key)
+			}
+			switch key {
+			case "components":
+				fd.components = values
+			case "architectures":
+				fd.architectures = values
+			case "format":
+				if len(values) != 1 || (values[0] != formatDebian && values[0] != formatPacman && values[0] != formatRPM) {
+					return nil, fmt.Errorf("line %d: invalid format %q: expected %s, %s or %s", lineno, option[idx+1:], formatDebian, formatPacman, formatRPM)
+				}
+				fd.format = values[0]
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", lineno, key)
+			}
+		}
+		result = append(result, fd)
+	}
+	return result, scanner.Err()
+}
+
+// splitList splits the comma-separated list s, ignoring empty elements.
+func splitList(s string) []string {
+	var result []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+// federatedDistributionsFromFile returns the distributions to
+// synchronize for the -federated_distributions file path, whose
+// packages are cached in pc (if non-nil).
+func federatedDistributionsFromFile(path string, pc *archive.PackageCache) ([]distribution, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fds, err := parseFederatedDistributions(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var dists []distribution
+	for _, fd := range fds {
+		ar := &archive.Getter{
+			ConnectionsPerMirror: 10,
+			MirrorURL:            fd.mirrorURLs[0],
+			Mirrors:              fd.mirrorURLs[1:],
+			Keyring:              fd.keyring,
+			Insecure:             *insecure || fd.keyring == "-",
+			RetriesTransient:     downloadRetriesTransient(),
+			Backoff:              *downloadBackoff,
+			Timeout:              *downloadTimeout,
+			PackageCache:         pc,
+		}
+		if dir := indexCacheDir(); dir != "" {
+			// Codenames of different distributions may clash.
+			ar.CacheDir = filepath.Join(dir, fd.prefix)
+		}
+		for _, codename := range fd.codenames {
+			dists = append(dists, distribution{
+				name:          codename,
+				identifier:    fromCodename,
+				prefix:        fd.prefix,
+				ar:            ar,
+				components:    fd.components,
+				architectures: fd.architectures,
+				format:        fd.format,
+			})
+		}
+	}
+	return dists, nil
+}
+
+// addSortOrder makes suite (e.g. ubuntu-jammy) known to sortOrder,
+// sorting it after all Debian suites and previously added suites.
+func addSortOrder(suite string) {
+	if _, ok := sortOrder[suite]; ok {
+		return
+	}
+	max := 0
+	for _, order := range sortOrder {
+		if order > max {
+			max = order
+		}
+	}
+	sortOrder[suite] = max + 1
+}
+
+// serveAs moves the contents and packages which were discovered in a
+// suite of a federated distribution to suite, the name under which the
+// suite is served (e.g. ubuntu-jammy instead of jammy).
+func serveAs(suite string, content []*contentEntry, pkgs []*pkgEntry, latestVersion map[string]*manpage.PkgMeta) map[string]*manpage.PkgMeta {
+	for _, c := range content {
+		c.suite = suite
+	}
+	for _, p := range pkgs {
+		p.suite = suite
+	}
+	result := make(map[string]*manpage.PkgMeta, len(latestVersion))
+	for _, pm := range latestVersion {
+		pm.Suite = suite
+		result[suite+"/"+pm.Binarypkg] = pm
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+func TestParseFederatedDistributions(t *testing.T) {
+	const input = `# distributions served in addition to Debian
+ubuntu http://archive.ubuntu.com/ubuntu,http://ports.ubuntu.com/ /usr/share/keyrings/ubuntu-archive-keyring.gpg focal,jammy
+
+devuan http://deb.devuan.org/merged/ /usr/share/keyrings/devuan-archive-keyring.gpg daedalus
+raspbian http://archive.raspbian.org/raspbian/ /usr/share/keyrings/raspbian-archive-keyring.gpg bookworm components=main,rpi architectures=armhf
+raspbian http://legacy.raspbian.org/raspbian/ /usr/share/keyrings/raspbian-archive-keyring.gpg buster
+arch https://geo.mirror.pkgbuild.com/ - core,extra format=pacman
+fedora https://dl.fedoraproject.org/pub/fedora/linux/releases/40/ - Everything format=rpm architectures=x86_64,aarch64
+`
+	got, err := parseFederatedDistributions(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []federatedDistribution{
+		{
+			prefix:     "ubuntu",
+			mirrorURLs: []string{"http://archive.ubuntu.com/ubuntu/", "http://ports.ubuntu.com/"},
+			keyring:    "/usr/share/keyrings/ubuntu-archive-keyring.gpg",
+			codenames:  []string{"focal", "jammy"},
+			format:     formatDebian,
+		},
+		{
+			prefix:     "devuan",
+			mirrorURLs: []string{"http://deb.devuan.org/merged/"},
+			keyring:    "/usr/share/keyrings/devuan-archive-keyring.gpg",
+			codenames:  []string{"daedalus"},
+			format:     formatDebian,
+		},
+		{
+			prefix:        "raspbian",
+			mirrorURLs:    []string{"http://archive.raspbian.org/raspbian/"},
+			keyring:       "/usr/share/keyrings/raspbian-archive-keyring.gpg",
+			codenames:     []string{"bookworm"},
+			components:    []string{"main", "rpi"},
+			architectures: []string{"armhf"},
+			format:        formatDebian,
+		},
+		{
+			prefix:     "raspbian",
+			mirrorURLs: []string{"http://legacy.raspbian.org/raspbian/"},
+			keyring:    "/usr/share/keyrings/raspbian-archive-keyring.gpg",
+			codenames:  []string{"buster"},
+			format:     formatDebian,
+		},
+		{
+			prefix:     "arch",
+			mirrorURLs: []string{"https://geo.mirror.pkgbuild.com/"},
+			keyring:    "-",
+			codenames:  []string{"core", "extra"},
+			format:     formatPacman,
+		},
+		{
+			prefix:        "fedora",
+			mirrorURLs:    []string{"https://dl.fedoraproject.org/pub/fedora/linux/releases/40/"},
+			keyring:       "-",
+			codenames:     []string{"Everything"},
+			architectures: []string{"x86_64", "aarch64"},
+			format:        formatRPM,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result: got %+v, want %+v", got, want)
+	}
+
+	for _, input := range []string{
+		"ubuntu http://archive.ubuntu.com/ubuntu/ focal",
+		"Ubuntu http://archive.ubuntu.com/ubuntu/ /tmp/k focal",
+		"ubuntu-lts http://archive.ubuntu.com/ubuntu/ /tmp/k focal",
+		"ubuntu http://archive.ubuntu.com/ubuntu/ /tmp/k ,",
+		"ubuntu http://a.example/ /tmp/k focal\nubuntu http://b.example/ /tmp/k jammy,focal",
+		"ubuntu http://archive.ubuntu.com/ubuntu/ /tmp/k focal components",
+		"ubuntu http://archive.ubuntu.com/ubuntu/ /tmp/k focal components=",
+		"ubuntu http://archive.ubuntu.com/ubuntu/ /tmp/k focal pool=ubuntu",
+		"arch https://geo.mirror.pkgbuild.com/ - core format=portage",
+		"ubuntu ftp://archive.ubuntu.com/ubuntu/ /tmp/k focal",
+	} {
+		if _, err := parseFederatedDistributions(strings.NewReader(input)); err == nil {
+			t.Errorf("parseFederatedDistributions(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestServeAs(t *testing.T) {
+	content := []*contentEntry{{suite: "jammy", binarypkg: "coreutils"}}
+	pkgs := []*pkgEntry{{suite: "jammy", binarypkg: "coreutils"}}
+	latestVersion := map[string]*manpage.PkgMeta{
+		"jammy/coreutils": {Binarypkg: "coreutils", Suite: "jammy"},
+	}
+	latestVersion = serveAs("ubuntu-jammy", content, pkgs, latestVersion)
+	if got, want := content[0].suite, "ubuntu-jammy"; got != want {
+		t.Errorf("content suite: got %q, want %q", got, want)
+	}
+	if got, want := pkgs[0].suite, "ubuntu-jammy"; got != want {
+		t.Errorf("package suite: got %q, want %q", got, want)
+	}
+	pm, ok := latestVersion["ubuntu-jammy/coreutils"]
+	if !ok {
+		t.Fatalf("latestVersion does not contain ubuntu-jammy/coreutils: %+v", latestVersion)
+	}
+	if got, want := pm.Suite, "ubuntu-jammy"; got != want {
+		t.Errorf("PkgMeta suite: got %q, want %q", got, want)
+	}
+}
+
+func TestSuiteTitle(t *testing.T) {
+	gv := newGlobalView(1, time.Now())
+	gv.prefixes["ubuntu-jammy"] = "ubuntu"
+	for _, tt := range []struct {
+		suite string
+		want  string
+	}{
+		{"jessie", "Debian jessie"},
+		{"ubuntu-jammy", "Ubuntu jammy"},
+	} {
+		if got := gv.suiteTitle(tt.suite); got != tt.want {
+			t.Errorf("suiteTitle(%q): got %q, want %q", tt.suite, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var atomFeedEntries = flag.Int("atom_feed_entries",
+	0,
+	"If non-zero, write an Atom feed of the manpages which are new or changed (i.e. whose source was updated and whose rendered content differs from the previous run) in each suite to feed-<suite>.atom, keeping the specified number of most recent entries across runs.")
+
+// feedChange describes why a manpage is (re-)rendered, for the Atom
+// feeds.
+type feedChange int
+
+const (
+	feedUnchanged feedChange = iota // e.g. re-rendered for a new cross-reference
+	feedNew
+	feedUpdated
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type byTitle []atomEntry
+
+func (p byTitle) Len() int           { return len(p) }
+func (p byTitle) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byTitle) Less(i, j int) bool { return p[i].Title < p[j].Title }
+
+// feedRecorder collects the manpages which are new or changed in this
+// run, keyed by suite.
+type feedRecorder struct {
+	updated string // time of the run, in RFC 3339 format
+
+	mu      sync.Mutex
+	entries map[string][]atomEntry
+}
+
+func newFeedRecorder(start time.Time) *feedRecorder {
+	return &feedRecorder{
+		updated: start.UTC().Format(time.RFC3339),
+		entries: make(map[string][]atomEntry),
+	}
+}
+
+// record adds m, which was rendered due to change, to the feed of its
+// suite.
+func (f *feedRecorder) record(m *manpage.Meta, change feedChange) {
+	if change == feedUnchanged {
+		return
+	}
+	summary := fmt.Sprintf("New in %s %s", m.Package.Binarypkg, m.Package.Version.String())
+	if change == feedUpdated {
+		summary = fmt.Sprintf("Updated in %s %s", m.Package.Binarypkg, m.Package.Version.String())
+	}
+	link := *baseURL + "/" + m.ServingPath() + ".html"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[m.Package.Suite] = append(f.entries[m.Package.Suite], atomEntry{
+		Title:   fmt.Sprintf("%s(%s) (%s)", m.Name, m.Section, m.Language),
+		ID:      link + "#" + m.Package.Version.String(),
+		Link:    atomLink{Href: link},
+		Updated: f.updated,
+		Summary: summary,
+	})
+}
+
+func feedPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("feed-%s.atom", suite))
+}
+
+// write prepends the recorded entries to the feed-<suite>.atom files in
+// destDir, keeping at most -atom_feed_entries entries per feed. Feeds
+// of suites without new entries are only written if they do not exist
+// yet. suiteTitle returns how a suite is referred to in feed titles
+// (see globalView.suiteTitle).
+func (f *feedRecorder) write(destDir string, suites map[string]bool, suiteTitle func(suite string) string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for suite := range suites {
+		path := feedPath(destDir, suite)
+		recorded := f.entries[suite]
+		var feed atomFeed
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if len(recorded) == 0 {
+				continue
+			}
+			if err := xml.Unmarshal(b, &feed); err != nil {
+				return fmt.Errorf("parsing %q: %v", path, err)
+			}
+		}
+		sort.Sort(byTitle(recorded))
+		entries := append(append([]atomEntry(nil), recorded...), feed.Entries...)
+		if len(entries) > *atomFeedEntries {
+			entries = entries[:*atomFeedEntries]
+		}
+		feed = atomFeed{
+			Title: fmt.Sprintf("New and changed manpages in %s", suiteTitle(suite)),
+			ID:    *baseURL + "/feed-" + suite + ".atom",
+			Link: []atomLink{
+				{Href: *baseURL + "/feed-" + suite + ".atom", Rel: "self"},
+				{Href: *baseURL + "/contents-" + suite + ".html"},
+			},
+			Updated: f.updated,
+			Author:  atomAuthor{Name: "debiman"},
+			Entries: entries,
+		}
+		recordArtifact(artifactData, path)
+		if err := writeAtomically(path, false, func(w io.Writer) error {
+			if _, err := io.WriteString(w, xml.Header); err != nil {
+				return err
+			}
+			enc := xml.NewEncoder(w)
+			enc.Indent("", "  ")
+			return enc.Encode(&feed)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
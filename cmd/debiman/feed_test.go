@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFeedRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debiman-feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldEntries := *atomFeedEntries
+	*atomFeedEntries = 2
+	defer func() { *atomFeedEntries = oldEntries }()
+
+	readFeed := func() atomFeed {
+		b, err := ioutil.ReadFile(feedPath(dir, "jessie"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var feed atomFeed
+		if err := xml.Unmarshal(b, &feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed
+	}
+	suites := map[string]bool{"jessie": true}
+	gv := newGlobalView(1, time.Now())
+
+	f := newFeedRecorder(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	f.record(mustParseFromServingPath(t, "jessie/i3-wm/i3.1.en"), feedNew)
+	f.record(mustParseFromServingPath(t, "jessie/cron/crontab.5.en"), feedUnchanged)
+	if err := f.write(dir, suites, gv.suiteTitle); err != nil {
+		t.Fatal(err)
+	}
+	feed := readFeed()
+	if got, want := len(feed.Entries), 1; got != want {
+		t.Fatalf("unexpected number of entries: got %d, want %d", got, want)
+	}
+	if got, want := feed.Updated, "2017-01-01T00:00:00Z"; got != want {
+		t.Fatalf("unexpected updated timestamp: got %q, want %q", got, want)
+	}
+	if got, want := feed.Title, "New and changed manpages in Debian jessie"; got != want {
+		t.Fatalf("unexpected title: got %q, want %q", got, want)
+	}
+
+	// A run without changes leaves the feed untouched.
+	f = newFeedRecorder(time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err := f.write(dir, suites, gv.suiteTitle); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFeed().Updated, "2017-01-01T00:00:00Z"; got != want {
+		t.Fatalf("unexpected updated timestamp: got %q, want %q", got, want)
+	}
+
+	// New entries are prepended, old entries beyond -atom_feed_entries
+	// are dropped.
+	f = newFeedRecorder(time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC))
+	f.record(mustParseFromServingPath(t, "jessie/w3m/w3m.1.en"), feedUpdated)
+	f.record(mustParseFromServingPath(t, "jessie/cron/crontab.5.en"), feedUpdated)
+	if err := f.write(dir, suites, gv.suiteTitle); err != nil {
+		t.Fatal(err)
+	}
+	feed = readFeed()
+	var titles []string
+	for _, e := range feed.Entries {
+		titles = append(titles, e.Title)
+	}
+	if got, want := len(titles), 2; got != want {
+		t.Fatalf("unexpected entries: got %q, want %d entries", titles, want)
+	}
+	if titles[0] != "crontab(5) (en)" || titles[1] != "w3m(1) (en)" {
+		t.Fatalf("unexpected entries: got %q", titles)
+	}
+}
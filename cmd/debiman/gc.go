@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var gcRemovedPackages = flag.Bool("gc_removed_packages",
+	false,
+	"Make the gc command remove the manpages of packages which are no longer in any configured suite. Directories containing -redirect_map pages are kept.")
+
+var gcTombstones = flag.Bool("gc_tombstones",
+	false,
+	"When the gc command removes a package which is no longer in any configured suite, leave redirect pages (tombstones) at the URLs of its manpages, pointing to another version of the manpage if there is one, or to its short URL (e.g. /i3.1) otherwise")
+
+// tombstoneMarker is the name of the file which marks a binary package
+// directory as containing only -gc_tombstones.
+const tombstoneMarker = ".tombstone"
+
+// isTombstone returns whether the binary package directory dir only
+// contains -gc_tombstones.
+func isTombstone(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, tombstoneMarker))
+	return err == nil
+}
+
+// removedPackages returns the binary package directories (relative to
+// -serving_dir) of the suites of gv which belong to packages that are
+// no longer in gv.pkgs, sorted by name. With -local_debs, gv.pkgs only
+// contains the packages being previewed, so no packages are removed.
+// Directories containing -redirect_map pages (e.g. for a package which
+// was renamed) are kept.
+func removedPackages(gv globalView) ([]string, error) {
+	if *localDebs != "" {
+		debugf("-local_debs specified, not removing any packages")
+		return nil, nil
+	}
+	known := make(map[string]bool, len(gv.pkgs))
+	pkgsBySuite := make(map[string]int)
+	for _, p := range gv.pkgs {
+		known[p.suite+"/"+p.binarypkg] = true
+		pkgsBySuite[p.suite]++
+	}
+	var removed []string
+	for suite := range gv.suites {
+		if pkgsBySuite[suite] == 0 {
+			// Rather than deleting an entire suite, e.g. because its
+			// packages could not be discovered, require manual
+			// intervention.
+			warningf("suite %q does not contain any packages, not removing any of its packages", suite)
+			continue
+		}
+		bins, err := ioutil.ReadDir(filepath.Join(*servingDir, suite))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, bfi := range bins {
+			rel := suite + "/" + bfi.Name()
+			if !bfi.IsDir() || known[rel] || isTombstone(filepath.Join(*servingDir, rel)) || hasRedirects(filepath.Join(*servingDir, rel)) {
+				continue
+			}
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// tombstoneTarget returns the URL to which the tombstone of m points.
+func tombstoneTarget(gv globalView, m *manpage.Meta) string {
+	var sameSection *manpage.Meta
+	for _, v := range gv.xref[m.Name] {
+		if v.Section != m.Section {
+			continue
+		}
+		if v.Language == m.Language {
+			return "/" + v.ServingPath() + ".html"
+		}
+		if sameSection == nil {
+			sameSection = v
+		}
+	}
+	if sameSection != nil {
+		return "/" + sameSection.ServingPath() + ".html"
+	}
+	return "/" + m.Name + "." + m.Section
+}
+
+func writeTombstone(dest, target string) error {
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		return redirectTmpl.Execute(w, struct {
+			Target string
+		}{
+			Target: target,
+		})
+	})
+}
+
+// removePackage removes the binary package directory rel (relative to
+// -serving_dir), leaving -gc_tombstones if enabled.
+func removePackage(gv globalView, rel string) error {
+	dir := filepath.Join(*servingDir, rel)
+	var manpages []*manpage.Meta
+	if *gcTombstones {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			fn := fi.Name()
+			if !strings.HasSuffix(fn, ".gz") || isRenderedOutput(fn) {
+				continue
+			}
+			m, err := manpage.FromServingPath(*servingDir, filepath.Join(dir, fn))
+			if err != nil {
+				continue // e.g. a file which a .so line refers to
+			}
+			manpages = append(manpages, m)
+		}
+	}
+
+	debugf("removing %q", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	atomic.AddUint64(&gv.stats.PackagesDeleted, 1)
+	if !*gcTombstones {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, m := range manpages {
+		if err := writeTombstone(filepath.Join(*servingDir, m.ServingPath()+htmlSuffix()), tombstoneTarget(gv, m)); err != nil {
+			return err
+		}
+	}
+	suite := filepath.Dir(rel)
+	if err := writeTombstone(filepath.Join(dir, "index"+htmlSuffix()), fmt.Sprintf("/contents-%s.html", suite)); err != nil {
+		return err
+	}
+	// The marker is written last, so that the tombstones are written
+	// again if debiman is interrupted.
+	return ioutil.WriteFile(filepath.Join(dir, tombstoneMarker), nil, 0644)
+}
+
+// gcPackages removes the manpages of packages which are no longer in
+// any configured suite, and updates the sitemaps and contents pages
+// which list them.
+func gcPackages(gv globalView) error {
+	removed, err := removedPackages(gv)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	for _, rel := range removed {
+		if err := removePackage(gv, rel); err != nil {
+			return err
+		}
+	}
+	infof("Removed %d packages which are no longer in the archive", len(removed))
+	if err := renderSitemaps(gv); err != nil {
+		return err
+	}
+	return renderAllContents(gv)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+func TestGCPackages(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+	defer func(old bool) { *gcTombstones = old }(*gcTombstones)
+	*gcTombstones = true
+
+	for _, path := range []string{
+		"testing/i3-wm/i3.1.en.gz",
+		"testing/i3-wm/i3.1.en.html.gz",
+		"testing/i3lock/i3lock.1.en.gz",
+		"testing/i3lock/i3lock.1.en.html.gz",
+		"testing/i3lock/i3lock.1.de.gz",
+		"testing/i3lock/index.html.gz",
+		"testing/oldtool/oldtool.8.en.gz",
+		// oldname only contains -redirect_map pages.
+		"testing/oldname/oldname.1.en.html.gz",
+		"testing/oldname/" + redirectsMarker,
+		"unstable/i3lock/i3lock.1.en.gz",
+		"experimental/i3-wm/i3.1.en.gz",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("manpage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unstableI3lock := &manpage.Meta{
+		Name:     "i3lock",
+		Section:  "1",
+		Language: "en",
+		Package:  &manpage.PkgMeta{Binarypkg: "i3lock", Suite: "unstable"},
+	}
+	gv := globalView{
+		pkgs: []*pkgEntry{
+			{suite: "testing", binarypkg: "i3-wm"},
+			{suite: "unstable", binarypkg: "i3lock"},
+		},
+		// experimental has no packages (e.g. because discovery
+		// failed), so none of its packages must be removed.
+		suites: map[string]bool{"testing": true, "unstable": true, "experimental": true},
+		xref:   map[string][]*manpage.Meta{"i3lock": {unstableI3lock}},
+		stats:  &stats{},
+	}
+
+	removed, err := removedPackages(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"testing/i3lock", "testing/oldtool"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removedPackages: got %q, want %q", removed, want)
+	}
+
+	if err := gcPackages(gv); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gv.stats.PackagesDeleted, uint64(2); got != want {
+		t.Errorf("PackagesDeleted: got %d, want %d", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "testing/i3lock/i3lock.1.en.gz")); !os.IsNotExist(err) {
+		t.Errorf("manpage of removed package not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "experimental/i3-wm/i3.1.en.gz")); err != nil {
+		t.Errorf("manpage of suite without packages deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "testing/oldname/oldname.1.en.html.gz")); err != nil {
+		t.Errorf("-redirect_map page deleted: %v", err)
+	}
+
+	for path, target := range map[string]string{
+		"testing/i3lock/i3lock.1.en.html.gz":   "/unstable/i3lock/i3lock.1.en.html",
+		"testing/i3lock/i3lock.1.de.html.gz":   "/unstable/i3lock/i3lock.1.en.html",
+		"testing/oldtool/oldtool.8.en.html.gz": "/oldtool.8",
+		"testing/i3lock/index.html.gz":         "/contents-testing.html",
+	} {
+		f, err := os.Open(filepath.Join(tmpdir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `href="`+target+`"`) {
+			t.Errorf("tombstone %q does not point to %q: %s", path, target, b)
+		}
+	}
+
+	// Tombstones are not removed again, and not listed.
+	removed, err = removedPackages(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) > 0 {
+		t.Errorf("removedPackages after gc: got %q, want none", removed)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(tmpdir, "contents-testing.html.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(strings.NewReader(string(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "oldtool") {
+		t.Errorf("contents page lists removed package oldtool")
+	}
+	if !strings.Contains(string(b), "i3-wm") {
+		t.Errorf("contents page does not list i3-wm")
+	}
+}
+
+func TestGCPackagesLocalDebs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+	oldLocalDebs := *localDebs
+	defer flag.Set("local_debs", oldLocalDebs)
+	flag.Set("local_debs", "i3-wm_4.13-1_amd64.deb")
+
+	// A previously previewed package, which is not part of this run.
+	path := filepath.Join(tmpdir, "local/i3lock/i3lock.1.en.gz")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("manpage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gv := globalView{
+		pkgs:   []*pkgEntry{{suite: "local", binarypkg: "i3-wm"}},
+		suites: map[string]bool{"local": true},
+		stats:  &stats{},
+	}
+	removed, err := removedPackages(gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) > 0 {
+		t.Fatalf("removedPackages with -local_debs: got %q, want none", removed)
+	}
+	if err := gcPackages(gv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("manpage deleted with -local_debs: %v", err)
+	}
+}
@@ -3,11 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/hex"
-	"fmt"
 	"io"
-	"log"
-	"os"
 
 	"golang.org/x/sync/errgroup"
 
@@ -20,15 +16,38 @@ type contentEntry struct {
 	suite     string
 	arch      string
 	binarypkg string
-	filename  string
+	filename  string // relative to /usr/share/man or, for info, /usr/share/info
+
+	// info is true if the file is an Info document (see -render_info)
+	// instead of a manpage. Only packages are discovered using Info
+	// documents, they are neither cross-referenced nor rendered.
+	info bool
 }
 
-var manPrefix = []byte("usr/share/man/")
+// contentLess returns whether a sorts before b in a Contents file,
+// i.e. Info documents (/usr/share/info) before manpages
+// (/usr/share/man).
+func contentLess(a, b *contentEntry) bool {
+	if a.info != b.info {
+		return a.info
+	}
+	return a.filename < b.filename
+}
+
+var (
+	manPrefix  = []byte("usr/share/man/")
+	infoPrefix = []byte("usr/share/info/")
+)
 
 func parseContentsEntry(scanner *bufio.Scanner) ([]*contentEntry, error) {
 	for scanner.Scan() {
 		text := scanner.Bytes()
-		if !bytes.HasPrefix(text, manPrefix) {
+		prefix := manPrefix
+		info := false
+		if *renderInfo && bytes.HasPrefix(text, infoPrefix) {
+			prefix = infoPrefix
+			info = true
+		} else if !bytes.HasPrefix(text, manPrefix) {
 			continue
 		}
 
@@ -36,6 +55,10 @@ func parseContentsEntry(scanner *bufio.Scanner) ([]*contentEntry, error) {
 		if idx == -1 {
 			continue
 		}
+		filename := string(bytes.TrimSpace(text[len(prefix):idx]))
+		if info && !infoFileRe.MatchString(filename) {
+			continue
+		}
 		parts := bytes.Split(text[idx:], []byte{','})
 		entries := make([]*contentEntry, 0, len(parts))
 		for _, part := range parts {
@@ -45,7 +68,8 @@ func parseContentsEntry(scanner *bufio.Scanner) ([]*contentEntry, error) {
 			}
 			entries = append(entries, &contentEntry{
 				binarypkg: string(part[idx2+1:]),
-				filename:  string(bytes.TrimSpace(text[len(manPrefix):idx])),
+				filename:  filename,
+				info:      info,
 			})
 		}
 		if len(entries) > 0 {
@@ -60,74 +84,94 @@ func parseContentsEntry(scanner *bufio.Scanner) ([]*contentEntry, error) {
 	return nil, io.EOF
 }
 
-func getContents(ar *archive.Getter, suite string, component string, archs []string, hashByFilename map[string]*control.SHA256FileHash) ([]*contentEntry, error) {
-	files := make([]*os.File, len(archs))
-	scanners := make([]*bufio.Scanner, len(archs))
-	contents := make([][]*contentEntry, len(archs))
-	advance := make([]bool, len(archs))
-	exhausted := make([]bool, len(archs))
+// contentsPath returns the path of the Contents file of component and
+// arch, relative to the dists/<suite> directory.
+func contentsPath(component, arch string) string {
+	return component + "/Contents-" + arch + ".gz"
+}
+
+// readContents returns the entries of each manpage (or Info document)
+// line of the Contents file of component and arch, in the order of the
+// file. The Contents file is only downloaded and parsed if its result
+// is not in cache.
+func readContents(ar *archive.Getter, suite, component, arch string, hashByFilename map[string]*control.SHA256FileHash, cache *discoveryCache) ([][]*contentEntry, error) {
+	path := contentsPath(component, arch)
+	key := discoveryCacheKey("contents", indexHash(hashByFilename, path))
+	if lines, ok := cache.loadContents(key); ok {
+		infof("%q unchanged, using the result of a previous run", suite+"/"+path)
+		return lines, nil
+	}
+
+	infof("getting %q", suite+"/"+path)
+	r, err := ar.GetIndex("dists/"+suite, path, hashByFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	var lines [][]*contentEntry
+	for {
+		entries, err := parseContentsEntry(scanner)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, entries)
+	}
+	cache.storeContents(key, lines)
+	return lines, nil
+}
+
+// getContents merges the Contents files of component for all archs. The
+// returned sets contain, for each arch, the binary packages which its
+// Contents file lists (regardless of the merge).
+func getContents(ar *archive.Getter, suite string, component string, archs []string, hashByFilename map[string]*control.SHA256FileHash, cache *discoveryCache) ([]*contentEntry, []map[string]bool, error) {
+	files := make([][][]*contentEntry, len(archs))
 	var eg errgroup.Group
 	for idx, arch := range archs {
 		idx := idx   // copy
 		arch := arch // copy
 		eg.Go(func() error {
-			path := component + "/Contents-" + arch + ".gz"
-			fh, ok := hashByFilename[path]
-			if !ok {
-				return fmt.Errorf("ERROR: expected path %q not found in Release file", path)
-			}
-
-			h, err := hex.DecodeString(fh.Hash)
-			if err != nil {
-				return err
-			}
-
-			log.Printf("getting %q (hash %v)", suite+"/"+path, fh.Hash)
-			r, err := ar.Get("dists/"+suite+"/"+path, h)
-			if err != nil {
-				return err
-			}
-
-			files[idx] = r
-			scanners[idx] = bufio.NewScanner(r)
-			contents[idx], err = parseContentsEntry(scanners[idx])
-			if err != nil {
-				if err == io.EOF {
-					exhausted[idx] = true
-					return nil
-				}
-				return err
-			}
-			advance[idx] = false
-			return nil
+			var err error
+			files[idx], err = readContents(ar, suite, component, arch, hashByFilename, cache)
+			return err
 		})
 	}
-	defer func() {
-		for _, f := range files {
-			if f != nil {
-				f.Close()
+	if err := eg.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	contains := make([]map[string]bool, len(archs))
+	for idx, lines := range files {
+		contains[idx] = make(map[string]bool)
+		for _, line := range lines {
+			for _, e := range line {
+				contains[idx][e.binarypkg] = true
 			}
 		}
-	}()
-	if err := eg.Wait(); err != nil {
-		return nil, err
 	}
 
+	contents := make([][]*contentEntry, len(archs))
+	next := make([]int, len(archs)) // index of the next line of files
+	advance := make([]bool, len(archs))
+	exhausted := make([]bool, len(archs))
+	for idx := range advance {
+		advance[idx] = true
+	}
 	var entries []*contentEntry
 	for {
 		for idx, move := range advance {
 			if !move {
 				continue
 			}
-			var err error
-			contents[idx], err = parseContentsEntry(scanners[idx])
-			if err != nil {
-				if err == io.EOF {
-					exhausted[idx] = true
-				} else {
-					return nil, err
-				}
+			if next[idx] == len(files[idx]) {
+				exhausted[idx] = true
+				continue
 			}
+			contents[idx] = files[idx][next[idx]]
+			next[idx]++
 		}
 		// TODO: unit test for edge cases: can this loop indefinitely or can packages be skipped here?
 		if done(exhausted) {
@@ -135,20 +179,20 @@ func getContents(ar *archive.Getter, suite string, component string, archs []str
 		}
 
 		// find the filename which is the least advanced in the sort order
-		var lowest int
+		lowest := -1
 		var sum int
 		for idx := range archs {
-			sum += len(contents[idx])
 			if exhausted[idx] {
 				continue
 			}
-			if contents[idx][0].filename < contents[lowest][0].filename {
+			sum += len(contents[idx])
+			if lowest == -1 || contentLess(contents[idx][0], contents[lowest][0]) {
 				lowest = idx
 			}
 		}
 
 		for idx := range advance {
-			advance[idx] = !exhausted[idx] && contents[lowest][0].filename == contents[idx][0].filename
+			advance[idx] = !exhausted[idx] && !contentLess(contents[lowest][0], contents[idx][0]) && !contentLess(contents[idx][0], contents[lowest][0])
 		}
 
 		binarypkgs := make(map[string]string, sum)
@@ -170,21 +214,26 @@ func getContents(ar *archive.Getter, suite string, component string, archs []str
 				binarypkg: pkg,
 				arch:      arch,
 				filename:  contents[lowest][0].filename,
+				info:      contents[lowest][0].info,
 				suite:     suite,
 			})
 		}
 	}
 
-	return entries, nil
+	return entries, contains, nil
 }
 
-func getAllContents(ar *archive.Getter, suite string, release *ptarchive.Release, hashByFilename map[string]*control.SHA256FileHash) ([]*contentEntry, error) {
+// getAllContents merges the Contents files of all components and
+// architectures of release. The returned sets contain the binary
+// packages which the Contents file of each component and architecture
+// lists, keyed by e.g. main/amd64.
+func getAllContents(ar *archive.Getter, suite string, components []string, release *ptarchive.Release, hashByFilename map[string]*control.SHA256FileHash, cache *discoveryCache) ([]*contentEntry, map[string]map[string]bool, error) {
 	// We skip archAll, because there is no Contents-all file. The
 	// contents of Architecture: all packages are included in the
 	// architecture-specific Contents-* files.
 
-	var components = [...]string{"main", "contrib"}
 	parts := make([][]*contentEntry, len(components))
+	contains := make(map[string]map[string]bool)
 	var sum int
 	for idx, component := range components {
 		archs := make([]string, len(release.Architectures))
@@ -192,12 +241,15 @@ func getAllContents(ar *archive.Getter, suite string, release *ptarchive.Release
 			archs[idx] = arch.String()
 		}
 
-		part, err := getContents(ar, suite, component, archs, hashByFilename)
+		part, partContains, err := getContents(ar, suite, component, archs, hashByFilename, cache)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		parts[idx] = part
 		sum += len(part)
+		for aidx, arch := range archs {
+			contains[component+"/"+arch] = partContains[aidx]
+		}
 	}
 
 	results := make([]*contentEntry, 0, sum)
@@ -205,5 +257,5 @@ func getAllContents(ar *archive.Getter, suite string, release *ptarchive.Release
 		results = append(results, part...)
 	}
 
-	return results, nil
+	return results, contains, nil
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseContentsEntryInfo(t *testing.T) {
+	const contents = `usr/bin/ls                                              utils/coreutils
+usr/share/info/coreutils.info.gz                        utils/coreutils
+usr/share/info/dir                                      utils/install-info
+usr/share/man/man1/ls.1.gz                              utils/coreutils
+`
+	parse := func() []contentEntry {
+		var entries []contentEntry
+		scanner := bufio.NewScanner(strings.NewReader(contents))
+		for {
+			parsed, err := parseContentsEntry(scanner)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range parsed {
+				entries = append(entries, *e)
+			}
+		}
+		return entries
+	}
+
+	manpage := contentEntry{binarypkg: "coreutils", filename: "man1/ls.1.gz"}
+	if got, want := parse(), []contentEntry{manpage}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("without -render_info: got %+v, want %+v", got, want)
+	}
+
+	old := *renderInfo
+	*renderInfo = true
+	defer func() { *renderInfo = old }()
+	info := contentEntry{binarypkg: "coreutils", filename: "coreutils.info.gz", info: true}
+	if got, want := parse(), []contentEntry{info, manpage}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("with -render_info: got %+v, want %+v", got, want)
+	}
+	if !contentLess(&info, &manpage) || contentLess(&manpage, &info) {
+		t.Fatalf("Info documents do not sort before manpages, like in Contents files")
+	}
+}
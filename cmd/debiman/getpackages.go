@@ -6,8 +6,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"strconv"
 	"strings"
 
@@ -50,7 +48,7 @@ func buildContainsMains(content []*contentEntry) map[string]map[string]bool {
 		}
 		containsMans[entry.binarypkg][entry.arch] = true
 	}
-	log.Printf("%d content entries, %d packages\n", len(content), len(containsMans))
+	infof("%d content entries, %d packages\n", len(content), len(containsMans))
 	return containsMans
 }
 
@@ -65,7 +63,9 @@ var (
 	prefixReplaces = []byte("Replaces")
 )
 
-func parsePackageParagraph(scanner *bufio.Scanner, arch string, containsMans map[string]map[string]bool) (pkgEntry, error) {
+// parsePackageParagraph returns the next package of scanner which is
+// contained in contains, i.e. which ships a manpage (or Info document).
+func parsePackageParagraph(scanner *bufio.Scanner, contains map[string]bool) (pkgEntry, error) {
 	var entry pkgEntry
 	for scanner.Scan() {
 		text := scanner.Bytes()
@@ -120,7 +120,7 @@ func parsePackageParagraph(scanner *bufio.Scanner, arch string, containsMans map
 			entry.filename != "" &&
 			entry.bytes > 0 &&
 			entry.sha256 != nil {
-			if !containsMans[entry.binarypkg][arch] {
+			if !contains[entry.binarypkg] {
 				entry = pkgEntry{}
 				continue
 			}
@@ -157,74 +157,105 @@ func done(exhausted []bool) bool {
 	return true
 }
 
-func getPackages(ar *archive.Getter, suite string, component string, archs []string, hashByFilename map[string]*control.SHA256FileHash, containsMans map[string]map[string]bool) ([]*pkgEntry, map[string]*manpage.PkgMeta, error) {
-	files := make([]*os.File, len(archs))
-	scanners := make([]*bufio.Scanner, len(archs))
-	pkgs := make([]pkgEntry, len(archs))
-	advance := make([]bool, len(archs))
-	exhausted := make([]bool, len(archs))
+// readPackages returns the packages of the Packages file of component
+// and arch which are contained in contains, in the order of the file.
+// contains must be the binary packages listed in the Contents file of
+// component and arch (see getContents). The Packages file is only
+// downloaded and parsed if its result is not in cache.
+func readPackages(ar *archive.Getter, suite, component, arch string, hashByFilename map[string]*control.SHA256FileHash, contains map[string]bool, cache *discoveryCache) ([]pkgEntry, error) {
+	// Prefer gzip over xz because gzip uncompresses faster.
+	path := component + "/binary-" + arch + "/Packages.gz"
+	fh, ok := hashByFilename[path]
+	if !ok {
+		path = component + "/binary-" + arch + "/Packages.xz"
+		fh, ok = hashByFilename[path]
+		if !ok {
+			return nil, fmt.Errorf("ERROR: expected path %q not found in Release file", path)
+		}
+	}
+
+	// The result is filtered by contains, so it also depends on the
+	// Contents file.
+	key := discoveryCacheKey("packages", fh.Hash, indexHash(hashByFilename, contentsPath(component, arch)))
+	if pkgs, ok := cache.loadPackages(key); ok {
+		infof("%q unchanged, using the result of a previous run", suite+"/"+path)
+		return pkgs, nil
+	}
+
+	infof("getting %q (hash %v)", suite+"/"+path, fh.Hash)
+	r, err := ar.GetIndex("dists/"+suite, path, hashByFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	var pkgs []pkgEntry
+	for {
+		p, err := parsePackageParagraph(scanner, contains)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	cache.storePackages(key, pkgs)
+	return pkgs, nil
+}
+
+// getPackages merges the Packages files of component for all archs,
+// picking the newest version of each package which ships manpages in
+// the architecture containsMans attributes them to. contains are the
+// binary packages listed in the Contents file of each arch.
+func getPackages(ar *archive.Getter, suite string, component string, archs []string, hashByFilename map[string]*control.SHA256FileHash, containsMans map[string]map[string]bool, contains []map[string]bool, cache *discoveryCache) ([]*pkgEntry, map[string]*manpage.PkgMeta, error) {
+	files := make([][]pkgEntry, len(archs))
 	var eg errgroup.Group
 	for idx, arch := range archs {
 		idx := idx   // copy
 		arch := arch // copy
 		eg.Go(func() error {
-			// Prefer gzip over xz because gzip uncompresses faster.
-			path := component + "/binary-" + arch + "/Packages.gz"
-			fh, ok := hashByFilename[path]
-			if !ok {
-				path = component + "/binary-" + arch + "/Packages.xz"
-				fh, ok = hashByFilename[path]
-				if !ok {
-					return fmt.Errorf("ERROR: expected path %q not found in Release file", path)
-				}
-			}
-
-			h, err := hex.DecodeString(fh.Hash)
+			parsed, err := readPackages(ar, suite, component, arch, hashByFilename, contains[idx], cache)
 			if err != nil {
 				return err
 			}
-
-			log.Printf("getting %q (hash %v)", suite+"/"+path, fh.Hash)
-			r, err := ar.Get("dists/"+suite+"/"+path, h)
-			if err != nil {
-				return err
+			// Merging the Contents files attributes the manpages of a
+			// package to only one of the architectures whose Contents
+			// files list it.
+			for _, p := range parsed {
+				if !containsMans[p.binarypkg][arch] {
+					continue
+				}
+				p.arch = arch
+				p.suite = suite
+				files[idx] = append(files[idx], p)
 			}
-
-			files[idx] = r
-			scanners[idx] = bufio.NewScanner(r)
-			advance[idx] = true
 			return nil
 		})
 	}
-	defer func() {
-		for _, f := range files {
-			if f != nil {
-				f.Close()
-			}
-		}
-	}()
 	if err := eg.Wait(); err != nil {
 		return nil, nil, err
 	}
 
+	pkgs := make([]pkgEntry, len(archs))
+	next := make([]int, len(archs)) // index of the next package of files
+	advance := make([]bool, len(archs))
+	exhausted := make([]bool, len(archs))
+	for idx := range advance {
+		advance[idx] = true
+	}
 	byVersion := make(map[string]*pkgEntry)
 	for {
 		for idx, move := range advance {
 			if !move {
 				continue
 			}
-			arch := archs[idx]
-			p, err := parsePackageParagraph(scanners[idx], arch, containsMans)
-			if err != nil {
-				if err == io.EOF {
-					exhausted[idx] = true
-				} else {
-					return nil, nil, err
-				}
+			if next[idx] == len(files[idx]) {
+				exhausted[idx] = true
+				continue
 			}
-			p.arch = arch
-			p.suite = suite
-			pkgs[idx] = p
+			pkgs[idx] = files[idx][next[idx]]
+			next[idx]++
 		}
 		// TODO: unit test for edge cases: can this loop indefinitely or can packages be skipped here?
 		if done(exhausted) {
@@ -316,8 +347,11 @@ func getPackages(ar *archive.Getter, suite string, component string, archs []str
 	return result, latestVersion, nil
 }
 
-func getAllPackages(ar *archive.Getter, suite string, release *ptarchive.Release, hashByFilename map[string]*control.SHA256FileHash, containsMans map[string]map[string]bool) ([]*pkgEntry, map[string]*manpage.PkgMeta, error) {
-	var components = [...]string{"main", "contrib"}
+// getAllPackages merges the Packages files of all components and
+// architectures of release. contains are the binary packages listed in
+// the Contents file of each component and architecture (see
+// getAllContents).
+func getAllPackages(ar *archive.Getter, suite string, components []string, release *ptarchive.Release, hashByFilename map[string]*control.SHA256FileHash, containsMans map[string]map[string]bool, contains map[string]map[string]bool, cache *discoveryCache) ([]*pkgEntry, map[string]*manpage.PkgMeta, error) {
 	partsp := make([][]*pkgEntry, len(components))
 	partsl := make([]map[string]*manpage.PkgMeta, len(components))
 	latestVersion := make(map[string]*manpage.PkgMeta)
@@ -327,7 +361,11 @@ func getAllPackages(ar *archive.Getter, suite string, release *ptarchive.Release
 		for idx, arch := range release.Architectures {
 			archs[idx] = arch.String()
 		}
-		partp, partl, err := getPackages(ar, suite, component, archs, hashByFilename, containsMans)
+		archContains := make([]map[string]bool, len(archs))
+		for idx, arch := range archs {
+			archContains[idx] = contains[component+"/"+arch]
+		}
+		partp, partl, err := getPackages(ar, suite, component, archs, hashByFilename, containsMans, archContains, cache)
 		if err != nil {
 			return nil, nil, err
 		}
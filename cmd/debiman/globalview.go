@@ -2,13 +2,15 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Debian/debiman/internal/archive"
 	"github.com/Debian/debiman/internal/manpage"
+	ptarchive "pault.ag/go/archive"
 	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
 )
 
 // mostPopularArchitecture is used as preferred architecture when we
@@ -18,12 +20,63 @@ import (
 const mostPopularArchitecture = "amd64"
 
 type stats struct {
-	PackagesExtracted uint64
-	PackagesDeleted   uint64
-	ManpagesRendered  uint64
-	ManpageBytes      uint64
-	HtmlBytes         uint64
-	IndexBytes        uint64
+	PackagesExtracted       uint64
+	PackagesDeleted         uint64
+	ManpagesRendered        uint64
+	DisambiguationsRendered uint64
+	MandocWarnings          uint64
+	DanglingReferences      uint64
+	ConversionTimeouts      uint64
+	ManpageBytes            uint64
+	HtmlBytes               uint64
+	BrotliBytes             uint64
+	ZstdBytes               uint64
+	IndexBytes              uint64
+	HardlinkedFiles         uint64
+	HardlinkedBytes         uint64
+
+	// Suites contains the per-suite breakdown of ManpagesRendered,
+	// ManpageBytes, HtmlBytes, BrotliBytes and ZstdBytes, which are
+	// updated per suite while rendering and summed up by accumulate
+	// afterwards. Suites is populated in buildGlobalView and not
+	// modified afterwards, so it can be read without locking.
+	Suites map[string]*suiteStats
+}
+
+// suiteStats are the stats of a single suite. All fields are accessed
+// atomically.
+type suiteStats struct {
+	ManpagesRendered uint64
+	ManpageBytes     uint64
+	HtmlBytes        uint64
+	BrotliBytes      uint64
+	ZstdBytes        uint64
+}
+
+// suite returns the stats of the specified suite. Stats of suites which
+// were not discovered in buildGlobalView are discarded.
+func (s *stats) suite(name string) *suiteStats {
+	if ss, ok := s.Suites[name]; ok {
+		return ss
+	}
+	return &suiteStats{}
+}
+
+// accumulate sets the totals of the per-suite stats.
+func (s *stats) accumulate() {
+	var rendered, manpageBytes, htmlBytes, brotliBytes, zstdBytes uint64
+	for _, ss := range s.Suites {
+		rendered += atomic.LoadUint64(&ss.ManpagesRendered)
+		manpageBytes += atomic.LoadUint64(&ss.ManpageBytes)
+		htmlBytes += atomic.LoadUint64(&ss.HtmlBytes)
+		brotliBytes += atomic.LoadUint64(&ss.BrotliBytes)
+		zstdBytes += atomic.LoadUint64(&ss.ZstdBytes)
+	}
+	atomic.StoreUint64(&s.ManpagesRendered, rendered)
+	atomic.StoreUint64(&s.ManpageBytes, manpageBytes)
+	atomic.StoreUint64(&s.HtmlBytes, htmlBytes)
+	atomic.StoreUint64(&s.BrotliBytes, brotliBytes)
+	atomic.StoreUint64(&s.ZstdBytes, zstdBytes)
 }
 
 type globalView struct {
@@ -33,7 +86,53 @@ type globalView struct {
 	contentByPath map[string][]*contentEntry
 	xref          map[string][]*manpage.Meta
 	stats         *stats
+	lint          *lintReport
 	start         time.Time
+
+	// refs collects the SEE ALSO references of the rendered manpages.
+	// nil unless -backlinks is set.
+	refs *referenceGraph
+
+	// whatis collects the NAME sections of the rendered manpages. nil
+	// unless -whatis is set.
+	whatis *whatisDB
+
+	// search collects the search documents of the rendered manpages.
+	// nil unless -search_index is set.
+	search *searchIndexer
+
+	// feeds collects the new and changed manpages for the Atom feeds.
+	// nil unless -atom_feed_entries is non-zero.
+	feeds *feedRecorder
+
+	// epubs collects the -render_epub books to assemble once all
+	// manpages were rendered. nil unless rendering.
+	epubs *epubQueue
+
+	// journal records the completely rendered binary packages. nil
+	// unless rendering without -since.
+	journal *renderJournal
+
+	// since is the -since cutoff. If non-zero, sources which were
+	// last modified before since are not rendered.
+	since time.Time
+
+	// getters contains the archive getters of the suites of
+	// -federated_distributions. Other suites use the default getter.
+	getters map[string]*archive.Getter
+
+	// prefixes maps the suites of -federated_distributions to the
+	// prefix of their distribution.
+	prefixes map[string]string
+}
+
+// getter returns the archive getter to use for downloading packages of
+// suite.
+func (gv globalView) getter(suite string, fallback *archive.Getter) *archive.Getter {
+	if ar, ok := gv.getters[suite]; ok {
+		return ar
+	}
+	return fallback
 }
 
 type distributionIdentifier int
@@ -46,6 +145,20 @@ const (
 type distribution struct {
 	name       string
 	identifier distributionIdentifier
+
+	// prefix and ar are only set for -federated_distributions.
+	prefix string
+	ar     *archive.Getter
+
+	// components and architectures restrict the suite to the specified
+	// components (instead of -components) and architectures (instead
+	// of all architectures of the suite), if non-nil.
+	components    []string
+	architectures []string
+
+	// format is the repository format (formatDebian, formatPacman or
+	// formatRPM). Empty means formatDebian.
+	format string
 }
 
 // distributions returns a list of all distributions (either codenames
@@ -74,34 +187,176 @@ func distributions(codenames []string, suites []string) []distribution {
 	return distributions
 }
 
-func buildGlobalView(ar *archive.Getter, dists []distribution, start time.Time) (globalView, error) {
-	var stats stats
+// newGlobalView returns an empty globalView for numSuites suites.
+func newGlobalView(numSuites int, start time.Time) globalView {
+	stats := stats{
+		Suites: make(map[string]*suiteStats, numSuites),
+	}
 	res := globalView{
-		suites:        make(map[string]bool, len(dists)),
-		idxSuites:     make(map[string]string, len(dists)),
+		suites:        make(map[string]bool, numSuites),
+		idxSuites:     make(map[string]string, numSuites),
 		contentByPath: make(map[string][]*contentEntry),
 		xref:          make(map[string][]*manpage.Meta),
 		stats:         &stats,
 		start:         start,
+		getters:       make(map[string]*archive.Getter),
+		prefixes:      make(map[string]string),
 	}
+	if *collectWarnings {
+		res.lint = newLintReport(&stats)
+	}
+	if *collectBacklinks {
+		res.refs = newReferenceGraph(&stats)
+	}
+	if *writeWhatis {
+		res.whatis = newWhatisDB()
+	}
+	if *buildSearchIndex {
+		res.search = newSearchIndexer()
+	}
+	if *atomFeedEntries > 0 {
+		res.feeds = newFeedRecorder(start)
+	}
+	return res
+}
+
+// releaseArchitectures returns the architectures of release which are
+// contained in wanted, or all architectures of release if wanted is
+// nil.
+func releaseArchitectures(release *ptarchive.Release, wanted []string) []dependency.Arch {
+	if wanted == nil {
+		return release.Architectures
+	}
+	want := make(map[string]bool, len(wanted))
+	for _, arch := range wanted {
+		want[arch] = true
+	}
+	var result []dependency.Arch
+	for _, arch := range release.Architectures {
+		if want[arch.String()] {
+			result = append(result, arch)
+		}
+	}
+	return result
+}
+
+// releaseComponents returns the components of wanted (see -components)
+// which the suite of release contains, in the order of wanted.
+func releaseComponents(release *ptarchive.Release, wanted []string) []string {
+	contained := make(map[string]bool, len(release.Components))
+	for _, component := range release.Components {
+		contained[component] = true
+	}
+	var result []string
+	for _, component := range wanted {
+		component = strings.TrimSpace(component)
+		if component == "" {
+			continue
+		}
+		if !contained[component] {
+			infof("suite %q does not contain component %q, skipping", release.Suite, component)
+			continue
+		}
+		result = append(result, component)
+	}
+	return result
+}
 
+// addFederatedSuite adds suite of the -federated_distributions
+// distribution dist, which is downloaded using ar. The suite is also
+// known as dist.name and aliases (prefixed with dist.prefix).
+func (gv globalView) addFederatedSuite(suite string, dist distribution, ar *archive.Getter, aliases ...string) {
+	addSortOrder(suite)
+	gv.getters[suite] = ar
+	gv.prefixes[suite] = dist.prefix
+	for _, alias := range append([]string{dist.name}, aliases...) {
+		gv.idxSuites[dist.prefix+"-"+alias] = suite
+	}
+	gv.suites[suite] = true
+	gv.stats.Suites[suite] = &suiteStats{}
+}
+
+// indexPackages returns the manpages, packages and package versions of
+// a suite which is not in Debian format, given its packages by binary
+// package name and the manpage paths (e.g.
+// usr/share/man/man1/bash.1.gz) of each binary package.
+func indexPackages(suite string, byName map[string]*pkgEntry, manpages map[string][]string) ([]*contentEntry, []*pkgEntry, map[string]*manpage.PkgMeta) {
+	var (
+		content []*contentEntry
+		pkgs    []*pkgEntry
+	)
+	latestVersion := make(map[string]*manpage.PkgMeta, len(byName))
+	for name, p := range byName {
+		pkgs = append(pkgs, p)
+		latestVersion[suite+"/"+name] = &manpage.PkgMeta{
+			Replaces:  p.replaces,
+			Binarypkg: p.binarypkg,
+			Suite:     p.suite,
+			Version:   p.version,
+		}
+		for _, fn := range manpages[name] {
+			content = append(content, &contentEntry{
+				suite:     suite,
+				arch:      p.arch,
+				binarypkg: p.binarypkg,
+				filename:  fn,
+			})
+		}
+	}
+	return content, pkgs, latestVersion
+}
+
+func buildGlobalView(ar *archive.Getter, dists []distribution, start time.Time) (globalView, error) {
+	res := newGlobalView(len(dists), start)
 	for _, dist := range dists {
+		ar := ar // copy
+		if dist.ar != nil {
+			ar = dist.ar
+		}
+		if dist.format == formatPacman || dist.format == formatRPM {
+			suite := dist.prefix + "-" + dist.name
+			res.addFederatedSuite(suite, dist, ar)
+			getRepo := getPacmanRepo
+			if dist.format == formatRPM {
+				getRepo = getRPMRepo
+			}
+			content, pkgs, latestVersion, err := getRepo(ar, dist, suite)
+			if err != nil {
+				return res, err
+			}
+			for _, c := range content {
+				res.contentByPath[c.filename] = append(res.contentByPath[c.filename], c)
+			}
+			res.pkgs = append(res.pkgs, pkgs...)
+			addXrefs(res.xref, content, latestVersion)
+			continue
+		}
+
 		release, err := ar.GetRelease(dist.name)
 		if err != nil {
 			return res, err
 		}
 
-		var suite string
+		// archiveSuite is the name of the suite in the archive, suite
+		// the name under which it is served.
+		var archiveSuite string
 		if dist.identifier == fromCodename {
-			suite = release.Codename
+			archiveSuite = release.Codename
 		} else {
-			suite = release.Suite
+			archiveSuite = release.Suite
 		}
+		suite := archiveSuite
 
-		res.suites[suite] = true
-		res.idxSuites[release.Suite] = suite
-		res.idxSuites[release.Codename] = suite
-		res.idxSuites[dist.name] = suite
+		if dist.prefix != "" {
+			suite = dist.prefix + "-" + archiveSuite
+			res.addFederatedSuite(suite, dist, ar, release.Suite, release.Codename)
+		} else {
+			res.idxSuites[release.Suite] = suite
+			res.idxSuites[release.Codename] = suite
+			res.idxSuites[dist.name] = suite
+			res.suites[suite] = true
+			res.stats.Suites[suite] = &suiteStats{}
+		}
 
 		hashByFilename := make(map[string]*control.SHA256FileHash, len(release.SHA256))
 		for idx, fh := range release.SHA256 {
@@ -109,69 +364,92 @@ func buildGlobalView(ar *archive.Getter, dists []distribution, start time.Time)
 			hashByFilename[fh.Filename] = &(release.SHA256[idx])
 		}
 
-		content, err := getAllContents(ar, suite, release, hashByFilename)
+		wanted := dist.components
+		if wanted == nil {
+			wanted = strings.Split(*components, ",")
+		}
+		components := releaseComponents(release, wanted)
+		release.Architectures = releaseArchitectures(release, dist.architectures)
+		if len(release.Architectures) == 0 {
+			return res, fmt.Errorf("suite %q contains none of the architectures %q", suite, dist.architectures)
+		}
+		cache := newDiscoveryCache(suite)
+		content, contains, err := getAllContents(ar, archiveSuite, components, release, hashByFilename, cache)
 		if err != nil {
 			return res, err
 		}
 
+		// Collect package download work units
+		pkgs, latestVersion, err := getAllPackages(ar, archiveSuite, components, release, hashByFilename, buildContainsMains(content), contains, cache)
+		if err != nil {
+			return res, err
+		}
+		if suite != archiveSuite {
+			latestVersion = serveAs(suite, content, pkgs, latestVersion)
+		}
+		if err := cache.prune(); err != nil {
+			warningf("pruning the cached index files of suite %q: %v", suite, err)
+		}
+
 		for _, c := range content {
+			if c.info {
+				continue
+			}
 			res.contentByPath[c.filename] = append(res.contentByPath[c.filename], c)
 		}
 
-		var latestVersion map[string]*manpage.PkgMeta
-		{
-			// Collect package download work units
-			var pkgs []*pkgEntry
-			var err error
-			pkgs, latestVersion, err = getAllPackages(ar, suite, release, hashByFilename, buildContainsMains(content))
-			if err != nil {
-				return res, err
-			}
+		infof("Adding %d packages from suite %q", len(pkgs), suite)
+		res.pkgs = append(res.pkgs, pkgs...)
 
-			log.Printf("Adding %d packages from suite %q", len(pkgs), suite)
-			res.pkgs = append(res.pkgs, pkgs...)
-		}
+		addXrefs(res.xref, content, latestVersion)
+	}
+	return res, nil
+}
 
-		knownIssues := make(map[string][]error)
+// addXrefs adds the manpages in content, whose packages’ versions are
+// contained in latestVersion, to xref (required for cross-referencing).
+func addXrefs(xref map[string][]*manpage.Meta, content []*contentEntry, latestVersion map[string]*manpage.PkgMeta) {
+	knownIssues := make(map[string][]error)
 
-		// Build a global view of all the manpages (required for cross-referencing).
-		// TODO(issue): edge case: packages which got renamed between releases
-		for _, c := range content {
-			if _, ok := latestVersion[c.suite+"/"+c.binarypkg]; !ok {
-				key := c.suite + "/" + c.binarypkg
-				knownIssues[key] = append(knownIssues[key],
-					fmt.Errorf("Could not determine latest version"))
-				continue
-			}
-			m, err := manpage.FromManPath(strings.TrimPrefix(c.filename, "usr/share/man/"), latestVersion[c.suite+"/"+c.binarypkg])
-			if err != nil {
-				key := c.suite + "/" + c.binarypkg
-				knownIssues[key] = append(knownIssues[key],
-					fmt.Errorf("Trying to interpret path %q: %v", c.filename, err))
-				continue
-			}
-			// NOTE(stapelberg): this additional verification step
-			// is necessary because manpages such as the French
-			// manpage for qelectrotech(1) are present in multiple
-			// encodings. manpageFromManPath ignores encodings, so
-			// if we didn’t filter, we would end up with what
-			// looks like duplicates.
-			present := false
-			for _, x := range res.xref[m.Name] {
-				if x.ServingPath() == m.ServingPath() {
-					present = true
-					break
-				}
-			}
-			if !present {
-				res.xref[m.Name] = append(res.xref[m.Name], m)
+	// Build a global view of all the manpages (required for cross-referencing).
+	// TODO(issue): edge case: packages which got renamed between releases
+	for _, c := range content {
+		if c.info {
+			continue
+		}
+		if _, ok := latestVersion[c.suite+"/"+c.binarypkg]; !ok {
+			key := c.suite + "/" + c.binarypkg
+			knownIssues[key] = append(knownIssues[key],
+				fmt.Errorf("Could not determine latest version"))
+			continue
+		}
+		m, err := manpage.FromManPath(strings.TrimPrefix(c.filename, "usr/share/man/"), latestVersion[c.suite+"/"+c.binarypkg])
+		if err != nil {
+			key := c.suite + "/" + c.binarypkg
+			knownIssues[key] = append(knownIssues[key],
+				fmt.Errorf("Trying to interpret path %q: %v", c.filename, err))
+			continue
+		}
+		// NOTE(stapelberg): this additional verification step
+		// is necessary because manpages such as the French
+		// manpage for qelectrotech(1) are present in multiple
+		// encodings. manpageFromManPath ignores encodings, so
+		// if we didn’t filter, we would end up with what
+		// looks like duplicates.
+		present := false
+		for _, x := range xref[m.Name] {
+			if x.ServingPath() == m.ServingPath() {
+				present = true
+				break
 			}
 		}
-
-		for key, errors := range knownIssues {
-			// TODO: write these to a known-issues file, parse bug numbers from an auxilliary file
-			log.Printf("package %q has errors: %v", key, errors)
+		if !present {
+			xref[m.Name] = append(xref[m.Name], m)
 		}
 	}
-	return res, nil
+
+	for key, errors := range knownIssues {
+		// TODO: write these to a known-issues file, parse bug numbers from an auxilliary file
+		infof("package %q has errors: %v", key, errors)
+	}
 }
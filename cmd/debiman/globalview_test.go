@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	ptarchive "pault.ag/go/archive"
+	"pault.ag/go/debian/dependency"
+)
+
+func TestReleaseComponents(t *testing.T) {
+	release := &ptarchive.Release{
+		Suite:      "stable",
+		Components: []string{"main", "contrib", "non-free-firmware", "non-free"},
+	}
+	got := releaseComponents(release, []string{"main", " non-free-firmware", "universe", ""})
+	want := []string{"main", "non-free-firmware"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("releaseComponents: got %q, want %q", got, want)
+	}
+}
+
+func TestReleaseArchitectures(t *testing.T) {
+	var archs []dependency.Arch
+	for _, arch := range []string{"amd64", "armhf", "i386"} {
+		a, err := dependency.ParseArch(arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archs = append(archs, *a)
+	}
+	release := &ptarchive.Release{Architectures: archs}
+	if got := releaseArchitectures(release, nil); !reflect.DeepEqual(got, archs) {
+		t.Fatalf("releaseArchitectures(nil): got %v, want %v", got, archs)
+	}
+	got := releaseArchitectures(release, []string{"armhf", "arm64"})
+	if want := archs[1:2]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("releaseArchitectures: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+var hardlinkDuplicates = flag.Bool("hardlink_identical",
+	false,
+	"After rendering, replace manpages and rendered outputs which are byte-identical across suites (e.g. stable and testing) with hard links to a single copy, to reduce disk usage")
+
+// hardlinkIdenticalFiles replaces identical files at the same
+// <binarypkg>/<file> path in different suites with hard links to a
+// single copy. Only compressed manpages (.gz) and their rendered
+// outputs (.html.gz etc.) are considered.
+//
+// Since hard links share their modification time, the copy whose
+// modification time keeps the rendering invariant (rendered outputs
+// are newer than their manpages) in all suites is linked to: for
+// manpages the oldest, for rendered outputs the newest. Files are
+// replaced atomically, and as debiman always writes files by renaming
+// new files into place, re-rendering a page in one suite does not
+// affect the others.
+func hardlinkIdenticalFiles(suites map[string]bool, st *stats) error {
+	var suiteNames []string
+	for suite := range suites {
+		suiteNames = append(suiteNames, suite)
+	}
+	if len(suiteNames) < 2 {
+		return nil
+	}
+	sort.Strings(suiteNames)
+
+	binarypkgs := make(map[string]bool)
+	for _, suite := range suiteNames {
+		names, err := readdirnames(filepath.Join(*servingDir, suite))
+		if err != nil {
+			return err
+		}
+		for _, bfn := range names {
+			binarypkgs[bfn] = true
+		}
+	}
+
+	for binarypkg := range binarypkgs {
+		paths := make(map[string][]string)
+		for _, suite := range suiteNames {
+			dir := filepath.Join(*servingDir, suite, binarypkg)
+			names, err := readdirnames(dir)
+			if err != nil {
+				return err
+			}
+			for _, fn := range names {
+				if !strings.HasSuffix(fn, ".gz") {
+					continue
+				}
+				paths[fn] = append(paths[fn], filepath.Join(dir, fn))
+			}
+		}
+		for fn, p := range paths {
+			if len(p) < 2 {
+				continue
+			}
+			if err := hardlinkIdentical(p, isRenderedOutput(fn), st); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readdirnames is like os.File.Readdirnames, but returns no names
+// (instead of an error) if dir does not exist or is not a directory.
+func readdirnames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, nil
+	}
+	return f.Readdirnames(-1)
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// hardlinkIdentical links all files in paths which have identical
+// contents to the same copy.
+func hardlinkIdentical(paths []string, newest bool, st *stats) error {
+	bySize := make(map[int64][]os.FileInfo)
+	pathOf := make(map[os.FileInfo]string)
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			continue // e.g. symlinks to other manpages
+		}
+		bySize[fi.Size()] = append(bySize[fi.Size()], fi)
+		pathOf[fi] = path
+	}
+
+	for _, fis := range bySize {
+		if len(fis) < 2 {
+			continue
+		}
+		byHash := make(map[string][]os.FileInfo)
+		hashOf := make(map[os.FileInfo]string)
+	Hash:
+		for _, fi := range fis {
+			// Files which were linked in a previous run are hashed
+			// only once.
+			for prev, h := range hashOf {
+				if os.SameFile(prev, fi) {
+					byHash[h] = append(byHash[h], fi)
+					continue Hash
+				}
+			}
+			h, err := fileHash(pathOf[fi])
+			if err != nil {
+				return err
+			}
+			hashOf[fi] = h
+			byHash[h] = append(byHash[h], fi)
+		}
+		for _, identical := range byHash {
+			if len(identical) < 2 {
+				continue
+			}
+			target := identical[0]
+			for _, fi := range identical[1:] {
+				if newest && fi.ModTime().After(target.ModTime()) ||
+					!newest && fi.ModTime().Before(target.ModTime()) {
+					target = fi
+				}
+			}
+			for _, fi := range identical {
+				if os.SameFile(fi, target) {
+					continue
+				}
+				if err := replaceWithLink(pathOf[target], pathOf[fi]); err != nil {
+					return err
+				}
+				atomic.AddUint64(&st.HardlinkedFiles, 1)
+				atomic.AddUint64(&st.HardlinkedBytes, uint64(fi.Size()))
+			}
+		}
+	}
+	return nil
+}
+
+// replaceWithLink atomically replaces path with a hard link to target.
+func replaceWithLink(target, path string) error {
+	// The temporary name must not end in .gz, lest it be mistaken for
+	// a manpage if debiman is interrupted.
+	tmp := filepath.Join(filepath.Dir(path), ".debiman-link-"+filepath.Base(path)+".tmp")
+	if err := os.Link(target, tmp); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// Left-over from an interrupted run.
+		if err := os.Remove(tmp); err != nil {
+			return err
+		}
+		if err := os.Link(target, tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replacing %q with a hard link to %q: %v", path, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHardlinkIdenticalFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-hardlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now().Add(-1 * time.Hour)
+	for _, f := range []struct {
+		path    string
+		content string
+		modTime time.Time
+	}{
+		{"jessie/i3-wm/i3.1.en.gz", "manpage", old},
+		{"stretch/i3-wm/i3.1.en.gz", "manpage", recent},
+		{"jessie/i3-wm/i3.1.en.html.gz", "html", old},
+		{"stretch/i3-wm/i3.1.en.html.gz", "html", recent},
+		{"jessie/i3-wm/i3-msg.1.en.gz", "old manpage", old},
+		{"stretch/i3-wm/i3-msg.1.en.gz", "new manpage", recent},
+	} {
+		path := filepath.Join(tmpdir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var st stats
+	suites := map[string]bool{"jessie": true, "stretch": true}
+	if err := hardlinkIdenticalFiles(suites, &st); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.HardlinkedFiles, uint64(2); got != want {
+		t.Fatalf("unexpected number of hard linked files: got %d, want %d", got, want)
+	}
+
+	for _, tt := range []struct {
+		fn      string
+		linked  bool
+		modTime time.Time
+	}{
+		{"i3.1.en.gz", true, old},         // manpages keep the oldest mtime
+		{"i3.1.en.html.gz", true, recent}, // rendered outputs the newest
+		{"i3-msg.1.en.gz", false, recent},
+	} {
+		jessie, err := os.Stat(filepath.Join(tmpdir, "jessie", "i3-wm", tt.fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stretch, err := os.Stat(filepath.Join(tmpdir, "stretch", "i3-wm", tt.fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := os.SameFile(jessie, stretch), tt.linked; got != want {
+			t.Errorf("%s: hard linked = %v, want %v", tt.fn, got, want)
+		}
+		if got, want := stretch.ModTime(), tt.modTime; !got.Equal(want) {
+			t.Errorf("%s: unexpected modification time: got %v, want %v", tt.fn, got, want)
+		}
+	}
+
+	// A second run finds nothing left to do.
+	st = stats{}
+	if err := hardlinkIdenticalFiles(suites, &st); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.HardlinkedFiles, uint64(0); got != want {
+		t.Fatalf("unexpected number of hard linked files in second run: got %d, want %d", got, want)
+	}
+}
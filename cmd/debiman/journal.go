@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var resumeRender = flag.Bool("resume",
+	true,
+	"Skip the binary packages which an interrupted previous run completely rendered (as recorded in <serving_dir>/.render-journal), instead of checking each of their files again. Ignored with -force_rerender and -since.")
+
+// renderJournalName is the name of the journal file within -serving_dir.
+// It only exists while (or after an interrupted) render phase.
+const renderJournalName = ".render-journal"
+
+func renderJournalPath() string {
+	return filepath.Join(*servingDir, renderJournalName)
+}
+
+// journalEntry records that a binary package directory was completely
+// rendered.
+type journalEntry struct {
+	// dirModTime is the modification time of the directory once all of
+	// its files were rendered. Extracting a new version of the package
+	// (or rendering a file) changes it, invalidating the entry.
+	dirModTime time.Time
+
+	// newestModTime is the modification time of the newest manpage of
+	// the package, which the sitemap lists.
+	newestModTime time.Time
+}
+
+// renderJournal records which binary package directories the render
+// phase completely rendered, so that a run which was interrupted can
+// skip them when resuming instead of stat(2)ing all of their files.
+//
+// The journal is removed once the render phase succeeds. A nil
+// *renderJournal records and skips nothing.
+type renderJournal struct {
+	mu sync.Mutex
+	f  *os.File
+
+	// done contains the entries of the interrupted run, keyed by the
+	// directory relative to -serving_dir (<suite>/<binarypkg>).
+	done map[string]journalEntry
+}
+
+// parseJournalEntry parses a line written by renderJournal.record.
+func parseJournalEntry(line string) (string, journalEntry, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return "", journalEntry{}, fmt.Errorf("malformed journal line %q", line)
+	}
+	dirModTime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", journalEntry{}, err
+	}
+	newestModTime, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", journalEntry{}, err
+	}
+	entry := journalEntry{dirModTime: time.Unix(0, dirModTime)}
+	if newestModTime != 0 {
+		entry.newestModTime = time.Unix(0, newestModTime)
+	}
+	return parts[2], entry, nil
+}
+
+// loadRenderJournal returns the entries of the journal at path, if any.
+func loadRenderJournal(path string) (map[string]journalEntry, error) {
+	done := make(map[string]journalEntry)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return done, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rel, entry, err := parseJournalEntry(scanner.Text())
+		if err != nil {
+			// e.g. the last line, if debiman was killed while writing it.
+			debugf("skipping journal line: %v", err)
+			continue
+		}
+		// Later lines supersede earlier ones.
+		done[rel] = entry
+	}
+	return done, scanner.Err()
+}
+
+// openRenderJournal opens the journal of the render phase, resuming an
+// interrupted run (see -resume). It returns nil if no journal should be
+// kept, i.e. with -since, which deliberately leaves outdated files.
+func openRenderJournal(since time.Time) (*renderJournal, error) {
+	if !since.IsZero() {
+		return nil, nil
+	}
+	path := renderJournalPath()
+	j := &renderJournal{}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if *resumeRender && !*forceRerender {
+		var err error
+		if j.done, err = loadRenderJournal(path); err != nil {
+			return nil, err
+		}
+		if len(j.done) > 0 {
+			infof("Resuming interrupted run: %d packages were already rendered according to %q", len(j.done), path)
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+	return j, nil
+}
+
+// skip returns whether the binary package directory rel (relative to
+// -serving_dir) was completely rendered by the interrupted run and was
+// not modified since, and if so, the modification time of its newest
+// manpage.
+func (j *renderJournal) skip(rel string) (time.Time, bool) {
+	if j == nil {
+		return time.Time{}, false
+	}
+	entry, ok := j.done[rel]
+	if !ok {
+		return time.Time{}, false
+	}
+	dir := filepath.Join(*servingDir, rel)
+	st, err := os.Stat(dir)
+	if err != nil || !st.ModTime().Equal(entry.dirModTime) {
+		return time.Time{}, false
+	}
+	if *renderEPUB && epubStale(dir, entry.newestModTime) {
+		// The books are assembled after all manpages were rendered.
+		return time.Time{}, false
+	}
+	return entry.newestModTime, true
+}
+
+// record appends an entry for the completely rendered binary package
+// directory rel (relative to -serving_dir) to the journal.
+func (j *renderJournal) record(rel string, newestModTime time.Time) {
+	if j == nil {
+		return
+	}
+	st, err := os.Stat(filepath.Join(*servingDir, rel))
+	if err != nil {
+		warningf("not recording %q in the journal: %v", rel, err)
+		return
+	}
+	var newest int64
+	if !newestModTime.IsZero() {
+		newest = newestModTime.UnixNano()
+	}
+	// Each line is written with a single write(2), so that lines of
+	// concurrently rendered packages cannot interleave.
+	line := fmt.Sprintf("%d %d %s\n", st.ModTime().UnixNano(), newest, rel)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.WriteString(line); err != nil {
+		warningf("writing journal: %v", err)
+	}
+}
+
+// close closes the journal. If the render phase succeeded, the journal is
+// removed, so that the next run checks all files again.
+func (j *renderJournal) close(succeeded bool) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if !succeeded {
+		return nil
+	}
+	return os.Remove(j.f.Name())
+}
+
+// packageJobs counts the outstanding work of a binary package directory:
+// the walk of the directory itself and the render jobs which were sent
+// for it. Once all of them are done, onDone is called.
+//
+// A nil *packageJobs tracks nothing.
+type packageJobs struct {
+	pending int64
+	onDone  func()
+}
+
+func newPackageJobs(onDone func()) *packageJobs {
+	return &packageJobs{pending: 1, onDone: onDone}
+}
+
+func (p *packageJobs) add() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.pending, 1)
+}
+
+func (p *packageJobs) done() {
+	if p == nil {
+		return
+	}
+	if atomic.AddInt64(&p.pending, -1) == 0 {
+		p.onDone()
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderJournal(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+
+	for _, dir := range []string{"testing/i3-wm", "testing/i3lock", "testing/oldtool"} {
+		if err := os.MkdirAll(filepath.Join(tmpdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newest := time.Unix(1484816329, 0)
+	j, err := openRenderJournal(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record("testing/i3-wm", newest)
+	j.record("testing/i3lock", time.Time{})
+	// Simulate being killed while writing a line.
+	if _, err := j.f.WriteString("12345 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Extracting a new version of i3lock modifies its directory.
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "testing/i3lock/i3lock.1.en.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = openRenderJournal(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := j.skip("testing/i3-wm"); !ok || !got.Equal(newest) {
+		t.Fatalf("skip(testing/i3-wm) = %v, %v, want %v, true", got, ok, newest)
+	}
+	if _, ok := j.skip("testing/i3lock"); ok {
+		t.Fatalf("skip(testing/i3lock) unexpectedly true after its directory was modified")
+	}
+	if _, ok := j.skip("testing/oldtool"); ok {
+		t.Fatalf("skip(testing/oldtool) unexpectedly true for a package which was not recorded")
+	}
+	if err := j.close(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(renderJournalPath()); !os.IsNotExist(err) {
+		t.Fatalf("journal unexpectedly not removed after success: %v", err)
+	}
+
+	// With -since, no journal is kept.
+	j, err = openRenderJournal(newest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatalf("openRenderJournal unexpectedly returned a journal with -since")
+	}
+}
+
+func TestPackageJobs(t *testing.T) {
+	var called int
+	jobs := newPackageJobs(func() { called++ })
+	jobs.add()
+	jobs.add()
+	jobs.done() // first render job
+	jobs.done() // the walk
+	if called != 0 {
+		t.Fatalf("onDone called with a render job outstanding")
+	}
+	jobs.done() // second render job
+	if called != 1 {
+		t.Fatalf("onDone called %d times, want 1", called)
+	}
+
+	// A nil *packageJobs must be usable for jobs without a package.
+	var nilJobs *packageJobs
+	nilJobs.add()
+	nilJobs.done()
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+import "os"
+
+func linkCount(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var collectWarnings = flag.Bool("collect_warnings",
+	false,
+	"Collect the number of mandoc warnings of each rendered manpage into lint-<suite>.json. Slows down rendering, as mandocd cannot be used.")
+
+// lintReport collects the number of mandoc warnings per manpage, keyed
+// by suite and serving path.
+type lintReport struct {
+	stats *stats
+
+	// verbose makes record log all warnings, not just count them.
+	verbose bool
+
+	mu       sync.Mutex
+	warnings map[string]map[string]int
+}
+
+func newLintReport(stats *stats) *lintReport {
+	return &lintReport{
+		stats:    stats,
+		warnings: make(map[string]map[string]int),
+	}
+}
+
+// record stores the warnings which were emitted when rendering m,
+// replacing any previously recorded warnings for m.
+func (l *lintReport) record(m *manpage.Meta, warnings []string) {
+	atomic.AddUint64(&l.stats.MandocWarnings, uint64(len(warnings)))
+	if l.verbose {
+		for _, w := range warnings {
+			infof("%s: %s", m.ServingPath(), w)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bySuite, ok := l.warnings[m.Package.Suite]
+	if !ok {
+		bySuite = make(map[string]int)
+		l.warnings[m.Package.Suite] = bySuite
+	}
+	bySuite[m.ServingPath()] = len(warnings)
+}
+
+func lintPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("lint-%s.json", suite))
+}
+
+// write merges the recorded warnings into the lint-<suite>.json files
+// in destDir. Manpages which were not re-rendered in this run keep
+// their previous entries, manpages without warnings are removed.
+func (l *lintReport) write(destDir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for suite, recorded := range l.warnings {
+		path := lintPath(destDir, suite)
+		merged := make(map[string]int)
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &merged); err != nil {
+				return fmt.Errorf("parsing %q: %v", path, err)
+			}
+		}
+		for servingPath, n := range recorded {
+			if n == 0 {
+				delete(merged, servingPath)
+			} else {
+				merged[servingPath] = n
+			}
+		}
+		recordArtifact(artifactData, path)
+		if err := writeAtomically(path, false, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(merged)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLintReportMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debiman-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(lintPath(dir, "jessie"), []byte(`{"jessie/cron/crontab.5.en":2,"jessie/i3-wm/i3.1.en":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var s stats
+	l := newLintReport(&s)
+	l.record(mustParseFromServingPath(t, "jessie/i3-wm/i3.1.en"), nil)
+	l.record(mustParseFromServingPath(t, "jessie/w3m/w3m.1.en"), []string{"a", "b", "c"})
+	if err := l.write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(lintPath(dir, "jessie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"jessie/cron/crontab.5.en": 2,
+		"jessie/w3m/w3m.1.en":      3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected lint report: got %v, want %v", got, want)
+	}
+	if got, want := s.MandocWarnings, uint64(3); got != want {
+		t.Fatalf("unexpected MandocWarnings: got %d, want %d", got, want)
+	}
+}
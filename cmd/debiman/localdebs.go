@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Debian/debiman/internal/archive"
+	"github.com/Debian/debiman/internal/manpage"
+	"pault.ag/go/debian/version"
+)
+
+var (
+	localDebs = flag.String("local_debs",
+		"",
+		"If non-empty, a comma-separated list of directories (searched recursively for .deb files), .deb files and .changes files to render instead of synchronizing from the Debian mirror, e.g. to preview how the manpages of a package will look before uploading it. Packages are always re-extracted.")
+
+	localDebsSuite = flag.String("local_debs_suite",
+		"local",
+		"Name of the suite into which -local_debs are placed")
+)
+
+// findLocalDebs returns the paths of all .deb files specified by
+// -local_debs.
+func findLocalDebs(paths []string) ([]string, error) {
+	var debs []string
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case st.IsDir():
+			if err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() && strings.HasSuffix(path, ".deb") {
+					debs = append(debs, path)
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+
+		case strings.HasSuffix(path, ".changes"):
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			files, err := changesDebs(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			for _, fn := range files {
+				debs = append(debs, filepath.Join(filepath.Dir(path), fn))
+			}
+
+		default:
+			debs = append(debs, path)
+		}
+	}
+	return debs, nil
+}
+
+// changesDebs returns the .deb files listed in the Files field of the
+// .changes file r, whose lines are of the form
+// “<md5> <size> <section> <priority> <filename>”.
+func changesDebs(r io.Reader) ([]string, error) {
+	var (
+		debs    []string
+		inFiles bool
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inFiles = strings.HasPrefix(line, "Files:")
+			continue
+		}
+		if !inFiles {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			continue
+		}
+		if fn := fields[4]; strings.HasSuffix(fn, ".deb") {
+			debs = append(debs, fn)
+		}
+	}
+	return debs, scanner.Err()
+}
+
+// scanLocalDeb returns the package entry and the manpages of the .deb
+// file path.
+func scanLocalDeb(suite, path string) (*pkgEntry, []*contentEntry, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, nil, err
+	}
+	d, err := loadDeb(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %q: %v", abs, err)
+	}
+	defer d.Close()
+	v, err := version.Parse(d.Control["Version"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %q: %v", abs, err)
+	}
+	p := &pkgEntry{
+		source:    d.Control["Source"],
+		suite:     suite,
+		binarypkg: d.Control["Package"],
+		arch:      d.Control["Architecture"],
+		filename:  abs,
+		version:   v,
+		sha256:    h.Sum(nil),
+		bytes:     n,
+	}
+	if p.source == "" {
+		p.source = p.binarypkg
+	}
+	if idx := strings.Index(p.source, " "); idx > -1 {
+		p.source = p.source[:idx]
+	}
+	for _, pkg := range strings.Split(d.Control["Replaces"], ",") {
+		pkg = strings.TrimSpace(pkg)
+		if idx := strings.Index(pkg, " "); idx > -1 {
+			pkg = pkg[:idx]
+		}
+		if pkg != "" {
+			p.replaces = append(p.replaces, pkg)
+		}
+	}
+
+	var content []*contentEntry
+	for {
+		header, err := d.Data.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg &&
+			header.Typeflag != tar.TypeRegA &&
+			header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+		// Paths in Contents files are relative, e.g.
+		// usr/share/man/man1/ls.1.gz.
+		fn := strings.TrimPrefix(header.Name, "./")
+		if !strings.HasPrefix(fn, "usr/share/man/") {
+			continue
+		}
+		content = append(content, &contentEntry{
+			suite:     suite,
+			arch:      p.arch,
+			binarypkg: p.binarypkg,
+			filename:  fn,
+		})
+	}
+	return p, content, nil
+}
+
+// buildLocalGlobalView is like buildGlobalView, but discovers the
+// -local_debs instead of the packages of a mirror. If multiple .deb
+// files contain the same binary package, the newest version (and
+// mostPopularArchitecture, if present) is used.
+func buildLocalGlobalView(paths []string, suite string, start time.Time) (globalView, error) {
+	res := newGlobalView(1, start)
+	addSortOrder(suite)
+	res.suites[suite] = true
+	res.stats.Suites[suite] = &suiteStats{}
+	res.idxSuites[suite] = suite
+	// Packages are “downloaded” by their absolute path.
+	res.getters[suite] = &archive.Getter{
+		ConnectionsPerMirror: 10,
+		LocalMirror:          "/",
+	}
+
+	debs, err := findLocalDebs(paths)
+	if err != nil {
+		return res, err
+	}
+	if len(debs) == 0 {
+		return res, fmt.Errorf("no .deb files found in %q", paths)
+	}
+
+	byPkg := make(map[string]*pkgEntry)
+	contentByPkg := make(map[string][]*contentEntry)
+	for _, path := range debs {
+		p, content, err := scanLocalDeb(suite, path)
+		if err != nil {
+			return res, err
+		}
+		if len(content) == 0 {
+			infof("%s: package %q contains no manpages, skipping", path, p.binarypkg)
+			continue
+		}
+		if prev, ok := byPkg[p.binarypkg]; ok {
+			if cmp := version.Compare(prev.version, p.version); cmp > 0 ||
+				(cmp == 0 && prev.arch == mostPopularArchitecture) {
+				continue
+			}
+		}
+		byPkg[p.binarypkg] = p
+		contentByPkg[p.binarypkg] = content
+	}
+
+	latestVersion := make(map[string]*manpage.PkgMeta, len(byPkg))
+	var content []*contentEntry
+	for binarypkg, p := range byPkg {
+		res.pkgs = append(res.pkgs, p)
+		latestVersion[suite+"/"+binarypkg] = &manpage.PkgMeta{
+			Replaces:  p.replaces,
+			Binarypkg: p.binarypkg,
+			Suite:     p.suite,
+			Version:   p.version,
+		}
+		content = append(content, contentByPkg[binarypkg]...)
+	}
+	for _, c := range content {
+		res.contentByPath[c.filename] = append(res.contentByPath[c.filename], c)
+	}
+	infof("Adding %d packages from %d local .deb files", len(res.pkgs), len(debs))
+	addXrefs(res.xref, content, latestVersion)
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testChanges = `Format: 1.8
+Source: i3lock
+Binary: i3lock
+Architecture: source amd64
+Version: 2.8-1
+Checksums-Sha256:
+ 0123 1234 i3lock_2.8-1.dsc
+ 4567 5678 i3lock_2.8-1_amd64.deb
+Files:
+ 89ab 1234 x11 optional i3lock_2.8-1.dsc
+ cdef 5678 x11 optional i3lock_2.8-1_amd64.deb
+ 0123 91011 debug optional i3lock-dbgsym_2.8-1_amd64.ddeb
+`
+
+func TestChangesDebs(t *testing.T) {
+	got, err := changesDebs(strings.NewReader(testChanges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"i3lock_2.8-1_amd64.deb"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result: got %q, want %q", got, want)
+	}
+}
+
+func TestFindLocalDebs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, fn := range []string{
+		"pool/a.deb",
+		"pool/sub/b.deb",
+		"pool/b.dsc",
+		"upload/i3lock_2.8-1_amd64.deb",
+	} {
+		path := filepath.Join(tmpdir, fn)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes := filepath.Join(tmpdir, "upload", "i3lock_2.8-1_amd64.changes")
+	if err := ioutil.WriteFile(changes, []byte(testChanges), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findLocalDebs([]string{
+		filepath.Join(tmpdir, "pool"),
+		changes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{
+		filepath.Join(tmpdir, "pool", "a.deb"),
+		filepath.Join(tmpdir, "pool", "sub", "b.deb"),
+		filepath.Join(tmpdir, "upload", "i3lock_2.8-1_amd64.deb"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result: got %q, want %q", got, want)
+	}
+
+	if _, err := findLocalDebs([]string{filepath.Join(tmpdir, "nonexistent.deb")}); err == nil {
+		t.Fatalf("findLocalDebs unexpectedly succeeded for a nonexistent file")
+	}
+}
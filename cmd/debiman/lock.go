@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lockFileName is the name of the file within -serving_dir on which each
+// debiman run which modifies the serving directory holds an exclusive
+// lock, so that overlapping runs (e.g. from cron) cannot break each
+// other’s modification time based invariants.
+const lockFileName = ".debiman.lock"
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// readOnlyPhases do not modify the serving directory, so they do not
+// need to lock it.
+var readOnlyPhases = map[string]bool{
+	"verify":     true,
+	"serve":      true,
+	"render-one": true, // writes to -render_one_output
+}
+
+// needsLock returns whether any of cmds modifies the serving directory.
+func needsLock(cmds []command) bool {
+	for _, cmd := range cmds {
+		if !readOnlyPhases[cmd.name] {
+			return true
+		}
+	}
+	return false
+}
+
+// mandocPhases run mandoc, so they require it to be installed.
+var mandocPhases = map[string]bool{
+	"render":     true,
+	"render-one": true,
+}
+
+// needsMandoc returns whether any of cmds runs mandoc. Note that
+// -render_one runs mandoc regardless of cmds.
+func needsMandoc(cmds []command) bool {
+	for _, cmd := range cmds {
+		if mandocPhases[cmd.name] {
+			return true
+		}
+	}
+	return false
+}
+
+// lockServingDir takes the exclusive lock on -serving_dir and records
+// the process ID in the lock file. The lock is held until the returned
+// file is closed (or the process exits).
+func lockServingDir() (*os.File, error) {
+	path := filepath.Join(*servingDir, lockFileName)
+	f, err := lockFile(path)
+	if err == errLocked {
+		pid := "unknown"
+		if b, err := ioutil.ReadFile(path); err == nil && len(b) > 0 {
+			pid = strings.TrimSpace(string(b))
+		}
+		return nil, fmt.Errorf("another debiman instance (pid %s) holds the lock on %q, refusing to run", pid, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
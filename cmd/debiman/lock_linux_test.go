@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockServingDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+
+	lock, err := lockServingDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, lockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), strconv.Itoa(os.Getpid()); got != want {
+		t.Fatalf("unexpected lock file contents: got %q, want %q", got, want)
+	}
+
+	// flock(2) locks belong to the open file description, so a second
+	// lock attempt fails even within the same process.
+	if _, err := lockServingDir(); err == nil || !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+		t.Fatalf("lockServingDir unexpectedly returned %v while locked", err)
+	}
+
+	if err := lock.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = lockServingDir()
+	if err != nil {
+		t.Fatalf("lockServingDir after unlocking: %v", err)
+	}
+	lock.Close()
+}
+
+func TestNeedsLock(t *testing.T) {
+	for _, tt := range []struct {
+		cmds []command
+		want bool
+	}{
+		{defaultPhases, true},
+		{[]command{{name: "verify"}}, false},
+		{[]command{{name: "serve"}}, false},
+		{[]command{{name: "render-one", arg: "/tmp/i3.1.gz"}}, false},
+		{[]command{{name: "fetch"}, {name: "render-one", arg: "/tmp/i3.1.gz"}}, true},
+		{[]command{{name: "render"}, {name: "verify"}}, true},
+	} {
+		if got := needsLock(tt.cmds); got != tt.want {
+			t.Errorf("needsLock(%v) = %v, want %v", tt.cmds, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsMandoc(t *testing.T) {
+	for _, tt := range []struct {
+		cmds []command
+		want bool
+	}{
+		{defaultPhases, true},
+		{[]command{{name: "fetch"}, {name: "extract"}, {name: "gc"}}, false},
+		{[]command{{name: "verify"}}, false},
+		{[]command{{name: "serve"}}, false},
+		{[]command{{name: "sitemap"}}, false},
+		{[]command{{name: "render-one", arg: "/tmp/i3.1.gz"}}, true},
+		{[]command{{name: "fetch"}, {name: "render"}}, true},
+	} {
+		if got := needsMandoc(tt.cmds); got != tt.want {
+			t.Errorf("needsMandoc(%v) = %v, want %v", tt.cmds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package main
+
+import "os"
+
+// lockFile opens (creating if necessary) path. Locking is only
+// implemented on Linux, so concurrent runs are not detected elsewhere.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
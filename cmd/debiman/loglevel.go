@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	logLevelName = flag.String("log_level",
+		"info",
+		"Minimum level of the messages to log: debug (e.g. which manpages are re-rendered because they were invalidated by another version, and how .so references are resolved), info, warning or error")
+
+	quiet = flag.Bool("quiet",
+		false,
+		"Only log warnings and errors, i.e. -log_level=warning. Fatal errors and the statistics at the end of a run are always printed.")
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug":   levelDebug,
+	"info":    levelInfo,
+	"warning": levelWarning,
+	"warn":    levelWarning,
+	"error":   levelError,
+}
+
+// logLevelPrefixes are prepended to messages of the respective level.
+var logLevelPrefixes = map[logLevel]string{
+	levelDebug:   "DEBUG: ",
+	levelWarning: "WARNING: ",
+	levelError:   "ERROR: ",
+}
+
+// minLogLevel is the minimum level of messages which are logged, see
+// -log_level and -quiet.
+var minLogLevel = levelInfo
+
+// setLogLevel configures minLogLevel from -log_level and -quiet.
+func setLogLevel() error {
+	level, ok := logLevelNames[strings.ToLower(*logLevelName)]
+	if !ok {
+		return fmt.Errorf("unknown -log_level %q, expected debug, info, warning or error", *logLevelName)
+	}
+	if *quiet && level < levelWarning {
+		level = levelWarning
+	}
+	minLogLevel = level
+	return nil
+}
+
+// leveledLogger logs messages of at least minLogLevel. The zero value
+// logs to the standard logger.
+type leveledLogger struct {
+	l *log.Logger
+}
+
+// newPackageLogger returns a leveledLogger prefixing all messages with
+// the suite and name of the binary package p.
+func newPackageLogger(p pkgEntry) *leveledLogger {
+	return &leveledLogger{log.New(os.Stderr, p.suite+"/"+p.binarypkg+": ", log.LstdFlags)}
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, v ...interface{}) {
+	if level < minLogLevel && level < levelWarning {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if level >= levelWarning {
+		// Warnings and errors are summarized at the end of the run,
+		// even if they are not logged.
+		example := msg
+		if l.l != nil {
+			example = l.l.Prefix() + msg
+		}
+		logSummary.record(level, format, example)
+	}
+	if level < minLogLevel {
+		return
+	}
+	msg = logLevelPrefixes[level] + msg
+	// calldepth 3 skips logf and the level-specific method, so that
+	// log.Lshortfile refers to the caller of the latter.
+	if l.l == nil {
+		log.Output(3, msg)
+	} else {
+		l.l.Output(3, msg)
+	}
+}
+
+func (l *leveledLogger) debugf(format string, v ...interface{}) {
+	l.logf(levelDebug, format, v...)
+}
+
+func (l *leveledLogger) infof(format string, v ...interface{}) {
+	l.logf(levelInfo, format, v...)
+}
+
+func (l *leveledLogger) warningf(format string, v ...interface{}) {
+	l.logf(levelWarning, format, v...)
+}
+
+func (l *leveledLogger) errorf(format string, v ...interface{}) {
+	l.logf(levelError, format, v...)
+}
+
+// stdLogger logs to the standard logger.
+var stdLogger = &leveledLogger{}
+
+func debugf(format string, v ...interface{}) {
+	stdLogger.logf(levelDebug, format, v...)
+}
+
+func infof(format string, v ...interface{}) {
+	stdLogger.logf(levelInfo, format, v...)
+}
+
+func warningf(format string, v ...interface{}) {
+	stdLogger.logf(levelWarning, format, v...)
+}
+
+func errorf(format string, v ...interface{}) {
+	stdLogger.logf(levelError, format, v...)
+}
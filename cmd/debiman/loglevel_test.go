@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogLevel(t *testing.T) {
+	oldLevel, oldQuiet, oldMin := *logLevelName, *quiet, minLogLevel
+	defer func() {
+		flag.Set("log_level", oldLevel)
+		*quiet = oldQuiet
+		minLogLevel = oldMin
+	}()
+
+	for _, tt := range []struct {
+		level   string
+		quiet   bool
+		want    []string
+		wantErr bool
+	}{
+		{level: "debug", want: []string{"DEBUG: invalidated", "rendered", "WARNING: timed out", "ERROR: BUG"}},
+		{level: "info", want: []string{"rendered", "WARNING: timed out", "ERROR: BUG"}},
+		{level: "INFO", quiet: true, want: []string{"WARNING: timed out", "ERROR: BUG"}},
+		{level: "warn", want: []string{"WARNING: timed out", "ERROR: BUG"}},
+		{level: "error", quiet: true, want: []string{"ERROR: BUG"}},
+		{level: "verbose", wantErr: true},
+	} {
+		flag.Set("log_level", tt.level)
+		*quiet = tt.quiet
+		err := setLogLevel()
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("setLogLevel(%q): got err %v, want error: %v", tt.level, err, want)
+		}
+		if err != nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+		l := &leveledLogger{log.New(&buf, "", 0)}
+		l.debugf("invalidated")
+		l.infof("rendered")
+		l.warningf("timed out")
+		l.errorf("BUG")
+		got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("-log_level=%s -quiet=%v: got %q, want %q", tt.level, tt.quiet, got, tt.want)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -15,7 +14,7 @@ import (
 
 	"github.com/Debian/debiman/internal/archive"
 	"github.com/Debian/debiman/internal/bundled"
-	"github.com/Debian/debiman/internal/commontmpl"
+	"github.com/Debian/debiman/internal/convert"
 )
 
 var (
@@ -27,6 +26,10 @@ var (
 		"<serving_dir>/auxserver.idx",
 		"Path to an auxserver index to generate")
 
+	dumpXrefPath = flag.String("dump_xref",
+		"",
+		"If non-empty, a path to which to write the cross-reference index (all manpages by name, with their suite, package, version, section, language and serving path) for consumption by external services. Written as JSON, or in the format of -index if the path ends in .pb.")
+
 	syncCodenames = flag.String("sync_codenames",
 		"",
 		"Debian codenames to synchronize (e.g. wheezy, jessie, …)")
@@ -35,6 +38,10 @@ var (
 		"testing",
 		"Debian suites to synchronize (e.g. testing, unstable)")
 
+	components = flag.String("components",
+		"main,contrib",
+		"Comma-separated list of archive components to synchronize (e.g. main,contrib,non-free,non-free-firmware). Components which a suite does not contain (as per the Components field of its Release file) are skipped, so the list may include the components of -federated_distributions, too (e.g. universe).")
+
 	onlyRender = flag.String("only_render_pkgs",
 		"",
 		"If non-empty, a comma-separated whitelist of packages to render (for developing)")
@@ -51,6 +58,54 @@ var (
 		"",
 		"If non-empty, a file system path to a Debian mirror, e.g. /srv/mirrors/debian on DSA-maintained machines")
 
+	keyring = flag.String("keyring",
+		archive.DefaultKeyring,
+		"Path to the keyring which the Release files of the Debian mirror must be signed with")
+
+	insecure = flag.Bool("insecure",
+		false,
+		"Do not verify the signatures of Release files. Only use this for testing or with a trusted mirror: without signature verification, a compromised mirror can feed arbitrary content into debiman.")
+
+	downloadRetries = flag.Int("download_retries",
+		archive.DefaultRetriesTransient,
+		"Number of times to retry a download which failed with a transient error (e.g. a connection reset or HTTP status 503) before rotating to the next mirror (see -mirrors) or giving up. Checksum mismatches and other permanent errors are not retried on the same mirror.")
+
+	downloadBackoff = flag.Duration("download_backoff",
+		1*time.Second,
+		"Delay before the first retry of a download (see -download_retries). Each further retry waits twice as long as the previous one.")
+
+	downloadTimeout = flag.Duration("download_timeout",
+		15*time.Minute,
+		"If non-zero, the maximum time a single HTTP request (including reading the response body) may take. Interrupted downloads are resumed when retried.")
+
+	packageCacheDir = flag.String("package_cache",
+		"",
+		"If non-empty, a directory in which downloaded packages are kept (keyed by their SHA256 sum), so that subsequent runs (or other suites containing the same packages) do not download them again, e.g. when re-extracting all packages using -force_reextract or into a fresh -serving_dir.")
+
+	packageCacheSize = flag.Int64("package_cache_size_mb",
+		10240,
+		"Size (in MiB) up to which -package_cache may grow. When it is exceeded, the least recently used packages are removed. If zero, the size is not limited.")
+
+	pdiffs = flag.Bool("pdiffs",
+		false,
+		"Cache the uncompressed Contents and Packages files in <serving_dir>/.indexcache and update them using pdiffs (where the mirror publishes them) instead of downloading them entirely on each run")
+
+	persistGlobalView = flag.Bool("persist_global_view",
+		false,
+		"Persist the result of parsing each Contents and Packages file in <serving_dir>/.globalview, keyed by the SHA256 hash of the file, so that subsequent runs only download and parse the index files which changed. The cross-reference index is built from the results on every run. Does not apply to pacman or rpm distributions of -federated_distributions.")
+
+	mandocPath = flag.String("mandoc_path",
+		"",
+		"If non-empty, the path to the mandoc binary to use instead of mandoc from $PATH, e.g. to test patched mandoc builds. mandocd is expected in the same directory.")
+
+	mandocArgs = flag.String("mandoc_args",
+		"",
+		"Additional space-separated arguments to pass to mandoc when converting manpages to HTML, e.g. -Oman=%N.%S. -O options are checked against the version of mandoc at startup. Disables the use of mandocd.")
+
+	mandocTimeout = flag.Duration("mandoc_timeout",
+		1*time.Minute,
+		"If non-zero, the maximum time converting a single manpage may take. mandoc processes which exceed it are killed and restarted, and an error page is rendered for the manpage. Also limits the conversions to plain text, PDF and Markdown.")
+
 	injectAssets = flag.String("inject_assets",
 		"",
 		"If non-empty, a file system path to a directory containing assets to overwrite")
@@ -63,100 +118,187 @@ var (
 // use go build -ldflags "-X main.debimanVersion=<version>" to set the version
 var debimanVersion = "HEAD"
 
-// TODO: handle deleted packages, i.e. packages which are present on
-// disk but not in pkgs
+// downloadRetriesTransient returns the -download_retries in the form
+// of archive.Getter.RetriesTransient, where 0 means the default.
+func downloadRetriesTransient() int {
+	if *downloadRetries == 0 {
+		return -1
+	}
+	return *downloadRetries
+}
+
+// packageCache returns the package cache configured by -package_cache
+// and -package_cache_size_mb, or nil if packages should not be cached.
+func packageCache() *archive.PackageCache {
+	if *packageCacheDir == "" {
+		return nil
+	}
+	return &archive.PackageCache{
+		Dir:      *packageCacheDir,
+		MaxBytes: *packageCacheSize * 1024 * 1024,
+	}
+}
+
+// indexCacheDir returns the directory in which index files are cached
+// (see -pdiffs), or an empty string if they should not be cached.
+func indexCacheDir() string {
+	if !*pdiffs || *dryRun {
+		return ""
+	}
+	return filepath.Join(*servingDir, ".indexcache")
+}
+
+// mandoc is the mandoc configuration (see -mandoc_path and
+// -mandoc_args).
+var mandoc convert.Mandoc
+
+// filters are the HTML post-processing filters (see -html_filters).
+var filters []convert.Filter
+
+// mandocFeatures are the optional features of the installed mandoc, as
+// detected at startup. Tests assume the minimum supported version.
+var mandocFeatures = convert.FeaturesOf(convert.MinimumVersion)
 
 // TODO(later): add memory usage estimates to the big structures, set
 // parallelism level according to available memory on the system
 func logic() error {
-	start := time.Now()
+	return runPhases(defaultPhases)
+}
 
-	ar := &archive.Getter{
-		ConnectionsPerMirror: 10,
-		LocalMirror:          *localMirror,
+func main() {
+	flag.Usage = usage
+	cmds, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	// Stage 1: all Debian packages of all architectures of the
-	// specified suites are discovered.
-	globalView, err := buildGlobalView(ar, distributions(
-		strings.Split(*syncCodenames, ","),
-		strings.Split(*syncSuites, ",")),
-		start)
-	if err != nil {
-		return err
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if err := setLogLevel(); err != nil {
+		log.Fatal(err)
 	}
+	// Count the warnings of the internal packages, too (see
+	// -fail_on_warnings).
+	archive.Warningf = warningf
+	bundled.Warningf = warningf
 
-	log.Printf("gathered packages of all suites, total %d packages", len(globalView.pkgs))
+	if *showVersion {
+		fmt.Printf("debiman %s\n", debimanVersion)
+		return
+	}
 
-	// Stage 2: man pages and auxilliary files (e.g. content fragment
-	// files which are included by a number of manpages) are extracted
-	// from the identified Debian packages.
-	if err := parallelDownload(ar, globalView); err != nil {
-		return err
+	if err := parseCompression(*compression); err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("Extracted all manpages, now rendering")
+	mandoc = convert.Mandoc{
+		Path:    *mandocPath,
+		Args:    strings.Fields(*mandocArgs),
+		Timeout: *mandocTimeout,
+	}
+	// Commands which do not render (e.g. verify on a deployment host)
+	// do not require mandoc to be installed.
+	if *renderOne != "" || needsMandoc(cmds) {
+		features, err := mandoc.DetectFeatures()
+		if err != nil {
+			log.Fatal(err)
+		}
+		infof("Using mandoc %v", features.Version)
+		if err := features.CheckArgs(mandoc.Args); err != nil {
+			log.Fatalf("-mandoc_args: %v", err)
+		}
+		mandocFeatures = features
 
-	// Stage 3: all man pages are rendered into an HTML representation
-	// using mandoc(1), directory index files are rendered, contents
-	// files are rendered.
-	if err := renderAll(globalView); err != nil {
-		return err
+		if *renderMarkdown && !features.Markdown {
+			warningf("mandoc %v does not support -Tmarkdown (requires mandoc >= 1.14.1), disabling -render_markdown", features.Version)
+			*renderMarkdown = false
+		}
 	}
 
-	log.Printf("Rendered all manpages, writing index")
+	if *localDebs != "" {
+		// Local packages are typically rebuilt without changing their
+		// version while previewing.
+		*forceReextract = true
+	}
 
-	// Stage 4: write the index only after all rendering is complete,
-	// otherwise debiman-auxserver might serve redirects to pages
-	// which cannot be served yet.
-	path := strings.Replace(*indexPath, "<serving_dir>", *servingDir, -1)
-	log.Printf("Writing debiman-auxserver index to %q", path)
-	if err := writeIndex(path, globalView); err != nil {
-		return err
+	diffPairs, err = parseDiffPairs(*renderDiffs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(diffPairs) > 0 && !*renderText {
+		log.Fatal("-render_diffs requires -render_text")
+	}
+	if *writeClientSearchIndex && !*writeWhatis {
+		log.Fatal("-client_search_index requires -whatis")
+	}
+	if *pdfConcurrency < 1 {
+		log.Fatal("-concurrency_pdf must be at least 1")
 	}
 
-	if err := renderAux(*servingDir, globalView); err != nil {
-		return err
+	if *htmlFilters != "" {
+		for _, spec := range strings.Split(*htmlFilters, ",") {
+			f, err := convert.NewFilter(spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			filters = append(filters, f)
+		}
 	}
 
-	fmt.Printf("total number of packages: %d\n", len(globalView.pkgs))
-	fmt.Printf("packages extracted:       %d\n", globalView.stats.PackagesExtracted)
-	fmt.Printf("packages deleted:         %d\n", globalView.stats.PackagesDeleted)
-	fmt.Printf("manpages rendered:        %d\n", globalView.stats.ManpagesRendered)
-	fmt.Printf("total manpage bytes:      %d\n", globalView.stats.ManpageBytes)
-	fmt.Printf("total HTML bytes:         %d\n", globalView.stats.HtmlBytes)
-	fmt.Printf("auxserver index bytes:    %d\n", globalView.stats.IndexBytes)
-	fmt.Printf("wall-clock runtime (s):   %d\n", int(time.Now().Sub(start).Seconds()))
+	if *injectAssets != "" {
+		if err := bundled.Inject(*injectAssets); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	return writeAtomically(filepath.Join(*servingDir, "metrics.txt"), false, func(w io.Writer) error {
-		return writeMetrics(w, globalView, start)
-	})
-}
+	if *templatesDir != "" {
+		if err := bundled.InjectTemplates(*templatesDir); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-func main() {
-	flag.Parse()
+	if *injectAssets != "" || *templatesDir != "" {
+		parseTemplates()
+		if err := validateTemplates(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if *progressInterval > 0 || *progressBar {
+		go reportProgress()
+	}
 
-	if *showVersion {
-		fmt.Printf("debiman %s\n", debimanVersion)
-		return
+	if *statusSocket != "" {
+		if err := serveStatus(*statusSocket); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	if *injectAssets != "" {
-		if err := bundled.Inject(*injectAssets); err != nil {
+	if *renderOneOutput != "-" {
+		// Resolve relative to the current working directory, which is
+		// changed below.
+		abs, err := filepath.Abs(*renderOneOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*renderOneOutput = abs
+	}
+
+	if *statsJSON != "" {
+		abs, err := filepath.Abs(*statsJSON)
+		if err != nil {
 			log.Fatal(err)
 		}
+		*statsJSON = abs
+	}
 
-		commonTmpls = commontmpl.MustParseCommonTmpls()
-		contentsTmpl = mustParseContentsTmpl()
-		pkgindexTmpl = mustParsePkgindexTmpl()
-		indexTmpl = mustParseIndexTmpl()
-		faqTmpl = mustParseFaqTmpl()
-		aboutTmpl = mustParseAboutTmpl()
-		manpageTmpl = mustParseManpageTmpl()
-		manpageerrorTmpl = mustParseManpageerrorTmpl()
-		manpagefooterextraTmpl = mustParseManpagefooterextraTmpl()
+	if *exportBulkDest != "" && !isBulkURL(*exportBulkDest) {
+		abs, err := filepath.Abs(*exportBulkDest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*exportBulkDest = abs
 	}
 
 	// All of our .so references are relative to *servingDir. For
@@ -168,7 +310,7 @@ func main() {
 
 	go http.ListenAndServe(":4414", nil)
 
-	if err := logic(); err != nil {
+	if err := runPhases(cmds); err != nil {
 		log.Fatal(err)
 	}
 }
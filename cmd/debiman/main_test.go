@@ -15,6 +15,8 @@ func TestEndToEnd(t *testing.T) {
 	defer os.RemoveAll(dir)
 	flag.Set("serving_dir", dir)
 	flag.Set("local_mirror", "../../testdata/tinymirror")
+	// The tinymirror Release file is not signed.
+	flag.Set("insecure", "true")
 	if err := logic(); err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// manifestEntry records the state of a package as of its last
+// extraction.
+type manifestEntry struct {
+	// SHA256 is the hex-encoded SHA256 sum of the package.
+	SHA256 string `json:"sha256"`
+
+	// Extracted is true if manpages were extracted from the package.
+	// Packages without manpages (e.g. due to imprecise Contents files)
+	// do not have a VERSION file.
+	Extracted bool `json:"extracted"`
+}
+
+// downloadManifest records the packages whose manpages were extracted,
+// so that packages which the archive still lists with the same SHA256
+// sum are neither downloaded nor extracted again.
+type downloadManifest struct {
+	path string
+
+	mu       sync.Mutex
+	previous map[string]manifestEntry // as loaded, keyed by suite/binarypkg
+	current  map[string]manifestEntry // recorded in this run
+}
+
+// downloadManifestPath returns the path of the download manifest in
+// the -serving_dir.
+func downloadManifestPath() string {
+	return filepath.Join(*servingDir, ".downloadmanifest.json")
+}
+
+// loadDownloadManifest reads the download manifest at path. A missing
+// manifest (e.g. on the first run) is empty.
+func loadDownloadManifest(path string) (*downloadManifest, error) {
+	m := &downloadManifest{
+		path:     path,
+		previous: make(map[string]manifestEntry),
+		current:  make(map[string]manifestEntry),
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m.previous); err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", path, err)
+	}
+	return m, nil
+}
+
+// has reports whether the manifest contains an entry for p from a
+// previous run.
+func (m *downloadManifest) has(p pkgEntry) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.previous[p.suite+"/"+p.binarypkg]
+	return ok
+}
+
+// unchanged reports whether p was extracted from a package with the
+// same SHA256 sum before, in which case its entry is carried over. vPath
+// is the path of the VERSION file of p, whose absence (e.g. because the
+// directory was deleted to re-process the package) means that the
+// package needs to be extracted again.
+func (m *downloadManifest) unchanged(p pkgEntry, vPath string) bool {
+	key := p.suite + "/" + p.binarypkg
+	m.mu.Lock()
+	entry, ok := m.previous[key]
+	m.mu.Unlock()
+	if !ok || entry.SHA256 != hex.EncodeToString(p.sha256) {
+		return false
+	}
+	if entry.Extracted {
+		if _, err := os.Stat(vPath); err != nil {
+			return false
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current[key] = entry
+	return true
+}
+
+// record stores that p was extracted, yielding manpages if extracted.
+func (m *downloadManifest) record(p pkgEntry, extracted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current[p.suite+"/"+p.binarypkg] = manifestEntry{
+		SHA256:    hex.EncodeToString(p.sha256),
+		Extracted: extracted,
+	}
+}
+
+// write writes the manifest, keeping the entries of previous runs. If
+// complete is true (i.e. all packages were processed), entries of the
+// suites which were processed in this run (as opposed to e.g. the
+// suites of other distributions) which were not recorded in this run
+// (e.g. because they were removed from the archive) are dropped.
+func (m *downloadManifest) write(complete bool, suites map[string]bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make(map[string]manifestEntry, len(m.previous)+len(m.current))
+	for key, entry := range m.previous {
+		if complete && suites[key[:strings.LastIndex(key, "/")]] {
+			continue
+		}
+		entries[key] = entry
+	}
+	for key, entry := range m.current {
+		entries[key] = entry
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return writeAtomically(m.path, false, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(entries)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadManifest(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, ".downloadmanifest.json")
+	vPath := filepath.Join(tmpdir, "VERSION")
+	i3 := pkgEntry{suite: "testing", binarypkg: "i3-wm", sha256: []byte{0x01, 0x23}}
+	i3lock := pkgEntry{suite: "testing", binarypkg: "i3lock", sha256: []byte{0x45, 0x67}}
+	bash := pkgEntry{suite: "testing", binarypkg: "bash", sha256: []byte{0x89, 0xab}}
+	sidBash := pkgEntry{suite: "sid", binarypkg: "bash", sha256: []byte{0x89, 0xab}}
+	suites := map[string]bool{"testing": true}
+
+	m, err := loadDownloadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.has(i3) || m.unchanged(i3, vPath) {
+		t.Fatalf("empty manifest unexpectedly contains %+v", i3)
+	}
+	m.record(i3, true)
+	m.record(i3lock, false)
+	m.record(bash, true)
+	m.record(sidBash, true)
+	if err := m.write(true, map[string]bool{"testing": true, "sid": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = loadDownloadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The VERSION file of i3-wm is missing, e.g. because its
+	// directory was deleted to re-process it.
+	if m.unchanged(i3, vPath) {
+		t.Fatalf("unchanged(%+v) = true despite a missing VERSION file", i3)
+	}
+	if err := ioutil.WriteFile(vPath, []byte("4.13-1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !m.unchanged(i3, vPath) {
+		t.Fatalf("unchanged(%+v) = false, want true", i3)
+	}
+	// i3lock did not contain manpages, so it has no VERSION file.
+	if !m.unchanged(i3lock, filepath.Join(tmpdir, "nonexistent")) {
+		t.Fatalf("unchanged(%+v) = false, want true", i3lock)
+	}
+	changed := i3lock
+	changed.sha256 = []byte{0xcd, 0xef}
+	if m.unchanged(changed, vPath) {
+		t.Fatalf("unchanged(%+v) = true despite a different SHA256", changed)
+	}
+
+	// An incomplete run keeps the entry of bash.
+	if err := m.write(false, suites); err != nil {
+		t.Fatal(err)
+	}
+	m, err = loadDownloadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.has(bash) {
+		t.Fatalf("manifest unexpectedly lost %+v after an incomplete run", bash)
+	}
+
+	// A complete run in which bash was not processed (e.g. because it
+	// was removed from the archive) drops its entry.
+	// Entries of suites which were not processed (sid) are kept.
+	m.unchanged(i3, vPath)
+	if err := m.write(true, suites); err != nil {
+		t.Fatal(err)
+	}
+	m, err = loadDownloadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.has(bash) || m.has(i3lock) || !m.has(i3) || !m.has(sidBash) {
+		t.Fatalf("unexpected manifest entries after a complete run: %+v", m.previous)
+	}
+}
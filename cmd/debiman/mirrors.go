@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	mirrors = flag.String("mirrors",
+		"",
+		"If non-empty, a comma-separated list of Debian mirror URLs to download from instead of http://deb.debian.org/debian/. Besides HTTP(S) URLs, local mirrors can be specified as file:// URLs or absolute paths (e.g. /srv/mirrors/debian). Mirrors are used in order: when downloading a file fails (e.g. because a mirror is unreachable or serves a file with a mismatching checksum while it is being updated), debiman rotates to the next mirror.")
+
+	httpProxy = flag.String("http_proxy",
+		"",
+		"If non-empty, the URL of the HTTP(S) proxy to download with, e.g. http://proxy.example.net:3128/. By default, the proxy specified in the environment ($http_proxy, $https_proxy and $no_proxy) is used.")
+)
+
+// parseMirrorURLs parses a comma-separated list of mirror URLs (HTTP,
+// HTTPS or file:// URLs, or absolute paths of local mirrors, which
+// are turned into file:// URLs), ensuring that each of them ends in a
+// slash.
+func parseMirrorURLs(s string) ([]string, error) {
+	var urls []string
+	for _, u := range strings.Split(s, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if strings.HasPrefix(u, "/") {
+			// A local mirror, e.g. /srv/mirrors/debian
+			u = "file://" + u
+		}
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		switch parsed.Scheme {
+		case "http", "https":
+		case "file":
+			if parsed.Host != "" || !strings.HasPrefix(parsed.Path, "/") {
+				return nil, fmt.Errorf("mirror URL %q: expected an absolute path, e.g. file:///srv/mirrors/debian/", u)
+			}
+		default:
+			return nil, fmt.Errorf("mirror URL %q: unsupported scheme %q", u, parsed.Scheme)
+		}
+		if !strings.HasSuffix(u, "/") {
+			u += "/"
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no mirror URLs specified")
+	}
+	return urls, nil
+}
+
+// setHTTPProxy configures all downloads to use the -http_proxy.
+func setHTTPProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("-http_proxy: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("-http_proxy=%q: expected a URL like http://proxy.example.net:3128/", proxy)
+	}
+	http.DefaultTransport.(*http.Transport).Proxy = http.ProxyURL(u)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMirrorURLs(t *testing.T) {
+	got, err := parseMirrorURLs("http://deb.debian.org/debian, https://mirror.example.net/debian/,file:///srv/mirrors/debian,/srv/mirrors/debian-ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"http://deb.debian.org/debian/",
+		"https://mirror.example.net/debian/",
+		"file:///srv/mirrors/debian/",
+		"file:///srv/mirrors/debian-ports/",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseMirrorURLs: got %q, want %q", got, want)
+	}
+
+	for _, input := range []string{
+		"",
+		"ftp://ftp.debian.org/debian/",
+		"deb.debian.org/debian",
+		"file://srv/mirrors/debian",
+	} {
+		if _, err := parseMirrorURLs(input); err == nil {
+			t.Errorf("parseMirrorURLs(%q) unexpectedly succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Debian/debiman/internal/archive"
+	"github.com/Debian/debiman/internal/manpage"
+	"github.com/Debian/debiman/internal/vercmp"
+	"pault.ag/go/debian/version"
+)
+
+// Formats of -federated_distributions.
+const (
+	formatDebian = "debian"
+	formatPacman = "pacman"
+	formatRPM    = "rpm"
+)
+
+// defaultPacmanArchitecture is used for pacman repositories whose
+// architectures are not specified.
+const defaultPacmanArchitecture = "x86_64"
+
+// pacmanPackage is a package entry of a pacman repository database.
+type pacmanPackage struct {
+	filename string // e.g. bash-5.2.026-2-x86_64.pkg.tar.zst
+	name     string
+	base     string // source package, may be empty
+	version  string // [epoch:]pkgver-pkgrel
+	arch     string // e.g. x86_64 or any
+	sha256   []byte
+	size     int64
+	replaces []string
+	manpages []string // e.g. usr/share/man/man1/bash.1.gz
+}
+
+// parsePacmanDesc parses a desc (or files) entry of a pacman repository
+// database, which consists of %KEY% lines, each followed by value lines
+// up to the next empty line.
+func parsePacmanDesc(b []byte) map[string][]string {
+	result := make(map[string][]string)
+	var key string
+	for _, line := range strings.Split(string(b), "\n") {
+		switch {
+		case line == "":
+			key = ""
+		case key == "" && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			key = strings.Trim(line, "%")
+			result[key] = nil
+		case key != "":
+			result[key] = append(result[key], line)
+		}
+	}
+	return result
+}
+
+// parsePacmanDB returns the packages of the pacman repository database
+// r (e.g. core.files), which contains a directory per package with a
+// desc and (in .files databases) a files entry.
+func parsePacmanDB(r io.Reader) ([]*pacmanPackage, error) {
+	rd, close, err := decompressByMagic(r)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	var (
+		pkgs  []*pacmanPackage
+		byDir = make(map[string]*pacmanPackage)
+	)
+	pkgFor := func(dir string) *pacmanPackage {
+		if p, ok := byDir[dir]; ok {
+			return p
+		}
+		p := &pacmanPackage{}
+		byDir[dir] = p
+		pkgs = append(pkgs, p)
+		return p
+	}
+	tr := tar.NewReader(rd)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dir, name := path.Split(strings.TrimSuffix(header.Name, "/"))
+		if name != "desc" && name != "files" {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		p := pkgFor(dir)
+		desc := parsePacmanDesc(b)
+		if name == "files" {
+			for _, fn := range desc["FILES"] {
+				if strings.HasPrefix(fn, "usr/share/man/") && !strings.HasSuffix(fn, "/") {
+					p.manpages = append(p.manpages, fn)
+				}
+			}
+			continue
+		}
+		first := func(key string) string {
+			if values := desc[key]; len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		p.filename = first("FILENAME")
+		p.name = first("NAME")
+		p.base = first("BASE")
+		p.version = first("VERSION")
+		p.arch = first("ARCH")
+		if p.sha256, err = hex.DecodeString(first("SHA256SUM")); err != nil {
+			return nil, fmt.Errorf("%s: %v", header.Name, err)
+		}
+		if size := first("CSIZE"); size != "" {
+			if p.size, err = strconv.ParseInt(size, 0, 64); err != nil {
+				return nil, fmt.Errorf("%s: %v", header.Name, err)
+			}
+		}
+		for _, replaces := range desc["REPLACES"] {
+			// Strip version constraints, e.g. foo<2.0
+			if idx := strings.IndexAny(replaces, "<>="); idx > -1 {
+				replaces = replaces[:idx]
+			}
+			p.replaces = append(p.replaces, replaces)
+		}
+	}
+	for dir, p := range byDir {
+		if p.name == "" || p.filename == "" || len(p.sha256) == 0 {
+			return nil, fmt.Errorf("%s: incomplete desc entry", dir)
+		}
+	}
+	return pkgs, nil
+}
+
+// pacmanVersion converts the pacman package version v
+// ([epoch:]pkgver-pkgrel) into a version.Version.
+func pacmanVersion(v string) version.Version {
+	if parsed, err := version.Parse(v); err == nil {
+		return parsed
+	}
+	// pkgver may contain characters which are invalid in Debian
+	// versions (e.g. _), so fill in the components directly.
+	var result version.Version
+	if idx := strings.Index(v, ":"); idx > -1 {
+		if epoch, err := strconv.ParseUint(v[:idx], 10, 32); err == nil {
+			result.Epoch = uint(epoch)
+			v = v[idx+1:]
+		}
+	}
+	if idx := strings.LastIndex(v, "-"); idx > -1 {
+		result.Revision = v[idx+1:]
+		v = v[:idx]
+	}
+	result.Version = v
+	return result
+}
+
+// pacmanVercmp compares the pacman package versions a and b
+// ([epoch:]pkgver[-pkgrel]) like vercmp(8), i.e. alpm_pkg_vercmp, and
+// returns -1, 0 or 1 if a is older than, the same as or newer than b.
+func pacmanVercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, verA, relA := pacmanParseEVR(a)
+	epochB, verB, relB := pacmanParseEVR(b)
+	if ret := vercmp.Pacman(epochA, epochB); ret != 0 {
+		return ret
+	}
+	if ret := vercmp.Pacman(verA, verB); ret != 0 {
+		return ret
+	}
+	// The pkgrel is only compared if both versions specify one.
+	if relA != "" && relB != "" {
+		return vercmp.Pacman(relA, relB)
+	}
+	return 0
+}
+
+// pacmanParseEVR splits the pacman package version v into its epoch
+// (0 if not specified), pkgver and pkgrel.
+func pacmanParseEVR(v string) (epoch, pkgver, pkgrel string) {
+	digits := 0
+	for digits < len(v) && isDigit(v[digits]) {
+		digits++
+	}
+	epoch = "0"
+	pkgver = v
+	if digits < len(v) && v[digits] == ':' {
+		if digits > 0 {
+			epoch = v[:digits]
+		}
+		pkgver = v[digits+1:]
+	}
+	if idx := strings.LastIndex(pkgver, "-"); idx > -1 {
+		pkgrel = pkgver[idx+1:]
+		pkgver = pkgver[:idx]
+	}
+	return epoch, pkgver, pkgrel
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// getPacmanRepo returns the manpages, packages and package versions of
+// the pacman repository dist.name (e.g. core), which is served as
+// suite. The repository is expected in the standard layout, i.e. at
+// <repo>/os/<arch>/ underneath the mirror URL.
+func getPacmanRepo(ar *archive.Getter, dist distribution, suite string) ([]*contentEntry, []*pkgEntry, map[string]*manpage.PkgMeta, error) {
+	archs := dist.architectures
+	if archs == nil {
+		archs = []string{defaultPacmanArchitecture}
+	}
+	byName := make(map[string]*pkgEntry)
+	// versions contains the pacman version of each package of byName,
+	// as pkgEntry.version is only an approximation.
+	versions := make(map[string]string)
+	manpages := make(map[string][]string)
+	for _, arch := range archs {
+		dir := dist.name + "/os/" + arch + "/"
+		f, err := ar.GetSigned(dir+dist.name+".files", dir+dist.name+".files.sig")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pkgs, err := parsePacmanDB(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s%s.files: %v", dir, dist.name, err)
+		}
+		for _, pp := range pkgs {
+			if len(pp.manpages) == 0 {
+				continue
+			}
+			p := &pkgEntry{
+				source:    pp.base,
+				suite:     suite,
+				binarypkg: pp.name,
+				arch:      pp.arch,
+				filename:  dir + pp.filename,
+				version:   pacmanVersion(pp.version),
+				sha256:    pp.sha256,
+				bytes:     pp.size,
+				replaces:  pp.replaces,
+			}
+			if p.source == "" {
+				p.source = p.binarypkg
+			}
+			if prev, ok := versions[p.binarypkg]; ok && pacmanVercmp(prev, pp.version) >= 0 {
+				continue
+			}
+			byName[p.binarypkg] = p
+			versions[p.binarypkg] = pp.version
+			manpages[p.binarypkg] = pp.manpages
+		}
+	}
+
+	content, pkgs, latestVersion := indexPackages(suite, byName, manpages)
+	infof("Adding %d packages from pacman repository %q", len(pkgs), suite)
+	return content, pkgs, latestVersion, nil
+}
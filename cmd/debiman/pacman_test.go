@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Debian/debiman/internal/archive"
+	"pault.ag/go/debian/version"
+)
+
+// writeTarGz writes a gzip-compressed tar archive containing files (in
+// the order of names) to w.
+func writeTarGz(t *testing.T, w io.Writer, names []string, files map[string]string) {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testPacmanDesc = `%FILENAME%
+i3-wm-4.23-1-x86_64.pkg.tar.gz
+
+%NAME%
+i3-wm
+
+%BASE%
+i3-wm
+
+%VERSION%
+4.23-1
+
+%CSIZE%
+1234
+
+%SHA256SUM%
+0123456789abcdef
+
+%ARCH%
+x86_64
+
+%REPLACES%
+i3bar<4.0
+`
+
+const testPacmanFiles = `%FILES%
+usr/
+usr/bin/i3
+usr/share/man/
+usr/share/man/man1/
+usr/share/man/man1/i3.1.gz
+`
+
+func TestParsePacmanDB(t *testing.T) {
+	var buf bytes.Buffer
+	writeTarGz(t, &buf, []string{
+		"i3-wm-4.23-1/desc",
+		"i3-wm-4.23-1/files",
+		"bash-5.2.026-2/desc",
+	}, map[string]string{
+		"i3-wm-4.23-1/desc":   testPacmanDesc,
+		"i3-wm-4.23-1/files":  testPacmanFiles,
+		"bash-5.2.026-2/desc": "%FILENAME%\nbash-5.2.026-2-x86_64.pkg.tar.zst\n\n%NAME%\nbash\n\n%VERSION%\n5.2.026-2\n\n%SHA256SUM%\nabcd\n",
+	})
+	got, err := parsePacmanDB(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*pacmanPackage{
+		{
+			filename: "i3-wm-4.23-1-x86_64.pkg.tar.gz",
+			name:     "i3-wm",
+			base:     "i3-wm",
+			version:  "4.23-1",
+			arch:     "x86_64",
+			sha256:   []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+			size:     1234,
+			replaces: []string{"i3bar"},
+			manpages: []string{"usr/share/man/man1/i3.1.gz"},
+		},
+		{
+			filename: "bash-5.2.026-2-x86_64.pkg.tar.zst",
+			name:     "bash",
+			version:  "5.2.026-2",
+			sha256:   []byte{0xab, 0xcd},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsePacmanDB: got %+v, want %+v", got, want)
+	}
+}
+
+func TestPacmanVersion(t *testing.T) {
+	for _, entry := range []struct {
+		input string
+		want  version.Version
+	}{
+		{"4.23-1", version.Version{Version: "4.23", Revision: "1"}},
+		{"1:2.0.1-3", version.Version{Epoch: 1, Version: "2.0.1", Revision: "3"}},
+		{"1.2_3-1", version.Version{Version: "1.2_3", Revision: "1"}},
+	} {
+		if got := pacmanVersion(entry.input); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("pacmanVersion(%q): got %+v, want %+v", entry.input, got, entry.want)
+		}
+	}
+}
+
+func TestPacmanVercmp(t *testing.T) {
+	for _, entry := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0", "1.0-2", 0},
+		{"1:0.9-1", "2.0-1", 1},
+		{"0:2.0-1", "2.0-1", 0},
+		{"1.9-1", "1.10-1", -1},
+		{"1.0a-1", "1.0-1", -1},
+		{"1.0-1", "1.0.1-1", -1},
+		{"1.0.a-1", "1.0-1", 1},
+		{"1.0.a-1", "1.0.1-1", -1},
+		{"1.0alpha-1", "1.0beta-1", -1},
+		{"1.01-1", "1.1-1", 0},
+		{"1.0..1-1", "1.0.1-1", 1},
+		{"2.0-1", "1.99-1", 1},
+	} {
+		if got := pacmanVercmp(entry.a, entry.b); got != entry.want {
+			t.Errorf("pacmanVercmp(%q, %q): got %d, want %d", entry.a, entry.b, got, entry.want)
+		}
+		if got := pacmanVercmp(entry.b, entry.a); got != -entry.want {
+			t.Errorf("pacmanVercmp(%q, %q): got %d, want %d", entry.b, entry.a, got, -entry.want)
+		}
+	}
+}
+
+func TestGetPacmanRepo(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dir := filepath.Join(tmpdir, "extra", "os", "x86_64")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "extra.files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTarGz(t, f, []string{"i3-wm-4.23-1/desc", "i3-wm-4.23-1/files"}, map[string]string{
+		"i3-wm-4.23-1/desc":  testPacmanDesc,
+		"i3-wm-4.23-1/files": testPacmanFiles,
+	})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ar := &archive.Getter{
+		ConnectionsPerMirror: 1,
+		LocalMirror:          tmpdir,
+		Insecure:             true,
+	}
+	content, pkgs, latestVersion, err := getPacmanRepo(ar, distribution{name: "extra", prefix: "arch", format: formatPacman}, "arch-extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pkgs), 1; got != want {
+		t.Fatalf("unexpected number of packages: got %d, want %d", got, want)
+	}
+	if got, want := pkgs[0].filename, "extra/os/x86_64/i3-wm-4.23-1-x86_64.pkg.tar.gz"; got != want {
+		t.Errorf("unexpected filename: got %q, want %q", got, want)
+	}
+	wantContent := []*contentEntry{{
+		suite:     "arch-extra",
+		arch:      "x86_64",
+		binarypkg: "i3-wm",
+		filename:  "usr/share/man/man1/i3.1.gz",
+	}}
+	if !reflect.DeepEqual(content, wantContent) {
+		t.Errorf("unexpected content: got %+v, want %+v", content, wantContent)
+	}
+	if pm, ok := latestVersion["arch-extra/i3-wm"]; !ok || pm.Version.String() != "4.23-1" {
+		t.Errorf("unexpected latestVersion: got %+v", latestVersion)
+	}
+}
+
+func TestOpenPacmanPackage(t *testing.T) {
+	f, err := ioutil.TempFile("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	writeTarGz(t, f, []string{".PKGINFO", "usr/share/man/man1/i3.1.gz"}, map[string]string{
+		".PKGINFO":                   "pkgname = i3-wm\n",
+		"usr/share/man/man1/i3.1.gz": "",
+	})
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openPackage(f, "i3-wm-4.23-1-x86_64.pkg.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"./.PKGINFO", "./usr/share/man/man1/i3.1.gz"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected file names: got %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Debian/debiman/internal/archive"
+)
+
+var minisrvPath = flag.String("minisrv",
+	"debiman-minisrv",
+	"Path to the debiman-minisrv binary which the serve command runs")
+
+// phase is a step of the debiman pipeline which can be run on its own,
+// e.g. to re-run only the phase which failed.
+type phase struct {
+	name string
+	// arg is the name of the file name argument which the phase
+	// requires, if any.
+	arg  string
+	help string
+	run  func(p *pipeline, arg string) error
+}
+
+// withoutArg adapts f, which does not take an argument, to phase.run.
+func withoutArg(f func(p *pipeline) error) func(p *pipeline, arg string) error {
+	return func(p *pipeline, arg string) error {
+		return f(p)
+	}
+}
+
+var phases = []phase{
+	{"fetch", "", "discover the packages containing manpages in all configured suites, for the following commands (on its own, requires -persist_global_view to cache the result for subsequent runs)", withoutArg((*pipeline).fetch)},
+	{"extract", "", "download and extract manpages of new or updated packages", withoutArg((*pipeline).extract)},
+	{"render", "", "render all outdated manpages, package indexes and sitemaps", withoutArg((*pipeline).render)},
+	{"index", "", "write the debiman-auxserver index and all site-wide indexes", withoutArg((*pipeline).index)},
+	{"sitemap", "", "re-write all sitemaps based on the serving directory", withoutArg((*pipeline).sitemap)},
+	{"gc", "", "remove unreferenced -content_addressed files and, with -gc_removed_packages, the manpages of packages which are no longer in any configured suite (see -gc_tombstones)", withoutArg((*pipeline).gc)},
+	{"verify", "", "check the serving directory invariants (rendered versions up to date, symlinks resolve, package indexes and sitemap targets exist), e.g. before switching a deployment to it", withoutArg((*pipeline).verify)},
+	{"serve", "", "serve the serving directory using debiman-minisrv (-minisrv)", withoutArg((*pipeline).serve)},
+	{"render-one", "<manpage.gz>", "render a single manpage file to -render_one_output (for debugging)", (*pipeline).renderOneFile},
+}
+
+// command is a phase to run, as specified on the command line.
+type command struct {
+	name string
+	arg  string
+}
+
+// defaultPhases are run when no command is specified.
+var defaultPhases = []command{{name: "fetch"}, {name: "extract"}, {name: "render"}, {name: "index"}, {name: "gc"}}
+
+func findPhase(name string) (phase, bool) {
+	for _, ph := range phases {
+		if ph.name == name {
+			return ph, true
+		}
+	}
+	return phase{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command…]\n\n", os.Args[0])
+	names := make([]string, len(defaultPhases))
+	for idx, cmd := range defaultPhases {
+		names[idx] = cmd.name
+	}
+	fmt.Fprintf(os.Stderr, "Commands are run in the order in which they are specified. Without a command, %s is run.\n\n", strings.Join(names, ", "))
+	for _, ph := range phases {
+		fmt.Fprintf(os.Stderr, "  %-25s %s\n", strings.TrimSpace(ph.name+" "+ph.arg), ph.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags (which may also follow a command):\n")
+	flag.PrintDefaults()
+}
+
+// parseCommandLine parses args, which may contain flags before and after
+// each command, and returns the specified commands. File name arguments
+// are made absolute, as debiman changes its working directory.
+func parseCommandLine(args []string) ([]command, error) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return nil, err
+	}
+	var cmds []command
+	for flag.NArg() > 0 {
+		name := flag.Arg(0)
+		ph, ok := findPhase(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+		rest := flag.Args()[1:]
+		cmd := command{name: name}
+		if ph.arg != "" {
+			if len(rest) == 0 {
+				return nil, fmt.Errorf("command %q requires an argument %s", name, ph.arg)
+			}
+			abs, err := filepath.Abs(rest[0])
+			if err != nil {
+				return nil, err
+			}
+			cmd.arg = abs
+			rest = rest[1:]
+		}
+		cmds = append(cmds, cmd)
+		if err := flag.CommandLine.Parse(rest); err != nil {
+			return nil, err
+		}
+	}
+	if *renderOneOutput != "-" {
+		var found bool
+		for _, cmd := range cmds {
+			found = found || cmd.name == "render-one"
+		}
+		if !found {
+			return nil, fmt.Errorf("-render_one_output requires the render-one command")
+		}
+	}
+	if len(cmds) > 0 && !*persistGlobalView {
+		onlyFetch := true
+		for _, cmd := range cmds {
+			onlyFetch = onlyFetch && cmd.name == "fetch"
+		}
+		if onlyFetch {
+			// The discovered packages would be discarded.
+			return nil, fmt.Errorf("the fetch command on its own requires -persist_global_view")
+		}
+	}
+	if len(cmds) == 0 {
+		return defaultPhases, nil
+	}
+	return cmds, nil
+}
+
+// pipeline holds the state shared by the phases of one debiman run.
+type pipeline struct {
+	start  time.Time
+	cutoff time.Time
+	ar     *archive.Getter
+
+	gv         globalView
+	discovered bool
+
+	// rendered is true once the render phase completed, i.e. once the
+	// outputs which are collected while rendering (e.g. -whatis) are
+	// complete.
+	rendered bool
+
+	// phases are the phases which were run so far, see -stats_json.
+	phases []phaseStats
+}
+
+func newPipeline(start time.Time) (*pipeline, error) {
+	p := &pipeline{start: start}
+	if *since != "" {
+		var err error
+		p.cutoff, err = parseSince(*since, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ar := &archive.Getter{
+		ConnectionsPerMirror: 10,
+		LocalMirror:          *localMirror,
+		Keyring:              *keyring,
+		Insecure:             *insecure,
+		RetriesTransient:     downloadRetriesTransient(),
+		Backoff:              *downloadBackoff,
+		Timeout:              *downloadTimeout,
+		CacheDir:             indexCacheDir(),
+		PackageCache:         packageCache(),
+	}
+	if *httpProxy != "" {
+		if err := setHTTPProxy(*httpProxy); err != nil {
+			return nil, err
+		}
+	}
+	if *mirrors != "" {
+		if *localMirror != "" || *snapshot != "" {
+			return nil, fmt.Errorf("-mirrors cannot be combined with -local_mirror or -snapshot")
+		}
+		urls, err := parseMirrorURLs(*mirrors)
+		if err != nil {
+			return nil, fmt.Errorf("-mirrors: %v", err)
+		}
+		ar.MirrorURL = urls[0]
+		ar.Mirrors = urls[1:]
+	}
+	if *snapshot != "" {
+		if *localMirror != "" {
+			return nil, fmt.Errorf("-snapshot and -local_mirror are mutually exclusive")
+		}
+		u, err := snapshotURL(*snapshot)
+		if err != nil {
+			return nil, err
+		}
+		infof("Synchronizing from %s", u)
+		// Be gentle, snapshot.debian.org has little capacity.
+		ar.ConnectionsPerMirror = 2
+		ar.MirrorURL = u
+		if *snapshotKeyring != "" {
+			ar.Keyring = *snapshotKeyring
+		}
+	}
+	p.ar = ar
+	return p, nil
+}
+
+// globalView returns the global view, discovering all packages first if
+// that did not yet happen during this run.
+func (p *pipeline) globalView() (globalView, error) {
+	if p.discovered {
+		return p.gv, nil
+	}
+
+	// Stage 1: all Debian packages of all architectures of the
+	// specified suites are discovered.
+	progress.setStage("discover")
+	var gv globalView
+	if *localDebs != "" {
+		var err error
+		gv, err = buildLocalGlobalView(strings.Split(*localDebs, ","), *localDebsSuite, p.start)
+		if err != nil {
+			return gv, err
+		}
+	} else {
+		dists := distributions(
+			strings.Split(*syncCodenames, ","),
+			strings.Split(*syncSuites, ","))
+		if *federatedDistributions != "" {
+			federated, err := federatedDistributionsFromFile(*federatedDistributions, p.ar.PackageCache)
+			if err != nil {
+				return gv, err
+			}
+			dists = append(dists, federated...)
+		}
+		var err error
+		gv, err = buildGlobalView(p.ar, dists, p.start)
+		if err != nil {
+			return gv, err
+		}
+	}
+
+	infof("gathered packages of all suites, total %d packages", len(gv.pkgs))
+	resolveDiffPairs(diffPairs, gv.idxSuites)
+	if *popconSource != "" {
+		infof("Loading popcon data from %q", *popconSource)
+		var err error
+		if popcon, err = loadPopcon(*popconSource); err != nil {
+			return gv, err
+		}
+	}
+	progress.setStats(gv.stats)
+	gv.since = p.cutoff
+
+	p.gv = gv
+	p.discovered = true
+	return gv, nil
+}
+
+func (p *pipeline) fetch() error {
+	_, err := p.globalView()
+	return err
+}
+
+func (p *pipeline) extract() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+
+	// Stage 2: man pages and auxilliary files (e.g. content fragment
+	// files which are included by a number of manpages) are extracted
+	// from the identified Debian packages.
+	progress.setStage("extract")
+	progress.setTotal(uint64(len(gv.pkgs)))
+	if err := parallelDownload(p.ar, gv); err != nil {
+		return err
+	}
+
+	infof("Extracted all manpages")
+	return nil
+}
+
+func (p *pipeline) render() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+
+	// Stage 3: all man pages are rendered into an HTML representation
+	// using mandoc(1), directory index files are rendered, contents
+	// files are rendered.
+	progress.setStage("render")
+	if !p.cutoff.IsZero() {
+		warningf("partial render: -since=%s skips all sources last modified before %v, even if their rendered versions are out of date. A full run is still needed for correctness.", *since, p.cutoff)
+	}
+	journal, err := openRenderJournal(gv.since)
+	if err != nil {
+		return err
+	}
+	gv.journal = journal
+	if err := renderAll(gv); err != nil {
+		// The journal is kept, so that the next run can resume.
+		journal.close(false)
+		return err
+	}
+	if err := journal.close(true); err != nil {
+		return err
+	}
+	p.rendered = true
+
+	infof("Rendered all manpages")
+	return nil
+}
+
+func (p *pipeline) index() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+
+	// Stage 4: write the index only after all rendering is complete,
+	// otherwise debiman-auxserver might serve redirects to pages
+	// which cannot be served yet.
+	progress.setStage("index")
+	path := strings.Replace(*indexPath, "<serving_dir>", *servingDir, -1)
+	infof("Writing debiman-auxserver index to %q", path)
+	if err := writeIndex(path, gv); err != nil {
+		return err
+	}
+	infof("Rendering A–Z index")
+	if err := renderAZIndex(gv); err != nil {
+		return err
+	}
+	infof("Rendering section index")
+	if err := renderSectionIndex(gv); err != nil {
+		return err
+	}
+	infof("Rendering language index")
+	if err := renderLanguageIndex(gv); err != nil {
+		return err
+	}
+	if *dumpXrefPath != "" {
+		infof("Writing cross-reference index to %q", *dumpXrefPath)
+		if err := dumpXref(*dumpXrefPath, gv); err != nil {
+			return err
+		}
+	}
+
+	if *exportBulkDest != "" {
+		infof("Exporting manpages to %q", *exportBulkDest)
+		progress.setStage("export")
+		if err := exportBulk(*exportBulkDest, gv); err != nil {
+			return err
+		}
+	}
+
+	if *hardlinkDuplicates {
+		infof("Hard linking identical files across suites")
+		progress.setStage("hardlink")
+		if err := hardlinkIdenticalFiles(gv.suites, gv.stats); err != nil {
+			return err
+		}
+	}
+
+	if err := renderAux(*servingDir, gv); err != nil {
+		return err
+	}
+
+	if err := writeCachePolicy(*servingDir); err != nil {
+		return err
+	}
+
+	if !p.rendered {
+		// The following outputs are collected while rendering and
+		// would be incomplete, so keep the existing files.
+		infof("Not writing outputs collected while rendering (e.g. -whatis): the render command did not run")
+		return nil
+	}
+
+	if gv.lint != nil {
+		if err := gv.lint.write(*servingDir); err != nil {
+			return err
+		}
+	}
+
+	if gv.refs != nil {
+		if err := gv.refs.write(*servingDir); err != nil {
+			return err
+		}
+	}
+
+	if gv.whatis != nil {
+		if err := gv.whatis.write(*servingDir, gv); err != nil {
+			return err
+		}
+	}
+
+	if *writeClientSearchIndex {
+		if err := writeClientSearch(*servingDir, gv); err != nil {
+			return err
+		}
+	}
+
+	if gv.search != nil {
+		if err := gv.search.write(*servingDir, gv); err != nil {
+			return err
+		}
+	}
+
+	if gv.feeds != nil {
+		if err := gv.feeds.write(*servingDir, gv.suites, gv.suiteTitle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) sitemap() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+	progress.setStage("sitemap")
+	return renderSitemaps(gv)
+}
+
+func (p *pipeline) gc() error {
+	gv, err := p.globalView()
+	if err != nil {
+		return err
+	}
+	progress.setStage("gc")
+	if *gcRemovedPackages {
+		if err := gcPackages(gv); err != nil {
+			return err
+		}
+	}
+	if *contentAddressed {
+		if err := gcPool(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) serve() error {
+	cmd := exec.Command(*minisrvPath, "-serving_dir="+*servingDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	infof("Running %v", cmd.Args)
+	return cmd.Run()
+}
+
+// printStats prints the statistics of a run which rendered manpages and
+// writes them to <serving_dir>/metrics.txt.
+func (p *pipeline) printStats() error {
+	gv := p.gv
+	fmt.Printf("total number of packages: %d\n", len(gv.pkgs))
+	fmt.Printf("packages extracted:       %d\n", gv.stats.PackagesExtracted)
+	fmt.Printf("packages deleted:         %d\n", gv.stats.PackagesDeleted)
+	fmt.Printf("manpages rendered:        %d\n", gv.stats.ManpagesRendered)
+	fmt.Printf("disambiguations rendered: %d\n", gv.stats.DisambiguationsRendered)
+	if gv.lint != nil {
+		fmt.Printf("mandoc warnings:          %d\n", gv.stats.MandocWarnings)
+	}
+	if gv.refs != nil {
+		fmt.Printf("dangling references:      %d\n", gv.stats.DanglingReferences)
+	}
+	fmt.Printf("total manpage bytes:      %d\n", gv.stats.ManpageBytes)
+	fmt.Printf("total HTML bytes:         %d\n", gv.stats.HtmlBytes)
+	if *brotliOutput {
+		fmt.Printf("total brotli bytes:       %d\n", gv.stats.BrotliBytes)
+	}
+	if zstdOutput {
+		fmt.Printf("total zstd bytes:         %d\n", gv.stats.ZstdBytes)
+	}
+	fmt.Printf("auxserver index bytes:    %d\n", gv.stats.IndexBytes)
+	if *mandocTimeout > 0 {
+		fmt.Printf("conversion timeouts:      %d\n", gv.stats.ConversionTimeouts)
+	}
+	if *hardlinkDuplicates {
+		fmt.Printf("hard linked files:        %d\n", gv.stats.HardlinkedFiles)
+		fmt.Printf("hard linked bytes saved:  %d\n", gv.stats.HardlinkedBytes)
+	}
+	suites := make([]string, 0, len(gv.stats.Suites))
+	for suite := range gv.stats.Suites {
+		suites = append(suites, suite)
+	}
+	sort.Strings(suites)
+	for _, suite := range suites {
+		ss := gv.stats.Suites[suite]
+		fmt.Printf("suite %s:\n", suite)
+		fmt.Printf("  manpages rendered:      %d\n", ss.ManpagesRendered)
+		fmt.Printf("  total manpage bytes:    %d\n", ss.ManpageBytes)
+		fmt.Printf("  total HTML bytes:       %d\n", ss.HtmlBytes)
+		if *brotliOutput {
+			fmt.Printf("  total brotli bytes:     %d\n", ss.BrotliBytes)
+		}
+		if zstdOutput {
+			fmt.Printf("  total zstd bytes:       %d\n", ss.ZstdBytes)
+		}
+	}
+	fmt.Printf("wall-clock runtime (s):   %d\n", int(time.Now().Sub(p.start).Seconds()))
+
+	return writeAtomically(filepath.Join(*servingDir, "metrics.txt"), false, func(w io.Writer) error {
+		return writeMetrics(w, gv, p.start)
+	})
+}
+
+// runPhases runs the phases specified by cmds in order.
+func runPhases(cmds []command) error {
+	p, err := newPipeline(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		return p.dryRun()
+	}
+
+	// -render_one re-renders the manpage within the serving directory.
+	if *renderOne != "" || needsLock(cmds) {
+		lock, err := lockServingDir()
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+	}
+
+	if *renderOne != "" {
+		gv, err := p.globalView()
+		if err != nil {
+			return err
+		}
+		return renderSelected(gv, *renderOne)
+	}
+
+	err = p.runCommands(cmds)
+	logSummary.print()
+	if err == nil {
+		err = logSummary.check()
+	}
+	if *statsJSON != "" {
+		// The statistics are written for failed runs as well, so that
+		// monitoring notices them.
+		if jerr := p.writeStatsJSON(*statsJSON, err); jerr != nil && err == nil {
+			err = jerr
+		}
+	}
+	return err
+}
+
+func (p *pipeline) runCommands(cmds []command) error {
+	for _, cmd := range cmds {
+		ph, ok := findPhase(cmd.name)
+		if !ok {
+			return fmt.Errorf("unknown command %q", cmd.name)
+		}
+		infof("Running command %q", cmd.name)
+		start := time.Now()
+		err := ph.run(p, cmd.arg)
+		p.phases = append(p.phases, phaseStats{
+			Name:    cmd.name,
+			Arg:     cmd.arg,
+			Seconds: time.Since(start).Seconds(),
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", cmd.name, err)
+		}
+	}
+
+	if !p.rendered {
+		return nil
+	}
+	return p.printStats()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	old := *onlyRender
+	defer flag.Set("only_render_pkgs", old)
+	defer flag.Set("persist_global_view", "false")
+	defer flag.Set("render_one_output", "-")
+
+	for _, tt := range []struct {
+		args        []string
+		want        []command
+		wantErr     bool
+		wantOnlyPkg string
+	}{
+		{args: nil, want: defaultPhases},
+		{args: []string{"-only_render_pkgs=i3-wm"}, want: defaultPhases, wantOnlyPkg: "i3-wm"},
+		{args: []string{"render"}, want: []command{{name: "render"}}},
+		{args: []string{"render", "-only_render_pkgs=cron", "index"}, want: []command{{name: "render"}, {name: "index"}}, wantOnlyPkg: "cron"},
+		{args: []string{"-only_render_pkgs=cron", "extract", "render"}, want: []command{{name: "extract"}, {name: "render"}}, wantOnlyPkg: "cron"},
+		{args: []string{"fetch", "render-one", "/tmp/i3.1.gz", "-only_render_pkgs=i3-wm"}, want: []command{{name: "fetch"}, {name: "render-one", arg: "/tmp/i3.1.gz"}}, wantOnlyPkg: "i3-wm"},
+		{args: []string{"render-one"}, wantErr: true},
+		{args: []string{"render-one", "/tmp/i3.1.gz", "-render_one_output=/tmp/i3.html"}, want: []command{{name: "render-one", arg: "/tmp/i3.1.gz"}}},
+		{args: []string{"-render_one_output=/tmp/i3.html"}, wantErr: true},
+		{args: []string{"rendr"}, wantErr: true},
+		{args: []string{"fetch"}, wantErr: true},
+		{args: []string{"fetch", "-persist_global_view"}, want: []command{{name: "fetch"}}},
+	} {
+		flag.Set("only_render_pkgs", "")
+		flag.Set("render_one_output", "-")
+		flag.Set("persist_global_view", "false")
+		got, err := parseCommandLine(tt.args)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("parseCommandLine(%q): got err %v, want error: %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommandLine(%q): got %+v, want %+v", tt.args, got, tt.want)
+		}
+		if got, want := *onlyRender, tt.wantOnlyPkg; got != want {
+			t.Errorf("parseCommandLine(%q): -only_render_pkgs: got %q, want %q", tt.args, got, want)
+		}
+	}
+}
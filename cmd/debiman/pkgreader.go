@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"xi2.org/x/xz"
+)
+
+// pkgReader iterates over the files of a package (e.g. the data.tar
+// member of a .deb or the cpio payload of an RPM), like tar.Reader.
+// File and hard link names are normalized to start with ./, like in
+// .deb files.
+type pkgReader struct {
+	io.Reader // contents of the current file
+	next      func() (*tar.Header, error)
+	close     func()
+}
+
+// newTarPkgReader returns a pkgReader for the files of tr.
+func newTarPkgReader(tr *tar.Reader, close func()) *pkgReader {
+	return &pkgReader{
+		Reader: tr,
+		next:   tr.Next,
+		close:  close,
+	}
+}
+
+// Next advances to the next file of the package.
+func (r *pkgReader) Next() (*tar.Header, error) {
+	header, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(header.Name, "./") {
+		header.Name = "./" + strings.TrimPrefix(header.Name, "/")
+	}
+	if header.Typeflag == tar.TypeLink && !strings.HasPrefix(header.Linkname, "./") {
+		header.Linkname = "./" + strings.TrimPrefix(header.Linkname, "/")
+	}
+	return header, nil
+}
+
+// Close releases the resources associated with r.
+func (r *pkgReader) Close() {
+	if r.close != nil {
+		r.close()
+	}
+}
+
+// isPacmanPackage returns whether filename is a pacman package, e.g.
+// bash-5.2.026-2-x86_64.pkg.tar.zst.
+func isPacmanPackage(filename string) bool {
+	return strings.Contains(filename, ".pkg.tar")
+}
+
+// isRPMPackage returns whether filename is an RPM package, e.g.
+// bash-5.2.26-3.fc40.x86_64.rpm.
+func isRPMPackage(filename string) bool {
+	return strings.HasSuffix(filename, ".rpm")
+}
+
+// openPackage returns a pkgReader for the files of the package stored
+// in f, whose format is determined by filename.
+func openPackage(f *os.File, filename string) (*pkgReader, error) {
+	if isRPMPackage(filename) {
+		r, close, err := openRPMPayload(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", filename, err)
+		}
+		cr := newCPIOReader(r)
+		return &pkgReader{
+			Reader: cr,
+			next:   cr.Next,
+			close:  close,
+		}, nil
+	}
+	if !isPacmanPackage(filename) {
+		d, err := loadDeb(f)
+		if err != nil {
+			return nil, err
+		}
+		return newTarPkgReader(d.Data, d.Close), nil
+	}
+
+	r, close, err := decompressByName(f, filename)
+	if err != nil {
+		return nil, err
+	}
+	return newTarPkgReader(tar.NewReader(r), close), nil
+}
+
+// decompressByName decompresses r, detecting its compression (gzip,
+// xz, zstd, bzip2 or none for .tar) by filename (e.g. data.tar.zst or
+// bash-5.2.026-2-x86_64.pkg.tar.zst). The returned function releases
+// the resources of the decompressor.
+func decompressByName(r io.Reader, filename string) (io.Reader, func(), error) {
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		rd, err := gzip.NewReader(r)
+		return rd, func() {}, err
+	case strings.HasSuffix(filename, ".xz"):
+		rd, err := xz.NewReader(r, 0)
+		return rd, func() {}, err
+	case strings.HasSuffix(filename, ".zst"):
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, dec.Close, nil
+	case strings.HasSuffix(filename, ".bz2"):
+		return bzip2.NewReader(r), func() {}, nil
+	case strings.HasSuffix(filename, ".tar"):
+		return r, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("%q: unsupported compression", filename)
+}
+
+// decompressByMagic decompresses r (e.g. a pacman repository database
+// or an RPM payload), detecting its compression (gzip, zstd, xz or
+// none) by its magic bytes. The returned function releases the
+// resources of the decompressor.
+func decompressByMagic(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		rd, err := gzip.NewReader(br)
+		return rd, func() {}, err
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		dec, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, dec.Close, nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		rd, err := xz.NewReader(br, 0)
+		return rd, func() {}, err
+	}
+	return br, func() {}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var popconSource = flag.String("popcon",
+	"",
+	"If non-empty, an HTTP(S) URL or file system path of Debian popularity contest data in the by_inst format, optionally gzip-compressed (e.g. https://popcon.debian.org/by_inst.gz). When multiple packages ship a manpage of the same name, the most installed packages are listed first and are the default redirect target of debiman-auxserver.")
+
+// popcon maps binary package names to their number of installations, as
+// loaded from -popcon on startup. nil unless -popcon is set.
+var popcon map[string]int64
+
+// parsePopcon parses popularity contest data in the by_inst format, in
+// which each line contains the rank, name and number of installations
+// of a package, followed by further columns.
+func parsePopcon(r io.Reader) (map[string]int64, error) {
+	result := make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		if _, err := strconv.ParseInt(fields[0], 0, 64); err != nil {
+			continue // e.g. the separator preceding the total
+		}
+		if fields[1] == "Total" {
+			continue
+		}
+		inst, err := strconv.ParseInt(fields[2], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid popcon line %q: %v", line, err)
+		}
+		result[fields[1]] = inst
+	}
+	return result, scanner.Err()
+}
+
+// loadPopcon loads the popularity contest data from src (see -popcon).
+func loadPopcon(src string) (map[string]int64, error) {
+	var rc io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := http.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: unexpected HTTP status code: got %d, want %d", src, got, want)
+		}
+		rc = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if strings.HasSuffix(src, ".gz") {
+		gzipr, err := gzip.NewReader(rc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", src, err)
+		}
+		defer gzipr.Close()
+		r = gzipr
+	}
+	result, err := parsePopcon(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	return result, nil
+}
+
+// popconLess orders the binary packages a and b by decreasing number of
+// installations, then by name. Without -popcon, packages are ordered by
+// name.
+func popconLess(a, b string) bool {
+	if popcon[a] != popcon[b] {
+		return popcon[a] > popcon[b]
+	}
+	return a < b
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+const testPopcon = `#Format
+#
+#<name> is the package name;
+#<inst> is the number of people who installed this package;
+#rank name                            inst  vote   old recent no-files (maintainer)
+1     cron                           194523 181735  1208 11565    15 (Javier Fernández-Sanguino Peña)
+2     systemd-cron                     412    301    63    48     0 (Alexandre Detiste)
+3     bcron                             97     45    39    13     0 (Gerrit Pape)
+--------------------------------------------------------------------------------------------------
+99999 Total                          195382 182081  1310 11626    15
+`
+
+func TestParsePopcon(t *testing.T) {
+	got, err := parsePopcon(strings.NewReader(testPopcon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		"cron":         194523,
+		"systemd-cron": 412,
+		"bcron":        97,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsePopcon: got %v, want %v", got, want)
+	}
+
+	if _, err := parsePopcon(strings.NewReader("1 cron many\n")); err == nil {
+		t.Fatalf("parsePopcon unexpectedly succeeded on an invalid number of installations")
+	}
+}
+
+func TestPopconOrder(t *testing.T) {
+	gv := newGlobalView(1, time.Now())
+	for _, pkg := range []string{"bcron", "cron", "systemd-cron", "anacron"} {
+		m, err := manpage.FromManPath("man5/crontab.5.gz", &manpage.PkgMeta{
+			Binarypkg: pkg,
+			Suite:     "jessie",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gv.xref[m.Name] = append(gv.xref[m.Name], m)
+	}
+	binarypkgs := func() []string {
+		var result []string
+		for _, e := range newIndex(gv).Entry {
+			result = append(result, e.Binarypkg)
+		}
+		return result
+	}
+
+	if got, want := binarypkgs(), []string{"bcron", "cron", "systemd-cron", "anacron"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("index without popcon: got %v, want %v", got, want)
+	}
+
+	var err error
+	if popcon, err = parsePopcon(strings.NewReader(testPopcon)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { popcon = nil }()
+
+	if got, want := binarypkgs(), []string{"cron", "systemd-cron", "bcron", "anacron"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("index with popcon: got %v, want %v", got, want)
+	}
+
+	mans := ambiguousIn("jessie", gv.xref["crontab"])
+	var got []string
+	for _, m := range mans {
+		got = append(got, m.Package.Binarypkg)
+	}
+	if want := []string{"cron", "systemd-cron", "bcron", "anacron"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("disambiguation with popcon: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	progressInterval = flag.Duration("progress_interval",
+		1*time.Minute,
+		"If non-zero, how often to log the progress of the current stage (packages processed, manpages rendered, bytes written and the estimated remaining time)")
+
+	progressBar = flag.Bool("progress_bar",
+		false,
+		"Display a progress bar of the current stage on standard error, updated every second. Only useful when running debiman interactively in a terminal.")
+)
+
+// progressBarWidth is the number of characters of the bar itself.
+const progressBarWidth = 40
+
+// eta returns the estimated remaining time to process total items when
+// processing done items took elapsed, or zero if it cannot be
+// estimated (yet).
+func eta(done, total uint64, elapsed time.Duration) time.Duration {
+	if done == 0 || total == 0 || done >= total {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+}
+
+// countPackageDirs returns the number of binary package directories of
+// all suites of gv which the render stage walks.
+func countPackageDirs(gv globalView, whitelist map[string]bool) uint64 {
+	var total uint64
+	for suite := range gv.suites {
+		dir, err := os.Open(filepath.Join(*servingDir, suite))
+		if err != nil {
+			continue
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if whitelist == nil || whitelist[name] {
+				total++
+			}
+		}
+	}
+	return total
+}
+
+// percent returns how much of the stage is done, or -1 if unknown.
+func (ev progressEvent) percent() int {
+	if ev.PackagesTotal == 0 {
+		return -1
+	}
+	pct := int(ev.PackagesProcessed * 100 / ev.PackagesTotal)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// String formats ev as a log line.
+func (ev progressEvent) String() string {
+	stage := ev.Stage
+	if ev.Suite != "" {
+		stage += " (suite " + ev.Suite + ")"
+	}
+	line := fmt.Sprintf("progress: stage %s: %d", stage, ev.PackagesProcessed)
+	if pct := ev.percent(); pct > -1 {
+		line += fmt.Sprintf("/%d packages (%d%%)", ev.PackagesTotal, pct)
+	} else {
+		line += " packages"
+	}
+	if ev.Stats != nil {
+		line += fmt.Sprintf(", %d manpages rendered", ev.Stats.ManpagesRendered)
+	}
+	line += fmt.Sprintf(", %d bytes written", ev.BytesWritten)
+	if ev.ETASeconds > 0 {
+		line += fmt.Sprintf(", ETA %v", time.Duration(ev.ETASeconds)*time.Second)
+	}
+	return line
+}
+
+// bar formats ev as a progress bar for terminals.
+func (ev progressEvent) bar() string {
+	pct := ev.percent()
+	filled := 0
+	if pct > -1 {
+		filled = pct * progressBarWidth / 100
+	}
+	bar := "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+	status := fmt.Sprintf(" %-9s %d", ev.Stage, ev.PackagesProcessed)
+	if pct > -1 {
+		status += fmt.Sprintf("/%d %3d%%", ev.PackagesTotal, pct)
+	}
+	if ev.ETASeconds > 0 {
+		status += fmt.Sprintf(" ETA %v", time.Duration(ev.ETASeconds)*time.Second)
+	}
+	return bar + status
+}
+
+// reportProgress logs the progress every -progress_interval and
+// updates the -progress_bar every second, until the process exits.
+func reportProgress() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	var lastBar int
+	for now := range ticker.C {
+		ev := progress.event()
+		if ev.Stage == "" {
+			continue
+		}
+		if *progressBar {
+			bar := ev.bar()
+			// Overwrite the previous bar, including any characters
+			// which the (possibly longer) previous bar left behind.
+			fmt.Fprintf(os.Stderr, "\r%-*s", lastBar, bar)
+			lastBar = len(bar)
+		}
+		if *progressInterval > 0 && now.Sub(lastLog) >= *progressInterval {
+			lastLog = now
+			if *progressBar {
+				fmt.Fprintln(os.Stderr)
+			}
+			infof("%s", ev)
+		}
+	}
+}
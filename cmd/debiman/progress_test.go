@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETA(t *testing.T) {
+	for _, tt := range []struct {
+		done, total uint64
+		elapsed     time.Duration
+		want        time.Duration
+	}{
+		{done: 0, total: 100, elapsed: 10 * time.Second, want: 0},
+		{done: 10, total: 0, elapsed: 10 * time.Second, want: 0},
+		{done: 10, total: 100, elapsed: 10 * time.Second, want: 90 * time.Second},
+		{done: 50, total: 100, elapsed: 1 * time.Minute, want: 1 * time.Minute},
+		{done: 100, total: 100, elapsed: 1 * time.Minute, want: 0},
+		{done: 120, total: 100, elapsed: 1 * time.Minute, want: 0},
+	} {
+		if got := eta(tt.done, tt.total, tt.elapsed); got != tt.want {
+			t.Errorf("eta(%d, %d, %v): got %v, want %v", tt.done, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestProgressEventFormat(t *testing.T) {
+	ev := progressEvent{
+		Stage:             "render",
+		Suite:             "testing",
+		PackagesProcessed: 250,
+		PackagesTotal:     1000,
+		BytesWritten:      4096,
+		Stats:             &stats{ManpagesRendered: 42},
+		ETASeconds:        90,
+	}
+	want := "progress: stage render (suite testing): 250/1000 packages (25%), 42 manpages rendered, 4096 bytes written, ETA 1m30s"
+	if got := ev.String(); got != want {
+		t.Errorf("String(): got %q, want %q", got, want)
+	}
+	bar := ev.bar()
+	if got, want := strings.Count(bar, "="), progressBarWidth/4; got != want {
+		t.Errorf("bar(): got %d filled characters, want %d (bar %q)", got, want, bar)
+	}
+	if !strings.Contains(bar, "250/1000  25% ETA 1m30s") {
+		t.Errorf("bar(): %q does not contain the progress", bar)
+	}
+
+	unknown := progressEvent{Stage: "discover", PackagesProcessed: 3}
+	want = "progress: stage discover: 3 packages, 0 bytes written"
+	if got := unknown.String(); got != want {
+		t.Errorf("String(): got %q, want %q", got, want)
+	}
+	if got, want := strings.Count(unknown.bar(), "="), 0; got != want {
+		t.Errorf("bar(): got %d filled characters, want %d", got, want)
+	}
+}
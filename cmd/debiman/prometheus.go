@@ -23,15 +23,52 @@ packages_deleted {{ .Stats.PackagesDeleted }}
 # TYPE manpages_rendered gauge
 manpages_rendered {{ .Stats.ManpagesRendered }}
 
+# HELP disambiguations_rendered Number of name disambiguation pages rendered to HTML
+# TYPE disambiguations_rendered gauge
+disambiguations_rendered {{ .Stats.DisambiguationsRendered }}
+
+# HELP mandoc_warnings Number of warnings mandoc emitted for the manpages rendered (only with -collect_warnings).
+# TYPE mandoc_warnings gauge
+mandoc_warnings {{ .Stats.MandocWarnings }}
+
+# HELP dangling_references Number of SEE ALSO references to manpages which do not exist (only with -backlinks).
+# TYPE dangling_references gauge
+dangling_references {{ .Stats.DanglingReferences }}
+
+# HELP conversion_timeouts Number of manpages whose conversion exceeded -mandoc_timeout.
+# TYPE conversion_timeouts gauge
+conversion_timeouts {{ .Stats.ConversionTimeouts }}
+
 # HELP manpage_bytes Total number of bytes used by manpages (by format).
 # TYPE manpage_bytes gauge
 manpage_bytes{format="man"} {{ .Stats.ManpageBytes }}
 manpage_bytes{format="html"} {{ .Stats.HtmlBytes }}
+manpage_bytes{format="html_brotli"} {{ .Stats.BrotliBytes }}
+manpage_bytes{format="html_zstd"} {{ .Stats.ZstdBytes }}
 
+# HELP suite_manpages_rendered Number of manpages rendered to HTML (by suite).
+# TYPE suite_manpages_rendered gauge
+{{ range $suite, $s := .Stats.Suites }}suite_manpages_rendered{suite="{{ $suite }}"} {{ $s.ManpagesRendered }}
+{{ end }}
+# HELP suite_manpage_bytes Number of bytes used by manpages (by suite and format).
+# TYPE suite_manpage_bytes gauge
+{{ range $suite, $s := .Stats.Suites }}suite_manpage_bytes{suite="{{ $suite }}",format="man"} {{ $s.ManpageBytes }}
+suite_manpage_bytes{suite="{{ $suite }}",format="html"} {{ $s.HtmlBytes }}
+suite_manpage_bytes{suite="{{ $suite }}",format="html_brotli"} {{ $s.BrotliBytes }}
+suite_manpage_bytes{suite="{{ $suite }}",format="html_zstd"} {{ $s.ZstdBytes }}
+{{ end }}
 # HELP index_bytes Total number of bytes used for the auxserver index.
 # TYPE index_bytes gauge
 index_bytes {{ .Stats.IndexBytes }}
 
+# HELP hardlinked_files Number of files replaced with hard links to identical files in other suites (only with -hardlink_identical).
+# TYPE hardlinked_files gauge
+hardlinked_files {{ .Stats.HardlinkedFiles }}
+
+# HELP hardlinked_bytes Number of bytes saved by replacing files with hard links (only with -hardlink_identical).
+# TYPE hardlinked_bytes gauge
+hardlinked_bytes {{ .Stats.HardlinkedBytes }}
+
 # HELP runtime Wall-clock runtime in seconds.
 # TYPE runtime gauge
 runtime {{ .Seconds }}
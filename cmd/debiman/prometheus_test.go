@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	gv := globalView{
+		stats: &stats{
+			ManpagesRendered:   2,
+			DanglingReferences: 3,
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, gv, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\nmanpages_rendered 2\n",
+		"\ndangling_references 3\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var collectBacklinks = flag.Bool("backlinks",
+	false,
+	"Collect the SEE ALSO references of each rendered manpage into references-<suite>.json, including dangling references (those which do not resolve to a manpage of the suite), and list the manpages referring to each manpage in a “referenced by” panel. The panel is based on the references collected by previous runs, so use -force_rerender once after the first run.")
+
+// manpageRefs are the SEE ALSO references of a manpage.
+type manpageRefs struct {
+	// SeeAlso contains the serving paths of the referenced manpages.
+	SeeAlso []string `json:"see_also,omitempty"`
+
+	// Dangling contains the references (e.g. “crontab(5)”) which do not
+	// resolve to a manpage of the suite.
+	Dangling []string `json:"dangling,omitempty"`
+}
+
+// referenceGraph collects the SEE ALSO references per manpage, keyed by
+// suite and serving path.
+type referenceGraph struct {
+	stats *stats
+
+	// referencedBy maps serving paths to the manpages referencing them,
+	// as collected by previous runs. Populated by load and not modified
+	// afterwards, so it can be read without locking.
+	referencedBy map[string][]*manpage.Meta
+
+	// loaded contains the references collected by previous runs, keyed
+	// by suite, without those of manpages which are no longer part of
+	// the suite. Populated by load, merged with refs by write.
+	loaded map[string]map[string]manpageRefs
+
+	mu   sync.Mutex
+	refs map[string]map[string]manpageRefs
+}
+
+func newReferenceGraph(stats *stats) *referenceGraph {
+	return &referenceGraph{
+		stats:        stats,
+		referencedBy: make(map[string][]*manpage.Meta),
+		loaded:       make(map[string]map[string]manpageRefs),
+		refs:         make(map[string]map[string]manpageRefs),
+	}
+}
+
+func referencesPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("references-%s.json", suite))
+}
+
+func readReferences(path string) (map[string]manpageRefs, error) {
+	refs := make(map[string]manpageRefs)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &refs); err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", path, err)
+	}
+	return refs, nil
+}
+
+// load populates referencedBy from the references-<suite>.json files in
+// destDir. References from manpages which are no longer part of gv are
+// pruned, i.e. skipped and removed from the files by write.
+func (g *referenceGraph) load(destDir string, gv globalView) error {
+	byServingPath := make(map[string]*manpage.Meta)
+	for _, versions := range gv.xref {
+		for _, v := range versions {
+			byServingPath[v.ServingPath()] = v
+		}
+	}
+	for suite := range gv.suites {
+		refs, err := readReferences(referencesPath(destDir, suite))
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		for servingPath, r := range refs {
+			m, ok := byServingPath[servingPath]
+			if !ok {
+				delete(refs, servingPath)
+				continue
+			}
+			for _, target := range r.SeeAlso {
+				g.referencedBy[target] = append(g.referencedBy[target], m)
+			}
+		}
+		g.loaded[suite] = refs
+	}
+	for _, sources := range g.referencedBy {
+		sort.Sort(byNameSection(sources))
+	}
+	return nil
+}
+
+// sources returns the manpages referencing m. sources can be called on
+// a nil *referenceGraph.
+func (g *referenceGraph) sources(m *manpage.Meta) []*manpage.Meta {
+	if g == nil {
+		return nil
+	}
+	return g.referencedBy[m.ServingPath()]
+}
+
+// record stores the SEE ALSO references within content, the rendered
+// manpage m, replacing any previously recorded references for m.
+func (g *referenceGraph) record(m *manpage.Meta, xref map[string][]*manpage.Meta, content string) {
+	found, err := convert.SeeAlsoRefs(content)
+	if err != nil {
+		warningf("extracting SEE ALSO references of %q: %v", m.ServingPath(), err)
+		return
+	}
+	var refs manpageRefs
+	seen := make(map[string]bool)
+	for _, ref := range found {
+		target := resolveXref(m, xref, ref)
+		if target == nil {
+			refs.Dangling = append(refs.Dangling, ref)
+			continue
+		}
+		servingPath := target.ServingPath()
+		if servingPath == m.ServingPath() || seen[servingPath] {
+			continue
+		}
+		seen[servingPath] = true
+		refs.SeeAlso = append(refs.SeeAlso, servingPath)
+	}
+	atomic.AddUint64(&g.stats.DanglingReferences, uint64(len(refs.Dangling)))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bySuite, ok := g.refs[m.Package.Suite]
+	if !ok {
+		bySuite = make(map[string]manpageRefs)
+		g.refs[m.Package.Suite] = bySuite
+	}
+	bySuite[m.ServingPath()] = refs
+}
+
+// write merges the recorded references into the references-<suite>.json
+// files in destDir. Manpages which were not re-rendered in this run keep
+// their previous entries, manpages without references and those pruned
+// by load are removed.
+func (g *referenceGraph) write(destDir string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	suites := make(map[string]bool)
+	for suite := range g.loaded {
+		suites[suite] = true
+	}
+	for suite := range g.refs {
+		suites[suite] = true
+	}
+	for suite := range suites {
+		path := referencesPath(destDir, suite)
+		merged, ok := g.loaded[suite]
+		if !ok {
+			var err error
+			merged, err = readReferences(path)
+			if err != nil {
+				return err
+			}
+		}
+		for servingPath, refs := range g.refs[suite] {
+			if len(refs.SeeAlso) == 0 && len(refs.Dangling) == 0 {
+				delete(merged, servingPath)
+			} else {
+				merged[servingPath] = refs
+			}
+		}
+		recordArtifact(artifactData, path)
+		if err := writeAtomically(path, false, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(merged)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
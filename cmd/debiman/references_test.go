@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// newTestServingDir points -serving_dir to a new temporary directory
+// and disables compression. The returned function restores both flags
+// and removes the directory.
+func newTestServingDir(t *testing.T) (string, func()) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	oldNoCompress := *noCompress
+	*noCompress = true
+	return tmpdir, func() {
+		*servingDir = oldServingDir
+		*noCompress = oldNoCompress
+		os.RemoveAll(tmpdir)
+	}
+}
+
+// newTestI3View returns a globalView of jessie containing i3(1),
+// i3-msg(1) and the manpages at the specified paths (relative to
+// /usr/share/man) of i3-wm.
+func newTestI3View(t *testing.T, paths ...string) globalView {
+	return newTestGlobalView(t, "jessie", "i3-wm", append([]string{"man1/i3.1.gz", "man1/i3-msg.1.gz"}, paths...)...)
+}
+
+func TestReferenceGraph(t *testing.T) {
+	tmpdir, cleanup := newTestServingDir(t)
+	defer cleanup()
+
+	gv := newTestI3View(t, "man1/i3bar.1.gz")
+	i3 := gv.xref["i3"][0]
+	i3msg := gv.xref["i3-msg"][0]
+	i3bar := gv.xref["i3bar"][0]
+
+	g := newReferenceGraph(gv.stats)
+	g.record(i3, gv.xref, `<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1>`+
+		`<a href="/jessie/i3-wm/i3-msg.1.en.html">i3-msg(1)</a>, <b>i3bar</b>(1), <b>i3-msg</b>(1), <b>i3status</b>(1)`)
+	g.record(i3msg, gv.xref, `<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1><b>i3</b>(1)`)
+	g.record(i3bar, gv.xref, `<h1 class="Sh" id="DESCRIPTION">DESCRIPTION</h1><b>i3</b>(1)`)
+	if got, want := gv.stats.DanglingReferences, uint64(1); got != want {
+		t.Errorf("got %d dangling references, want %d", got, want)
+	}
+	if err := g.write(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readReferences(referencesPath(tmpdir, "jessie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]manpageRefs{
+		"jessie/i3-wm/i3.1.en": {
+			SeeAlso:  []string{"jessie/i3-wm/i3-msg.1.en", "jessie/i3-wm/i3bar.1.en"},
+			Dangling: []string{"i3status(1)"},
+		},
+		"jessie/i3-wm/i3-msg.1.en": {
+			SeeAlso: []string{"jessie/i3-wm/i3.1.en"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected references: got %+v, want %+v", got, want)
+	}
+
+	loaded := newReferenceGraph(gv.stats)
+	if err := loaded.load(tmpdir, gv); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		m    string
+		want []string
+	}{
+		{"i3", []string{"jessie/i3-wm/i3-msg.1.en"}},
+		{"i3-msg", []string{"jessie/i3-wm/i3.1.en"}},
+		{"i3bar", []string{"jessie/i3-wm/i3.1.en"}},
+	} {
+		var got []string
+		for _, m := range loaded.sources(gv.xref[tt.m][0]) {
+			got = append(got, m.ServingPath())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sources(%s): got %v, want %v", tt.m, got, tt.want)
+		}
+	}
+
+	// References from manpages which were removed from the suite are
+	// pruned when loading.
+	g = newReferenceGraph(gv.stats)
+	g.record(i3bar, gv.xref, `<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1><b>i3</b>(1)`)
+	if err := g.write(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	pruned := newReferenceGraph(gv.stats)
+	if err := pruned.load(tmpdir, newTestI3View(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruned.write(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readReferences(referencesPath(tmpdir, "jessie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got[i3bar.ServingPath()]; ok {
+		t.Errorf("references of removed manpage %q not pruned: %+v", i3bar.ServingPath(), got)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected references after pruning: got %+v, want %+v", got, want)
+	}
+
+	var nilGraph *referenceGraph
+	if got := nilGraph.sources(i3); got != nil {
+		t.Errorf("sources on a nil graph: got %v, want nil", got)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"flag"
-	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
@@ -20,7 +19,6 @@ import (
 	"github.com/Debian/debiman/internal/commontmpl"
 	"github.com/Debian/debiman/internal/convert"
 	"github.com/Debian/debiman/internal/manpage"
-	"github.com/Debian/debiman/internal/sitemap"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
@@ -41,8 +39,32 @@ var (
 	baseURL = flag.String("base_url",
 		"https://manpages.debian.org",
 		"Base URL (without trailing slash) to the site. Used where absolute URLs are required, e.g. sitemaps.")
+
+	tocDepth = flag.Int("toc_depth",
+		1,
+		"Depth of the table of contents of manpages: 1 lists sections, 2 additionally lists subsections (.SS) in collapsible lists, which helps navigating long manpages such as bash(1)")
+
+	htmlFilters = flag.String("html_filters",
+		"",
+		"Comma-separated list of filters to apply to the HTML of every manpage, each of the form name[=argument]. Available filters: strip=<selector> removes elements (e.g. strip=table.foot), rewrite_links=<from>|<to> replaces link prefixes, anchor=<selector> adds anchor links to elements with an id (e.g. anchor=dt). Selectors are element, .class or element.class.")
+
+	highlightExamples = flag.Bool("highlight_examples",
+		false,
+		"Syntax-highlight shell and C code in the EXAMPLES section of manpages (server-side, no JavaScript required). Use -force_rerender to apply to already rendered manpages.")
+
+	noCompress = flag.Bool("no_compress",
+		false,
+		"Write rendered HTML as plain .html files instead of .html.gz files (for development: saves zcat’ing output files). Use a fresh -serving_dir, as .html.gz files from previous runs are neither updated nor removed.")
 )
 
+// htmlSuffix returns the file name suffix of rendered HTML files.
+func htmlSuffix() string {
+	if *noCompress {
+		return ".html"
+	}
+	return ".html.gz"
+}
+
 type breadcrumb struct {
 	Link string
 	Text string
@@ -99,18 +121,55 @@ const (
 	packageIndex
 )
 
+// optionalOutputsStale returns true if any of the optional outputs
+// (e.g. -json_sidecar, -render_text, -render_roff) of the rendered
+// manpage dest is missing or older than modTime.
+func optionalOutputsStale(dest string, modTime time.Time) bool {
+	return sidecarStale(dest, modTime) ||
+		textStale(dest, modTime) ||
+		pdfStale(dest, modTime) ||
+		roffStale(dest, modTime) ||
+		markdownStale(dest, modTime)
+}
+
+// renderedOutputSuffixes are the suffixes of gzip-compressed files
+// which debiman writes next to the manpages in a binary package
+// directory.
+var renderedOutputSuffixes = []string{
+	".html.gz",
+	".json.gz", // -json_sidecar
+	".txt.gz",  // -render_text
+	".roff.gz", // -render_roff
+	".md.gz",   // -render_markdown
+}
+
+// isRenderedOutput returns whether fn was written by debiman, as
+// opposed to a manpage extracted from a package.
+func isRenderedOutput(fn string) bool {
+	for _, suffix := range renderedOutputSuffixes {
+		if strings.HasSuffix(fn, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // walkManContents walks over all entries in dir and, depending on mode, does:
 // 1. send a renderJob for each regular file
 // 2. send a renderJob for each symlink
 // 3. renders a directory index
-func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir string, mode renderingMode, gv globalView, newestModTime time.Time) (time.Time, error) {
+//
+// The render jobs are added to jobs.
+func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir string, mode renderingMode, gv globalView, newestModTime time.Time, jobs *packageJobs) (time.Time, error) {
 	// the invariant is: each file ending in .gz must have a corresponding .html.gz file
 	// the .html.gz must have a modtime that is >= the modtime of the .gz file
+	// (with -no_compress, .html files take the place of .html.gz files)
 
-	var manpageByName map[string]*manpage.Meta
-	if mode == packageIndex {
-		manpageByName = make(map[string]*manpage.Meta)
-	}
+	// manpageNames holds only file names (not *manpage.Meta) to bound
+	// memory usage for packages with thousands of manpages.
+	var manpageNames []string
+
+	suiteStats := gv.stats.suite(filepath.Base(filepath.Dir(dir)))
 
 	files, err := os.Open(dir)
 	if err != nil {
@@ -146,20 +205,19 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 
 		for _, fn := range names {
 			if !strings.HasSuffix(fn, ".gz") ||
-				strings.HasSuffix(fn, ".html.gz") {
+				isRenderedOutput(fn) {
 				continue
 			}
 			full := filepath.Join(dir, fn)
 			if mode == packageIndex {
-				m, err := manpage.FromServingPath(*servingDir, full)
-				if err != nil {
+				if _, err := manpage.FromServingPath(*servingDir, full); err != nil {
 					// If we run into this case, our code cannot correctly
 					// interpret the result of ServingPath().
-					log.Printf("BUG: cannot parse manpage from serving path %q: %v", full, err)
+					errorf("BUG: cannot parse manpage from serving path %q: %v", full, err)
 					continue
 				}
 
-				manpageByName[fn] = m
+				manpageNames = append(manpageNames, fn)
 				continue
 			}
 
@@ -174,7 +232,7 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 			symlink := st.Mode()&os.ModeSymlink != 0
 
 			if !symlink {
-				atomic.AddUint64(&gv.stats.ManpageBytes, uint64(st.Size()))
+				atomic.AddUint64(&suiteStats.ManpageBytes, uint64(st.Size()))
 			}
 
 			if mode == regularFiles && symlink ||
@@ -182,17 +240,37 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 				continue
 			}
 
-			n := strings.TrimSuffix(fn, ".gz") + ".html.gz"
+			n := strings.TrimSuffix(fn, ".gz") + htmlSuffix()
 			htmlst, err := os.Stat(filepath.Join(dir, n))
 			if err == nil {
-				atomic.AddUint64(&gv.stats.HtmlBytes, uint64(htmlst.Size()))
+				atomic.AddUint64(&suiteStats.HtmlBytes, uint64(htmlst.Size()))
+			}
+			var siblingStale bool
+			for format, path := range siblingPaths(filepath.Join(dir, n)) {
+				sibst, err := os.Stat(path)
+				if err == nil {
+					atomic.AddUint64(format.bytes(suiteStats), uint64(sibst.Size()))
+				}
+				if err != nil || sibst.ModTime().Before(st.ModTime()) {
+					siblingStale = true
+				}
 			}
-			if err != nil || *forceRerender || htmlst.ModTime().Before(st.ModTime()) {
+			// -since deliberately violates the invariant for speed.
+			if !gv.since.IsZero() && st.ModTime().Before(gv.since) {
+				continue
+			}
+			if err != nil || *forceRerender || siblingStale || optionalOutputsStale(filepath.Join(dir, n), st.ModTime()) || htmlst.ModTime().Before(st.ModTime()) {
+				change := feedUnchanged
+				if err != nil {
+					change = feedNew
+				} else if htmlst.ModTime().Before(st.ModTime()) {
+					change = feedUpdated
+				}
 				m, err := manpage.FromServingPath(*servingDir, full)
 				if err != nil {
 					// If we run into this case, our code cannot correctly
 					// interpret the result of ServingPath().
-					log.Printf("BUG: cannot parse manpage from serving path %q: %v", full, err)
+					errorf("BUG: cannot parse manpage from serving path %q: %v", full, err)
 					continue
 				}
 
@@ -215,7 +293,7 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 					}
 
 					vfull := filepath.Join(*servingDir, v.RawPath())
-					vfn := filepath.Join(*servingDir, v.ServingPath()+".html.gz")
+					vfn := filepath.Join(*servingDir, v.ServingPath()+htmlSuffix())
 					vhtmlst, err := os.Stat(vfn)
 					if err == nil && vhtmlst.ModTime().After(gv.start) {
 						// The variant was already re-rendered with this globalView.
@@ -224,7 +302,7 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 
 					vst, err := os.Stat(vfull)
 					if err != nil {
-						log.Printf("WARNING: stat %q: %v", vfull, err)
+						warningf("stat %q: %v", vfull, err)
 						continue
 					}
 
@@ -233,8 +311,10 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 						vreuse = vfn
 					}
 
-					log.Printf("%s invalidated by %s", vfn, full)
+					debugf("%s invalidated by %s", vfn, full)
 
+					atomic.AddInt64(&progress.queueDepth, 1)
+					jobs.add()
 					select {
 					case renderChan <- renderJob{
 						dest:     vfn,
@@ -244,8 +324,14 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 						xref:     gv.xref,
 						modTime:  vst.ModTime(),
 						reuse:    vreuse,
+						lint:     gv.lint,
+						refs:     gv.refs,
+						whatis:   gv.whatis,
+						search:   gv.search,
+						pkg:      jobs,
 					}:
 					case <-ctx.Done():
+						atomic.AddInt64(&progress.queueDepth, -1)
 						break
 					}
 				}
@@ -255,10 +341,25 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 					link, err := os.Readlink(full)
 					if err == nil {
 						resolved := filepath.Join(dir, link)
-						reuse = strings.TrimSuffix(resolved, ".gz") + ".html.gz"
+						reuse = strings.TrimSuffix(resolved, ".gz") + htmlSuffix()
+					}
+				} else if target, err := soTarget(full); err != nil {
+					warningf("%v", err)
+				} else if target != "" {
+					if *soRedirects && strings.Split(target, "/")[1] != m.Package.Binarypkg {
+						// Redirect pages have no sibling or optional
+						// outputs, so they are checked on every run and
+						// only re-written if their target changed.
+						if err := writeSoRedirect(filepath.Join(dir, n), target); err != nil {
+							return newestModTime, err
+						}
+						continue
 					}
+					reuse = soReuse(target)
 				}
 
+				atomic.AddInt64(&progress.queueDepth, 1)
+				jobs.add()
 				select {
 				case renderChan <- renderJob{
 					dest:     filepath.Join(dir, n),
@@ -268,8 +369,15 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 					xref:     gv.xref,
 					modTime:  st.ModTime(),
 					reuse:    reuse,
+					lint:     gv.lint,
+					refs:     gv.refs,
+					whatis:   gv.whatis,
+					search:   gv.search,
+					change:   change,
+					pkg:      jobs,
 				}:
 				case <-ctx.Done():
+					atomic.AddInt64(&progress.queueDepth, -1)
 					break
 				}
 			}
@@ -280,20 +388,37 @@ func walkManContents(ctx context.Context, renderChan chan<- renderJob, dir strin
 		return newestModTime, nil
 	}
 
-	st, err := os.Stat(filepath.Join(dir, "index.html.gz"))
-	if !*forceRerender && err == nil && st.ModTime().After(newestModTime) {
+	indexPath := filepath.Join(dir, "index"+htmlSuffix())
+	st, err := os.Stat(indexPath)
+	if !*forceRerender && err == nil && st.ModTime().After(newestModTime) && !siblingsStale(indexPath, newestModTime) && !epubStale(dir, newestModTime) {
 		return newestModTime, nil
 	}
 
-	if len(manpageByName) == 0 {
-		log.Printf("WARNING: empty directory %q, not generating package index", dir)
+	if !gv.since.IsZero() && newestModTime.Before(gv.since) {
+		return newestModTime, nil
+	}
+
+	if len(manpageNames) == 0 {
+		if isTombstone(dir) {
+			return newestModTime, nil
+		}
+		if *stubEmptyIndexes {
+			warningf("empty directory %q, generating stub package index", dir)
+			suite := filepath.Base(filepath.Dir(dir))
+			return newestModTime, renderEmptyPkgindex(indexPath, suite, filepath.Base(dir))
+		}
+		warningf("empty directory %q, not generating package index", dir)
 		return newestModTime, nil
 	}
 
-	if err := renderPkgindex(filepath.Join(dir, "index.html.gz"), manpageByName); err != nil {
+	if err := renderPkgindex(indexPath, dir, manpageNames); err != nil {
 		return newestModTime, err
 	}
 
+	if *renderEPUB {
+		gv.epubs.add(dir, manpageNames, newestModTime)
+	}
+
 	return newestModTime, nil
 }
 
@@ -311,6 +436,7 @@ func walkContents(ctx context.Context, renderChan chan<- renderJob, whitelist ma
 		if !gv.suites[sfi.Name()] {
 			continue
 		}
+		progress.setSuite(sfi.Name())
 		bins, err := os.Open(filepath.Join(*servingDir, sfi.Name()))
 		if err != nil {
 			return err
@@ -349,25 +475,44 @@ func walkContents(ctx context.Context, renderChan chan<- renderJob, whitelist ma
 					// enough RAM to keep all dirents cached over the
 					// runtime of this code path.
 
+					rel := sfi.Name() + "/" + bfn
+					if newestModTime, ok := gv.journal.skip(rel); ok {
+						atomic.AddUint64(&progress.packagesProcessed, 1)
+						if !newestModTime.IsZero() {
+							sitemapEntriesMu.Lock()
+							defer sitemapEntriesMu.Unlock()
+							sitemapEntries[bfn] = newestModTime
+						}
+						return nil
+					}
+
 					var newestModTime time.Time
 					var err error
+					// The package is recorded in the journal once the
+					// walk and all render jobs sent by it are done.
+					jobs := newPackageJobs(func() {
+						gv.journal.record(rel, newestModTime)
+					})
 					// Render all regular files first
-					newestModTime, err = walkManContents(ctx, renderChan, dir, regularFiles, gv, newestModTime)
+					newestModTime, err = walkManContents(ctx, renderChan, dir, regularFiles, gv, newestModTime, jobs)
 					if err != nil {
 						return err
 					}
 
 					// then render all symlinks, re-using the rendered fragments
-					newestModTime, err = walkManContents(ctx, renderChan, dir, symlinks, gv, newestModTime)
+					newestModTime, err = walkManContents(ctx, renderChan, dir, symlinks, gv, newestModTime, jobs)
 					if err != nil {
 						return err
 					}
 
 					// and finally render the package index files which need to
 					// consider both regular files and symlinks.
-					if _, err := walkManContents(ctx, renderChan, dir, packageIndex, gv, newestModTime); err != nil {
+					if _, err := walkManContents(ctx, renderChan, dir, packageIndex, gv, newestModTime, jobs); err != nil {
 						return err
 					}
+					jobs.done()
+
+					atomic.AddUint64(&progress.packagesProcessed, 1)
 
 					if !newestModTime.IsZero() {
 						sitemapEntriesMu.Lock()
@@ -384,32 +529,59 @@ func walkContents(ctx context.Context, renderChan chan<- renderJob, whitelist ma
 		}
 		bins.Close()
 
-		sitemapPath := filepath.Join(*servingDir, sfi.Name(), "sitemap.xml.gz")
-		if err := writeAtomically(sitemapPath, true, func(w io.Writer) error {
-			return sitemap.WriteTo(w, *baseURL+"/"+sfi.Name(), sitemapEntries)
-		}); err != nil {
+		modTime, err := writeSuiteSitemap(sfi.Name(), sitemapEntries)
+		if err != nil {
 			return err
 		}
-		st, err := os.Stat(sitemapPath)
-		if err == nil {
-			sitemaps[sfi.Name()] = st.ModTime()
+		if !modTime.IsZero() {
+			sitemaps[sfi.Name()] = modTime
 		}
 	}
-	return writeAtomically(filepath.Join(*servingDir, "sitemapindex.xml.gz"), true, func(w io.Writer) error {
-		return sitemap.WriteIndexTo(w, *baseURL, sitemaps)
-	})
+	return writeSitemapIndexes(gv, sitemaps)
 }
 
 func renderAll(gv globalView) error {
+	// Conversions to plain text, PDF and Markdown count as timeouts,
+	// too (HTML conversions are counted below).
+	mandoc.Timeouts = &gv.stats.ConversionTimeouts
+
+	if gv.refs != nil {
+		if err := gv.refs.load(*servingDir, gv); err != nil {
+			return err
+		}
+	}
+
 	eg, ctx := errgroup.WithContext(context.Background())
 	renderChan := make(chan renderJob)
+
+	// PDFs are rendered by a separate, smaller set of workers, to which
+	// the HTML rendering workers pass on their jobs.
+	pdfEg, pdfCtx := errgroup.WithContext(ctx)
+	pdfChan := make(chan renderJob, *pdfConcurrency)
+	if *renderPDF {
+		for i := 0; i < *pdfConcurrency; i++ {
+			pdfEg.Go(func() error {
+				for r := range pdfChan {
+					if err := writePDF(r.src, r.dest); err != nil {
+						return err
+					}
+					r.pkg.done()
+				}
+				return nil
+			})
+		}
+	}
+
 	for i := 0; i < *renderConcurrency; i++ {
 		eg.Go(func() error {
-			converter, err := convert.NewProcess()
+			converter, err := convert.NewProcess(mandoc)
 			if err != nil {
 				return err
 			}
 			defer converter.Kill()
+			converter.HighlightExamples = *highlightExamples
+			converter.Timeout = *mandocTimeout
+			converter.Filters = filters
 
 			// NOTE(stapelberg): gzip’s decompression phase takes the same
 			// time, regardless of compression level. Hence, we invest the
@@ -420,16 +592,44 @@ func renderAll(gv globalView) error {
 			}
 
 			for r := range renderChan {
-				n, err := rendermanpage(gzipw, converter, r)
+				timeouts := converter.Timeouts()
+				n, change, err := rendermanpage(gzipw, converter, r)
 				if err != nil {
 					// rendermanpage writes an error page if rendering
 					// failed, any returned error is severe (e.g. file
 					// system full) and should lead to termination.
 					return err
 				}
+				if converter.Timeouts() > timeouts {
+					// rendermanpageprep already warned about the
+					// failed conversion.
+					atomic.AddUint64(&gv.stats.ConversionTimeouts, 1)
+				}
+
+				suiteStats := gv.stats.suite(r.meta.Package.Suite)
+				atomic.AddUint64(&suiteStats.HtmlBytes, n)
+				atomic.AddUint64(&suiteStats.ManpagesRendered, 1)
+				atomic.AddUint64(&progress.bytesWritten, n)
+				if gv.feeds != nil {
+					gv.feeds.record(r.meta, change)
+				}
+				for format, path := range siblingPaths(r.dest) {
+					if st, err := os.Stat(path); err == nil {
+						atomic.AddUint64(format.bytes(suiteStats), uint64(st.Size()))
+					}
+				}
+				atomic.AddInt64(&progress.queueDepth, -1)
 
-				atomic.AddUint64(&gv.stats.HtmlBytes, n)
-				atomic.AddUint64(&gv.stats.ManpagesRendered, 1)
+				if pdfStale(r.dest, r.modTime) {
+					// The PDF worker marks the job as done.
+					select {
+					case pdfChan <- r:
+					case <-pdfCtx.Done():
+						return pdfCtx.Err()
+					}
+				} else {
+					r.pkg.done()
+				}
 			}
 			return nil
 		})
@@ -438,50 +638,62 @@ func renderAll(gv globalView) error {
 	var whitelist map[string]bool
 	if *onlyRender != "" {
 		whitelist = make(map[string]bool)
-		log.Printf("Restricting rendering to the following binary packages:")
+		infof("Restricting rendering to the following binary packages:")
 		for _, e := range strings.Split(strings.TrimSpace(*onlyRender), ",") {
 			whitelist[e] = true
-			log.Printf("  %q", e)
+			infof("  %q", e)
 		}
-		log.Printf("(total: %d whitelist entries)", len(whitelist))
+		infof("(total: %d whitelist entries)", len(whitelist))
 	}
 
+	if *renderEPUB {
+		gv.epubs = &epubQueue{}
+	}
+
+	progress.setTotal(countPackageDirs(gv, whitelist))
 	if err := walkContents(ctx, renderChan, whitelist, gv); err != nil {
 		return err
 	}
 
 	close(renderChan)
-	if err := eg.Wait(); err != nil {
-		return err
+	err := eg.Wait()
+	close(pdfChan)
+	if pdfErr := pdfEg.Wait(); pdfErr != nil {
+		return pdfErr
 	}
-
-	suitedirs, err := ioutil.ReadDir(*servingDir)
 	if err != nil {
 		return err
 	}
-	for _, sfi := range suitedirs {
-		if !sfi.IsDir() {
-			continue
-		}
-		if !gv.suites[sfi.Name()] {
-			continue
-		}
-		bins, err := os.Open(filepath.Join(*servingDir, sfi.Name()))
-		if err != nil {
+	gv.stats.accumulate()
+
+	if *renderEPUB {
+		if err := gv.epubs.render(); err != nil {
 			return err
 		}
-		defer bins.Close()
+	}
 
-		names, err := bins.Readdirnames(-1)
-		if err != nil {
+	if err := renderAllContents(gv); err != nil {
+		return err
+	}
+
+	if err := renderDisambiguations(gv); err != nil {
+		return err
+	}
+
+	if len(diffSuites) > 0 {
+		if err := renderAllDiffs(gv); err != nil {
 			return err
 		}
+	}
 
-		if err := renderContents(filepath.Join(*servingDir, fmt.Sprintf("contents-%s.html.gz", sfi.Name())), sfi.Name(), names); err != nil {
+	if *renderInfo {
+		if err := renderInfoDocuments(gv.suites); err != nil {
 			return err
 		}
+	}
 
-		bins.Close()
+	if *redirectMap != "" {
+		return renderRedirects(*redirectMap)
 	}
 
 	return nil
@@ -20,3 +20,26 @@ func TestBreadcrumbsToJSON(t *testing.T) {
 		t.Fatalf("unexpected breadcrumbs JSON: got %q, want %q", got, want)
 	}
 }
+
+func TestIsRenderedOutput(t *testing.T) {
+	for _, fn := range []string{
+		"i3.1.en.html.gz",
+		"i3.1.en.json.gz",
+		"i3.1.en.txt.gz",
+		"i3.1.en.roff.gz",
+		"i3.1.en.md.gz",
+	} {
+		if !isRenderedOutput(fn) {
+			t.Errorf("isRenderedOutput(%q) = false, want true", fn)
+		}
+	}
+	for _, fn := range []string{
+		"i3.1.en.gz",
+		"git-log.1.en.gz",
+		"Net::HTTP.3pm.en.gz",
+	} {
+		if isRenderedOutput(fn) {
+			t.Errorf("isRenderedOutput(%q) = true, want false", fn)
+		}
+	}
+}
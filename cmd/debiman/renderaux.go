@@ -50,25 +50,31 @@ func renderAux(destDir string, gv globalView) error {
 	}
 	sort.Stable(bySuiteStr(suites))
 
-	if err := writeAtomically(filepath.Join(destDir, "index.html.gz"), true, func(w io.Writer) error {
+	recordArtifact(artifactIndex, filepath.Join(destDir, "index"+htmlSuffix()))
+	if err := writeAtomically(filepath.Join(destDir, "index"+htmlSuffix()), !*noCompress, func(w io.Writer) error {
 		return indexTmpl.Execute(w, struct {
 			Title          string
 			DebimanVersion string
 			Breadcrumbs    breadcrumbs
 			FooterExtra    string
 			Suites         []string
+			Feeds          bool
+			SearchIndex    bool
 			Meta           *manpage.Meta
 			HrefLangs      []*manpage.Meta
 		}{
 			Title:          "index",
 			Suites:         suites,
+			Feeds:          gv.feeds != nil,
+			SearchIndex:    *writeClientSearchIndex,
 			DebimanVersion: debimanVersion,
 		})
 	}); err != nil {
 		return err
 	}
 
-	if err := writeAtomically(filepath.Join(destDir, "faq.html.gz"), true, func(w io.Writer) error {
+	recordArtifact(artifactIndex, filepath.Join(destDir, "faq"+htmlSuffix()))
+	if err := writeAtomically(filepath.Join(destDir, "faq"+htmlSuffix()), !*noCompress, func(w io.Writer) error {
 		return faqTmpl.Execute(w, struct {
 			Title          string
 			DebimanVersion string
@@ -84,7 +90,8 @@ func renderAux(destDir string, gv globalView) error {
 		return err
 	}
 
-	if err := writeAtomically(filepath.Join(destDir, "about.html.gz"), true, func(w io.Writer) error {
+	recordArtifact(artifactIndex, filepath.Join(destDir, "about"+htmlSuffix()))
+	if err := writeAtomically(filepath.Join(destDir, "about"+htmlSuffix()), !*noCompress, func(w io.Writer) error {
 		return aboutTmpl.Execute(w, struct {
 			Title          string
 			DebimanVersion string
@@ -103,6 +110,9 @@ func renderAux(destDir string, gv globalView) error {
 	for name, content := range bundled.AssetsFiltered(func(fn string) bool {
 		return !strings.HasSuffix(fn, ".tmpl") && !strings.HasSuffix(fn, "style.css")
 	}) {
+		if strings.HasSuffix(name, ".woff") || strings.HasSuffix(name, ".woff2") {
+			recordArtifact(artifactStatic, filepath.Join(destDir, filepath.Base(name)))
+		}
 		if err := writeAtomically(filepath.Join(destDir, filepath.Base(name)+".gz"), true, func(w io.Writer) error {
 			_, err := io.WriteString(w, content)
 			return err
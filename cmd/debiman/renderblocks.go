@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var fallbackImageCommand = flag.String("fallback_image_command",
+	"",
+	"If non-empty, eqn(7) blocks (unless the installed mandoc renders them as MathML) and tbl(7) blocks which mandoc cannot express in HTML are rendered as images by running this shell command with the roff source of the block on stdin. The command must print a PNG image with a transparent background on stdout, which is cropped to its contents. Example: groff -e -t -Tps | gs -q -dSAFER -sDEVICE=pngalpha -r150 -o - -")
+
+// cropImage returns the smallest part of img (plus a small margin)
+// which contains all non-transparent pixels.
+func cropImage(img image.Image) (image.Image, error) {
+	const margin = 4
+	b := img.Bounds()
+	crop := image.Rectangle{Min: b.Max, Max: b.Min}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a == 0 {
+				continue
+			}
+			crop = crop.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	if crop.Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	crop = image.Rect(crop.Min.X-margin, crop.Min.Y-margin, crop.Max.X+margin, crop.Max.Y+margin).Intersect(b)
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return img, nil
+	}
+	return sub.SubImage(crop), nil
+}
+
+// renderBlockImage renders block into a PNG image at dest using
+// -fallback_image_command.
+func renderBlockImage(block convert.Block, dest string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", *fallbackImageCommand)
+	cmd.Stdin = strings.NewReader(block.Source)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v, stderr: %s", err, stderr.String())
+	}
+	img, err := png.Decode(&stdout)
+	if err != nil {
+		return err
+	}
+	cropped, err := cropImage(img)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return writeAtomically(dest, false, func(w io.Writer) error {
+		return png.Encode(w, cropped)
+	})
+}
+
+// renderBlockImages renders the eqn(7) and tbl(7) blocks which were
+// replaced by convert.ReplaceBlocks into the assets directory of m and
+// returns their URLs. Blocks which cannot be rendered result in an
+// empty URL.
+func renderBlockImages(m *manpage.Meta, blocks []convert.Block) []string {
+	urls := make([]string, len(blocks))
+	for idx, block := range blocks {
+		rel := filepath.Join(pageAssetsDir(m), fmt.Sprintf("%s-%d.png", block.Preprocessor, idx))
+		if err := renderBlockImage(block, filepath.Join(*servingDir, rel)); err != nil {
+			warningf("%s: rendering %s block %d as image failed: %v", m.ServingPath(), block.Preprocessor, idx, err)
+			continue
+		}
+		urls[idx] = "/" + rel
+	}
+	return urls
+}
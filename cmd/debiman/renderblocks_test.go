@@ -0,0 +1,24 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCropImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	img.Set(10, 20, color.Black)
+	img.Set(50, 60, color.Black)
+	cropped, err := cropImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cropped.Bounds(), image.Rect(6, 16, 55, 65); got != want {
+		t.Fatalf("unexpected bounds: got %v, want %v", got, want)
+	}
+
+	if _, err := cropImage(image.NewNRGBA(image.Rect(0, 0, 10, 10))); err == nil {
+		t.Fatalf("cropImage(empty image) unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/manpage"
+	"golang.org/x/text/language/display"
+)
+
+var browseTmpl = mustParseBrowseTmpl()
+
+func mustParseBrowseTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("browse").Parse(bundled.Asset("browse.tmpl")))
+}
+
+// browseLink is an entry of the navigation of a browse page, e.g. a
+// letter of the A–Z index.
+type browseLink struct {
+	Href    string
+	Text    string
+	Current bool
+}
+
+type browseData struct {
+	Title          string
+	DebimanVersion string
+	Breadcrumbs    breadcrumbs
+	FooterExtra    string
+	Meta           *manpage.Meta
+	HrefLangs      []*manpage.Meta
+	Heading        string
+	Description    string
+	Nav            []browseLink
+	Manpages       []*manpage.Meta
+}
+
+type byNameSection []*manpage.Meta
+
+func (p byNameSection) Len() int      { return len(p) }
+func (p byNameSection) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byNameSection) Less(i, j int) bool {
+	if p[i].Name != p[j].Name {
+		return p[i].Name < p[j].Name
+	}
+	if p[i].Section != p[j].Section {
+		return p[i].Section < p[j].Section
+	}
+	if p[i].Package.Binarypkg != p[j].Package.Binarypkg {
+		return p[i].Package.Binarypkg < p[j].Package.Binarypkg
+	}
+	return p[i].Language < p[j].Language
+}
+
+// suiteManpages returns the manpages of xref by suite, with one
+// manpage (in English, if available) per binary package, name and
+// section.
+func suiteManpages(xref map[string][]*manpage.Meta) map[string][]*manpage.Meta {
+	bySuite := make(map[string][]*manpage.Meta)
+	for _, x := range xref {
+		best := make(map[string]*manpage.Meta, len(x))
+		var order []string
+		for _, m := range x {
+			key := m.PermaLink()
+			if b, ok := best[key]; !ok {
+				order = append(order, key)
+			} else if b.Language == "en" || m.Language != "en" {
+				continue
+			}
+			best[key] = m
+		}
+		for _, key := range order {
+			m := best[key]
+			bySuite[m.Package.Suite] = append(bySuite[m.Package.Suite], m)
+		}
+	}
+	return bySuite
+}
+
+// azLetters are the pages of the A–Z index. Manpages whose name does
+// not start with a letter are listed under “other”.
+var azLetters = func() []string {
+	letters := make([]string, 0, 27)
+	for c := 'a'; c <= 'z'; c++ {
+		letters = append(letters, string(c))
+	}
+	return append(letters, "other")
+}()
+
+// azLetter returns the page of the A–Z index listing the manpage name.
+func azLetter(name string) string {
+	if name == "" {
+		return "other"
+	}
+	c := name[0]
+	if c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	if c >= 'a' && c <= 'z' {
+		return string(c)
+	}
+	return "other"
+}
+
+// azIndexPath returns the serving path of the letter page of the A–Z
+// index of suite.
+func azIndexPath(suite, letter string) string {
+	return fmt.Sprintf("/az-%s-%s.html", suite, letter)
+}
+
+// renderBrowse renders a browse page to dest.
+func renderBrowse(dest string, data browseData) error {
+	sort.Sort(byNameSection(data.Manpages))
+	data.DebimanVersion = debimanVersion
+	recordArtifact(artifactIndex, dest)
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		return browseTmpl.Execute(w, data)
+	})
+}
+
+// renderAZIndex renders the A–Z index of each suite, i.e. one page per
+// letter listing all manpages whose name starts with the letter.
+func renderAZIndex(gv globalView) error {
+	bySuite := suiteManpages(gv.xref)
+	for suite := range gv.suites {
+		byLetter := make(map[string][]*manpage.Meta, len(azLetters))
+		for _, m := range bySuite[suite] {
+			letter := azLetter(m.Name)
+			byLetter[letter] = append(byLetter[letter], m)
+		}
+		for _, letter := range azLetters {
+			nav := make([]browseLink, len(azLetters))
+			for idx, l := range azLetters {
+				nav[idx] = browseLink{
+					Href:    azIndexPath(suite, l),
+					Text:    l,
+					Current: l == letter,
+				}
+			}
+			dest := filepath.Join(*servingDir, fmt.Sprintf("az-%s-%s%s", suite, letter, htmlSuffix()))
+			if err := renderBrowse(dest, browseData{
+				Title: fmt.Sprintf("A–Z index of %s: %s", gv.suiteTitle(suite), letter),
+				Breadcrumbs: breadcrumbs{
+					{fmt.Sprintf("/contents-%s.html", suite), suite},
+					{"", "A–Z"},
+				},
+				Heading:  fmt.Sprintf("A–Z index of %s: %s", gv.suiteTitle(suite), letter),
+				Nav:      nav,
+				Manpages: byLetter[letter],
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sectionIndexPath returns the serving path of the index of the
+// manpages in (main) section of suite.
+func sectionIndexPath(suite, section string) string {
+	return fmt.Sprintf("/section-%s-%s.html", suite, section)
+}
+
+// indexSections returns the sections for which an index is rendered:
+// the standard sections (see longSections) followed by the
+// non-standard sections of manpages (e.g. “n” for Tcl).
+func indexSections(manpages []*manpage.Meta) []string {
+	sections := make([]string, 0, len(longSections))
+	for section := range longSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	var extra []string
+	seen := make(map[string]bool)
+	for _, m := range manpages {
+		section := m.MainSection()
+		if _, ok := longSections[section]; ok || seen[section] {
+			continue
+		}
+		seen[section] = true
+		extra = append(extra, section)
+	}
+	sort.Strings(extra)
+	return append(sections, extra...)
+}
+
+// renderSectionIndex renders an index per main section (e.g. 1 for
+// manpages in sections 1 and 1ssl) of each suite.
+func renderSectionIndex(gv globalView) error {
+	bySuite := suiteManpages(gv.xref)
+	for suite := range gv.suites {
+		bySection := make(map[string][]*manpage.Meta)
+		for _, m := range bySuite[suite] {
+			section := m.MainSection()
+			bySection[section] = append(bySection[section], m)
+		}
+		sections := indexSections(bySuite[suite])
+		for _, section := range sections {
+			nav := make([]browseLink, len(sections))
+			for idx, s := range sections {
+				nav[idx] = browseLink{
+					Href:    sectionIndexPath(suite, s),
+					Text:    s,
+					Current: s == section,
+				}
+			}
+			heading := fmt.Sprintf("Section %s of %s", section, gv.suiteTitle(suite))
+			if long, ok := longSections[section]; ok {
+				heading += ": " + long
+			}
+			dest := filepath.Join(*servingDir, fmt.Sprintf("section-%s-%s%s", suite, section, htmlSuffix()))
+			if err := renderBrowse(dest, browseData{
+				Title: fmt.Sprintf("Section %s of %s", section, gv.suiteTitle(suite)),
+				Breadcrumbs: breadcrumbs{
+					{fmt.Sprintf("/contents-%s.html", suite), suite},
+					{"", "Section " + section},
+				},
+				Heading:  heading,
+				Nav:      nav,
+				Manpages: bySection[section],
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// languageIndexPath returns the serving path of the index of the
+// manpages in language (e.g. “de” or “pt_BR”) of suite.
+func languageIndexPath(suite, language string) string {
+	return fmt.Sprintf("/lang-%s-%s.html", suite, language)
+}
+
+// renderLanguageIndex renders an index per language of each suite,
+// listing all manpages which are available in the language.
+func renderLanguageIndex(gv globalView) error {
+	byLanguage := make(map[string]map[string][]*manpage.Meta)
+	total := make(map[string]int)
+	for suite, manpages := range suiteManpages(gv.xref) {
+		total[suite] = len(manpages)
+	}
+	for _, x := range gv.xref {
+		for _, m := range x {
+			suite := m.Package.Suite
+			if byLanguage[suite] == nil {
+				byLanguage[suite] = make(map[string][]*manpage.Meta)
+			}
+			byLanguage[suite][m.Language] = append(byLanguage[suite][m.Language], m)
+		}
+	}
+	for suite := range gv.suites {
+		// English is always rendered, as the front page links to it.
+		languages := []string{"en"}
+		for language := range byLanguage[suite] {
+			if language != "en" {
+				languages = append(languages, language)
+			}
+		}
+		sort.Strings(languages[1:])
+		for _, language := range languages {
+			nav := make([]browseLink, len(languages))
+			for idx, l := range languages {
+				nav[idx] = browseLink{
+					Href:    languageIndexPath(suite, l),
+					Text:    l,
+					Current: l == language,
+				}
+			}
+			manpages := byLanguage[suite][language]
+			name := language
+			if len(manpages) > 0 {
+				name = fmt.Sprintf("%s (%s)", display.English.Languages().Name(manpages[0].LanguageTag), language)
+			}
+			dest := filepath.Join(*servingDir, fmt.Sprintf("lang-%s-%s%s", suite, language, htmlSuffix()))
+			if err := renderBrowse(dest, browseData{
+				Title: fmt.Sprintf("Manpages in %s in %s", name, gv.suiteTitle(suite)),
+				Breadcrumbs: breadcrumbs{
+					{fmt.Sprintf("/contents-%s.html", suite), suite},
+					{"", language},
+				},
+				Heading:     fmt.Sprintf("Manpages in %s in %s", name, gv.suiteTitle(suite)),
+				Description: fmt.Sprintf("%d of the %d manpages in %s are available in %s.", len(manpages), total[suite], gv.suiteTitle(suite), name),
+				Nav:         nav,
+				Manpages:    manpages,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+// newTestGlobalView returns a globalView of suite containing the
+// manpages at the specified paths (relative to /usr/share/man) of the
+// binary package pkg.
+func newTestGlobalView(t *testing.T, suite, pkg string, paths ...string) globalView {
+	gv := newGlobalView(1, time.Now())
+	gv.suites[suite] = true
+	p := &manpage.PkgMeta{
+		Binarypkg: pkg,
+		Suite:     suite,
+	}
+	for _, path := range paths {
+		m, err := manpage.FromManPath(path, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gv.xref[m.Name] = append(gv.xref[m.Name], m)
+	}
+	return gv
+}
+
+func TestAZLetter(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"i3", "i"},
+		{"X", "x"},
+		{"2to3", "other"},
+		{"_exit", "other"},
+		{"", "other"},
+	} {
+		if got := azLetter(tt.name); got != tt.want {
+			t.Errorf("azLetter(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSuiteManpages(t *testing.T) {
+	gv := newTestGlobalView(t, "testing", "i3-wm",
+		"de/man1/i3.1.gz",
+		"man1/i3.1.gz",
+		"fr/man1/i3.1.gz",
+		"de/man1/i3-msg.1.gz")
+	got := suiteManpages(gv.xref)["testing"]
+	var paths []string
+	for _, m := range got {
+		paths = append(paths, m.ServingPath())
+	}
+	want := map[string]bool{
+		"testing/i3-wm/i3.1.en":     true,
+		"testing/i3-wm/i3-msg.1.de": true,
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("suiteManpages: got %q, want %d manpages", paths, len(want))
+	}
+	for _, path := range paths {
+		if !want[path] {
+			t.Fatalf("suiteManpages: unexpected manpage %q (got %q)", path, paths)
+		}
+	}
+}
+
+func TestRenderAZIndex(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	parseTemplates()
+	gv := newTestGlobalView(t, "testing", "i3-wm", "man1/i3.1.gz", "man1/I3-dump-log.1.gz")
+	if err := renderAZIndex(gv); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, "az-testing-i.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`href="/testing/i3-wm/i3.1.en.html"`,
+		`href="/testing/i3-wm/I3-dump-log.1.en.html"`,
+		`href="/az-testing-other.html"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("az-testing-i.html does not contain %q", want)
+		}
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(tmpdir, "az-testing-other.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "There are no manpages in this index.") {
+		t.Errorf("az-testing-other.html unexpectedly lists manpages")
+	}
+}
+
+func TestRenderSectionIndex(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	parseTemplates()
+	gv := newTestGlobalView(t, "testing", "perl", "man1/perl.1.gz", "man3/Carp.3perl.gz", "mann/after.n.gz")
+	if err := renderSectionIndex(gv); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"section-testing-1.html", `href="/testing/perl/perl.1.en.html"`},
+		{"section-testing-3.html", `href="/testing/perl/Carp.3perl.en.html"`},
+		{"section-testing-3.html", "Library calls"},
+		{"section-testing-n.html", `href="/testing/perl/after.n.en.html"`},
+		{"section-testing-8.html", `href="/section-testing-n.html"`},
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(tmpdir, tt.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), tt.want) {
+			t.Errorf("%s does not contain %q", tt.path, tt.want)
+		}
+	}
+}
+
+func TestRenderLanguageIndex(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	parseTemplates()
+	gv := newTestGlobalView(t, "testing", "i3-wm", "man1/i3.1.gz", "man1/i3-msg.1.gz", "de/man1/i3.1.gz")
+	if err := renderLanguageIndex(gv); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, "lang-testing-de.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`href="/testing/i3-wm/i3.1.de.html"`,
+		`href="/lang-testing-en.html"`,
+		"1 of the 2 manpages in Debian testing are available in German (de).",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("lang-testing-de.html does not contain %q", want)
+		}
+	}
+	if strings.Contains(string(b), "i3-msg") {
+		t.Errorf("lang-testing-de.html unexpectedly lists i3-msg(1)")
+	}
+}
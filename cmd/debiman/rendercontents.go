@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -21,7 +22,8 @@ func mustParseContentsTmpl() *template.Template {
 func renderContents(dest, suite string, bins []string) error {
 	sort.Strings(bins)
 
-	if err := writeAtomically(dest, true, func(w io.Writer) error {
+	recordArtifact(artifactIndex, dest)
+	if err := writeAtomicallyLarge(dest, func(w io.Writer) error {
 		return contentsTmpl.Execute(w, struct {
 			Title          string
 			DebimanVersion string
@@ -41,14 +43,54 @@ func renderContents(dest, suite string, bins []string) error {
 			Bins:  bins,
 			Suite: suite,
 		})
-	}); err != nil {
+	}, nil); err != nil {
 		return err
 	}
 
-	destPath := filepath.Join(*servingDir, suite, "index.html.gz")
-	link := fmt.Sprintf("../contents-%s.html.gz", suite)
+	destPath := filepath.Join(*servingDir, suite, "index"+htmlSuffix())
+	link := fmt.Sprintf("../contents-%s%s", suite, htmlSuffix())
 	if err := os.Symlink(link, destPath); err != nil && !os.IsExist(err) {
 		return err
 	}
 	return nil
 }
+
+// renderAllContents renders the contents page of each suite, listing
+// its binary packages (except for -gc_tombstones).
+func renderAllContents(gv globalView) error {
+	suitedirs, err := ioutil.ReadDir(*servingDir)
+	if err != nil {
+		return err
+	}
+	for _, sfi := range suitedirs {
+		if !sfi.IsDir() {
+			continue
+		}
+		if !gv.suites[sfi.Name()] {
+			continue
+		}
+		bins, err := os.Open(filepath.Join(*servingDir, sfi.Name()))
+		if err != nil {
+			return err
+		}
+		defer bins.Close()
+
+		names, err := bins.Readdirnames(-1)
+		if err != nil {
+			return err
+		}
+		listed := names[:0]
+		for _, name := range names {
+			if !isTombstone(filepath.Join(*servingDir, sfi.Name(), name)) {
+				listed = append(listed, name)
+			}
+		}
+
+		if err := renderContents(filepath.Join(*servingDir, fmt.Sprintf("contents-%s%s", sfi.Name(), htmlSuffix())), sfi.Name(), listed); err != nil {
+			return err
+		}
+
+		bins.Close()
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/diff"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var renderDiffs = flag.String("render_diffs",
+	"",
+	"Comma-separated list of suite pairs (e.g. stable:testing) for which to render the differences between the plain text versions of each manpage present in both suites, as <name>.<section>.<language>.diff-<suite>.html.gz next to the manpage of the second suite, linked from its “other versions” panel. Requires -render_text. Manpages which were rendered before enabling -render_diffs only link to their difference pages once re-rendered, e.g. using -force_rerender.")
+
+// diffContext is the number of unchanged lines around each change.
+const diffContext = 3
+
+var diffTmpl = mustParseDiffTmpl()
+
+func mustParseDiffTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("diff").Parse(bundled.Asset("diff.tmpl")))
+}
+
+// diffPair is a pair of suites of -render_diffs.
+type diffPair struct {
+	from, to string
+}
+
+// parseDiffPairs parses the -render_diffs flag.
+func parseDiffPairs(value string) ([]diffPair, error) {
+	var pairs []diffPair
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.Split(spec, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || parts[0] == parts[1] {
+			return nil, fmt.Errorf("-render_diffs: invalid suite pair %q, expected e.g. stable:testing", spec)
+		}
+		pairs = append(pairs, diffPair{from: parts[0], to: parts[1]})
+	}
+	return pairs, nil
+}
+
+// diffPairs are the suite pairs of -render_diffs, as parsed on
+// startup.
+var diffPairs []diffPair
+
+// diffSuites maps each suite to the suites from which differences are
+// rendered (see -render_diffs), by their names in the serving
+// directory. Populated once the global view is built.
+var diffSuites map[string][]string
+
+// resolveDiffPairs sets diffSuites, resolving suite names such as
+// “stable” using idxSuites.
+func resolveDiffPairs(pairs []diffPair, idxSuites map[string]string) {
+	diffSuites = make(map[string][]string)
+	for _, p := range pairs {
+		from, ok := idxSuites[p.from]
+		if !ok {
+			warningf("-render_diffs: unknown suite %q, skipping", p.from)
+			continue
+		}
+		to, ok := idxSuites[p.to]
+		if !ok {
+			warningf("-render_diffs: unknown suite %q, skipping", p.to)
+			continue
+		}
+		diffSuites[to] = append(diffSuites[to], from)
+	}
+}
+
+// diffServingPath returns the serving path of the differences of m to
+// the manpage in suite from.
+func diffServingPath(m *manpage.Meta, from string) string {
+	return m.ServingPath() + ".diff-" + from
+}
+
+// diffLinks returns the links to the difference pages of meta, keyed by
+// the suite of the other version, for the manpage template. As
+// renderAllDiffs runs after all manpages (and their plain text
+// versions) were rendered, the difference pages do not exist yet when
+// meta is rendered for the first time, so all versions which will have
+// one are linked.
+func diffLinks(meta *manpage.Meta, versions []*manpage.Meta) map[string]string {
+	links := make(map[string]string)
+	for _, from := range diffSuites[meta.Package.Suite] {
+		for _, v := range versions {
+			if v.Package.Suite == from &&
+				v.Package.Binarypkg == meta.Package.Binarypkg &&
+				v.Section == meta.Section &&
+				v.Language == meta.Language {
+				links[from] = "/" + diffServingPath(meta, from) + ".html"
+				break
+			}
+		}
+	}
+	return links
+}
+
+type diffLineData struct {
+	Class  string
+	Prefix string
+	Text   string
+}
+
+type diffHunkData struct {
+	Header string
+	Lines  []diffLineData
+}
+
+// diffHunks returns the hunks of the differences between the texts a
+// and b for the diff template.
+func diffHunks(a, b string) []diffHunkData {
+	lines := diff.Lines(
+		strings.Split(strings.TrimSuffix(a, "\n"), "\n"),
+		strings.Split(strings.TrimSuffix(b, "\n"), "\n"))
+	hunks := diff.Hunks(lines, diffContext)
+	result := make([]diffHunkData, len(hunks))
+	for idx, h := range hunks {
+		lines := make([]diffLineData, len(h.Lines))
+		for lidx, l := range h.Lines {
+			switch l.Op {
+			case diff.Equal:
+				lines[lidx] = diffLineData{"diffequal", " ", l.Text}
+			case diff.Delete:
+				lines[lidx] = diffLineData{"diffdelete", "-", l.Text}
+			case diff.Insert:
+				lines[lidx] = diffLineData{"diffinsert", "+", l.Text}
+			}
+		}
+		result[idx] = diffHunkData{
+			Header: fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.AStart, h.ALines, h.BStart, h.BLines),
+			Lines:  lines,
+		}
+	}
+	return result
+}
+
+// readText returns the plain text version of the rendered manpage dest.
+func readText(dest string) (string, error) {
+	path := textPath(dest)
+	if *noCompress {
+		b, err := ioutil.ReadFile(path)
+		return string(b), err
+	}
+	var text string
+	err := readSource(path, func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		text = string(b)
+		return err
+	})
+	return text, err
+}
+
+// renderDiff renders the differences between the manpages from and to
+// to dest, unless dest is newer than both of their plain text versions.
+func renderDiff(dest string, from, to *manpage.Meta) error {
+	fromDest := filepath.Join(*servingDir, from.ServingPath()+htmlSuffix())
+	toDest := filepath.Join(*servingDir, to.ServingPath()+htmlSuffix())
+	// Plain text versions are written for every rendered manpage, so
+	// they are only missing if a manpage was not rendered (e.g. due to
+	// -only_render_pkgs), in which case the link of diffLinks is dead.
+	fromSt, err := os.Stat(textPath(fromDest))
+	if err != nil {
+		warningf("not rendering %q: %v", dest, err)
+		return nil
+	}
+	toSt, err := os.Stat(textPath(toDest))
+	if err != nil {
+		warningf("not rendering %q: %v", dest, err)
+		return nil
+	}
+	if st, err := os.Stat(dest); err == nil && !*forceRerender &&
+		st.ModTime().After(fromSt.ModTime()) && st.ModTime().After(toSt.ModTime()) {
+		return nil
+	}
+
+	a, err := readText(fromDest)
+	if err != nil {
+		return err
+	}
+	b, err := readText(toDest)
+	if err != nil {
+		return err
+	}
+	recordArtifact(artifactDiff, dest)
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		return diffTmpl.Execute(w, struct {
+			Title          string
+			DebimanVersion string
+			Breadcrumbs    breadcrumbs
+			FooterExtra    string
+			Meta           *manpage.Meta
+			HrefLangs      []*manpage.Meta
+			From           *manpage.Meta
+			Hunks          []diffHunkData
+		}{
+			Title:          fmt.Sprintf("%s(%s): Debian %s → %s", to.Name, to.Section, from.Package.Suite, to.Package.Suite),
+			DebimanVersion: debimanVersion,
+			Breadcrumbs: breadcrumbs{
+				{fmt.Sprintf("/contents-%s.html", to.Package.Suite), to.Package.Suite},
+				{fmt.Sprintf("/%s/%s/index.html", to.Package.Suite, to.Package.Binarypkg), to.Package.Binarypkg},
+				{"/" + to.ServingPath() + ".html", fmt.Sprintf("%s(%s)", to.Name, to.Section)},
+				{"", "Differences to " + from.Package.Suite},
+			},
+			Meta:  to,
+			From:  from,
+			Hunks: diffHunks(a, b),
+		})
+	})
+}
+
+// renderAllDiffs renders the differences between the manpages of the
+// suite pairs of -render_diffs.
+func renderAllDiffs(gv globalView) error {
+	for to, froms := range diffSuites {
+		for _, from := range froms {
+			infof("Rendering differences between %q and %q", from, to)
+			for _, versions := range gv.xref {
+				byKey := make(map[string]*manpage.Meta)
+				for _, v := range versions {
+					if v.Package.Suite == from {
+						byKey[v.Package.Binarypkg+"/"+v.Name+"."+v.Section+"."+v.Language] = v
+					}
+				}
+				for _, v := range versions {
+					if v.Package.Suite != to {
+						continue
+					}
+					f, ok := byKey[v.Package.Binarypkg+"/"+v.Name+"."+v.Section+"."+v.Language]
+					if !ok {
+						continue
+					}
+					dest := filepath.Join(*servingDir, diffServingPath(v, from)+htmlSuffix())
+					if err := renderDiff(dest, f, v); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+func TestParseDiffPairs(t *testing.T) {
+	got, err := parseDiffPairs("stable:testing, testing:unstable,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []diffPair{{"stable", "testing"}, {"testing", "unstable"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseDiffPairs: got %v, want %v", got, want)
+	}
+	for _, value := range []string{"stable", "stable:", "stable:testing:unstable", "testing:testing"} {
+		if _, err := parseDiffPairs(value); err == nil {
+			t.Errorf("parseDiffPairs(%q) unexpectedly succeeded", value)
+		}
+	}
+}
+
+func TestRenderAllDiffs(t *testing.T) {
+	tmpdir, cleanup := newTestServingDir(t)
+	defer cleanup()
+	defer func() { diffSuites = nil }()
+
+	parseTemplates()
+	gv := newTestGlobalView(t, "stretch", "i3-wm", "man1/i3.1.gz", "man1/i3-msg.1.gz")
+	for _, m := range newTestGlobalView(t, "buster", "i3-wm", "man1/i3.1.gz", "man1/i3-msg.1.gz").xref {
+		gv.xref[m[0].Name] = append(gv.xref[m[0].Name], m...)
+	}
+	resolveDiffPairs([]diffPair{{"stable", "testing"}, {"oldstable", "testing"}}, map[string]string{
+		"stable":  "stretch",
+		"testing": "buster",
+	})
+
+	for path, text := range map[string]string{
+		"stretch/i3-wm/i3.1.en.txt":     "I3(1)\nNAME\n  i3 - improved dynamic tiling window manager\n",
+		"buster/i3-wm/i3.1.en.txt":      "I3(1)\nNAME\n  i3 - improved tiling window manager\n",
+		"stretch/i3-wm/i3-msg.1.en.txt": "I3-MSG(1)\n",
+		"buster/i3-wm/i3-msg.1.en.txt":  "I3-MSG(1)\n",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := renderAllDiffs(gv); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, "buster/i3-wm/i3.1.en.diff-stretch.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"@@ -1,3 &#43;1,3 @@",
+		`<tr class="diffdelete"><td class="diffop">-</td><td><pre>  i3 - improved dynamic tiling window manager</pre></td></tr>`,
+		`<tr class="diffinsert"><td class="diffop">&#43;</td><td><pre>  i3 - improved tiling window manager</pre></td></tr>`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("diff page does not contain %q", want)
+		}
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(tmpdir, "buster/i3-wm/i3-msg.1.en.diff-stretch.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "The plain text versions do not differ.") {
+		t.Errorf("diff page of identical versions does not state that they do not differ")
+	}
+
+	i3 := gv.xref["i3"]
+	for _, m := range i3 {
+		want := map[string]string{}
+		if m.Package.Suite == "buster" {
+			want["stretch"] = "/buster/i3-wm/i3.1.en.diff-stretch.html"
+		}
+		if got := diffLinks(m, i3); !reflect.DeepEqual(got, want) {
+			t.Errorf("diffLinks(%v): got %v, want %v", m, got, want)
+		}
+	}
+
+	// The difference pages are linked before they are rendered, as
+	// renderAllDiffs runs after the manpages were rendered.
+	if err := os.Remove(filepath.Join(tmpdir, "buster/i3-wm/i3.1.en.diff-stretch.html")); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range gv.xref["i3"] {
+		if got := diffLinks(m, gv.xref["i3"]); m.Package.Suite == "buster" && len(got) != 1 {
+			t.Errorf("diffLinks(%v) without difference page: got %v, want a link", m, got)
+		}
+	}
+
+	// A version in a different binary package is not linked.
+	other := *gv.xref["i3"][0]
+	otherPkg := *other.Package
+	otherPkg.Binarypkg = "i3"
+	other.Package = &otherPkg
+	for _, m := range gv.xref["i3"] {
+		if got := diffLinks(m, []*manpage.Meta{m, &other}); len(got) != 0 {
+			t.Errorf("diffLinks(%v) with a version of a different package: got %v, want none", m, got)
+		}
+	}
+}
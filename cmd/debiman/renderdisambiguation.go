@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var disambiguationTmpl = mustParseDisambiguationTmpl()
+
+func mustParseDisambiguationTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("disambiguation").Parse(bundled.Asset("disambiguation.tmpl")))
+}
+
+type bySectionPkgAndLanguage []*manpage.Meta
+
+func (p bySectionPkgAndLanguage) Len() int      { return len(p) }
+func (p bySectionPkgAndLanguage) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p bySectionPkgAndLanguage) Less(i, j int) bool {
+	if p[i].Section != p[j].Section {
+		return p[i].Section < p[j].Section
+	}
+	if p[i].Package.Binarypkg != p[j].Package.Binarypkg {
+		return popconLess(p[i].Package.Binarypkg, p[j].Package.Binarypkg)
+	}
+	return p[i].Language < p[j].Language
+}
+
+// ambiguousIn returns all manpages in versions which belong to suite,
+// provided they span more than one section or binary package. Multiple
+// languages of the same manpage are not considered ambiguous.
+func ambiguousIn(suite string, versions []*manpage.Meta) []*manpage.Meta {
+	var mans []*manpage.Meta
+	distinct := make(map[string]bool)
+	for _, v := range versions {
+		if v.Package.Suite != suite {
+			continue
+		}
+		mans = append(mans, v)
+		distinct[v.Package.Binarypkg+"/"+v.Section] = true
+	}
+	if len(distinct) < 2 {
+		return nil
+	}
+	sort.Sort(bySectionPkgAndLanguage(mans))
+	return mans
+}
+
+// disambiguationPath returns the path (relative to -serving_dir) of
+// the disambiguation page for name in suite.
+func disambiguationPath(suite, name string) string {
+	return filepath.Join("disambiguation", suite, name+htmlSuffix())
+}
+
+// disambiguationURL returns the URL of the disambiguation page for
+// name in suite.
+func disambiguationURL(suite, name string) string {
+	return "/disambiguation/" + suite + "/" + name + ".html"
+}
+
+// needsDisambiguationRender returns true if any of mans was modified
+// after dest was last rendered.
+func needsDisambiguationRender(dest string, mans []*manpage.Meta) bool {
+	if *forceRerender {
+		return true
+	}
+	st, err := os.Stat(dest)
+	if err != nil {
+		return true
+	}
+	for _, m := range mans {
+		mst, err := os.Lstat(filepath.Join(*servingDir, m.RawPath()))
+		if err != nil || mst.ModTime().After(st.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+func renderDisambiguation(dest, suite, name string, mans []*manpage.Meta) error {
+	recordArtifact(artifactIndex, dest)
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		return disambiguationTmpl.Execute(w, struct {
+			Title          string
+			DebimanVersion string
+			Breadcrumbs    breadcrumbs
+			FooterExtra    string
+			Name           string
+			Suite          string
+			Mans           []*manpage.Meta
+			Meta           *manpage.Meta
+			HrefLangs      []*manpage.Meta
+		}{
+			Title:          fmt.Sprintf("%s in Debian %s", name, suite),
+			DebimanVersion: debimanVersion,
+			Breadcrumbs: breadcrumbs{
+				{fmt.Sprintf("/contents-%s.html", suite), suite},
+				{"", name},
+			},
+			Name:  name,
+			Suite: suite,
+			Mans:  mans,
+		})
+	})
+}
+
+// renderDisambiguations renders a disambiguation page for every
+// manpage name which is present in more than one section or binary
+// package within a suite.
+func renderDisambiguations(gv globalView) error {
+	type disambiguationJob struct {
+		suite string
+		name  string
+		mans  []*manpage.Meta
+	}
+	eg, ctx := errgroup.WithContext(context.Background())
+	jobs := make(chan disambiguationJob)
+	for i := 0; i < *renderConcurrency; i++ {
+		eg.Go(func() error {
+			for j := range jobs {
+				dest := filepath.Join(*servingDir, disambiguationPath(j.suite, j.name))
+				if !needsDisambiguationRender(dest, j.mans) {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+					return err
+				}
+				if err := renderDisambiguation(dest, j.suite, j.name, j.mans); err != nil {
+					return err
+				}
+				atomic.AddUint64(&gv.stats.DisambiguationsRendered, 1)
+			}
+			return nil
+		})
+	}
+
+	// rendered contains the paths (relative to -serving_dir) of all
+	// current disambiguation pages, see removeStaleDisambiguations.
+	rendered := make(map[string]bool)
+	for name, versions := range gv.xref {
+		for suite := range gv.suites {
+			mans := ambiguousIn(suite, versions)
+			if mans == nil {
+				continue
+			}
+			rendered[disambiguationPath(suite, name)] = true
+			select {
+			case jobs <- disambiguationJob{
+				suite: suite,
+				name:  name,
+				mans:  mans,
+			}:
+			case <-ctx.Done():
+				break
+			}
+		}
+	}
+	close(jobs)
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	return removeStaleDisambiguations(gv, rendered)
+}
+
+// removeStaleDisambiguations removes the disambiguation pages of the
+// suites of gv which are not in rendered, e.g. because one of the
+// manpages was removed and the name is no longer ambiguous.
+func removeStaleDisambiguations(gv globalView, rendered map[string]bool) error {
+	for suite := range gv.suites {
+		dir := filepath.Join(*servingDir, "disambiguation", suite)
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, fi := range fis {
+			rel := filepath.Join("disambiguation", suite, fi.Name())
+			if !fi.Mode().IsRegular() || !strings.HasSuffix(rel, htmlSuffix()) || rendered[rel] {
+				continue
+			}
+			debugf("removing stale disambiguation page %q", rel)
+			if err := os.Remove(filepath.Join(*servingDir, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderDisambiguations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	parseTemplates()
+	gv := newTestGlobalView(t, "testing", "i3-wm", "man1/i3.1.gz", "man5/i3.5.gz", "man1/i3-msg.1.gz")
+
+	// A page of a name which is no longer ambiguous, and unrelated
+	// files, which must be left alone.
+	for _, path := range []string{
+		"disambiguation/testing/i3-msg.html",
+		"disambiguation/testing/README",
+		"disambiguation/stable/i3-msg.html",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := renderDisambiguations(gv); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, "disambiguation/testing/i3.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a href="/testing/i3-wm/i3.1.en.html">i3(1)</a>`,
+		`<a href="/testing/i3-wm/i3.5.en.html">i3(5)</a>`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("disambiguation page does not contain %q", want)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpdir, "disambiguation/testing/i3-msg.html")); !os.IsNotExist(err) {
+		t.Errorf("stale disambiguation page not removed: %v", err)
+	}
+	for _, path := range []string{
+		"disambiguation/testing/README",
+		"disambiguation/stable/i3-msg.html",
+	} {
+		if _, err := os.Stat(filepath.Join(tmpdir, path)); err != nil {
+			t.Errorf("%s unexpectedly removed: %v", path, err)
+		}
+	}
+
+	if got, want := disambiguationURL("testing", "i3"), "/disambiguation/testing/i3.html"; got != want {
+		t.Errorf("disambiguationURL: got %q, want %q", got, want)
+	}
+}
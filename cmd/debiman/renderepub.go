@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Debian/debiman/internal/epub"
+	"github.com/Debian/debiman/internal/manpage"
+	"golang.org/x/sync/errgroup"
+)
+
+var renderEPUB = flag.Bool("render_epub",
+	false,
+	"Additionally assemble an EPUB book (<suite>/<binarypkg>/<binarypkg>.epub) containing all rendered manpages of each binary package, linked from the package index")
+
+// epubPath returns the path of the EPUB book of the binary package
+// directory dir.
+func epubPath(dir string) string {
+	return filepath.Join(dir, filepath.Base(dir)+".epub")
+}
+
+// epubStale returns true if the EPUB book of dir should be written,
+// but is missing or older than modTime.
+func epubStale(dir string, modTime time.Time) bool {
+	if !*renderEPUB {
+		return false
+	}
+	st, err := os.Stat(epubPath(dir))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+type epubJob struct {
+	dir     string
+	names   []string
+	modTime time.Time
+}
+
+// epubQueue collects the binary packages whose EPUB books need to be
+// assembled. While the package index is rendered, the package’s
+// manpages might still be rendering, so books are only assembled once
+// all manpages were rendered.
+type epubQueue struct {
+	mu   sync.Mutex
+	jobs []epubJob
+}
+
+// add queues the EPUB book for the manpages names (file names within
+// dir) for assembly. q may be nil, in which case add does nothing.
+func (q *epubQueue) add(dir string, names []string, modTime time.Time) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(q.jobs, epubJob{dir: dir, names: names, modTime: modTime})
+}
+
+// render assembles all queued EPUB books.
+func (q *epubQueue) render() error {
+	eg, ctx := errgroup.WithContext(context.Background())
+	jobs := make(chan epubJob)
+	for i := 0; i < *renderConcurrency; i++ {
+		eg.Go(func() error {
+			for job := range jobs {
+				if err := renderEPUBBook(job); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	// Once a worker failed, the others stop reading jobs.
+feed:
+	for _, job := range q.jobs {
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	return eg.Wait()
+}
+
+// epubLinks makes the site-relative links of rendered manpages
+// absolute, so that they work within EPUB readers.
+func epubLinks(doc string) string {
+	return strings.NewReplacer(
+		`href="/`, `href="`+*baseURL+`/`,
+		`src="/`, `src="`+*baseURL+`/`).Replace(doc)
+}
+
+// renderEPUBBook assembles the EPUB book from the rendered manpages of
+// job.dir, in the order of the package index.
+func renderEPUBBook(job epubJob) error {
+	suite := filepath.Base(filepath.Dir(job.dir))
+	binarypkg := filepath.Base(job.dir)
+	book := &epub.Book{
+		Identifier: fmt.Sprintf("%s/%s/%s/", *baseURL, suite, binarypkg),
+		Title:      fmt.Sprintf("Manpages of %s in Debian %s", binarypkg, suite),
+		Language:   "en",
+		Modified:   job.modTime,
+	}
+	for _, fn := range job.names {
+		m, err := manpage.FromServingPath(*servingDir, filepath.Join(job.dir, fn))
+		if err != nil {
+			errorf("BUG: cannot parse manpage from serving path %q: %v", filepath.Join(job.dir, fn), err)
+			continue
+		}
+		rendered := filepath.Join(job.dir, strings.TrimSuffix(fn, ".gz")+htmlSuffix())
+		doc, _, err := reuse(rendered)
+		if err != nil {
+			warningf("omitting %q from EPUB book: %v", rendered, err)
+			continue
+		}
+		title := fmt.Sprintf("%s(%s)", m.Name, m.Section)
+		if m.Language != "en" {
+			title += " (" + m.Language + ")"
+		}
+		book.AddChapter(title, epubLinks(doc))
+	}
+	recordArtifact(artifactEPUB, epubPath(job.dir))
+	return writeAtomically(epubPath(job.dir), false, func(w io.Writer) error {
+		return book.Write(w)
+	})
+}
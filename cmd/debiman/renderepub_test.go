@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEPUBQueueFailure(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldConcurrency := *renderConcurrency
+	*renderConcurrency = 1
+	defer func() { *renderConcurrency = oldConcurrency }()
+
+	// The package directories do not exist, so writing any book fails.
+	var q epubQueue
+	for _, pkg := range []string{"i3-wm", "i3lock", "i3status"} {
+		q.add(filepath.Join(tmpdir, "jessie", pkg), nil, time.Now())
+	}
+	done := make(chan error)
+	go func() { done <- q.render() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("render unexpectedly succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("render did not return after its worker failed")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var transcodeImages = flag.Bool("transcode_images",
+	false,
+	"Transcode PNG and JPEG images embedded in manpages to the more compact WebP format using cwebp(1)")
+
+// webImageExt contains the file extensions of image formats which
+// browsers can display.
+var webImageExt = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".svg":  true,
+	".webp": true,
+	".avif": true,
+}
+
+// pageAssetsDir returns the directory (relative to -serving_dir) in
+// which the images of m are placed.
+func pageAssetsDir(m *manpage.Meta) string {
+	return m.ServingPath() + ".assets"
+}
+
+// containedPath returns the path of name (e.g. an absolute path within
+// a package, like /usr/share/doc/i3/diagram.png) within dir, or an
+// error if name would leave dir (e.g. /../../../../etc/passwd).
+func containedPath(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside of %q", name, dir)
+	}
+	return path, nil
+}
+
+// copyPageImages places the images referenced by m (absolute paths
+// which were extracted into the aux/ directory of m’s package) into
+// the assets directory of m and returns their URLs. Images which were
+// not extracted or which browsers cannot display result in an empty
+// URL. Images are only copied (or transcoded) if they are missing or
+// older than their source.
+func copyPageImages(m *manpage.Meta, images []string) ([]string, error) {
+	urls := make([]string, len(images))
+	names := make(map[string]bool, len(images))
+	for idx, img := range images {
+		src, err := containedPath(filepath.Join(*servingDir, m.Package.Suite, m.Package.Binarypkg, "aux"), img)
+		if err != nil {
+			warningf("%s: omitting image: %v", m.ServingPath(), err)
+			continue
+		}
+		st, err := os.Lstat(src)
+		if err != nil {
+			warningf("%s: image %q was not extracted: %v", m.ServingPath(), img, err)
+			continue
+		}
+		if !st.Mode().IsRegular() {
+			// e.g. a symlink, which could point outside of the package.
+			warningf("%s: image %q is not a regular file, omitting", m.ServingPath(), img)
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(img))
+		if !webImageExt[ext] {
+			warningf("%s: image %q cannot be displayed by browsers, omitting", m.ServingPath(), img)
+			continue
+		}
+
+		name := filepath.Base(img)
+		transcode := *transcodeImages && (ext == ".png" || ext == ".jpg" || ext == ".jpeg")
+		if transcode {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".webp"
+		}
+		if names[name] {
+			// Disambiguate images with the same file name.
+			name = fmt.Sprintf("%d-%s", idx, name)
+		}
+		names[name] = true
+
+		rel := filepath.Join(pageAssetsDir(m), name)
+		urls[idx] = "/" + rel
+		dest := filepath.Join(*servingDir, rel)
+		if dst, err := os.Stat(dest); err == nil && !dst.ModTime().Before(st.ModTime()) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, err
+		}
+		recordArtifact(artifactManpage, dest)
+		if transcode {
+			err := writeAtomically(dest, false, func(w io.Writer) error {
+				cmd := exec.Command("cwebp", "-quiet", src, "-o", "-")
+				cmd.Stdout = w
+				cmd.Stderr = os.Stderr
+				return cmd.Run()
+			})
+			if err == nil {
+				continue
+			}
+			warningf("%s: transcoding %q failed, using the original image: %v", m.ServingPath(), img, err)
+			name = filepath.Base(img)
+			rel = filepath.Join(pageAssetsDir(m), name)
+			urls[idx] = "/" + rel
+			dest = filepath.Join(*servingDir, rel)
+			recordArtifact(artifactManpage, dest)
+		}
+		if err := writeAtomically(dest, false, func(w io.Writer) error {
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
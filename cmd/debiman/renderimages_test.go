@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+func TestCopyPageImages(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	old := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = old }()
+
+	aux := filepath.Join(tmpdir, "jessie", "foo", "aux", "usr", "share", "doc", "foo")
+	if err := os.MkdirAll(aux, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{"diagram.png", "diagram.eps"} {
+		if err := ioutil.WriteFile(filepath.Join(aux, fn), []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &manpage.Meta{
+		Name: "foo",
+		Package: &manpage.PkgMeta{
+			Binarypkg: "foo",
+			Suite:     "jessie",
+		},
+		Section:  "1",
+		Language: "en",
+	}
+	// A file outside of aux/, which must not be copied.
+	secret := filepath.Join(tmpdir, "jessie", "secret.png")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(aux, "link.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	images := []string{
+		"/usr/share/doc/foo/diagram.png",
+		"/usr/share/doc/foo/diagram.eps", // not displayable
+		"/usr/share/doc/foo/missing.png", // not extracted
+		"../../secret.png",
+		"/../../../secret.png",
+		"/usr/share/doc/foo/link.png",
+	}
+	urls, err := copyPageImages(m, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/jessie/foo/foo.1.en.assets/diagram.png", "", "", "", "", ""}
+	if len(urls) != len(want) {
+		t.Fatalf("copyPageImages: got %d URLs, want %d", len(urls), len(want))
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "jessie", "foo", "foo.1.en.assets", "secret.png")); !os.IsNotExist(err) {
+		t.Fatalf("image outside of aux/ was unexpectedly copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "jessie", "foo", "foo.1.en.assets", "link.png")); !os.IsNotExist(err) {
+		t.Fatalf("symlinked image was unexpectedly copied: %v", err)
+	}
+	for idx, url := range urls {
+		if url != want[idx] {
+			t.Errorf("copyPageImages()[%d]: got %q, want %q", idx, url, want[idx])
+		}
+	}
+
+	dest := filepath.Join(tmpdir, "jessie", "foo", "foo.1.en.assets", "diagram.png")
+	past := time.Now().Add(-1 * time.Hour)
+	if err := os.Chtimes(dest, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(aux, "diagram.png"), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// Up to date images must not be copied again.
+	if _, err := copyPageImages(m, images); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.ModTime().Equal(past) {
+		t.Fatalf("up to date image %q was unexpectedly copied again", dest)
+	}
+
+	// Stale images must be copied again.
+	if err := ioutil.WriteFile(filepath.Join(aux, "diagram.png"), []byte("updated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyPageImages(m, images); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "updated"; got != want {
+		t.Fatalf("stale image not updated: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/info"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var renderInfo = flag.Bool("render_info",
+	false,
+	"Additionally extract GNU Info documents (/usr/share/info/*.info.gz) and render them to <serving_dir>/info/<suite>/<binarypkg>/<document>.html, with one index per suite. Packages which ship Info documents are discovered like those which ship manpages (see the Contents files), and their documents are extracted when the package is (re-)extracted.")
+
+var infodocTmpl = mustParseInfodocTmpl()
+
+func mustParseInfodocTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("infodoc").Parse(bundled.Asset("infodoc.tmpl")))
+}
+
+var infoindexTmpl = mustParseInfoindexTmpl()
+
+func mustParseInfoindexTmpl() *template.Template {
+	return template.Must(template.Must(commonTmpls.Clone()).New("infoindex").Parse(bundled.Asset("infoindex.tmpl")))
+}
+
+// infoFileRe matches the files of Info documents, i.e. the main file
+// (e.g. coreutils.info.gz) and, for large documents, the files it
+// was split into (e.g. coreutils.info-1.gz).
+var infoFileRe = regexp.MustCompile(`^[^/]+\.info(-[0-9]+)?\.gz$`)
+
+// isInfoFile returns whether name (a path within a Debian package) is a
+// file of an Info document.
+func isInfoFile(name string) bool {
+	return strings.HasPrefix(name, "./usr/share/info/") && infoFileRe.MatchString(path.Base(name))
+}
+
+// infoDir returns the directory containing the extracted and rendered
+// Info documents of binarypkg in suite.
+func infoDir(suite, binarypkg string) string {
+	return filepath.Join(*servingDir, "info", suite, binarypkg)
+}
+
+// extractInfo extracts the Info file described by header from r.
+func extractInfo(p pkgEntry, header *tar.Header, r io.Reader) error {
+	dest := filepath.Join(infoDir(p.suite, p.binarypkg), path.Base(header.Name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	recordArtifact(artifactInfo, dest)
+	if err := writeAtomically(dest, false, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	}); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, header.ModTime, header.ModTime)
+}
+
+// infoDoc is an extracted Info document.
+type infoDoc struct {
+	Name      string // e.g. coreutils
+	Binarypkg string
+
+	// modTime is the modification time of the newest file of the
+	// document.
+	modTime time.Time
+}
+
+type infoDocsByName []*infoDoc
+
+func (p infoDocsByName) Len() int           { return len(p) }
+func (p infoDocsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p infoDocsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+
+// findInfoDocs returns the Info documents extracted for suite, sorted
+// by name. If multiple packages ship a document of the same name, the
+// first package in sort order wins.
+func findInfoDocs(suite string) ([]*infoDoc, error) {
+	dir := filepath.Join(*servingDir, "info", suite)
+	binarypkgs, err := readdirnames(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(binarypkgs)
+	byName := make(map[string]*infoDoc)
+	var docs []*infoDoc
+	for _, binarypkg := range binarypkgs {
+		f, err := os.Open(filepath.Join(dir, binarypkg))
+		if err != nil {
+			return nil, err
+		}
+		fis, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		pkgDocs := make(map[string]*infoDoc)
+		for _, fi := range fis {
+			if !infoFileRe.MatchString(fi.Name()) {
+				continue
+			}
+			// Split files (coreutils.info-1.gz) belong to the document
+			// of the same name.
+			name := info.DocumentName(strings.TrimSuffix(fi.Name(), ".gz"))
+			if idx := strings.LastIndex(name, ".info-"); idx > -1 {
+				name = name[:idx]
+			}
+			doc, ok := pkgDocs[name]
+			if !ok {
+				doc = &infoDoc{Name: name, Binarypkg: binarypkg}
+				pkgDocs[name] = doc
+			}
+			if fi.ModTime().After(doc.modTime) {
+				doc.modTime = fi.ModTime()
+			}
+		}
+		for name, doc := range pkgDocs {
+			if _, err := os.Stat(filepath.Join(dir, binarypkg, name+".info.gz")); err != nil {
+				continue // split files without main file
+			}
+			if _, ok := byName[name]; ok {
+				warningf("Info document %q is shipped by %q and %q in %q, using the former", name, byName[name].Binarypkg, binarypkg, suite)
+				continue
+			}
+			byName[name] = doc
+			docs = append(docs, doc)
+		}
+	}
+	sort.Sort(infoDocsByName(docs))
+	return docs, nil
+}
+
+// openInfoFile opens the (gzip-compressed) file name of an Info document
+// in dir. As name is taken from the document (e.g. its indirect table),
+// it must not lead outside of dir, neither directly nor via symlinks.
+func openInfoFile(dir, name string) (io.ReadCloser, error) {
+	path, err := containedPath(dir, name+".gz")
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(resolved, resolvedDir+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%q is outside of %q", name, dir)
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+func renderInfoDoc(suite string, doc *infoDoc, resolve func(document string) string) error {
+	dir := infoDir(suite, doc.Binarypkg)
+	parsed, err := info.Read(doc.Name+".info", func(name string) (io.ReadCloser, error) {
+		return openInfoFile(dir, name)
+	})
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, doc.Name+htmlSuffix())
+	recordArtifact(artifactInfo, dest)
+	return writeAtomicallyLarge(dest, func(w io.Writer) error {
+		return infodocTmpl.Execute(w, struct {
+			Title          string
+			DebimanVersion string
+			Breadcrumbs    breadcrumbs
+			FooterExtra    string
+			Meta           *manpage.Meta
+			HrefLangs      []*manpage.Meta
+			Name           string
+			Suite          string
+			Binarypkg      string
+			Content        template.HTML
+		}{
+			Title:          fmt.Sprintf("%s (Info)", doc.Name),
+			DebimanVersion: debimanVersion,
+			Breadcrumbs: breadcrumbs{
+				{fmt.Sprintf("/info/%s/index.html", suite), suite},
+				{"", doc.Name},
+			},
+			Name:      doc.Name,
+			Suite:     suite,
+			Binarypkg: doc.Binarypkg,
+			Content:   template.HTML(parsed.HTML(resolve)),
+		})
+	}, nil)
+}
+
+// renderInfoDocuments renders all Info documents whose HTML is missing
+// or older than the document, and the index of each suite.
+// Cross-references between documents are resolved within the suite.
+func renderInfoDocuments(suites map[string]bool) error {
+	for suite := range suites {
+		docs, err := findInfoDocs(suite)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		byName := make(map[string]*infoDoc, len(docs))
+		for _, doc := range docs {
+			byName[doc.Name] = doc
+		}
+		resolve := func(document string) string {
+			doc, ok := byName[document]
+			if !ok {
+				return ""
+			}
+			return fmt.Sprintf("/info/%s/%s/%s.html", suite, doc.Binarypkg, doc.Name)
+		}
+		var rendered int
+		for _, doc := range docs {
+			dest := filepath.Join(infoDir(suite, doc.Binarypkg), doc.Name+htmlSuffix())
+			if st, err := os.Stat(dest); err == nil && !*forceRerender && !st.ModTime().Before(doc.modTime) {
+				continue
+			}
+			if err := renderInfoDoc(suite, doc, resolve); err != nil {
+				// A malformed document should not prevent rendering the
+				// others.
+				warningf("rendering Info document %q of %q failed: %v", doc.Name, doc.Binarypkg, err)
+				continue
+			}
+			rendered++
+		}
+
+		dest := filepath.Join(*servingDir, "info", suite, "index"+htmlSuffix())
+		recordArtifact(artifactInfo, dest)
+		if err := writeAtomicallyLarge(dest, func(w io.Writer) error {
+			return infoindexTmpl.Execute(w, struct {
+				Title          string
+				DebimanVersion string
+				Breadcrumbs    breadcrumbs
+				FooterExtra    string
+				Meta           *manpage.Meta
+				HrefLangs      []*manpage.Meta
+				Suite          string
+				Docs           []*infoDoc
+			}{
+				Title:          fmt.Sprintf("Info documents in Debian %s", suite),
+				DebimanVersion: debimanVersion,
+				Breadcrumbs: breadcrumbs{
+					{"", "Info"},
+					{"", suite},
+				},
+				Suite: suite,
+				Docs:  docs,
+			})
+		}, nil); err != nil {
+			return err
+		}
+		infof("Rendered %d of %d Info documents in %q", rendered, len(docs), suite)
+	}
+	return nil
+}
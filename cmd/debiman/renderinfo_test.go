@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsInfoFile(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"./usr/share/info/coreutils.info.gz", true},
+		{"./usr/share/info/coreutils.info-1.gz", true},
+		{"./usr/share/info/dir", false},
+		{"./usr/share/info/coreutils.info", false},
+		{"./usr/share/info/coreutils/ls.info.gz", true},
+		{"./usr/share/doc/coreutils/coreutils.info.gz", false},
+	} {
+		if got := isInfoFile(tt.name); got != tt.want {
+			t.Errorf("isInfoFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenInfoFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dir := filepath.Join(tmpdir, "jessie", "coreutils", "info")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "coreutils.info-1.gz"),
+		filepath.Join(tmpdir, "secret.gz"),
+	} {
+		writeGzipped(t, path, "contents")
+	}
+	if err := os.Symlink("../../../secret.gz", filepath.Join(dir, "link.gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openInfoFile(dir, "coreutils.info-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "contents"; got != want {
+		t.Fatalf("unexpected contents: got %q, want %q", got, want)
+	}
+
+	for _, name := range []string{"../../../secret", "/../../../../secret", "link"} {
+		if r, err := openInfoFile(dir, name); err == nil {
+			r.Close()
+			t.Errorf("openInfoFile(%q) unexpectedly succeeded", name)
+		}
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -134,26 +134,92 @@ func mustParseManpagefooterextraTmpl() *template.Template {
 		Parse(bundled.Asset("manpagefooterextra.tmpl")))
 }
 
-func convertFile(converter *convert.Process, src string, resolve func(ref string) string) (doc string, toc []string, err error) {
+// convertFile converts the manpage src (of m) to HTML. If lint is
+// non-nil, mandoc’s warnings are returned as well. Images referenced
+// by the manpage (and, with -fallback_image_command, rendered eqn and
+// tbl blocks) are placed into the assets directory of m.
+func convertFile(converter *convert.Process, src string, m *manpage.Meta, lint *lintReport, resolve func(ref string) string) (doc string, toc []convert.TOCEntry, warnings []string, err error) {
 	f, err := os.Open(src)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 	defer f.Close()
 	r, err := gzip.NewReader(f)
 	if err != nil {
 		if err == io.EOF {
 			// TODO: better representation of an empty manpage
-			return "This space intentionally left blank.", nil, nil
+			return "This space intentionally left blank.", nil, nil, nil
 		}
-		return "", nil, err
+		return "", nil, nil, err
 	}
 	defer r.Close()
-	out, toc, err := converter.ToHTML(r, resolve)
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("convert(%q): %v", src, err)
+		return "", nil, nil, err
 	}
-	return out, toc, nil
+	b, images, err := convert.ReplaceImages(b)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var blocks []convert.Block
+	if *fallbackImageCommand != "" {
+		b, blocks, err = convert.ReplaceBlocks(b, mandocFeatures.MathML)
+		if err != nil {
+			return "", nil, nil, err
+		}
+	}
+	var out string
+	if lint != nil {
+		out, toc, warnings, err = converter.ToHTMLWithWarnings(bytes.NewReader(b), resolve)
+	} else {
+		out, toc, err = converter.ToHTML(bytes.NewReader(b), resolve)
+	}
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("convert(%q): %v", src, err)
+	}
+	if len(images) > 0 {
+		urls, err := copyPageImages(m, images)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		out = convert.InsertImages(out, urls)
+	}
+	if len(blocks) > 0 {
+		out = convert.InsertBlocks(out, renderBlockImages(m, blocks), blocks)
+	}
+	return out, toc, warnings, nil
+}
+
+// limitTOC returns toc without the subsection entries if depth is
+// less than 2.
+func limitTOC(toc []convert.TOCEntry, depth int) []convert.TOCEntry {
+	if depth >= 2 {
+		return toc
+	}
+	limited := make([]convert.TOCEntry, len(toc))
+	for idx, entry := range toc {
+		limited[idx] = convert.TOCEntry{Heading: entry.Heading}
+	}
+	return limited
+}
+
+// readSource calls read with the decompressed contents of the manpage
+// src, unless src is empty.
+func readSource(src string, read func(r io.Reader) error) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	return read(r)
 }
 
 type byPkgAndLanguage struct {
@@ -173,6 +239,35 @@ func (p byPkgAndLanguage) Less(i, j int) bool {
 	return p.opts[i].Language < p.opts[j].Language
 }
 
+// resolveXref returns the manpage in the suite of current which ref
+// (e.g. “crontab(5)”) refers to, or nil if there is none.
+func resolveXref(current *manpage.Meta, xref map[string][]*manpage.Meta, ref string) *manpage.Meta {
+	idx := strings.LastIndex(ref, "(")
+	if idx == -1 {
+		return nil
+	}
+	section := ref[idx+1 : len(ref)-1]
+	name := ref[:idx]
+	related, ok := xref[name]
+	if !ok {
+		return nil
+	}
+	filtered := make([]*manpage.Meta, 0, len(related))
+	for _, r := range related {
+		if r.MainSection() != section {
+			continue
+		}
+		if r.Package.Suite != current.Package.Suite {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return bestLanguageMatch(current, filtered)
+}
+
 // bestLanguageMatch returns the best manpage out of options (coming
 // from current) based on text/language’s matching.
 func bestLanguageMatch(current *manpage.Meta, options []*manpage.Meta) *manpage.Meta {
@@ -226,6 +321,15 @@ type renderJob struct {
 	xref     map[string][]*manpage.Meta
 	modTime  time.Time
 	reuse    string
+	lint     *lintReport
+	refs     *referenceGraph
+	whatis   *whatisDB
+	search   *searchIndexer
+	change   feedChange // refined by rendermanpage, see contentChanged
+
+	// pkg is the binary package directory for which the job was sent,
+	// see renderJournal. nil when rendering a single manpage.
+	pkg *packageJobs
 }
 
 var notYetRenderedSentinel = errors.New("Not yet rendered")
@@ -242,10 +346,30 @@ type manpagePrepData struct {
 	Langs          []*manpage.Meta
 	HrefLangs      []*manpage.Meta
 	Meta           *manpage.Meta
-	TOC            []string
+	TOC            []convert.TOCEntry
 	Ambiguous      map[*manpage.Meta]bool
 	Content        template.HTML
 	Error          error
+	Text           bool
+	PDF            bool
+	Roff           bool
+	Markdown       bool // set by rendermanpage
+
+	// Diffs are the links to the differences to other suites (see
+	// -render_diffs), keyed by suite.
+	Diffs map[string]string
+
+	// Disambiguation is the URL of the disambiguation page of the
+	// manpage name, if it is ambiguous in the suite.
+	Disambiguation string
+
+	// ReferencedBy are the manpages referencing this manpage in their
+	// SEE ALSO section (see -backlinks).
+	ReferencedBy []*manpage.Meta
+
+	// SearchIndex is set if the client-side search index is written
+	// (see -client_search_index).
+	SearchIndex bool
 }
 
 type bySuite []*manpage.Meta
@@ -269,9 +393,11 @@ func (p byMainSection) Less(i, j int) bool { return p[i].MainSection() < p[j].Ma
 
 type byBinarypkg []*manpage.Meta
 
-func (p byBinarypkg) Len() int           { return len(p) }
-func (p byBinarypkg) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p byBinarypkg) Less(i, j int) bool { return p[i].Package.Binarypkg < p[j].Package.Binarypkg }
+func (p byBinarypkg) Len() int      { return len(p) }
+func (p byBinarypkg) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byBinarypkg) Less(i, j int) bool {
+	return popconLess(p[i].Package.Binarypkg, p[j].Package.Binarypkg)
+}
 
 func rendermanpageprep(converter *convert.Process, job renderJob) (*template.Template, manpagePrepData, error) {
 	meta := job.meta // for convenience
@@ -281,45 +407,42 @@ func rendermanpageprep(converter *convert.Process, job renderJob) (*template.Tem
 
 	var (
 		content   string
-		toc       []string
+		toc       []convert.TOCEntry
 		renderErr = notYetRenderedSentinel
 	)
 	if job.reuse != "" {
 		content, toc, renderErr = reuse(job.reuse)
 		if renderErr != nil {
-			log.Printf("WARNING: re-using %q failed: %v", job.reuse, renderErr)
+			warningf("re-using %q failed: %v", job.reuse, renderErr)
 		}
 	}
 	if renderErr != nil {
-		content, toc, renderErr = convertFile(converter, job.src, func(ref string) string {
-			idx := strings.LastIndex(ref, "(")
-			if idx == -1 {
-				return ""
-			}
-			section := ref[idx+1 : len(ref)-1]
-			name := ref[:idx]
-			related, ok := job.xref[name]
-			if !ok {
-				return ""
-			}
-			filtered := make([]*manpage.Meta, 0, len(related))
-			for _, r := range related {
-				if r.MainSection() != section {
-					continue
-				}
-				if r.Package.Suite != meta.Package.Suite {
-					continue
-				}
-				filtered = append(filtered, r)
-			}
-			if len(filtered) == 0 {
-				return ""
+		var warnings []string
+		content, toc, warnings, renderErr = convertFile(converter, job.src, meta, job.lint, func(ref string) string {
+			if target := resolveXref(meta, job.xref, ref); target != nil {
+				return "/" + target.ServingPath() + ".html"
 			}
-			return "/" + bestLanguageMatch(meta, filtered).ServingPath() + ".html"
+			return ""
 		})
+		if renderErr != nil {
+			warningf("rendering %q failed, writing an error page: %v", job.src, renderErr)
+		}
+		if job.lint != nil && renderErr == nil {
+			job.lint.record(meta, warnings)
+		}
+	}
+
+	if job.refs != nil && renderErr == nil {
+		job.refs.record(meta, job.xref, content)
+	}
+	if job.whatis != nil && renderErr == nil {
+		job.whatis.record(meta, content)
+	}
+	if job.search != nil && renderErr == nil {
+		job.search.record(meta, content)
 	}
 
-	log.Printf("rendering %q", job.dest)
+	debugf("rendering %q", job.dest)
 
 	suites := make([]*manpage.Meta, 0, len(job.versions))
 	for _, v := range job.versions {
@@ -423,6 +546,11 @@ func rendermanpageprep(converter *convert.Process, job renderJob) (*template.Tem
 		title = "Error: " + title
 	}
 
+	var disambiguation string
+	if ambiguousIn(meta.Package.Suite, job.versions) != nil {
+		disambiguation = disambiguationURL(meta.Package.Suite, meta.Name)
+	}
+
 	var footerExtra bytes.Buffer
 	if err := manpagefooterextraTmpl.Execute(&footerExtra, struct {
 		SourceFile  string
@@ -446,18 +574,25 @@ func rendermanpageprep(converter *convert.Process, job renderJob) (*template.Tem
 			{fmt.Sprintf("/%s/%s/index.html", meta.Package.Suite, meta.Package.Binarypkg), meta.Package.Binarypkg},
 			{"", shorttitle},
 		},
-		FooterExtra: template.HTML(footerExtra.String()),
-		Suites:      suites,
-		Versions:    job.versions,
-		Sections:    sections,
-		Bins:        bins,
-		Langs:       langs,
-		HrefLangs:   hrefLangs,
-		Meta:        meta,
-		TOC:         toc,
-		Ambiguous:   ambiguous,
-		Content:     template.HTML(content),
-		Error:       renderErr,
+		FooterExtra:    template.HTML(footerExtra.String()),
+		Suites:         suites,
+		Versions:       job.versions,
+		Sections:       sections,
+		Bins:           bins,
+		Langs:          langs,
+		HrefLangs:      hrefLangs,
+		Meta:           meta,
+		TOC:            limitTOC(toc, *tocDepth),
+		Ambiguous:      ambiguous,
+		Content:        template.HTML(content),
+		Error:          renderErr,
+		Text:           *renderText,
+		PDF:            *renderPDF,
+		Roff:           *renderRoff,
+		Diffs:          diffLinks(meta, job.versions),
+		Disambiguation: disambiguation,
+		ReferencedBy:   job.refs.sources(meta),
+		SearchIndex:    *writeClientSearchIndex,
 	}, nil
 }
 
@@ -468,18 +603,75 @@ func (c *countingWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func rendermanpage(gzipw *gzip.Writer, converter *convert.Process, job renderJob) (uint64, error) {
+// contentChanged returns whether the manpage content of the page which
+// is about to be written to dest differs from the content of the page
+// previously rendered to dest. The rest of the page (e.g. the conversion
+// time or the list of versions) is not taken into account.
+func contentChanged(dest string, content template.HTML) bool {
+	if content == "" {
+		return true
+	}
+	old, err := readRendered(dest)
+	if err != nil {
+		return true
+	}
+	return !bytes.Contains(old, []byte(content))
+}
+
+// rendermanpage renders job and returns the number of bytes written
+// and, for the Atom feeds, the change of job.meta. Manpages whose
+// source was updated without changing their content are considered
+// unchanged.
+func rendermanpage(gzipw *gzip.Writer, converter *convert.Process, job renderJob) (uint64, feedChange, error) {
 	t, data, err := rendermanpageprep(converter, job)
 	if err != nil {
-		return 0, err
+		return 0, feedUnchanged, err
+	}
+
+	change := job.change
+	if change == feedUpdated && !contentChanged(job.dest, data.Content) {
+		change = feedUnchanged
+	}
+
+	if *jsonSidecar {
+		// The sidecar is written first, so that it is re-rendered
+		// along with job.dest if debiman is interrupted.
+		sidecar, err := newManpageSidecar(job.meta, job.versions, job.modTime, string(data.Content))
+		if err != nil {
+			return 0, feedUnchanged, err
+		}
+		if err := writeSidecar(job.dest, sidecar); err != nil {
+			return 0, feedUnchanged, err
+		}
+	}
+
+	if *renderText {
+		if err := writeText(job.src, job.dest); err != nil {
+			return 0, feedUnchanged, err
+		}
+	}
+
+	if *renderRoff {
+		if err := writeRoff(job.src, job.dest); err != nil {
+			return 0, feedUnchanged, err
+		}
+	}
+
+	if *renderMarkdown {
+		written, err := writeMarkdown(job.src, job.dest)
+		if err != nil {
+			return 0, feedUnchanged, err
+		}
+		data.Markdown = written
 	}
 
+	recordArtifact(artifactManpage, job.dest)
 	var written countingWriter
 	if err := writeAtomicallyWithGz(job.dest, gzipw, func(w io.Writer) error {
 		return t.Execute(io.MultiWriter(w, &written), data)
 	}); err != nil {
-		return 0, err
+		return 0, feedUnchanged, err
 	}
 
-	return uint64(written), nil
+	return uint64(written), change, nil
 }
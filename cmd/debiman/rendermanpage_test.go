@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -79,7 +80,7 @@ baz
 	manpagesFrSystemd := mustParseFromServingPath(t, "testing/manpages-fr-systemd/crontab.5.fr")
 	manpagesFrSystemd.Package.Replaces = []string{"manpages-fr-extra"}
 
-	converter, err := convert.NewProcess()
+	converter, err := convert.NewProcess(convert.Mandoc{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -187,3 +188,30 @@ baz
 		}
 	})
 }
+
+func TestContentChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debiman-changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	dest := filepath.Join(dir, "i3.1.en.html")
+	if !contentChanged(dest, "<p>i3</p>") {
+		t.Errorf("contentChanged = false for a page which was not yet rendered")
+	}
+	page := "<html>converted at 2016-01-01<p>i3</p></html>"
+	if err := ioutil.WriteFile(dest, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if contentChanged(dest, "<p>i3</p>") {
+		t.Errorf("contentChanged = true for unchanged content")
+	}
+	if !contentChanged(dest, "<p>i3 4.13</p>") {
+		t.Errorf("contentChanged = false for changed content")
+	}
+}
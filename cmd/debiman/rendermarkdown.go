@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var renderMarkdown = flag.Bool("render_markdown",
+	false,
+	"Additionally render a Markdown version (<name>.<section>.<language>.md.gz) of every manpage using mandoc -Tmarkdown, e.g. for embedding in wikis. Requires mandoc >= 1.14.1, which only supports mdoc(7) manpages.")
+
+// markdownPath returns the path of the Markdown version of the rendered
+// manpage dest.
+func markdownPath(dest string) string {
+	path := strings.TrimSuffix(dest, htmlSuffix()) + ".md"
+	if !*noCompress {
+		path += ".gz"
+	}
+	return path
+}
+
+// markdownStale returns true if the Markdown version of dest should be
+// written, but is missing or older than modTime.
+func markdownStale(dest string, modTime time.Time) bool {
+	if !*renderMarkdown {
+		return false
+	}
+	st, err := os.Stat(markdownPath(dest))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+// writeMarkdown renders the manpage src to Markdown and writes it next
+// to the rendered manpage dest. The returned bool indicates whether the
+// Markdown version has any content, i.e. whether it should be linked:
+// the file is written regardless, so that the manpage is not
+// re-rendered over and over.
+func writeMarkdown(src, dest string) (bool, error) {
+	var markdown string
+	if err := readSource(src, func(r io.Reader) error {
+		var err error
+		if markdown, err = mandoc.ToMarkdown(r); err != nil {
+			warningf("rendering %q as Markdown failed: %v", src, err)
+			markdown = ""
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	recordArtifact(artifactManpage, markdownPath(dest))
+	if err := writeAtomically(markdownPath(dest), !*noCompress, func(w io.Writer) error {
+		_, err := io.WriteString(w, markdown)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(markdown) != "", nil
+}
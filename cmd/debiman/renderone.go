@@ -0,0 +1,192 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var (
+	renderOne = flag.String("render_one",
+		"",
+		"If non-empty, the serving path (e.g. jessie/i3-wm/i3.1.en) of a single manpage to re-render, or a comma-separated list of serving paths. The manpage must have been extracted, and is re-rendered in place within -serving_dir. Extraction and all other rendering is skipped (for debugging, or for debiman-minisrv -watch). To render an arbitrary manpage file instead, use the render-one command")
+
+	renderOneOutput = flag.String("render_one_output",
+		"-",
+		"Path to which the render-one command (not -render_one, which writes into -serving_dir) writes the rendered (uncompressed) HTML, or - for standard output")
+)
+
+// renderSelected re-renders the manpages identified by the
+// comma-separated serving paths.
+func renderSelected(gv globalView, servingPaths string) error {
+	for _, servingPath := range strings.Split(servingPaths, ",") {
+		if servingPath == "" {
+			continue
+		}
+		if err := renderSingle(gv, servingPath); err != nil {
+			return fmt.Errorf("%s: %v", servingPath, err)
+		}
+	}
+	return nil
+}
+
+// renderSingle re-renders the manpage identified by servingPath,
+// printing mandoc’s warnings for it.
+func renderSingle(gv globalView, servingPath string) error {
+	servingPath = strings.TrimPrefix(servingPath, "/")
+	servingPath = strings.TrimSuffix(servingPath, ".gz")
+	servingPath = strings.TrimSuffix(servingPath, ".html")
+
+	m, err := manpage.FromServingPath(*servingDir, filepath.Join(*servingDir, servingPath))
+	if err != nil {
+		return err
+	}
+	versions := gv.xref[m.Name]
+	// Replace m with its corresponding entry in versions, like
+	// walkManContents does.
+	for _, v := range versions {
+		if v.ServingPath() == m.ServingPath() {
+			m = v
+			break
+		}
+	}
+
+	src := filepath.Join(*servingDir, m.RawPath())
+	st, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("%v (was the package extracted?)", err)
+	}
+
+	converter, err := convert.NewProcess(mandoc)
+	if err != nil {
+		return err
+	}
+	defer converter.Kill()
+	converter.HighlightExamples = *highlightExamples
+	converter.Timeout = *mandocTimeout
+	converter.Filters = filters
+
+	gzipw, err := gzip.NewWriterLevel(nil, *gzipLevel)
+	if err != nil {
+		return err
+	}
+
+	lint := newLintReport(gv.stats)
+	lint.verbose = true
+	dest := filepath.Join(*servingDir, m.ServingPath()+htmlSuffix())
+	infof("mandoc command: zcat %s | mandoc -Ofragment -Thtml -Wwarning", src)
+	if _, _, err := rendermanpage(gzipw, converter, renderJob{
+		dest:     dest,
+		src:      src,
+		meta:     m,
+		versions: versions,
+		xref:     gv.xref,
+		modTime:  st.ModTime(),
+		lint:     lint,
+	}); err != nil {
+		return err
+	}
+	infof("%d mandoc warnings, wrote %q", gv.stats.MandocWarnings, dest)
+	return nil
+}
+
+// fileMeta returns the manpage metadata of the manpage file at path,
+// which is either in the serving directory, or named like in the
+// serving directory (<name>.<section>.<lang>.gz) or like in
+// /usr/share/man (<name>.<section>.gz). Manpages outside of the serving
+// directory are attributed to the suite and package “local”.
+func fileMeta(path string) (*manpage.Meta, error) {
+	if strings.HasPrefix(path, filepath.Clean(*servingDir)+"/") {
+		if m, err := manpage.FromServingPath(*servingDir, path); err == nil {
+			return m, nil
+		}
+	}
+	base := strings.TrimSuffix(filepath.Base(path), ".gz")
+	if m, err := manpage.FromServingPath("", "local/local/"+base); err == nil {
+		return m, nil
+	}
+	ext := filepath.Ext(base)
+	if len(ext) < 2 {
+		return nil, fmt.Errorf("cannot determine the section of %q: expected e.g. i3.1.gz or i3.1.en.gz", path)
+	}
+	return manpage.FromManPath("man"+ext[1:2]+"/"+base+".gz", &manpage.PkgMeta{
+		Binarypkg: "local",
+		Suite:     "local",
+	})
+}
+
+// renderOneFile renders the manpage file src to -render_one_output. The
+// cross-reference index of this run is used if packages were discovered
+// (e.g. by the fetch command), otherwise only src itself is known.
+func (p *pipeline) renderOneFile(src string) error {
+	m, err := fileMeta(src)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	versions := []*manpage.Meta{m}
+	xref := map[string][]*manpage.Meta{m.Name: versions}
+	stats := &stats{}
+	synthetic := true
+	if p.discovered {
+		stats = p.gv.stats
+		for _, v := range p.gv.xref[m.Name] {
+			if v.ServingPath() == m.ServingPath() {
+				m = v
+				versions = p.gv.xref[m.Name]
+				xref = p.gv.xref
+				synthetic = false
+				break
+			}
+		}
+	}
+	if synthetic {
+		infof("%s not found in the cross-reference index, using a synthetic one (run the fetch command first to resolve references)", m.ServingPath())
+	}
+
+	converter, err := convert.NewProcess(mandoc)
+	if err != nil {
+		return err
+	}
+	defer converter.Kill()
+	converter.HighlightExamples = *highlightExamples
+	converter.Timeout = *mandocTimeout
+	converter.Filters = filters
+
+	lint := newLintReport(stats)
+	lint.verbose = true
+	t, data, err := rendermanpageprep(converter, renderJob{
+		dest:     filepath.Join(*servingDir, m.ServingPath()+htmlSuffix()),
+		src:      src,
+		meta:     m,
+		versions: versions,
+		xref:     xref,
+		modTime:  st.ModTime(),
+		lint:     lint,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *renderOneOutput == "-" {
+		return t.Execute(os.Stdout, data)
+	}
+	if err := writeAtomically(*renderOneOutput, false, func(w io.Writer) error {
+		return t.Execute(w, data)
+	}); err != nil {
+		return err
+	}
+	infof("%d mandoc warnings, wrote %q", stats.MandocWarnings, *renderOneOutput)
+	return nil
+}
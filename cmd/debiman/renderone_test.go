@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestFileMeta(t *testing.T) {
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", "/srv/man")
+
+	for _, tt := range []struct {
+		path        string
+		servingPath string
+		wantErr     bool
+	}{
+		{path: "/srv/man/jessie/i3-wm/i3.1.en.gz", servingPath: "jessie/i3-wm/i3.1.en"},
+		{path: "/tmp/i3.1.en.gz", servingPath: "local/local/i3.1.en"},
+		{path: "/tmp/i3.1.gz", servingPath: "local/local/i3.1.en"},
+		{path: "/usr/share/man/man5/systemd.service.5.gz", servingPath: "local/local/systemd.service.5.en"},
+		{path: "/tmp/Pod::Usage.3perl.gz", servingPath: "local/local/Pod::Usage.3perl.en"},
+		{path: "/tmp/i3.de.1.gz", servingPath: "local/local/i3.de.1.en"},
+		{path: "/tmp/README.gz", wantErr: true},
+	} {
+		m, err := fileMeta(tt.path)
+		if got, want := err != nil, tt.wantErr; got != want {
+			t.Errorf("fileMeta(%q): got err %v, want error: %v", tt.path, err, want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got, want := m.ServingPath(), tt.servingPath; got != want {
+			t.Errorf("fileMeta(%q): got %q, want %q", tt.path, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	renderPDF = flag.Bool("render_pdf",
+		false,
+		"Additionally render a PDF version (<name>.<section>.<language>.pdf) of every manpage using mandoc -Tpdf, linked from the manpage for downloading")
+
+	pdfConcurrency = flag.Int("concurrency_pdf",
+		2,
+		"Concurrency level for rendering manpages to PDF using mandoc (-render_pdf). PDF rendering is considerably slower than HTML rendering, which continues while PDFs are being rendered.")
+)
+
+// pdfPath returns the path of the PDF version of the rendered manpage
+// dest.
+func pdfPath(dest string) string {
+	return strings.TrimSuffix(dest, htmlSuffix()) + ".pdf"
+}
+
+// pdfFailedPath returns the path of the file which records that
+// rendering the PDF version of the rendered manpage dest failed.
+func pdfFailedPath(dest string) string {
+	return pdfPath(dest) + ".failed"
+}
+
+// pdfStale returns true if the PDF version of dest should be written,
+// but is missing or older than modTime, unless rendering it already
+// failed for the manpage as of modTime.
+func pdfStale(dest string, modTime time.Time) bool {
+	if !*renderPDF {
+		return false
+	}
+	if st, err := os.Stat(pdfPath(dest)); err == nil && !st.ModTime().Before(modTime) {
+		return false
+	}
+	st, err := os.Stat(pdfFailedPath(dest))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+// writePDF renders the manpage src to PDF and writes it next to the
+// rendered manpage dest. PDFs are not compressed, as browsers display
+// .pdf links inline.
+func writePDF(src, dest string) error {
+	var pdf []byte
+	var pdfErr error
+	// An empty src results in an empty PDF document.
+	empty := true
+	if err := readSource(src, func(r io.Reader) error {
+		empty = false
+		pdf, pdfErr = mandoc.ToPDF(r)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if empty {
+		pdf, pdfErr = mandoc.ToPDF(&bytes.Buffer{})
+	}
+	if pdfErr != nil {
+		// Unlike for HTML, there is no error page to write. Record
+		// the failure instead, so that the PDF is only rendered again
+		// once the manpage changes.
+		warningf("rendering %q as PDF failed: %v", src, pdfErr)
+		return writeAtomically(pdfFailedPath(dest), false, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, pdfErr)
+			return err
+		})
+	}
+	recordArtifact(artifactManpage, pdfPath(dest))
+	if err := writeAtomically(pdfPath(dest), false, func(w io.Writer) error {
+		_, err := w.Write(pdf)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(pdfFailedPath(dest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
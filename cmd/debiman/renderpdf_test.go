@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPDFStale(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldRenderPDF := *renderPDF
+	defer func() { *renderPDF = oldRenderPDF }()
+
+	dest := filepath.Join(tmpdir, "i3.1.en.html.gz")
+	if got, want := pdfPath(dest), filepath.Join(tmpdir, "i3.1.en.pdf"); got != want {
+		t.Fatalf("pdfPath(%q): got %q, want %q", dest, got, want)
+	}
+
+	*renderPDF = false
+	if pdfStale(dest, time.Now()) {
+		t.Fatalf("missing PDF considered stale without -render_pdf")
+	}
+
+	*renderPDF = true
+	if !pdfStale(dest, time.Now()) {
+		t.Fatalf("missing PDF not considered stale")
+	}
+	if err := ioutil.WriteFile(pdfPath(dest), []byte("%PDF-1.1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pdfStale(dest, time.Now().Add(-1*time.Hour)) {
+		t.Fatalf("up to date PDF considered stale")
+	}
+	if !pdfStale(dest, time.Now().Add(1*time.Hour)) {
+		t.Fatalf("outdated PDF not considered stale")
+	}
+
+	// A failure recorded for the current manpage prevents rendering
+	// the PDF again, until the manpage changes.
+	if err := os.Remove(pdfPath(dest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pdfFailedPath(dest), []byte("timeout\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pdfStale(dest, time.Now().Add(-1*time.Hour)) {
+		t.Fatalf("PDF which failed to render considered stale")
+	}
+	if !pdfStale(dest, time.Now().Add(1*time.Hour)) {
+		t.Fatalf("PDF which failed to render for an older manpage not considered stale")
+	}
+}
@@ -1,57 +1,114 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"path/filepath"
 	"sort"
 
 	"github.com/Debian/debiman/internal/bundled"
 	"github.com/Debian/debiman/internal/manpage"
 )
 
+var stubEmptyIndexes = flag.Bool("stub_empty_indexes",
+	false,
+	"Render a stub package index for binary packages which do not ship any manpages (instead of not rendering an index, resulting in HTTP 404)")
+
 var pkgindexTmpl = mustParsePkgindexTmpl()
 
 func mustParsePkgindexTmpl() *template.Template {
 	return template.Must(template.Must(commonTmpls.Clone()).New("pkgindex").Parse(bundled.Asset("pkgindex.tmpl")))
 }
 
-func renderPkgindex(dest string, manpageByName map[string]*manpage.Meta) error {
-	var first *manpage.Meta
-	for _, m := range manpageByName {
-		first = m
-		break
+type pkgindexData struct {
+	Title          string
+	DebimanVersion string
+	Breadcrumbs    breadcrumbs
+	FooterExtra    string
+	Suite          string
+	Binarypkg      string
+	First          *manpage.Meta
+	Meta           *manpage.Meta
+	Manpages       <-chan *manpage.Meta
+	Mans           []string
+	HrefLangs      []*manpage.Meta
+	EPUB           string
+}
+
+func newPkgindexData(suite, binarypkg string) pkgindexData {
+	return pkgindexData{
+		Title:          fmt.Sprintf("Manpages of %s in Debian %s", binarypkg, suite),
+		DebimanVersion: debimanVersion,
+		Breadcrumbs: breadcrumbs{
+			{fmt.Sprintf("/contents-%s.html", suite), suite},
+			{fmt.Sprintf("/%s/%s/index.html", suite, binarypkg), binarypkg},
+			{"", "Contents"},
+		},
+		Suite:     suite,
+		Binarypkg: binarypkg,
 	}
+}
+
+// streamManpages sends the manpage.Meta for each of names (file names
+// within dir, in order) to the returned channel, constructing them one
+// at a time instead of keeping all of them in memory. The channel is
+// closed once all names were sent or done is closed.
+func streamManpages(dir string, names []string, done <-chan struct{}) <-chan *manpage.Meta {
+	mans := make(chan *manpage.Meta)
+	go func() {
+		defer close(mans)
+		for _, fn := range names {
+			full := filepath.Join(dir, fn)
+			m, err := manpage.FromServingPath(*servingDir, full)
+			if err != nil {
+				errorf("BUG: cannot parse manpage from serving path %q: %v", full, err)
+				continue
+			}
+			select {
+			case mans <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return mans
+}
 
-	mans := make([]string, 0, len(manpageByName))
-	for n := range manpageByName {
-		mans = append(mans, n)
+// renderPkgindex renders the package index for the manpages in dir,
+// whose file names are passed in names. Entries are written while the
+// template is executing, so memory usage does not grow with the number
+// of manpages in the package.
+func renderPkgindex(dest, dir string, names []string) error {
+	sort.Strings(names)
+
+	first, err := manpage.FromServingPath(*servingDir, filepath.Join(dir, names[0]))
+	if err != nil {
+		return err
 	}
-	sort.Strings(mans)
-
-	return writeAtomically(dest, true, func(w io.Writer) error {
-		return pkgindexTmpl.Execute(w, struct {
-			Title          string
-			DebimanVersion string
-			Breadcrumbs    breadcrumbs
-			FooterExtra    string
-			First          *manpage.Meta
-			Meta           *manpage.Meta
-			ManpageByName  map[string]*manpage.Meta
-			Mans           []string
-			HrefLangs      []*manpage.Meta
-		}{
-			Title:          fmt.Sprintf("Manpages of %s in Debian %s", first.Package.Binarypkg, first.Package.Suite),
-			DebimanVersion: debimanVersion,
-			Breadcrumbs: breadcrumbs{
-				{fmt.Sprintf("/contents-%s.html", first.Package.Suite), first.Package.Suite},
-				{fmt.Sprintf("/%s/%s/index.html", first.Package.Suite, first.Package.Binarypkg), first.Package.Binarypkg},
-				{"", "Contents"},
-			},
-			First:         first,
-			Meta:          first,
-			ManpageByName: manpageByName,
-			Mans:          mans,
-		})
+
+	recordArtifact(artifactIndex, dest)
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		done := make(chan struct{})
+		defer close(done)
+		data := newPkgindexData(first.Package.Suite, first.Package.Binarypkg)
+		data.First = first
+		data.Meta = first
+		data.Manpages = streamManpages(dir, names, done)
+		data.Mans = names
+		if *renderEPUB {
+			data.EPUB = fmt.Sprintf("/%s/%s/%s.epub", first.Package.Suite, first.Package.Binarypkg, first.Package.Binarypkg)
+		}
+		return pkgindexTmpl.Execute(w, data)
+	})
+}
+
+// renderEmptyPkgindex renders a stub package index for binarypkg,
+// which does not ship any manpages.
+func renderEmptyPkgindex(dest, suite, binarypkg string) error {
+	recordArtifact(artifactIndex, dest)
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		return pkgindexTmpl.Execute(w, newPkgindexData(suite, binarypkg))
 	})
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+// TestRenderPkgindexStreaming verifies that streaming the entries of a
+// package index yields the same output as materializing all of them
+// before executing the template (as debiman used to).
+func TestRenderPkgindexStreaming(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = true
+	defer func() { *noCompress = oldNoCompress }()
+
+	parseTemplates()
+
+	const streamed = "{{ range $m := .Manpages }}\n  {{ with $m }}"
+	const batch = "{{ range $idx, $fn := .Mans }}\n  {{ with $m := index $.ManpageByName $fn }}"
+	content := bundled.Asset("pkgindex.tmpl")
+	if !strings.Contains(content, streamed) {
+		t.Fatalf("pkgindex.tmpl does not contain %q", streamed)
+	}
+	batchTmpl := template.Must(template.Must(commonTmpls.Clone()).New("pkgindex").Parse(strings.Replace(content, streamed, batch, 1)))
+
+	dir := filepath.Join(tmpdir, "testing", "i3-wm")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{
+		"i3.1.en.gz",
+		"i3-msg.1.en.gz",
+		"i3.1.de.gz",
+		"i3-dump-log.1.en.gz",
+		"i3.5.fr.gz",
+	}
+	dest := filepath.Join(dir, "index.html")
+	if err := renderPkgindex(dest, dir, append([]string(nil), names...)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manpageByName := make(map[string]*manpage.Meta)
+	for _, fn := range names {
+		m, err := manpage.FromServingPath(tmpdir, filepath.Join(dir, fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manpageByName[fn] = m
+	}
+	data := newPkgindexData("testing", "i3-wm")
+	data.First = manpageByName["i3-dump-log.1.en.gz"]
+	data.Meta = data.First
+	data.Mans = append([]string(nil), names...)
+	sort.Strings(data.Mans)
+	var want bytes.Buffer
+	if err := batchTmpl.Execute(&want, struct {
+		pkgindexData
+		ManpageByName map[string]*manpage.Meta
+	}{data, manpageByName}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("streamed package index differs from batch rendering:\ngot:\n%s\nwant:\n%s", got, want.Bytes())
+	}
+	if !bytes.Contains(got, []byte(`href="/testing/i3-wm/i3.5.fr.html"`)) {
+		t.Fatalf("package index does not link to i3(5): %s", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var redirectMap = flag.String("redirect_map",
+	"",
+	"If non-empty, path to a file containing lines of the form “<old serving path> <new serving path or URL>”. For each line, a redirect page is placed at the old serving path, e.g. for manpages which were renamed or removed.")
+
+const redirectTmplContent = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta http-equiv="refresh" content="0; url={{ .Target }}">
+<link rel="canonical" href="{{ .Target }}">
+<title>Moved — debiman</title>
+</head>
+<body>
+<p>This manpage has moved to <a href="{{ .Target }}">{{ .Target }}</a>.</p>
+</body>
+</html>
+`
+
+var redirectTmpl = template.Must(template.New("redirect").Parse(redirectTmplContent))
+
+type redirectEntry struct {
+	// old is the serving path (e.g. jessie/i3-wm/i3.1.en) at which a
+	// redirect page should be placed.
+	old string
+
+	// target is either a serving path or an absolute URL.
+	target string
+}
+
+// external returns true if the redirect target is an absolute URL
+// instead of a serving path within -serving_dir.
+func (e redirectEntry) external() bool {
+	u, err := url.Parse(e.target)
+	return err == nil && u.IsAbs()
+}
+
+// href returns the URL to which the redirect page should point.
+func (e redirectEntry) href() string {
+	if e.external() {
+		return e.target
+	}
+	return "/" + e.target + ".html"
+}
+
+func trimServingPath(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	path = strings.TrimSuffix(path, ".gz")
+	return strings.TrimSuffix(path, ".html")
+}
+
+// parseRedirectMap parses a redirect map. Empty lines and lines
+// starting with # are ignored.
+func parseRedirectMap(r io.Reader) ([]redirectEntry, error) {
+	var entries []redirectEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields, got %d", line, len(fields))
+		}
+		e := redirectEntry{
+			old:    trimServingPath(fields[0]),
+			target: fields[1],
+		}
+		if !e.external() {
+			e.target = trimServingPath(e.target)
+		}
+		if strings.Contains(e.old, "..") || strings.Contains(e.target, "..") {
+			return nil, fmt.Errorf("line %d: paths must not contain ..", line)
+		}
+		if strings.Count(e.old, "/") != 2 {
+			return nil, fmt.Errorf("line %d: %q is not a serving path (<suite>/<binarypkg>/<name>.<section>.<lang>)", line, e.old)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// redirectsMarker is the name of the file which marks a binary package
+// directory as containing -redirect_map pages, which the gc command
+// must not remove even if the directory does not belong to a package.
+const redirectsMarker = ".redirects"
+
+// hasRedirects returns whether the binary package directory dir
+// contains -redirect_map pages.
+func hasRedirects(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, redirectsMarker))
+	return err == nil
+}
+
+// renderRedirects places a redirect page at the old serving path of
+// each entry in the -redirect_map file. Entries which conflict with a
+// manpage or whose target does not exist are reported and skipped.
+func renderRedirects(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := parseRedirectMap(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	var rendered int
+	for _, e := range entries {
+		if _, err := os.Lstat(filepath.Join(*servingDir, e.old+".gz")); err == nil {
+			warningf("redirect map: %q conflicts with an existing manpage, skipping", e.old)
+			continue
+		}
+		if !e.external() {
+			if _, err := os.Stat(filepath.Join(*servingDir, e.target+htmlSuffix())); err != nil {
+				warningf("redirect map: target %q of %q does not exist, skipping", e.target, e.old)
+				continue
+			}
+		}
+
+		dest := filepath.Join(*servingDir, e.old+htmlSuffix())
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if !hasRedirects(filepath.Dir(dest)) {
+			if err := ioutil.WriteFile(filepath.Join(filepath.Dir(dest), redirectsMarker), nil, 0644); err != nil {
+				return err
+			}
+		}
+		if err := writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+			return redirectTmpl.Execute(w, struct {
+				Target string
+			}{
+				Target: e.href(),
+			})
+		}); err != nil {
+			return err
+		}
+		rendered++
+	}
+	infof("Rendered %d of %d redirects from %q", rendered, len(entries), path)
+	return nil
+}
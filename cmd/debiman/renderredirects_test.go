@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRedirectMap(t *testing.T) {
+	const input = `
+# renamed in stretch
+jessie/foo/foo.1.en stretch/foo-ng/foo.1.en.html
+/jessie/bar/bar.8.en.html https://example.org/bar.html
+`
+	entries, err := parseRedirectMap(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("unexpected number of entries: got %d, want %d", got, want)
+	}
+	for idx, want := range []struct {
+		old      string
+		href     string
+		external bool
+	}{
+		{"jessie/foo/foo.1.en", "/stretch/foo-ng/foo.1.en.html", false},
+		{"jessie/bar/bar.8.en", "https://example.org/bar.html", true},
+	} {
+		e := entries[idx]
+		if e.old != want.old {
+			t.Errorf("entry %d: unexpected old path: got %q, want %q", idx, e.old, want.old)
+		}
+		if got := e.href(); got != want.href {
+			t.Errorf("entry %d: unexpected href: got %q, want %q", idx, got, want.href)
+		}
+		if got := e.external(); got != want.external {
+			t.Errorf("entry %d: unexpected external: got %v, want %v", idx, got, want.external)
+		}
+	}
+
+	for _, invalid := range []string{
+		"jessie/foo/foo.1.en",
+		"foo.1.en stretch/foo/foo.1.en",
+		"jessie/foo/../../etc.1.en stretch/foo/foo.1.en",
+	} {
+		if _, err := parseRedirectMap(strings.NewReader(invalid)); err == nil {
+			t.Errorf("parseRedirectMap(%q) unexpectedly succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var renderRoff = flag.Bool("render_roff",
+	false,
+	"Additionally write the roff source (<name>.<section>.<language>.roff.gz) of every manpage with all .so requests inlined, so that it can be downloaded and viewed using man -l. The raw man page link points to this file instead of the extracted manpage, whose .so requests refer to -serving_dir.")
+
+// maxSoDepth limits the nesting of .so requests in inlineSo, which
+// might otherwise recurse endlessly for manpages including themselves.
+const maxSoDepth = 8
+
+// maxRoffLineLength is the length of the longest line which inlineSo
+// handles. Lines of generated manpages can exceed bufio.Scanner’s
+// default of 64 KB, e.g. for embedded tables or data.
+const maxRoffLineLength = 16 * 1024 * 1024
+
+// roffPath returns the path of the roff source of the rendered
+// manpage dest. The roff source is compressed even with -no_compress,
+// so that its URL does not depend on flags (man -l decompresses it).
+func roffPath(dest string) string {
+	return strings.TrimSuffix(dest, htmlSuffix()) + ".roff.gz"
+}
+
+// roffFailedPath returns the path of the file which records that
+// writing the roff source of dest failed.
+func roffFailedPath(dest string) string {
+	return roffPath(dest) + ".failed"
+}
+
+// roffStale returns true if the roff source of dest should be written,
+// but is missing or older than modTime, unless writing it failed for
+// the current version of the manpage.
+func roffStale(dest string, modTime time.Time) bool {
+	if !*renderRoff {
+		return false
+	}
+	if st, err := os.Stat(roffPath(dest)); err == nil && !st.ModTime().Before(modTime) {
+		return false
+	}
+	st, err := os.Stat(roffFailedPath(dest))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+// openMaybeGzipped opens the file path, which is decompressed if it is
+// gzip-compressed. Files referenced using .so are extracted into
+// -serving_dir as found in the package.
+func openMaybeGzipped(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	bufr := bufio.NewReader(f)
+	if magic, err := bufr.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return struct {
+			io.Reader
+			io.Closer
+		}{bufr, f}, nil
+	}
+	gzipr, err := gzip.NewReader(bufr)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gzipr, f}, nil
+}
+
+// soPath returns the path of the file which the .so request so of a
+// manpage in suiteDir refers to. The file must be located within a
+// binary package directory of suiteDir: soElim rewrote the .so requests
+// of manpages accordingly, but files in aux/ are inlined as found in
+// the package and might refer to e.g. ../../../etc/shadow.
+func soPath(suiteDir, so string) (string, error) {
+	path, err := containedPath(*servingDir, so)
+	if err != nil {
+		return "", err
+	}
+	// Symlinks (e.g. of manpages in other languages) must not lead
+	// outside either.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(suiteDir); err == nil {
+		suiteDir = resolved
+	}
+	rel, err := filepath.Rel(suiteDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+		!strings.Contains(rel, string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is not within a package directory of %q", so, suiteDir)
+	}
+	return path, nil
+}
+
+// inlineSo copies the roff source r of a manpage in suiteDir to w,
+// replacing .so requests with the contents of the file they refer to.
+// soElim rewrote all .so requests to refer to files within -serving_dir.
+func inlineSo(w io.Writer, r io.Reader, suiteDir string, depth int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRoffLineLength)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, ".so ") || depth >= maxSoDepth {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		so := strings.TrimSpace(line[len(".so "):])
+		path, err := soPath(suiteDir, so)
+		if err != nil {
+			warningf("not inlining .so referenced file: %v", err)
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := openMaybeGzipped(path)
+		if err != nil {
+			warningf("could not inline .so referenced file %q: %v", so, err)
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		err = inlineSo(w, f, suiteDir, depth+1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// writeRoff writes the roff source of the manpage src (with all .so
+// requests inlined) next to the rendered manpage dest.
+func writeRoff(src, dest string) error {
+	suiteDir := filepath.Dir(filepath.Dir(dest))
+	recordArtifact(artifactManpage, roffPath(dest))
+	err := writeAtomically(roffPath(dest), true, func(w io.Writer) error {
+		// An empty src results in an empty roff source.
+		return readSource(src, func(r io.Reader) error {
+			return inlineSo(w, r, suiteDir, 0)
+		})
+	})
+	if err == bufio.ErrTooLong {
+		// Record the failure, so that the roff source is only written
+		// again once the manpage changes.
+		warningf("not writing the roff source of %q: a line is longer than %d bytes", src, maxRoffLineLength)
+		return writeAtomically(roffFailedPath(dest), false, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, bufio.ErrTooLong)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(roffFailedPath(dest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeGzipped(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gzipw := gzip.NewWriter(&buf)
+	if _, err := gzipw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInlineSo(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-roff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+
+	writeGzipped(t, filepath.Join(tmpdir, "jessie/foo/aux/usr/share/man/man7/common.7.gz"),
+		".SH AUTHOR\nFoo Bar\n")
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "jessie/foo/aux/usr/share/man/man7/plain.7"),
+		[]byte(".so jessie/foo/aux/usr/share/man/man7/common.7.gz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeGzipped(t, filepath.Join(tmpdir, "jessie/foo/self.1.en.gz"),
+		".so jessie/foo/self.1.en.gz\n")
+	// Files outside of the package directories of the suite, which must
+	// not be inlined.
+	writeGzipped(t, filepath.Join(filepath.Dir(tmpdir), filepath.Base(tmpdir)+"-secret.gz"), "secret\n")
+	writeGzipped(t, filepath.Join(tmpdir, "stretch/foo/other.1.en.gz"), "other suite\n")
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "jessie/foo/aux/usr/share/man/man7/evil.7"),
+		[]byte(".so ../"+filepath.Base(tmpdir)+"-secret.gz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(filepath.Dir(tmpdir), filepath.Base(tmpdir)+"-secret.gz"),
+		filepath.Join(tmpdir, "jessie/foo/link.1.en.gz")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filepath.Join(filepath.Dir(tmpdir), filepath.Base(tmpdir)+"-secret.gz"))
+
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   ".TH FOO 1\n.so jessie/foo/aux/usr/share/man/man7/common.7.gz\n.SH SEE ALSO\n",
+			want: ".TH FOO 1\n.SH AUTHOR\nFoo Bar\n.SH SEE ALSO\n",
+		},
+
+		{
+			in:   ".so jessie/foo/aux/usr/share/man/man7/plain.7\n",
+			want: ".SH AUTHOR\nFoo Bar\n",
+		},
+
+		{
+			in:   ".so jessie/foo/missing.1.en.gz\n",
+			want: ".so jessie/foo/missing.1.en.gz\n",
+		},
+
+		{
+			in:   ".so jessie/foo/self.1.en.gz\n",
+			want: ".so jessie/foo/self.1.en.gz\n",
+		},
+
+		{
+			in:   ".so jessie/foo/aux/usr/share/man/man7/evil.7\n",
+			want: ".so ../" + filepath.Base(tmpdir) + "-secret.gz\n",
+		},
+
+		{
+			in:   ".so jessie/../../" + filepath.Base(tmpdir) + "-secret.gz\n",
+			want: ".so jessie/../../" + filepath.Base(tmpdir) + "-secret.gz\n",
+		},
+
+		{
+			in:   ".so jessie/foo/link.1.en.gz\n",
+			want: ".so jessie/foo/link.1.en.gz\n",
+		},
+
+		{
+			in:   ".so stretch/foo/other.1.en.gz\n",
+			want: ".so stretch/foo/other.1.en.gz\n",
+		},
+
+		{
+			// Lines longer than bufio.Scanner’s default limit.
+			in:   strings.Repeat("x", 100*1024) + "\n",
+			want: strings.Repeat("x", 100*1024) + "\n",
+		},
+	} {
+		var buf bytes.Buffer
+		if err := inlineSo(&buf, strings.NewReader(tt.in), filepath.Join(tmpdir, "jessie"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("inlineSo(%.100q): got %.100q, want %.100q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRoffTooLong(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-roff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldServingDir := *servingDir
+	*servingDir = tmpdir
+	defer func() { *servingDir = oldServingDir }()
+	oldNoCompress := *noCompress
+	*noCompress = false
+	defer func() { *noCompress = oldNoCompress }()
+	oldRenderRoff := *renderRoff
+	*renderRoff = true
+	defer func() { *renderRoff = oldRenderRoff }()
+
+	src := filepath.Join(tmpdir, "jessie/foo/foo.1.en.gz")
+	dest := filepath.Join(tmpdir, "jessie/foo/foo.1.en.html.gz")
+	writeGzipped(t, src, ".TH FOO 1\n"+strings.Repeat("a", maxRoffLineLength+1)+"\n")
+	st, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRoff(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(roffPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("roff source unexpectedly written: %v", err)
+	}
+	if roffStale(dest, st.ModTime()) {
+		t.Errorf("roffStale = true after a recorded failure, want false")
+	}
+
+	// Once the manpage changes, the roff source is written again.
+	writeGzipped(t, src, ".TH FOO 1\n")
+	if err := writeRoff(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(roffPath(dest)); err != nil {
+		t.Errorf("roff source not written: %v", err)
+	}
+	if _, err := os.Stat(roffFailedPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("failure marker not removed: %v", err)
+	}
+}
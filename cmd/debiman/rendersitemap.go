@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Debian/debiman/internal/sitemap"
+)
+
+// writeSuiteSitemap writes the sitemap of suite, listing the package
+// index pages of entries (binary package name to last modification
+// time). It returns the modification time of the written sitemap, or
+// the zero time if it cannot be determined.
+func writeSuiteSitemap(suite string, entries map[string]time.Time) (time.Time, error) {
+	sitemapPath := filepath.Join(*servingDir, suite, "sitemap.xml.gz")
+	recordArtifact(artifactSitemap, sitemapPath)
+	if err := writeAtomicallyLarge(sitemapPath, func(w io.Writer) error {
+		return sitemap.WriteTo(w, *baseURL+"/"+suite, entries)
+	}, sitemap.Validate); err != nil {
+		return time.Time{}, err
+	}
+	st, err := os.Stat(sitemapPath)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return st.ModTime(), nil
+}
+
+// writeSitemapIndexes writes the sitemap index referencing the sitemaps
+// of all suites (suite name to modification time of its sitemap), and
+// one sitemap index per federated distribution.
+func writeSitemapIndexes(gv globalView, sitemaps map[string]time.Time) error {
+	// Each federated distribution additionally gets a sitemap index of
+	// its own suites, so that it can be submitted separately.
+	byPrefix := make(map[string]map[string]time.Time)
+	for suite, modTime := range sitemaps {
+		prefix, ok := gv.prefixes[suite]
+		if !ok {
+			continue
+		}
+		if byPrefix[prefix] == nil {
+			byPrefix[prefix] = make(map[string]time.Time)
+		}
+		byPrefix[prefix][suite] = modTime
+	}
+	for prefix, sitemaps := range byPrefix {
+		sitemaps := sitemaps // copy
+		path := filepath.Join(*servingDir, "sitemapindex-"+prefix+".xml.gz")
+		recordArtifact(artifactSitemap, path)
+		if err := writeAtomicallyVerified(path, true, func(w io.Writer) error {
+			return sitemap.WriteIndexTo(w, *baseURL, sitemaps)
+		}, gunzipped(sitemap.Validate)); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(*servingDir, "sitemapindex.xml.gz")
+	recordArtifact(artifactSitemap, path)
+	return writeAtomicallyVerified(path, true, func(w io.Writer) error {
+		return sitemap.WriteIndexTo(w, *baseURL, sitemaps)
+	}, gunzipped(sitemap.Validate))
+}
+
+// newestManpage returns the newest modification time of the manpages
+// (not their rendered versions) in the binary package directory dir,
+// which is what walkContents lists in the sitemap.
+func newestManpage(dir string) (time.Time, error) {
+	var newest time.Time
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return newest, err
+	}
+	for _, fi := range fis {
+		fn := fi.Name()
+		if !strings.HasSuffix(fn, ".gz") || isRenderedOutput(fn) {
+			continue
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// renderSitemaps re-writes all sitemaps based on the manpages present
+// in the serving directory, without rendering any manpages.
+func renderSitemaps(gv globalView) error {
+	sitemaps := make(map[string]time.Time)
+	suitedirs, err := ioutil.ReadDir(*servingDir)
+	if err != nil {
+		return err
+	}
+	for _, sfi := range suitedirs {
+		if !sfi.IsDir() || !gv.suites[sfi.Name()] {
+			continue
+		}
+		bins, err := ioutil.ReadDir(filepath.Join(*servingDir, sfi.Name()))
+		if err != nil {
+			return err
+		}
+		entries := make(map[string]time.Time, len(bins))
+		for _, bfi := range bins {
+			if !bfi.IsDir() {
+				continue
+			}
+			newest, err := newestManpage(filepath.Join(*servingDir, sfi.Name(), bfi.Name()))
+			if err != nil {
+				return err
+			}
+			if !newest.IsZero() {
+				entries[bfi.Name()] = newest
+			}
+		}
+		infof("Writing sitemap of suite %q (%d packages)", sfi.Name(), len(entries))
+		modTime, err := writeSuiteSitemap(sfi.Name(), entries)
+		if err != nil {
+			return err
+		}
+		if !modTime.IsZero() {
+			sitemaps[sfi.Name()] = modTime
+		}
+	}
+	return writeSitemapIndexes(gv, sitemaps)
+}
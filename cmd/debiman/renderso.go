@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var soRedirects = flag.Bool("so_redirects",
+	false,
+	"Place redirect pages at manpages which consist of nothing but a .so request to a manpage of a different binary package (e.g. compatibility names), instead of rendering the included manpage")
+
+// soTarget returns the serving path (e.g. jessie/bash/bash.1.en.gz) of
+// the manpage which the manpage src includes if src consists of
+// nothing but comments and a single .so request, or an empty string.
+// soElim rewrote .so requests to be relative to -serving_dir when
+// extracting src.
+func soTarget(src string) (string, error) {
+	var target string
+	err := readSource(src, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" ||
+				line == "." ||
+				strings.HasPrefix(line, `.\"`) ||
+				strings.HasPrefix(line, `'\"`) {
+				continue
+			}
+			if !strings.HasPrefix(line, ".so ") || target != "" {
+				target = ""
+				return nil
+			}
+			target = strings.TrimSpace(line[len(".so "):])
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return "", err
+	}
+	// Files in aux/ (e.g. shared fragments) are not manpages.
+	if strings.Count(target, "/") != 2 || !strings.HasSuffix(target, ".gz") {
+		return "", nil
+	}
+	return target, nil
+}
+
+// soReuse returns the rendered HTML of target (see soTarget), if it is
+// newer than target itself, so that it can be re-used instead of
+// converting the including manpage.
+func soReuse(target string) string {
+	src := filepath.Join(*servingDir, target)
+	fn := strings.TrimSuffix(src, ".gz") + htmlSuffix()
+	st, err := os.Stat(src)
+	if err != nil {
+		return ""
+	}
+	htmlst, err := os.Stat(fn)
+	if err != nil || htmlst.ModTime().Before(st.ModTime()) {
+		return ""
+	}
+	return fn
+}
+
+// readRendered returns the contents of the rendered file dest, which is
+// gzip-compressed unless -no_compress is set.
+func readRendered(dest string) ([]byte, error) {
+	if *noCompress {
+		return ioutil.ReadFile(dest)
+	}
+	var b []byte
+	err := readSource(dest, func(r io.Reader) error {
+		var err error
+		b, err = ioutil.ReadAll(r)
+		return err
+	})
+	return b, err
+}
+
+// writeSoRedirect places a redirect page to the manpage target (see
+// soTarget) at dest. If dest already contains it, only its
+// modification time is updated, so that dest stays newer than the
+// manpage source it was rendered from.
+func writeSoRedirect(dest, target string) error {
+	e := redirectEntry{target: trimServingPath(target)}
+	var buf bytes.Buffer
+	if err := redirectTmpl.Execute(&buf, struct {
+		Target string
+	}{
+		Target: e.href(),
+	}); err != nil {
+		return err
+	}
+	if old, err := readRendered(dest); err == nil && bytes.Equal(old, buf.Bytes()) {
+		now := time.Now()
+		return os.Chtimes(dest, now, now)
+	}
+	return writeAtomically(dest, !*noCompress, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
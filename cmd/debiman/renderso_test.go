@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSoTarget(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, tt := range []struct {
+		content string
+		want    string
+	}{
+		{".so jessie/bash/bash.1.en.gz\n", "jessie/bash/bash.1.en.gz"},
+		{".\\\" compatibility name\n\n.so jessie/bash/bash.1.en.gz\n", "jessie/bash/bash.1.en.gz"},
+		{".so jessie/bash/aux/usr/share/man/man1/common.inc.gz\n", ""},
+		{".so jessie/bash/bash.1.en.gz\n.so jessie/bash/sh.1.en.gz\n", ""},
+		{".TH FOO 1\n.so jessie/bash/bash.1.en.gz\n", ""},
+		{".TH FOO 1\n", ""},
+	} {
+		src := filepath.Join(tmpdir, "foo.1.en.gz")
+		writeGzipped(t, src, tt.content)
+		got, err := soTarget(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("soTarget(%q): got %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSoRedirect(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dest := filepath.Join(tmpdir, "sh.1.en.html.gz")
+	if err := writeSoRedirect(dest, "jessie/bash/bash.1.en.gz"); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dest, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged redirect page must not be re-written, but touched so
+	// that it is not considered stale.
+	if err := writeSoRedirect(dest, "jessie/bash/bash.1.en.gz"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, st) {
+		t.Fatalf("unchanged redirect page re-written")
+	}
+	if !st.ModTime().After(past) {
+		t.Fatalf("unchanged redirect page not touched: modification time %v", st.ModTime())
+	}
+	if err := os.Chtimes(dest, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeSoRedirect(dest, "jessie/dash/dash.1.en.gz"); err != nil {
+		t.Fatal(err)
+	}
+	st, err = os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ModTime().Equal(past) {
+		t.Fatalf("redirect page with a new target not re-written")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var renderText = flag.Bool("render_text",
+	false,
+	"Additionally render a plain text version (<name>.<section>.<language>.txt.gz) of every manpage using mandoc -Tutf8, e.g. for use with curl")
+
+// textPath returns the path of the plain text version of the rendered
+// manpage dest.
+func textPath(dest string) string {
+	path := strings.TrimSuffix(dest, htmlSuffix()) + ".txt"
+	if !*noCompress {
+		path += ".gz"
+	}
+	return path
+}
+
+// textStale returns true if the plain text version of dest should be
+// written, but is missing or older than modTime.
+func textStale(dest string, modTime time.Time) bool {
+	if !*renderText {
+		return false
+	}
+	st, err := os.Stat(textPath(dest))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+// writeText renders the manpage src to plain text and writes it next
+// to the rendered manpage dest.
+func writeText(src, dest string) error {
+	var text string
+	// An empty src results in an empty plain text version.
+	if err := readSource(src, func(r io.Reader) error {
+		var err error
+		if text, err = mandoc.ToText(r); err != nil {
+			// Like an error page for the HTML version, so that the
+			// manpage is not re-rendered over and over.
+			warningf("rendering %q as plain text failed: %v", src, err)
+			text = fmt.Sprintf("This manpage could not be rendered as plain text: %v\n", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	recordArtifact(artifactManpage, textPath(dest))
+	return writeAtomically(textPath(dest), !*noCompress, func(w io.Writer) error {
+		_, err := io.WriteString(w, text)
+		return err
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestTextPath(t *testing.T) {
+	oldNoCompress := *noCompress
+	defer func() { *noCompress = oldNoCompress }()
+
+	for _, tt := range []struct {
+		noCompress bool
+		dest       string
+		want       string
+	}{
+		{false, "testing/i3-wm/i3.1.en.html.gz", "testing/i3-wm/i3.1.en.txt.gz"},
+		{true, "testing/i3-wm/i3.1.en.html", "testing/i3-wm/i3.1.en.txt"},
+	} {
+		*noCompress = tt.noCompress
+		if got := textPath(tt.dest); got != tt.want {
+			t.Errorf("textPath(%q) (-no_compress=%v): got %q, want %q", tt.dest, tt.noCompress, got, tt.want)
+		}
+	}
+}
@@ -4,25 +4,36 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"io"
 	"os"
+	"strings"
+
+	"github.com/Debian/debiman/internal/convert"
 )
 
 var mandocDivB = []byte(`<div class="mandoc">`)
-var tocLinkPrefix = []byte(`  <a class="toclink"`)
 var footerB = []byte(`<div id="footer">`)
 
-func reuse(src string) (doc string, toc []string, err error) {
+// reuse returns the manpage content of the previously rendered page src
+// and its table of contents. The table of contents is derived from the
+// content, not taken from the page, as the latter is limited to
+// -toc_depth at the time src was rendered.
+func reuse(src string) (doc string, toc []convert.TOCEntry, err error) {
 	f, err := os.Open(src)
 	if err != nil {
 		return "", nil, err
 	}
 	defer f.Close()
 
-	r, err := gzip.NewReader(f)
-	if err != nil {
-		return "", nil, err
+	r := io.Reader(f)
+	if strings.HasSuffix(src, ".gz") {
+		gzipr, err := gzip.NewReader(f)
+		if err != nil {
+			return "", nil, err
+		}
+		defer gzipr.Close()
+		r = gzipr
 	}
-	defer r.Close()
 
 	var (
 		buf       bytes.Buffer
@@ -37,7 +48,9 @@ func reuse(src string) (doc string, toc []string, err error) {
 		if bytes.Equal(b, footerB) {
 			all := buf.Bytes()
 			all = bytes.TrimSuffix(all, []byte("</div>\n</div>\n"))
-			return string(bytes.TrimSpace(all)), toc, nil
+			doc = string(bytes.TrimSpace(all))
+			toc, err = convert.TOC(doc)
+			return doc, toc, err
 		}
 
 		if inManpage {
@@ -47,12 +60,6 @@ func reuse(src string) (doc string, toc []string, err error) {
 			if _, err := buf.Write([]byte{'\n'}); err != nil {
 				return "", nil, err
 			}
-		} else if bytes.HasPrefix(b, tocLinkPrefix) {
-			entry := bytes.TrimSuffix(b, []byte("</a>"))
-			off := bytes.Index(entry, []byte{'>'})
-			if off > -1 {
-				toc = append(toc, string(entry[off+1:]))
-			}
 		}
 	}
 	return buf.String(), nil, scanner.Err()
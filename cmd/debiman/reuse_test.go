@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -43,7 +44,7 @@ baz
 		},
 	}
 
-	converter, err := convert.NewProcess()
+	converter, err := convert.NewProcess(convert.Mandoc{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -54,7 +55,7 @@ baz
 		t.Fatal(err)
 	}
 
-	if _, err := rendermanpage(gzipw, converter, renderJob{
+	if _, _, err := rendermanpage(gzipw, converter, renderJob{
 		dest:     f.Name(),
 		src:      f.Name(),
 		meta:     meta,
@@ -87,9 +88,10 @@ baz
 	if got, want := len(tocGot), len(tocWant); got != want {
 		t.Fatalf("Unexpected table of contents length: got %d, want %d", got, want)
 	}
+	// The table of contents is not limited to -toc_depth.
 	for n := 0; n < len(tocGot); n++ {
-		if got, want := tocGot[n], tocWant[n]; got != want {
-			t.Fatalf("Unexpected table of contents element %d: got %q, want %q", n, got, want)
+		if got, want := tocGot[n], tocWant[n]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Unexpected table of contents element %d: got %+v, want %+v", n, got, want)
 		}
 	}
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Debian/debiman/internal/archive"
+	"github.com/Debian/debiman/internal/manpage"
+	"github.com/Debian/debiman/internal/vercmp"
+	"pault.ag/go/debian/version"
+)
+
+// defaultRPMArchitecture is used for RPM repositories whose
+// architectures are not specified.
+const defaultRPMArchitecture = "x86_64"
+
+// rpmRepomd is the repository index (repodata/repomd.xml) of an RPM
+// repository.
+type rpmRepomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Checksum struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"checksum"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+// rpmMetadataFile is a metadata file referenced by repomd.xml, e.g.
+// repodata/<sha256>-primary.xml.zst.
+type rpmMetadataFile struct {
+	href   string
+	sha256 []byte
+}
+
+// parseRPMRepomd returns the metadata files of the specified types
+// (e.g. primary and filelists) from the repomd.xml file r.
+func parseRPMRepomd(r io.Reader, types ...string) (map[string]rpmMetadataFile, error) {
+	var repomd rpmRepomd
+	if err := xml.NewDecoder(r).Decode(&repomd); err != nil {
+		return nil, err
+	}
+	result := make(map[string]rpmMetadataFile, len(types))
+	for _, data := range repomd.Data {
+		if data.Checksum.Type != "sha256" {
+			continue
+		}
+		sum, err := hex.DecodeString(strings.TrimSpace(data.Checksum.Value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", data.Location.Href, err)
+		}
+		result[data.Type] = rpmMetadataFile{
+			href:   data.Location.Href,
+			sha256: sum,
+		}
+	}
+	for _, t := range types {
+		if _, ok := result[t]; !ok {
+			return nil, fmt.Errorf("no %s metadata with a sha256 checksum found", t)
+		}
+	}
+	return result, nil
+}
+
+// rpmEVR is the epoch, version and release of an RPM package.
+type rpmEVR struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+// rpmCompareEVR compares a and b like RPM (rpmvercmp(8)) and returns
+// -1, 0 or 1 if a is older than, the same as or newer than b.
+func rpmCompareEVR(a, b rpmEVR) int {
+	epochA, _ := strconv.ParseUint(a.Epoch, 10, 32)
+	epochB, _ := strconv.ParseUint(b.Epoch, 10, 32)
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+	if ret := vercmp.RPM(a.Ver, b.Ver); ret != 0 {
+		return ret
+	}
+	return vercmp.RPM(a.Rel, b.Rel)
+}
+
+// rpmPackage is a package entry of an RPM repository’s primary.xml.
+type rpmPackage struct {
+	Name     string `xml:"name"`
+	Arch     string `xml:"arch"`
+	Version  rpmEVR `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Size struct {
+		Package int64 `xml:"package,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		SourceRPM string `xml:"sourcerpm"`
+		Obsoletes []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"obsoletes>entry"`
+	} `xml:"format"`
+}
+
+// rpmFilelist is a package entry of an RPM repository’s filelists.xml.
+type rpmFilelist struct {
+	Pkgid string `xml:"pkgid,attr"`
+	Files []struct {
+		Type string `xml:"type,attr"`
+		Path string `xml:",chardata"`
+	} `xml:"file"`
+}
+
+// decodeRPMPackages calls fn for each <package> element of the RPM
+// repository metadata file r (e.g. primary.xml), which is decoded into
+// a new value returned by newValue. Metadata files are too large to be
+// decoded at once.
+func decodeRPMPackages(r io.Reader, newValue func() interface{}, fn func(v interface{}) error) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "package" {
+			continue
+		}
+		v := newValue()
+		if err := dec.DecodeElement(v, &se); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// rpmSource returns the source package name of the source RPM file
+// name sourcerpm (e.g. bash-5.2.26-3.fc40.src.rpm), or an empty string
+// if it cannot be determined.
+func rpmSource(sourcerpm string) string {
+	name := strings.TrimSuffix(sourcerpm, ".src.rpm")
+	for i := 0; i < 2; i++ { // strip release and version
+		idx := strings.LastIndex(name, "-")
+		if idx == -1 {
+			return ""
+		}
+		name = name[:idx]
+	}
+	return name
+}
+
+// rpmVersion converts the RPM package version into a version.Version.
+func rpmVersion(p *rpmPackage) version.Version {
+	v := version.Version{
+		Version:  p.Version.Ver,
+		Revision: p.Version.Rel,
+	}
+	if epoch, err := strconv.ParseUint(p.Version.Epoch, 10, 32); err == nil {
+		v.Epoch = uint(epoch)
+	}
+	return v
+}
+
+// getRPMRepo returns the manpages, packages and package versions of
+// the RPM (yum/dnf) repository dist.name (e.g. BaseOS), which is
+// served as suite. The repository is expected in the standard layout,
+// i.e. at <repo>/<arch>/os/ underneath the mirror URL.
+func getRPMRepo(ar *archive.Getter, dist distribution, suite string) ([]*contentEntry, []*pkgEntry, map[string]*manpage.PkgMeta, error) {
+	archs := dist.architectures
+	if archs == nil {
+		archs = []string{defaultRPMArchitecture}
+	}
+	byName := make(map[string]*pkgEntry)
+	// versions contains the RPM version of each package of byName, as
+	// pkgEntry.version is only an approximation.
+	versions := make(map[string]rpmEVR)
+	manpages := make(map[string][]string)
+	for _, arch := range archs {
+		dir := dist.name + "/" + arch + "/os/"
+		f, err := ar.GetSigned(dir+"repodata/repomd.xml", dir+"repodata/repomd.xml.asc")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files, err := parseRPMRepomd(f, "primary", "filelists")
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%srepodata/repomd.xml: %v", dir, err)
+		}
+
+		// Packages (by pkgid, i.e. their sha256 sum) which ship
+		// manpages according to filelists.xml.
+		pkgManpages := make(map[string][]string)
+		filelists := files["filelists"]
+		f, err = ar.Get(dir+filelists.href, filelists.sha256)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		err = decodeRPMPackages(f, func() interface{} { return &rpmFilelist{} }, func(v interface{}) error {
+			fl := v.(*rpmFilelist)
+			for _, file := range fl.Files {
+				if file.Type != "dir" && strings.HasPrefix(file.Path, "/usr/share/man/") {
+					pkgManpages[fl.Pkgid] = append(pkgManpages[fl.Pkgid], strings.TrimPrefix(file.Path, "/"))
+				}
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s%s: %v", dir, filelists.href, err)
+		}
+
+		primary := files["primary"]
+		f, err = ar.Get(dir+primary.href, primary.sha256)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		err = decodeRPMPackages(f, func() interface{} { return &rpmPackage{} }, func(v interface{}) error {
+			rp := v.(*rpmPackage)
+			if rp.Arch != arch && rp.Arch != "noarch" {
+				return nil // e.g. src or i686 (multilib)
+			}
+			pkgid := strings.TrimSpace(rp.Checksum.Value)
+			if len(pkgManpages[pkgid]) == 0 {
+				return nil
+			}
+			if rp.Checksum.Type != "sha256" {
+				return fmt.Errorf("package %s: unsupported checksum type %q", rp.Name, rp.Checksum.Type)
+			}
+			sum, err := hex.DecodeString(pkgid)
+			if err != nil {
+				return fmt.Errorf("package %s: %v", rp.Name, err)
+			}
+			p := &pkgEntry{
+				source:    rpmSource(rp.Format.SourceRPM),
+				suite:     suite,
+				binarypkg: rp.Name,
+				arch:      rp.Arch,
+				filename:  dir + rp.Location.Href,
+				version:   rpmVersion(rp),
+				sha256:    sum,
+				bytes:     rp.Size.Package,
+			}
+			for _, obsoletes := range rp.Format.Obsoletes {
+				p.replaces = append(p.replaces, obsoletes.Name)
+			}
+			if p.source == "" {
+				p.source = p.binarypkg
+			}
+			if prev, ok := versions[p.binarypkg]; ok && rpmCompareEVR(prev, rp.Version) >= 0 {
+				return nil
+			}
+			byName[p.binarypkg] = p
+			versions[p.binarypkg] = rp.Version
+			manpages[p.binarypkg] = pkgManpages[pkgid]
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s%s: %v", dir, primary.href, err)
+		}
+	}
+
+	content, pkgs, latestVersion := indexPackages(suite, byName, manpages)
+	infof("Adding %d packages from RPM repository %q", len(pkgs), suite)
+	return content, pkgs, latestVersion, nil
+}
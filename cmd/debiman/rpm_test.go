@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Debian/debiman/internal/archive"
+)
+
+const testRPMPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="3">
+<package type="rpm">
+  <name>i3</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <checksum type="sha256" pkgid="YES">0123456789abcdef</checksum>
+  <size package="1234" installed="4321" archive="4444"/>
+  <location href="Packages/i/i3-4.23-1.fc40.x86_64.rpm"/>
+  <format>
+    <rpm:sourcerpm>i3-4.23-1.fc40.src.rpm</rpm:sourcerpm>
+    <rpm:obsoletes>
+      <rpm:entry name="i3bar" flags="LT" epoch="0" ver="4.0"/>
+    </rpm:obsoletes>
+  </format>
+</package>
+<package type="rpm">
+  <name>i3</name>
+  <arch>i686</arch>
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <checksum type="sha256" pkgid="YES">abcd</checksum>
+  <location href="Packages/i/i3-4.23-1.fc40.i686.rpm"/>
+</package>
+<package type="rpm">
+  <name>i3-devel</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <checksum type="sha256" pkgid="YES">dcba</checksum>
+  <location href="Packages/i/i3-devel-4.23-1.fc40.x86_64.rpm"/>
+</package>
+</metadata>
+`
+
+const testRPMFilelists = `<?xml version="1.0" encoding="UTF-8"?>
+<filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="3">
+<package pkgid="0123456789abcdef" name="i3" arch="x86_64">
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <file>/usr/bin/i3</file>
+  <file type="dir">/usr/share/man/man1</file>
+  <file>/usr/share/man/man1/i3.1.gz</file>
+</package>
+<package pkgid="abcd" name="i3" arch="i686">
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <file>/usr/share/man/man1/i3.1.gz</file>
+</package>
+<package pkgid="dcba" name="i3-devel" arch="x86_64">
+  <version epoch="0" ver="4.23" rel="1.fc40"/>
+  <file>/usr/include/i3/ipc.h</file>
+</package>
+</filelists>
+`
+
+func TestRPMSource(t *testing.T) {
+	for _, entry := range []struct {
+		input string
+		want  string
+	}{
+		{"bash-5.2.26-3.fc40.src.rpm", "bash"},
+		{"perl-Git-SVN-2.45.2-2.fc40.src.rpm", "perl-Git-SVN"},
+		{"", ""},
+	} {
+		if got := rpmSource(entry.input); got != entry.want {
+			t.Errorf("rpmSource(%q): got %q, want %q", entry.input, got, entry.want)
+		}
+	}
+}
+
+func TestRPMCompareEVR(t *testing.T) {
+	for _, entry := range []struct {
+		a, b rpmEVR
+		want int
+	}{
+		{rpmEVR{Ver: "1.0", Rel: "1"}, rpmEVR{Epoch: "0", Ver: "1.0", Rel: "1"}, 0},
+		{rpmEVR{Ver: "1.0", Rel: "1"}, rpmEVR{Ver: "1.0", Rel: "2"}, -1},
+		{rpmEVR{Epoch: "1", Ver: "0.9", Rel: "1"}, rpmEVR{Ver: "2.0", Rel: "1"}, 1},
+		{rpmEVR{Ver: "1.9", Rel: "1.el9"}, rpmEVR{Ver: "1.10", Rel: "1.el9"}, -1},
+		{rpmEVR{Ver: "1.0", Rel: "1.el9_2"}, rpmEVR{Ver: "1.0", Rel: "1.el9_10"}, -1},
+	} {
+		if got := rpmCompareEVR(entry.a, entry.b); got != entry.want {
+			t.Errorf("rpmCompareEVR(%+v, %+v): got %d, want %d", entry.a, entry.b, got, entry.want)
+		}
+	}
+}
+
+func TestGetRPMRepo(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dir := filepath.Join(tmpdir, "Everything", "x86_64", "os", "repodata")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var sums []interface{}
+	for _, f := range []struct {
+		name    string
+		content string
+	}{
+		{"primary.xml.gz", testRPMPrimary},
+		{"filelists.xml.gz", testRPMFilelists},
+	} {
+		path := filepath.Join(dir, f.name)
+		writeGzipped(t, path, f.content)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(b)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	repomd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+  <data type="filelists">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/filelists.xml.gz"/>
+  </data>
+</repomd>
+`, sums...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "repomd.xml"), []byte(repomd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ar := &archive.Getter{
+		ConnectionsPerMirror: 1,
+		LocalMirror:          tmpdir,
+		Insecure:             true,
+	}
+	content, pkgs, latestVersion, err := getRPMRepo(ar, distribution{name: "Everything", prefix: "fedora", format: formatRPM}, "fedora-Everything")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pkgs), 1; got != want {
+		t.Fatalf("unexpected number of packages: got %d, want %d", got, want)
+	}
+	p := pkgs[0]
+	if got, want := p.filename, "Everything/x86_64/os/Packages/i/i3-4.23-1.fc40.x86_64.rpm"; got != want {
+		t.Errorf("unexpected filename: got %q, want %q", got, want)
+	}
+	if got, want := p.source, "i3"; got != want {
+		t.Errorf("unexpected source: got %q, want %q", got, want)
+	}
+	if got, want := p.replaces, []string{"i3bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected replaces: got %q, want %q", got, want)
+	}
+	if got, want := p.sha256, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}; !bytes.Equal(got, want) {
+		t.Errorf("unexpected sha256: got %x, want %x", got, want)
+	}
+	wantContent := []*contentEntry{{
+		suite:     "fedora-Everything",
+		arch:      "x86_64",
+		binarypkg: "i3",
+		filename:  "usr/share/man/man1/i3.1.gz",
+	}}
+	if !reflect.DeepEqual(content, wantContent) {
+		t.Errorf("unexpected content: got %+v, want %+v", content, wantContent)
+	}
+	if pm, ok := latestVersion["fedora-Everything/i3"]; !ok || pm.Version.String() != "4.23-1.fc40" {
+		t.Errorf("unexpected latestVersion: got %+v", latestVersion)
+	}
+}
+
+// cpioFile is an entry of a cpio archive written by writeCPIO.
+type cpioFile struct {
+	name    string
+	mode    int64
+	ino     int64
+	nlink   int64
+	content string
+}
+
+// writeCPIO writes a cpio archive in the “new ASCII” format containing
+// files to w.
+func writeCPIO(t *testing.T, w io.Writer, files []cpioFile) {
+	pad := func(n int) {
+		if _, err := w.Write(make([]byte, (4-n%4)%4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range append(files, cpioFile{name: cpioTrailer}) {
+		if f.nlink == 0 {
+			f.nlink = 1
+		}
+		header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			f.ino, f.mode, 0, 0, f.nlink, 0, len(f.content), 0, 0, 0, 0, len(f.name)+1, 0)
+		if _, err := io.WriteString(w, header+f.name+"\x00"); err != nil {
+			t.Fatal(err)
+		}
+		pad(len(header) + len(f.name) + 1)
+		if _, err := io.WriteString(w, f.content); err != nil {
+			t.Fatal(err)
+		}
+		pad(len(f.content))
+	}
+}
+
+// writeRPMHeader writes an RPM header structure without index entries
+// whose data store consists of size bytes.
+func writeRPMHeader(t *testing.T, w io.Writer, size int) {
+	preamble := append(append([]byte(nil), rpmHeaderMagic...), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(size))
+	if _, err := w.Write(append(preamble, make([]byte, size)...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenRPMPackage(t *testing.T) {
+	f, err := ioutil.TempFile("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmLeadMagic)
+	if _, err := f.Write(lead); err != nil {
+		t.Fatal(err)
+	}
+	// The signature header is padded to 8 bytes: (16 + 5 + 3) % 8 == 0
+	writeRPMHeader(t, f, 5)
+	if _, err := f.Write(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	writeRPMHeader(t, f, 5) // the header is not padded
+	gw := gzip.NewWriter(f)
+	writeCPIO(t, gw, []cpioFile{
+		{name: "./usr/share/man/man1", mode: 040755},
+		{name: "./usr/share/man/man1/i3.1.gz", mode: 0100644, content: "i3(1)"},
+		{name: "./usr/share/man/man1/i3-with-shmlog.1.gz", mode: 0120777, content: "i3.1.gz"},
+		{name: "./usr/share/man/man1/i3-msg.1.gz", mode: 0100644, ino: 7, nlink: 2},
+		{name: "./usr/share/man/man1/i3-dump-log.1.gz", mode: 0100644, ino: 7, nlink: 2, content: "i3-msg(1)"},
+	})
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openPackage(f, "i3-4.23-1.fc40.x86_64.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got []string
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%c %s %s %s", header.Typeflag, header.Name, header.Linkname, b))
+	}
+	sort.Strings(got)
+	want := []string{
+		"0 ./usr/share/man/man1/i3-dump-log.1.gz  i3-msg(1)",
+		"0 ./usr/share/man/man1/i3.1.gz  i3(1)",
+		"1 ./usr/share/man/man1/i3-msg.1.gz ./usr/share/man/man1/i3-dump-log.1.gz ",
+		"2 ./usr/share/man/man1/i3-with-shmlog.1.gz i3.1.gz ",
+		"5 ./usr/share/man/man1  ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected files: got %q, want %q", got, want)
+	}
+}
+
+func TestCPIOReaderLimits(t *testing.T) {
+	for _, entry := range []struct {
+		desc     string
+		mode     int64
+		size     int64
+		namesize int64
+	}{
+		{"name", 0100644, 0, 0x7fffffff},
+		{"symlink target", 0120777, 0x7fffffff, 7},
+	} {
+		header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			0, entry.mode, 0, 0, 1, 0, entry.size, 0, 0, 0, 0, entry.namesize, 0)
+		cr := newCPIOReader(strings.NewReader(header + "./i3.1\x00\x00\x00\x00"))
+		if _, err := cr.Next(); err == nil || !strings.Contains(err.Error(), "too long") {
+			t.Errorf("%s: unexpected error: got %v, want too long", entry.desc, err)
+		}
+	}
+}
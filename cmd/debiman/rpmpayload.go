@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// rpmLeadSize is the size of the (obsolete) lead with which RPM files
+// start.
+const rpmLeadSize = 96
+
+// skipRPMHeader skips an RPM header structure in r: a 16-byte
+// preamble containing the number of index entries and the size of the
+// data store, followed by the index entries and the data store.
+func skipRPMHeader(r io.Reader) (int64, error) {
+	var preamble [16]byte
+	if _, err := io.ReadFull(r, preamble[:]); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(preamble[:4], rpmHeaderMagic) {
+		return 0, fmt.Errorf("invalid RPM header magic %x", preamble[:4])
+	}
+	nindex := int64(binary.BigEndian.Uint32(preamble[8:12]))
+	hsize := int64(binary.BigEndian.Uint32(preamble[12:16]))
+	size := 16*nindex + hsize
+	if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+		return 0, err
+	}
+	return int64(len(preamble)) + size, nil
+}
+
+// openRPMPayload returns the (decompressed) cpio archive contained in
+// the RPM file r. The returned function releases the resources of the
+// decompressor.
+func openRPMPayload(r io.Reader) (io.Reader, func(), error) {
+	var lead [rpmLeadSize]byte
+	if _, err := io.ReadFull(r, lead[:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(lead[:4], rpmLeadMagic) {
+		return nil, nil, fmt.Errorf("invalid RPM lead magic %x", lead[:4])
+	}
+	// The signature header is padded to a multiple of 8 bytes.
+	n, err := skipRPMHeader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signature header: %v", err)
+	}
+	if pad := (8 - n%8) % 8; pad > 0 {
+		if _, err := io.CopyN(ioutil.Discard, r, pad); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := skipRPMHeader(r); err != nil {
+		return nil, nil, fmt.Errorf("header: %v", err)
+	}
+	return decompressByMagic(r)
+}
+
+// cpioHeaderSize is the size of a cpio “new ASCII” (newc) header.
+const cpioHeaderSize = 110
+
+// cpioMaxPathSize limits the size of entry names and symlink targets,
+// which are read into memory, so that a corrupt archive cannot make us
+// allocate gigabytes. It is PATH_MAX on Linux.
+const cpioMaxPathSize = 4096
+
+// cpioTrailer is the name of the last entry of a cpio archive.
+const cpioTrailer = "TRAILER!!!"
+
+// cpioReader iterates over the entries of a cpio archive in the “new
+// ASCII” format (as used in RPM payloads), like tar.Reader.
+type cpioReader struct {
+	r io.Reader
+
+	// remaining is the number of unread bytes of the current entry,
+	// pad the number of padding bytes following them.
+	remaining int64
+	pad       int64
+
+	// links contains the hard links (by inode) whose content is stored
+	// with the last link, which is yet to be read. queue contains hard
+	// links to be returned (as tar.TypeLink) by the next calls to Next.
+	links map[int64][]*tar.Header
+	queue []*tar.Header
+}
+
+func newCPIOReader(r io.Reader) *cpioReader {
+	return &cpioReader{
+		r:     r,
+		links: make(map[int64][]*tar.Header),
+	}
+}
+
+// Next advances to the next entry of the archive.
+func (cr *cpioReader) Next() (*tar.Header, error) {
+	if _, err := io.CopyN(ioutil.Discard, cr.r, cr.remaining+cr.pad); err != nil {
+		return nil, err
+	}
+	cr.remaining, cr.pad = 0, 0
+	if len(cr.queue) > 0 {
+		header := cr.queue[0]
+		cr.queue = cr.queue[1:]
+		return header, nil
+	}
+	for {
+		header, ino, nlink, err := cr.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if nlink > 1 && header.Typeflag == tar.TypeReg {
+			if header.Size == 0 {
+				cr.links[ino] = append(cr.links[ino], header)
+				continue
+			}
+			for _, link := range cr.links[ino] {
+				link.Typeflag = tar.TypeLink
+				link.Linkname = header.Name
+				cr.queue = append(cr.queue, link)
+			}
+			delete(cr.links, ino)
+		}
+		return header, nil
+	}
+}
+
+// readHeader reads the header of the next entry of the archive, which
+// also returns its inode and link count for resolving hard links.
+func (cr *cpioReader) readHeader() (*tar.Header, int64, int64, error) {
+	var buf [cpioHeaderSize]byte
+	if _, err := io.ReadFull(cr.r, buf[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, 0, err
+	}
+	if magic := string(buf[:6]); magic != "070701" && magic != "070702" {
+		return nil, 0, 0, fmt.Errorf("unsupported cpio magic %q", magic)
+	}
+	var fields [13]int64
+	for i := range fields {
+		field := buf[6+8*i : 6+8*(i+1)]
+		v, err := strconv.ParseInt(string(field), 16, 64)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid cpio header field %q", field)
+		}
+		fields[i] = v
+	}
+	var (
+		ino      = fields[0]
+		mode     = fields[1]
+		nlink    = fields[4]
+		mtime    = fields[5]
+		size     = fields[6]
+		namesize = fields[11]
+	)
+	if namesize > cpioMaxPathSize {
+		return nil, 0, 0, fmt.Errorf("cpio entry name too long (%d bytes)", namesize)
+	}
+	// The name (including its terminating NUL byte) is padded to a
+	// multiple of 4 bytes, counting the header.
+	name := make([]byte, namesize+(4-(cpioHeaderSize+namesize)%4)%4)
+	if _, err := io.ReadFull(cr.r, name); err != nil {
+		return nil, 0, 0, err
+	}
+	if idx := bytes.IndexByte(name, 0); idx > -1 {
+		name = name[:idx]
+	}
+	if string(name) == cpioTrailer {
+		return nil, 0, 0, io.EOF
+	}
+
+	header := &tar.Header{
+		Name:    string(name),
+		Mode:    mode & 07777,
+		Uid:     int(fields[2]),
+		Gid:     int(fields[3]),
+		ModTime: time.Unix(mtime, 0),
+	}
+	cr.remaining = size
+	cr.pad = (4 - size%4) % 4
+	switch mode & 0170000 {
+	case 0100000:
+		header.Typeflag = tar.TypeReg
+		header.Size = size
+	case 0040000:
+		header.Typeflag = tar.TypeDir
+	case 0120000:
+		header.Typeflag = tar.TypeSymlink
+		if size > cpioMaxPathSize {
+			return nil, 0, 0, fmt.Errorf("%s: symlink target too long (%d bytes)", name, size)
+		}
+		target := make([]byte, size)
+		if _, err := io.ReadFull(cr.r, target); err != nil {
+			return nil, 0, 0, err
+		}
+		header.Linkname = string(target)
+		cr.remaining = 0
+	case 0020000:
+		header.Typeflag = tar.TypeChar
+	case 0060000:
+		header.Typeflag = tar.TypeBlock
+	case 0010000:
+		header.Typeflag = tar.TypeFifo
+	default:
+		return nil, 0, 0, fmt.Errorf("%s: unsupported file mode %o", name, mode)
+	}
+	return header, ino, nlink, nil
+}
+
+// Read reads from the current entry of the archive.
+func (cr *cpioReader) Read(b []byte) (int, error) {
+	if cr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > cr.remaining {
+		b = b[:cr.remaining]
+	}
+	n, err := cr.r.Read(b)
+	cr.remaining -= int64(n)
+	if err == io.EOF && cr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+	"github.com/Debian/debiman/internal/search"
+)
+
+var buildSearchIndex = flag.Bool("search_index",
+	false,
+	"Index the text of each rendered manpage for full-text search into search-<suite>.gob, which debiman-auxserver -search serves at /search?q=<query>.")
+
+// searchIndexer collects the search documents of the rendered manpages,
+// keyed by suite and serving path.
+type searchIndexer struct {
+	mu   sync.Mutex
+	docs map[string]map[string]search.Document
+}
+
+func newSearchIndexer() *searchIndexer {
+	return &searchIndexer{
+		docs: make(map[string]map[string]search.Document),
+	}
+}
+
+// record indexes content, the rendered manpage m, replacing any
+// previously recorded document for m.
+func (s *searchIndexer) record(m *manpage.Meta, content string) {
+	text, err := convert.Text(content)
+	if err != nil {
+		warningf("extracting text of %q: %v", m.ServingPath(), err)
+		return
+	}
+	_, description, err := convert.Whatis(content)
+	if err != nil {
+		warningf("extracting NAME section of %q: %v", m.ServingPath(), err)
+	}
+	doc := search.NewDocument(search.Document{
+		ServingPath: m.ServingPath(),
+		Name:        m.Name,
+		Section:     m.Section,
+		Language:    m.Language,
+		Binarypkg:   m.Package.Binarypkg,
+		Description: description,
+	}, text)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bySuite, ok := s.docs[m.Package.Suite]
+	if !ok {
+		bySuite = make(map[string]search.Document)
+		s.docs[m.Package.Suite] = bySuite
+	}
+	bySuite[m.ServingPath()] = doc
+}
+
+func searchIndexPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("search-%s.gob", suite))
+}
+
+// write merges the recorded documents into the search indexes of each
+// suite of gv in destDir. Manpages which were not re-rendered in this
+// run keep their previous documents, manpages which are no longer part
+// of gv are removed.
+func (s *searchIndexer) write(destDir string, gv globalView) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := make(map[string]bool)
+	for _, versions := range gv.xref {
+		for _, v := range versions {
+			current[v.ServingPath()] = true
+		}
+	}
+	for suite := range gv.suites {
+		path := searchIndexPath(destDir, suite)
+		existing, err := search.Load(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("loading %q: %v", path, err)
+		}
+		recorded := s.docs[suite]
+		if os.IsNotExist(err) && len(recorded) == 0 {
+			continue
+		}
+		merged := make(map[string]search.Document, len(existing)+len(recorded))
+		for _, d := range existing {
+			merged[d.ServingPath] = d
+		}
+		for servingPath, d := range recorded {
+			merged[servingPath] = d
+		}
+		docs := make([]search.Document, 0, len(merged))
+		for servingPath, d := range merged {
+			if d.Length == 0 || !current[servingPath] {
+				continue
+			}
+			docs = append(docs, d)
+		}
+		recordArtifact(artifactData, path)
+		if err := writeAtomically(path, false, func(w io.Writer) error {
+			return search.Write(w, docs)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Debian/debiman/internal/search"
+)
+
+func TestSearchIndexer(t *testing.T) {
+	tmpdir, cleanup := newTestServingDir(t)
+	defer cleanup()
+
+	// i3bar(1) was removed from the suite since the previous run.
+	previous := []search.Document{
+		search.NewDocument(search.Document{ServingPath: "jessie/i3-wm/i3-msg.1.en", Name: "i3-msg"}, "send messages to i3"),
+		search.NewDocument(search.Document{ServingPath: testI3barServingPath, Name: "i3bar"}, "workspace bar"),
+	}
+	if err := writeAtomically(searchIndexPath(tmpdir, "jessie"), false, func(w io.Writer) error {
+		return search.Write(w, previous)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gv := newTestI3View(t)
+	s := newSearchIndexer()
+	s.record(gv.xref["i3"][0], testI3NameSection)
+	if err := s.write(tmpdir, gv); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := search.Load(searchIndexPath(tmpdir, "jessie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := search.NewIndex(docs)
+	if got, want := idx.Len(), 2; got != want {
+		t.Fatalf("got %d documents, want %d", got, want)
+	}
+	results := idx.Search("tiling", nil, 10)
+	if len(results) != 1 {
+		t.Fatalf("Search(tiling): got %v, want 1 result", results)
+	}
+	if got, want := results[0].Description, "an improved dynamic, tiling window manager"; got != want {
+		t.Errorf("unexpected description: got %q, want %q", got, want)
+	}
+	if got := idx.Search("workspace", nil, 10); len(got) != 0 {
+		t.Errorf("Search(workspace): got %v, want no results for the removed i3bar(1)", got)
+	}
+}
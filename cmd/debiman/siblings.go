@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var brotliOutput = flag.Bool("brotli",
+	false,
+	"Additionally write a brotli-compressed .html.br file next to every .html.gz file, e.g. for nginx’s brotli_static")
+
+var compression = flag.String("compression",
+	"gzip",
+	"Comma-separated list of formats in which to compress rendered HTML: gzip, optionally followed by zstd. gzip must be specified: debiman compares the modification times of the .html.gz files to decide what to re-render, debiman-auxserver redirects to them, and every browser accepts gzip. zstd additionally writes a .html.zst file next to every .html.gz file.")
+
+var zstdOutput bool
+
+// parseCompression validates the -compression flag and enables the
+// formats it specifies. gzip is required (see -compression).
+func parseCompression(value string) error {
+	var gzipOutput bool
+	for _, format := range strings.Split(value, ",") {
+		switch strings.TrimSpace(format) {
+		case "gzip":
+			gzipOutput = true
+		case "zstd":
+			zstdOutput = true
+		default:
+			return fmt.Errorf("-compression: unknown format %q (known formats: gzip, zstd)", format)
+		}
+	}
+	if !gzipOutput {
+		return fmt.Errorf("-compression=%s: gzip is required, writing only zstd is not supported (use -compression=gzip,zstd)", value)
+	}
+	return nil
+}
+
+// siblingEncoder is implemented by brotli.Writer and zstd.Encoder.
+type siblingEncoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// siblingFormat is a compression format in which rendered HTML is
+// written in addition to gzip, so that web servers can pick the
+// smallest variant a client accepts.
+type siblingFormat struct {
+	ext     string // e.g. .br
+	enabled func() bool
+
+	// bytes returns the counter for the size of files in this format.
+	bytes func(ss *suiteStats) *uint64
+
+	// newEncoder returns a new encoder for this format.
+	newEncoder func() (siblingEncoder, error)
+
+	// encoders is a pool of encoders, which are expensive to allocate
+	// at the highest compression level. Like the gzip.Writer of each
+	// renderAll worker, they are reused across files.
+	encoders sync.Pool
+}
+
+// encoder returns an encoder from f.encoders, or a new one if the pool
+// is empty.
+func (f *siblingFormat) encoder() (siblingEncoder, error) {
+	if enc := f.encoders.Get(); enc != nil {
+		return enc.(siblingEncoder), nil
+	}
+	return f.newEncoder()
+}
+
+var siblingFormats = []*siblingFormat{
+	{
+		ext:     ".br",
+		enabled: func() bool { return *brotliOutput },
+		bytes:   func(ss *suiteStats) *uint64 { return &ss.BrotliBytes },
+		newEncoder: func() (siblingEncoder, error) {
+			// Like with gzip, decompression takes the same time
+			// regardless of the compression level, so we invest the
+			// maximum CPU time.
+			return brotli.NewWriterLevel(nil, brotli.BestCompression), nil
+		},
+	},
+
+	{
+		ext:     ".zst",
+		enabled: func() bool { return zstdOutput },
+		bytes:   func(ss *suiteStats) *uint64 { return &ss.ZstdBytes },
+		newEncoder: func() (siblingEncoder, error) {
+			return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		},
+	},
+}
+
+// path returns the path of the sibling of the gzip-compressed file
+// dest in format f, or "" if no sibling should be written.
+func (f *siblingFormat) path(dest string) string {
+	if !f.enabled() || !strings.HasSuffix(dest, ".html.gz") {
+		return ""
+	}
+	return strings.TrimSuffix(dest, ".gz") + f.ext
+}
+
+// siblingPaths returns the paths of all siblings of dest which should
+// be written.
+func siblingPaths(dest string) map[*siblingFormat]string {
+	paths := make(map[*siblingFormat]string)
+	for _, f := range siblingFormats {
+		if path := f.path(dest); path != "" {
+			paths[f] = path
+		}
+	}
+	return paths
+}
+
+// siblingsStale returns true if any sibling of dest should be written,
+// but is missing or older than modTime.
+func siblingsStale(dest string, modTime time.Time) bool {
+	for _, path := range siblingPaths(dest) {
+		st, err := os.Stat(path)
+		if err != nil || st.ModTime().Before(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// sibling is a file which is being written by writeAtomically or
+// writeAtomicallyWithGz in addition to dest.
+type sibling struct {
+	format *siblingFormat
+	dest   string
+	f      *os.File
+	bufw   *bufio.Writer
+	enc    siblingEncoder
+}
+
+// siblings are all siblings of a file.
+type siblings []*sibling
+
+// newSiblings returns the siblings of the gzip-compressed file dest.
+func newSiblings(dest string) (siblings, error) {
+	var s siblings
+	for format, path := range siblingPaths(dest) {
+		f, err := ioutil.TempFile(tempDir(path), "debiman-")
+		if err != nil {
+			s.cleanup()
+			return nil, err
+		}
+		enc, err := format.encoder()
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			s.cleanup()
+			return nil, err
+		}
+		bufw := bufio.NewWriter(f)
+		enc.Reset(bufw)
+		s = append(s, &sibling{
+			format: format,
+			dest:   path,
+			f:      f,
+			bufw:   bufw,
+			enc:    enc,
+		})
+	}
+	return s, nil
+}
+
+// wrap returns a write function which additionally writes the
+// uncompressed output of write to all siblings.
+func (s siblings) wrap(write func(w io.Writer) error) func(w io.Writer) error {
+	if len(s) == 0 {
+		return write
+	}
+	return func(w io.Writer) error {
+		writers := []io.Writer{w}
+		for _, sib := range s {
+			writers = append(writers, sib.enc)
+		}
+		return write(io.MultiWriter(writers...))
+	}
+}
+
+// commit moves all siblings into place.
+func (s siblings) commit() error {
+	for _, sib := range s {
+		if err := sib.enc.Close(); err != nil {
+			return err
+		}
+		if err := sib.bufw.Flush(); err != nil {
+			return err
+		}
+		if err := sib.f.Chmod(0644); err != nil {
+			return err
+		}
+		if err := sib.f.Close(); err != nil {
+			return err
+		}
+		if err := commitFile(sib.f.Name(), sib.dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanup removes the temporary files (unless they were committed)
+// and returns the encoders to their pools.
+func (s siblings) cleanup() {
+	for _, sib := range s {
+		sib.f.Close()
+		os.Remove(sib.f.Name())
+		sib.enc.Reset(nil)
+		sib.format.encoders.Put(sib.enc)
+	}
+}
+
+// writeSiblings writes the siblings of dest with the (uncompressed)
+// contents b, if any.
+func writeSiblings(dest string, b []byte) error {
+	s, err := newSiblings(dest)
+	if err != nil {
+		return err
+	}
+	defer s.cleanup()
+	for _, sib := range s {
+		if _, err := io.Copy(sib.enc, bytes.NewReader(b)); err != nil {
+			return err
+		}
+	}
+	return s.commit()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCompression(t *testing.T) {
+	defer func() { zstdOutput = false }()
+
+	for _, value := range []string{"gzip", "gzip,zstd", "zstd, gzip"} {
+		if err := parseCompression(value); err != nil {
+			t.Errorf("parseCompression(%q): %v", value, err)
+		}
+	}
+	zstdOutput = false
+	if err := parseCompression("gzip,zstd"); err != nil {
+		t.Errorf("parseCompression(%q): %v", "gzip,zstd", err)
+	}
+	if !zstdOutput {
+		t.Errorf("parseCompression(%q) did not enable zstd", "gzip,zstd")
+	}
+	// gzip is required.
+	for _, value := range []string{"zstd", "gzip,lzma", ""} {
+		if err := parseCompression(value); err == nil {
+			t.Errorf("parseCompression(%q) unexpectedly succeeded", value)
+		}
+	}
+}
+
+func TestSiblings(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-siblings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldBrotli := *brotliOutput
+	*brotliOutput = true
+	zstdOutput = true
+	defer func() {
+		*brotliOutput = oldBrotli
+		zstdOutput = false
+	}()
+
+	for _, dest := range []string{"sitemap.xml.gz", "metrics.txt"} {
+		if got := siblingPaths(filepath.Join(tmpdir, dest)); len(got) > 0 {
+			t.Errorf("siblingPaths(%q): got %v, want none", dest, got)
+		}
+	}
+
+	dest := filepath.Join(tmpdir, "i3.1.en.html.gz")
+	if !siblingsStale(dest, time.Now()) {
+		t.Fatalf("missing siblings not considered stale")
+	}
+	if err := writeAtomically(dest, true, func(w io.Writer) error {
+		_, err := io.WriteString(w, "<html></html>")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	paths := siblingPaths(dest)
+	if got, want := len(paths), len(siblingFormats); got != want {
+		t.Fatalf("unexpected number of siblings: got %d, want %d", got, want)
+	}
+	for format, path := range paths {
+		if got, want := path, strings.TrimSuffix(dest, ".gz")+format.ext; got != want {
+			t.Errorf("sibling path of %q: got %q, want %q", dest, got, want)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("sibling not written: %v", err)
+		}
+	}
+	if siblingsStale(dest, time.Now().Add(-1*time.Hour)) {
+		t.Fatalf("up to date siblings considered stale")
+	}
+
+	// A failed write must not leave siblings behind.
+	failed := filepath.Join(tmpdir, "failed.html.gz")
+	if err := writeAtomically(failed, true, func(w io.Writer) error {
+		return io.ErrUnexpectedEOF
+	}); err == nil {
+		t.Fatalf("writeAtomically unexpectedly succeeded")
+	}
+	for _, path := range siblingPaths(failed) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("sibling %q of failed write unexpectedly present: %v", path, err)
+		}
+	}
+	entries, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 1+len(siblingFormats); got != want {
+		t.Errorf("unexpected number of files in %q (left-over temporary files?): got %d, want %d", tmpdir, got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+var jsonSidecar = flag.Bool("json_sidecar",
+	false,
+	"Write a <name>.<section>.<language>.json.gz file containing metadata (package, versions, SEE ALSO references, …) next to every rendered manpage, for consumption by external tools")
+
+// manpageSidecar is the metadata of a manpage, as written to its JSON
+// sidecar file.
+type manpageSidecar struct {
+	Name     string    `json:"name"`
+	Section  string    `json:"section"`
+	Language string    `json:"language"`
+	Package  string    `json:"package"`
+	Suite    string    `json:"suite"`
+	Modified time.Time `json:"modified"`
+
+	// Versions contains the serving paths of all other versions of
+	// the manpage (other suites, packages, sections and languages).
+	Versions []string `json:"versions"`
+
+	// SeeAlso contains the serving paths of the manpages referenced in
+	// the SEE ALSO section.
+	SeeAlso []string `json:"see_also"`
+}
+
+// sidecarPath returns the path of the JSON sidecar of the rendered
+// manpage dest.
+func sidecarPath(dest string) string {
+	path := strings.TrimSuffix(dest, htmlSuffix()) + ".json"
+	if !*noCompress {
+		path += ".gz"
+	}
+	return path
+}
+
+// sidecarStale returns true if the JSON sidecar of dest should be
+// written, but is missing or older than modTime.
+func sidecarStale(dest string, modTime time.Time) bool {
+	if !*jsonSidecar {
+		return false
+	}
+	st, err := os.Stat(sidecarPath(dest))
+	return err != nil || st.ModTime().Before(modTime)
+}
+
+func newManpageSidecar(meta *manpage.Meta, versions []*manpage.Meta, modTime time.Time, content string) (*manpageSidecar, error) {
+	sidecar := &manpageSidecar{
+		Name:     meta.Name,
+		Section:  meta.Section,
+		Language: meta.Language,
+		Package:  meta.Package.Binarypkg,
+		Suite:    meta.Package.Suite,
+		Modified: modTime.UTC(),
+		Versions: []string{},
+		SeeAlso:  []string{},
+	}
+	for _, v := range versions {
+		if v == meta {
+			continue
+		}
+		sidecar.Versions = append(sidecar.Versions, v.ServingPath())
+	}
+	targets, err := convert.SeeAlso(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		// Only links to other manpages are of interest.
+		if !strings.HasPrefix(target, "/") || !strings.HasSuffix(target, ".html") {
+			continue
+		}
+		sidecar.SeeAlso = append(sidecar.SeeAlso, strings.TrimSuffix(strings.TrimPrefix(target, "/"), ".html"))
+	}
+	return sidecar, nil
+}
+
+// writeSidecar writes the JSON sidecar of the rendered manpage dest.
+func writeSidecar(dest string, sidecar *manpageSidecar) error {
+	recordArtifact(artifactManpage, sidecarPath(dest))
+	return writeAtomically(sidecarPath(dest), !*noCompress, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(sidecar)
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/manpage"
+)
+
+func TestManpageSidecar(t *testing.T) {
+	mk := func(suite, binarypkg, section, lang string) *manpage.Meta {
+		return &manpage.Meta{
+			Name: "crontab",
+			Package: &manpage.PkgMeta{
+				Binarypkg: binarypkg,
+				Suite:     suite,
+			},
+			Section:  section,
+			Language: lang,
+		}
+	}
+	meta := mk("jessie", "cron", "1", "en")
+	versions := []*manpage.Meta{
+		meta,
+		mk("jessie", "cron", "1", "fr"),
+		mk("stretch", "cron", "1", "en"),
+	}
+	content := `<h1 class="Sh" id="NAME">NAME<a class="anchor" href="#NAME">¶</a></h1>
+<a href="/jessie/cron/cron.8.en.html">cron(8)</a>
+<h1 class="Sh" id="SEE_ALSO">SEE ALSO<a class="anchor" href="#SEE_ALSO">¶</a></h1>
+<a href="/jessie/cron/crontab.5.en.html">crontab(5)</a>, <a href="https://example.org/">https://example.org/</a>`
+	modTime := time.Unix(1484816329, 0)
+
+	got, err := newManpageSidecar(meta, versions, modTime, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &manpageSidecar{
+		Name:     "crontab",
+		Section:  "1",
+		Language: "en",
+		Package:  "cron",
+		Suite:    "jessie",
+		Modified: modTime.UTC(),
+		Versions: []string{"jessie/cron/crontab.1.fr", "stretch/cron/crontab.1.en"},
+		SeeAlso:  []string{"jessie/cron/crontab.5.en"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected sidecar: got %+v, want %+v", got, want)
+	}
+
+	if got, want := sidecarPath("/srv/man/jessie/cron/crontab.1.en.html.gz"), "/srv/man/jessie/cron/crontab.1.en.json.gz"; got != want {
+		t.Fatalf("sidecarPath: got %q, want %q", got, want)
+	}
+}
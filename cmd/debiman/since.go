@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var since = flag.String("since",
+	"",
+	"If non-empty, a duration (e.g. 1h) or RFC 3339 timestamp (e.g. 2017-01-02T15:04:05Z). Only manpages whose source was modified after that point in time are rendered, even if the rendered versions of other manpages are out of date. This results in a partial render: a full run is still needed for correctness.")
+
+// parseSince returns the cutoff time specified by value, which is
+// either a duration (relative to now) or an RFC 3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("-since=%q: duration must not be negative", value)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-since=%q: neither a duration nor an RFC 3339 timestamp", value)
+	}
+	return t, nil
+}
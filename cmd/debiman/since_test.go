@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2017, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, entry := range []struct {
+		value string
+		want  time.Time
+	}{
+		{"1h", now.Add(-1 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2017-01-01T00:00:00Z", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		entry := entry // capture
+		t.Run(entry.value, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseSince(entry.value, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(entry.want) {
+				t.Fatalf("unexpected cutoff: got %v, want %v", got, entry.want)
+			}
+		})
+	}
+
+	for _, value := range []string{"yesterday", "-1h", "2017-01-01"} {
+		if _, err := parseSince(value, now); err == nil {
+			t.Errorf("parseSince(%q) unexpectedly succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	snapshot = flag.String("snapshot",
+		"",
+		"If non-empty, a point in time (e.g. 2017-06-17T00:00:00Z or 20170617T000000Z) as of which to synchronize the suites from snapshot.debian.org instead of the Debian mirror, e.g. to regenerate a historical view. Use a separate -serving_dir, as newer package versions are not replaced with older ones. Note that snapshot.debian.org is rate-limited.")
+
+	snapshotKeyring = flag.String("snapshot_keyring",
+		"",
+		"If non-empty, the keyring to verify -snapshot Release files with instead of -keyring, e.g. /usr/share/keyrings/debian-archive-removed-keys.gpg for releases signed with keys which were since removed from debian-archive-keyring")
+)
+
+// snapshotTimestampFormat is the format of timestamps in
+// snapshot.debian.org URLs.
+const snapshotTimestampFormat = "20060102T150405Z"
+
+// snapshotURL returns the URL of the Debian archive on
+// snapshot.debian.org as of the point in time ts (see -snapshot).
+// snapshot.debian.org serves the newest state of the archive which is
+// not newer than the timestamp.
+func snapshotURL(ts string) (string, error) {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		var err2 error
+		t, err2 = time.Parse(snapshotTimestampFormat, ts)
+		if err2 != nil {
+			return "", fmt.Errorf("-snapshot=%q: %v", ts, err)
+		}
+	}
+	if t.Year() < 2005 {
+		// snapshot.debian.org starts in March 2005.
+		return "", fmt.Errorf("-snapshot=%q: snapshot.debian.org has no data before 2005", ts)
+	}
+	return "https://snapshot.debian.org/archive/debian/" + t.UTC().Format(snapshotTimestampFormat) + "/", nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSnapshotURL(t *testing.T) {
+	for _, entry := range []struct {
+		ts   string
+		want string
+	}{
+		{"2017-06-17T00:00:00Z", "https://snapshot.debian.org/archive/debian/20170617T000000Z/"},
+		{"2017-06-17T02:30:00+02:00", "https://snapshot.debian.org/archive/debian/20170617T003000Z/"},
+		{"20170617T153000Z", "https://snapshot.debian.org/archive/debian/20170617T153000Z/"},
+	} {
+		got, err := snapshotURL(entry.ts)
+		if err != nil {
+			t.Fatalf("snapshotURL(%q): %v", entry.ts, err)
+		}
+		if got != entry.want {
+			t.Errorf("snapshotURL(%q): got %q, want %q", entry.ts, got, entry.want)
+		}
+	}
+
+	for _, ts := range []string{"yesterday", "2017-06-17", "1999-01-01T00:00:00Z"} {
+		if _, err := snapshotURL(ts); err == nil {
+			t.Errorf("snapshotURL(%q) unexpectedly succeeded", ts)
+		}
+	}
+}
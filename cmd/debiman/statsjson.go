@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"time"
+)
+
+var statsJSON = flag.String("stats_json",
+	"",
+	"If non-empty, path to which the statistics of each run (the numbers printed at the end of a run, the duration of each command and the number of warnings and errors) are written as JSON, e.g. for monitoring and dashboards. Also written for failed runs.")
+
+// phaseStats is the duration of a command which was run.
+type phaseStats struct {
+	Name    string  `json:"name"`
+	Arg     string  `json:"arg,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// runStats is the content of the -stats_json file.
+type runStats struct {
+	Start     time.Time    `json:"start"`
+	End       time.Time    `json:"end"`
+	Seconds   float64      `json:"seconds"`
+	Succeeded bool         `json:"succeeded"`
+	Error     string       `json:"error,omitempty"`
+	Phases    []phaseStats `json:"phases"`
+	Warnings  uint64       `json:"warnings"`
+	Errors    uint64       `json:"errors"`
+
+	// Packages and Stats are only present if packages were discovered.
+	// Stats uses the same field names as the -status_socket events.
+	Packages int    `json:"packages,omitempty"`
+	Stats    *stats `json:"stats,omitempty"`
+}
+
+// runStats returns the statistics of the run so far, which failed with
+// runErr if non-nil.
+func (p *pipeline) runStats(runErr error) runStats {
+	end := time.Now()
+	rs := runStats{
+		Start:     p.start,
+		End:       end,
+		Seconds:   end.Sub(p.start).Seconds(),
+		Succeeded: runErr == nil,
+		Phases:    p.phases,
+		Warnings:  uint64(logSummary.levelCount(levelWarning)),
+		Errors:    uint64(logSummary.levelCount(levelError)),
+	}
+	if rs.Phases == nil {
+		rs.Phases = []phaseStats{}
+	}
+	if runErr != nil {
+		rs.Error = runErr.Error()
+	}
+	if p.discovered {
+		rs.Packages = len(p.gv.pkgs)
+		rs.Stats = p.gv.stats
+	}
+	return rs
+}
+
+// writeStatsJSON writes the statistics of the run to path, see
+// -stats_json.
+func (p *pipeline) writeStatsJSON(path string, runErr error) error {
+	rs := p.runStats(runErr)
+	return writeAtomically(path, false, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rs)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteStatsJSON(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := &pipeline{
+		start: time.Now().Add(-1 * time.Minute),
+		gv: globalView{
+			pkgs: []*pkgEntry{{suite: "testing", binarypkg: "i3-wm"}},
+			stats: &stats{
+				ManpagesRendered: 2,
+				Suites: map[string]*suiteStats{
+					"testing": {ManpagesRendered: 2, HtmlBytes: 1024},
+				},
+			},
+		},
+		discovered: true,
+		phases: []phaseStats{
+			{Name: "fetch", Seconds: 1},
+			{Name: "render", Seconds: 2},
+		},
+	}
+	path := filepath.Join(tmpdir, "stats.json")
+	if err := p.writeStatsJSON(path, errors.New("index: disk full")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Succeeded bool
+		Error     string
+		Seconds   float64
+		Packages  int
+		Phases    []phaseStats
+		Stats     struct {
+			ManpagesRendered uint64
+			Suites           map[string]struct {
+				HtmlBytes uint64
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Succeeded || got.Error != "index: disk full" {
+		t.Errorf("unexpected result: succeeded = %v, error = %q", got.Succeeded, got.Error)
+	}
+	if got.Seconds < 60 {
+		t.Errorf("unexpected runtime: got %v, want >= 60", got.Seconds)
+	}
+	if got.Packages != 1 {
+		t.Errorf("unexpected number of packages: got %d, want 1", got.Packages)
+	}
+	if len(got.Phases) != 2 || got.Phases[1].Name != "render" || got.Phases[1].Seconds != 2 {
+		t.Errorf("unexpected phases: %+v", got.Phases)
+	}
+	if got.Stats.ManpagesRendered != 2 || got.Stats.Suites["testing"].HtmlBytes != 1024 {
+		t.Errorf("unexpected stats: %+v", got.Stats)
+	}
+
+	// Without discovery, there are no stats to write.
+	rs := (&pipeline{start: time.Now()}).runStats(nil)
+	if !rs.Succeeded || rs.Stats != nil || rs.Phases == nil {
+		t.Errorf("unexpected stats without discovery: %+v", rs)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var statusSocket = flag.String("status_socket",
+	"",
+	"If non-empty, path to a Unix domain socket on which progress events are streamed (as newline-delimited JSON) to every client")
+
+// progressTracker holds the progress of the current run. Updating it
+// is cheap and never blocks on clients of the status socket.
+type progressTracker struct {
+	// packagesProcessed, packagesTotal, bytesWritten and queueDepth
+	// are accessed atomically and must come first for 64-bit
+	// alignment on 32-bit platforms.
+	packagesProcessed uint64
+	packagesTotal     uint64
+	bytesWritten      uint64
+	queueDepth        int64
+
+	mu         sync.Mutex
+	stage      string
+	stageStart time.Time
+	suite      string
+	stats      *stats
+}
+
+var progress progressTracker
+
+func (p *progressTracker) setStage(stage string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage = stage
+	p.stageStart = time.Now()
+	p.suite = ""
+	atomic.StoreUint64(&p.packagesProcessed, 0)
+	atomic.StoreUint64(&p.packagesTotal, 0)
+}
+
+// setTotal sets the number of packages which the current stage
+// processes, if known, which is used to estimate its remaining time.
+func (p *progressTracker) setTotal(total uint64) {
+	atomic.StoreUint64(&p.packagesTotal, total)
+}
+
+func (p *progressTracker) setSuite(suite string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.suite = suite
+}
+
+func (p *progressTracker) setStats(s *stats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats = s
+}
+
+type progressEvent struct {
+	Time              time.Time `json:"time"`
+	Stage             string    `json:"stage"`
+	Suite             string    `json:"suite,omitempty"`
+	PackagesProcessed uint64    `json:"packages_processed"`
+	PackagesTotal     uint64    `json:"packages_total,omitempty"`
+	BytesWritten      uint64    `json:"bytes_written"`
+	QueueDepth        int64     `json:"queue_depth"`
+	Stats             *stats    `json:"stats,omitempty"`
+
+	// ETASeconds is the estimated remaining time of the stage, based
+	// on its throughput so far, or zero if unknown.
+	ETASeconds int64 `json:"eta_seconds,omitempty"`
+}
+
+func (p *progressTracker) event() progressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ev := progressEvent{
+		Time:              time.Now(),
+		Stage:             p.stage,
+		Suite:             p.suite,
+		PackagesProcessed: atomic.LoadUint64(&p.packagesProcessed),
+		PackagesTotal:     atomic.LoadUint64(&p.packagesTotal),
+		BytesWritten:      atomic.LoadUint64(&p.bytesWritten),
+		QueueDepth:        atomic.LoadInt64(&p.queueDepth),
+	}
+	ev.ETASeconds = int64(eta(ev.PackagesProcessed, ev.PackagesTotal, ev.Time.Sub(p.stageStart)).Seconds())
+	if p.stats != nil {
+		snapshot := p.stats.snapshot()
+		ev.Stats = &snapshot
+	}
+	return ev
+}
+
+// snapshot returns a copy of s which is safe to use while s is being
+// updated concurrently. While rendering, the totals of the per-suite
+// stats are not yet accumulated, so snapshot sums them up itself.
+func (s *stats) snapshot() stats {
+	snapshot := stats{
+		PackagesExtracted:       atomic.LoadUint64(&s.PackagesExtracted),
+		PackagesDeleted:         atomic.LoadUint64(&s.PackagesDeleted),
+		ManpagesRendered:        atomic.LoadUint64(&s.ManpagesRendered),
+		DisambiguationsRendered: atomic.LoadUint64(&s.DisambiguationsRendered),
+		MandocWarnings:          atomic.LoadUint64(&s.MandocWarnings),
+		DanglingReferences:      atomic.LoadUint64(&s.DanglingReferences),
+		ConversionTimeouts:      atomic.LoadUint64(&s.ConversionTimeouts),
+		ManpageBytes:            atomic.LoadUint64(&s.ManpageBytes),
+		HtmlBytes:               atomic.LoadUint64(&s.HtmlBytes),
+		BrotliBytes:             atomic.LoadUint64(&s.BrotliBytes),
+		ZstdBytes:               atomic.LoadUint64(&s.ZstdBytes),
+		IndexBytes:              atomic.LoadUint64(&s.IndexBytes),
+		HardlinkedFiles:         atomic.LoadUint64(&s.HardlinkedFiles),
+		HardlinkedBytes:         atomic.LoadUint64(&s.HardlinkedBytes),
+	}
+	if len(s.Suites) > 0 {
+		snapshot.Suites = make(map[string]*suiteStats, len(s.Suites))
+		for name, ss := range s.Suites {
+			snapshot.Suites[name] = &suiteStats{
+				ManpagesRendered: atomic.LoadUint64(&ss.ManpagesRendered),
+				ManpageBytes:     atomic.LoadUint64(&ss.ManpageBytes),
+				HtmlBytes:        atomic.LoadUint64(&ss.HtmlBytes),
+				BrotliBytes:      atomic.LoadUint64(&ss.BrotliBytes),
+				ZstdBytes:        atomic.LoadUint64(&ss.ZstdBytes),
+			}
+		}
+		snapshot.accumulate()
+	}
+	return snapshot
+}
+
+// serveStatus listens on the Unix domain socket path and streams a
+// progress event per second to each client until it disconnects.
+func serveStatus(path string) error {
+	// Remove the stale socket of a previous run, if any. Refuse to
+	// remove anything but sockets, and sockets on which another run is
+	// still listening.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%q exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("%q is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				warningf("status socket: %v", err)
+				return
+			}
+			go streamStatus(conn)
+		}
+	}()
+	return nil
+}
+
+func streamStatus(conn net.Conn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		// Give up on clients which do not read their events.
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := enc.Encode(progress.event()); err != nil {
+			return
+		}
+		<-ticker.C
+	}
+}
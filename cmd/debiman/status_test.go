@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStatusSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debiman-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "status.sock")
+	if err := serveStatus(path); err != nil {
+		t.Fatal(err)
+	}
+
+	progress.setStage("render")
+	progress.setSuite("jessie")
+	progress.setStats(&stats{ManpagesRendered: 42})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var ev progressEvent
+	if err := json.NewDecoder(conn).Decode(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ev.Stage, "render"; got != want {
+		t.Errorf("unexpected stage: got %q, want %q", got, want)
+	}
+	if got, want := ev.Suite, "jessie"; got != want {
+		t.Errorf("unexpected suite: got %q, want %q", got, want)
+	}
+	if ev.Stats == nil || ev.Stats.ManpagesRendered != 42 {
+		t.Errorf("unexpected stats: got %+v, want ManpagesRendered = 42", ev.Stats)
+	}
+
+	// The socket is in use, and regular files are not sockets: neither
+	// must be removed.
+	if err := serveStatus(path); err == nil {
+		t.Errorf("serveStatus(%q) unexpectedly took over the socket in use", path)
+	}
+	regular := filepath.Join(dir, "regular")
+	if err := ioutil.WriteFile(regular, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := serveStatus(regular); err == nil {
+		t.Errorf("serveStatus unexpectedly replaced the regular file %q", regular)
+	}
+	if _, err := os.Stat(regular); err != nil {
+		t.Errorf("regular file %q removed: %v", regular, err)
+	}
+}
+
+func TestSnapshotSuites(t *testing.T) {
+	s := &stats{
+		Suites: map[string]*suiteStats{
+			"jessie":  {ManpagesRendered: 2, ManpageBytes: 10, HtmlBytes: 20},
+			"stretch": {ManpagesRendered: 3, ManpageBytes: 30, HtmlBytes: 40},
+		},
+	}
+	snapshot := s.snapshot()
+	if got, want := snapshot.ManpagesRendered, uint64(5); got != want {
+		t.Errorf("ManpagesRendered: got %d, want %d", got, want)
+	}
+	if got, want := snapshot.ManpageBytes, uint64(40); got != want {
+		t.Errorf("ManpageBytes: got %d, want %d", got, want)
+	}
+	if got, want := snapshot.HtmlBytes, uint64(60); got != want {
+		t.Errorf("HtmlBytes: got %d, want %d", got, want)
+	}
+	if got, want := snapshot.Suites["stretch"].ManpagesRendered, uint64(3); got != want {
+		t.Errorf("stretch ManpagesRendered: got %d, want %d", got, want)
+	}
+	// The totals of s itself are only set by accumulate.
+	if got := s.ManpagesRendered; got != 0 {
+		t.Errorf("snapshot unexpectedly modified s: ManpagesRendered = %d", got)
+	}
+}
+
+func TestSnapshotCopiesAllCounters(t *testing.T) {
+	s := &stats{}
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Uint64 {
+			f.SetUint(uint64(i + 1))
+		}
+	}
+	snapshot := reflect.ValueOf(s.snapshot())
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() != reflect.Uint64 {
+			continue
+		}
+		if got, want := snapshot.Field(i).Uint(), v.Field(i).Uint(); got != want {
+			t.Errorf("%s: got %d, want %d", v.Type().Field(i).Name, got, want)
+		}
+	}
+}
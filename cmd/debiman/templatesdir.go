@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"text/template/parse"
+
+	"github.com/Debian/debiman/internal/commontmpl"
+)
+
+var templatesDir = flag.String("templates_dir",
+	"",
+	"If non-empty, a directory containing templates (e.g. manpage.tmpl, header.tmpl) which override the built-in templates. Templates which are not present in the directory fall back to the built-in versions.")
+
+// parseTemplates (re-)parses all templates, e.g. after bundled assets
+// were overwritten by -inject_assets or -templates_dir.
+func parseTemplates() {
+	commonTmpls = commontmpl.MustParseCommonTmpls()
+	contentsTmpl = mustParseContentsTmpl()
+	pkgindexTmpl = mustParsePkgindexTmpl()
+	indexTmpl = mustParseIndexTmpl()
+	faqTmpl = mustParseFaqTmpl()
+	aboutTmpl = mustParseAboutTmpl()
+	manpageTmpl = mustParseManpageTmpl()
+	manpageerrorTmpl = mustParseManpageerrorTmpl()
+	manpagefooterextraTmpl = mustParseManpagefooterextraTmpl()
+	disambiguationTmpl = mustParseDisambiguationTmpl()
+	infodocTmpl = mustParseInfodocTmpl()
+	infoindexTmpl = mustParseInfoindexTmpl()
+	browseTmpl = mustParseBrowseTmpl()
+	diffTmpl = mustParseDiffTmpl()
+}
+
+// validateTemplates returns an error if any of the templates used for
+// rendering is empty or invokes a named template which is not defined.
+// It must be called before any of the templates is executed, as
+// html/template rewrites template invocations when escaping.
+func validateTemplates() error {
+	for _, t := range []*template.Template{
+		contentsTmpl,
+		pkgindexTmpl,
+		indexTmpl,
+		faqTmpl,
+		aboutTmpl,
+		manpageTmpl,
+		manpageerrorTmpl,
+		manpagefooterextraTmpl,
+		disambiguationTmpl,
+		infodocTmpl,
+		infoindexTmpl,
+		browseTmpl,
+		diffTmpl,
+	} {
+		if err := validateTemplate(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTemplate(t *template.Template) error {
+	if t.Tree == nil || len(t.Tree.Root.Nodes) == 0 {
+		return fmt.Errorf("template %q is empty", t.Name())
+	}
+	for _, assoc := range t.Templates() {
+		if assoc.Tree == nil {
+			continue
+		}
+		refs := make(map[string]bool)
+		templateRefs(assoc.Tree.Root, refs)
+		for name := range refs {
+			if t.Lookup(name) == nil {
+				return fmt.Errorf("template %q: %q invokes undefined template %q", t.Name(), assoc.Name(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// templateRefs adds the names of all templates which are invoked
+// within n to refs.
+func templateRefs(n parse.Node, refs map[string]bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateRefs(child, refs)
+		}
+	case *parse.IfNode:
+		templateRefs(n.List, refs)
+		templateRefs(n.ElseList, refs)
+	case *parse.RangeNode:
+		templateRefs(n.List, refs)
+		templateRefs(n.ElseList, refs)
+	case *parse.WithNode:
+		templateRefs(n.List, refs)
+		templateRefs(n.ElseList, refs)
+	case *parse.TemplateNode:
+		refs[n.Name] = true
+	}
+}
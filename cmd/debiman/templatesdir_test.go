@@ -0,0 +1,33 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	valid := template.Must(template.New("page").Parse(`{{ define "header" }}<h1>{{ . }}</h1>{{ end }}{{ if . }}{{ template "header" . }}{{ end }}`))
+	if err := validateTemplate(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{
+		`{{ template "header" . }}`,
+		`{{ range . }}{{ else }}{{ template "header" . }}{{ end }}`,
+		`{{ define "header" }}<h1>{{ . }}</h1>{{ end }}`,
+	} {
+		tmpl := template.Must(template.New("page").Parse(content))
+		if err := validateTemplate(tmpl); err == nil {
+			t.Errorf("validateTemplate(%q) unexpectedly succeeded", content)
+		}
+	}
+}
+
+func TestValidateBundledTemplates(t *testing.T) {
+	// Other tests might have executed (and thereby escaped) the
+	// templates already.
+	parseTemplates()
+	if err := validateTemplates(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Debian/debiman/internal/manpage"
+	"github.com/Debian/debiman/internal/sitemap"
+)
+
+// verifyExamples is the maximum number of problems printed per check.
+const verifyExamples = 20
+
+// verifyReport collects the violated invariants of a serving directory.
+type verifyReport struct {
+	manpages int
+	problems map[string][]string // by check
+}
+
+func (r *verifyReport) add(check, format string, v ...interface{}) {
+	if r.problems == nil {
+		r.problems = make(map[string][]string)
+	}
+	r.problems[check] = append(r.problems[check], fmt.Sprintf(format, v...))
+}
+
+// count returns the total number of problems.
+func (r *verifyReport) count() int {
+	var n int
+	for _, p := range r.problems {
+		n += len(p)
+	}
+	return n
+}
+
+func (r *verifyReport) print() {
+	fmt.Printf("manpages verified: %d\n", r.manpages)
+	checks := make([]string, 0, len(r.problems))
+	for check := range r.problems {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		problems := r.problems[check]
+		fmt.Printf("%s: %d\n", check, len(problems))
+		for i, p := range problems {
+			if i == verifyExamples {
+				fmt.Printf("  … and %d more\n", len(problems)-verifyExamples)
+				break
+			}
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	fmt.Printf("problems found: %d\n", r.count())
+}
+
+// verifyPackage checks the binary package directory dir.
+func verifyPackage(r *verifyReport, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var hasManpages bool
+	for _, fi := range fis {
+		fn := fi.Name()
+		full := filepath.Join(dir, fn)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(full); err != nil {
+				r.add("dangling symlinks", "%s: %v", full, err)
+				continue
+			}
+		}
+		if !strings.HasSuffix(fn, ".gz") || isRenderedOutput(fn) {
+			continue
+		}
+		if _, err := manpage.FromServingPath(*servingDir, full); err != nil {
+			continue // e.g. a file which a .so line refers to
+		}
+		hasManpages = true
+		r.manpages++
+		html := strings.TrimSuffix(full, ".gz") + htmlSuffix()
+		htmlst, err := os.Stat(html)
+		if err != nil {
+			r.add("manpages without rendered version", "%s", full)
+			continue
+		}
+		// Like render, compare against the manpage itself, not the
+		// target of its symlink.
+		if htmlst.ModTime().Before(fi.ModTime()) {
+			r.add("manpages with outdated rendered version", "%s (%v) is older than %s (%v)", html, htmlst.ModTime(), full, fi.ModTime())
+		}
+	}
+	if hasManpages {
+		index := filepath.Join(dir, "index"+htmlSuffix())
+		if _, err := os.Stat(index); err != nil {
+			r.add("missing package indexes", "%s", index)
+		}
+	}
+	return nil
+}
+
+// locationPath returns the path within -serving_dir which the sitemap
+// location loc refers to.
+func locationPath(loc string) (string, error) {
+	if !strings.HasPrefix(loc, *baseURL+"/") {
+		return "", fmt.Errorf("not below -base_url %q", *baseURL)
+	}
+	u, err := url.Parse(strings.TrimPrefix(loc, *baseURL))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(*servingDir, filepath.FromSlash(u.Path))
+	if strings.HasSuffix(path, ".html") {
+		path = strings.TrimSuffix(path, ".html") + htmlSuffix()
+	}
+	return path, nil
+}
+
+// verifySitemap checks that all locations of the (gzip-compressed)
+// sitemap or sitemap index path exist.
+func verifySitemap(r *verifyReport, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	locs, err := sitemap.Locations(gr)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, loc := range locs {
+		target, err := locationPath(loc)
+		if err != nil {
+			r.add("invalid sitemap locations", "%s: %q: %v", path, loc, err)
+			continue
+		}
+		if _, err := os.Stat(target); err != nil {
+			r.add("sitemap locations without file", "%s: %q: %v", path, loc, err)
+		}
+	}
+	return nil
+}
+
+// verifySuite checks the suite directory dir.
+func verifySuite(r *verifyReport, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var packages int
+	for _, fi := range fis {
+		full := filepath.Join(dir, fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(full); err != nil {
+				r.add("dangling symlinks", "%s: %v", full, err)
+			}
+			continue
+		}
+		if !fi.IsDir() || isTombstone(full) {
+			continue
+		}
+		packages++
+		if err := verifyPackage(r, full); err != nil {
+			return err
+		}
+	}
+	if packages == 0 {
+		return nil
+	}
+	sitemapPath := filepath.Join(dir, "sitemap.xml.gz")
+	if _, err := os.Stat(sitemapPath); err != nil {
+		r.add("missing sitemaps", "%s", sitemapPath)
+		return nil
+	}
+	if err := verifySitemap(r, sitemapPath); err != nil {
+		r.add("unparsable sitemaps", "%v", err)
+	}
+	return nil
+}
+
+// nonSuiteDirs are the top-level directories of -serving_dir which
+// do not contain a suite, see renderinfo.go, clientsearch.go and
+// renderdisambiguation.go.
+var nonSuiteDirs = map[string]bool{
+	"info":           true,
+	"search-index":   true,
+	"disambiguation": true,
+}
+
+// verifyServingDir checks the invariants of -serving_dir: every manpage
+// has a rendered version which is not older than the manpage, all
+// symlinks resolve, every package with manpages has an index, and all
+// sitemaps only reference existing files.
+func verifyServingDir(suites []string) (*verifyReport, error) {
+	r := &verifyReport{}
+	discover := suites == nil
+	fis, err := ioutil.ReadDir(*servingDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		fn := fi.Name()
+		full := filepath.Join(*servingDir, fn)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(full); err != nil {
+				r.add("dangling symlinks", "%s: %v", full, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(fn, "sitemapindex") && strings.HasSuffix(fn, ".xml.gz") {
+			if err := verifySitemap(r, full); err != nil {
+				r.add("unparsable sitemaps", "%v", err)
+			}
+			continue
+		}
+		if discover && fi.IsDir() && !strings.HasPrefix(fn, ".") && !nonSuiteDirs[fn] {
+			suites = append(suites, fn)
+		}
+	}
+	sort.Strings(suites)
+	for _, suite := range suites {
+		dir := filepath.Join(*servingDir, suite)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			r.add("missing suites", "%s", dir)
+			continue
+		}
+		if err := verifySuite(r, dir); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// verify checks the invariants of -serving_dir and returns an error if
+// any are violated, e.g. before a deployment switches to it. When run
+// after fetch, only the configured suites are checked, otherwise all
+// directories of -serving_dir.
+func (p *pipeline) verify() error {
+	progress.setStage("verify")
+	var suites []string
+	if p.discovered {
+		for suite := range p.gv.suites {
+			suites = append(suites, suite)
+		}
+	}
+	infof("Verifying %q", *servingDir)
+	r, err := verifyServingDir(suites)
+	if err != nil {
+		return err
+	}
+	r.print()
+	if n := r.count(); n > 0 {
+		return fmt.Errorf("verify: %d problems found in %q", n, *servingDir)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Debian/debiman/internal/sitemap"
+)
+
+func TestVerifyServingDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+
+	for _, path := range []string{
+		"testing/i3-wm/i3.1.en.gz",
+		"testing/i3-wm/i3.1.en.html.gz",
+		"testing/i3-wm/index.html.gz",
+		"testing/i3lock/i3lock.1.en.gz",
+		"testing/i3lock/i3lock.1.en.html.gz",
+		"testing/i3lock/index.html.gz",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("manpage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("testing", filepath.Join(tmpdir, "buster")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeSitemap := func(pkgs ...string) {
+		contents := make(map[string]time.Time)
+		for _, pkg := range pkgs {
+			contents[pkg] = time.Now()
+		}
+		var buf bytes.Buffer
+		if err := sitemap.WriteTo(&buf, *baseURL+"/testing", contents); err != nil {
+			t.Fatal(err)
+		}
+		writeGzipped(t, filepath.Join(tmpdir, "testing", "sitemap.xml.gz"), buf.String())
+	}
+	writeSitemap("i3-wm", "i3lock")
+
+	r, err := verifyServingDir(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.manpages, 2; got != want {
+		t.Fatalf("unexpected number of verified manpages: got %d, want %d", got, want)
+	}
+	if n := r.count(); n != 0 {
+		t.Fatalf("unexpected problems in a consistent serving directory: %v", r.problems)
+	}
+
+	// Break every invariant once.
+	future := time.Now().Add(1 * time.Hour)
+	if err := os.Chtimes(filepath.Join(tmpdir, "testing/i3-wm/i3.1.en.gz"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(tmpdir, "testing/i3lock/i3lock.1.en.html.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(tmpdir, "testing/i3lock/index.html.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("i3.1.en.gz", filepath.Join(tmpdir, "testing/i3-wm/i3.1.de.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("nonexistent.1.en.gz", filepath.Join(tmpdir, "testing/i3-wm/i3-msg.1.en.gz")); err != nil {
+		t.Fatal(err)
+	}
+	writeSitemap("i3-wm", "i3lock", "oldtool")
+
+	r, err = verifyServingDir([]string{"testing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(r.problems))
+	for check, problems := range r.problems {
+		for range problems {
+			got = append(got, check)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"dangling symlinks",
+		"manpages with outdated rendered version",
+		"manpages without rendered version",
+		// i3.1.de.gz, whose rendered version is missing as well.
+		"manpages without rendered version",
+		"missing package indexes",
+		// The missing i3lock index and oldtool.
+		"sitemap locations without file",
+		"sitemap locations without file",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected problems: got %q, want %q (details: %v)", got, want, r.problems)
+	}
+}
+
+func TestVerifyServingDirAllSuites(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	oldServingDir := *servingDir
+	defer flag.Set("serving_dir", oldServingDir)
+	flag.Set("serving_dir", tmpdir)
+
+	for _, path := range []string{
+		"bookworm/i3-wm/i3.1.en.gz",
+		"bookworm/i3-wm/i3.1.en.html.gz",
+		"bookworm/i3-wm/index.html.gz",
+		"sid/i3-wm/i3.1.en.gz",
+		"sid/i3-wm/i3.1.en.html.gz",
+		"sid/i3-wm/index.html.gz",
+		// The rendered version is missing in the last suite.
+		"trixie/i3-wm/i3.1.en.gz",
+		"trixie/i3-wm/index.html.gz",
+		// Neither of these directories contains a suite.
+		"info/sid/i3-wm/index.html.gz",
+		"search-index/sid/index.json",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("manpage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, suite := range []string{"bookworm", "sid", "trixie"} {
+		var buf bytes.Buffer
+		if err := sitemap.WriteTo(&buf, *baseURL+"/"+suite, map[string]time.Time{"i3-wm": time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		writeGzipped(t, filepath.Join(tmpdir, suite, "sitemap.xml.gz"), buf.String())
+	}
+
+	r, err := verifyServingDir(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.manpages, 3; got != want {
+		t.Fatalf("unexpected number of verified manpages: got %d, want %d", got, want)
+	}
+	got := make([]string, 0, len(r.problems))
+	for check, problems := range r.problems {
+		for range problems {
+			got = append(got, check)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"manpages without rendered version"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected problems: got %q, want %q (details: %v)", got, want, r.problems)
+	}
+}
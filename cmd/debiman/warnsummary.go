@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var failOnWarnings = flag.Int("fail_on_warnings",
+	0,
+	"If positive, fail the run (i.e. exit with a non-zero status after printing the summary of warnings and errors) if it logged at least this many warnings and errors, such as unparsable manpages, failed stat(2) calls or manpages which mandoc could not convert. Useful for CI-style deployments.")
+
+// warningKind aggregates the warnings or errors logged with the same
+// format string.
+type warningKind struct {
+	level   logLevel
+	format  string
+	count   int
+	example string // the first message
+}
+
+type byCount []*warningKind
+
+func (p byCount) Len() int      { return len(p) }
+func (p byCount) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byCount) Less(i, j int) bool {
+	if p[i].count != p[j].count {
+		return p[i].count > p[j].count
+	}
+	return p[i].format < p[j].format
+}
+
+// warningSummary collects the non-fatal warnings and errors of a run, so
+// that they can be summarized at its end instead of only being
+// interleaved with all other log messages.
+type warningSummary struct {
+	mu    sync.Mutex
+	kinds map[string]*warningKind // by level and format string
+}
+
+// logSummary collects the warnings and errors logged by leveledLogger.
+var logSummary = &warningSummary{}
+
+func (s *warningSummary) record(level logLevel, format, msg string) {
+	key := logLevelPrefixes[level] + format
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kinds == nil {
+		s.kinds = make(map[string]*warningKind)
+	}
+	kind, ok := s.kinds[key]
+	if !ok {
+		kind = &warningKind{level: level, format: format, example: msg}
+		s.kinds[key] = kind
+	}
+	kind.count++
+}
+
+// sorted returns the kinds of warnings and errors, most frequent first.
+func (s *warningSummary) sorted() []*warningKind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds := make([]*warningKind, 0, len(s.kinds))
+	for _, kind := range s.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Sort(byCount(kinds))
+	return kinds
+}
+
+// count returns the number of warnings and errors.
+func (s *warningSummary) count() int {
+	return s.levelCount(levelWarning) + s.levelCount(levelError)
+}
+
+// levelCount returns the number of messages of level.
+func (s *warningSummary) levelCount(level logLevel) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, kind := range s.kinds {
+		if kind.level == level {
+			n += kind.count
+		}
+	}
+	return n
+}
+
+// print prints the number of warnings and errors of each kind, along
+// with an example message.
+func (s *warningSummary) print() {
+	if s.count() == 0 {
+		return
+	}
+	fmt.Printf("warnings and errors:      %d\n", s.count())
+	for _, kind := range s.sorted() {
+		fmt.Printf("  %6d %s%s\n", kind.count, logLevelPrefixes[kind.level], kind.example)
+	}
+}
+
+// check returns an error if the run logged too many warnings and errors,
+// see -fail_on_warnings.
+func (s *warningSummary) check() error {
+	if *failOnWarnings <= 0 {
+		return nil
+	}
+	if n := s.count(); n >= *failOnWarnings {
+		return fmt.Errorf("%d warnings and errors logged, failing because of -fail_on_warnings=%d", n, *failOnWarnings)
+	}
+	return nil
+}
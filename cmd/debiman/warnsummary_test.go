@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestWarningSummary(t *testing.T) {
+	defer func(old *warningSummary) { logSummary = old }(logSummary)
+	logSummary = &warningSummary{}
+	defer func(old logLevel) { minLogLevel = old }(minLogLevel)
+	minLogLevel = levelError
+	defer func(old int) { *failOnWarnings = old }(*failOnWarnings)
+
+	var buf bytes.Buffer
+	l := &leveledLogger{log.New(&buf, "testing/i3-wm: ", 0)}
+	l.infof("rendered %q", "i3.1")
+	l.warningf("stat %q: %v", "i3.1.en.gz", "permission denied")
+	l.warningf("stat %q: %v", "i3-msg.1.en.gz", "permission denied")
+	l.errorf("BUG: cannot parse manpage from serving path %q", "i3.1")
+
+	if got, want := buf.String(), "testing/i3-wm: ERROR: BUG: cannot parse manpage from serving path \"i3.1\"\n"; got != want {
+		t.Errorf("unexpected log output: got %q, want %q", got, want)
+	}
+
+	if got, want := logSummary.count(), 3; got != want {
+		t.Fatalf("unexpected number of warnings and errors: got %d, want %d", got, want)
+	}
+	if got, want := logSummary.levelCount(levelWarning), 2; got != want {
+		t.Errorf("unexpected number of warnings: got %d, want %d", got, want)
+	}
+	if got, want := logSummary.levelCount(levelError), 1; got != want {
+		t.Errorf("unexpected number of errors: got %d, want %d", got, want)
+	}
+	kinds := logSummary.sorted()
+	if len(kinds) != 2 {
+		t.Fatalf("unexpected number of kinds: got %d, want 2", len(kinds))
+	}
+	if got, want := kinds[0].count, 2; got != want {
+		t.Errorf("unexpected count of the most frequent kind: got %d, want %d", got, want)
+	}
+	if got, want := kinds[0].example, `testing/i3-wm: stat "i3.1.en.gz": permission denied`; got != want {
+		t.Errorf("unexpected example: got %q, want %q", got, want)
+	}
+	if got, want := kinds[1].level, levelError; got != want {
+		t.Errorf("unexpected level of the least frequent kind: got %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		threshold int
+		wantErr   bool
+	}{
+		{0, false},
+		{3, true},
+		{4, false},
+	} {
+		*failOnWarnings = tt.threshold
+		if err := logSummary.check(); (err != nil) != tt.wantErr {
+			t.Errorf("-fail_on_warnings=%d: got err %v, want error: %v", tt.threshold, err, tt.wantErr)
+		}
+	}
+}
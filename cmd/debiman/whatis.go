@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Debian/debiman/internal/convert"
+	"github.com/Debian/debiman/internal/manpage"
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+var writeWhatis = flag.Bool("whatis",
+	false,
+	"Extract the NAME one-liner of each rendered manpage into a whatis database per suite: whatis-<suite>.json, which debiman-auxserver -whatis uses for keyword search, and whatis-<suite>.txt, which contains the English manpages in the format of man-db’s whatis(1).")
+
+// whatisDB collects the NAME sections of the rendered manpages, keyed by
+// suite and serving path.
+type whatisDB struct {
+	mu      sync.Mutex
+	entries map[string]map[string]whatis.Entry
+}
+
+func newWhatisDB() *whatisDB {
+	return &whatisDB{
+		entries: make(map[string]map[string]whatis.Entry),
+	}
+}
+
+// record stores the NAME section of content, the rendered manpage m,
+// replacing any previously recorded entry for m.
+func (w *whatisDB) record(m *manpage.Meta, content string) {
+	names, description, err := convert.Whatis(content)
+	if err != nil {
+		warningf("extracting NAME section of %q: %v", m.ServingPath(), err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	bySuite, ok := w.entries[m.Package.Suite]
+	if !ok {
+		bySuite = make(map[string]whatis.Entry)
+		w.entries[m.Package.Suite] = bySuite
+	}
+	// An entry without names removes m from the database.
+	bySuite[m.ServingPath()] = whatis.Entry{
+		Names:       names,
+		Section:     m.Section,
+		Language:    m.Language,
+		Binarypkg:   m.Package.Binarypkg,
+		Description: description,
+		ServingPath: m.ServingPath(),
+	}
+}
+
+func whatisPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("whatis-%s.json", suite))
+}
+
+func whatisManDBPath(destDir, suite string) string {
+	return filepath.Join(destDir, fmt.Sprintf("whatis-%s.txt", suite))
+}
+
+// write merges the recorded entries into the whatis databases of each
+// suite of gv in destDir. Manpages which were not re-rendered in this
+// run keep their previous entries, manpages which are no longer part of
+// gv are removed.
+func (w *whatisDB) write(destDir string, gv globalView) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	current := make(map[string]bool)
+	for _, versions := range gv.xref {
+		for _, v := range versions {
+			current[v.ServingPath()] = true
+		}
+	}
+	for suite := range gv.suites {
+		path := whatisPath(destDir, suite)
+		existing, err := whatis.Load(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		recorded := w.entries[suite]
+		if os.IsNotExist(err) && len(recorded) == 0 {
+			continue
+		}
+		merged := make(map[string]whatis.Entry, len(existing)+len(recorded))
+		for _, e := range existing {
+			merged[e.ServingPath] = e
+		}
+		for servingPath, e := range recorded {
+			merged[servingPath] = e
+		}
+		entries := make([]whatis.Entry, 0, len(merged))
+		for servingPath, e := range merged {
+			if len(e.Names) == 0 || !current[servingPath] {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		recordArtifact(artifactData, path)
+		if err := writeAtomically(path, false, func(w io.Writer) error {
+			return whatis.WriteJSON(w, entries)
+		}); err != nil {
+			return err
+		}
+		recordArtifact(artifactData, whatisManDBPath(destDir, suite))
+		if err := writeAtomically(whatisManDBPath(destDir, suite), false, func(w io.Writer) error {
+			return whatis.WriteManDB(w, entries)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
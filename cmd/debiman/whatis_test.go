@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+// testI3NameSection is the NAME section of i3(1) as rendered by mandoc.
+const testI3NameSection = `<h1 class="Sh" id="NAME">NAME</h1><b>i3</b> - an improved dynamic, tiling window manager`
+
+// testI3barServingPath is the serving path of i3bar(1), which tests use
+// as a manpage that was removed from the suite since the previous run.
+const testI3barServingPath = "jessie/i3-wm/i3bar.1.en"
+
+func TestWhatisDB(t *testing.T) {
+	tmpdir, cleanup := newTestServingDir(t)
+	defer cleanup()
+
+	// i3bar(1) was removed from the suite since the previous run.
+	previous := []whatis.Entry{
+		{Names: []string{"i3"}, Section: "1", Language: "en", Description: "outdated", ServingPath: "jessie/i3-wm/i3.1.en"},
+		{Names: []string{"i3bar"}, Section: "1", Language: "en", Description: "xorg-specific workspace bar", ServingPath: testI3barServingPath},
+		{Names: []string{"i3-msg"}, Section: "1", Language: "en", Binarypkg: "i3-wm", Description: "send messages to i3 window manager", ServingPath: "jessie/i3-wm/i3-msg.1.en"},
+	}
+	if err := writeAtomically(whatisPath(tmpdir, "jessie"), false, func(w io.Writer) error {
+		return whatis.WriteJSON(w, append([]whatis.Entry(nil), previous...))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gv := newTestI3View(t, "man1/i3-dump-log.1.gz")
+	db := newWhatisDB()
+	db.record(gv.xref["i3"][0], testI3NameSection)
+	db.record(gv.xref["i3-dump-log"][0], `<h1 class="Sh" id="DESCRIPTION">DESCRIPTION</h1>no NAME section`)
+	if err := db.write(tmpdir, gv); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := whatis.Load(whatisPath(tmpdir, "jessie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []whatis.Entry{
+		previous[2],
+		{Names: []string{"i3"}, Section: "1", Language: "en", Binarypkg: "i3-wm", Description: "an improved dynamic, tiling window manager", ServingPath: "jessie/i3-wm/i3.1.en"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected whatis entries: got %+v, want %+v", got, want)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, "whatis-jessie.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "i3 (1) - an improved dynamic, tiling window manager\ni3-msg (1) - send messages to i3 window manager\n"; got != want {
+		t.Fatalf("unexpected whatis-jessie.txt: got %q, want %q", got, want)
+	}
+}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/Debian/debiman/internal/pgzip"
 )
 
+// parallelGzipThreshold is the uncompressed size (in bytes) starting
+// at which writeAtomicallyLarge compresses using all CPU cores.
+const parallelGzipThreshold = 4 * pgzip.BlockSize
+
 func tempDir(dest string) string {
 	tempdir := os.Getenv("TMPDIR")
 	if tempdir == "" {
@@ -20,7 +29,15 @@ func tempDir(dest string) string {
 	return tempdir
 }
 
-func writeAtomically(dest string, compress bool, write func(w io.Writer) error) (err error) {
+func writeAtomically(dest string, compress bool, write func(w io.Writer) error) error {
+	return writeAtomicallyVerified(dest, compress, write, nil)
+}
+
+// writeAtomicallyVerified is like writeAtomically, but if verify is
+// non-nil, it is called with the contents of the file as written to
+// disk (i.e. compressed, if compress is true). If verify returns an
+// error, dest is left untouched.
+func writeAtomicallyVerified(dest string, compress bool, write func(w io.Writer) error, verify func(r io.Reader) error) (err error) {
 	f, err := ioutil.TempFile(tempDir(dest), "debiman-")
 	if err != nil {
 		return err
@@ -33,6 +50,15 @@ func writeAtomically(dest string, compress bool, write func(w io.Writer) error)
 	}()
 	defer f.Close()
 
+	var sibs siblings
+	if compress {
+		if sibs, err = newSiblings(dest); err != nil {
+			return err
+		}
+		defer sibs.cleanup()
+		write = sibs.wrap(write)
+	}
+
 	bufw := bufio.NewWriter(f)
 
 	w := io.Writer(bufw)
@@ -71,9 +97,50 @@ func writeAtomically(dest string, compress bool, write func(w io.Writer) error)
 		return err
 	}
 
-	return os.Rename(f.Name(), dest)
+	if verify != nil {
+		if err := verifyFile(f.Name(), verify); err != nil {
+			return fmt.Errorf("verifying %q: %v", dest, err)
+		}
+	}
+
+	// Commit the siblings first, so that they are re-rendered along
+	// with dest if debiman is interrupted.
+	if err := sibs.commit(); err != nil {
+		return err
+	}
+
+	return commitFile(f.Name(), dest)
 }
 
+func verifyFile(path string, verify func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verify(bufio.NewReader(f))
+}
+
+// gunzipped wraps verify such that it is called with the decompressed
+// contents of a gzip file.
+func gunzipped(verify func(r io.Reader) error) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		gzipr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gzipr.Close()
+		if err := verify(gzipr); err != nil {
+			return err
+		}
+		// Read until EOF so that the gzip checksum is verified.
+		_, err = io.Copy(ioutil.Discard, gzipr)
+		return err
+	}
+}
+
+// writeAtomicallyWithGz is like writeAtomically, but re-uses gzipw to
+// compress dest, unless dest does not end in .gz.
 func writeAtomicallyWithGz(dest string, gzipw *gzip.Writer, write func(w io.Writer) error) (err error) {
 	f, err := ioutil.TempFile(tempDir(dest), "debiman-")
 	if err != nil {
@@ -87,15 +154,30 @@ func writeAtomicallyWithGz(dest string, gzipw *gzip.Writer, write func(w io.Writ
 	}()
 	defer f.Close()
 
+	sibs, err := newSiblings(dest)
+	if err != nil {
+		return err
+	}
+	defer sibs.cleanup()
+	write = sibs.wrap(write)
+
 	bufw := bufio.NewWriter(f)
-	gzipw.Reset(bufw)
+	// dest is not compressed with -no_compress.
+	compress := strings.HasSuffix(dest, ".gz")
+	w := io.Writer(bufw)
+	if compress {
+		gzipw.Reset(bufw)
+		w = gzipw
+	}
 
-	if err := write(gzipw); err != nil {
+	if err := write(w); err != nil {
 		return err
 	}
 
-	if err := gzipw.Close(); err != nil {
-		return err
+	if compress {
+		if err := gzipw.Close(); err != nil {
+			return err
+		}
 	}
 
 	if err := bufw.Flush(); err != nil {
@@ -110,5 +192,45 @@ func writeAtomicallyWithGz(dest string, gzipw *gzip.Writer, write func(w io.Writ
 		return err
 	}
 
-	return os.Rename(f.Name(), dest)
+	// Commit the siblings first, so that they are re-rendered along
+	// with dest if debiman is interrupted.
+	if err := sibs.commit(); err != nil {
+		return err
+	}
+
+	return commitFile(f.Name(), dest)
+}
+
+// writeAtomicallyLarge is like writeAtomically with compression
+// enabled (unless dest does not end in .gz), but compresses files
+// which are larger than parallelGzipThreshold in parallel. This is
+// worthwhile for files such as contents-<suite>.html.gz, which are
+// written at the end of a run and would otherwise keep a single CPU
+// core busy. If verify is non-nil, it is called with the uncompressed
+// file contents as read back from disk (see writeAtomicallyVerified).
+func writeAtomicallyLarge(dest string, write func(w io.Writer) error, verify func(r io.Reader) error) error {
+	if !strings.HasSuffix(dest, ".gz") {
+		// dest is not compressed with -no_compress.
+		return writeAtomicallyVerified(dest, false, write, verify)
+	}
+	if verify != nil {
+		verify = gunzipped(verify)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if buf.Len() < parallelGzipThreshold {
+		// writeAtomicallyVerified writes the siblings.
+		return writeAtomicallyVerified(dest, true, func(w io.Writer) error {
+			_, err := buf.WriteTo(w)
+			return err
+		}, verify)
+	}
+	if err := writeSiblings(dest, buf.Bytes()); err != nil {
+		return err
+	}
+	return writeAtomicallyVerified(dest, false, func(w io.Writer) error {
+		return pgzip.Compress(w, buf.Bytes(), gzip.BestCompression)
+	}, verify)
 }
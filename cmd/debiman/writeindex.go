@@ -2,15 +2,17 @@ package main
 
 import (
 	"io"
+	"sort"
 	"sync/atomic"
 
+	"github.com/Debian/debiman/internal/manpage"
 	pb "github.com/Debian/debiman/internal/proto"
 	"github.com/golang/protobuf/proto"
 )
 
-// writeIndex serializes an index for the redirect package (used in
-// debiman-auxserver) to dest.
-func writeIndex(dest string, gv globalView) error {
+// newIndex returns the index for the redirect package (used in
+// debiman-auxserver) of all manpages in gv.
+func newIndex(gv globalView) *pb.Index {
 	idx := &pb.Index{
 		Entry: make([]*pb.IndexEntry, 0, len(gv.xref)),
 	}
@@ -18,6 +20,12 @@ func writeIndex(dest string, gv globalView) error {
 	langs := make(map[string]bool)
 	sections := make(map[string]bool)
 	for _, x := range gv.xref {
+		if popcon != nil {
+			// debiman-auxserver redirects to the first of multiple
+			// binary packages shipping a manpage.
+			x = append([]*manpage.Meta(nil), x...)
+			sort.Stable(byBinarypkg(x))
+		}
 		for _, m := range x {
 			idx.Entry = append(idx.Entry, &pb.IndexEntry{
 				Name:      m.Name,
@@ -41,8 +49,13 @@ func writeIndex(dest string, gv globalView) error {
 	}
 
 	idx.Suite = gv.idxSuites
+	return idx
+}
 
-	idxb, err := proto.Marshal(idx)
+// writeIndex serializes an index for the redirect package (used in
+// debiman-auxserver) to dest.
+func writeIndex(dest string, gv globalView) error {
+	idxb, err := proto.Marshal(newIndex(gv))
 	if err != nil {
 		return err
 	}
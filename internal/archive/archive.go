@@ -15,13 +15,24 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"xi2.org/x/xz"
 
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
 	"pault.ag/go/archive"
 )
 
+// Warningf logs a warning, e.g. when rotating to another mirror.
+// Programs can replace it to count warnings along with their own; by
+// default, warnings are logged using the standard logger.
+var Warningf = func(format string, v ...interface{}) {
+	log.Output(2, "WARNING: "+fmt.Sprintf(format, v...))
+}
+
 type pool struct {
 	ch chan bool
 }
@@ -42,24 +53,161 @@ func (p *pool) unlock() {
 	<-p.ch
 }
 
+// Default configuration of a Getter, i.e. the Debian archive.
+const (
+	DefaultMirrorURL        = "http://deb.debian.org/debian/"
+	DefaultKeyring          = "/usr/share/keyrings/debian-archive-keyring.gpg"
+	DefaultRetriesTransient = 3
+)
+
 type Getter struct {
 	ConnectionsPerMirror int
-	RetriesTransient     int
-	Mirrors              []string
-	LocalMirror          string
+
+	// LocalMirror is the file system path of a mirror which, if
+	// non-empty, is used instead of MirrorURL and Mirrors.
+	LocalMirror string
+
+	// RetriesTransient is the number of times a download which failed
+	// with a transient error (e.g. a connection reset or HTTP status
+	// 503) is retried before rotating to the next mirror. If zero,
+	// DefaultRetriesTransient is used; if negative, downloads are not
+	// retried.
+	RetriesTransient int
+
+	// Backoff is the delay before the first retry of a download. Each
+	// further retry waits twice as long as the previous one.
+	Backoff time.Duration
+
+	// Timeout is the maximum time a single HTTP request (including
+	// reading the response body) may take. If zero, there is no
+	// timeout.
+	Timeout time.Duration
+
+	// MirrorURL is the URL of the archive (with trailing slash) from
+	// which Release files and packages are downloaded. If empty, the
+	// Debian archive is used. Besides HTTP(S) URLs, file:// URLs and
+	// file system paths (e.g. /srv/mirrors/debian/) are supported.
+	MirrorURL string
+
+	// Mirrors are the URLs (with trailing slash) of further mirrors of
+	// the archive at MirrorURL. When downloading a file from a mirror
+	// fails (e.g. because it is unreachable or serves a file whose
+	// checksum does not match), the Getter rotates to the next mirror.
+	Mirrors []string
+
+	// Keyring is the path to the keyring which Release files must be
+	// signed with. If empty, DefaultKeyring is used.
+	Keyring string
+
+	// Insecure disables the verification of Release file signatures,
+	// which is required to trust the checksums of index files (and
+	// thereby of packages) downloaded from (possibly compromised)
+	// mirrors.
+	Insecure bool
+
+	// CacheDir is the directory in which GetIndex caches uncompressed
+	// index files. If empty, index files are not cached.
+	CacheDir string
+
+	// PackageCache, if non-nil, is used by GetPackage to avoid
+	// downloading packages repeatedly.
+	PackageCache *PackageCache
 
 	once    sync.Once
 	pool    *pool
+	client  *http.Client
 	keyring openpgp.EntityList
 
+	// keyringOnce guards loading keyring, which is only required for
+	// verifying Release files.
+	keyringOnce sync.Once
+	keyringErr  error
+
 	byHash   map[string]bool
 	byHashMu sync.RWMutex
+
+	// mirror is the index of the mirror (in mirrorURLs) to download
+	// from.
+	mirror   int
+	mirrorMu sync.Mutex
 }
 
 type transientError struct {
 	error
 }
 
+// transientStatus reports whether an HTTP request which failed with
+// status code might succeed when retried.
+func transientStatus(code int) bool {
+	return code < 400 ||
+		code >= 500 ||
+		code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests
+}
+
+// retry calls f until it succeeds or fails with an error other than
+// transientError, retrying up to g.RetriesTransient times with
+// exponential backoff.
+func (g *Getter) retry(f func() error) error {
+	retries := g.RetriesTransient
+	if retries == 0 {
+		retries = DefaultRetriesTransient
+	}
+	backoff := g.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		t, ok := err.(transientError)
+		if !ok || attempt > retries {
+			return err
+		}
+		log.Printf("transient error %v, retrying in %v (retry %d of %d)", t, backoff, attempt, retries)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// notFoundError is returned by getDists and download if the requested
+// file does not exist in the archive.
+type notFoundError struct {
+	error
+}
+
+// mirrorURLs returns MirrorURL (or DefaultMirrorURL), followed by
+// Mirrors. If LocalMirror is set, it is the only mirror.
+func (g *Getter) mirrorURLs() []string {
+	if g.LocalMirror != "" {
+		return []string{"file://" + g.LocalMirror}
+	}
+	first := DefaultMirrorURL
+	if g.MirrorURL != "" {
+		first = g.MirrorURL
+	}
+	return append([]string{first}, g.Mirrors...)
+}
+
+// currentMirror returns the index of the mirror to download from.
+func (g *Getter) currentMirror() int {
+	g.mirrorMu.Lock()
+	defer g.mirrorMu.Unlock()
+	return g.mirror
+}
+
+// rotateMirror switches to the mirror following failed, unless another
+// download already rotated away from failed.
+func (g *Getter) rotateMirror(failed int, reason error) {
+	mirrors := g.mirrorURLs()
+	if len(mirrors) < 2 {
+		return
+	}
+	g.mirrorMu.Lock()
+	defer g.mirrorMu.Unlock()
+	if g.mirror != failed {
+		return
+	}
+	g.mirror = (failed + 1) % len(mirrors)
+	Warningf("mirror %s failed (%v), rotating to %s", mirrors[failed], reason, mirrors[g.mirror])
+}
+
 func (g *Getter) byHashFor(suite string) bool {
 	g.byHashMu.RLock()
 	defer g.byHashMu.RUnlock()
@@ -70,6 +218,10 @@ func (g *Getter) maybeByHashPath(path string, sha256sum []byte) string {
 	if !strings.HasPrefix(path, "dists/") {
 		return path
 	}
+	// pdiffs are downloaded by name, see GetIndex.
+	if strings.Contains(path, ".diff/") {
+		return path
+	}
 
 	parts := strings.Split(path, "/")
 	if len(parts) < 2 {
@@ -82,112 +234,198 @@ func (g *Getter) maybeByHashPath(path string, sha256sum []byte) string {
 	return filepath.Dir(path) + "/by-hash/SHA256/" + hex.EncodeToString(sha256sum)
 }
 
-// download stores the contents of the Debian archive’s file
-// identified by path in f, provided its SHA256 sum matches
+// download stores the (compressed) contents of the Debian archive’s
+// file identified by path in f, provided its SHA256 sum matches
 // sha256sum. download returns transientError if the caller should
-// retry.
-func (g *Getter) download(path string, f *os.File, sha256sum []byte) error {
+// retry. The file is downloaded from mirrorURL (see fetcherFor).
+//
+// Index files are downloaded using their by-hash path (if the Release
+// file advertises Acquire-By-Hash), so that a mirror which is updated
+// while debiman runs still serves the files referenced by the Release
+// file which debiman fetched. If the by-hash path does not exist (e.g.
+// on an incompletely synchronized mirror), path is used.
+func (g *Getter) download(mirrorURL, path string, f *os.File, sha256sum []byte) error {
 	byHash := g.maybeByHashPath(path, sha256sum)
+	err := g.downloadFrom(mirrorURL, byHash, path, f, sha256sum)
+	if _, ok := err.(notFoundError); ok && byHash != path {
+		log.Printf("%s not found, falling back to %s", byHash, path)
+		return g.downloadFrom(mirrorURL, path, path, f, sha256sum)
+	}
+	return err
+}
 
-	var r io.Reader
-	if g.LocalMirror != "" {
-		f, err := os.Open(filepath.Join(g.LocalMirror, byHash))
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		r = f
-	} else {
-		resp, err := http.Get("http://deb.debian.org/debian/" + byHash)
-		if err != nil {
-			return transientError{err}
-		}
-		defer func() {
-			ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-		}()
-		if got, want := resp.StatusCode, http.StatusOK; got != want {
-			err := fmt.Errorf("download(%q): Unexpected HTTP status code: got %d, want %d", path, got, want)
-			if resp.StatusCode < 400 || resp.StatusCode >= 500 {
-				return transientError{err}
-			}
+// downloadFrom is like download, but fetches the file from src, which
+// is either path or its by-hash path.
+//
+// If f already contains the beginning of the file (from an interrupted
+// previous attempt), the download is resumed (e.g. using an HTTP Range
+// request).
+func (g *Getter) downloadFrom(mirrorURL, src, path string, f *os.File, sha256sum []byte) error {
+	offset, err := f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	r, offset, err := g.fetcherFor(mirrorURL).fetch(src, offset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if offset > 0 {
+		log.Printf("resuming download of %q at byte %d", path, offset)
+	}
+
+	if offset == 0 {
+		// Discard the contents of previous attempts.
+		if err := f.Truncate(0); err != nil {
 			return err
 		}
-		r = resp.Body
+	}
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return err
 	}
 
-	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+	// Transient errors keep what was received so far, the next attempt
+	// resumes.
+	if _, err := io.Copy(f, r); err != nil {
 		return err
 	}
 
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
 	h := sha256.New()
-	rd := io.Reader(io.TeeReader(r, h))
-	var err error
-	if strings.HasSuffix(path, ".gz") {
-		rd, err = gzip.NewReader(rd)
-		if err != nil {
-			return err
-		}
-	} else if strings.HasSuffix(path, ".xz") {
-		rd, err = xz.NewReader(rd, 0)
-		if err != nil {
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got, want := h.Sum(nil), sha256sum; want != nil && !bytes.Equal(got, want) {
+		// Corrupt files must not be resumed.
+		if err := f.Truncate(0); err != nil {
 			return err
 		}
+		return fmt.Errorf("%q: invalid hash: got %v, want %v", path, hex.EncodeToString(got), hex.EncodeToString(want))
 	}
 
-	w := bufio.NewWriter(f)
+	return nil
+}
 
+// tempFile returns a new anonymous temporary file.
+func tempFile() (*os.File, error) {
+	// TODO: how does this fail on linux < 3.11 or other OSes?
+	// TODO: fallback
+	// f, err := ioutil.TempFile("", "archive-")
+	// if err != nil {
+	// 	return nil, err
+	// }
+	// // Remove the file system entry, we make do with the file descriptor from here on.
+	// os.Remove(f.Name())
+	return os.OpenFile("/tmp", 0x410000|os.O_RDWR, 0600)
+}
+
+// isCompressed returns whether the archive’s file path is compressed,
+// judging by its name.
+func isCompressed(path string) bool {
+	return strings.HasSuffix(path, ".gz") ||
+		strings.HasSuffix(path, ".xz") ||
+		strings.HasSuffix(path, ".zst")
+}
+
+// decompress returns a temporary file containing the decompressed
+// contents of f, which holds the archive’s file path (e.g.
+// Contents-amd64.gz).
+func decompress(path string, f *os.File) (*os.File, error) {
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	var (
+		rd  io.Reader
+		err error
+	)
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		rd, err = gzip.NewReader(f)
+	case strings.HasSuffix(path, ".zst"):
+		var dec *zstd.Decoder
+		dec, err = zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			defer dec.Close()
+			rd = dec
+		}
+	default:
+		rd, err = xz.NewReader(f, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := tempFile()
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(out)
 	if _, err := io.Copy(w, rd); err != nil {
-		return err
+		out.Close()
+		return nil, err
 	}
 	if strings.HasSuffix(path, ".gz") {
 		if err := rd.(*gzip.Reader).Close(); err != nil {
-			return err
+			out.Close()
+			return nil, err
 		}
 	}
-
 	if err := w.Flush(); err != nil {
-		return err
-	}
-
-	if got, want := h.Sum(nil), sha256sum; !bytes.Equal(got, want) {
-		return fmt.Errorf("%q: invalid hash: got %v, want %v", path, hex.EncodeToString(got), hex.EncodeToString(want))
+		out.Close()
+		return nil, err
 	}
-
-	return nil
+	return out, nil
 }
 
+// Get returns a temporary file containing the archive’s file path
+// (decompressed, if its name ends in .gz, .xz or .zst), provided its SHA256
+// sum matches sha256sum. If sha256sum is nil, the file is not verified
+// (see GetSigned).
 func (g *Getter) Get(path string, sha256sum []byte) (*os.File, error) {
+	return g.get(path, sha256sum, isCompressed(path))
+}
+
+// get is like Get, but only decompresses the file if uncompress is
+// true.
+func (g *Getter) get(path string, sha256sum []byte, uncompress bool) (*os.File, error) {
 	if err := g.init(); err != nil {
 		return nil, err
 	}
 	g.pool.lock()
 	defer g.pool.unlock()
 
-	// TODO: how does this fail on linux < 3.11 or other OSes?
-	f, err := os.OpenFile("/tmp", 0x410000|os.O_RDWR, 0600)
+	f, err := tempFile()
 	if err != nil {
 		return nil, fmt.Errorf("archive.get: %v", err)
 	}
-	// TODO: fallback
-	// f, err := ioutil.TempFile("", "archive-")
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// // Remove the file system entry, we make do with the file descriptor from here on.
-	// os.Remove(f.Name())
 
-	for retry := 0; retry < 3; retry++ {
-		err := g.download(path, f, sha256sum)
+	// Each mirror is tried (in turn, starting with the current one)
+	// until the file was downloaded successfully.
+	mirrors := g.mirrorURLs()
+	for try := 0; try < len(mirrors); try++ {
+		idx := g.currentMirror()
+		err = g.retry(func() error {
+			return g.download(mirrors[idx], path, f, sha256sum)
+		})
 		if err == nil {
 			break
 		}
-		if t, ok := err.(transientError); ok {
-			log.Printf("transient error %v, retrying (attempt %d of %d)", t, retry, 3)
-			continue
-		}
+		g.rotateMirror(idx, err)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("archive.get: %v", err)
+	}
+
+	if uncompress {
+		compressed := f
+		f, err = decompress(path, compressed)
+		compressed.Close()
 		if err != nil {
-			return nil, fmt.Errorf("archive.get: %v", err)
+			return nil, fmt.Errorf("archive.get: %q: %v", path, err)
 		}
 	}
 
@@ -199,20 +437,23 @@ func (g *Getter) Get(path string, sha256sum []byte) (*os.File, error) {
 }
 
 func (g *Getter) init() error {
-	var err error
 	g.once.Do(func() {
 		g.pool = newPool(g.ConnectionsPerMirror)
-		err = g.loadArchiveKeyrings()
+		g.client = &http.Client{Timeout: g.Timeout}
 		g.byHash = make(map[string]bool)
 	})
-	return err
+	return nil
 }
 
 // loadArchiveKeyrings loads the debian-archive-keyring.gpg keyring
 // shipped in the debian-archive-keyring Debian package (NOT all
-// trusted keys stored in /etc/apt/trusted.gpg.d).
+// trusted keys stored in /etc/apt/trusted.gpg.d), or g.Keyring.
 func (g *Getter) loadArchiveKeyrings() error {
-	f, err := os.Open("/usr/share/keyrings/debian-archive-keyring.gpg")
+	keyring := DefaultKeyring
+	if g.Keyring != "" {
+		keyring = g.Keyring
+	}
+	f, err := os.Open(keyring)
 	if err != nil {
 		// TODO: add helpful error message to install the debian-archive-keyring package in case this is os.IsNotExist
 		return err
@@ -222,43 +463,170 @@ func (g *Getter) loadArchiveKeyrings() error {
 	return err
 }
 
-func (g *Getter) GetRelease(suite string) (*archive.Release, error) {
+// getDists returns the contents of the file name (e.g. Release) in the
+// dists/<suite> directory of the archive.
+func (g *Getter) getDists(suite, name string) ([]byte, error) {
+	return g.getFile("dists/" + suite + "/" + name)
+}
+
+// getFile returns the contents of the archive’s (small) file path.
+// Like Get, getFile rotates through the mirrors until one of them
+// serves the file.
+func (g *Getter) getFile(path string) ([]byte, error) {
 	if err := g.init(); err != nil {
 		return nil, err
 	}
+	var err error
+	mirrors := g.mirrorURLs()
+	for try := 0; try < len(mirrors); try++ {
+		idx := g.currentMirror()
+		var b []byte
+		err = g.retry(func() error {
+			var err error
+			b, err = g.fetchAll(mirrors[idx], path)
+			return err
+		})
+		if err == nil {
+			return b, nil
+		}
+		if _, ok := err.(notFoundError); ok {
+			// e.g. InRelease, which is optional
+			return nil, err
+		}
+		g.rotateMirror(idx, err)
+	}
+	return nil, err
+}
 
-	// TODO: retry
-	// TODO: use correct mirror
+// fetchAll returns the contents of the file path on mirrorURL.
+func (g *Getter) fetchAll(mirrorURL, path string) ([]byte, error) {
+	r, _, err := g.fetcherFor(mirrorURL).fetch(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
 
-	var r io.Reader
-	if g.LocalMirror != "" {
-		f, err := os.Open(filepath.Join(g.LocalMirror, "dists", suite, "Release"))
+// verifySignature verifies that sig is a valid detached signature of
+// signed made by a key in the keyring. Signatures are usually
+// ASCII-armored (e.g. Release.gpg), but binary signatures are valid,
+// too.
+func (g *Getter) verifySignature(signed io.Reader, sig []byte) error {
+	sigr := io.Reader(bytes.NewReader(sig))
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		block, err := armor.Decode(bytes.NewReader(sig))
 		if err != nil {
-			return nil, fmt.Errorf("archive.GetRelease: %v", err)
+			return err
 		}
-		defer f.Close()
-		r = f
-	} else {
-		// TODO: switch to /InRelease for $TODO-debian-version
-		path := "http://ftp.ch.debian.org/debian/dists/" + suite + "/Release"
-		resp, err := http.Get(path)
-		if err != nil {
-			return nil, fmt.Errorf("archive.GetRelease: %v", err)
+		sigr = block.Body
+	}
+	return g.verifySignatureReader(signed, sigr)
+}
+
+// verifySignatureReader verifies that sig is a valid binary detached
+// signature of signed made by a key in the keyring.
+func (g *Getter) verifySignatureReader(signed, sig io.Reader) error {
+	g.keyringOnce.Do(func() {
+		g.keyringErr = g.loadArchiveKeyrings()
+	})
+	if g.keyringErr != nil {
+		return g.keyringErr
+	}
+	_, err := openpgp.CheckDetachedSignature(g.keyring, signed, sig)
+	return err
+}
+
+// readRelease returns the contents of the Release file of suite. The
+// clear-signed InRelease file is preferred, as some repositories only
+// publish InRelease. If the archive does not provide it, Release and
+// its detached signature Release.gpg are used. Unless g.Insecure is
+// set, the signature is verified.
+func (g *Getter) readRelease(suite string) ([]byte, error) {
+	b, err := g.getDists(suite, "InRelease")
+	if err == nil {
+		block, _ := clearsign.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("dists/%s/InRelease: no clear-signed message found", suite)
 		}
+		if g.Insecure {
+			Warningf("not verifying the signature of dists/%s/InRelease (insecure mode)", suite)
+			return block.Plaintext, nil
+		}
+		if err := g.verifySignatureReader(bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+			return nil, fmt.Errorf("verifying signature of dists/%s/InRelease: %v", suite, err)
+		}
+		return block.Plaintext, nil
+	}
+	if _, ok := err.(notFoundError); !ok {
+		return nil, err
+	}
 
-		defer func() {
-			ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-		}()
+	b, err = g.getDists(suite, "Release")
+	if err != nil {
+		return nil, err
+	}
+	if g.Insecure {
+		Warningf("not verifying the signature of dists/%s/Release (insecure mode)", suite)
+		return b, nil
+	}
+	sig, err := g.getDists(suite, "Release.gpg")
+	if err != nil {
+		return nil, err
+	}
+	if err := g.verifySignature(bytes.NewReader(b), sig); err != nil {
+		return nil, fmt.Errorf("verifying signature of dists/%s/Release: %v", suite, err)
+	}
+	return b, nil
+}
 
-		if got, want := resp.StatusCode, http.StatusOK; got != want {
-			return nil, fmt.Errorf("GetRelease(%q): Unexpected HTTP status code: got %d, want %d", path, got, want)
+// GetSigned returns a temporary file containing the archive’s file
+// path (e.g. a pacman repository database), which is verified using
+// its detached signature sigPath (e.g. path+".sig") unless g.Insecure
+// is set.
+func (g *Getter) GetSigned(path, sigPath string) (*os.File, error) {
+	var sig []byte
+	if g.Insecure {
+		Warningf("not verifying the signature of %s (insecure mode)", path)
+	} else {
+		var err error
+		sig, err = g.getFile(sigPath)
+		if err != nil {
+			return nil, fmt.Errorf("archive.GetSigned: %v", err)
 		}
+	}
+	f, err := g.Get(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.Insecure {
+		return f, nil
+	}
+	if err := g.verifySignature(f, sig); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("archive.GetSigned: verifying signature of %s: %v", path, err)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("archive.GetSigned: %v", err)
+	}
+	return f, nil
+}
 
-		r = resp.Body
+// GetRelease returns the (verified, see readRelease) Release file of
+// suite.
+func (g *Getter) GetRelease(suite string) (*archive.Release, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+
+	b, err := g.readRelease(suite)
+	if err != nil {
+		return nil, fmt.Errorf("archive.GetRelease: %v", err)
 	}
 
-	release, err := archive.LoadInRelease(r, &g.keyring)
+	// The signature was verified (if at all) by readRelease.
+	release, err := archive.LoadInRelease(bytes.NewReader(b), nil)
 	if err != nil {
 		return nil, fmt.Errorf("archive.GetRelease: %v", err)
 	}
@@ -0,0 +1,406 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// testMirror is a local mirror containing the suite testing, whose
+// Release files are signed by a newly generated key.
+type testMirror struct {
+	t       *testing.T
+	dir     string
+	keyring string
+	entity  *openpgp.Entity
+}
+
+func newTestMirror(t *testing.T, tmpdir string) *testMirror {
+	e, err := openpgp.NewEntity("debiman test", "", "test@example.invalid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := filepath.Join(tmpdir, "keyring.gpg")
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmpdir, "mirror")
+	if err := os.MkdirAll(filepath.Join(dir, "dists", "testing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &testMirror{
+		t:       t,
+		dir:     dir,
+		keyring: keyring,
+		entity:  e,
+	}
+}
+
+func (m *testMirror) write(name string, content []byte) {
+	if err := ioutil.WriteFile(filepath.Join(m.dir, "dists", "testing", name), content, 0644); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func (m *testMirror) remove(name string) {
+	if err := os.Remove(filepath.Join(m.dir, "dists", "testing", name)); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// writeRelease writes release as Release and its detached signature as
+// Release.gpg.
+func (m *testMirror) writeRelease(release []byte) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, m.entity, bytes.NewReader(release), nil); err != nil {
+		m.t.Fatal(err)
+	}
+	m.write("Release", release)
+	m.write("Release.gpg", sig.Bytes())
+}
+
+// writeInRelease writes release as clear-signed InRelease file.
+func (m *testMirror) writeInRelease(release []byte) {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, m.entity.PrivateKey, nil)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if _, err := w.Write(release); err != nil {
+		m.t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		m.t.Fatal(err)
+	}
+	m.write("InRelease", buf.Bytes())
+}
+
+func (m *testMirror) getter() *Getter {
+	return &Getter{
+		ConnectionsPerMirror: 1,
+		LocalMirror:          m.dir,
+		Keyring:              m.keyring,
+	}
+}
+
+func TestReadRelease(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m := newTestMirror(t, tmpdir)
+	release := []byte("Suite: testing\nCodename: buster\n")
+	tampered := append(append([]byte(nil), release...), "Acquire-By-Hash: yes\n"...)
+
+	t.Run("Release", func(t *testing.T) {
+		m.writeRelease(release)
+		got, err := m.getter().readRelease("testing")
+		if err != nil {
+			t.Fatalf("readRelease: unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, release) {
+			t.Fatalf("readRelease: got %q, want %q", got, release)
+		}
+
+		m.write("Release", tampered)
+		if _, err := m.getter().readRelease("testing"); err == nil || !strings.Contains(err.Error(), "verifying signature") {
+			t.Fatalf("readRelease: got err %v, want signature verification error", err)
+		}
+		// GetRelease must refuse to parse a Release file whose
+		// signature does not match.
+		if _, err := m.getter().GetRelease("testing"); err == nil || !strings.Contains(err.Error(), "verifying signature") {
+			t.Fatalf("GetRelease: got err %v, want signature verification error", err)
+		}
+
+		g := m.getter()
+		g.Insecure = true
+		if got, err := g.readRelease("testing"); err != nil || !bytes.Equal(got, tampered) {
+			t.Fatalf("readRelease(insecure) = %q, %v; want %q, nil", got, err, tampered)
+		}
+	})
+
+	// InRelease is preferred over Release (which is tampered with at
+	// this point).
+	t.Run("InRelease", func(t *testing.T) {
+		m.writeInRelease(release)
+		defer m.remove("InRelease")
+		got, err := m.getter().readRelease("testing")
+		if err != nil {
+			t.Fatalf("readRelease: unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, release) {
+			t.Fatalf("readRelease: got %q, want %q", got, release)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(m.dir, "dists", "testing", "InRelease"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.write("InRelease", bytes.Replace(b, []byte("buster"), []byte("bullseye"), 1))
+		if _, err := m.getter().readRelease("testing"); err == nil || !strings.Contains(err.Error(), "verifying signature") {
+			t.Fatalf("readRelease: got err %v, want signature verification error", err)
+		}
+	})
+}
+
+func TestMirrorFailover(t *testing.T) {
+	const want = "Package: i3-wm\n"
+	var staleRequests int
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// e.g. a mirror which is in the middle of an update
+		staleRequests++
+		w.Write([]byte("Package: i3\n"))
+	}))
+	defer stale.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(want))
+	}))
+	defer good.Close()
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            stale.URL + "/",
+		Mirrors:              []string{good.URL + "/"},
+	}
+	sum := sha256.Sum256([]byte(want))
+	for i := 0; i < 2; i++ {
+		f, err := g.Get("dists/testing/main/binary-amd64/Packages", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("Get: got %q, want %q", got, want)
+		}
+	}
+	// The Getter must stick to the good mirror once it rotated.
+	if got, want := staleRequests, 1; got != want {
+		t.Fatalf("stale mirror: got %d requests, want %d", got, want)
+	}
+
+	// Files which no mirror serves correctly result in an error.
+	wrong := sha256.Sum256([]byte("Package: emacs\n"))
+	if _, err := g.Get("dists/testing/main/binary-amd64/Packages", wrong[:]); err == nil {
+		t.Fatalf("Get: unexpectedly succeeded despite checksum mismatch on all mirrors")
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("i3-wm_4.13-1_amd64.deb\n"), 1000)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) > 1 {
+			http.ServeContent(w, r, "i3-wm.deb", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// Interrupt the first transfer half-way through.
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            srv.URL + "/",
+	}
+	sum := sha256.Sum256(content)
+	f, err := g.Get("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("Get: got %d bytes, want %d bytes", len(got), len(content))
+	}
+	want := []string{"", fmt.Sprintf("bytes=%d-", len(content)/2)}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("Range headers: got %q, want %q", ranges, want)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	const want = "Package: i3-wm\n"
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/forbidden"):
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case requests < 3:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(want))
+		}
+	}))
+	defer srv.Close()
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            srv.URL + "/",
+		RetriesTransient:     2,
+		Backoff:              1 * time.Millisecond,
+	}
+	sum := sha256.Sum256([]byte(want))
+	f, err := g.Get("dists/testing/main/binary-amd64/Packages", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got, want := requests, 3; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+
+	// Permanent errors are not retried.
+	requests = 0
+	if _, err := g.Get("forbidden", sum[:]); err == nil {
+		t.Fatalf("Get: unexpectedly succeeded")
+	}
+	if got, want := requests, 1; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+}
+
+func TestByHash(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	const (
+		want  = "Package: i3-wm\nVersion: 4.13-1\n"
+		newer = "Package: i3-wm\nVersion: 4.14-1\n"
+	)
+	dir := filepath.Join(tmpdir, "dists", "testing", "main", "binary-amd64")
+	if err := os.MkdirAll(filepath.Join(dir, "by-hash", "SHA256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The mirror was updated after the Release file was fetched.
+	if err := ioutil.WriteFile(filepath.Join(dir, "Packages"), []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(want))
+	byHash := filepath.Join(dir, "by-hash", "SHA256", hex.EncodeToString(sum[:]))
+	if err := ioutil.WriteFile(byHash, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		LocalMirror:          tmpdir,
+	}
+	g.init()
+	// as if the Release file contained “Acquire-By-Hash: yes”
+	g.byHash["testing"] = true
+
+	get := func() (string, error) {
+		f, err := g.Get("dists/testing/main/binary-amd64/Packages", sum[:])
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		return string(b), err
+	}
+	if got, err := get(); err != nil || got != want {
+		t.Fatalf("Get = %q, %v; want %q, nil", got, err, want)
+	}
+
+	// Without the by-hash file, the file is downloaded by name.
+	if err := os.Remove(byHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Packages"), []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := get(); err != nil || got != want {
+		t.Fatalf("Get = %q, %v; want %q, nil", got, err, want)
+	}
+}
+
+func TestFileMirror(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	const want = "Package: i3-wm\n"
+	dir := filepath.Join(tmpdir, "dists", "testing", "main", "binary-amd64")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Packages"), []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	sum := sha256.Sum256([]byte(want))
+	for _, g := range []*Getter{
+		{MirrorURL: "file://" + tmpdir + "/"},
+		{MirrorURL: tmpdir + "/"},
+		{MirrorURL: broken.URL + "/", Mirrors: []string{"file://" + tmpdir + "/"}},
+	} {
+		g.ConnectionsPerMirror = 1
+		g.RetriesTransient = -1
+		f, err := g.Get("dists/testing/main/binary-amd64/Packages", sum[:])
+		if err != nil {
+			t.Fatalf("Get(MirrorURL=%q): %v", g.MirrorURL, err)
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("Get(MirrorURL=%q): got %q, want %q", g.MirrorURL, got, want)
+		}
+		if _, err := g.getFile("dists/testing/InRelease"); err == nil {
+			t.Fatalf("getFile(MirrorURL=%q) unexpectedly succeeded for a missing file", g.MirrorURL)
+		} else if _, ok := err.(notFoundError); !ok {
+			t.Fatalf("getFile(MirrorURL=%q): got %v, want notFoundError", g.MirrorURL, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fetcher retrieves files from a mirror.
+type fetcher interface {
+	// fetch returns the contents of the mirror’s file path, starting
+	// at byte offset if possible. The returned offset is the byte at
+	// which the contents start, i.e. offset or 0.
+	//
+	// Errors (including those returned by the reader) are
+	// notFoundError if path does not exist and transientError if the
+	// caller should retry.
+	fetch(path string, offset int64) (io.ReadCloser, int64, error)
+}
+
+// fetcherFor returns the fetcher for mirrorURL, which is either an
+// HTTP(S) URL, a file:// URL or a file system path.
+func (g *Getter) fetcherFor(mirrorURL string) fetcher {
+	if strings.HasPrefix(mirrorURL, "file://") {
+		return fileFetcher(strings.TrimPrefix(mirrorURL, "file://"))
+	}
+	if !strings.HasPrefix(mirrorURL, "http://") && !strings.HasPrefix(mirrorURL, "https://") {
+		return fileFetcher(mirrorURL)
+	}
+	return &httpFetcher{
+		client:  g.client,
+		baseURL: mirrorURL,
+	}
+}
+
+// fileFetcher fetches files from a local mirror in the directory it
+// names.
+type fileFetcher string
+
+func (dir fileFetcher) fetch(path string, offset int64) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.Join(string(dir), filepath.FromSlash(path)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, notFoundError{err}
+		}
+		return nil, 0, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+	}
+	return f, offset, nil
+}
+
+// httpFetcher fetches files from an HTTP(S) mirror, resuming downloads
+// using HTTP Range requests.
+type httpFetcher struct {
+	client  *http.Client
+	baseURL string // with trailing slash
+}
+
+// transientReader is an io.ReadCloser whose read errors (e.g. a
+// connection reset in the middle of a transfer) are transient.
+type transientReader struct {
+	io.ReadCloser
+}
+
+func (r transientReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = transientError{err}
+	}
+	return n, err
+}
+
+func (h *httpFetcher) fetch(path string, offset int64) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest("GET", h.baseURL+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, transientError{err}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server does not support Range requests (or none was
+		// sent): start from the beginning.
+		return transientReader{resp.Body}, 0, nil
+	case http.StatusPartialContent:
+		if offset == 0 {
+			discard(resp)
+			return nil, 0, fmt.Errorf("%q: unexpected partial content", path)
+		}
+		return transientReader{resp.Body}, offset, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is at least as large as the file on the
+		// mirror (which must have changed), so it cannot be resumed.
+		discard(resp)
+		return h.fetch(path, 0)
+	}
+	discard(resp)
+	err = fmt.Errorf("%q: Unexpected HTTP status code: got %d, want %d", path, resp.StatusCode, http.StatusOK)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, notFoundError{err}
+	}
+	if transientStatus(resp.StatusCode) {
+		return nil, 0, transientError{err}
+	}
+	return nil, 0, err
+}
+
+// discard reads and closes the body of resp so that its connection can
+// be reused.
+func discard(resp *http.Response) {
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
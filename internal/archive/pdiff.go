@@ -0,0 +1,460 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pault.ag/go/debian/control"
+)
+
+// pdiffIndex is a parsed pdiff index file (e.g.
+// dists/sid/main/Contents-amd64.diff/Index), see
+// https://wiki.debian.org/DebianRepository/Format#diffs
+type pdiffIndex struct {
+	// current is the SHA256 sum of the current version of the file.
+	current string
+
+	// history contains the SHA256 sums of previous versions of the
+	// file and the names of the patches to apply to them, oldest
+	// first.
+	history []pdiffEntry
+
+	// download maps the names of gzip-compressed patches (e.g.
+	// 2017-06-17-0214.31.gz) to their SHA256 sums.
+	download map[string]string
+
+	// merged is true if each patch updates its version to the current
+	// version (as opposed to the next version).
+	merged bool
+}
+
+type pdiffEntry struct {
+	sha256 string
+	name   string
+}
+
+// parsePdiffIndex parses the SHA256 fields of a pdiff index file.
+func parsePdiffIndex(r io.Reader) (*pdiffIndex, error) {
+	idx := &pdiffIndex{
+		download: make(map[string]string),
+	}
+	var field string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			field = parts[0]
+			value := strings.Fields(parts[1])
+			switch field {
+			case "SHA256-Current":
+				if len(value) > 0 {
+					idx.current = value[0]
+				}
+			case "X-Patch-Precedence":
+				idx.merged = len(value) > 0 && value[0] == "merged"
+			}
+			continue
+		}
+		// e.g. “ 0a3b… 12345 2017-06-17-0214.31”
+		value := strings.Fields(line)
+		if len(value) != 3 {
+			continue
+		}
+		switch field {
+		case "SHA256-History":
+			idx.history = append(idx.history, pdiffEntry{sha256: value[0], name: value[2]})
+		case "SHA256-Download":
+			idx.download[value[2]] = value[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if idx.current == "" {
+		return nil, fmt.Errorf("pdiff index does not contain SHA256-Current")
+	}
+	return idx, nil
+}
+
+// patches returns the names of the patches to apply to the version of
+// the file with the SHA256 sum sha256sum in order to obtain the current
+// version, or false if the version is unknown.
+func (idx *pdiffIndex) patches(sha256sum string) ([]string, bool) {
+	for i, e := range idx.history {
+		if e.sha256 != sha256sum {
+			continue
+		}
+		if idx.merged {
+			return []string{e.name}, true
+		}
+		names := make([]string, 0, len(idx.history)-i)
+		for _, e := range idx.history[i:] {
+			names = append(names, e.name)
+		}
+		return names, true
+	}
+	return nil, false
+}
+
+// edCommand is a command of an ed script as generated by diff --ed,
+// e.g. “5,7c”.
+type edCommand struct {
+	op         byte // 'a', 'c' or 'd'
+	start, end int  // line numbers (1-based, inclusive)
+	text       [][]byte
+}
+
+// parseEd parses the ed script (as generated by diff --ed) script and
+// returns its commands in ascending order.
+func parseEd(script []byte) ([]edCommand, error) {
+	var cmds []edCommand
+	lines := bytes.SplitAfter(script, []byte{'\n'})
+	for i := 0; i < len(lines); i++ {
+		line := string(bytes.TrimSuffix(lines[i], []byte{'\n'}))
+		if line == "" {
+			continue
+		}
+		if line == "s/.//" {
+			// diff --ed escapes lines consisting of a single dot as two
+			// dots, followed by this command.
+			if len(cmds) == 0 || len(cmds[len(cmds)-1].text) == 0 {
+				return nil, fmt.Errorf("ed script: s/.// without preceding text")
+			}
+			text := cmds[len(cmds)-1].text
+			text[len(text)-1] = text[len(text)-1][1:]
+			continue
+		}
+		var cmd edCommand
+		if line == "a" {
+			// Appends after the current line, i.e. continues the text of
+			// the previous command (after a s/.// command).
+			if len(cmds) == 0 || cmds[len(cmds)-1].op == 'd' {
+				return nil, fmt.Errorf("ed script: a without preceding text")
+			}
+		} else {
+			cmd.op = line[len(line)-1]
+			addr := strings.SplitN(line[:len(line)-1], ",", 2)
+			var err error
+			if cmd.start, err = strconv.Atoi(addr[0]); err != nil {
+				return nil, fmt.Errorf("ed script: invalid command %q", line)
+			}
+			cmd.end = cmd.start
+			if len(addr) == 2 {
+				if cmd.end, err = strconv.Atoi(addr[1]); err != nil {
+					return nil, fmt.Errorf("ed script: invalid command %q", line)
+				}
+			}
+			switch {
+			case cmd.op != 'a' && cmd.op != 'c' && cmd.op != 'd':
+				return nil, fmt.Errorf("ed script: unsupported command %q", line)
+			case cmd.end < cmd.start,
+				cmd.op != 'a' && cmd.start < 1:
+				return nil, fmt.Errorf("ed script: invalid range in %q", line)
+			}
+		}
+		if cmd.op == 'd' {
+			cmds = append(cmds, cmd)
+			continue
+		}
+		var text [][]byte
+		for i++; ; i++ {
+			if i >= len(lines) {
+				return nil, fmt.Errorf("ed script: unterminated text of %q", line)
+			}
+			if bytes.Equal(bytes.TrimSuffix(lines[i], []byte{'\n'}), []byte(".")) {
+				break
+			}
+			text = append(text, lines[i])
+		}
+		if line == "a" {
+			prev := &cmds[len(cmds)-1]
+			prev.text = append(prev.text, text...)
+			continue
+		}
+		cmd.text = text
+		cmds = append(cmds, cmd)
+	}
+
+	// diff --ed emits commands in descending order, so that line
+	// numbers are not affected by previous commands.
+	for i, j := 0, len(cmds)-1; i < j; i, j = i+1, j-1 {
+		cmds[i], cmds[j] = cmds[j], cmds[i]
+	}
+	for i := 1; i < len(cmds); i++ {
+		if cmds[i].start <= cmds[i-1].end {
+			return nil, fmt.Errorf("ed script: commands are not in descending order")
+		}
+	}
+	return cmds, nil
+}
+
+// applyEd writes the result of applying the ed script (as generated by
+// diff --ed) to src to dst. src is read sequentially, so that large
+// files need not be held in memory.
+func applyEd(dst io.Writer, src io.Reader, script []byte) error {
+	cmds, err := parseEd(script)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+	var lineno int // number of lines read from src
+	// copyUntil copies src lines up to and including line n.
+	copyUntil := func(n int, write bool) error {
+		for lineno < n {
+			line, err := r.ReadBytes('\n')
+			if err != nil && (err != io.EOF || len(line) == 0) {
+				if err == io.EOF {
+					return fmt.Errorf("ed script references line %d, but input only has %d lines", n, lineno)
+				}
+				return err
+			}
+			lineno++
+			if write {
+				if _, err := w.Write(line); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, cmd := range cmds {
+		switch cmd.op {
+		case 'a':
+			if err := copyUntil(cmd.start, true); err != nil {
+				return err
+			}
+		case 'c', 'd':
+			if err := copyUntil(cmd.start-1, true); err != nil {
+				return err
+			}
+			if err := copyUntil(cmd.end, false); err != nil {
+				return err
+			}
+		}
+		for _, line := range cmd.text {
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func sha256File(f *os.File) (string, error) {
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uncompressedName returns the name of the uncompressed version of the
+// index file path, e.g. main/Contents-amd64 for main/Contents-amd64.gz.
+func uncompressedName(path string) string {
+	for _, ext := range []string{".gz", ".xz"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// GetIndex is like Get, but for the index file path (e.g.
+// main/Contents-amd64.gz) of the Release file in dir (e.g.
+// dists/sid), whose SHA256 sums are contained in hashByFilename.
+//
+// If g.CacheDir is non-empty, the uncompressed file is cached and, when
+// it changed, updated by applying the pdiffs published next to it
+// (e.g. main/Contents-amd64.diff/), if any, instead of downloading the
+// entire file again.
+func (g *Getter) GetIndex(dir, path string, hashByFilename map[string]*control.SHA256FileHash) (*os.File, error) {
+	fh, ok := hashByFilename[path]
+	if !ok {
+		return nil, fmt.Errorf("ERROR: expected path %q not found in Release file", path)
+	}
+	h, err := hex.DecodeString(fh.Hash)
+	if err != nil {
+		return nil, err
+	}
+	uncompressed := uncompressedName(path)
+	current, ok := hashByFilename[uncompressed]
+	if g.CacheDir == "" || !ok {
+		return g.Get(dir+"/"+path, h)
+	}
+
+	cachePath := filepath.Join(g.CacheDir, dir, uncompressed)
+	if f, err := os.Open(cachePath); err == nil {
+		cached, err := sha256File(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if cached == current.Hash {
+			return f, nil
+		}
+		patched, err := g.patchIndex(dir, uncompressed, f, cached, current.Hash, hashByFilename)
+		f.Close()
+		if err == nil {
+			return patched, nil
+		}
+		log.Printf("updating %q using pdiffs failed, downloading it: %v", dir+"/"+uncompressed, err)
+	}
+
+	f, err := g.Get(dir+"/"+path, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.cacheIndex(cachePath, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	}); err != nil {
+		log.Printf("caching %q failed: %v", cachePath, err)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cacheIndex atomically replaces cachePath with the contents written by
+// write.
+func (g *Getter) cacheIndex(cachePath string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(cachePath), "debiman-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after successful rename
+	defer f.Close()
+	bufw := bufio.NewWriter(f)
+	if err := write(bufw); err != nil {
+		return err
+	}
+	if err := bufw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cachePath)
+}
+
+// patchIndex updates the cached index file f (of version cached) to the
+// version current using pdiffs, updates the cache and returns the
+// updated file.
+func (g *Getter) patchIndex(dir, uncompressed string, f *os.File, cached, current string, hashByFilename map[string]*control.SHA256FileHash) (*os.File, error) {
+	indexName := uncompressed + ".diff/Index"
+	ifh, ok := hashByFilename[indexName]
+	if !ok {
+		return nil, fmt.Errorf("no pdiffs available")
+	}
+	h, err := hex.DecodeString(ifh.Hash)
+	if err != nil {
+		return nil, err
+	}
+	indexf, err := g.Get(dir+"/"+indexName, h)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parsePdiffIndex(indexf)
+	indexf.Close()
+	if err != nil {
+		return nil, err
+	}
+	if idx.current != current {
+		return nil, fmt.Errorf("pdiff index is not up to date: SHA256-Current is %s, Release file: %s", idx.current, current)
+	}
+	names, ok := idx.patches(cached)
+	if !ok {
+		return nil, fmt.Errorf("cached version %s is too old", cached)
+	}
+
+	scripts := make([][]byte, len(names))
+	for i, name := range names {
+		dh, ok := idx.download[name+".gz"]
+		if !ok {
+			return nil, fmt.Errorf("patch %q not listed in SHA256-Download", name)
+		}
+		h, err := hex.DecodeString(dh)
+		if err != nil {
+			return nil, err
+		}
+		pf, err := g.Get(dir+"/"+uncompressed+".diff/"+name+".gz", h)
+		if err != nil {
+			return nil, err
+		}
+		scripts[i], err = ioutil.ReadAll(pf)
+		pf.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Apply all patches, using temporary files for the intermediate
+	// versions.
+	src := io.Reader(f)
+	var tmps []*os.File
+	defer func() {
+		for _, t := range tmps {
+			t.Close()
+		}
+	}()
+	for _, script := range scripts[:len(scripts)-1] {
+		t, err := ioutil.TempFile(g.CacheDir, "pdiff-")
+		if err != nil {
+			return nil, err
+		}
+		os.Remove(t.Name())
+		tmps = append(tmps, t)
+		if err := applyEd(t, src, script); err != nil {
+			return nil, err
+		}
+		if _, err := t.Seek(0, os.SEEK_SET); err != nil {
+			return nil, err
+		}
+		src = t
+	}
+	hw := sha256.New()
+	cachePath := filepath.Join(g.CacheDir, dir, uncompressed)
+	tmpPath := cachePath + ".patched"
+	if err := g.cacheIndex(tmpPath, func(w io.Writer) error {
+		return applyEd(io.MultiWriter(w, hw), src, scripts[len(scripts)-1])
+	}); err != nil {
+		return nil, err
+	}
+	if got := hex.EncodeToString(hw.Sum(nil)); got != current {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("invalid hash after applying %d patches: got %s, want %s", len(scripts), got, current)
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return nil, err
+	}
+	log.Printf("updated %q using %d pdiffs", dir+"/"+uncompressed, len(scripts))
+	return os.Open(cachePath)
+}
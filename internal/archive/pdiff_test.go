@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pault.ag/go/debian/control"
+)
+
+func TestApplyEd(t *testing.T) {
+	for _, entry := range []struct {
+		name   string
+		src    string
+		script string
+		want   string
+	}{
+		{
+			name: "diff",
+			src:  "a\nb\nc\nd\ne\nf\ng\n",
+			// generated using diff --ed
+			script: "7a\nh\n.\n6d\n4c\n..\n.\ns/.//\na\nx\n.\n2c\nB\n.\n",
+			want:   "a\nB\nc\n.\nx\ne\ng\nh\n",
+		},
+
+		{
+			name:   "prepend",
+			src:    "b\n",
+			script: "0a\na\n.\n",
+			want:   "a\nb\n",
+		},
+
+		{
+			name:   "range",
+			src:    "a\nb\nc\nd\n",
+			script: "2,3d\n",
+			want:   "a\nd\n",
+		},
+
+		{
+			name:   "empty",
+			src:    "a\n",
+			script: "",
+			want:   "a\n",
+		},
+	} {
+		t.Run(entry.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := applyEd(&buf, strings.NewReader(entry.src), []byte(entry.script)); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != entry.want {
+				t.Fatalf("unexpected result: got %q, want %q", got, entry.want)
+			}
+		})
+	}
+
+	for _, script := range []string{
+		"5d\n",     // beyond the end of the input
+		"1d\n2d\n", // ascending order
+		"1a\nunterminated\n",
+		"1,x\n",
+		"1p\n",
+	} {
+		var buf bytes.Buffer
+		if err := applyEd(&buf, strings.NewReader("a\nb\n"), []byte(script)); err == nil {
+			t.Errorf("applyEd(%q) unexpectedly succeeded", script)
+		}
+	}
+}
+
+func TestParsePdiffIndex(t *testing.T) {
+	const input = `SHA256-Current: cccc 300
+SHA256-History:
+ aaaa 100 2017-06-16-0214.31
+ bbbb 200 2017-06-17-0214.31
+SHA256-Patches:
+ 1111 10 2017-06-16-0214.31
+ 2222 20 2017-06-17-0214.31
+SHA256-Download:
+ 3333 5 2017-06-16-0214.31.gz
+ 4444 6 2017-06-17-0214.31.gz
+`
+	idx, err := parsePdiffIndex(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idx.current, "cccc"; got != want {
+		t.Errorf("current: got %q, want %q", got, want)
+	}
+	got, ok := idx.patches("aaaa")
+	if want := []string{"2017-06-16-0214.31", "2017-06-17-0214.31"}; !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("patches(aaaa): got %q, want %q", got, want)
+	}
+	if _, ok := idx.patches("dddd"); ok {
+		t.Errorf("patches(dddd) unexpectedly succeeded")
+	}
+	if got, want := idx.download["2017-06-17-0214.31.gz"], "4444"; got != want {
+		t.Errorf("download: got %q, want %q", got, want)
+	}
+
+	idx, err = parsePdiffIndex(strings.NewReader("X-Patch-Precedence: merged\n" + input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok = idx.patches("aaaa")
+	if want := []string{"2017-06-16-0214.31"}; !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("merged patches(aaaa): got %q, want %q", got, want)
+	}
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetIndexPdiff(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	mirror := filepath.Join(tmpdir, "mirror")
+	cache := filepath.Join(tmpdir, "cache")
+
+	const (
+		v1 = "usr/share/man/man1/a.1.gz admin/a\n"
+		v2 = "usr/share/man/man1/a.1.gz admin/a\nusr/share/man/man1/b.1.gz admin/b\n"
+		v3 = "usr/share/man/man1/b.1.gz admin/b\n"
+	)
+	patches := map[string]string{
+		"2017-06-16-0214.31": "1a\nusr/share/man/man1/b.1.gz admin/b\n.\n",
+		"2017-06-17-0214.31": "1d\n",
+	}
+	var download string
+	for _, name := range []string{"2017-06-16-0214.31", "2017-06-17-0214.31"} {
+		gz := gzipped(t, []byte(patches[name]))
+		download += fmt.Sprintf(" %s %d %s.gz\n", sha256Hex(gz), len(gz), name)
+		path := filepath.Join(mirror, "dists/sid/main/Contents-amd64.diff", name+".gz")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, gz, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := fmt.Sprintf("SHA256-Current: %s %d\nSHA256-History:\n %s %d 2017-06-16-0214.31\n %s %d 2017-06-17-0214.31\nSHA256-Download:\n%s",
+		sha256Hex([]byte(v3)), len(v3),
+		sha256Hex([]byte(v1)), len(v1),
+		sha256Hex([]byte(v2)), len(v2),
+		download)
+	if err := ioutil.WriteFile(filepath.Join(mirror, "dists/sid/main/Contents-amd64.diff/Index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The full file is deliberately not present on the mirror, so
+	// GetIndex fails unless it uses the pdiffs.
+	hashByFilename := map[string]*control.SHA256FileHash{
+		"main/Contents-amd64.gz":         {FileHash: control.FileHash{Hash: sha256Hex(gzipped(t, []byte(v3)))}},
+		"main/Contents-amd64":            {FileHash: control.FileHash{Hash: sha256Hex([]byte(v3))}},
+		"main/Contents-amd64.diff/Index": {FileHash: control.FileHash{Hash: sha256Hex([]byte(index))}},
+	}
+	cached := filepath.Join(cache, "dists/sid/main/Contents-amd64")
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cached, []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		LocalMirror:          mirror,
+		CacheDir:             cache,
+	}
+	f, err := g.GetIndex("dists/sid", "main/Contents-amd64.gz", hashByFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), v3; got != want {
+		t.Fatalf("unexpected content: got %q, want %q", got, want)
+	}
+	b, err = ioutil.ReadFile(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), v3; got != want {
+		t.Fatalf("unexpected cache content: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PackageCache is a directory in which downloaded packages are kept
+// across runs, keyed by their SHA256 sum, so that packages which are
+// present in multiple suites (or did not change since the last run)
+// are downloaded only once. A PackageCache can be shared by multiple
+// Getters (e.g. for different distributions).
+type PackageCache struct {
+	// Dir is the directory containing the cached packages, stored as
+	// <Dir>/<first two hex digits>/<SHA256 sum>.
+	Dir string
+
+	// MaxBytes is the size up to which the cache may grow. Whenever a
+	// package is added to a cache which is larger, the least recently
+	// used packages are removed until the cache is at 90% of MaxBytes.
+	// If zero, the cache size is not limited.
+	MaxBytes int64
+
+	mu      sync.Mutex
+	scanned bool
+	size    int64 // total size of the cached packages
+}
+
+// pkgCacheTempPrefix is the name prefix of the temporary files to
+// which packages are written before they are renamed into the cache.
+const pkgCacheTempPrefix = "debiman-"
+
+// path returns the path of the package with the (hex-encoded) SHA256
+// sum sum.
+func (c *PackageCache) path(sum string) string {
+	return filepath.Join(c.Dir, sum[:2], sum)
+}
+
+// get returns the cached package with the SHA256 sum sha256sum, or nil
+// if it is not cached. Packages whose contents do not match their
+// checksum (e.g. after a disk corruption) are removed.
+func (c *PackageCache) get(sha256sum []byte) *os.File {
+	sum := hex.EncodeToString(sha256sum)
+	path := c.path(sum)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	got, err := sha256File(f)
+	if err != nil || got != sum {
+		Warningf("removing corrupt cached package %q", path)
+		f.Close()
+		c.remove(path)
+		return nil
+	}
+	// The modification time marks the package as recently used.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		Warningf("marking cached package %q as used: %v", path, err)
+	}
+	return f
+}
+
+// put adds the contents of f, whose SHA256 sum is sha256sum, to the
+// cache and seeks f back to its beginning.
+func (c *PackageCache) put(sha256sum []byte, f *os.File) error {
+	path := c.path(hex.EncodeToString(sha256sum))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	t, err := ioutil.TempFile(filepath.Dir(path), pkgCacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name()) // fails after the rename
+	n, err := io.Copy(t, f)
+	if err != nil {
+		t.Close()
+		return err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.scanLocked(); err != nil {
+		return err
+	}
+	var replaced int64
+	if fi, err := os.Stat(path); err == nil {
+		replaced = fi.Size()
+	}
+	if err := os.Rename(t.Name(), path); err != nil {
+		return err
+	}
+	c.size += n - replaced
+	if c.MaxBytes > 0 && c.size > c.MaxBytes {
+		return c.evictLocked(c.MaxBytes / 10 * 9)
+	}
+	return nil
+}
+
+// remove removes the cached package at path.
+func (c *PackageCache) remove(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scanned {
+		c.size -= fi.Size()
+	}
+}
+
+// cachedPackage is a file in the cache directory.
+type cachedPackage struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+type byMtime []cachedPackage
+
+func (p byMtime) Len() int           { return len(p) }
+func (p byMtime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byMtime) Less(i, j int) bool { return p[i].mtime.Before(p[j].mtime) }
+
+// list returns all packages in the cache.
+func (c *PackageCache) list() ([]cachedPackage, error) {
+	var pkgs []cachedPackage
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Temporary files are being written by concurrent calls to
+		// put, which account for them once they are renamed.
+		if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), pkgCacheTempPrefix) {
+			pkgs = append(pkgs, cachedPackage{
+				path:  path,
+				size:  info.Size(),
+				mtime: info.ModTime(),
+			})
+		}
+		return nil
+	})
+	return pkgs, err
+}
+
+// scanLocked determines the cache size, unless it is known already.
+func (c *PackageCache) scanLocked() error {
+	if c.scanned {
+		return nil
+	}
+	pkgs, err := c.list()
+	if err != nil {
+		return err
+	}
+	for _, p := range pkgs {
+		c.size += p.size
+	}
+	c.scanned = true
+	return nil
+}
+
+// evictLocked removes the least recently used packages until the cache
+// is not larger than target bytes.
+func (c *PackageCache) evictLocked(target int64) error {
+	pkgs, err := c.list()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byMtime(pkgs))
+	var evicted int
+	c.size = 0
+	for _, p := range pkgs {
+		c.size += p.size
+	}
+	for _, p := range pkgs {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(p.path); err != nil {
+			return err
+		}
+		c.size -= p.size
+		evicted++
+	}
+	log.Printf("evicted %d packages from the package cache, now at %d bytes", evicted, c.size)
+	return nil
+}
+
+// GetPackage is like Get, but for packages (e.g.
+// pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb), which are never
+// decompressed (e.g. bash-5.2.026-2-x86_64.pkg.tar.zst) and are taken
+// from and added to g.PackageCache (if non-nil) by their SHA256 sum.
+//
+// As packages are extracted, their SHA256 sum (as recorded in the
+// Packages index) is mandatory. A package whose contents do not match
+// it is downloaded from the next mirror (see Mirrors).
+func (g *Getter) GetPackage(path string, sha256sum []byte) (*os.File, error) {
+	if len(sha256sum) != sha256.Size {
+		return nil, fmt.Errorf("archive.GetPackage: %q: invalid SHA256 sum %x", path, sha256sum)
+	}
+	c := g.PackageCache
+	if c == nil {
+		return g.get(path, sha256sum, false)
+	}
+	if f := c.get(sha256sum); f != nil {
+		return f, nil
+	}
+	f, err := g.get(path, sha256sum, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.put(sha256sum, f); err != nil {
+		// The package was downloaded successfully, so the run can
+		// continue without caching it.
+		Warningf("caching package %q: %v", path, err)
+		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
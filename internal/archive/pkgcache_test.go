@@ -0,0 +1,170 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackageCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	packages := map[string]string{
+		"/pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb":  strings.Repeat("i3-wm", 20),
+		"/pool/main/i/i3lock/i3lock_2.8-1_amd64.deb": strings.Repeat("i3lock", 20),
+		"/pool/main/b/bash/bash_4.4-5_amd64.deb":     strings.Repeat("bash", 20),
+	}
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Write([]byte(packages[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	cache := &PackageCache{
+		Dir:      filepath.Join(tmpdir, "cache"),
+		MaxBytes: 250,
+	}
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            srv.URL + "/",
+		PackageCache:         cache,
+	}
+	get := func(path string) {
+		sum := sha256.Sum256([]byte(packages["/"+path]))
+		f, err := g.GetPackage(path, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), packages["/"+path]; got != want {
+			t.Fatalf("GetPackage(%q): got %q, want %q", path, got, want)
+		}
+	}
+
+	get("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb")
+	get("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb")
+	if got, want := requests["/pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb"], 1; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+
+	// A corrupt cache entry is replaced.
+	sum := sha256.Sum256([]byte(packages["/pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb"]))
+	if err := ioutil.WriteFile(cache.path(hex.EncodeToString(sum[:])), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	get("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb")
+	if got, want := requests["/pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb"], 2; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+
+	// Exceeding MaxBytes evicts the least recently used package (i3lock).
+	get("pool/main/i/i3lock/i3lock_2.8-1_amd64.deb")
+	past := time.Now().Add(-1 * time.Hour)
+	sum = sha256.Sum256([]byte(packages["/pool/main/i/i3lock/i3lock_2.8-1_amd64.deb"]))
+	i3lock := cache.path(hex.EncodeToString(sum[:]))
+	if err := os.Chtimes(i3lock, past, past); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary files of concurrent puts are never evicted.
+	inflight := filepath.Join(filepath.Dir(i3lock), pkgCacheTempPrefix+"inflight")
+	if err := ioutil.WriteFile(inflight, []byte(strings.Repeat("x", 200)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(inflight, past, past); err != nil {
+		t.Fatal(err)
+	}
+	get("pool/main/b/bash/bash_4.4-5_amd64.deb")
+	if _, err := os.Stat(i3lock); !os.IsNotExist(err) {
+		t.Fatalf("least recently used package %q unexpectedly not evicted (err: %v)", i3lock, err)
+	}
+	if _, err := os.Stat(inflight); err != nil {
+		t.Fatalf("temporary file %q unexpectedly evicted: %v", inflight, err)
+	}
+	get("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb")
+	if got, want := requests["/pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb"], 2; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+}
+
+func TestGetPackageCompressed(t *testing.T) {
+	// Packages are not decompressed, even if their name suggests so.
+	const want = "not actually zstd-compressed"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(want))
+	}))
+	defer srv.Close()
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            srv.URL + "/",
+	}
+	sum := sha256.Sum256([]byte(want))
+	f, err := g.GetPackage("core/os/x86_64/bash-5.2.026-2-x86_64.pkg.tar.zst", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != want {
+		t.Fatalf("GetPackage: got %q, want %q", got, want)
+	}
+}
+
+func TestGetPackageVerification(t *testing.T) {
+	const want = "i3-wm_4.13-1_amd64.deb"
+	var corruptRequests int
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		corruptRequests++
+		w.Write([]byte("i3-wm_4.13-1_amd64.deb\x00"))
+	}))
+	defer corrupt.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(want))
+	}))
+	defer good.Close()
+
+	g := &Getter{
+		ConnectionsPerMirror: 1,
+		MirrorURL:            corrupt.URL + "/",
+		Mirrors:              []string{good.URL + "/"},
+	}
+	sum := sha256.Sum256([]byte(want))
+	f, err := g.GetPackage("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != want {
+		t.Fatalf("GetPackage: got %q, want %q", got, want)
+	}
+	// Checksum mismatches are not retried on the same mirror.
+	if got, want := corruptRequests, 1; got != want {
+		t.Fatalf("corrupt mirror: got %d requests, want %d", got, want)
+	}
+
+	if _, err := g.GetPackage("pool/main/i/i3-wm/i3-wm_4.13-1_amd64.deb", nil); err == nil {
+		t.Fatalf("GetPackage unexpectedly succeeded without a checksum")
+	}
+}
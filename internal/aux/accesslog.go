@@ -0,0 +1,123 @@
+package aux
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clfTimeFormat is the timestamp format of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes one line per request in the Common Log Format or the
+// Combined Log Format (which additionally contains the referer and user
+// agent), as understood by log analysis tools such as goaccess or
+// awstats.
+type AccessLog struct {
+	combined bool
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog returns an AccessLog writing to w, in the Combined Log
+// Format if combined is true, in the Common Log Format otherwise.
+func NewAccessLog(w io.Writer, combined bool) *AccessLog {
+	return &AccessLog{combined: combined, w: w}
+}
+
+// OpenAccessLog returns an AccessLog appending to the file at path
+// (standard output for “-”) in format, which must be “common” or
+// “combined”.
+func OpenAccessLog(path, format string) (*AccessLog, error) {
+	var combined bool
+	switch format {
+	case "common":
+	case "combined":
+		combined = true
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, expected common or combined", format)
+	}
+	if path == "-" {
+		return NewAccessLog(os.Stdout, combined), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewAccessLog(f, combined), nil
+}
+
+// countingWriter remembers the status code and counts the body bytes
+// written by a handler.
+type countingWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (c *countingWriter) WriteHeader(code int) {
+	c.code = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.bytes += int64(n)
+	return n, err
+}
+
+// orDash returns s, or “-” if s is empty, as CLF requires for missing
+// values.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (a *AccessLog) format(r *http.Request, start time.Time, code int, size int64) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok {
+		user = orDash(u)
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+	// RequestURI is logged instead of URL because handlers such as
+	// HandleJump modify the latter.
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		orDash(host),
+		user,
+		start.Format(clfTimeFormat),
+		strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+		code,
+		bytes)
+	if a.combined {
+		line += " " + strconv.Quote(orDash(r.Referer())) + " " + strconv.Quote(orDash(r.UserAgent()))
+	}
+	return line + "\n"
+}
+
+// Wrap returns a handler which logs all requests passed to h.
+func (a *AccessLog) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		cw := &countingWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(cw, r)
+		line := a.format(r, start, cw.code, cw.bytes)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		io.WriteString(a.w, line)
+	})
+}
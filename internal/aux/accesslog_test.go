@@ -0,0 +1,44 @@
+package aux
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/jessie/i3-wm/i3.1.en.html", http.StatusTemporaryRedirect)
+	})
+	for _, combined := range []bool{false, true} {
+		var buf bytes.Buffer
+		a := NewAccessLog(&buf, combined)
+		req := httptest.NewRequest("GET", "/i3?lang=fr", nil)
+		req.RemoteAddr = "192.0.2.1:4242"
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		a.Wrap(h).ServeHTTP(httptest.NewRecorder(), req)
+		line := buf.String()
+		if !strings.HasPrefix(line, `192.0.2.1 - - [`) || !strings.Contains(line, `] "GET /i3?lang=fr HTTP/1.1" 307 `) {
+			t.Errorf("combined=%v: unexpected access log line: %q", combined, line)
+		}
+		if got, want := strings.HasSuffix(line, ` "-" "Mozilla/5.0"`+"\n"), combined; got != want {
+			t.Errorf("combined=%v: unexpected access log line: %q", combined, line)
+		}
+	}
+
+	a := NewAccessLog(nil, true)
+	req := httptest.NewRequest("GET", "/i3", nil)
+	req.RemoteAddr = "192.0.2.1:4242"
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := `192.0.2.1 - - [02/Jan/2017:03:04:05 +0000] "GET /i3 HTTP/1.1" 404 - "-" "-"` + "\n"
+	if got := a.format(req, start, http.StatusNotFound, 0); got != want {
+		t.Errorf("unexpected access log line: got %q, want %q", got, want)
+	}
+
+	if _, err := OpenAccessLog("-", "json"); err == nil {
+		t.Errorf("OpenAccessLog unexpectedly accepted an unknown format")
+	}
+}
@@ -8,12 +8,35 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Debian/debiman/internal/manpage"
 	"github.com/Debian/debiman/internal/redirect"
+	"github.com/Debian/debiman/internal/search"
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+// defaultSuggestions and maxSuggestions are the default and maximum
+// number of suggestions HandleSuggest returns.
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 100
+)
+
+// maxAproposResults is the maximum number of entries HandleApropos
+// returns.
+const maxAproposResults = 100
+
+// defaultSearchResults and maxSearchResults are the default and maximum
+// number of results HandleSearch returns.
+const (
+	defaultSearchResults = 20
+	maxSearchResults     = 100
 )
 
 type Server struct {
@@ -21,7 +44,25 @@ type Server struct {
 	idxMu          sync.RWMutex
 	notFoundTmpl   *template.Template
 	debimanVersion string
-	sortedNames    []string
+	suggestions    *trie
+	whatis         []whatis.Entry
+	search         *search.Index
+	metrics        *metrics
+	log            *Logger
+	rules          redirect.Rules
+	maxIndexAge    time.Duration
+}
+
+// defaultRules are the rules for rewriting legacy URLs which a Server
+// uses unless SwapRules is called.
+var defaultRules = mustParseRules(redirect.DefaultRules)
+
+func mustParseRules(rules string) redirect.Rules {
+	r, err := redirect.ParseRules(strings.NewReader(rules))
+	if err != nil {
+		panic(err)
+	}
+	return r
 }
 
 func NewServer(idx redirect.Index, notFoundTmpl *template.Template, debimanVersion string) *Server {
@@ -29,27 +70,45 @@ func NewServer(idx redirect.Index, notFoundTmpl *template.Template, debimanVersi
 		idx:            idx,
 		notFoundTmpl:   notFoundTmpl,
 		debimanVersion: debimanVersion,
+		metrics:        newMetrics(),
+		log:            NewLogger(os.Stderr, LevelInfo, false),
+		rules:          defaultRules,
 	}
 	s.prepareSuggest()
 	return s
 }
 
-// prepareSuggest sets sortedNames to a sorted slice of
-// <name>.<section> strings found in idx.
+// prepareSuggest sets suggestions to a trie of the <name>.<section>
+// strings found in idx.
 func (s *Server) prepareSuggest() {
-	names := make(map[string]bool)
+	suggestions := make(map[string]*Suggestion)
 	for name, entries := range s.idx.Entries {
 		for _, entry := range entries {
-			names[name+"."+entry.Section] = true
+			key := name + "." + entry.Section
+			sugg, ok := suggestions[key]
+			if !ok {
+				sugg = &Suggestion{Name: name, Section: entry.Section}
+				suggestions[key] = sugg
+			}
+			var found bool
+			for _, suite := range sugg.Suites {
+				if suite == entry.Suite {
+					found = true
+					break
+				}
+			}
+			if !found {
+				sugg.Suites = append(sugg.Suites, entry.Suite)
+			}
 		}
 	}
 
-	result := make([]string, 0, len(names))
-	for name := range names {
-		result = append(result, name)
+	t := &trie{}
+	for _, sugg := range suggestions {
+		sort.Strings(sugg.Suites)
+		t.insert(sugg)
 	}
-	sort.Strings(result)
-	s.sortedNames = result
+	s.suggestions = t
 }
 
 func (s *Server) SwapIndex(idx redirect.Index) error {
@@ -70,16 +129,80 @@ func (s *Server) SwapIndex(idx redirect.Index) error {
 	defer s.idxMu.Unlock()
 	s.idx = idx
 	s.prepareSuggest()
+	s.metrics.setIndexTime(time.Now())
 	return nil
 }
 
+// SetLogger sets the Logger to which requests are logged. It must be
+// called before serving requests.
+func (s *Server) SetLogger(l *Logger) {
+	s.log = l
+}
+
+// SwapWhatis replaces the whatis database which HandleApropos searches.
+func (s *Server) SwapWhatis(entries []whatis.Entry) {
+	s.idxMu.Lock()
+	defer s.idxMu.Unlock()
+	s.whatis = entries
+}
+
+// SwapSearch replaces the full-text index which HandleSearch queries.
+func (s *Server) SwapSearch(idx *search.Index) {
+	s.idxMu.Lock()
+	defer s.idxMu.Unlock()
+	s.search = idx
+}
+
+// SwapRules replaces the rules for rewriting legacy URLs.
+func (s *Server) SwapRules(rules redirect.Rules) {
+	s.idxMu.Lock()
+	defer s.idxMu.Unlock()
+	s.rules = rules
+}
+
+// rewrite rewrites the path of r if it matches a rule for legacy URLs.
+func (s *Server) rewrite(r *http.Request) {
+	s.idxMu.RLock()
+	defer s.idxMu.RUnlock()
+	if path, ok := s.rules.Rewrite(r.URL); ok {
+		s.log.Debug("rewritten", Fields{"path": r.URL.Path, "rewritten": path})
+		r.URL.Path = path
+	}
+}
+
 func (s *Server) redirect(r *http.Request) (string, error) {
 	s.idxMu.RLock()
 	defer s.idxMu.RUnlock()
-	return s.idx.Redirect(r)
+	res, err := s.idx.Resolve(r)
+	var outcome string
+	switch {
+	case err != nil:
+		if _, ok := err.(*redirect.NotFoundError); ok {
+			outcome = outcomeMiss
+		} else {
+			outcome = outcomeError
+		}
+	case res.Ambiguous:
+		outcome = outcomeAmbiguous
+	default:
+		outcome = outcomeHit
+	}
+	s.metrics.redirect(outcome)
+	s.log.Debug("resolved", Fields{
+		"path":      r.URL.Path,
+		"name":      res.Parsed.Name,
+		"suite":     res.Parsed.Suite,
+		"binarypkg": res.Parsed.Binarypkg,
+		"section":   res.Parsed.Section,
+		"lang":      res.Parsed.Language,
+		"outcome":   outcome,
+		"target":    res.Target,
+	})
+	return res.Target, err
 }
 
 func (s *Server) HandleRedirect(w http.ResponseWriter, r *http.Request) {
+	s.rewrite(r)
 	redir, err := s.redirect(r)
 	if err != nil {
 		if nf, ok := err.(*redirect.NotFoundError); ok {
@@ -91,6 +214,9 @@ func (s *Server) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 				FooterExtra    string
 				Manpage        string
 				BestChoice     redirect.IndexEntry
+				Similar        []redirect.IndexEntry
+				Variants       []redirect.IndexEntry
+				Disambiguation string
 				Meta           *manpage.Meta
 				HrefLangs      []*manpage.Meta
 			}{
@@ -98,6 +224,9 @@ func (s *Server) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 				DebimanVersion: s.debimanVersion,
 				Manpage:        nf.Manpage,
 				BestChoice:     nf.BestChoice,
+				Similar:        nf.Similar,
+				Variants:       nf.Variants,
+				Disambiguation: nf.Disambiguation,
 			})
 			if err == nil {
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -129,46 +258,183 @@ func (s *Server) HandleJump(w http.ResponseWriter, r *http.Request) {
 	s.HandleRedirect(w, r)
 }
 
-func (s *Server) suggest(q string) []string {
+// preferenceMaxAge is how long browsers keep the preferred language
+// cookie.
+const preferenceMaxAge = 365 * 24 * 60 * 60
+
+// HandlePreferences sets the preferred manpage language (the language=
+// query parameter) in a cookie, which HandleRedirect honors above the
+// Accept-Language header. An empty language= removes the preference. If a
+// (local) redirect= parameter is specified, the user is sent back there.
+func (s *Server) HandlePreferences(w http.ResponseWriter, r *http.Request) {
+	lang := r.FormValue("language")
+	cookie := &http.Cookie{
+		Name:     redirect.LanguageCookie,
+		Value:    lang,
+		Path:     "/",
+		MaxAge:   preferenceMaxAge,
+		HttpOnly: true,
+	}
+	msg := fmt.Sprintf("Preferred manpage language set to %q", lang)
+	if lang == "" {
+		cookie.MaxAge = -1 // delete
+		msg = "Preferred manpage language removed"
+	} else {
+		s.idxMu.RLock()
+		ok := s.idx.Langs[lang]
+		s.idxMu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown language %q", lang), http.StatusBadRequest)
+			return
+		}
+	}
+	http.SetCookie(w, cookie)
+
+	// Only redirect to paths on this host, not to arbitrary URLs.
+	if target := r.FormValue("redirect"); strings.HasPrefix(target, "/") &&
+		!strings.HasPrefix(target, "//") &&
+		!strings.HasPrefix(target, "/\\") {
+		http.Redirect(w, r, target, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, msg)
+}
+
+// suggest returns the (at most n) suggestions for the prefix q.
+func (s *Server) suggest(q string, n int) []Suggestion {
 	s.idxMu.RLock()
 	defer s.idxMu.RUnlock()
+	return s.suggestions.complete(strings.ToLower(q), n)
+}
 
-	i := sort.Search(len(s.sortedNames), func(i int) bool {
-		return s.sortedNames[i] >= q
-	})
+// HandleSuggest returns the suggestions for the prefix in the q=
+// parameter, by default in the OpenSearch suggestions format, or with
+// their sections and suites if format=json is specified.
+func (s *Server) HandleSuggest(w http.ResponseWriter, r *http.Request) {
+	q := r.FormValue("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(w, "No q= query parameter specified", http.StatusBadRequest)
+		return
+	}
+	n := defaultSuggestions
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "Invalid n= query parameter", http.StatusBadRequest)
+			return
+		}
+		if n > maxSuggestions {
+			n = maxSuggestions
+		}
+	}
 
-	var result []string
-	for i < len(s.sortedNames) {
-		if strings.HasPrefix(s.sortedNames[i], q) {
-			result = append(result, s.sortedNames[i])
-		} else {
-			break
+	suggestions := s.suggest(q, n)
+	var response interface{}
+	if r.FormValue("format") == "json" {
+		if suggestions == nil {
+			suggestions = []Suggestion{}
+		}
+		response = suggestions
+	} else {
+		var completions []string
+		for _, sugg := range suggestions {
+			completions = append(completions, sugg.key())
+		}
+		response = []interface{}{
+			q,
+			completions,
 		}
-		i++
 	}
-	if len(result) > 10 {
-		result = result[:10]
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(response); err != nil {
+		http.Error(w, fmt.Sprintf("encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	io.Copy(w, &buf)
+}
+
+// apropos returns the whatis entries matching keyword, optionally
+// restricted to suite and language.
+func (s *Server) apropos(keyword, suite, lang string) []whatis.Entry {
+	s.idxMu.RLock()
+	defer s.idxMu.RUnlock()
+
+	result := []whatis.Entry{}
+	for _, e := range whatis.Search(s.whatis, keyword) {
+		if suite != "" && !strings.HasPrefix(e.ServingPath, suite+"/") {
+			continue
+		}
+		if lang != "" && e.Language != lang {
+			continue
+		}
+		result = append(result, e)
+		if len(result) == maxAproposResults {
+			break
+		}
 	}
 	return result
 }
 
-func (s *Server) HandleSuggest(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleApropos(w http.ResponseWriter, r *http.Request) {
 	q := r.FormValue("q")
 	if strings.TrimSpace(q) == "" {
 		http.Error(w, "No q= query parameter specified", http.StatusBadRequest)
 		return
 	}
 
-	r.URL.Path = "/" + q
-	completions := s.suggest(q)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(s.apropos(q, r.FormValue("suite"), r.FormValue("lang"))); err != nil {
+		http.Error(w, fmt.Sprintf("encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, &buf)
+}
+
+// searchResults returns the (at most n) results of the full-text query
+// q, optionally restricted to suite and language, ordered by relevance.
+func (s *Server) searchResults(q, suite, lang string, n int) []search.Result {
+	s.idxMu.RLock()
+	defer s.idxMu.RUnlock()
+
+	if s.search == nil {
+		return []search.Result{}
+	}
+	results := s.search.Search(q, func(d *search.Document) bool {
+		return (suite == "" || strings.HasPrefix(d.ServingPath, suite+"/")) &&
+			(lang == "" || d.Language == lang)
+	}, n)
+	if results == nil {
+		results = []search.Result{}
+	}
+	return results
+}
+
+func (s *Server) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.FormValue("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(w, "No q= query parameter specified", http.StatusBadRequest)
+		return
+	}
+	n := defaultSearchResults
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "Invalid n= query parameter", http.StatusBadRequest)
+			return
+		}
+		if n > maxSearchResults {
+			n = maxSearchResults
+		}
+	}
 
 	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode([]interface{}{
-		q,
-		completions,
-	}); err != nil {
+	if err := json.NewEncoder(&buf).Encode(s.searchResults(q, r.FormValue("suite"), r.FormValue("lang"), n)); err != nil {
 		http.Error(w, fmt.Sprintf("encoding response: %v", err), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	io.Copy(w, &buf)
 }
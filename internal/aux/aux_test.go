@@ -2,11 +2,16 @@ package aux
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Debian/debiman/internal/redirect"
+	"github.com/Debian/debiman/internal/search"
+	"github.com/Debian/debiman/internal/whatis"
 )
 
 var i3OnlyIdx = redirect.Index{
@@ -140,18 +145,236 @@ func TestSuggest(t *testing.T) {
 			want:  nil,
 		},
 	} {
-		if got, want := s.suggest(entry.query), entry.want; !reflect.DeepEqual(got, want) {
+		var got []string
+		for _, sugg := range s.suggest(entry.query, 10) {
+			got = append(got, sugg.key())
+		}
+		if want := entry.want; !reflect.DeepEqual(got, want) {
 			t.Fatalf("unexpected result: got %v, want %v", got, want)
 		}
 	}
 }
 
+func TestSuggestOrder(t *testing.T) {
+	idx := redirect.Index{Entries: make(map[string][]redirect.IndexEntry)}
+	for _, e := range []redirect.IndexEntry{
+		{Name: "i3", Suite: "jessie", Section: "1"},
+		{Name: "i3", Suite: "stretch", Section: "1"},
+		{Name: "i3", Suite: "jessie", Section: "1"},
+		{Name: "i3-msg", Suite: "stretch", Section: "1"},
+		{Name: "i3bar", Suite: "jessie", Section: "1"},
+		{Name: "I3lock", Suite: "jessie", Section: "1"},
+		{Name: "i3status", Suite: "jessie", Section: "1"},
+		{Name: "ls", Suite: "jessie", Section: "1"},
+	} {
+		name := strings.ToLower(e.Name)
+		idx.Entries[name] = append(idx.Entries[name], e)
+	}
+	s := &Server{idx: idx}
+	s.prepareSuggest()
+
+	got := s.suggest("I3", 4)
+	want := []Suggestion{
+		{Name: "i3", Section: "1", Suites: []string{"jessie", "stretch"}},
+		{Name: "i3bar", Section: "1", Suites: []string{"jessie"}},
+		{Name: "i3-msg", Section: "1", Suites: []string{"stretch"}},
+		{Name: "i3lock", Section: "1", Suites: []string{"jessie"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected suggestions: got %+v, want %+v", got, want)
+	}
+	if got := s.suggest("i3.1", 10); len(got) != 1 || got[0].Name != "i3" {
+		t.Fatalf("unexpected suggestions for i3.1: got %+v, want i3(1)", got)
+	}
+	if got := s.suggest("emacs", 10); got != nil {
+		t.Fatalf("unexpected suggestions for emacs: got %+v, want none", got)
+	}
+}
+
+func TestHandleSuggest(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	for _, entry := range []struct {
+		url  string
+		want string
+	}{
+		{"/suggest?q=i", `["i",["i3.1"]]` + "\n"},
+		{"/suggest?q=i&format=json", `[{"name":"i3","section":"1","suites":["jessie"]}]` + "\n"},
+		{"/suggest?q=a&format=json", "[]\n"},
+	} {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", entry.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.HandleSuggest(rec, req)
+		if got := rec.Body.String(); got != entry.want {
+			t.Errorf("%s: got %q, want %q", entry.url, got, entry.want)
+		}
+	}
+}
+
+func TestApropos(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	if got := s.apropos("i3", "", ""); len(got) != 0 {
+		t.Fatalf("apropos without whatis database: got %v, want no entries", got)
+	}
+	s.SwapWhatis([]whatis.Entry{
+		{Names: []string{"i3"}, Language: "en", Description: "an improved dynamic, tiling window manager", ServingPath: "jessie/i3-wm/i3.1.en"},
+		{Names: []string{"i3"}, Language: "de", Description: "ein verbesserter Fenstermanager", ServingPath: "jessie/i3-wm/i3.1.de"},
+		{Names: []string{"i3"}, Language: "en", Description: "an improved dynamic, tiling window manager", ServingPath: "stretch/i3-wm/i3.1.en"},
+	})
+	for _, entry := range []struct {
+		keyword, suite, lang string
+		want                 []string
+	}{
+		{"WINDOW", "", "", []string{"jessie/i3-wm/i3.1.en", "stretch/i3-wm/i3.1.en"}},
+		{"i3", "jessie", "", []string{"jessie/i3-wm/i3.1.en", "jessie/i3-wm/i3.1.de"}},
+		{"i3", "", "de", []string{"jessie/i3-wm/i3.1.de"}},
+		{"emacs", "", "", nil},
+	} {
+		var got []string
+		for _, e := range s.apropos(entry.keyword, entry.suite, entry.lang) {
+			got = append(got, e.ServingPath)
+		}
+		if !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("apropos(%q, %q, %q): got %v, want %v", entry.keyword, entry.suite, entry.lang, got, entry.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	if got := s.searchResults("i3", "", "", 10); len(got) != 0 {
+		t.Fatalf("search without index: got %v, want no results", got)
+	}
+	s.SwapSearch(search.NewIndex([]search.Document{
+		search.NewDocument(search.Document{ServingPath: "jessie/i3-wm/i3.1.en", Name: "i3", Language: "en"}, "i3 is a tiling window manager"),
+		search.NewDocument(search.Document{ServingPath: "jessie/i3-wm/i3.1.de", Name: "i3", Language: "de"}, "i3 ist ein Fenstermanager"),
+		search.NewDocument(search.Document{ServingPath: "stretch/i3-wm/i3.1.en", Name: "i3", Language: "en"}, "i3 is a tiling window manager"),
+	}))
+	for _, entry := range []struct {
+		query, suite, lang string
+		want               []string
+	}{
+		{"window", "", "", []string{"jessie/i3-wm/i3.1.en", "stretch/i3-wm/i3.1.en"}},
+		{"i3", "stretch", "", []string{"stretch/i3-wm/i3.1.en"}},
+		{"i3", "jessie", "de", []string{"jessie/i3-wm/i3.1.de"}},
+		{"emacs", "", "", nil},
+	} {
+		var got []string
+		for _, r := range s.searchResults(entry.query, entry.suite, entry.lang, 10) {
+			got = append(got, r.ServingPath)
+		}
+		if !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("searchResults(%q, %q, %q): got %v, want %v", entry.query, entry.suite, entry.lang, got, entry.want)
+		}
+	}
+}
+
 func BenchmarkSuggest(b *testing.B) {
 	// TODO: load representative index
 	s := NewServer(i3OnlyIdx, nil, "")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		// TODO: run sub benchmarks for a few search terms
-		s.suggest("i")
+		s.suggest("i", defaultSuggestions)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	redirectHandler := s.Instrument("redirect", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.redirect(r); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	})
+	for _, path := range []string{"/i3", "/i3.1", "/nonexistent"} {
+		redirectHandler(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`auxserver_requests_total{handler="redirect",code="200"} 2`,
+		`auxserver_requests_total{handler="redirect",code="404"} 1`,
+		`auxserver_redirects_total{outcome="hit"} 2`,
+		`auxserver_redirects_total{outcome="ambiguous"} 0`,
+		`auxserver_redirects_total{outcome="miss"} 1`,
+		`auxserver_request_duration_seconds_bucket{handler="redirect",le="+Inf"} 3`,
+		`auxserver_request_duration_seconds_count{handler="redirect"} 3`,
+		`auxserver_index_entries 1`,
+		`# TYPE auxserver_index_age_seconds gauge`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandlePreferences(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	for _, tt := range []struct {
+		query        string
+		wantCode     int
+		wantCookie   string
+		wantLocation string
+	}{
+		{query: "language=en", wantCode: http.StatusOK, wantCookie: "lang=en; Path=/; Max-Age=31536000; HttpOnly"},
+		{query: "language=", wantCode: http.StatusOK, wantCookie: "lang=; Path=/; Max-Age=0; HttpOnly"},
+		{query: "language=xx", wantCode: http.StatusBadRequest},
+		{query: "language=en&redirect=/jessie/i3", wantCode: http.StatusSeeOther, wantCookie: "lang=en; Path=/; Max-Age=31536000; HttpOnly", wantLocation: "/jessie/i3"},
+		{query: "language=en&redirect=//evil.example/", wantCode: http.StatusOK, wantCookie: "lang=en; Path=/; Max-Age=31536000; HttpOnly"},
+	} {
+		rec := httptest.NewRecorder()
+		s.HandlePreferences(rec, httptest.NewRequest("GET", "/preferences?"+tt.query, nil))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: unexpected status code: got %d, want %d", tt.query, got, want)
+		}
+		if got, want := rec.Header().Get("Set-Cookie"), tt.wantCookie; got != want {
+			t.Errorf("%s: unexpected cookie: got %q, want %q", tt.query, got, want)
+		}
+		if got, want := rec.Header().Get("Location"), tt.wantLocation; got != want {
+			t.Errorf("%s: unexpected location: got %q, want %q", tt.query, got, want)
+		}
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	s := NewServer(i3OnlyIdx, nil, "")
+	for _, tt := range []struct {
+		name        string
+		modTime     time.Time
+		maxIndexAge time.Duration
+		wantCode    int
+	}{
+		{name: "no maximum", modTime: time.Now().Add(-72 * time.Hour), wantCode: http.StatusOK},
+		{name: "fresh", modTime: time.Now().Add(-1 * time.Hour), maxIndexAge: 48 * time.Hour, wantCode: http.StatusOK},
+		{name: "stale", modTime: time.Now().Add(-72 * time.Hour), maxIndexAge: 48 * time.Hour, wantCode: http.StatusServiceUnavailable},
+	} {
+		s.SetIndexModTime(tt.modTime)
+		s.SetMaxIndexAge(tt.maxIndexAge)
+		rec := httptest.NewRecorder()
+		s.HandleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: unexpected status code: got %d, want %d", tt.name, got, want)
+		}
+		if !strings.Contains(rec.Body.String(), `"index_entries":1`) {
+			t.Errorf("%s: unexpected response: %s", tt.name, rec.Body.String())
+		}
+	}
+
+	empty := NewServer(redirect.Index{}, nil, "")
+	rec := httptest.NewRecorder()
+	empty.HandleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("empty index: unexpected status code: got %d, want %d", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	empty.HandleHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("healthz: unexpected status code: got %d, want %d", got, want)
 	}
 }
@@ -0,0 +1,60 @@
+package aux
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// corsMaxAge is how long (in seconds) browsers may cache the result of
+// a CORS preflight request.
+const corsMaxAge = 24 * 60 * 60
+
+// CORS adds Cross-Origin Resource Sharing headers to responses, so that
+// web applications served from the allowed origins can query the API
+// endpoints from the browser.
+type CORS struct {
+	allowAll bool
+	origins  map[string]bool
+}
+
+// NewCORS returns a CORS which allows the specified origins
+// (e.g. “https://example.net”). The origin “*” allows all origins.
+func NewCORS(origins []string) *CORS {
+	c := &CORS{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.allowAll = true
+		}
+		c.origins[origin] = true
+	}
+	return c
+}
+
+// Wrap returns a handler which adds CORS headers for allowed origins,
+// answers preflight requests and passes all other requests to h.
+func (c *CORS) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || (!c.allowAll && !c.origins[origin]) {
+			h(w, r)
+			return
+		}
+		if c.allowAll {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Preflight request.
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,49 @@
+package aux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	var called bool
+	h := func(w http.ResponseWriter, r *http.Request) { called = true }
+
+	for _, tt := range []struct {
+		name        string
+		origins     []string
+		method      string
+		origin      string
+		wantOrigin  string
+		wantCode    int
+		wantHandled bool
+	}{
+		{name: "no origin", origins: []string{"https://example.net"}, method: "GET", wantCode: http.StatusOK, wantHandled: true},
+		{name: "allowed", origins: []string{"https://example.net"}, method: "GET", origin: "https://example.net", wantOrigin: "https://example.net", wantCode: http.StatusOK, wantHandled: true},
+		{name: "not allowed", origins: []string{"https://example.net"}, method: "GET", origin: "https://evil.example", wantCode: http.StatusOK, wantHandled: true},
+		{name: "wildcard", origins: []string{"*"}, method: "GET", origin: "https://evil.example", wantOrigin: "*", wantCode: http.StatusOK, wantHandled: true},
+		{name: "preflight", origins: []string{"https://example.net"}, method: "OPTIONS", origin: "https://example.net", wantOrigin: "https://example.net", wantCode: http.StatusNoContent},
+		{name: "disabled", method: "GET", origin: "https://example.net", wantCode: http.StatusOK, wantHandled: true},
+	} {
+		called = false
+		req := httptest.NewRequest(tt.method, "/api/resolve?name=i3", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		rec := httptest.NewRecorder()
+		NewCORS(tt.origins).Wrap(h)(rec, req)
+		if got, want := rec.Header().Get("Access-Control-Allow-Origin"), tt.wantOrigin; got != want {
+			t.Errorf("%s: unexpected Access-Control-Allow-Origin: got %q, want %q", tt.name, got, want)
+		}
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: unexpected status code: got %d, want %d", tt.name, got, want)
+		}
+		if got, want := called, tt.wantHandled; got != want {
+			t.Errorf("%s: handler called: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
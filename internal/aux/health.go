@@ -0,0 +1,64 @@
+package aux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SetIndexModTime sets the modification time of the index file, from
+// which the age of the index is calculated (instead of from the time at
+// which it was loaded).
+func (s *Server) SetIndexModTime(t time.Time) {
+	s.metrics.setIndexTime(t)
+}
+
+// SetMaxIndexAge makes HandleReadyz report the Server as not ready once
+// the index is older than d. A d of 0 disables the check.
+func (s *Server) SetMaxIndexAge(d time.Duration) {
+	s.maxIndexAge = d
+}
+
+// HandleHealthz reports that the Server is alive (liveness probe).
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readiness is the JSON response of HandleReadyz.
+type readiness struct {
+	Ready           bool    `json:"ready"`
+	Reason          string  `json:"reason,omitempty"`
+	IndexEntries    int     `json:"index_entries"`
+	IndexAgeSeconds float64 `json:"index_age_seconds"`
+}
+
+// HandleReadyz reports whether the Server is ready to serve requests,
+// i.e. whether its index is loaded and not older than the maximum index
+// age (readiness probe). Not ready is signaled with HTTP 503.
+func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.idxMu.RLock()
+	entries := len(s.idx.Entries)
+	s.idxMu.RUnlock()
+	age := s.metrics.indexAge(time.Now())
+
+	resp := readiness{
+		Ready:           true,
+		IndexEntries:    entries,
+		IndexAgeSeconds: age.Seconds(),
+	}
+	if entries == 0 {
+		resp.Ready = false
+		resp.Reason = "index is empty"
+	} else if s.maxIndexAge > 0 && age > s.maxIndexAge {
+		resp.Ready = false
+		resp.Reason = fmt.Sprintf("index is older than %v", s.maxIndexAge)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,45 @@
+package aux
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Debian/debiman/internal/search"
+	"github.com/Debian/debiman/internal/whatis"
+)
+
+// LoadWhatis returns the entries of all whatis databases (as written by
+// debiman -whatis) matching glob.
+func LoadWhatis(glob string) ([]whatis.Entry, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	var entries []whatis.Entry
+	for _, path := range paths {
+		e, err := whatis.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+// LoadSearch returns the full-text index of all documents of the search
+// indexes (as written by debiman -search_index) matching glob.
+func LoadSearch(glob string) (*search.Index, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	var docs []search.Document
+	for _, path := range paths {
+		d, err := search.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading %q: %v", path, err)
+		}
+		docs = append(docs, d...)
+	}
+	return search.NewIndex(docs), nil
+}
@@ -0,0 +1,134 @@
+package aux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level called name (e.g. “info”).
+func ParseLevel(name string) (Level, error) {
+	for idx, n := range levelNames {
+		if n == name {
+			return Level(idx), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Fields are the key/value pairs attached to a log message.
+type Fields map[string]interface{}
+
+// Logger writes leveled log messages with attached fields, either as
+// human-readable lines or as one JSON object per line (for ingestion
+// into journald or ELK).
+type Logger struct {
+	level Level
+	json  bool
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogger returns a Logger which writes messages of at least level to
+// w, encoded as JSON if json is true.
+func NewLogger(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{
+		level: level,
+		json:  json,
+		w:     w,
+	}
+}
+
+// formatValue returns v as a string suitable for the text log format,
+// quoting it if necessary.
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+func (l *Logger) encode(now time.Time, level Level, msg string, fields Fields) ([]byte, error) {
+	var buf bytes.Buffer
+	if l.json {
+		obj := make(map[string]interface{}, len(fields)+3)
+		for key, value := range fields {
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			obj[key] = value
+		}
+		obj["time"] = now.Format(time.RFC3339Nano)
+		obj["level"] = level.String()
+		obj["msg"] = msg
+		// Encode appends a newline.
+		if err := json.NewEncoder(&buf).Encode(obj); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(&buf, "%s %s %s", now.Format(time.RFC3339), strings.ToUpper(level.String()), msg)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, " %s=%s", key, formatValue(fields[key]))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Log writes msg with fields if level is at least the Logger’s level.
+func (l *Logger) Log(level Level, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+	b, err := l.encode(time.Now(), level, msg, fields)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%s ERROR encoding log message %q: %v\n", time.Now().Format(time.RFC3339), msg, err))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b)
+}
+
+func (l *Logger) Debug(msg string, fields Fields)   { l.Log(LevelDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields Fields)    { l.Log(LevelInfo, msg, fields) }
+func (l *Logger) Warning(msg string, fields Fields) { l.Log(LevelWarning, msg, fields) }
+func (l *Logger) Error(msg string, fields Fields)   { l.Log(LevelError, msg, fields) }
+
+// Fatal logs msg with fields at LevelError and exits the program.
+func (l *Logger) Fatal(msg string, fields Fields) {
+	l.Log(LevelError, msg, fields)
+	os.Exit(1)
+}
@@ -0,0 +1,80 @@
+package aux
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerText(t *testing.T) {
+	l := NewLogger(nil, LevelInfo, false)
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	b, err := l.encode(now, LevelWarning, "swapping index failed", Fields{
+		"path":  "/i3",
+		"error": errors.New("no such file"),
+		"code":  404,
+		"empty": "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2017-01-02T03:04:05Z WARNING swapping index failed code=404 empty="" error="no such file" path=/i3` + "\n"
+	if got := string(b); got != want {
+		t.Fatalf("unexpected log line: got %q, want %q", got, want)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	l := NewLogger(nil, LevelInfo, true)
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	b, err := l.encode(now, LevelInfo, "request", Fields{
+		"path":  "/i3",
+		"code":  307,
+		"error": errors.New("boom"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time":  "2017-01-02T03:04:05Z",
+		"level": "info",
+		"msg":   "request",
+		"path":  "/i3",
+		"code":  float64(307),
+		"error": "boom",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected log object: got %v, want %v", got, want)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf, LevelWarning, false)
+	l.Info("dropped", nil)
+	l.Error("kept", nil)
+	if got := buf.String(); strings.Contains(got, "dropped") || !strings.Contains(got, "kept") {
+		t.Fatalf("unexpected log output: %q", got)
+	}
+
+	for _, name := range []string{"debug", "info", "warning", "error"} {
+		level, err := ParseLevel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := level.String(); got != name {
+			t.Errorf("ParseLevel(%q).String() = %q", name, got)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel(%q) unexpectedly succeeded", "verbose")
+	}
+}
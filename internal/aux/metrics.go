@@ -0,0 +1,213 @@
+package aux
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the request
+// latency histogram buckets.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Redirect outcomes, as exported in auxserver_redirects_total.
+const (
+	outcomeHit       = "hit"
+	outcomeAmbiguous = "ambiguous"
+	outcomeMiss      = "miss"
+	outcomeError     = "error"
+)
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+// metrics are the request statistics of a Server, exported in the
+// Prometheus text exposition format by HandleMetrics.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	redirects map[string]uint64
+	latency   map[string]*histogram
+
+	// indexTime is the modification time of the current index file, if
+	// known (see Server.SetIndexModTime), or the time at which the
+	// index was loaded.
+	indexTime time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestKey]uint64),
+		redirects: make(map[string]uint64),
+		latency:   make(map[string]*histogram),
+		indexTime: time.Now(),
+	}
+}
+
+func (m *metrics) request(handler string, code int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{handler, code}]++
+	h, ok := m.latency[handler]
+	if !ok {
+		h = &histogram{}
+		m.latency[handler] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *metrics) redirect(outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redirects[outcome]++
+}
+
+func (m *metrics) setIndexTime(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.indexTime = t
+}
+
+// indexAge returns how old the current index is at now.
+func (m *metrics) indexAge(now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return now.Sub(m.indexTime)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (m *metrics) write(w io.Writer, now time.Time, entries int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []requestKey
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Sort(byHandlerAndCode(keys))
+	fmt.Fprintf(w, "# HELP auxserver_requests_total HTTP requests served, by handler and status code.\n")
+	fmt.Fprintf(w, "# TYPE auxserver_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "auxserver_requests_total{handler=%q,code=\"%d\"} %d\n", k.handler, k.code, m.requests[k])
+	}
+
+	fmt.Fprintf(w, "# HELP auxserver_redirects_total Redirect lookups, by outcome (hit, ambiguous, miss, error).\n")
+	fmt.Fprintf(w, "# TYPE auxserver_redirects_total counter\n")
+	for _, outcome := range []string{outcomeHit, outcomeAmbiguous, outcomeMiss, outcomeError} {
+		fmt.Fprintf(w, "auxserver_redirects_total{outcome=%q} %d\n", outcome, m.redirects[outcome])
+	}
+
+	var handlers []string
+	for handler := range m.latency {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	fmt.Fprintf(w, "# HELP auxserver_request_duration_seconds Latency of HTTP requests, by handler.\n")
+	fmt.Fprintf(w, "# TYPE auxserver_request_duration_seconds histogram\n")
+	for _, handler := range handlers {
+		h := m.latency[handler]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "auxserver_request_duration_seconds_bucket{handler=%q,le=%q} %d\n", handler, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "auxserver_request_duration_seconds_bucket{handler=%q,le=\"+Inf\"} %d\n", handler, h.count)
+		fmt.Fprintf(w, "auxserver_request_duration_seconds_sum{handler=%q} %s\n", handler, formatFloat(h.sum))
+		fmt.Fprintf(w, "auxserver_request_duration_seconds_count{handler=%q} %d\n", handler, h.count)
+	}
+
+	fmt.Fprintf(w, "# HELP auxserver_index_age_seconds Time since the redirect index was written (or loaded, if unknown).\n")
+	fmt.Fprintf(w, "# TYPE auxserver_index_age_seconds gauge\n")
+	fmt.Fprintf(w, "auxserver_index_age_seconds %s\n", formatFloat(now.Sub(m.indexTime).Seconds()))
+
+	fmt.Fprintf(w, "# HELP auxserver_index_entries Manpage names in the redirect index.\n")
+	fmt.Fprintf(w, "# TYPE auxserver_index_entries gauge\n")
+	_, err := fmt.Fprintf(w, "auxserver_index_entries %d\n", entries)
+	return err
+}
+
+type byHandlerAndCode []requestKey
+
+func (p byHandlerAndCode) Len() int      { return len(p) }
+func (p byHandlerAndCode) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byHandlerAndCode) Less(i, j int) bool {
+	if p[i].handler != p[j].handler {
+		return p[i].handler < p[j].handler
+	}
+	return p[i].code < p[j].code
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Instrument wraps h so that its requests are counted and timed in the
+// metrics exported by HandleMetrics, labeled with handler, and logged
+// together with the inputs of the content negotiation.
+func (s *Server) Instrument(handler string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// Handlers such as HandleJump modify the request URL.
+		path, query := r.URL.Path, r.URL.RawQuery
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, r)
+		duration := time.Since(start)
+		s.metrics.request(handler, rec.code, duration)
+		s.log.Info("request", Fields{
+			"handler":         handler,
+			"method":          r.Method,
+			"path":            path,
+			"query":           query,
+			"accept_language": r.Header.Get("Accept-Language"),
+			"code":            rec.code,
+			"target":          w.Header().Get("Location"),
+			"duration_ms":     float64(duration) / float64(time.Millisecond),
+		})
+	}
+}
+
+// HandleMetrics serves the request statistics in the Prometheus text
+// exposition format.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.idxMu.RLock()
+	entries := len(s.idx.Entries)
+	s.idxMu.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(w, time.Now(), entries)
+}
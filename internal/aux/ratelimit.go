@@ -0,0 +1,139 @@
+package aux
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often a Limiter forgets about clients whose
+// bucket has been refilled completely.
+const sweepInterval = 1 * time.Minute
+
+// bucket is a token bucket, refilled at Limiter.rate tokens per second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter protects handlers against abusive clients by limiting the
+// request rate per client IP address (token bucket) and the number of
+// requests which are handled concurrently.
+type Limiter struct {
+	rate  float64 // tokens per second, 0 disables the per-IP limit
+	burst float64
+
+	// trustForwardedFor makes the Limiter use the last address of the
+	// X-Forwarded-For header, as set by a reverse proxy, instead of the
+	// address of the connection.
+	trustForwardedFor bool
+
+	// sem is nil if the number of concurrent requests is not limited.
+	sem chan struct{}
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter returns a Limiter which allows rate requests per second
+// (with bursts of up to burst requests) per client IP address, and at
+// most maxConcurrent concurrent requests. A rate or maxConcurrent of 0
+// disables the respective limit.
+func NewLimiter(rate float64, burst int, maxConcurrent int, trustForwardedFor bool) *Limiter {
+	l := &Limiter{
+		rate:              rate,
+		burst:             float64(burst),
+		trustForwardedFor: trustForwardedFor,
+		now:               time.Now,
+		buckets:           make(map[string]*bucket),
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// clientIP returns the IP address of the client which sent r.
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.trustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token from the bucket of ip. If the bucket is empty,
+// allow returns false and the time until the next token is available.
+func (l *Limiter) allow(ip string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		// Buckets which were refilled completely are equivalent to
+		// missing buckets.
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for key, b := range l.buckets {
+			if now.Sub(b.last) >= full {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Wrap returns a handler which rejects requests exceeding the limits of
+// l with HTTP 429 (Too Many Requests) or HTTP 503 (Service Unavailable),
+// and passes all other requests to h.
+func (l *Limiter) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := l.allow(l.clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too many requests, please slow down", http.StatusTooManyRequests)
+			return
+		}
+		if l.sem != nil {
+			select {
+			case l.sem <- struct{}{}:
+				defer func() { <-l.sem }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Too many concurrent requests, please try again later", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,82 @@
+package aux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := NewLimiter(1, 2, 0, false)
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("192.0.2.1"); !ok {
+			t.Fatalf("request %d within burst unexpectedly rejected", i)
+		}
+	}
+	ok, retryAfter := l.allow("192.0.2.1")
+	if ok {
+		t.Fatalf("request exceeding burst unexpectedly allowed")
+	}
+	if got, want := retryAfter, 1*time.Second; got != want {
+		t.Errorf("unexpected retry after: got %v, want %v", got, want)
+	}
+	if ok, _ := l.allow("192.0.2.2"); !ok {
+		t.Fatalf("request from a different client unexpectedly rejected")
+	}
+
+	now = now.Add(1 * time.Second)
+	if ok, _ := l.allow("192.0.2.1"); !ok {
+		t.Fatalf("request after refill unexpectedly rejected")
+	}
+
+	now = now.Add(sweepInterval)
+	l.allow("192.0.2.3")
+	if got, want := len(l.buckets), 1; got != want {
+		t.Errorf("idle buckets not swept: got %d buckets, want %d", got, want)
+	}
+}
+
+func TestLimiterWrap(t *testing.T) {
+	release := make(chan bool)
+	entered := make(chan bool)
+	l := NewLimiter(0, 0, 1, true)
+	h := l.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			entered <- true
+			<-release
+		}
+	})
+
+	go h(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-entered
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/i3", nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("unexpected status code while at capacity: got %d, want %d", got, want)
+	}
+	release <- true
+
+	l = NewLimiter(1, 1, 0, true)
+	h = l.Wrap(func(w http.ResponseWriter, r *http.Request) {})
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/i3", nil)
+		req.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.1")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if got := rec.Code; got != want {
+			t.Errorf("unexpected status code: got %d, want %d", got, want)
+		}
+		if want == http.StatusTooManyRequests {
+			if got, want := rec.Header().Get("Retry-After"), "1"; got != want {
+				t.Errorf("unexpected Retry-After: got %q, want %q", got, want)
+			}
+		}
+	}
+	if _, ok := l.buckets["192.0.2.1"]; !ok {
+		t.Errorf("client not identified by X-Forwarded-For: buckets %v", l.buckets)
+	}
+}
@@ -0,0 +1,113 @@
+package aux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Debian/debiman/internal/redirect"
+)
+
+// apiEntry is a manpage variant as returned by HandleResolve.
+type apiEntry struct {
+	URL       string `json:"url"`
+	Name      string `json:"name"`
+	Suite     string `json:"suite"`
+	Binarypkg string `json:"binarypkg"`
+	Section   string `json:"section"`
+	Language  string `json:"language"`
+}
+
+func newAPIEntry(e redirect.IndexEntry) *apiEntry {
+	return &apiEntry{
+		URL:       e.ServingPath(".html"),
+		Name:      e.Name,
+		Suite:     e.Suite,
+		Binarypkg: e.Binarypkg,
+		Section:   e.Section,
+		Language:  e.Language,
+	}
+}
+
+type byURL []*apiEntry
+
+func (p byURL) Len() int           { return len(p) }
+func (p byURL) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byURL) Less(i, j int) bool { return p[i].URL < p[j].URL }
+
+// resolveResponse is the JSON response of HandleResolve.
+type resolveResponse struct {
+	// Manpage is the variant a redirect would lead to.
+	Manpage *apiEntry `json:"manpage,omitempty"`
+
+	// Candidates are all variants of the manpage, sorted by URL.
+	Candidates []*apiEntry `json:"candidates"`
+
+	// Error is set if no variant matches the request.
+	Error string `json:"error,omitempty"`
+
+	// BestChoice is the variant of the (most similar) manpage offered
+	// instead if no variant matches the request.
+	BestChoice *apiEntry `json:"best_choice,omitempty"`
+}
+
+func (s *Server) resolveAPI(r *http.Request, t redirect.IndexEntry) (redirect.IndexEntry, []redirect.IndexEntry, error) {
+	s.idxMu.RLock()
+	defer s.idxMu.RUnlock()
+	return s.idx.ResolveEntry(t, s.idx.AcceptLanguage(r))
+}
+
+// HandleResolve resolves the manpage specified by the name= (required),
+// section=, suite= and binarypkg= query parameters like HandleRedirect
+// would, but returns the chosen variant and all candidate variants as
+// JSON instead of redirecting. The language= query parameter and the
+// Accept-Language header express a language preference.
+func (s *Server) HandleResolve(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if strings.TrimSpace(name) == "" {
+		http.Error(w, "No name= query parameter specified", http.StatusBadRequest)
+		return
+	}
+	chosen, entries, err := s.resolveAPI(r, redirect.IndexEntry{
+		Name:      name,
+		Suite:     r.FormValue("suite"),
+		Binarypkg: r.FormValue("binarypkg"),
+		Section:   r.FormValue("section"),
+	})
+
+	resp := resolveResponse{
+		Candidates: make([]*apiEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Candidates = append(resp.Candidates, newAPIEntry(e))
+	}
+	sort.Sort(byURL(resp.Candidates))
+	code := http.StatusOK
+	if err != nil {
+		nf, ok := err.(*redirect.NotFoundError)
+		if !ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		code = http.StatusNotFound
+		resp.Error = fmt.Sprintf("no manpage %q found", name)
+		if nf.BestChoice.Name != "" {
+			resp.BestChoice = newAPIEntry(nf.BestChoice)
+		}
+	} else {
+		resp.Manpage = newAPIEntry(chosen)
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&resp); err != nil {
+		http.Error(w, fmt.Sprintf("encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.Copy(w, &buf)
+}
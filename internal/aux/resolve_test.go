@@ -0,0 +1,127 @@
+package aux
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Debian/debiman/internal/bundled"
+	"github.com/Debian/debiman/internal/commontmpl"
+	"github.com/Debian/debiman/internal/redirect"
+)
+
+var i3Idx = redirect.Index{
+	Entries: map[string][]redirect.IndexEntry{
+		"i3": []redirect.IndexEntry{
+			{Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "1", Language: "en"},
+			{Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "1", Language: "fr"},
+			{Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "5", Language: "en"},
+		},
+		"i3bar": []redirect.IndexEntry{
+			{Name: "i3bar", Suite: "jessie", Binarypkg: "i3-wm", Section: "1", Language: "en"},
+		},
+	},
+	Suites: map[string]string{
+		"jessie": "jessie",
+		"stable": "jessie",
+	},
+	Langs: map[string]bool{
+		"en": true,
+		"fr": true,
+	},
+	Sections: map[string]bool{
+		"1": true,
+		"5": true,
+	},
+}
+
+func TestHandleResolve(t *testing.T) {
+	s := NewServer(i3Idx, nil, "")
+	candidates := []*apiEntry{
+		{URL: "/jessie/i3-wm/i3.1.en.html", Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "1", Language: "en"},
+		{URL: "/jessie/i3-wm/i3.1.fr.html", Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "1", Language: "fr"},
+		{URL: "/jessie/i3-wm/i3.5.en.html", Name: "i3", Suite: "jessie", Binarypkg: "i3-wm", Section: "5", Language: "en"},
+	}
+	for _, tt := range []struct {
+		query    string
+		wantCode int
+		want     resolveResponse
+	}{
+		{
+			query:    "name=i3",
+			wantCode: http.StatusOK,
+			want:     resolveResponse{Manpage: candidates[0], Candidates: candidates},
+		},
+		{
+			query:    "name=i3&section=1&suite=stable&language=fr",
+			wantCode: http.StatusOK,
+			want:     resolveResponse{Manpage: candidates[1], Candidates: candidates},
+		},
+		{
+			query:    "name=i3&section=5&language=fr",
+			wantCode: http.StatusOK,
+			want:     resolveResponse{Manpage: candidates[2], Candidates: candidates},
+		},
+		{
+			query:    "name=i3&suite=wheezy",
+			wantCode: http.StatusNotFound,
+			want: resolveResponse{
+				Candidates: candidates,
+				Error:      `no manpage "i3" found`,
+				BestChoice: candidates[0],
+			},
+		},
+		{
+			query:    "name=i4",
+			wantCode: http.StatusNotFound,
+			want: resolveResponse{
+				Candidates: []*apiEntry{},
+				Error:      `no manpage "i4" found`,
+			},
+		},
+	} {
+		rec := httptest.NewRecorder()
+		s.HandleResolve(rec, httptest.NewRequest("GET", "/api/resolve?"+tt.query, nil))
+		if got, want := rec.Code, tt.wantCode; got != want {
+			t.Errorf("%s: unexpected status code: got %d, want %d", tt.query, got, want)
+		}
+		var got resolveResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unexpected response: got %s", tt.query, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleResolve(rec, httptest.NewRequest("GET", "/api/resolve", nil))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Errorf("unexpected status code without name: got %d, want %d", got, want)
+	}
+}
+
+func TestHandleRedirectNotFound(t *testing.T) {
+	notFoundTmpl := template.Must(commontmpl.MustParseCommonTmpls().New("notfound").Parse(bundled.Asset("notfound.tmpl")))
+	s := NewServer(i3Idx, notFoundTmpl, "")
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"/wheezy/i3", `<a href="/jessie/i3-wm/i3.5.en.html">i3(5)</a> in jessie`},
+		{"/i3baz", `<a href="/jessie/i3-wm/i3bar.1.en.html">i3bar(1)</a> in jessie`},
+	} {
+		rec := httptest.NewRecorder()
+		s.HandleRedirect(rec, httptest.NewRequest("GET", tt.path, nil))
+		if got, want := rec.Code, http.StatusNotFound; got != want {
+			t.Errorf("%s: unexpected status code: got %d, want %d", tt.path, got, want)
+		}
+		if !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: response does not contain %q:\n%s", tt.path, tt.want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package aux
+
+import "sort"
+
+// Suggestion is a manpage name (in lower case) and section, suggested
+// for a prefix.
+type Suggestion struct {
+	Name    string   `json:"name"`
+	Section string   `json:"section"`
+	Suites  []string `json:"suites"`
+}
+
+// key returns the <name>.<section> string of s, as used in the trie.
+func (s *Suggestion) key() string {
+	return s.Name + "." + s.Section
+}
+
+// trie is a prefix tree of <name>.<section> strings.
+type trie struct {
+	b byte
+
+	// children are sorted by b.
+	children []*trie
+
+	// suggestion is non-nil if the path to this node is a complete
+	// <name>.<section> string.
+	suggestion *Suggestion
+}
+
+func (t *trie) child(b byte) *trie {
+	i := sort.Search(len(t.children), func(i int) bool {
+		return t.children[i].b >= b
+	})
+	if i < len(t.children) && t.children[i].b == b {
+		return t.children[i]
+	}
+	return nil
+}
+
+func (t *trie) insert(s *Suggestion) {
+	node := t
+	key := s.key()
+	for idx := 0; idx < len(key); idx++ {
+		b := key[idx]
+		next := node.child(b)
+		if next == nil {
+			next = &trie{b: b}
+			i := sort.Search(len(node.children), func(i int) bool {
+				return node.children[i].b >= b
+			})
+			node.children = append(node.children, nil)
+			copy(node.children[i+1:], node.children[i:])
+			node.children[i] = next
+		}
+		node = next
+	}
+	node.suggestion = s
+}
+
+// complete returns (at most n) suggestions starting with prefix,
+// shortest first, and in lexicographical order among those of equal
+// length.
+func (t *trie) complete(prefix string, n int) []Suggestion {
+	node := t
+	for idx := 0; idx < len(prefix) && node != nil; idx++ {
+		node = node.child(prefix[idx])
+	}
+	if node == nil {
+		return nil
+	}
+	var result []Suggestion
+	// A breadth-first traversal visits the nodes of each depth in
+	// lexicographical order, as the children of each node are sorted.
+	queue := []*trie{node}
+	for len(queue) > 0 && len(result) < n {
+		node, queue = queue[0], queue[1:]
+		if node.suggestion != nil {
+			result = append(result, *node.suggestion)
+		}
+		queue = append(queue, node.children...)
+	}
+	return result
+}
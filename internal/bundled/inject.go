@@ -1,6 +1,7 @@
 package bundled
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -8,6 +9,14 @@ import (
 	"strings"
 )
 
+// Warningf logs a warning, e.g. about an injected asset which does not
+// overwrite any bundled asset. Programs can replace it to count
+// warnings along with their own; by default, warnings are logged
+// using the standard logger.
+var Warningf = func(format string, v ...interface{}) {
+	log.Output(2, "WARNING: "+fmt.Sprintf(format, v...))
+}
+
 // Inject overwrites bundled assets with versions from dir. Not all
 // assets must be overwritten at once, i.e. just supplying a modified
 // header.tmpl is perfectly fine.
@@ -32,7 +41,7 @@ func Inject(dir string) error {
 			return err
 		}
 		if a, ok := assets["assets/"+fn]; !ok {
-			log.Printf("Warning: injected asset %q does not overwrite any bundled asset (left-over file?)", fn)
+			Warningf("injected asset %q does not overwrite any bundled asset (left-over file?)", fn)
 		} else {
 			log.Printf("Overwriting bundled asset %q (len %d) with %q (len %d)", fn, len(a), path, len(b))
 		}
@@ -41,6 +50,30 @@ func Inject(dir string) error {
 	return nil
 }
 
+// InjectTemplates overwrites bundled templates with the *.tmpl files
+// in dir. Other files in dir are ignored. Unlike Inject, a template
+// which does not overwrite any bundled template is an error, so that
+// typos are caught at startup.
+func InjectTemplates(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fn := filepath.Base(path)
+		if _, ok := assets["assets/"+fn]; !ok {
+			return fmt.Errorf("template %q does not overwrite any bundled template", path)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("Overwriting bundled template %q with %q", fn, path)
+		assets["assets/"+fn] = string(b)
+	}
+	return nil
+}
+
 // Asset returns either the bundled asset with the given name or the
 // injected version (see the -inject_assets flag).
 func Asset(basename string) string {
@@ -223,6 +223,73 @@ func plaintext(n *html.Node) string {
 	return result
 }
 
+// blockElements are the elements whose text Text separates from the
+// surrounding text, so that e.g. a heading does not run into the
+// following paragraph.
+var blockElements = map[string]bool{
+	"blockquote": true,
+	"br":         true,
+	"dd":         true,
+	"div":        true,
+	"dl":         true,
+	"dt":         true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"hr":         true,
+	"li":         true,
+	"ol":         true,
+	"p":          true,
+	"pre":        true,
+	"section":    true,
+	"table":      true,
+	"td":         true,
+	"th":         true,
+	"tr":         true,
+	"ul":         true,
+}
+
+// blockText writes the text content of n to buf, surrounding the text
+// of block elements with newlines.
+func blockText(buf *bytes.Buffer, n *html.Node) {
+	if n.Type == html.TextNode {
+		buf.WriteString(n.Data)
+		return
+	}
+	block := n.Type == html.ElementNode && blockElements[n.Data]
+	if block {
+		buf.WriteByte('\n')
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		blockText(buf, c)
+	}
+	if block {
+		buf.WriteByte('\n')
+	}
+}
+
+// Text returns the text content of doc, an HTML fragment as returned by
+// ToHTML, e.g. for indexing. Blocks (e.g. headings and paragraphs) are
+// returned as separate lines, without surrounding whitespace.
+func Text(doc string) (string, error) {
+	parsed, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	blockText(&buf, parsed)
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
 func headTable(n *html.Node) bool {
 	for _, a := range n.Attr {
 		if a.Key == "class" && a.Val == "head" {
@@ -232,6 +299,20 @@ func headTable(n *html.Node) bool {
 	return false
 }
 
+// isAnchor returns whether n is the anchor link which postprocess
+// appends to headings.
+func isAnchor(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "a" {
+		return false
+	}
+	for _, a := range n.Attr {
+		if a.Key == "class" && a.Val == "anchor" {
+			return true
+		}
+	}
+	return false
+}
+
 func replaceId(n *html.Node, id string) {
 	for idx, a := range n.Attr {
 		if a.Key == "id" {
@@ -245,7 +326,45 @@ func replaceId(n *html.Node, id string) {
 	})
 }
 
-func postprocess(resolve func(ref string) string, n *html.Node, toc *[]string) error {
+// TOCEntry is an entry in the table of contents of a manpage: the
+// heading of a section (.SH) and the headings of its subsections (.SS).
+type TOCEntry struct {
+	Heading     string
+	Subsections []string
+}
+
+// TOC returns the table of contents of doc, an HTML fragment as
+// returned by ToHTML, e.g. to re-use a previously rendered manpage.
+func TOC(doc string) ([]TOCEntry, error) {
+	parsed, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+	var toc []TOCEntry
+	err = recurse(parsed, func(n *html.Node) error {
+		if n.Type != html.ElementNode || (n.Data != "h1" && n.Data != "h2") {
+			return nil
+		}
+		// Skip the anchor which postprocess appended to the heading.
+		var text string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if isAnchor(c) {
+				continue
+			}
+			text += plaintext(c)
+		}
+		if n.Data == "h1" {
+			toc = append(toc, TOCEntry{Heading: text})
+		} else if len(toc) > 0 {
+			last := &toc[len(toc)-1]
+			last.Subsections = append(last.Subsections, text)
+		}
+		return nil
+	})
+	return toc, err
+}
+
+func postprocess(resolve func(ref string) string, n *html.Node, toc *[]TOCEntry) error {
 	if n.Parent == nil {
 		return nil
 	}
@@ -294,7 +413,11 @@ func postprocess(resolve func(ref string) string, n *html.Node, toc *[]string) e
 		n.AppendChild(a)
 
 		if n.Data == "h1" && toc != nil {
-			*toc = append(*toc, text)
+			*toc = append(*toc, TOCEntry{Heading: text})
+		}
+		if n.Data == "h2" && toc != nil && len(*toc) > 0 {
+			last := &(*toc)[len(*toc)-1]
+			last.Subsections = append(last.Subsections, text)
 		}
 	}
 
@@ -338,7 +461,7 @@ func postprocess(resolve func(ref string) string, n *html.Node, toc *[]string) e
 //
 // resolve, if non-nil, will be called to resolve a reference (like
 // “rm(1)”) into a URL.
-func (p *Process) ToHTML(r io.Reader, resolve func(ref string) string) (doc string, toc []string, err error) {
+func (p *Process) ToHTML(r io.Reader, resolve func(ref string) string) (doc string, toc []TOCEntry, err error) {
 	stdout, stderr, err := p.mandoc(r)
 	if stderr != "" {
 		return "", nil, fmt.Errorf("mandoc failed: %v", stderr)
@@ -346,7 +469,23 @@ func (p *Process) ToHTML(r io.Reader, resolve func(ref string) string) (doc stri
 	if err != nil {
 		return "", nil, fmt.Errorf("running mandoc failed: %v", err)
 	}
+	return p.postprocessHTML(stdout, resolve)
+}
 
+// ToHTMLWithWarnings is like ToHTML, but additionally returns the
+// warnings which mandoc emitted while converting r. Warnings are not
+// treated as errors. This is slower than ToHTML, because mandocd(8)
+// cannot be used.
+func (p *Process) ToHTMLWithWarnings(r io.Reader, resolve func(ref string) string) (doc string, toc []TOCEntry, warnings []string, err error) {
+	stdout, warnings, err := p.mandocWarnings(r)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("running mandoc failed: %v", err)
+	}
+	doc, toc, err = p.postprocessHTML(stdout, resolve)
+	return doc, toc, warnings, err
+}
+
+func (p *Process) postprocessHTML(stdout string, resolve func(ref string) string) (doc string, toc []TOCEntry, err error) {
 	parsed, err := html.Parse(strings.NewReader(stdout))
 	if err != nil {
 		return "", nil, err
@@ -356,6 +495,17 @@ func (p *Process) ToHTML(r io.Reader, resolve func(ref string) string) (doc stri
 	if err != nil {
 		return "", toc, err
 	}
+	optionAnchors(parsed)
+	tableHeaders(parsed)
+	microdata(parsed)
+	if p.HighlightExamples {
+		highlightExamples(parsed)
+	}
+	for _, f := range p.Filters {
+		if err := f.Filter(parsed); err != nil {
+			return "", toc, err
+		}
+	}
 	var rendered bytes.Buffer
 	if err := html.Render(&rendered, parsed); err != nil {
 		return "", toc, err
@@ -2,18 +2,26 @@ package convert
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/html"
 )
 
+var update = flag.Bool("update",
+	false,
+	"Write the output of mandoc to the golden files in testdata/ instead of comparing against them")
+
 func diff(got, want string) ([]byte, error) {
 	gf, err := ioutil.TempFile("", "debiman-")
 	if err != nil {
@@ -60,7 +68,7 @@ func TestToHTML(t *testing.T) {
 		t.Run(d, func(t *testing.T) {
 			t.Parallel()
 
-			converter, err := NewProcess()
+			converter, err := NewProcess(Mandoc{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -76,6 +84,11 @@ func TestToHTML(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			if *update {
+				if err := ioutil.WriteFile("../../testdata/"+d+".html", []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
 			b, err := ioutil.ReadFile("../../testdata/" + d + ".html")
 			if err != nil {
 				t.Fatal(err)
@@ -140,6 +153,17 @@ func cmpElems(input *html.Node, got []*html.Node, want []*html.Node) error {
 	return nil
 }
 
+func TestText(t *testing.T) {
+	got, err := Text(`<h1 class="Sh" id="NAME">NAME</h1><b>i3</b> - <i>improved</i> tiling window manager` +
+		`<h1 class="Sh" id="OPTIONS">OPTIONS</h1><dl><dt>-V</dt><dd>Be verbose.<br>Really.</dd></dl>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NAME\ni3 - improved tiling window manager\nOPTIONS\n-V\nBe verbose.\nReally."; got != want {
+		t.Fatalf("Text: got %q, want %q", got, want)
+	}
+}
+
 func TestXref(t *testing.T) {
 	input := &html.Node{
 		Type: html.TextNode,
@@ -415,3 +439,166 @@ func BenchmarkXref(b *testing.B) {
 		xref(data, func(ref string) string { return ref })
 	}
 }
+
+func TestTOC(t *testing.T) {
+	const input = `<div class="mandoc">
+<h2 class="Ss" id="Orphan">Orphan</h2>
+<h1 class="Sh" id="NAME">NAME</h1>
+<h1 class="Sh" id="SHELL_GRAMMAR">SHELL GRAMMAR</h1>
+<h2 class="Ss" id="Simple_Commands">Simple Commands</h2>
+<h2 class="Ss" id="Pipelines">Pipelines</h2>
+<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1>
+</div>`
+	want := []TOCEntry{
+		{Heading: "NAME"},
+		{Heading: "SHELL GRAMMAR", Subsections: []string{"Simple Commands", "Pipelines"}},
+		{Heading: "SEE ALSO"},
+	}
+	var p Process
+	doc, got, err := p.postprocessHTML(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected table of contents: got %+v, want %+v", got, want)
+	}
+
+	// The table of contents can be derived from the output again.
+	got, err = TOC(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected table of contents of the output: got %+v, want %+v", got, want)
+	}
+}
+
+func TestStripOverstrike(t *testing.T) {
+	for _, entry := range []struct {
+		input string
+		want  string
+	}{
+		{"plain text", "plain text"},
+		{"N\bNA\bAM\bME\bE", "NAME"},
+		{"_\bf_\bi_\bl_\be", "file"},
+		{"_\bä\bä", "ä"},
+	} {
+		if got := stripOverstrike(entry.input); got != entry.want {
+			t.Errorf("stripOverstrike(%q): got %q, want %q", entry.input, got, entry.want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, tt := range []struct {
+		output string
+		want   Version
+	}{
+		{"mandoc 1.13.3\n", Version{1, 13, 3}},
+		{"mandoc 1.14.4", Version{1, 14, 4}},
+	} {
+		got, err := ParseVersion(tt.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+	if _, err := ParseVersion("mandoc: -V: Bad argument"); err == nil {
+		t.Errorf("ParseVersion unexpectedly succeeded on an error message")
+	}
+}
+
+func TestFeaturesOf(t *testing.T) {
+	for _, tt := range []struct {
+		version  Version
+		markdown bool
+		mathML   bool
+	}{
+		{Version{1, 13, 3}, false, false},
+		{Version{1, 14, 1}, true, true},
+		{Version{2, 0, 0}, true, true},
+	} {
+		f := FeaturesOf(tt.version)
+		if f.Markdown != tt.markdown || f.MathML != tt.mathML {
+			t.Errorf("FeaturesOf(%v) = %+v, want Markdown=%v, MathML=%v", tt.version, f, tt.markdown, tt.mathML)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	for _, tt := range []struct {
+		version Version
+		args    []string
+		ok      bool
+	}{
+		{Version{1, 13, 3}, nil, true},
+		{Version{1, 13, 3}, []string{"-Oman=%N.%S"}, true},
+		{Version{1, 13, 3}, []string{"-O", "man=%N.%S,style=/style.css"}, true},
+		{Version{1, 13, 3}, []string{"-Otoc"}, false},
+		{Version{1, 14, 5}, []string{"-Otoc"}, true},
+		{Version{1, 14, 5}, []string{"-Oman=%N.%S,bogus"}, false},
+		{Version{1, 14, 5}, []string{"-Wall"}, true},
+	} {
+		err := FeaturesOf(tt.version).CheckArgs(tt.args)
+		if got := err == nil; got != tt.ok {
+			t.Errorf("CheckArgs(%q) with mandoc %v: got %v, want ok=%v", tt.args, tt.version, err, tt.ok)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	p := &Process{Timeout: 10 * time.Millisecond}
+	if err := p.run(exec.Command("sleep", "10")); err != ErrTimeout {
+		t.Fatalf("run(sleep 10): got %v, want %v", err, ErrTimeout)
+	}
+	if err := p.run(exec.Command("true")); err != nil {
+		t.Fatalf("run(true): %v", err)
+	}
+	if got, want := p.Timeouts(), uint64(1); got != want {
+		t.Fatalf("unexpected number of timeouts: got %d, want %d", got, want)
+	}
+}
+
+func TestOutputTimeout(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "debiman-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "mandoc")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var timeouts uint64
+	m := Mandoc{
+		Path:     path,
+		Timeout:  10 * time.Millisecond,
+		Timeouts: &timeouts,
+	}
+	if _, err := m.ToText(strings.NewReader("")); err != ErrTimeout {
+		t.Fatalf("ToText: got %v, want %v", err, ErrTimeout)
+	}
+	if _, err := m.ToPDF(strings.NewReader("")); err != ErrTimeout {
+		t.Fatalf("ToPDF: got %v, want %v", err, ErrTimeout)
+	}
+	if got, want := timeouts, uint64(2); got != want {
+		t.Fatalf("unexpected number of timeouts: got %d, want %d", got, want)
+	}
+}
+
+func TestMandocProducedOutput(t *testing.T) {
+	for status, want := range map[int]bool{
+		1: true,
+		2: true,
+		3: true,
+		4: true,  // unsupported
+		5: false, // bad arguments
+		6: false, // system error
+	} {
+		if got := mandocProducedOutput(status); got != want {
+			t.Errorf("mandocProducedOutput(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Like .PSPIC requests (see images.go), eqn(7) and tbl(7) blocks which
+// mandoc cannot express in HTML are replaced with placeholders before
+// converting a manpage, which are replaced with images (rendered by
+// other tools) afterwards.
+const blockPlaceholderPrefix = "DEBIMAN_BLOCK_"
+
+func blockPlaceholder(idx int) string {
+	return fmt.Sprintf("%s%d_", blockPlaceholderPrefix, idx)
+}
+
+// Block is an eqn(7) or tbl(7) block of a manpage.
+type Block struct {
+	// Preprocessor is either “eqn” or “tbl”.
+	Preprocessor string
+
+	// Source is the roff source of the block, including the
+	// .EQ/.EN or .TS/.TE lines.
+	Source string
+}
+
+// unsupportedTbl returns whether mandoc cannot express the tbl(7)
+// block consisting of lines in HTML. mandoc ignores roff requests
+// within the data section of tables (“ignoring macro in table”), which
+// manpages use e.g. for font changes or vertical spacing.
+func unsupportedTbl(lines []string) bool {
+	data := false
+	for _, line := range lines[1 : len(lines)-1] {
+		if !data {
+			// The format section ends with a line ending in a period.
+			data = strings.HasSuffix(strings.TrimSpace(line), ".")
+			continue
+		}
+		if (strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'")) &&
+			!strings.HasPrefix(line, ".T&") {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceBlocks replaces the eqn(7) and tbl(7) blocks in the roff
+// source src which mandoc cannot express in HTML with placeholders.
+// eqn blocks are only replaced if mathML is false, i.e. if mandoc does
+// not render them as MathML. The replaced blocks are returned in order,
+// so that InsertBlocks can later fill in the images.
+func ReplaceBlocks(src []byte, mathML bool) (out []byte, blocks []Block, err error) {
+	if !bytes.Contains(src, []byte(".EQ")) && !bytes.Contains(src, []byte(".TS")) {
+		return src, nil, nil
+	}
+	var (
+		buf     bytes.Buffer
+		pending []string // lines of the current block
+		end     string   // request which ends the current block
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if end == "" {
+			switch {
+			case strings.HasPrefix(line, ".EQ"):
+				end = ".EN"
+			case strings.HasPrefix(line, ".TS"):
+				end = ".TE"
+			default:
+				buf.WriteString(line + "\n")
+				continue
+			}
+			pending = []string{line}
+			continue
+		}
+		pending = append(pending, line)
+		if !strings.HasPrefix(line, end) {
+			continue
+		}
+		preprocessor := "tbl"
+		if end == ".EN" {
+			preprocessor = "eqn"
+		}
+		end = ""
+		if preprocessor == "eqn" && mathML ||
+			preprocessor == "tbl" && !unsupportedTbl(pending) {
+			buf.WriteString(strings.Join(pending, "\n") + "\n")
+			continue
+		}
+		fmt.Fprintf(&buf, ".br\n%s\n.br\n", blockPlaceholder(len(blocks)))
+		blocks = append(blocks, Block{
+			Preprocessor: preprocessor,
+			Source:       strings.Join(pending, "\n") + "\n",
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if end != "" {
+		// Unterminated block: leave it to mandoc.
+		buf.WriteString(strings.Join(pending, "\n") + "\n")
+	}
+	return buf.Bytes(), blocks, nil
+}
+
+// InsertBlocks replaces the placeholders which ReplaceBlocks inserted
+// into the roff source with <img> elements in the converted doc. urls
+// must contain one entry per block. Blocks whose URL is empty (e.g.
+// because rendering the image failed) are shown as roff source.
+func InsertBlocks(doc string, urls []string, blocks []Block) string {
+	for idx, url := range urls {
+		b := blocks[idx]
+		var repl string
+		if url != "" {
+			repl = fmt.Sprintf(`<img class="%s" src="%s" alt="%s"/>`, b.Preprocessor, html.EscapeString(url), html.EscapeString(b.Source))
+		} else {
+			repl = fmt.Sprintf(`<pre class="%s">%s</pre>`, b.Preprocessor, html.EscapeString(b.Source))
+		}
+		doc = strings.Replace(doc, blockPlaceholder(idx), repl, -1)
+	}
+	return doc
+}
+
+// allBold returns whether all cells of the table row tr only contain
+// bold text.
+func allBold(tr *html.Node) bool {
+	var cells int
+	for td := tr.FirstChild; td != nil; td = td.NextSibling {
+		if td.Type != html.ElementNode {
+			continue
+		}
+		if td.Data != "td" {
+			return false
+		}
+		cells++
+		for c := td.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+			if c.Type != html.ElementNode || c.Data != "b" {
+				return false
+			}
+		}
+	}
+	return cells > 0
+}
+
+// hasNextRow returns whether tr is followed by another table row.
+func hasNextRow(tr *html.Node) bool {
+	for c := tr.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "tr" {
+			return true
+		}
+	}
+	return false
+}
+
+// tableHeaders turns the first row of tbl(7) tables into a header row
+// (<thead> with <th> cells) if all of its cells are bold, which is how
+// manpages format the column names of tables.
+func tableHeaders(doc *html.Node) {
+	var tables []*html.Node
+	recurse(doc, func(n *html.Node) error {
+		if n.Type != html.ElementNode || n.Data != "table" {
+			return nil
+		}
+		for _, a := range n.Attr {
+			if a.Key == "class" && a.Val == "tbl" {
+				tables = append(tables, n)
+			}
+		}
+		return nil
+	})
+	for _, table := range tables {
+		// The parser wraps rows in a <tbody> element.
+		var tbody, tr *html.Node
+		for c := table.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "tbody" {
+				tbody = c
+				break
+			}
+		}
+		if tbody == nil {
+			continue
+		}
+		for c := tbody.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				tr = c
+				break
+			}
+		}
+		if tr == nil || tr.Data != "tr" || !hasNextRow(tr) || !allBold(tr) {
+			continue
+		}
+		for td := tr.FirstChild; td != nil; td = td.NextSibling {
+			if td.Type == html.ElementNode {
+				td.Data = "th"
+			}
+		}
+		thead := &html.Node{Type: html.ElementNode, Data: "thead"}
+		tbody.RemoveChild(tr)
+		thead.AppendChild(tr)
+		table.InsertBefore(thead, tbody)
+	}
+}
@@ -0,0 +1,122 @@
+package convert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplaceBlocks(t *testing.T) {
+	const input = `.SH EQUATIONS
+.EQ
+x sup 2
+.EN
+.TS
+l l.
+a	b
+.TE
+.TS
+l l.
+a	b
+.ft B
+c	d
+.TE
+.TS
+l.
+unterminated
+`
+	const want = `.SH EQUATIONS
+.br
+DEBIMAN_BLOCK_0_
+.br
+.TS
+l l.
+a	b
+.TE
+.br
+DEBIMAN_BLOCK_1_
+.br
+.TS
+l.
+unterminated
+`
+	wantBlocks := []Block{
+		{"eqn", ".EQ\nx sup 2\n.EN\n"},
+		{"tbl", ".TS\nl l.\na\tb\n.ft B\nc\td\n.TE\n"},
+	}
+	out, blocks, err := ReplaceBlocks([]byte(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != want {
+		t.Fatalf("unexpected output:\ngot  %q\nwant %q", got, want)
+	}
+	if !reflect.DeepEqual(blocks, wantBlocks) {
+		t.Fatalf("unexpected blocks: got %+v, want %+v", blocks, wantBlocks)
+	}
+
+	// With MathML, eqn blocks are left to mandoc.
+	_, blocks, err = ReplaceBlocks([]byte(input), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blocks, wantBlocks[1:]) {
+		t.Fatalf("unexpected blocks: got %+v, want %+v", blocks, wantBlocks[1:])
+	}
+}
+
+func TestInsertBlocks(t *testing.T) {
+	blocks := []Block{
+		{"eqn", ".EQ\nx < 2\n.EN\n"},
+		{"tbl", ".TS\nl.\na\n.TE\n"},
+	}
+	doc := "<p>DEBIMAN_BLOCK_0_</p><p>DEBIMAN_BLOCK_1_</p>"
+	got := InsertBlocks(doc, []string{"/a/eqn-0.png", ""}, blocks)
+	want := `<p><img class="eqn" src="/a/eqn-0.png" alt=".EQ
+x &lt; 2
+.EN
+"/></p><p><pre class="tbl">.TS
+l.
+a
+.TE
+</pre></p>`
+	if got != want {
+		t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestTableHeaders(t *testing.T) {
+	const input = `<div class="mandoc">
+<table class="tbl">
+<tr><td><b>Name</b></td><td><b>Value</b></td></tr>
+<tr><td>a</td><td>1</td></tr>
+</table>
+<table class="tbl">
+<tr><td><b>only row</b></td></tr>
+</table>
+<table class="tbl">
+<tr><td><b>Name</b></td><td>plain</td></tr>
+<tr><td>a</td><td>1</td></tr>
+</table>
+</div>`
+	const want = `<div class="mandoc">
+<table class="tbl">
+<thead><tr><th><b>Name</b></th><th><b>Value</b></th></tr></thead><tbody>
+<tr><td>a</td><td>1</td></tr>
+</tbody></table>
+<table class="tbl">
+<tbody><tr><td><b>only row</b></td></tr>
+</tbody></table>
+<table class="tbl">
+<tbody><tr><td><b>Name</b></td><td>plain</td></tr>
+<tr><td>a</td><td>1</td></tr>
+</tbody></table>
+</div>`
+	var p Process
+	got, _, err := p.postprocessHTML(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package convert
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Filter post-processes the HTML of a converted manpage. Filters run
+// after debiman’s own post-processing (see Process.Filters) and must be
+// safe for concurrent use, as one Filter is shared by all Processes.
+type Filter interface {
+	Filter(doc *html.Node) error
+}
+
+// FilterFunc is an adapter to use ordinary functions as Filters.
+type FilterFunc func(doc *html.Node) error
+
+// Filter calls f(doc).
+func (f FilterFunc) Filter(doc *html.Node) error {
+	return f(doc)
+}
+
+// filterFactories contains the filters which can be created by name
+// using NewFilter.
+var filterFactories = map[string]func(arg string) (Filter, error){
+	"strip":         newStripFilter,
+	"rewrite_links": newRewriteLinksFilter,
+	"anchor":        newAnchorFilter,
+}
+
+// RegisterFilter makes a filter available to NewFilter under name.
+// factory is called with the filter’s argument (which is empty if none
+// was specified). RegisterFilter is not safe for concurrent use and
+// should be called from an init function.
+func RegisterFilter(name string, factory func(arg string) (Filter, error)) {
+	if _, ok := filterFactories[name]; ok {
+		panic(fmt.Sprintf("filter %q registered twice", name))
+	}
+	filterFactories[name] = factory
+}
+
+// FilterNames returns the names of all registered filters.
+func FilterNames() []string {
+	names := make([]string, 0, len(filterFactories))
+	for name := range filterFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFilter creates the filter specified by spec, which is the name of
+// a registered filter, optionally followed by an equals sign and an
+// argument, e.g. “strip=table.head”.
+func NewFilter(spec string) (Filter, error) {
+	name, arg := spec, ""
+	if idx := strings.Index(spec, "="); idx > -1 {
+		name, arg = spec[:idx], spec[idx+1:]
+	}
+	factory, ok := filterFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q (known filters: %s)", name, strings.Join(FilterNames(), ", "))
+	}
+	f, err := factory(arg)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %v", name, err)
+	}
+	return f, nil
+}
+
+// selector is a simple CSS selector: an element name, a class name
+// (“.class”) or both (“element.class”).
+type selector struct {
+	element, class string
+}
+
+func parseSelector(s string) (selector, error) {
+	if s == "" || s == "." {
+		return selector{}, fmt.Errorf("empty selector")
+	}
+	var sel selector
+	sel.element = s
+	if idx := strings.Index(s, "."); idx > -1 {
+		sel.element, sel.class = s[:idx], s[idx+1:]
+	}
+	return sel, nil
+}
+
+func (s selector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if s.element != "" && n.Data != s.element {
+		return false
+	}
+	if s.class == "" {
+		return true
+	}
+	for _, a := range n.Attr {
+		if a.Key != "class" {
+			continue
+		}
+		for _, c := range strings.Fields(a.Val) {
+			if c == s.class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matching returns all elements in doc which sel matches, in document
+// order.
+func (s selector) matching(doc *html.Node) []*html.Node {
+	var nodes []*html.Node
+	recurse(doc, func(n *html.Node) error {
+		if s.matches(n) {
+			nodes = append(nodes, n)
+		}
+		return nil
+	})
+	return nodes
+}
+
+// newStripFilter returns a filter which removes all elements matching
+// the selector arg, e.g. “strip=table.foot”.
+func newStripFilter(arg string) (Filter, error) {
+	sel, err := parseSelector(arg)
+	if err != nil {
+		return nil, err
+	}
+	return FilterFunc(func(doc *html.Node) error {
+		for _, n := range sel.matching(doc) {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+		}
+		return nil
+	}), nil
+}
+
+// newRewriteLinksFilter returns a filter which replaces the prefix
+// from of link targets with to, specified as “from|to”, e.g.
+// “rewrite_links=https://manpages.debian.org/|/”.
+func newRewriteLinksFilter(arg string) (Filter, error) {
+	parts := strings.SplitN(arg, "|", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("expected argument of the form from|to, got %q", arg)
+	}
+	from, to := parts[0], parts[1]
+	return FilterFunc(func(doc *html.Node) error {
+		recurse(doc, func(n *html.Node) error {
+			if n.Type != html.ElementNode || n.Data != "a" {
+				return nil
+			}
+			for idx, a := range n.Attr {
+				if a.Key == "href" && strings.HasPrefix(a.Val, from) {
+					n.Attr[idx].Val = to + strings.TrimPrefix(a.Val, from)
+				}
+			}
+			return nil
+		})
+		return nil
+	}), nil
+}
+
+// newAnchorFilter returns a filter which adds an anchor link (like the
+// one added to headings) to all elements which match the selector arg
+// and have an id, e.g. “anchor=dt”.
+func newAnchorFilter(arg string) (Filter, error) {
+	sel, err := parseSelector(arg)
+	if err != nil {
+		return nil, err
+	}
+	return FilterFunc(func(doc *html.Node) error {
+		for _, n := range sel.matching(doc) {
+			var id string
+			for _, a := range n.Attr {
+				if a.Key == "id" {
+					id = a.Val
+				}
+			}
+			if id == "" {
+				continue
+			}
+			u := url.URL{Fragment: id}
+			a := &html.Node{
+				Type: html.ElementNode,
+				Data: "a",
+				Attr: []html.Attribute{
+					{Key: "class", Val: "anchor"},
+					{Key: "href", Val: u.String()},
+				},
+			}
+			a.AppendChild(&html.Node{
+				Type: html.TextNode,
+				Data: "¶",
+			})
+			n.AppendChild(a)
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestNewFilter(t *testing.T) {
+	for _, spec := range []string{"unknown", "strip", "strip=.", "rewrite_links=/"} {
+		if _, err := NewFilter(spec); err == nil {
+			t.Errorf("NewFilter(%q) unexpectedly succeeded", spec)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	const input = `<div class="mandoc">
+<table class="head"><tr><td>I3(1)</td></tr></table>
+<p><a href="https://manpages.debian.org/i3lock(1)">i3lock(1)</a></p>
+<dl><dt id="x">x</dt><dt>y</dt></dl>
+</div>`
+	const want = `<div class="mandoc">
+
+<p><a href="/i3lock(1)">i3lock(1)</a></p>
+<dl><dt id="x">x<a class="anchor" href="#x">¶</a></dt><dt>y</dt></dl>
+</div>`
+	var p Process
+	for _, spec := range []string{"strip=table.head", "rewrite_links=https://manpages.debian.org/|/", "anchor=dt"} {
+		f, err := NewFilter(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Filters = append(p.Filters, f)
+	}
+	got, _, err := p.postprocessHTML(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("test_upper", func(arg string) (Filter, error) {
+		return FilterFunc(func(doc *html.Node) error {
+			recurse(doc, func(n *html.Node) error {
+				if n.Type == html.TextNode {
+					n.Data = strings.ToUpper(n.Data)
+				}
+				return nil
+			})
+			return nil
+		}), nil
+	})
+	defer delete(filterFactories, "test_upper")
+	f, err := NewFilter("test_upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Process{Filters: []Filter{f}}
+	got, _, err := p.postprocessHTML(`<p>foo</p>`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<p>FOO</p>`; got != want {
+		t.Fatalf("unexpected output: got %q, want %q", got, want)
+	}
+}
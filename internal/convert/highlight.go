@@ -0,0 +1,271 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// token is a piece of highlighted source code. class is empty for
+// text which is not highlighted.
+type token struct {
+	class string
+	text  string
+}
+
+var cKeywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true,
+	"const": true, "continue": true, "default": true, "do": true,
+	"double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "if": true,
+	"int": true, "long": true, "register": true, "return": true,
+	"short": true, "signed": true, "sizeof": true, "static": true,
+	"struct": true, "switch": true, "typedef": true, "union": true,
+	"unsigned": true, "void": true, "volatile": true, "while": true,
+}
+
+var shKeywords = map[string]bool{
+	"case": true, "do": true, "done": true, "elif": true,
+	"else": true, "esac": true, "export": true, "fi": true,
+	"for": true, "function": true, "if": true, "in": true,
+	"local": true, "then": true, "until": true, "while": true,
+}
+
+var cDeclaration = regexp.MustCompile(`\b(int|void|char|unsigned|struct|static)\s+\**\w+\s*[(;=\[]`)
+
+// detectLanguage returns the language of the code example text, “c”
+// or “sh”. Code in EXAMPLES sections is most commonly a shell command
+// line, so “sh” is assumed unless text looks like C.
+func detectLanguage(text string) string {
+	if strings.Contains(text, "#include") || cDeclaration.MatchString(text) {
+		return "c"
+	}
+	return "sh"
+}
+
+func isIdentStart(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_'
+}
+
+func isIdent(b byte) bool {
+	return isIdentStart(b) || '0' <= b && b <= '9'
+}
+
+// lineStart returns whether only whitespace precedes position i in its
+// line.
+func lineStart(text string, i int) bool {
+	for j := i - 1; j >= 0 && text[j] != '\n'; j-- {
+		if text[j] != ' ' && text[j] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// commandStart returns whether a shell command starts at position i,
+// so that e.g. “for” is not highlighted in “ls for”.
+func commandStart(text string, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		switch text[j] {
+		case ' ', '\t':
+			continue
+		case '\n', ';', '|', '&', '(':
+			return true
+		case '$':
+			return lineStart(text, j) // prompt
+		}
+		return false
+	}
+	return true
+}
+
+// quoted returns the end of the string starting with the quote at
+// position i (or the end of text if the string is not terminated).
+func quoted(text string, i int) int {
+	quote := text[i]
+	for j := i + 1; j < len(text); j++ {
+		if text[j] == '\\' && quote != '\'' {
+			j++
+			continue
+		}
+		if text[j] == quote {
+			return j + 1
+		}
+	}
+	return len(text)
+}
+
+// untilEOL returns the position of the end of the line at position i.
+func untilEOL(text string, i int) int {
+	if idx := strings.IndexByte(text[i:], '\n'); idx > -1 {
+		return i + idx
+	}
+	return len(text)
+}
+
+// highlight splits text, which is code in language lang (see
+// detectLanguage), into tokens.
+func highlight(text, lang string) []token {
+	var tokens []token
+	var plain int // start of the pending plain text
+	emit := func(start, end int, class string) {
+		if start > plain {
+			tokens = append(tokens, token{text: text[plain:start]})
+		}
+		tokens = append(tokens, token{class: class, text: text[start:end]})
+		plain = end
+	}
+	keywords := shKeywords
+	if lang == "c" {
+		keywords = cKeywords
+	}
+
+	for i := 0; i < len(text); {
+		b := text[i]
+		var end int
+		var class string
+		switch {
+		case lang == "sh" && b == '$' && lineStart(text, i) && strings.HasPrefix(text[i:], "$ "):
+			end, class = i+2, "hl-prompt"
+
+		case lang == "sh" && b == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t' || text[i-1] == '\n'):
+			end, class = untilEOL(text, i), "hl-comment"
+
+		case lang == "sh" && b == '$' && i+1 < len(text) && text[i+1] == '{':
+			end, class = len(text), "hl-variable"
+			if idx := strings.IndexByte(text[i:], '}'); idx > -1 {
+				end = i + idx + 1
+			}
+
+		case lang == "sh" && b == '$' && i+1 < len(text) && isIdent(text[i+1]):
+			end, class = i+2, "hl-variable"
+			for end < len(text) && isIdent(text[end]) {
+				end++
+			}
+
+		case lang == "c" && b == '#' && lineStart(text, i):
+			end, class = untilEOL(text, i), "hl-preproc"
+
+		case lang == "c" && strings.HasPrefix(text[i:], "//"):
+			end, class = untilEOL(text, i), "hl-comment"
+
+		case lang == "c" && strings.HasPrefix(text[i:], "/*"):
+			end, class = len(text), "hl-comment"
+			if idx := strings.Index(text[i+2:], "*/"); idx > -1 {
+				end = i + 2 + idx + 2
+			}
+
+		case b == '"' || b == '\'':
+			end, class = quoted(text, i), "hl-string"
+
+		case lang == "c" && '0' <= b && b <= '9' && (i == 0 || !isIdent(text[i-1])):
+			end, class = i+1, "hl-number"
+			for end < len(text) && (isIdent(text[end]) || text[end] == '.') {
+				end++
+			}
+
+		case isIdentStart(b) && (i == 0 || !isIdent(text[i-1]) && text[i-1] != '-'):
+			end = i + 1
+			for end < len(text) && isIdent(text[end]) {
+				end++
+			}
+			if keywords[text[i:end]] && (lang == "c" || commandStart(text, i)) {
+				class = "hl-keyword"
+			}
+		}
+		if class == "" {
+			if end > i {
+				i = end
+			} else {
+				i++
+			}
+			continue
+		}
+		emit(i, end, class)
+		i = end
+	}
+	if plain < len(text) {
+		tokens = append(tokens, token{text: text[plain:]})
+	}
+	return tokens
+}
+
+// highlightPre highlights all text within the <pre> element pre.
+func highlightPre(pre *html.Node) {
+	lang := detectLanguage(plaintext(pre))
+	class := "hl hl-" + lang
+	for idx, a := range pre.Attr {
+		if a.Key == "class" {
+			pre.Attr[idx].Val += " " + class
+			class = ""
+		}
+	}
+	if class != "" {
+		pre.Attr = append(pre.Attr, html.Attribute{Key: "class", Val: class})
+	}
+	var texts []*html.Node
+	recurse(pre, func(n *html.Node) error {
+		if n.Type == html.TextNode {
+			texts = append(texts, n)
+		}
+		return nil
+	})
+	for _, n := range texts {
+		for _, t := range highlight(n.Data, lang) {
+			text := &html.Node{Type: html.TextNode, Data: t.text}
+			if t.class == "" {
+				n.Parent.InsertBefore(text, n)
+				continue
+			}
+			span := &html.Node{
+				Type: html.ElementNode,
+				Data: "span",
+				Attr: []html.Attribute{{Key: "class", Val: t.class}},
+			}
+			span.AppendChild(text)
+			n.Parent.InsertBefore(span, n)
+		}
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// highlightExamples highlights the <pre> elements (code examples) in
+// the EXAMPLES section of doc.
+func highlightExamples(doc *html.Node) {
+	var examples []*html.Node
+	recurse(doc, func(n *html.Node) error {
+		if n.Type != html.ElementNode || n.Data != "h1" {
+			return nil
+		}
+		title := strings.TrimSpace(strings.TrimSuffix(plaintext(n), "¶"))
+		if title == "EXAMPLES" || title == "EXAMPLE" {
+			examples = append(examples, n)
+		}
+		return nil
+	})
+	for _, h1 := range examples {
+		// The section extends until the next heading. Depending on
+		// the mandoc version, sections are either wrapped in an
+		// element or flat, so only siblings are considered.
+		var pres []*html.Node
+		for s := h1.NextSibling; s != nil; s = s.NextSibling {
+			if s.Type == html.ElementNode && s.Data == "h1" {
+				break
+			}
+			if s.Type == html.ElementNode && s.Data == "pre" {
+				pres = append(pres, s)
+				continue
+			}
+			recurse(s, func(n *html.Node) error {
+				if n.Type == html.ElementNode && n.Data == "pre" {
+					pres = append(pres, n)
+				}
+				return nil
+			})
+		}
+		for _, pre := range pres {
+			highlightPre(pre)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{"$ ls -l | grep foo\n", "sh"},
+		{"find . -name '*.c' -exec rm {} \\;\n", "sh"},
+		{"#include <stdio.h>\n", "c"},
+		{"int main(int argc, char *argv[]) {\n", "c"},
+		{"static int counter;\n", "c"},
+	} {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// markup renders tokens in a compact form for comparisons.
+func markup(tokens []token) string {
+	var parts []string
+	for _, t := range tokens {
+		if t.class == "" {
+			parts = append(parts, t.text)
+		} else {
+			parts = append(parts, "["+t.class+":"+t.text+"]")
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func TestHighlight(t *testing.T) {
+	for _, tt := range []struct {
+		lang string
+		text string
+		want string
+	}{
+		{
+			lang: "sh",
+			text: "$ for f in *.txt; do echo \"$f\" ${HOME}; done # done\n",
+			want: "[hl-prompt:$ ][hl-keyword:for] f in *.txt; [hl-keyword:do] echo [hl-string:\"$f\"] [hl-variable:${HOME}]; [hl-keyword:done] [hl-comment:# done]\n",
+		},
+
+		{
+			lang: "sh",
+			text: "ls for-each done\n",
+			want: "ls for-each done\n",
+		},
+
+		{
+			lang: "sh",
+			text: "echo $HOME/foo#bar\n",
+			want: "echo [hl-variable:$HOME]/foo#bar\n",
+		},
+
+		{
+			lang: "c",
+			text: "#include <stdio.h>\nint main(void) {\n\treturn 0; /* ok */ // \"done\"\n}\n",
+			want: "[hl-preproc:#include <stdio.h>]\n[hl-keyword:int] main([hl-keyword:void]) {\n\t[hl-keyword:return] [hl-number:0]; [hl-comment:/* ok */] [hl-comment:// \"done\"]\n}\n",
+		},
+	} {
+		if got := markup(highlight(tt.text, tt.lang)); got != tt.want {
+			t.Errorf("highlight(%q, %q):\ngot  %q\nwant %q", tt.text, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightExamples(t *testing.T) {
+	const input = `<div class="mandoc">
+<h1 class="Sh" id="DESCRIPTION">DESCRIPTION</h1>
+<pre>$ ls</pre>
+<h1 class="Sh" id="EXAMPLES">EXAMPLES</h1>
+<div class="Bd-indent"><pre class="Li">$ <b>ls</b> "foo"</pre></div>
+<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1>
+<pre>$ ls</pre>
+</div>`
+	const want = `<div class="mandoc">
+<h1 class="Sh" id="DESCRIPTION">DESCRIPTION<a class="anchor" href="#DESCRIPTION">¶</a></h1><div itemprop="articleBody">
+<pre>$ ls</pre>
+</div><h1 class="Sh" id="EXAMPLES">EXAMPLES<a class="anchor" href="#EXAMPLES">¶</a></h1>
+<div class="Bd-indent"><pre class="Li hl hl-sh"><span class="hl-prompt">$ </span><b>ls</b> <span class="hl-string">&#34;foo&#34;</span></pre></div>
+<h1 class="Sh" id="SEE_ALSO">SEE ALSO<a class="anchor" href="#SEE_ALSO">¶</a></h1>
+<pre>$ ls</pre>
+</div>`
+	p := &Process{HighlightExamples: true}
+	got, _, err := p.postprocessHTML(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"path"
+	"strings"
+)
+
+// mandoc(1) does not support images, so .PSPIC requests (see
+// groff_tmac(5)) are replaced with placeholders before converting a
+// manpage, which are replaced with <img> elements afterwards.
+const imagePlaceholderPrefix = "DEBIMAN_IMAGE_"
+
+func imagePlaceholder(idx int) string {
+	return fmt.Sprintf("%s%d_", imagePlaceholderPrefix, idx)
+}
+
+// SplitPSPIC splits line into its fields if it is a .PSPIC request,
+// i.e. “.PSPIC [-L|-R|-C|-I n] file [width [height]]”. file is the
+// index of the file argument within fields, or -1 if line is not a
+// (valid) .PSPIC request.
+func SplitPSPIC(line string) (fields []string, file int) {
+	fields = strings.Fields(line)
+	if len(fields) < 2 || fields[0] != ".PSPIC" {
+		return nil, -1
+	}
+	for i := 1; i < len(fields); i++ {
+		switch {
+		case fields[i] == "-L" || fields[i] == "-R" || fields[i] == "-C":
+			continue
+		case fields[i] == "-I":
+			i++ // skip the indentation argument
+			continue
+		case strings.HasPrefix(fields[i], "-I"):
+			continue
+		}
+		return fields, i
+	}
+	return nil, -1
+}
+
+// ReplaceImages replaces all .PSPIC requests in the roff source src
+// with placeholders. The paths of the referenced images are returned
+// in order, so that InsertImages can later fill in the <img> elements.
+func ReplaceImages(src []byte) (out []byte, images []string, err error) {
+	if !bytes.Contains(src, []byte(".PSPIC")) {
+		return src, nil, nil
+	}
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields, file := SplitPSPIC(line)
+		if file == -1 {
+			buf.WriteString(line + "\n")
+			continue
+		}
+		fmt.Fprintf(&buf, ".br\n%s\n.br\n", imagePlaceholder(len(images)))
+		images = append(images, fields[file])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), images, nil
+}
+
+// InsertImages replaces the placeholders which ReplaceImages inserted
+// into the roff source with <img> elements in the converted doc. urls
+// must contain one entry per image returned by ReplaceImages. Images
+// whose URL is empty are omitted.
+func InsertImages(doc string, urls []string) string {
+	for idx, url := range urls {
+		var img string
+		if url != "" {
+			img = fmt.Sprintf(`<img src="%s" alt="%s"/>`, html.EscapeString(url), html.EscapeString(path.Base(url)))
+		}
+		doc = strings.Replace(doc, imagePlaceholder(idx), img, -1)
+	}
+	return doc
+}
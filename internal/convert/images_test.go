@@ -0,0 +1,60 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPSPIC(t *testing.T) {
+	for _, entry := range []struct {
+		line string
+		want string
+	}{
+		{".PSPIC /usr/share/doc/foo/diagram.png", "/usr/share/doc/foo/diagram.png"},
+		{".PSPIC -C diagram.png 3i", "diagram.png"},
+		{".PSPIC -I 2n diagram.png", "diagram.png"},
+		{".PSPIC -I2n diagram.png", "diagram.png"},
+		{".PSPIC", ""},
+		{".PSPIC -L", ""},
+		{".SH PSPIC", ""},
+	} {
+		fields, file := SplitPSPIC(entry.line)
+		var got string
+		if file > -1 {
+			got = fields[file]
+		}
+		if got != entry.want {
+			t.Errorf("SplitPSPIC(%q): got %q, want %q", entry.line, got, entry.want)
+		}
+	}
+}
+
+func TestImages(t *testing.T) {
+	src := `.TH FOO 1
+.SH DESCRIPTION
+.PSPIC -C /usr/share/doc/foo/a&b.png
+text
+.PSPIC /usr/share/doc/foo/diagram.eps
+`
+	out, images, err := ReplaceImages([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(images), 2; got != want {
+		t.Fatalf("unexpected number of images: got %d, want %d", got, want)
+	}
+	if got, want := images[0], "/usr/share/doc/foo/a&b.png"; got != want {
+		t.Fatalf("unexpected image: got %q, want %q", got, want)
+	}
+	if strings.Contains(string(out), ".PSPIC") {
+		t.Fatalf(".PSPIC request not replaced: %q", out)
+	}
+
+	// Simulate mandoc(1) passing through the placeholders as text.
+	doc := "<p>" + imagePlaceholder(0) + "</p><p>" + imagePlaceholder(1) + "</p>"
+	got := InsertImages(doc, []string{"/jessie/foo/foo.1.en.assets/a&b.png", ""})
+	want := `<p><img src="/jessie/foo/foo.1.en.assets/a&amp;b.png" alt="a&amp;b.png"/></p><p></p>`
+	if got != want {
+		t.Fatalf("unexpected InsertImages result: got %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package convert
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,24 +11,96 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// Mandoc specifies which mandoc binary is run, and how.
+type Mandoc struct {
+	// Path is the path to the mandoc binary. If empty, mandoc is looked
+	// up in $PATH. mandocd(8) is expected in the same directory.
+	Path string
+
+	// Args are passed to mandoc in addition to the arguments debiman
+	// uses when converting manpages to HTML, e.g. -Oman=%N.%S. As
+	// mandocd(8) does not accept arbitrary arguments, a mandoc process
+	// is forked for each manpage if Args is non-empty.
+	Args []string
+
+	// Timeout, if non-zero, limits the time which converting a single
+	// manpage using ToText, ToPDF or ToMarkdown may take. mandoc is
+	// killed when the timeout expires, and ErrTimeout is returned.
+	// Conversions to HTML are limited by Process.Timeout instead.
+	Timeout time.Duration
+
+	// Timeouts, if non-nil, is atomically incremented for each
+	// conversion which exceeded Timeout.
+	Timeouts *uint64
+}
+
+// command returns a command running mandoc with args.
+func (m Mandoc) command(args ...string) *exec.Cmd {
+	return m.commandContext(context.Background(), args...)
+}
+
+// commandContext is like command, but mandoc is killed once ctx is
+// done.
+func (m Mandoc) commandContext(ctx context.Context, args ...string) *exec.Cmd {
+	path := m.Path
+	if path == "" {
+		path = "mandoc"
+	}
+	return exec.CommandContext(ctx, path, args...)
+}
+
+// htmlCommand returns a command converting a manpage to HTML, using
+// the HTML output options args and m.Args.
+func (m Mandoc) htmlCommand(args ...string) *exec.Cmd {
+	return m.command(append(args, m.Args...)...)
+}
+
 // Process starts a mandoc process to convert manpages to HTML.
 type Process struct {
+	// HighlightExamples enables syntax highlighting of the code
+	// examples in the EXAMPLES section of manpages.
+	HighlightExamples bool
+
+	// Filters are applied in order to the HTML of every converted
+	// manpage, after debiman’s own post-processing.
+	Filters []Filter
+
+	// Timeout, if non-zero, limits the time which converting a single
+	// manpage may take, so that pathological input cannot stall
+	// rendering. mandoc is killed (and mandocd is restarted) when the
+	// timeout expires, and ErrTimeout is returned.
+	Timeout time.Duration
+
+	timeouts      uint64
+	config        Mandoc
 	mandocConn    *net.UnixConn
 	mandocProcess *os.Process
 	stopWait      chan bool
 }
 
-func NewProcess() (*Process, error) {
-	p := &Process{}
+// ErrTimeout is returned when converting a manpage takes longer than
+// Process.Timeout.
+var ErrTimeout = errors.New("mandoc timed out")
+
+func NewProcess(m Mandoc) (*Process, error) {
+	p := &Process{config: m}
 	return p, p.initMandoc()
 }
 
+// Timeouts returns how many conversions exceeded p.Timeout.
+func (p *Process) Timeouts() uint64 {
+	return p.timeouts
+}
+
 func (p *Process) Kill() error {
 	if p.mandocProcess == nil {
 		return nil
@@ -36,6 +110,11 @@ func (p *Process) Kill() error {
 }
 
 func (p *Process) initMandoc() error {
+	if len(p.config.Args) > 0 {
+		log.Printf("additional mandoc arguments given, falling back to fork+exec for each manpage")
+		return nil
+	}
+
 	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
 	if err != nil {
 		return err
@@ -50,9 +129,13 @@ func (p *Process) initMandoc() error {
 	}
 	conn := fc.(*net.UnixConn)
 
-	path, err := exec.LookPath("mandocd")
+	mandocd := "mandocd"
+	if p.config.Path != "" {
+		mandocd = filepath.Join(filepath.Dir(p.config.Path), "mandocd")
+	}
+	path, err := exec.LookPath(mandocd)
 	if err != nil {
-		if ee, ok := err.(*exec.Error); ok && ee.Err == exec.ErrNotFound {
+		if ee, ok := err.(*exec.Error); ok && (ee.Err == exec.ErrNotFound || os.IsNotExist(ee.Err)) {
 			log.Printf("mandocd not found, falling back to fork+exec for each manpage")
 			return nil
 		}
@@ -92,33 +175,218 @@ func (p *Process) initMandoc() error {
 
 func (p *Process) mandoc(r io.Reader) (stdout string, stderr string, err error) {
 	if p.mandocConn != nil {
-		stdout, stderr, err = p.mandocUnix(r)
+		stdout, stderr, err = p.mandocUnixWatchdog(r)
 	} else {
 		stdout, stderr, err = p.mandocFork(r)
 	}
+	if err == nil {
+		stdout = wrapMandocDiv(stdout)
+	}
+	return stdout, stderr, err
+}
+
+// wrapMandocDiv wraps stdout in a <div class="mandoc"> element, unless
+// mandoc already did that.
+func wrapMandocDiv(stdout string) string {
 	// TODO(later): once a new-enough version of mandoc is in Debian,
 	// get rid of this compatibility code by changing our CSS to not
 	// rely on the mandoc class at all anymore.
-	if err == nil && !strings.HasPrefix(stdout, `<div class="mandoc">`) {
-		stdout = `<div class="mandoc">
+	if !strings.HasPrefix(stdout, `<div class="mandoc">`) {
+		return `<div class="mandoc">
 ` + stdout + `</div>
 `
 	}
-	return stdout, stderr, err
+	return stdout
 }
 
 func (p *Process) mandocFork(r io.Reader) (stdout string, stderr string, err error) {
 	var stdoutb, stderrb bytes.Buffer
-	cmd := exec.Command("mandoc", "-Ofragment", "-Thtml")
+	cmd := p.config.htmlCommand("-Ofragment", "-Thtml")
 	cmd.Stdin = r
 	cmd.Stdout = &stdoutb
 	cmd.Stderr = &stderrb
-	if err := cmd.Run(); err != nil {
+	if err := p.run(cmd); err != nil {
 		return "", "", fmt.Errorf("%v, stderr: %s", err, stderrb.String())
 	}
 	return stdoutb.String(), stderrb.String(), nil
 }
 
+// run runs cmd, killing it if it exceeds p.Timeout.
+func (p *Process) run(cmd *exec.Cmd) error {
+	if p.Timeout == 0 {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(p.Timeout):
+	}
+	p.timeouts++
+	cmd.Process.Kill()
+	<-done
+	return ErrTimeout
+}
+
+// mandocUnsupported is the exit status of mandoc when it encountered
+// features it does not support. The output is still written, but may
+// be incomplete.
+const mandocUnsupported = 4
+
+// mandocProducedOutput returns whether mandoc still produced output
+// when exiting with status: it exits with status 1 (style), 2
+// (warning), 3 (error) or 4 (unsupported) when it encountered messages
+// of that level, whereas status 5 and higher mean that it failed.
+func mandocProducedOutput(status int) bool {
+	return status >= 1 && status <= mandocUnsupported
+}
+
+// mandocWarnings is like mandocFork, but makes mandoc report
+// warnings, which are returned line by line. mandocd(8) does not
+// offer a way to report warnings, hence a process is forked for each
+// manpage.
+func (p *Process) mandocWarnings(r io.Reader) (stdout string, warnings []string, err error) {
+	var stdoutb, stderrb bytes.Buffer
+	cmd := p.config.htmlCommand("-Ofragment", "-Thtml", "-Wwarning")
+	cmd.Stdin = r
+	cmd.Stdout = &stdoutb
+	cmd.Stderr = &stderrb
+	var unsupported bool
+	if err := p.run(cmd); err != nil {
+		ee, ok := err.(*exec.ExitError)
+		if !ok {
+			return "", nil, fmt.Errorf("%v, stderr: %s", err, stderrb.String())
+		}
+		ws, ok := ee.Sys().(syscall.WaitStatus)
+		if !ok || !mandocProducedOutput(ws.ExitStatus()) {
+			return "", nil, fmt.Errorf("%v, stderr: %s", err, stderrb.String())
+		}
+		unsupported = ws.ExitStatus() == mandocUnsupported
+	}
+	if msgs := strings.TrimSpace(stderrb.String()); msgs != "" {
+		warnings = strings.Split(msgs, "\n")
+	}
+	if unsupported {
+		warnings = append(warnings, "mandoc: unsupported features, the output may be incomplete")
+	}
+	return wrapMandocDiv(stdoutb.String()), warnings, nil
+}
+
+// ToText converts the manpage r to plain text using mandoc -Tutf8.
+func (m Mandoc) ToText(r io.Reader) (string, error) {
+	out, err := m.output(r, "utf8")
+	if err != nil {
+		return "", err
+	}
+	return stripOverstrike(string(out)), nil
+}
+
+// ToPDF converts the manpage r to PDF using mandoc -Tpdf.
+func (m Mandoc) ToPDF(r io.Reader) ([]byte, error) {
+	return m.output(r, "pdf")
+}
+
+// ToMarkdown converts the manpage r to Markdown using mandoc
+// -Tmarkdown, which requires Features.Markdown. Note that mandoc only
+// supports mdoc(7) input for Markdown output, so the result is empty
+// for man(7) manpages.
+func (m Mandoc) ToMarkdown(r io.Reader) (string, error) {
+	out, err := m.output(r, "markdown")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// output converts the manpage r to the specified mandoc output
+// format. mandocd(8) only produces the output format it was started
+// with, hence a mandoc process is forked instead of using a Process.
+// m.Args are not passed, as they are specific to the HTML output.
+func (m Mandoc) output(r io.Reader, format string) ([]byte, error) {
+	ctx := context.Background()
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	var stdoutb, stderrb bytes.Buffer
+	cmd := m.commandContext(ctx, "-T"+format)
+	cmd.Stdin = r
+	cmd.Stdout = &stdoutb
+	cmd.Stderr = &stderrb
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			if m.Timeouts != nil {
+				atomic.AddUint64(m.Timeouts, 1)
+			}
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("running mandoc -T%s failed: %v, stderr: %s", format, err, stderrb.String())
+	}
+	return stdoutb.Bytes(), nil
+}
+
+// stripOverstrike removes the backspace sequences which mandoc uses
+// for bold (“x\bx”) and underlined (“_\bx”) text in terminal output.
+func stripOverstrike(s string) string {
+	if !strings.Contains(s, "\b") {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == '\b' {
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// mandocUnixWatchdog is like mandocUnix, but restarts mandocd if
+// converting r exceeds p.Timeout.
+func (p *Process) mandocUnixWatchdog(r io.Reader) (stdout string, stderr string, err error) {
+	if p.Timeout == 0 {
+		return p.mandocUnix(r)
+	}
+	type result struct {
+		stdout, stderr string
+		err            error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stdout, stderr, err := p.mandocUnix(r)
+		done <- result{stdout, stderr, err}
+	}()
+	select {
+	case res := <-done:
+		return res.stdout, res.stderr, res.err
+	case <-time.After(p.Timeout):
+	}
+	p.timeouts++
+	// Killing mandocd closes its ends of the pipes, which unblocks
+	// mandocUnix.
+	if err := p.Kill(); err != nil {
+		return "", "", err
+	}
+	<-done
+	p.mandocConn.Close()
+	p.mandocConn = nil
+	p.mandocProcess = nil
+	if err := p.initMandoc(); err != nil {
+		return "", "", fmt.Errorf("restarting mandocd: %v", err)
+	}
+	return "", "", ErrTimeout
+}
+
 func (p *Process) mandocUnix(r io.Reader) (stdout string, stderr string, err error) {
 	manr, manw, err := os.Pipe()
 	if err != nil {
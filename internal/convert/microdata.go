@@ -0,0 +1,177 @@
+package convert
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// nameSeparators separate the names from the description in the NAME
+// section of man(7) (“ls - list …”) and mdoc(7) (“ls — list …”)
+// manpages.
+var nameSeparators = []string{" - ", " — ", " − "}
+
+// sectionContent returns the nodes following the section heading h1 up
+// to the next section heading. Sections are either flat (older mandoc
+// versions) or wrapped in <section> elements; in both cases, the
+// content consists of siblings of h1.
+func sectionContent(h1 *html.Node) []*html.Node {
+	var content []*html.Node
+	for c := h1.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "h1" {
+			break
+		}
+		content = append(content, c)
+	}
+	return content
+}
+
+// wrapNodes moves nodes (which must be consecutive siblings) into a new
+// <div> element with the specified attributes and returns it.
+func wrapNodes(nodes []*html.Node, attr ...html.Attribute) *html.Node {
+	div := &html.Node{
+		Type: html.ElementNode,
+		Data: "div",
+		Attr: attr,
+	}
+	nodes[0].Parent.InsertBefore(div, nodes[0])
+	for _, n := range nodes {
+		n.Parent.RemoveChild(n)
+		div.AppendChild(n)
+	}
+	return div
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for idx, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[idx].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func textSpan(itemprop, text string) *html.Node {
+	span := &html.Node{
+		Type: html.ElementNode,
+		Data: "span",
+		Attr: []html.Attribute{{Key: "itemprop", Val: itemprop}},
+	}
+	span.AppendChild(&html.Node{
+		Type: html.TextNode,
+		Data: text,
+	})
+	return span
+}
+
+// nameMicrodata marks up the names and the description in the content
+// of the NAME section as the name and abstract of the article.
+func nameMicrodata(content []*html.Node) {
+	// mdoc(7) manpages use .Nm and .Nd macros, which mandoc renders
+	// with the corresponding classes.
+	var found bool
+	mark := func(c *html.Node) error {
+		if (selector{class: "Nm"}).matches(c) {
+			setAttr(c, "itemprop", "name")
+			found = true
+		}
+		if (selector{class: "Nd"}).matches(c) {
+			setAttr(c, "itemprop", "abstract")
+			found = true
+		}
+		return nil
+	}
+	for _, n := range content {
+		// recurse does not call mark for n itself.
+		mark(n)
+		recurse(n, mark)
+	}
+	if found {
+		return
+	}
+
+	// man(7) manpages contain plain text, e.g. “ls - list directory
+	// contents”, which is only marked up if the names and the description
+	// are contained in the same text node.
+	for _, n := range content {
+		if n.Type != html.TextNode {
+			continue
+		}
+		for _, sep := range nameSeparators {
+			idx := strings.Index(n.Data, sep)
+			if idx == -1 {
+				continue
+			}
+			names, desc := n.Data[:idx], n.Data[idx+len(sep):]
+			namesTrimmed := strings.TrimLeft(names, " \t\n")
+			descTrimmed := strings.TrimRight(desc, " \t\n")
+			if namesTrimmed == "" || descTrimmed == "" {
+				return
+			}
+			parent := n.Parent
+			for _, r := range []*html.Node{
+				{Type: html.TextNode, Data: names[:len(names)-len(namesTrimmed)]},
+				textSpan("name", namesTrimmed),
+				{Type: html.TextNode, Data: sep},
+				textSpan("abstract", descTrimmed),
+				{Type: html.TextNode, Data: desc[len(descTrimmed):]},
+			} {
+				if r.Type == html.TextNode && r.Data == "" {
+					continue
+				}
+				parent.InsertBefore(r, n)
+			}
+			parent.RemoveChild(n)
+			return
+		}
+		if strings.TrimSpace(n.Data) != "" {
+			return
+		}
+	}
+}
+
+// microdata adds schema.org microdata to the NAME, SYNOPSIS and
+// DESCRIPTION sections of doc, so that search engines can display the
+// name, description and usage of a manpage in their results. Only the
+// English section headings are recognized. The properties belong to the
+// schema.org TechArticle which the manpage template declares.
+func microdata(doc *html.Node) {
+	var headings []*html.Node
+	recurse(doc, func(n *html.Node) error {
+		if n.Type == html.ElementNode && n.Data == "h1" {
+			headings = append(headings, n)
+		}
+		return nil
+	})
+
+	seen := make(map[string]bool)
+	for _, h1 := range headings {
+		// plaintext(h1) includes the anchor link added by postprocess.
+		heading := strings.TrimSpace(strings.TrimSuffix(plaintext(h1), "¶"))
+		if seen[heading] {
+			continue
+		}
+		content := sectionContent(h1)
+		if len(content) == 0 {
+			continue
+		}
+		switch heading {
+		case "NAME":
+			nameMicrodata(content)
+		case "SYNOPSIS":
+			// The synopsis is a part of the article, whose text is the
+			// usage of the program.
+			text := wrapNodes(content, html.Attribute{Key: "itemprop", Val: "text"})
+			wrapNodes([]*html.Node{text},
+				html.Attribute{Key: "itemprop", Val: "hasPart"},
+				html.Attribute{Key: "itemscope"},
+				html.Attribute{Key: "itemtype", Val: "https://schema.org/SoftwareSourceCode"})
+		case "DESCRIPTION":
+			wrapNodes(content, html.Attribute{Key: "itemprop", Val: "articleBody"})
+		default:
+			continue
+		}
+		seen[heading] = true
+	}
+}
@@ -0,0 +1,83 @@
+package convert
+
+import "testing"
+
+func TestMicrodata(t *testing.T) {
+	for _, entry := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "man",
+			input: `<div class="manual-text">
+<h1 class="Sh" id="NAME">NAME</h1>
+ls - list directory contents
+<h1 class="Sh" id="SYNOPSIS">SYNOPSIS</h1>
+<b>ls</b> [<i>OPTION</i>]...
+<h1 class="Sh" id="DESCRIPTION">DESCRIPTION</h1>
+List information about the FILEs.
+<h1 class="Sh" id="AUTHOR">AUTHOR</h1>
+Written by Richard M. Stallman.
+</div>`,
+			want: `<div class="manual-text">
+<h1 class="Sh" id="NAME">NAME<a class="anchor" href="#NAME">¶</a></h1>
+<span itemprop="name">ls</span> - <span itemprop="abstract">list directory contents</span>
+<h1 class="Sh" id="SYNOPSIS">SYNOPSIS<a class="anchor" href="#SYNOPSIS">¶</a></h1><div itemprop="hasPart" itemscope="" itemtype="https://schema.org/SoftwareSourceCode"><div itemprop="text">
+<b>ls</b> [<i>OPTION</i>]...
+</div></div><h1 class="Sh" id="DESCRIPTION">DESCRIPTION<a class="anchor" href="#DESCRIPTION">¶</a></h1><div itemprop="articleBody">
+List information about the FILEs.
+</div><h1 class="Sh" id="AUTHOR">AUTHOR<a class="anchor" href="#AUTHOR">¶</a></h1>
+Written by Richard M. Stallman.
+</div>`,
+		},
+
+		{
+			name: "mdoc",
+			input: `<div class="manual-text">
+<section class="Sh">
+<h1 class="Sh" id="NAME">NAME</h1>
+<code class="Nm">cat</code> — <span class="Nd">concatenate files</span>
+</section>
+<section class="Sh">
+<h1 class="Sh" id="DESCRIPTION">DESCRIPTION</h1>
+<p class="Pp">The <code class="Nm">cat</code> utility reads files.</p>
+</section>
+</div>`,
+			want: `<div class="manual-text">
+<section class="Sh">
+<h1 class="Sh" id="NAME">NAME<a class="anchor" href="#NAME">¶</a></h1>
+<code class="Nm" itemprop="name">cat</code> — <span class="Nd" itemprop="abstract">concatenate files</span>
+</section>
+<section class="Sh">
+<h1 class="Sh" id="DESCRIPTION">DESCRIPTION<a class="anchor" href="#DESCRIPTION">¶</a></h1><div itemprop="articleBody">
+<p class="Pp">The <code class="Nm">cat</code> utility reads files.</p>
+</div></section>
+</div>`,
+		},
+
+		{
+			name: "translated",
+			input: `<div class="manual-text">
+<h1 class="Sh" id="BEZEICHNUNG">BEZEICHNUNG</h1>
+ls - Verzeichnisinhalte auflisten
+</div>`,
+			want: `<div class="manual-text">
+<h1 class="Sh" id="BEZEICHNUNG">BEZEICHNUNG<a class="anchor" href="#BEZEICHNUNG">¶</a></h1>
+ls - Verzeichnisinhalte auflisten
+</div>`,
+		},
+	} {
+		entry := entry // copy
+		t.Run(entry.name, func(t *testing.T) {
+			var p Process
+			got, _, err := p.postprocessHTML(entry.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != entry.want {
+				t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, entry.want)
+			}
+		})
+	}
+}
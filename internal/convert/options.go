@@ -0,0 +1,94 @@
+package convert
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// optionRe matches command line options like “-v”, “--verbose” or
+// “--color=WHEN” (of which only “--color” is captured). mandoc
+// separates option arguments with non-breaking spaces, which \s does
+// not match.
+var optionRe = regexp.MustCompile(`(?:^|[\s\x{00a0},|\[])(--?[A-Za-z0-9?][A-Za-z0-9_.?-]*)`)
+
+// optionNames returns the command line options which the option list
+// term text (e.g. “-v, --verbose”) describes. Terms which do not start
+// with an option are not considered.
+func optionNames(text string) []string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "-") && !strings.HasPrefix(text, "[-") {
+		return nil
+	}
+	var names []string
+	for _, m := range optionRe.FindAllStringSubmatch(text, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// optionAnchors sets stable ids (e.g. “-v” and “--verbose”) on the
+// terms of option lists in doc, so that the description of a specific
+// option can be linked to, e.g. ls.1.en.html#--all. This works for
+// both man(7) (.TP) and mdoc(7) (.It Fl) lists, as mandoc renders both
+// as <dl> elements. Ids which are already in use (e.g. for options
+// which are described twice) are not set again.
+func optionAnchors(doc *html.Node) {
+	used := make(map[string]bool)
+	var terms []*html.Node
+	recurse(doc, func(n *html.Node) error {
+		if n.Type != html.ElementNode {
+			return nil
+		}
+		for _, a := range n.Attr {
+			if a.Key == "id" {
+				used[a.Val] = true
+			}
+		}
+		if n.Data == "dt" {
+			terms = append(terms, n)
+		}
+		return nil
+	})
+
+	for _, dt := range terms {
+		content := dt.FirstChild
+		var first string
+		for _, name := range optionNames(plaintext(dt)) {
+			if used[name] {
+				continue
+			}
+			used[name] = true
+			if first == "" {
+				first = name
+			}
+			// An empty element per id, as mandoc ≥ 1.14.5 already sets
+			// an id (without leading dashes) on the <dt> element.
+			dt.InsertBefore(&html.Node{
+				Type: html.ElementNode,
+				Data: "span",
+				Attr: []html.Attribute{{Key: "id", Val: name}},
+			}, content)
+		}
+		if first == "" {
+			continue
+		}
+		// Like for headings, the link is only visible while hovering.
+		u := url.URL{Fragment: first}
+		a := &html.Node{
+			Type: html.ElementNode,
+			Data: "a",
+			Attr: []html.Attribute{
+				{Key: "class", Val: "anchor"},
+				{Key: "href", Val: u.String()},
+			},
+		}
+		a.AppendChild(&html.Node{
+			Type: html.TextNode,
+			Data: "¶",
+		})
+		dt.AppendChild(a)
+	}
+}
@@ -0,0 +1,57 @@
+package convert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptionNames(t *testing.T) {
+	for _, entry := range []struct {
+		input string
+		want  []string
+	}{
+		{"-v, --verbose", []string{"-v", "--verbose"}},
+		{"--color[=WHEN]", []string{"--color"}},
+		{"-f file", []string{"-f"}},
+		{"[-a | --all]", []string{"-a", "--all"}},
+		{"-c\u00a0rrggbb,\u00a0--color=rrggbb", []string{"-c", "--color"}},
+		{"-", nil},
+		{"FILES", nil},
+		{"dpkg-query", nil},
+	} {
+		if got := optionNames(entry.input); !reflect.DeepEqual(got, entry.want) {
+			t.Errorf("optionNames(%q): got %q, want %q", entry.input, got, entry.want)
+		}
+	}
+}
+
+func TestOptionAnchors(t *testing.T) {
+	const input = `<div class="mandoc">
+<dl class="Bl-tag">
+<dt id="v"><a class="permalink" href="#v"><b class="Fl">-v</b>, <b class="Fl">--verbose</b></a></dt>
+<dd>Be verbose.</dd>
+<dt><b>ENVIRONMENT</b></dt>
+<dd>Not an option.</dd>
+<dt><b>-v</b></dt>
+<dd>Described twice.</dd>
+</dl>
+</div>`
+	const want = `<div class="mandoc">
+<dl class="Bl-tag">
+<dt id="v"><span id="-v"></span><span id="--verbose"></span><a class="permalink" href="#v"><b class="Fl">-v</b>, <b class="Fl">--verbose</b></a><a class="anchor" href="#-v">¶</a></dt>
+<dd>Be verbose.</dd>
+<dt><b>ENVIRONMENT</b></dt>
+<dd>Not an option.</dd>
+<dt><b>-v</b></dt>
+<dd>Described twice.</dd>
+</dl>
+</div>`
+	var p Process
+	got, _, err := p.postprocessHTML(input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected output:\ngot  %s\nwant %s", got, want)
+	}
+}
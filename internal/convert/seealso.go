@@ -0,0 +1,86 @@
+package convert
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// walkSeeAlso calls fn for each node within the SEE ALSO section of
+// doc, an HTML fragment as returned by ToHTML. Only the English
+// section heading is recognized.
+func walkSeeAlso(doc string, fn func(n *html.Node)) error {
+	parsed, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return err
+	}
+	var inSeeAlso bool
+	// Sections are either flat (older mandoc versions) or wrapped in
+	// <section> elements, so the document is walked in order, and each
+	// <h1> starts a new section.
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "h1" {
+			// plaintext(n) includes the anchor link added by
+			// postprocess.
+			inSeeAlso = strings.HasPrefix(strings.TrimSpace(plaintext(n)), "SEE ALSO")
+			return
+		}
+		if inSeeAlso {
+			fn(n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(parsed)
+	return nil
+}
+
+// SeeAlso returns the link targets within the SEE ALSO section of doc,
+// an HTML fragment as returned by ToHTML. Only the English section
+// heading is recognized.
+func SeeAlso(doc string) ([]string, error) {
+	var targets []string
+	err := walkSeeAlso(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.Data != "a" {
+			return
+		}
+		for _, a := range n.Attr {
+			if a.Key == "href" && !strings.HasPrefix(a.Val, "#") {
+				targets = append(targets, a.Val)
+			}
+		}
+	})
+	return targets, err
+}
+
+// SeeAlsoRefs returns the manpage references (e.g. “crontab(5)”)
+// within the SEE ALSO section of doc, regardless of whether they could
+// be resolved to a link. Each reference is returned once.
+func SeeAlsoRefs(doc string) ([]string, error) {
+	var refs []string
+	seen := make(map[string]bool)
+	err := walkSeeAlso(doc, func(n *html.Node) {
+		if n.Type != html.TextNode {
+			return
+		}
+		// References such as <b>crontab</b>(5) span multiple nodes.
+		var prefix string
+		if n.PrevSibling != nil {
+			prefix = plaintext(n.PrevSibling)
+		}
+		txt := prefix + n.Data
+		for _, r := range findXrefs(txt) {
+			if r[1] <= len(prefix) {
+				continue // contained in the previous sibling
+			}
+			ref := txt[r[0]:r[1]]
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	})
+	return refs, err
+}
@@ -0,0 +1,52 @@
+package convert
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestSeeAlso(t *testing.T) {
+	b, err := ioutil.ReadFile("../../testdata/refs.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := SeeAlso(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"http://w3m.sourceforge.net",
+		"testing/i3lock/i3lock.1.C",
+		"testing/i3-wm/i3-msg.1.C",
+		"testing/systemd/systemd.service.5.C",
+		"http://debian.org",
+		"http://debian.org/",
+		"http://gnome.org",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected SEE ALSO targets: got %q, want %q", got, want)
+	}
+
+	// Newer mandoc versions wrap sections in <section> elements.
+	got, err = SeeAlso(`<section class="Sh"><h1 class="Sh" id="NAME">NAME</h1><a href="/jessie/cron/cron.8.en.html">cron(8)</a></section>` +
+		`<section class="Sh"><h1 class="Sh" id="SEE_ALSO">SEE ALSO<a class="anchor" href="#SEE_ALSO">¶</a></h1><a href="/jessie/cron/crontab.5.en.html">crontab(5)</a></section>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/jessie/cron/crontab.5.en.html"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected SEE ALSO targets: got %q, want %q", got, want)
+	}
+}
+
+func TestSeeAlsoRefs(t *testing.T) {
+	got, err := SeeAlsoRefs(`<h1 class="Sh" id="NAME">NAME</h1>cron(8)` +
+		`<h1 class="Sh" id="SEE_ALSO">SEE ALSO</h1>` +
+		`<a class="Xr" href="/jessie/cron/crontab.5.en.html">crontab(5)</a>, <b>cron</b>(8), <b>anacron</b>(8), crontab(5)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"crontab(5)", "cron(8)", "anacron(8)"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected SEE ALSO references: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a mandoc version number, e.g. 1.14.4.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns whether v is the same as or newer than o.
+func (v Version) AtLeast(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor > o.Minor
+	}
+	return v.Patch >= o.Patch
+}
+
+// MinimumVersion is the oldest mandoc version which produces HTML that
+// debiman can post-process.
+var MinimumVersion = Version{1, 13, 3}
+
+var versionRe = regexp.MustCompile(`mandoc (\d+)\.(\d+)\.(\d+)`)
+
+// ParseVersion parses the output of mandoc -V, e.g. “mandoc 1.14.4”.
+func ParseVersion(output string) (Version, error) {
+	matches := versionRe.FindStringSubmatch(output)
+	if matches == nil {
+		return Version{}, fmt.Errorf("cannot parse mandoc version from %q", output)
+	}
+	var parts [3]int
+	for idx, m := range matches[1:] {
+		n, err := strconv.Atoi(m)
+		if err != nil {
+			return Version{}, err
+		}
+		parts[idx] = n
+	}
+	return Version{parts[0], parts[1], parts[2]}, nil
+}
+
+// Features are the capabilities of the installed mandoc which are
+// optional for debiman.
+//
+// Tagging (ids on the terms of option lists) is not a feature: debiman
+// sets its own ids (see optionAnchors) regardless of the mandoc
+// version.
+type Features struct {
+	Version Version
+
+	// Markdown is true if mandoc supports -Tmarkdown (mandoc ≥ 1.14.1).
+	Markdown bool
+
+	// MathML is true if mandoc renders eqn(7) blocks as MathML in its
+	// HTML output (mandoc ≥ 1.14.1).
+	MathML bool
+}
+
+// FeaturesOf returns the Features of mandoc version v.
+func FeaturesOf(v Version) *Features {
+	return &Features{
+		Version:  v,
+		Markdown: v.AtLeast(Version{1, 14, 1}),
+		MathML:   v.AtLeast(Version{1, 14, 1}),
+	}
+}
+
+// htmlOptions are the -O options of mandoc’s HTML output, with the
+// mandoc version which introduced them.
+var htmlOptions = map[string]Version{
+	"fragment": {1, 12, 0},
+	"includes": {1, 12, 0},
+	"man":      {1, 12, 0},
+	"style":    {1, 12, 0},
+	"toc":      {1, 14, 5},
+}
+
+// CheckArgs returns an error if args (see Mandoc.Args) contain -O
+// options which mandoc f.Version does not support for HTML output, so
+// that debiman can fail at startup instead of when converting the
+// first manpage.
+func (f *Features) CheckArgs(args []string) error {
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if !strings.HasPrefix(arg, "-O") {
+			continue
+		}
+		options := strings.TrimPrefix(arg, "-O")
+		if options == "" && idx+1 < len(args) {
+			// -O man=%N.%S
+			idx++
+			options = args[idx]
+		}
+		for _, option := range strings.Split(options, ",") {
+			name := option
+			if pos := strings.IndexByte(name, '='); pos > -1 {
+				name = name[:pos]
+			}
+			required, ok := htmlOptions[name]
+			if !ok {
+				return fmt.Errorf("unknown mandoc HTML output option -O%s", option)
+			}
+			if !f.Version.AtLeast(required) {
+				return fmt.Errorf("mandoc %v does not support -O%s (requires mandoc >= %v)", f.Version, name, required)
+			}
+		}
+	}
+	return nil
+}
+
+// DetectFeatures determines the installed mandoc version and returns
+// its Features. An error is returned if mandoc cannot be run or is
+// older than MinimumVersion, so that debiman can fail at startup
+// instead of when rendering the first manpage.
+func (m Mandoc) DetectFeatures() (*Features, error) {
+	var stdoutb, stderrb bytes.Buffer
+	cmd := m.command("-V")
+	cmd.Stdout = &stdoutb
+	cmd.Stderr = &stderrb
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.Error); ok && ee.Err == exec.ErrNotFound {
+			return nil, fmt.Errorf("mandoc not found in $PATH. Please install mandoc >= %v (apt install mandoc)", MinimumVersion)
+		}
+		return nil, fmt.Errorf("determining mandoc version: running mandoc -V failed: %v, stderr: %s (mandoc >= %v is required)", err, stderrb.String(), MinimumVersion)
+	}
+	v, err := ParseVersion(stdoutb.String())
+	if err != nil {
+		return nil, err
+	}
+	if !v.AtLeast(MinimumVersion) {
+		return nil, fmt.Errorf("mandoc %v is too old, mandoc >= %v is required", v, MinimumVersion)
+	}
+	return FeaturesOf(v), nil
+}
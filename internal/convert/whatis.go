@@ -0,0 +1,53 @@
+package convert
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Whatis returns the names and the description from the NAME section
+// of doc, an HTML fragment as returned by ToHTML, e.g. “gzip”, “gunzip”
+// and “compress or expand files”, as displayed by whatis(1). names is
+// nil if doc contains no NAME section of the usual form. Only the
+// English section heading is recognized.
+func Whatis(doc string) (names []string, description string, err error) {
+	parsed, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil, "", err
+	}
+	var line string
+	var found bool
+	recurse(parsed, func(n *html.Node) error {
+		if found || n.Type != html.ElementNode || n.Data != "h1" {
+			return nil
+		}
+		// plaintext(n) includes the anchor link added by postprocess.
+		if strings.TrimSpace(strings.TrimSuffix(plaintext(n), "¶")) != "NAME" {
+			return nil
+		}
+		found = true
+		for _, c := range sectionContent(n) {
+			line += plaintext(c)
+		}
+		return nil
+	})
+	line = strings.Join(strings.Fields(line), " ")
+	for _, sep := range nameSeparators {
+		idx := strings.Index(line, sep)
+		if idx == -1 {
+			continue
+		}
+		for _, name := range strings.Split(line[:idx], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		description = strings.TrimSpace(line[idx+len(sep):])
+		if len(names) == 0 || description == "" {
+			return nil, "", nil
+		}
+		return names, description, nil
+	}
+	return nil, "", nil
+}
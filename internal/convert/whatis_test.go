@@ -0,0 +1,44 @@
+package convert
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestWhatis(t *testing.T) {
+	b, err := ioutil.ReadFile("../../testdata/i3lock.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		doc         string
+		names       []string
+		description string
+	}{
+		{string(b), []string{"i3lock"}, "improved screen locker"},
+		{
+			`<h1 class="Sh" id="NAME">NAME<a class="anchor" href="#NAME">¶</a></h1>
+<b>gzip</b>, gunzip,
+  zcat - compress or expand files
+<h1 class="Sh" id="SYNOPSIS">SYNOPSIS</h1>ls - list directory contents`,
+			[]string{"gzip", "gunzip", "zcat"},
+			"compress or expand files",
+		},
+		{
+			`<section class="Sh"><h1 class="Sh" id="NAME">NAME</h1><code class="Nm">ls</code> — <div class="Nd">list directory contents</div></section>`,
+			[]string{"ls"},
+			"list directory contents",
+		},
+		{`<h1 class="Sh" id="NAME">NAME</h1>ls`, nil, ""},
+		{`<h1 class="Sh" id="SYNOPSIS">SYNOPSIS</h1>ls - list directory contents`, nil, ""},
+	} {
+		names, description, err := Whatis(tt.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, tt.names) || description != tt.description {
+			t.Errorf("Whatis(%q): got %q, %q, want %q, %q", tt.doc, names, description, tt.names, tt.description)
+		}
+	}
+}
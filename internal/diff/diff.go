@@ -0,0 +1,217 @@
+// Package diff computes line-based differences between two texts, as
+// displayed in unified diffs.
+package diff
+
+// Op is the operation of a Line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is a line of a diff.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// MaxEdits is the number of edits beyond which Lines gives up on
+// finding a minimal diff and replaces the remaining lines entirely.
+// This bounds the time and memory Lines takes for unrelated texts.
+const MaxEdits = 4096
+
+// Lines returns the (minimal, unless it exceeds MaxEdits) sequence of
+// lines which transforms a into b, using the algorithm from Eugene W.
+// Myers: “An O(ND) Difference Algorithm and Its Variations”.
+func Lines(a, b []string) []Line {
+	var prefix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	var suffix int
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	result := make([]Line, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		result = append(result, Line{Equal, text})
+	}
+	result = append(result, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		result = append(result, Line{Equal, text})
+	}
+	return result
+}
+
+func replace(a, b []string) []Line {
+	result := make([]Line, 0, len(a)+len(b))
+	for _, text := range a {
+		result = append(result, Line{Delete, text})
+	}
+	for _, text := range b {
+		result = append(result, Line{Insert, text})
+	}
+	return result
+}
+
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+	max := n + m
+	if max > MaxEdits {
+		max = MaxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] is a copy of v[offset-d:offset+d+1] after d edits, for
+	// backtracking: only the diagonals -d to d are reachable with d
+	// edits, so copying all of v would take quadratic memory in max.
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(a, b, trace)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	return replace(a, b)
+}
+
+// backtrack reconstructs the edit script from the trace of myers.
+func backtrack(a, b []string, trace [][]int) []Line {
+	x, y := len(a), len(b)
+	var reversed []Line
+	for d := len(trace) - 1; d > 0; d-- {
+		// trace[d-1] starts at diagonal -(d-1).
+		v, offset := trace[d-1], d-1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, Line{Equal, a[x]})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, Line{Insert, b[y]})
+		} else {
+			x--
+			reversed = append(reversed, Line{Delete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, Line{Equal, a[x]})
+	}
+	result := make([]Line, len(reversed))
+	for idx, l := range reversed {
+		result[len(reversed)-1-idx] = l
+	}
+	return result
+}
+
+// Hunk is a group of changed lines with surrounding context.
+type Hunk struct {
+	// AStart and BStart are the 1-based line numbers of the first line
+	// of the hunk in a and b, respectively.
+	AStart, BStart int
+
+	// ALines and BLines are the number of lines of a and b,
+	// respectively, which the hunk covers.
+	ALines, BLines int
+
+	Lines []Line
+}
+
+// Hunks groups the changes in lines into hunks with context unchanged
+// lines before and after each change, as in unified diffs. Hunks
+// returns nil if lines contains no changes.
+func Hunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	var aLine, bLine int // 0-based line numbers of lines[idx]
+	var cur *Hunk
+	var trailing int // unchanged lines since the last change of cur
+	for idx, l := range lines {
+		if l.Op != Equal {
+			if cur == nil {
+				start := idx - context
+				if start < 0 {
+					start = 0
+				}
+				// The unchanged lines before idx are context.
+				hunks = append(hunks, Hunk{
+					AStart: aLine - (idx - start) + 1,
+					BStart: bLine - (idx - start) + 1,
+				})
+				cur = &hunks[len(hunks)-1]
+				for _, c := range lines[start:idx] {
+					cur.Lines = append(cur.Lines, c)
+					cur.ALines++
+					cur.BLines++
+				}
+			}
+			trailing = 0
+		} else if cur != nil {
+			if trailing == 2*context {
+				// The unchanged lines do not connect the previous
+				// change to the next one: end the hunk with context
+				// lines.
+				cur.Lines = cur.Lines[:len(cur.Lines)-context]
+				cur.ALines -= context
+				cur.BLines -= context
+				cur = nil
+			} else {
+				trailing++
+			}
+		}
+		if cur != nil {
+			cur.Lines = append(cur.Lines, l)
+			if l.Op != Insert {
+				cur.ALines++
+			}
+			if l.Op != Delete {
+				cur.BLines++
+			}
+		}
+		if l.Op != Insert {
+			aLine++
+		}
+		if l.Op != Delete {
+			bLine++
+		}
+	}
+	if cur != nil && trailing > context {
+		cur.Lines = cur.Lines[:len(cur.Lines)-(trailing-context)]
+		cur.ALines -= trailing - context
+		cur.BLines -= trailing - context
+	}
+	return hunks
+}
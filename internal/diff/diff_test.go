@@ -0,0 +1,128 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unified formats lines like the body of a unified diff.
+func unified(lines []Line) string {
+	prefix := map[Op]string{Equal: " ", Delete: "-", Insert: "+"}
+	var result []string
+	for _, l := range lines {
+		result = append(result, prefix[l.Op]+l.Text)
+	}
+	return strings.Join(result, "\n")
+}
+
+func TestLines(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want string
+	}{
+		{"a b c", "a b c", " a\n b\n c"},
+		{"", "a", "+a"},
+		{"a", "", "-a"},
+		{"a b c", "a x c", " a\n-b\n+x\n c"},
+		{"a b c d", "b c d e", "-a\n b\n c\n d\n+e"},
+		{"a b c a b b a", "c b a b a c", "-a\n-b\n c\n+b\n a\n b\n-b\n a\n+c"},
+	} {
+		got := unified(Lines(strings.Fields(tt.a), strings.Fields(tt.b)))
+		if got != tt.want {
+			t.Errorf("Lines(%q, %q): got\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLinesMinimal(t *testing.T) {
+	a := strings.Fields("a b c a b b a")
+	b := strings.Fields("c b a b a c")
+	var edits int
+	var gotA, gotB []string
+	for _, l := range Lines(a, b) {
+		if l.Op != Equal {
+			edits++
+		}
+		if l.Op != Insert {
+			gotA = append(gotA, l.Text)
+		}
+		if l.Op != Delete {
+			gotB = append(gotB, l.Text)
+		}
+	}
+	if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatalf("Lines does not transform %q into %q", a, b)
+	}
+	if got, want := edits, 5; got != want {
+		t.Fatalf("Lines: got %d edits, want %d", got, want)
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	words := func(n int) []string {
+		result := make([]string, n)
+		for idx := range result {
+			result[idx] = string('a' + rune(rnd.Intn(4)))
+		}
+		return result
+	}
+	for i := 0; i < 200; i++ {
+		a, b := words(rnd.Intn(40)), words(rnd.Intn(40))
+		var gotA, gotB []string
+		for _, l := range Lines(a, b) {
+			if l.Op != Insert {
+				gotA = append(gotA, l.Text)
+			}
+			if l.Op != Delete {
+				gotB = append(gotB, l.Text)
+			}
+		}
+		if len(gotA) == 0 && len(a) == 0 {
+			gotA = a
+		}
+		if len(gotB) == 0 && len(b) == 0 {
+			gotB = b
+		}
+		if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+			t.Fatalf("Lines does not transform %q into %q", a, b)
+		}
+	}
+}
+
+func TestHunks(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12")
+	b := strings.Fields("1 2 x 4 5 6 7 8 9 y 11 12")
+	if got := Hunks(Lines(a, a), 3); got != nil {
+		t.Fatalf("Hunks of identical texts: got %+v, want nil", got)
+	}
+
+	// The changes are separated by more than 2*context lines.
+	hunks := Hunks(Lines(a, b), 2)
+	if got, want := len(hunks), 2; got != want {
+		t.Fatalf("got %d hunks, want %d", got, want)
+	}
+	if got, want := unified(hunks[0].Lines), " 1\n 2\n-3\n+x\n 4\n 5"; got != want {
+		t.Errorf("hunk 0: got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := [4]int{hunks[0].AStart, hunks[0].ALines, hunks[0].BStart, hunks[0].BLines}, [4]int{1, 5, 1, 5}; got != want {
+		t.Errorf("hunk 0: got range %v, want %v", got, want)
+	}
+	if got, want := unified(hunks[1].Lines), " 8\n 9\n-10\n+y\n 11\n 12"; got != want {
+		t.Errorf("hunk 1: got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := [4]int{hunks[1].AStart, hunks[1].ALines, hunks[1].BStart, hunks[1].BLines}, [4]int{8, 5, 8, 5}; got != want {
+		t.Errorf("hunk 1: got range %v, want %v", got, want)
+	}
+
+	// With more context, the changes are merged into one hunk.
+	hunks = Hunks(Lines(a, b), 3)
+	if got, want := len(hunks), 1; got != want {
+		t.Fatalf("got %d hunks, want %d", got, want)
+	}
+	if got, want := [4]int{hunks[0].AStart, hunks[0].ALines, hunks[0].BStart, hunks[0].BLines}, [4]int{1, 12, 1, 12}; got != want {
+		t.Errorf("hunk 0: got range %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+// Package epub assembles EPUB 3 books from XHTML fragments.
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"time"
+)
+
+// Book is an EPUB book, consisting of chapters in the order in which
+// they were added.
+type Book struct {
+	// Identifier uniquely identifies the book, e.g. its URL.
+	Identifier string
+	Title      string
+	Language   string
+	Modified   time.Time
+
+	chapters []chapter
+}
+
+type chapter struct {
+	title   string
+	content string
+}
+
+// AddChapter adds a chapter with the specified title. content must be
+// an XHTML fragment (i.e. well-formed XML), which becomes the body of
+// the chapter.
+func (b *Book) AddChapter(title, content string) {
+	b.chapters = append(b.chapters, chapter{title: title, content: content})
+}
+
+const container = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`
+
+type item struct {
+	XMLName    xml.Name `xml:"item"`
+	ID         string   `xml:"id,attr"`
+	Href       string   `xml:"href,attr"`
+	MediaType  string   `xml:"media-type,attr"`
+	Properties string   `xml:"properties,attr,omitempty"`
+}
+
+type itemref struct {
+	XMLName xml.Name `xml:"itemref"`
+	IDRef   string   `xml:"idref,attr"`
+}
+
+type meta struct {
+	XMLName  xml.Name `xml:"meta"`
+	Property string   `xml:"property,attr"`
+	Value    string   `xml:",chardata"`
+}
+
+type pkg struct {
+	XMLName          xml.Name `xml:"http://www.idpf.org/2007/opf package"`
+	Version          string   `xml:"version,attr"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		DC         string `xml:"xmlns:dc,attr"`
+		Identifier struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"dc:identifier"`
+		Title    string `xml:"dc:title"`
+		Language string `xml:"dc:language"`
+		Meta     meta
+	} `xml:"metadata"`
+	Manifest []item    `xml:"manifest>item"`
+	Spine    []itemref `xml:"spine>itemref"`
+}
+
+func chapterFileName(idx int) string {
+	return fmt.Sprintf("chapter%d.xhtml", idx)
+}
+
+func (b *Book) packageDocument() ([]byte, error) {
+	p := pkg{
+		Version:          "3.0",
+		UniqueIdentifier: "id",
+	}
+	p.Metadata.DC = "http://purl.org/dc/elements/1.1/"
+	p.Metadata.Identifier.ID = "id"
+	p.Metadata.Identifier.Value = b.Identifier
+	p.Metadata.Title = b.Title
+	p.Metadata.Language = b.Language
+	p.Metadata.Meta = meta{
+		Property: "dcterms:modified",
+		Value:    b.Modified.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	p.Manifest = append(p.Manifest, item{
+		ID:         "nav",
+		Href:       "nav.xhtml",
+		MediaType:  "application/xhtml+xml",
+		Properties: "nav",
+	})
+	for idx := range b.chapters {
+		id := fmt.Sprintf("chapter%d", idx)
+		p.Manifest = append(p.Manifest, item{
+			ID:        id,
+			Href:      chapterFileName(idx),
+			MediaType: "application/xhtml+xml",
+		})
+		p.Spine = append(p.Spine, itemref{IDRef: id})
+	}
+	out, err := xml.MarshalIndent(&p, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func writeXHTML(w io.Writer, title, body string) error {
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>%s</title>
+</head>
+<body>
+%s
+</body>
+</html>
+`, html.EscapeString(title), body)
+	return err
+}
+
+func (b *Book) navDocument(w io.Writer) error {
+	body := fmt.Sprintf("<nav epub:type=\"toc\">\n<h1>%s</h1>\n<ol>\n", html.EscapeString(b.Title))
+	for idx, c := range b.chapters {
+		body += fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", chapterFileName(idx), html.EscapeString(c.title))
+	}
+	body += "</ol>\n</nav>"
+	return writeXHTML(w, b.Title, body)
+}
+
+// Write writes the book as an EPUB container to w.
+func (b *Book) Write(w io.Writer) error {
+	zipw := zip.NewWriter(w)
+
+	// The mimetype file must be the first file in the container and
+	// must not be compressed, so that the format can be identified by
+	// looking at a fixed offset.
+	mimetype, err := zipw.CreateHeader(&zip.FileHeader{
+		Name:   "mimetype",
+		Method: zip.Store,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	create := func(name string) (io.Writer, error) {
+		return zipw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		})
+	}
+
+	f, err := create("META-INF/container.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, container); err != nil {
+		return err
+	}
+
+	opf, err := b.packageDocument()
+	if err != nil {
+		return err
+	}
+	if f, err = create("OEBPS/content.opf"); err != nil {
+		return err
+	}
+	if _, err := f.Write(opf); err != nil {
+		return err
+	}
+
+	if f, err = create("OEBPS/nav.xhtml"); err != nil {
+		return err
+	}
+	if err := b.navDocument(f); err != nil {
+		return err
+	}
+
+	for idx, c := range b.chapters {
+		if f, err = create("OEBPS/" + chapterFileName(idx)); err != nil {
+			return err
+		}
+		if err := writeXHTML(f, c.title, c.content); err != nil {
+			return err
+		}
+	}
+
+	return zipw.Close()
+}
@@ -0,0 +1,88 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	b := &Book{
+		Identifier: "https://manpages.debian.org/jessie/i3-wm/",
+		Title:      "Manpages of i3-wm in Debian jessie",
+		Language:   "en",
+		Modified:   time.Unix(1484816329, 0),
+	}
+	b.AddChapter("i3(1)", `<div class="mandoc"><p>i3 &amp; friends<br/></p></div>`)
+	b.AddChapter("i3-msg(1) <en>", `<div class="mandoc"></div>`)
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zipr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(zipr.File), 6; got != want {
+		t.Fatalf("unexpected number of files: got %d, want %d", got, want)
+	}
+	if first := zipr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("unexpected first file: got %q (method %d), want %q (method %d)", first.Name, first.Method, "mimetype", zip.Store)
+	}
+	// The mimetype must be readable at a fixed offset.
+	if got, want := string(buf.Bytes()[30:30+len("mimetype")+len("application/epub+zip")]), "mimetypeapplication/epub+zip"; got != want {
+		t.Fatalf("unexpected container header: got %q, want %q", got, want)
+	}
+
+	contents := make(map[string]string)
+	for _, f := range zipr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[f.Name] = string(b)
+		if f.Name == "mimetype" {
+			continue
+		}
+		// All other files must be well-formed XML.
+		dec := xml.NewDecoder(bytes.NewReader(b))
+		dec.Strict = true
+		dec.Entity = xml.HTMLEntity
+		for {
+			if _, err := dec.Token(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+		}
+	}
+
+	opf := contents["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<dc:identifier id="id">https://manpages.debian.org/jessie/i3-wm/</dc:identifier>`,
+		`<dc:language>en</dc:language>`,
+		`<meta property="dcterms:modified">2017-01-19T08:58:49Z</meta>`,
+		`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"></item>`,
+		`<itemref idref="chapter1"></itemref>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf does not contain %q:\n%s", want, opf)
+		}
+	}
+	if nav, want := contents["OEBPS/nav.xhtml"], `<a href="chapter1.xhtml">i3-msg(1) &lt;en&gt;</a>`; !strings.Contains(nav, want) {
+		t.Errorf("nav.xhtml does not contain %q:\n%s", want, nav)
+	}
+}
@@ -0,0 +1,238 @@
+// Package info parses GNU Info documents (as generated by makeinfo)
+// and converts them to HTML.
+package info
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Node is a node (i.e. a section) of an Info document.
+type Node struct {
+	Name string
+	Next string
+	Prev string
+	Up   string
+
+	// Text is the preformatted text of the node, excluding the header
+	// line.
+	Text string
+}
+
+// Document is an Info document, e.g. coreutils.info.
+type Document struct {
+	// Name is the name of the document as used in cross-references,
+	// e.g. “coreutils”.
+	Name  string
+	Nodes []*Node
+}
+
+// separator starts each node, indirect table and tag table.
+const separator = "\x1f"
+
+// Read reads the Info document name (e.g. “coreutils.info”). Documents
+// which are split into multiple files (e.g. coreutils.info-1) list
+// these files in their indirect table, and open is called to read them.
+func Read(name string, open func(name string) (io.ReadCloser, error)) (*Document, error) {
+	doc := &Document{Name: DocumentName(name)}
+	files, err := doc.parse(name, open)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range files {
+		if _, err := doc.parse(fn, open); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// DocumentName returns the name by which the Info file fn (e.g.
+// “coreutils.info.gz”) is referenced, i.e. “coreutils”.
+func DocumentName(fn string) string {
+	fn = strings.TrimSuffix(fn, ".gz")
+	return strings.TrimSuffix(fn, ".info")
+}
+
+// parse adds the nodes contained in the file name to doc and returns
+// the files listed in its indirect table, if any.
+func (doc *Document) parse(name string, open func(name string) (io.ReadCloser, error)) ([]string, error) {
+	rc, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	var indirect []string
+	// The first part is a preamble, e.g. “This is coreutils.info, …”.
+	for _, part := range strings.Split(string(b), separator)[1:] {
+		part = strings.TrimPrefix(part, "\f")
+		part = strings.TrimPrefix(part, "\n")
+		switch {
+		case strings.HasPrefix(part, "Indirect:"):
+			indirect = parseIndirect(part)
+		case strings.HasPrefix(part, "Tag Table:"),
+			strings.HasPrefix(part, "End Tag Table"),
+			strings.HasPrefix(part, "Local Variables:"):
+			continue
+		default:
+			if n := parseNode(part); n != nil {
+				doc.Nodes = append(doc.Nodes, n)
+			}
+		}
+	}
+	return indirect, nil
+}
+
+// parseIndirect parses an indirect table, whose lines are of the form
+// “coreutils.info-1: 1234”.
+func parseIndirect(part string) []string {
+	var files []string
+	scanner := bufio.NewScanner(strings.NewReader(part))
+	scanner.Scan() // skip “Indirect:”
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.LastIndex(line, ":"); idx > -1 {
+			files = append(files, strings.TrimSpace(line[:idx]))
+		}
+	}
+	return files
+}
+
+// parseNode parses a node, which starts with a header line like
+// “File: coreutils.info,  Node: ls invocation,  Next: dir invocation,
+// Up: Directory listing”.
+func parseNode(part string) *Node {
+	idx := strings.Index(part, "\n")
+	if idx == -1 {
+		return nil
+	}
+	header, text := part[:idx], part[idx+1:]
+	n := &Node{Text: strings.TrimRight(text, "\n") + "\n"}
+	for _, field := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "Node":
+			n.Name = v
+		case "Next":
+			n.Next = v
+		case "Prev":
+			n.Prev = v
+		case "Up":
+			n.Up = v
+		}
+	}
+	if n.Name == "" {
+		return nil
+	}
+	return n
+}
+
+// Anchor returns the HTML id of the node name.
+func Anchor(name string) string {
+	return strings.Replace(strings.TrimSpace(name), " ", "-", -1)
+}
+
+// refRe matches cross-references (“*note Label: Node.”, “*Note
+// Node::”) and menu entries (“* Label: Node.”, “* Node::”).
+// Submatches: 1 is the label or node, 2 the document (of references
+// to other documents) and 3 the node (if a label is present).
+var refRe = regexp.MustCompile(`(?:\*[Nn]ote[ \n]+|(?m:^)\* )([^:*]+?)(?:::|:[ \n]+(?:\(([^)\n]+)\))?([^.,:*\t\n][^.,:\t\n]*?)?[.,\t\n])`)
+
+// target returns the document (empty for the current document) and
+// node of a reference label, which can itself be of the form
+// “(document)node”.
+func target(doc, node string) (string, string) {
+	if strings.HasPrefix(node, "(") {
+		if idx := strings.Index(node, ")"); idx > -1 {
+			return node[1:idx], strings.TrimSpace(node[idx+1:])
+		}
+	}
+	return doc, node
+}
+
+// HTML converts doc to HTML. resolve is called for references to other
+// documents and returns the URL of the document’s HTML page (or an
+// empty string if the document is unknown).
+func (doc *Document) HTML(resolve func(document string) string) string {
+	href := func(document, node string) string {
+		if node == "" {
+			node = "Top"
+		}
+		u := url.URL{Fragment: Anchor(node)}
+		if document == "" || document == doc.Name {
+			return u.String()
+		}
+		base := resolve(document)
+		if base == "" {
+			return ""
+		}
+		return base + u.String()
+	}
+	link := func(buf *bytes.Buffer, document, node, text string) {
+		if h := href(document, node); h != "" {
+			fmt.Fprintf(buf, `<a href="%s">%s</a>`, html.EscapeString(h), html.EscapeString(text))
+			return
+		}
+		buf.WriteString(html.EscapeString(text))
+	}
+
+	var buf bytes.Buffer
+	for _, n := range doc.Nodes {
+		fmt.Fprintf(&buf, "<div class=\"infonode\" id=\"%s\">\n", html.EscapeString(Anchor(n.Name)))
+		buf.WriteString(`<div class="infonav">`)
+		for _, nav := range []struct{ rel, node string }{
+			{"Next", n.Next},
+			{"Prev", n.Prev},
+			{"Up", n.Up},
+		} {
+			if nav.node == "" {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s: ", nav.rel)
+			document, node := target("", nav.node)
+			link(&buf, document, node, nav.node)
+			buf.WriteString(" ")
+		}
+		buf.WriteString("</div>\n<pre>")
+
+		text := n.Text
+		last := 0
+		for _, m := range refRe.FindAllStringSubmatchIndex(text, -1) {
+			label := text[m[2]:m[3]]
+			var document, node string
+			if m[6] > -1 {
+				node = text[m[6]:m[7]]
+			}
+			if m[4] > -1 {
+				document = text[m[4]:m[5]]
+			}
+			if node == "" && document == "" {
+				if !strings.HasPrefix(text[m[3]:], "::") {
+					continue // e.g. “* Menu:”
+				}
+				document, node = target("", label)
+			}
+			buf.WriteString(html.EscapeString(text[last:m[2]]))
+			link(&buf, document, strings.Join(strings.Fields(node), " "), label)
+			last = m[3]
+		}
+		buf.WriteString(html.EscapeString(text[last:]))
+		buf.WriteString("</pre>\n</div>\n")
+	}
+	return buf.String()
+}
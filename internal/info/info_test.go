@@ -0,0 +1,92 @@
+package info
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const mainFile = "This is test.info, produced by makeinfo.\n" +
+	"\x1f\nIndirect:\ntest.info-1: 100\n" +
+	"\x1f\nTag Table:\n(Indirect)\nNode: Top\x7f100\n\x1f\nEnd Tag Table\n"
+
+const subFile = "This is test.info, produced by makeinfo.\n" +
+	"\x1f\nFile: test.info,  Node: Top,  Next: Invoking,  Up: (dir)\n\n" +
+	"Test <manual>\n\n* Menu:\n\n* Invoking::       How to run test.\n* Other: (coreutils)ls invocation.   Listing.\n" +
+	"\x1f\nFile: test.info,  Node: Invoking,  Prev: Top,  Up: Top\n\n" +
+	"Invoking\n\nSee *note Top:: and *note the ls\ninvocation: (coreutils)ls invocation, or *note (unknown)Foo::.\n"
+
+func TestRead(t *testing.T) {
+	files := map[string]string{
+		"test.info":   mainFile,
+		"test.info-1": subFile,
+	}
+	doc, err := Read("test.info", func(name string) (io.ReadCloser, error) {
+		content, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%q not found", name)
+		}
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc.Name, "test"; got != want {
+		t.Fatalf("unexpected document name: got %q, want %q", got, want)
+	}
+	if got, want := len(doc.Nodes), 2; got != want {
+		t.Fatalf("unexpected number of nodes: got %d, want %d", got, want)
+	}
+	top := doc.Nodes[0]
+	if top.Name != "Top" || top.Next != "Invoking" || top.Up != "(dir)" {
+		t.Fatalf("unexpected Top node: %+v", top)
+	}
+
+	got := doc.HTML(func(document string) string {
+		if document == "coreutils" {
+			return "/info/jessie/coreutils/coreutils.html"
+		}
+		return ""
+	})
+	want := `<div class="infonode" id="Top">
+<div class="infonav">Next: <a href="#Invoking">Invoking</a> Up: (dir) </div>
+<pre>
+Test &lt;manual&gt;
+
+* Menu:
+
+* <a href="#Invoking">Invoking</a>::       How to run test.
+* <a href="/info/jessie/coreutils/coreutils.html#ls-invocation">Other</a>: (coreutils)ls invocation.   Listing.
+</pre>
+</div>
+<div class="infonode" id="Invoking">
+<div class="infonav">Prev: <a href="#Top">Top</a> Up: <a href="#Top">Top</a> </div>
+<pre>
+Invoking
+
+See *note <a href="#Top">Top</a>:: and *note <a href="/info/jessie/coreutils/coreutils.html#ls-invocation">the ls
+invocation</a>: (coreutils)ls invocation, or *note (unknown)Foo::.
+</pre>
+</div>
+`
+	if got != want {
+		t.Fatalf("unexpected HTML:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestDocumentName(t *testing.T) {
+	for _, tt := range []struct {
+		fn   string
+		want string
+	}{
+		{"coreutils.info.gz", "coreutils"},
+		{"coreutils.info", "coreutils"},
+		{"gawk.gz", "gawk"},
+	} {
+		if got := DocumentName(tt.fn); got != tt.want {
+			t.Errorf("DocumentName(%q): got %q, want %q", tt.fn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package pgzip compresses data into a gzip stream using multiple
+// cores.
+//
+// Like pigz, the input is split into blocks which are compressed
+// independently. Each block but the last ends in a sync flush, so
+// that the compressed blocks can be concatenated into a single,
+// regular gzip member which any gzip reader can decompress.
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BlockSize is the number of uncompressed bytes per block. Larger
+// blocks result in a better compression ratio, smaller blocks result
+// in more parallelism.
+const BlockSize = 1 << 20
+
+// Compress writes data to w as a gzip stream, compressed with the
+// specified level (see compress/gzip).
+func Compress(w io.Writer, data []byte, level int) error {
+	nblocks := (len(data) + BlockSize - 1) / BlockSize
+	if nblocks == 0 {
+		nblocks = 1
+	}
+	blocks := make([]bytes.Buffer, nblocks)
+	sem := make(chan bool, runtime.NumCPU())
+	var eg errgroup.Group
+	for idx := range blocks {
+		idx := idx // copy
+		eg.Go(func() error {
+			sem <- true
+			defer func() { <-sem }()
+
+			start := idx * BlockSize
+			end := start + BlockSize
+			if end > len(data) {
+				end = len(data)
+			}
+			fw, err := flate.NewWriter(&blocks[idx], level)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data[start:end]); err != nil {
+				return err
+			}
+			if idx == len(blocks)-1 {
+				return fw.Close()
+			}
+			return fw.Flush()
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// See RFC 1952 for the header format. The modification time is
+	// left unset, like compress/gzip does by default.
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch level {
+	case gzip.BestCompression:
+		header[8] = 2
+	case gzip.BestSpeed:
+		header[8] = 4
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for idx := range blocks {
+		if _, err := blocks[idx].WriteTo(w); err != nil {
+			return err
+		}
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(data)))
+	_, err := w.Write(trailer)
+	return err
+}
@@ -0,0 +1,78 @@
+package pgzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// contentsPage returns an HTML document which resembles a
+// contents-<suite>.html page with n entries.
+func contentsPage(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<html><body><ul>\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "<li><a href=\"/unstable/pkg%d/index.html\">pkg%d</a></li>\n", i, i)
+	}
+	buf.WriteString("</ul></body></html>\n")
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("hello world\n"),
+		contentsPage(100000), // spans multiple blocks
+	} {
+		var buf bytes.Buffer
+		if err := Compress(&buf, data, gzip.BestCompression); err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The output must be a single gzip member, not a
+		// concatenation of multiple members.
+		r.Multistream(false)
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("unexpected round-trip result: got %d bytes, want %d bytes", len(got), len(data))
+		}
+		if buf.Len() > 0 {
+			t.Fatalf("%d unexpected trailing bytes after gzip member", buf.Len())
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	data := contentsPage(500000)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if err := Compress(ioutil.Discard, data, gzip.BestCompression); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompressStdlib(b *testing.B) {
+	data := contentsPage(500000)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		w, err := gzip.NewWriterLevel(ioutil.Discard, gzip.BestCompression)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
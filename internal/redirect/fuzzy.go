@@ -0,0 +1,104 @@
+package redirect
+
+import "sort"
+
+// maxDistance returns the maximum edit distance at which names are
+// considered similar to name: none for very short names (everything
+// would be similar to them), then one per four bytes, up to two.
+func maxDistance(name string) int {
+	d := len(name) / 4
+	if d > 2 {
+		d = 2
+	}
+	return d
+}
+
+// levenshtein returns the edit distance between a and b (in bytes), or
+// max+1 if it exceeds max.
+func levenshtein(a, b string, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if del := prev[j] + 1; del < cur[j] {
+				cur[j] = del
+			}
+			if ins := cur[j-1] + 1; ins < cur[j] {
+				cur[j] = ins
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(b)] > max {
+		return max + 1
+	}
+	return prev[len(b)]
+}
+
+// maxSimilar is the maximum number of similar names offered when a
+// manpage was not found.
+const maxSimilar = 5
+
+type scoredName struct {
+	name     string
+	distance int
+}
+
+type byDistance []scoredName
+
+func (p byDistance) Len() int      { return len(p) }
+func (p byDistance) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byDistance) Less(i, j int) bool {
+	if p[i].distance != p[j].distance {
+		return p[i].distance < p[j].distance
+	}
+	return p[i].name < p[j].name
+}
+
+// closestNames returns up to n names in the index (in lower case) within
+// maxDistance of name (which must be in lower case), closest first. Of
+// multiple names with the same distance, the lexicographically smaller
+// one comes first.
+func (i Index) closestNames(name string, n int) []string {
+	max := maxDistance(name)
+	if max == 0 {
+		return nil
+	}
+	var scored []scoredName
+	for candidate := range i.Entries {
+		if candidate == name {
+			continue
+		}
+		if d := levenshtein(name, candidate, max); d <= max {
+			scored = append(scored, scoredName{candidate, d})
+		}
+	}
+	sort.Sort(byDistance(scored))
+	if len(scored) > n {
+		scored = scored[:n]
+	}
+	names := make([]string, len(scored))
+	for idx, s := range scored {
+		names[idx] = s.name
+	}
+	return names
+}
@@ -0,0 +1,140 @@
+package redirect
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"i3", "i3", 2, 0},
+		{"sytemctl", "systemctl", 2, 1},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3}, // exceeds max
+		{"a", "abcd", 2, 3},         // length difference exceeds max
+		{"", "ab", 2, 2},
+	} {
+		if got := levenshtein(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("levenshtein(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestClosestNames(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want []string
+	}{
+		{"editlin", []string{"editline"}},
+		{"edtiline", []string{"editline"}},
+		{"git-rebsae", []string{"git-rebase"}},
+		{"oi3", nil}, // too short
+		{"xxxxxxxx", nil},
+	} {
+		got := testIdx.closestNames(tt.name, 1)
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("closestNames(%q, 1) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	idx := Index{Entries: map[string][]IndexEntry{
+		"abcdefgh": nil, // the name itself is not similar
+		"abcdefgy": nil, // distance 1
+		"abcdefxy": nil, // distance 2
+		"abcdefgx": nil, // distance 1
+		"abcdefga": nil, // distance 1
+		"abcdexyz": nil, // distance 3, exceeds maxDistance
+	}}
+	for _, tt := range []struct {
+		n    int
+		want []string
+	}{
+		// Names with the same distance are ordered lexicographically.
+		{1, []string{"abcdefga"}},
+		{2, []string{"abcdefga", "abcdefgx"}},
+		{5, []string{"abcdefga", "abcdefgx", "abcdefgy", "abcdefxy"}},
+	} {
+		if got := idx.closestNames("abcdefgh", tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("closestNames(%q, %d) = %q, want %q", "abcdefgh", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundFuzzy(t *testing.T) {
+	u, err := url.Parse("http://man.debian.org/editlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testIdx.Redirect(&http.Request{URL: u})
+	e, ok := err.(*NotFoundError)
+	if !ok {
+		t.Fatalf("Redirect for /editlin: got %v, want a NotFoundError", err)
+	}
+	if got, want := e.Manpage, "editlin"; got != want {
+		t.Fatalf("Unexpected e.Manpage: got %q, want %q", got, want)
+	}
+	if got, want := e.BestChoice.Name, "editline"; got != want {
+		t.Fatalf("Unexpected e.BestChoice.Name: got %q, want %q", got, want)
+	}
+}
+
+func TestNotFoundSuggestions(t *testing.T) {
+	notFound := func(path string) *NotFoundError {
+		u, err := url.Parse("http://man.debian.org" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testIdx.Redirect(&http.Request{URL: u})
+		e, ok := err.(*NotFoundError)
+		if !ok {
+			t.Fatalf("Redirect for %s: got %v, want a NotFoundError", path, err)
+		}
+		return e
+	}
+
+	e := notFound("/git-rebas")
+	var got []string
+	for _, s := range e.Similar {
+		got = append(got, s.ServingPath(""))
+	}
+	if want := []string{"/jessie/git-man/git-rebase.1.en"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected e.Similar: got %v, want %v", got, want)
+	}
+	if e.Variants != nil {
+		t.Errorf("Unexpected e.Variants: got %v, want nil", e.Variants)
+	}
+
+	e = notFound("/wheezy/i3")
+	got = nil
+	for _, v := range e.Variants {
+		got = append(got, v.ServingPath(""))
+	}
+	want := []string{
+		"/jessie/i3-wm/i3.1.en",
+		"/jessie/i3-wm/i3.5.en",
+		"/testing/i3-wm/i3.1.en",
+		"/testing/i3-wm/i3.5.en",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected e.Variants: got %v, want %v", got, want)
+	}
+
+	e = notFound("/testing/editline")
+	got = nil
+	for _, v := range e.Variants {
+		got = append(got, v.ServingPath(""))
+	}
+	want = []string{
+		"/jessie/libeditline-dev/editline.3.en",
+		"/jessie/libedit-dev/editline.3edit.en",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected e.Variants: got %v, want %v", got, want)
+	}
+}
@@ -3,7 +3,6 @@ package redirect
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"path/filepath"
 	"sort"
@@ -18,7 +17,7 @@ import (
 type IndexEntry struct {
 	Name      string // TODO: string pool
 	Suite     string // TODO: enum to save space
-	Binarypkg string // TODO: sort by popcon, TODO: use a string pool
+	Binarypkg string // TODO: use a string pool
 	Section   string // TODO: use a string pool
 	Language  string // TODO: type: would it make sense to use language.Tag?
 }
@@ -285,6 +284,8 @@ func (i Index) Narrow(acceptLang string, template, ref IndexEntry, entries []Ind
 	// binarypkg
 
 	if t.Binarypkg == "" {
+		// debiman orders the entries of multiple binary packages by
+		// their popularity if -popcon is set.
 		t.Binarypkg = filtered[0].Binarypkg
 	}
 
@@ -295,9 +296,188 @@ func (i Index) Narrow(acceptLang string, template, ref IndexEntry, entries []Ind
 	return filtered
 }
 
+// lookup returns the entries of the manpage called name.
+func (i Index) lookup(name string) ([]IndexEntry, bool) {
+	lname := strings.ToLower(name)
+	if entries, ok := i.Entries[lname]; ok {
+		return entries, true
+	}
+	// Fall back to joining (originally) whitespace-separated parts by
+	// dashes and underscores, like man(1).
+	if entries, ok := i.Entries[strings.Replace(lname, ".", "-", -1)]; ok {
+		return entries, true
+	}
+	entries, ok := i.Entries[strings.Replace(lname, ".", "_", -1)]
+	return entries, ok
+}
+
+// resolve returns the entry best matching t (with t.Suite already
+// rewritten), and all entries of the manpage t.Name.
+func (i Index) resolve(t IndexEntry, acceptLang string, ref IndexEntry) (IndexEntry, []IndexEntry, error) {
+	entries, ok := i.lookup(t.Name)
+	if !ok {
+		// Offer the manpages with the most similar names, e.g.
+		// systemctl for “sytemctl”, preferably in the requested suite.
+		nf := &NotFoundError{Manpage: t.Name}
+		for _, name := range i.closestNames(strings.ToLower(t.Name), maxSimilar) {
+			similar := i.Narrow(acceptLang, IndexEntry{Suite: t.Suite}, ref, i.Entries[name])
+			if len(similar) == 0 {
+				similar = i.Narrow(acceptLang, IndexEntry{}, ref, i.Entries[name])
+			}
+			nf.Similar = append(nf.Similar, similar[0])
+		}
+		if len(nf.Similar) > 0 {
+			nf.BestChoice = nf.Similar[0]
+		}
+		return IndexEntry{}, nil, nf
+	}
+
+	filtered := i.Narrow(acceptLang, IndexEntry{
+		Suite:     t.Suite,
+		Binarypkg: t.Binarypkg,
+		Section:   t.Section,
+		Language:  t.Language,
+	}, ref, entries)
+
+	if len(filtered) == 0 {
+		// Present the user with another choice for this manpage.
+		var best IndexEntry
+		if t.Name != "index" && t.Name != "favicon" {
+			best = i.Narrow(acceptLang, IndexEntry{}, ref, entries)[0]
+		}
+		return IndexEntry{}, entries, &NotFoundError{
+			Manpage:        t.Name,
+			BestChoice:     best,
+			Variants:       i.variants(acceptLang, ref, entries),
+			Disambiguation: disambiguation(best.Suite, entries)}
+	}
+	return filtered[0], entries, nil
+}
+
+// disambiguation returns the URL of the disambiguation page which
+// debiman renders for the manpage of entries in suite, provided it is
+// present in more than one section or binary package within suite.
+func disambiguation(suite string, entries []IndexEntry) string {
+	if suite == "" {
+		return ""
+	}
+	var name string
+	distinct := make(map[string]bool)
+	for _, e := range entries {
+		if e.Suite != suite {
+			continue
+		}
+		name = e.Name
+		distinct[e.Binarypkg+"/"+e.Section] = true
+	}
+	if len(distinct) < 2 {
+		return ""
+	}
+	return "/disambiguation/" + suite + "/" + name + ".html"
+}
+
+type bySuiteAndSection []IndexEntry
+
+func (p bySuiteAndSection) Len() int      { return len(p) }
+func (p bySuiteAndSection) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p bySuiteAndSection) Less(i, j int) bool {
+	if p[i].Suite != p[j].Suite {
+		return p[i].Suite < p[j].Suite
+	}
+	return p[i].Section < p[j].Section
+}
+
+// variants returns the best of entries (i.e. in the preferred language
+// and binary package) for each suite and section.
+func (i Index) variants(acceptLang string, ref IndexEntry, entries []IndexEntry) []IndexEntry {
+	seen := make(map[IndexEntry]bool)
+	var variants []IndexEntry
+	for _, e := range entries {
+		key := IndexEntry{Suite: e.Suite, Section: e.Section}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		for _, v := range i.Narrow(acceptLang, key, ref, entries) {
+			// Narrow matches the main section only.
+			if v.Section == e.Section {
+				variants = append(variants, v)
+				break
+			}
+		}
+	}
+	sort.Sort(bySuiteAndSection(variants))
+	return variants
+}
+
+// ResolveEntry resolves the manpage specified by t, of which only Name
+// is required, like Resolve does for the components of a request URL.
+// It returns the chosen entry and all entries (i.e. variants) of the
+// manpage.
+func (i Index) ResolveEntry(t IndexEntry, acceptLang string) (IndexEntry, []IndexEntry, error) {
+	if rewrite, ok := i.Suites[t.Suite]; ok {
+		t.Suite = rewrite
+	}
+	return i.resolve(t, acceptLang, IndexEntry{})
+}
+
+// LanguageCookie is the name of the cookie in which users store their
+// preferred manpage language, see PreferredLanguage.
+const LanguageCookie = "lang"
+
+// PreferredLanguage returns the language which the user who sent r
+// prefers over the languages of their Accept-Language header: the
+// language query parameter (as sent by the search form of each page),
+// if any, or the value of the LanguageCookie cookie. Languages not
+// contained in the index are ignored.
+func (i Index) PreferredLanguage(r *http.Request) string {
+	if lang := r.FormValue("language"); lang != "" && i.Langs[lang] {
+		return lang
+	}
+	if c, err := r.Cookie(LanguageCookie); err == nil && i.Langs[c.Value] {
+		return c.Value
+	}
+	return ""
+}
+
+// AcceptLanguage returns the Accept-Language header of r, with the
+// PreferredLanguage (if any) taking precedence.
+func (i Index) AcceptLanguage(r *http.Request) string {
+	acceptLang := r.Header.Get("Accept-Language")
+	if pref := i.PreferredLanguage(r); pref != "" {
+		acceptLang = preferLanguage(pref, acceptLang)
+	}
+	return acceptLang
+}
+
+// preferLanguage returns acceptLang with the locale pref prepended, so
+// that pref is chosen if available, falling back to acceptLang.
+func preferLanguage(pref, acceptLang string) string {
+	t, err := tag.FromLocale(pref)
+	if err != nil {
+		return acceptLang
+	}
+	if acceptLang == "" {
+		return t.String()
+	}
+	return t.String() + ", " + acceptLang
+}
+
 type NotFoundError struct {
 	Manpage    string
 	BestChoice IndexEntry
+
+	// Similar contains the best variant of each manpage whose name is
+	// similar to Manpage (which does not exist), most similar first.
+	Similar []IndexEntry
+
+	// Variants contains the best variant per suite and section of
+	// Manpage, which exists, but not as requested.
+	Variants []IndexEntry
+
+	// Disambiguation is the URL of the disambiguation page of Manpage
+	// in the suite of BestChoice, if Manpage is ambiguous there.
+	Disambiguation string
 }
 
 func (e *NotFoundError) Error() string {
@@ -305,12 +485,33 @@ func (e *NotFoundError) Error() string {
 }
 
 func (i Index) Redirect(r *http.Request) (string, error) {
+	res, err := i.Resolve(r)
+	return res.Target, err
+}
+
+// Resolution describes how Resolve handled a request.
+type Resolution struct {
+	// Target is the serving path the request resolved to.
+	Target string
+
+	// Ambiguous is true if Target was chosen out of multiple binary
+	// packages or sections.
+	Ambiguous bool
+
+	// Parsed contains the components specified in the request URL path.
+	Parsed IndexEntry
+}
+
+// Resolve is like Redirect, but additionally returns the components of
+// the request and whether it was ambiguous.
+func (i Index) Resolve(r *http.Request) (Resolution, error) {
+	var res Resolution
 	path := r.URL.Path
 
 	if strings.HasSuffix(path, "/") ||
 		strings.HasSuffix(path, "/index.html") ||
 		strings.HasPrefix(path, "/contents-") {
-		return "", &NotFoundError{}
+		return res, &NotFoundError{}
 	}
 
 	suffix := ".html"
@@ -345,48 +546,43 @@ func (i Index) Redirect(r *http.Request) (string, error) {
 		section = ""
 	}
 
-	log.Printf("path %q -> suite = %q, binarypkg = %q, name = %q, section = %q, lang = %q", path, suite, binarypkg, name, section, lang)
-
-	lname := strings.ToLower(name)
-	entries, ok := i.Entries[lname]
-	if !ok {
-		// Fall back to joining (originally) whitespace-separated
-		// parts by dashes and underscores, like man(1).
-		entries, ok = i.Entries[strings.Replace(lname, ".", "-", -1)]
-		if !ok {
-			entries, ok = i.Entries[strings.Replace(lname, ".", "_", -1)]
-			if !ok {
-				return "", &NotFoundError{Manpage: name}
-			}
-		}
+	res.Parsed = IndexEntry{
+		Name:      name,
+		Suite:     suite,
+		Binarypkg: binarypkg,
+		Section:   section,
+		Language:  lang,
 	}
 
-	acceptLang := r.Header.Get("Accept-Language")
+	acceptLang := i.AcceptLanguage(r)
 	ref := IndexEntry{
 		Suite:     r.FormValue("suite"),
 		Binarypkg: r.FormValue("binarypkg"),
 		Section:   r.FormValue("section"),
 		Language:  r.FormValue("language"),
 	}
-	filtered := i.Narrow(acceptLang, IndexEntry{
-		Suite:     suite,
-		Binarypkg: binarypkg,
-		Section:   section,
-		Language:  lang,
-	}, ref, entries)
 
-	if len(filtered) == 0 {
-		// Present the user with another choice for this manpage.
-		var best IndexEntry
-		if name != "index" && name != "favicon" {
-			best = i.Narrow(acceptLang, IndexEntry{}, ref, entries)[0]
-		}
-		return "", &NotFoundError{
-			Manpage:    name,
-			BestChoice: best}
+	chosen, entries, err := i.resolve(res.Parsed, acceptLang, ref)
+	if err != nil {
+		return res, err
 	}
 
-	return filtered[0].ServingPath(suffix), nil
+	distinct := make(map[string]bool)
+	for _, e := range entries {
+		if e.Suite != chosen.Suite || e.Language != chosen.Language {
+			continue
+		}
+		if binarypkg != "" && e.Binarypkg != binarypkg {
+			continue
+		}
+		if section != "" && e.Section[:1] != section[:1] {
+			continue
+		}
+		distinct[e.Binarypkg+"/"+e.Section] = true
+	}
+	res.Target = chosen.ServingPath(suffix)
+	res.Ambiguous = len(distinct) > 1
+	return res, nil
 }
 
 func IndexFromProto(path string) (Index, error) {
@@ -223,6 +223,10 @@ func TestNotFoundWrongSuite(t *testing.T) {
 	if got, want := e.BestChoice.ServingPath(".html"), "/jessie/i3-wm/i3.1.en.html"; got != want {
 		t.Fatalf("Unexpected e.BestChoice.ServingPath(): got %q, want %q", got, want)
 	}
+	// i3 is present in sections 1 and 5 of jessie.
+	if got, want := e.Disambiguation, "/disambiguation/jessie/i3.html"; got != want {
+		t.Fatalf("Unexpected e.Disambiguation: got %q, want %q", got, want)
+	}
 }
 
 func TestNotFoundFullySpecified(t *testing.T) {
@@ -634,3 +638,113 @@ func TestBlankRedirect(t *testing.T) {
 // 	URL:  "http://man.debian.org/lenny/i3",
 // 	want: "http://man.debian.org/wheezy/i3-wm/i3.1.en.html",
 // },
+
+func TestResolveAmbiguous(t *testing.T) {
+	table := []struct {
+		URL       string
+		Ambiguous bool
+	}{
+		{URL: "/jessie/i3", Ambiguous: true},
+		{URL: "/jessie/i3.1", Ambiguous: false},
+		{URL: "/jessie/i3.fr", Ambiguous: true},
+		{URL: "/jessie/i3.5.fr", Ambiguous: false},
+		{URL: "/editline", Ambiguous: true},
+		{URL: "/libedit-dev/editline", Ambiguous: false},
+		{URL: "/jessie/dup", Ambiguous: false},
+		{URL: "/man", Ambiguous: false},
+	}
+	for _, entry := range table {
+		entry := entry // capture
+		t.Run(entry.URL, func(t *testing.T) {
+			u, err := url.Parse(entry.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := testIdx.Resolve(&http.Request{URL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.Ambiguous, entry.Ambiguous; got != want {
+				t.Fatalf("Unexpected ambiguity: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	table := []struct {
+		URL        string
+		acceptLang string
+		cookie     string
+		want       string
+	}{
+		{
+			URL:        "i3",
+			acceptLang: "fr-CH, fr;q=0.9, en;q=0.8",
+			cookie:     "en",
+			want:       "jessie/i3-wm/i3.1.en.html",
+		},
+
+		{
+			URL:        "i3?language=fr",
+			acceptLang: "en",
+			cookie:     "en",
+			want:       "jessie/i3-wm/i3.1.fr.html",
+		},
+
+		{
+			URL:    "i3",
+			cookie: "fr",
+			want:   "jessie/i3-wm/i3.1.fr.html",
+		},
+
+		{
+			// Not available in French, falls back to Accept-Language.
+			URL:        "dup",
+			acceptLang: "en",
+			cookie:     "fr",
+			want:       "jessie/manpages-dev/dup.2.en.html",
+		},
+
+		{
+			// Unknown languages are ignored.
+			URL:        "i3",
+			acceptLang: "fr",
+			cookie:     "xx",
+			want:       "jessie/i3-wm/i3.1.fr.html",
+		},
+
+		{
+			// An explicitly requested language takes precedence.
+			URL:    "i3.en",
+			cookie: "fr",
+			want:   "jessie/i3-wm/i3.1.en.html",
+		},
+	}
+	for _, entry := range table {
+		entry := entry // capture
+		t.Run(entry.URL+" "+entry.cookie, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse("http://man.debian.org/" + entry.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{
+				URL: u,
+				Header: http.Header{
+					"Accept-Language": []string{entry.acceptLang},
+				},
+			}
+			req.AddCookie(&http.Cookie{Name: LanguageCookie, Value: entry.cookie})
+			got, err := testIdx.Redirect(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "/" + entry.want
+			if got != want {
+				t.Fatalf("Unexpected redirect: got %q, want %q", got, want)
+			}
+		})
+	}
+}
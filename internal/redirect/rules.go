@@ -0,0 +1,98 @@
+package redirect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultRules rewrite the URLs of other manpage sites, so that links to
+// them can be pointed to debiman.
+const DefaultRules = `
+# man.cgi (e.g. FreeBSD, OpenBSD, old manpages.debian.org):
+# /cgi-bin/man.cgi?query=ls&sektion=1
+^/cgi-bin/man\.cgi$                          /{query}.{sektion}
+
+# man7.org: /linux/man-pages/man1/ls.1.html
+^/linux/man-pages/man(\w+)/(.+)\.\w+\.html$  /${2}.${1}
+`
+
+// Rule rewrites the URL paths matching a regular expression.
+type Rule struct {
+	re *regexp.Regexp
+
+	// target is expanded into the rewritten path. ${1} refers to the
+	// first submatch of re, {name} to the value of the query
+	// parameter name.
+	target string
+}
+
+// Rules are applied in order, the first matching Rule wins.
+type Rules []Rule
+
+var queryParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// ParseRules parses one rule per line, consisting of a regular
+// expression matching the URL path and the target path, separated by
+// whitespace. Empty lines and lines starting with # are ignored.
+func ParseRules(r io.Reader) (Rules, error) {
+	var rules Rules
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields (regexp and target), got %d", lineno, len(fields))
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("line %d: target %q does not start with /", lineno, fields[1])
+		}
+		rules = append(rules, Rule{re: re, target: fields[1]})
+	}
+	return rules, scanner.Err()
+}
+
+// LoadRules parses the rules file at path, see ParseRules.
+func LoadRules(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Rewrite returns the path which u is rewritten to by the first matching
+// rule, if any.
+func (rs Rules) Rewrite(u *url.URL) (string, bool) {
+	for _, rule := range rs {
+		m := rule.re.FindStringSubmatchIndex(u.Path)
+		if m == nil {
+			continue
+		}
+		target := string(rule.re.ExpandString(nil, rule.target, u.Path, m))
+		query := u.Query()
+		target = queryParamRe.ReplaceAllStringFunc(target, func(param string) string {
+			// Query parameters must not introduce additional path
+			// components.
+			return strings.Replace(query.Get(param[1:len(param)-1]), "/", "", -1)
+		})
+		return target, true
+	}
+	return "", false
+}
@@ -0,0 +1,83 @@
+package redirect
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDefaultRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(DefaultRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		URL  string
+		want string
+	}{
+		{URL: "cgi-bin/man.cgi?query=i3&sektion=5", want: "jessie/i3-wm/i3.5.en.html"},
+		{URL: "cgi-bin/man.cgi?query=i3", want: "jessie/i3-wm/i3.1.en.html"},
+		{URL: "cgi-bin/man.cgi?query=i3&sektion=0&manpath=Debian+8+jessie", want: "jessie/i3-wm/i3.1.en.html"},
+		{URL: "linux/man-pages/man2/dup.2.html", want: "jessie/manpages-dev/dup.2.en.html"},
+		{URL: "linux/man-pages/man5/systemd.service.5.html", want: "jessie/systemd/systemd.service.5.en.html"},
+	}
+	for _, entry := range table {
+		entry := entry // capture
+		t.Run(entry.URL, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse("http://man.debian.org/" + entry.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, ok := rules.Rewrite(u)
+			if !ok {
+				t.Fatalf("no rule matched %q", u.Path)
+			}
+			u.Path = path
+			got, err := testIdx.Redirect(&http.Request{URL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "/" + entry.want
+			if got != want {
+				t.Fatalf("Unexpected redirect: got %q, want %q", got, want)
+			}
+		})
+	}
+
+	u, err := url.Parse("http://man.debian.org/jessie/i3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, ok := rules.Rewrite(u); ok {
+		t.Errorf("unexpectedly rewrote %q to %q", u.Path, path)
+	}
+}
+
+func TestRewriteQueryParamSlashes(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`^/q$ /{q}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse("http://man.debian.org/q?q=testing/i3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := rules.Rewrite(u); got != "/testingi3" {
+		t.Errorf("unexpected rewrite: got %q, want %q", got, "/testingi3")
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		"^/foo$",
+		"^/foo( /bar",
+		"^/foo$ bar",
+	} {
+		if _, err := ParseRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("ParseRules(%q) unexpectedly succeeded", rules)
+		}
+	}
+}
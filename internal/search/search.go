@@ -0,0 +1,211 @@
+// Package search implements a full-text index of manpages, ranking
+// results using Okapi BM25.
+package search
+
+import (
+	"encoding/gob"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, see
+// https://en.wikipedia.org/wiki/Okapi_BM25
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// nameBoost is added to the score of manpages whose name is the query,
+// so that e.g. searching for “ls” ranks ls(1) first.
+const nameBoost = 10
+
+// maxTermLength is the length in bytes beyond which words (e.g. base64
+// blobs in examples) are not indexed.
+const maxTermLength = 40
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true,
+	"be": true, "by": true, "for": true, "if": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+// Tokenize returns the terms of text, i.e. its lower-cased words
+// without stop words.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if len(w) > maxTermLength || stopWords[w] {
+			continue
+		}
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// Document is an indexed manpage.
+type Document struct {
+	// ServingPath is the path of the manpage, without the file name
+	// extension, e.g. “jessie/i3-wm/i3.1.en”.
+	ServingPath string
+	Name        string
+	Section     string
+	Language    string
+	Binarypkg   string
+
+	// Description is the one-line description from the NAME section.
+	Description string
+
+	// Terms maps each term of the text of the manpage to its number of
+	// occurrences.
+	Terms map[string]int
+
+	// Length is the total number of terms of the text of the manpage.
+	Length int
+}
+
+// NewDocument returns a Document for text with the specified metadata.
+func NewDocument(d Document, text string) Document {
+	d.Terms = make(map[string]int)
+	for _, t := range Tokenize(text) {
+		d.Terms[t]++
+		d.Length++
+	}
+	return d
+}
+
+// Load reads the documents at path, as written by Write.
+func Load(path string) ([]Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var docs []Document
+	if err := gob.NewDecoder(f).Decode(&docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// Write writes docs to w.
+func Write(w io.Writer, docs []Document) error {
+	return gob.NewEncoder(w).Encode(docs)
+}
+
+type posting struct {
+	doc  int32
+	freq int32
+}
+
+// Index is an inverted index of documents.
+type Index struct {
+	docs      []Document // without Terms
+	lengths   []int
+	avgLength float64
+	postings  map[string][]posting
+}
+
+// NewIndex returns an Index of docs.
+func NewIndex(docs []Document) *Index {
+	idx := &Index{
+		docs:     make([]Document, len(docs)),
+		lengths:  make([]int, len(docs)),
+		postings: make(map[string][]posting),
+	}
+	var total int
+	for i, d := range docs {
+		for term, freq := range d.Terms {
+			idx.postings[term] = append(idx.postings[term], posting{doc: int32(i), freq: int32(freq)})
+		}
+		idx.lengths[i] = d.Length
+		total += d.Length
+		d.Terms = nil
+		idx.docs[i] = d
+	}
+	if len(docs) > 0 {
+		idx.avgLength = float64(total) / float64(len(docs))
+	}
+	return idx
+}
+
+// Len returns the number of documents in the index.
+func (i *Index) Len() int {
+	return len(i.docs)
+}
+
+// Result is a document matching a query.
+type Result struct {
+	ServingPath string  `json:"serving_path"`
+	Name        string  `json:"name"`
+	Section     string  `json:"section"`
+	Language    string  `json:"language"`
+	Binarypkg   string  `json:"binarypkg"`
+	Description string  `json:"description"`
+	Score       float64 `json:"score"`
+}
+
+type byScore []Result
+
+func (p byScore) Len() int      { return len(p) }
+func (p byScore) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byScore) Less(i, j int) bool {
+	if p[i].Score != p[j].Score {
+		return p[i].Score > p[j].Score
+	}
+	return p[i].ServingPath < p[j].ServingPath
+}
+
+// Search returns the (at most n) documents which contain any term of
+// query and for which keep (if non-nil) returns true, ordered by
+// decreasing relevance.
+func (i *Index) Search(query string, keep func(d *Document) bool, n int) []Result {
+	scores := make(map[int32]float64)
+	numDocs := float64(len(i.docs))
+	for _, term := range Tokenize(query) {
+		postings := i.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (numDocs-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.freq)
+			norm := k1 * (1 - b + b*float64(i.lengths[p.doc])/i.avgLength)
+			scores[p.doc] += idf * tf * (k1 + 1) / (tf + norm)
+		}
+	}
+
+	query = strings.TrimSpace(query)
+	results := make([]Result, 0, len(scores))
+	for doc, score := range scores {
+		d := &i.docs[doc]
+		if keep != nil && !keep(d) {
+			continue
+		}
+		if strings.EqualFold(d.Name, query) {
+			score += nameBoost
+		}
+		results = append(results, Result{
+			ServingPath: d.ServingPath,
+			Name:        d.Name,
+			Section:     d.Section,
+			Language:    d.Language,
+			Binarypkg:   d.Binarypkg,
+			Description: d.Description,
+			Score:       score,
+		})
+	}
+	sort.Sort(byScore(results))
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
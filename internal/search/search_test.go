@@ -0,0 +1,89 @@
+package search
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("The i3-msg(1) tool sends IPC_MESSAGES to i3, and " + strings.Repeat("x", maxTermLength+1))
+	want := []string{"i3", "msg", "1", "tool", "sends", "ipc_messages", "i3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tokenize: got %q, want %q", got, want)
+	}
+}
+
+func testDocs() []Document {
+	return []Document{
+		NewDocument(Document{ServingPath: "jessie/i3-wm/i3.1.en", Name: "i3", Language: "en"},
+			"i3 is a tiling window manager. i3 manages windows in a tree."),
+		NewDocument(Document{ServingPath: "jessie/i3-wm/i3-msg.1.en", Name: "i3-msg", Language: "en"},
+			"i3-msg sends messages to the i3 window manager using its IPC interface."),
+		NewDocument(Document{ServingPath: "jessie/coreutils/ls.1.en", Name: "ls", Language: "en"},
+			"ls lists information about files in the current directory."),
+		NewDocument(Document{ServingPath: "jessie/manpages-de/ls.1.de", Name: "ls", Language: "de"},
+			"ls listet Informationen über Dateien auf."),
+	}
+}
+
+func servingPaths(results []Result) []string {
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.ServingPath)
+	}
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex(testDocs())
+	for _, tt := range []struct {
+		query string
+		keep  func(d *Document) bool
+		n     int
+		want  []string
+	}{
+		// The higher term frequency ranks i3(1) first.
+		{"i3", nil, 10, []string{"jessie/i3-wm/i3.1.en", "jessie/i3-wm/i3-msg.1.en"}},
+		{"window manager", nil, 10, []string{"jessie/i3-wm/i3.1.en", "jessie/i3-wm/i3-msg.1.en"}},
+		{"IPC", nil, 10, []string{"jessie/i3-wm/i3-msg.1.en"}},
+		// The name boost ranks i3-msg(1) first.
+		{"i3-msg", nil, 10, []string{"jessie/i3-wm/i3-msg.1.en", "jessie/i3-wm/i3.1.en"}},
+		{"i3", nil, 1, []string{"jessie/i3-wm/i3.1.en"}},
+		{"ls", func(d *Document) bool { return d.Language == "de" }, 10, []string{"jessie/manpages-de/ls.1.de"}},
+		{"the", nil, 10, nil},
+		{"emacs", nil, 10, nil},
+	} {
+		if got := servingPaths(idx.Search(tt.query, tt.keep, tt.n)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q): got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "search-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, testDocs()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmpdir, "search-jessie.gob")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testDocs(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Load: got %+v, want %+v", got, want)
+	}
+}
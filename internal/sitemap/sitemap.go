@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	neturl "net/url"
 	"sort"
 	"time"
 )
@@ -22,6 +23,11 @@ type sitemap struct {
 
 const sitemapDateFormat = "2006-01-02"
 
+// escapePath percent-encodes p for use as a URL path component.
+func escapePath(p string) string {
+	return (&neturl.URL{Path: p}).EscapedPath()
+}
+
 func WriteTo(w io.Writer, baseUrl string, contents map[string]time.Time) error {
 	if _, err := w.Write([]byte(xml.Header)); err != nil {
 		return err
@@ -47,7 +53,7 @@ func WriteTo(w io.Writer, baseUrl string, contents map[string]time.Time) error {
 	sort.Strings(pkgs)
 	for _, binarypkg := range pkgs {
 		if err := enc.EncodeElement(&url{
-			Loc:     fmt.Sprintf("%s/%s/index.html", baseUrl, binarypkg),
+			Loc:     fmt.Sprintf("%s/%s/index.html", baseUrl, escapePath(binarypkg)),
 			Lastmod: contents[binarypkg].Format(sitemapDateFormat),
 		}, xml.StartElement{Name: xml.Name{Local: "url"}}); err != nil {
 			return err
@@ -85,7 +91,7 @@ func WriteIndexTo(w io.Writer, baseUrl string, contents map[string]time.Time) er
 	sort.Strings(pkgs)
 	for _, suite := range pkgs {
 		if err := enc.EncodeElement(&sitemap{
-			Loc:     fmt.Sprintf("%s/%s/sitemap.xml.gz", baseUrl, suite),
+			Loc:     fmt.Sprintf("%s/%s/sitemap.xml.gz", baseUrl, escapePath(suite)),
 			Lastmod: contents[suite].Format(sitemapDateFormat),
 		}, xml.StartElement{Name: xml.Name{Local: "sitemap"}}); err != nil {
 			return err
@@ -97,3 +103,89 @@ func WriteIndexTo(w io.Writer, baseUrl string, contents map[string]time.Time) er
 
 	return enc.Flush()
 }
+
+// Validate returns an error if r does not contain a well-formed sitemap
+// or sitemap index, or if any of its locations is not an absolute,
+// properly escaped URL.
+func Validate(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	var (
+		root  string
+		inLoc bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = t.Name.Local
+				if root != "urlset" && root != "sitemapindex" {
+					return fmt.Errorf("unexpected root element %q", root)
+				}
+			}
+			inLoc = t.Name.Local == "loc"
+		case xml.EndElement:
+			inLoc = false
+		case xml.CharData:
+			if !inLoc {
+				continue
+			}
+			loc := string(t)
+			u, err := neturl.Parse(loc)
+			if err != nil {
+				return fmt.Errorf("invalid location %q: %v", loc, err)
+			}
+			if !u.IsAbs() {
+				return fmt.Errorf("location %q is not an absolute URL", loc)
+			}
+			for _, r := range loc {
+				if r > 0x7f || r <= ' ' {
+					return fmt.Errorf("location %q contains unescaped character %q", loc, r)
+				}
+			}
+		}
+	}
+	if root == "" {
+		return fmt.Errorf("no root element found")
+	}
+	return nil
+}
+
+// Locations returns the locations (<loc> elements) of the sitemap or
+// sitemap index in r, in document order.
+func Locations(r io.Reader) ([]string, error) {
+	dec := xml.NewDecoder(r)
+	var (
+		locs  []string
+		inLoc bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inLoc = t.Name.Local == "loc"
+			if inLoc {
+				locs = append(locs, "")
+			}
+		case xml.EndElement:
+			inLoc = false
+		case xml.CharData:
+			if inLoc {
+				locs[len(locs)-1] += string(t)
+			}
+		}
+	}
+	return locs, nil
+}
@@ -2,6 +2,7 @@ package sitemap
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,3 +38,84 @@ func TestSitemapIndex(t *testing.T) {
 		t.Fatalf("unexpected sitemap contents: got %q, want %q", got, want)
 	}
 }
+
+func TestSitemapEscaping(t *testing.T) {
+	const want = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://manpages.debian.org/jessie/a&amp;b/index.html</loc><lastmod>2017-01-19</lastmod></url><url><loc>https://manpages.debian.org/jessie/g++/index.html</loc><lastmod>2017-01-19</lastmod></url><url><loc>https://manpages.debian.org/jessie/na%C3%AFve/index.html</loc><lastmod>2017-01-19</lastmod></url></urlset>`
+
+	var gotb bytes.Buffer
+	if err := WriteTo(&gotb, "https://manpages.debian.org/jessie", map[string]time.Time{
+		"a&b":   time.Unix(1484816329, 0),
+		"g++":   time.Unix(1484816329, 0),
+		"naïve": time.Unix(1484816329, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gotb.String(); got != want {
+		t.Fatalf("unexpected sitemap contents: got %q, want %q", got, want)
+	}
+
+	if err := Validate(&gotb); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var index bytes.Buffer
+	if err := WriteIndexTo(&index, "https://manpages.debian.org", map[string]time.Time{
+		"jessie": time.Unix(1484816329, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(&index); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []string{
+		``,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<urlset><url><loc>https://manpages.debian.org/jessie/a&b/index.html</loc></url></urlset>`,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<urlset><url><loc>https://manpages.debian.org/jessie/naïve/index.html</loc></url></urlset>`,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<urlset><url><loc>/jessie/cron/index.html</loc></url></urlset>`,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<urlset><url><loc>https://manpages.debian.org/jessie/cron/index.html</loc></url>`,
+		`<html></html>`,
+	} {
+		if err := Validate(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Validate(%q) unexpectedly succeeded", invalid)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTo(&buf, "https://manpages.debian.org/jessie", map[string]time.Time{
+		"pdns-recursor": time.Unix(1484816329, 0),
+		"libstdc++6":    time.Unix(1484816329, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Locations(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://manpages.debian.org/jessie/libstdc++6/index.html",
+		"https://manpages.debian.org/jessie/pdns-recursor/index.html",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected locations: got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected locations: got %q, want %q", got, want)
+		}
+	}
+
+	if _, err := Locations(strings.NewReader("<urlset><url><loc>")); err == nil {
+		t.Fatalf("Locations unexpectedly succeeded on truncated input")
+	}
+}
@@ -0,0 +1,149 @@
+// Package vercmp compares version strings like the rpmvercmp
+// algorithm, which both RPM and pacman (libalpm) use, albeit with
+// slight differences.
+package vercmp
+
+import "strings"
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+func isAlpha(b byte) bool { return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') }
+func isAlnum(b byte) bool { return isDigit(b) || isAlpha(b) }
+
+// RPM compares the version strings a and b like RPM’s rpmvercmp and
+// returns -1, 0 or 1 if a is older than, the same as or newer than b.
+// Alternating runs of digits (compared numerically) and letters
+// (compared lexically) are compared one by one, a ~ sorts before
+// anything (even the end of the string, e.g. 1.0~rc1 < 1.0), and a ^
+// sorts after the end of the string, but before anything else (e.g.
+// 1.0 < 1.0^git1 < 1.0.1).
+func RPM(a, b string) int {
+	return compare(a, b, true)
+}
+
+// Pacman compares the version components a and b like libalpm’s
+// rpmvercmp, which differs from RPM in that ~ and ^ are not treated
+// specially, a longer separator is newer (e.g. 1..0 > 1.0), and a
+// trailing letter segment is older than the end of the string (e.g.
+// 1.0a < 1.0, whereas RPM considers 1.0a > 1.0).
+func Pacman(a, b string) int {
+	return compare(a, b, false)
+}
+
+// compare implements RPM (if rpm is true) and Pacman.
+func compare(a, b string, rpm bool) int {
+	if a == b {
+		return 0
+	}
+	special := func(c byte) bool { return rpm && (c == '~' || c == '^') }
+	var i, j int // positions in a and b
+	for i < len(a) || j < len(b) {
+		if !rpm && (i == len(a) || j == len(b)) {
+			break // libalpm stops as soon as either string ends
+		}
+		sepA, sepB := i, j
+		for i < len(a) && !isAlnum(a[i]) && !special(a[i]) {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) && !special(b[j]) {
+			j++
+		}
+		if rpm {
+			tildeA := i < len(a) && a[i] == '~'
+			tildeB := j < len(b) && b[j] == '~'
+			if tildeA || tildeB {
+				if !tildeA {
+					return 1
+				}
+				if !tildeB {
+					return -1
+				}
+				i, j = i+1, j+1
+				continue
+			}
+			caretA := i < len(a) && a[i] == '^'
+			caretB := j < len(b) && b[j] == '^'
+			if caretA || caretB {
+				if i == len(a) {
+					return -1
+				}
+				if j == len(b) {
+					return 1
+				}
+				if !caretA {
+					return 1
+				}
+				if !caretB {
+					return -1
+				}
+				i, j = i+1, j+1
+				continue
+			}
+		}
+		if i == len(a) || j == len(b) {
+			break
+		}
+		if !rpm && i-sepA != j-sepB {
+			if i-sepA < j-sepB {
+				return -1
+			}
+			return 1
+		}
+
+		startA, startB := i, j
+		isNum := isDigit(a[i])
+		if isNum {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+		segA, segB := a[startA:i], b[startB:j]
+		if segB == "" {
+			// Segments of different types: numbers are newer.
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) < len(segB) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if segA != segB {
+			if segA < segB {
+				return -1
+			}
+			return 1
+		}
+	}
+	if i == len(a) && j == len(b) {
+		return 0
+	}
+	if rpm {
+		if i == len(a) {
+			return -1
+		}
+		return 1
+	}
+	// A remaining letter segment never beats an empty remainder, e.g.
+	// 1.0 is newer than 1.0alpha, but older than 1.0.1.
+	if (i == len(a) && !isAlpha(b[j])) || (i < len(a) && isAlpha(a[i])) {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,58 @@
+package vercmp
+
+import "testing"
+
+func TestRPM(t *testing.T) {
+	for _, entry := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.01", "1.1", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0", "1_0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0~rc1^git1", "1.0", -1},
+		{"a", "1", -1},
+	} {
+		if got := RPM(entry.a, entry.b); got != entry.want {
+			t.Errorf("RPM(%q, %q): got %d, want %d", entry.a, entry.b, got, entry.want)
+		}
+		if got := RPM(entry.b, entry.a); got != -entry.want {
+			t.Errorf("RPM(%q, %q): got %d, want %d", entry.b, entry.a, got, -entry.want)
+		}
+	}
+}
+
+func TestPacman(t *testing.T) {
+	for _, entry := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.01", "1.1", 0},
+		{"1.0a", "1.0", -1},
+		{"1.0.a", "1.0", 1},
+		{"1.0.a", "1.0.1", -1},
+		{"1.0alpha", "1.0beta", -1},
+		{"1.0..1", "1.0.1", 1},
+		{"1.0~rc1", "1.0", 1},
+	} {
+		if got := Pacman(entry.a, entry.b); got != entry.want {
+			t.Errorf("Pacman(%q, %q): got %d, want %d", entry.a, entry.b, got, entry.want)
+		}
+		if got := Pacman(entry.b, entry.a); got != -entry.want {
+			t.Errorf("Pacman(%q, %q): got %d, want %d", entry.b, entry.a, got, -entry.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package whatis reads and writes whatis databases, which contain the
+// one-line descriptions from the NAME section of each manpage, and
+// searches them like apropos(1).
+package whatis
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Entry is the NAME section of a manpage.
+type Entry struct {
+	Names       []string `json:"names"`
+	Section     string   `json:"section"`
+	Language    string   `json:"language"`
+	Binarypkg   string   `json:"binarypkg"`
+	Description string   `json:"description"`
+
+	// ServingPath is the path of the manpage, without the file name
+	// extension, e.g. “jessie/i3-wm/i3.1.en”.
+	ServingPath string `json:"serving_path"`
+}
+
+type byServingPath []Entry
+
+func (p byServingPath) Len() int           { return len(p) }
+func (p byServingPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byServingPath) Less(i, j int) bool { return p[i].ServingPath < p[j].ServingPath }
+
+// Load reads the JSON database at path, as written by WriteJSON.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", path, err)
+	}
+	return entries, nil
+}
+
+// WriteJSON writes entries, sorted by serving path, as JSON to w.
+func WriteJSON(w io.Writer, entries []Entry) error {
+	sort.Sort(byServingPath(entries))
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// WriteManDB writes the English entries to w in the format of the
+// whatis databases of man-db, i.e. one “name (section) - description”
+// line per name, sorted by name and section.
+func WriteManDB(w io.Writer, entries []Entry) error {
+	lines := make(map[string]bool)
+	for _, e := range entries {
+		if e.Language != "en" {
+			continue
+		}
+		for _, name := range e.Names {
+			lines[fmt.Sprintf("%s (%s) - %s", name, e.Section, e.Description)] = true
+		}
+	}
+	sorted := make([]string, 0, len(lines))
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Strings(sorted)
+	bufw := bufio.NewWriter(w)
+	for _, line := range sorted {
+		if _, err := bufw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return bufw.Flush()
+}
+
+// Search returns the entries whose names or description contain keyword,
+// ignoring case, like apropos(1).
+func Search(entries []Entry, keyword string) []Entry {
+	keyword = strings.ToLower(keyword)
+	var result []Entry
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Description), keyword) {
+			result = append(result, e)
+			continue
+		}
+		for _, name := range e.Names {
+			if strings.Contains(strings.ToLower(name), keyword) {
+				result = append(result, e)
+				break
+			}
+		}
+	}
+	return result
+}
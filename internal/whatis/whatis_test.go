@@ -0,0 +1,98 @@
+package whatis
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testEntries = []Entry{
+	{
+		Names:       []string{"i3lock"},
+		Section:     "1",
+		Language:    "en",
+		Binarypkg:   "i3lock",
+		Description: "improved screen locker",
+		ServingPath: "jessie/i3lock/i3lock.1.en",
+	},
+	{
+		Names:       []string{"gzip", "gunzip", "zcat"},
+		Section:     "1",
+		Language:    "en",
+		Binarypkg:   "gzip",
+		Description: "compress or expand files",
+		ServingPath: "jessie/gzip/gzip.1.en",
+	},
+	{
+		Names:       []string{"gzip", "gunzip", "zcat"},
+		Section:     "1",
+		Language:    "fr",
+		Binarypkg:   "manpages-fr-extra",
+		Description: "compresse ou décompresse des fichiers",
+		ServingPath: "jessie/manpages-fr-extra/gzip.1.fr",
+	},
+}
+
+func TestJSON(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "whatis-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var buf bytes.Buffer
+	entries := append([]Entry(nil), testEntries...)
+	if err := WriteJSON(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmpdir, "whatis-jessie.json")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{testEntries[1], testEntries[0], testEntries[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Load: got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteManDB(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteManDB(&buf, testEntries); err != nil {
+		t.Fatal(err)
+	}
+	want := `gunzip (1) - compress or expand files
+gzip (1) - compress or expand files
+i3lock (1) - improved screen locker
+zcat (1) - compress or expand files
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("WriteManDB: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, tt := range []struct {
+		keyword string
+		want    []string
+	}{
+		{"LOCK", []string{"jessie/i3lock/i3lock.1.en"}},
+		{"zcat", []string{"jessie/gzip/gzip.1.en", "jessie/manpages-fr-extra/gzip.1.fr"}},
+		{"expand", []string{"jessie/gzip/gzip.1.en"}},
+		{"nonexistent", nil},
+	} {
+		var got []string
+		for _, e := range Search(testEntries, tt.keyword) {
+			got = append(got, e.ServingPath)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q): got %v, want %v", tt.keyword, got, tt.want)
+		}
+	}
+}